@@ -0,0 +1,32 @@
+package main
+
+/*
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"errors"
+	"unsafe"
+)
+
+// callParse calls npirates_parse the way a C caller would, copying its
+// arguments into C memory and its result back out, and frees what it
+// returned. It is for the tests, which cannot use cgo themselves.
+func callParse(data []byte, npis, source string) ([]byte, error) {
+	cdata := C.CBytes(data)
+	defer C.free(cdata)
+	cnpis, csource := C.CString(npis), C.CString(source)
+	defer C.free(unsafe.Pointer(cnpis))
+	defer C.free(unsafe.Pointer(csource))
+
+	var outLen C.longlong
+	var errOut *C.char
+	out := npirates_parse((*C.char)(cdata), C.longlong(len(data)), cnpis, csource, &outLen, &errOut)
+	if out == nil {
+		defer npirates_free(errOut)
+		return nil, errors.New(C.GoString(errOut))
+	}
+	defer npirates_free(out)
+	return C.GoBytes(unsafe.Pointer(out), C.int(outLen)), nil
+}
@@ -0,0 +1,35 @@
+package main
+
+/*
+#include <stdlib.h>
+*/
+import "C"
+
+import "unsafe"
+
+// npirates_parse searches the MRF in data[0:len] for the comma-separated
+// NPIs and returns the matching rates as NDJSON, with its length in
+// *outLen. source names the file in each result's source_file. On failure
+// it returns NULL and sets *errOut to a message. Both strings are allocated
+// with malloc and must be released with npirates_free.
+//
+//export npirates_parse
+func npirates_parse(data *C.char, length C.longlong, npis, source *C.char, outLen *C.longlong, errOut **C.char) *C.char {
+	*outLen = 0
+	*errOut = nil
+	buf := unsafe.Slice((*byte)(unsafe.Pointer(data)), int(length))
+	out, err := parse(buf, C.GoString(npis), C.GoString(source))
+	if err != nil {
+		*errOut = C.CString(err.Error())
+		return nil
+	}
+	*outLen = C.longlong(len(out))
+	return (*C.char)(C.CBytes(out))
+}
+
+// npirates_free releases a string returned by npirates_parse.
+//
+//export npirates_free
+func npirates_free(p *C.char) {
+	C.free(unsafe.Pointer(p))
+}
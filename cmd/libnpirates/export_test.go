@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gyeh/npi-rates/internal/mrf"
+)
+
+const testMRF = `{
+	"reporting_entity_name": "Test Health Plan",
+	"provider_references": [
+		{"provider_group_id": 1, "provider_groups": [{"npi": [1316924913], "tin": {"type": "ein", "value": "16-0960964"}}]},
+		{"provider_group_id": 2, "provider_groups": [{"npi": [5555555555], "tin": {"type": "ein", "value": "55-5555555"}}]}
+	],
+	"in_network": [
+		{
+			"billing_code_type": "CPT", "billing_code": "99213", "negotiation_arrangement": "ffs",
+			"negotiated_rates": [{"provider_references": [1, 2], "negotiated_prices": [
+				{"negotiated_rate": 125.50, "negotiated_type": "negotiated", "billing_class": "professional", "expiration_date": "2026-12-31"}
+			]}]
+		}
+	]
+}`
+
+// inNetworkFirst is testMRF with in_network before provider_references,
+// which takes a second pass.
+const inNetworkFirst = `{
+	"in_network": [
+		{
+			"billing_code_type": "CPT", "billing_code": "99214", "negotiation_arrangement": "ffs",
+			"negotiated_rates": [{"provider_references": [1], "negotiated_prices": [
+				{"negotiated_rate": 180.00, "negotiated_type": "negotiated", "billing_class": "professional", "expiration_date": "2026-12-31"}
+			]}]
+		}
+	],
+	"provider_references": [
+		{"provider_group_id": 1, "provider_groups": [{"npi": [1316924913], "tin": {"type": "ein", "value": "16-0960964"}}]}
+	]
+}`
+
+// decodeResults parses NDJSON output into RateResults.
+func decodeResults(t *testing.T, out []byte) []mrf.RateResult {
+	t.Helper()
+	var results []mrf.RateResult
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		var r mrf.RateResult
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+			t.Fatalf("result line %q: %v", sc.Text(), err)
+		}
+		results = append(results, r)
+	}
+	return results
+}
+
+func gzipped(t *testing.T, s string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte(s))
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestNpiratesParse(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		npis string
+		want []string // NPI and billing code of each result
+	}{
+		{"plain", []byte(testMRF), "1316924913", []string{"1316924913 99213"}},
+		{"gzip", gzipped(t, testMRF), " 5555555555 ,", []string{"5555555555 99213"}},
+		{"no match", []byte(testMRF), "1111111111", nil},
+		{"in_network first", []byte(inNetworkFirst), "1316924913", []string{"1316924913 99214"}},
+	}
+	for _, tt := range tests {
+		out, err := callParse(tt.data, tt.npis, "test.json")
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		var got []string
+		for _, r := range decodeResults(t, out) {
+			if r.SourceFile != "test.json" {
+				t.Errorf("%s: source_file %q", tt.name, r.SourceFile)
+			}
+			got = append(got, strconv.FormatInt(r.NPI, 10)+" "+r.BillingCode)
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNpiratesParse_Errors(t *testing.T) {
+	tests := []struct {
+		name, data, npis, want string
+	}{
+		{"bad NPI", testMRF, "1316924913,abc", `invalid NPI "abc"`},
+		{"no NPIs", testMRF, " , ", "no NPIs given"},
+		{"not an MRF", `[1, 2]`, "1316924913", ""},
+	}
+	for _, tt := range tests {
+		out, err := callParse([]byte(tt.data), tt.npis, "test.json")
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got %q, %v; want an error containing %q", tt.name, out, err, tt.want)
+		}
+	}
+}
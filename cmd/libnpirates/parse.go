@@ -0,0 +1,85 @@
+// Command libnpirates builds the npi-rates parser as a C shared library, so
+// other languages can call it in-process instead of running the CLI:
+//
+//	go build -buildmode=c-shared -o libnpirates.so ./cmd/libnpirates
+//
+// This writes libnpirates.so and libnpirates.h. The interface is bytes in,
+// NDJSON out: npirates_parse takes one MRF in-network file (uncompressed,
+// gzip, zstd or bzip2) and a comma-separated NPI list, and returns one JSON
+// RateResult per line, the same objects the CLI writes with -o x.ndjson.
+// python/npirates.py is a ctypes binding.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gyeh/npi-rates/internal/mrf"
+	"github.com/gyeh/npi-rates/internal/worker"
+)
+
+func main() {}
+
+// parse searches data, a whole MRF, for the NPIs in npiList and returns the
+// matching rates as NDJSON.
+func parse(data []byte, npiList, sourceFile string) ([]byte, error) {
+	targets := make(map[int64]struct{})
+	for _, s := range strings.Split(npiList, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid NPI %q", s)
+		}
+		targets[n] = struct{}{}
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no NPIs given")
+	}
+	opts := &mrf.SearchOptions{NPIs: targets}
+
+	var (
+		mu     sync.Mutex
+		out    bytes.Buffer
+		encErr error
+	)
+	enc := json.NewEncoder(&out)
+	emit := func(rs []mrf.RateResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		for i := range rs {
+			if err := enc.Encode(&rs[i]); err != nil && encErr == nil {
+				encErr = err
+			}
+		}
+	}
+
+	// The whole file is in memory, so a file listing in_network first is
+	// simply parsed again with the provider index from the first pass.
+	var prebuilt *mrf.MatchedProviders
+	for pass := 0; pass < 2; pass++ {
+		r, err := worker.NewDecompressReader(bytes.NewReader(data), false)
+		if err != nil {
+			return nil, err
+		}
+		sr, err := mrf.StreamParse(r, opts, sourceFile, mrf.StreamCallbacks{}, emit, prebuilt)
+		r.Close()
+		if err != nil {
+			return nil, err
+		}
+		if !sr.NeedSecondPass {
+			break
+		}
+		prebuilt = sr.MatchedProviders
+	}
+	if encErr != nil {
+		return nil, encErr
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gyeh/npi-rates/internal/humanize"
+	"github.com/gyeh/npi-rates/internal/logx"
+	modalorch "github.com/gyeh/npi-rates/internal/modal"
+	"github.com/gyeh/npi-rates/internal/objstore"
+	"github.com/gyeh/npi-rates/internal/output"
+	"github.com/gyeh/npi-rates/internal/progress"
+	"github.com/spf13/cobra"
+)
+
+// validateCloud rejects the flags a --cloud search can't hand on to its
+// shards or apply itself.
+func (f *searchFlags) validateCloud() error {
+	if f.notifyURL != "" || f.slackWebhook != "" {
+		return fmt.Errorf("--notify-url and --slack-webhook are not supported with --cloud")
+	}
+	if len(f.sinkSpecs) > 0 {
+		return fmt.Errorf("--sink is not supported with --cloud")
+	}
+	if len(f.npiSetSpecs) > 0 {
+		return fmt.Errorf("--npi-set is not supported with --cloud")
+	}
+	if output.IsRemote(f.outputFile) {
+		return fmt.Errorf("s3://, gs://, sftp:// and http(s):// outputs are not supported with --cloud; deliver the merged results afterwards")
+	}
+	if f.fileLogs {
+		return fmt.Errorf("--file-logs is not supported with --cloud")
+	}
+	if f.checkpoint != "" {
+		return fmt.Errorf("--checkpoint is not supported with --cloud")
+	}
+	if f.snapEvery > 0 {
+		return fmt.Errorf("--snapshot-every is not supported with --cloud")
+	}
+	if f.workerMode {
+		return fmt.Errorf("--worker-mode is for the workers of a distributed search, not its --cloud orchestrator")
+	}
+	if f.deadline > 0 || f.stopAt != "" {
+		return fmt.Errorf("--deadline and --stop-at are not supported with --cloud")
+	}
+	if f.costPerGB > 0 {
+		return fmt.Errorf("--transfer-cost-per-gb is not supported with --cloud")
+	}
+	if f.itemHookCmd != "" {
+		return fmt.Errorf("--item-hook is not supported with --cloud")
+	}
+	if f.refreshCmd != "" {
+		return fmt.Errorf("--url-refresh-cmd is not supported with --cloud")
+	}
+	if f.progressJSON != "" {
+		return fmt.Errorf("--progress-json is not supported with --cloud")
+	}
+	if f.cacheDir != "" {
+		return fmt.Errorf("--cache-dir is not supported with --cloud")
+	}
+	if f.maxWorkers > 0 {
+		return fmt.Errorf("--max-workers is not supported with --cloud")
+	}
+	if f.keepSplit != "" {
+		return fmt.Errorf("--keep-split is not supported with --cloud")
+	}
+	if f.maxBandwidth != "" || f.maxWorkerBW != "" {
+		return fmt.Errorf("--max-bandwidth and --max-worker-bandwidth are not supported with --cloud")
+	}
+	if f.aggregate {
+		return fmt.Errorf("--aggregate is not supported with --cloud")
+	}
+	if f.feeSchedule != "" {
+		return fmt.Errorf("--medicare-fee-schedule is not supported with --cloud")
+	}
+	if f.codeDescs != "" {
+		return fmt.Errorf("--code-descriptions is not supported with --cloud")
+	}
+	if f.taskRetries < 0 || f.taskRetries > 10 {
+		return fmt.Errorf("--task-retries must be between 0 and 10, got %d", f.taskRetries)
+	}
+	if f.rerunMissing < 0 {
+		return fmt.Errorf("--rerun-missing must not be negative, got %d", f.rerunMissing)
+	}
+	if f.maxShardGB < 0 {
+		return fmt.Errorf("--max-shard-gb must not be negative")
+	}
+	return nil
+}
+
+// cloudSearch is what the orchestrator has found out for a --cloud search
+// before handing it to the shards.
+type cloudSearch struct {
+	npis, groupIDs []int64
+	urls           []string
+	mirrors        map[string][]string
+	sizes          []int64 // compressed sizes of urls, 0 if unknown
+	location       string  // the file servers' location, if found
+	progress       progress.Manager
+}
+
+// runCloudSearch runs the search f describes as a distributed search on
+// Modal, with the URLs sharded by size.
+func runCloudSearch(ctx context.Context, cmd *cobra.Command, f *searchFlags, s cloudSearch) error {
+	for _, u := range s.urls {
+		if objstore.IsObjectURL(u) {
+			return fmt.Errorf("s3:// and gs:// URLs are not supported with --cloud (%s); use pre-signed HTTPS links", u)
+		}
+	}
+	plan := modalorch.PlanShards(s.sizes, f.shards, int64(f.maxShardGB*(1<<30)))
+	if err := checkCloudCost(s.sizes, plan, f.cloudWorkers, f.maxCost); err != nil {
+		return err
+	}
+	npiStrs := make([]string, len(s.npis))
+	for i, n := range s.npis {
+		npiStrs[i] = fmt.Sprintf("%d", n)
+	}
+
+	// A URL list read from stdin can't be handed on by path.
+	urlsFile, urls := f.urlsFile, f.urlsList
+	if f.urlsFile == "-" {
+		urlsFile, urls = "", s.urls
+	}
+
+	return modalorch.RunSearch(ctx, modalorch.Config{
+		RunID:           f.runID,
+		NPI:             strings.Join(npiStrs, ","),
+		URLsFile:        urlsFile,
+		URLs:            urls,
+		OutputFile:      f.outputFile,
+		Shards:          f.shards,
+		ShardURLs:       shardURLs(s.urls, s.mirrors, plan),
+		WorkersPerShard: f.cloudWorkers,
+		TaskRetries:     f.taskRetries,
+		RerunMissing:    f.rerunMissing,
+		AllowPartial:    f.allowPartial,
+		Headers:         f.headers,
+		Secrets:         f.modalSecrets,
+		SearchArgs:      shardSearchArgs(cmd, f, s.groupIDs, s.location),
+		NoDedup:         f.noDedup,
+		DedupKey:        f.dedupKey,
+		Progress:        s.progress,
+	})
+}
+
+// shardSearchArgs returns the search flags the orchestrator passes on to
+// each shard. Shards run with --worker-mode (see deploy_modal.py) and make
+// no lookups of their own; they get the location found here.
+func shardSearchArgs(cmd *cobra.Command, f *searchFlags, groupIDs []int64, location string) []string {
+	var args []string
+	if location != "" {
+		args = append(args, "--cdn-location", location)
+	}
+	if logx.IsQuiet() {
+		args = append(args, "--quiet")
+	} else if logx.IsVerbose() {
+		args = append(args, "--verbose")
+	}
+	if humanize.Raw() {
+		args = append(args, "--raw-numbers")
+	}
+	resolve, _ := cmd.Flags().GetStringArray("resolve")
+	for _, r := range resolve {
+		args = append(args, "--resolve", r)
+	}
+	rewriteURLs, _ := cmd.Flags().GetStringArray("rewrite-url")
+	for _, r := range rewriteURLs {
+		args = append(args, "--rewrite-url", r)
+	}
+	if v, _ := cmd.Flags().GetString("ip-version"); v != "auto" {
+		args = append(args, "--ip-version", v)
+	}
+	for _, t := range f.tins {
+		args = append(args, "--tin", t)
+	}
+	for _, id := range groupIDs {
+		args = append(args, "--provider-group-id", strconv.FormatInt(id, 10))
+	}
+	for _, c := range f.billingCodes {
+		args = append(args, "--billing-code", c)
+	}
+	for _, t := range f.codeTypes {
+		args = append(args, "--billing-code-type", t)
+	}
+	if f.collapse != "none" {
+		args = append(args, "--collapse-providers", f.collapse)
+	}
+	if f.noBundles {
+		args = append(args, "--no-bundles")
+	}
+	if f.provenance {
+		args = append(args, "--provenance")
+	}
+	if f.noDedup {
+		args = append(args, "--no-dedup")
+	} else if f.dedupKey != "" {
+		args = append(args, "--dedup-key", f.dedupKey)
+	}
+	if cmd.Flags().Changed("min-rate") || cmd.Flags().Changed("max-rate") {
+		args = append(args, "--min-rate", strconv.FormatFloat(f.minRate, 'g', -1, 64),
+			"--max-rate", strconv.FormatFloat(f.maxRate, 'g', -1, 64))
+	}
+	return args
+}
+
+// checkCloudCost prints the estimated cost of a cloud search over files of
+// the given sizes and fails if it is above maxCost (when positive). With no
+// known file size there is no estimate, which --max-cost treats as a failure.
+func checkCloudCost(sizes []int64, plan [][]int, workers int, maxCost float64) error {
+	est, ok := modalorch.EstimateCost(sizes, plan, workers)
+	if !ok {
+		if maxCost > 0 {
+			return fmt.Errorf("--max-cost: no file sizes available to estimate the cost from")
+		}
+		return nil
+	}
+	logx.Infof("Estimate: %s compressed", humanize.Bytes(uint64(est.Bytes)))
+	if est.SizedFiles < est.Files {
+		logx.Infof(" (%d/%d sizes known, rest at the average)", est.SizedFiles, est.Files)
+	}
+	logx.Infof(", %d shards x %d CPU / %d MB, ~%.1f task-hours, ~%s wall clock, ~$%.2f\n",
+		est.Shards, modalorch.ShardCPU, modalorch.ShardMemoryMB, est.TaskHours,
+		est.WallTime.Round(time.Second), est.Cost)
+	if maxCost > 0 && est.Cost > maxCost {
+		return fmt.Errorf("estimated cost $%.2f exceeds --max-cost $%.2f; use fewer files or raise --max-cost", est.Cost, maxCost)
+	}
+	return nil
+}
+
+// shardURLs turns a shard plan into each shard's URL lines, with the
+// mirrors of each URL after it as in a --urls-file.
+func shardURLs(urls []string, mirrors map[string][]string, plan [][]int) [][]string {
+	out := make([][]string, len(plan))
+	for i, files := range plan {
+		for _, f := range files {
+			out[i] = append(out[i], strings.Join(append([]string{urls[f]}, mirrors[urls[f]]...), " "))
+		}
+	}
+	return out
+}
@@ -2,15 +2,21 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math"
+	"math/rand"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -18,480 +24,1947 @@ import (
 	"syscall"
 	"time"
 
-	modalorch "github.com/gyeh/npi-rates/internal/modal"
+	"github.com/gyeh/npi-rates/internal/cache"
+	"github.com/gyeh/npi-rates/internal/config"
+	"github.com/gyeh/npi-rates/internal/httpx"
+	"github.com/gyeh/npi-rates/internal/humanize"
+	"github.com/gyeh/npi-rates/internal/logx"
 	"github.com/gyeh/npi-rates/internal/mrf"
 	"github.com/gyeh/npi-rates/internal/npi"
+	"github.com/gyeh/npi-rates/internal/objstore"
 	"github.com/gyeh/npi-rates/internal/output"
 	"github.com/gyeh/npi-rates/internal/progress"
+	"github.com/gyeh/npi-rates/internal/server"
 	"github.com/gyeh/npi-rates/internal/toc"
 	"github.com/gyeh/npi-rates/internal/worker"
 	"github.com/spf13/cobra"
 )
 
 func main() {
+	var quiet, verbose, rawNumbers bool
+	var configPath, profile string
+	var requesterPays bool
+	var gcsProject string
+	var resolve []string
+	var rewriteURLs []string
+	var ipVersion string
 	rootCmd := &cobra.Command{
 		Use:   "npi-rates",
 		Short: "Search CMS Price Transparency MRF files for negotiated rates by NPI",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := applyConfig(cmd, configPath, profile); err != nil {
+				return err
+			}
+			humanize.SetRaw(rawNumbers)
+			switch {
+			case quiet && verbose:
+				return fmt.Errorf("--quiet and --verbose are mutually exclusive")
+			case quiet:
+				logx.SetLevel(logx.Quiet)
+			case verbose:
+				logx.SetLevel(logx.Verbose)
+			}
+			if gcsProject == "" {
+				gcsProject = os.Getenv("GOOGLE_CLOUD_PROJECT")
+			}
+			objstore.SetRequesterPays(requesterPays, gcsProject)
+			family := 0
+			switch ipVersion {
+			case "auto":
+			case "4", "6":
+				family, _ = strconv.Atoi(ipVersion)
+			default:
+				return fmt.Errorf("--ip-version must be 4, 6 or auto, got %q", ipVersion)
+			}
+			if err := worker.SetIPVersion(family); err != nil {
+				return err
+			}
+			if err := worker.SetRewrites(rewriteURLs); err != nil {
+				return err
+			}
+			return worker.SetResolve(resolve)
+		},
 	}
+	rootCmd.PersistentFlags().BoolVarP(&quiet, "quiet", "q", false, "Suppress all output except errors and the final summary")
+	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "Log download attempts, retries, and parser decisions")
+	rootCmd.PersistentFlags().BoolVar(&rawNumbers, "raw-numbers", false, "Print sizes and counts as plain integers (for parsing logs)")
+	rootCmd.PersistentFlags().StringVar(&configPath, "config", "", "Config file with flag defaults (default: ~/.config/npi-rates/config.yaml, if present)")
+	rootCmd.PersistentFlags().StringVar(&profile, "profile", "", "Also apply this profile from the config file")
+	rootCmd.PersistentFlags().BoolVar(&requesterPays, "requester-pays", false, "Pay for downloads from requester-pays s3:// and gs:// buckets")
+	rootCmd.PersistentFlags().StringArrayVar(&resolve, "resolve", nil, "Connect to a host at a given address instead of resolving it, as host:addr or host:port:addr like curl's --resolve (can be repeated)")
+	rootCmd.PersistentFlags().StringArrayVar(&rewriteURLs, "rewrite-url", nil, "Rewrite MRF URLs before fetching them, as 'REGEX => REPLACEMENT' ($1 for groups), e.g. '^http:// => https://'; results keep the listed URL (can be repeated)")
+	rootCmd.PersistentFlags().StringVar(&ipVersion, "ip-version", "auto", "IP family to connect over first: 4 or 6, falling back to the other when no address connects, or auto to race both")
+	rootCmd.PersistentFlags().StringVar(&gcsProject, "gcs-project", "", "Google Cloud project billed for requester-pays gs:// downloads (default: $GOOGLE_CLOUD_PROJECT)")
 
 	rootCmd.AddCommand(newSearchCmd())
 	rootCmd.AddCommand(newDownloadCmd())
 	rootCmd.AddCommand(newSplitCmd())
+	rootCmd.AddCommand(newExploreCmd())
+	rootCmd.AddCommand(newTOCCmd())
+	rootCmd.AddCommand(newDiscoverCmd())
+	rootCmd.AddCommand(newServeCmd())
+	rootCmd.AddCommand(newValidateCmd())
+	rootCmd.AddCommand(newStatsCmd())
+	rootCmd.AddCommand(newCompatCmd())
+	rootCmd.AddCommand(newVerifyCmd())
+	rootCmd.AddCommand(newCompareCmd())
+	rootCmd.AddCommand(newCacheCmd())
 
 	if err := rootCmd.Execute(); err != nil {
 		os.Exit(1)
 	}
 }
 
-func newSearchCmd() *cobra.Command {
+// applyConfig sets the flags of cmd that weren't given on the command line
+// from the config file at path (or the default one, if it exists) and the
+// named profile in it. Later settings for a flag replace earlier ones.
+func applyConfig(cmd *cobra.Command, path, profile string) error {
+	explicit := path != ""
+	if !explicit {
+		path = config.DefaultPath()
+	}
+	if path == "" {
+		if profile != "" {
+			return fmt.Errorf("--profile: no config file")
+		}
+		return nil
+	}
+	cfg, err := config.Load(path)
+	if errors.Is(err, os.ErrNotExist) && !explicit && profile == "" {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading config: %w", err)
+	}
+	err = cfg.Validate(func(names []string) bool {
+		c := cmd.Root()
+		for _, name := range names {
+			if c = findSubcommand(c, name); c == nil {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	settings, err := cfg.Settings(strings.Fields(cmd.CommandPath())[1:], profile)
+	if err != nil {
+		return err
+	}
+
+	final := make(map[string]config.Setting)
+	var order []string
+	for _, s := range settings {
+		if _, seen := final[s.Flag]; !seen {
+			order = append(order, s.Flag)
+		}
+		final[s.Flag] = s
+	}
+	flags := cmd.Flags()
+	for _, name := range order {
+		s := final[name]
+		f := flags.Lookup(name)
+		switch {
+		case name == "config" || name == "profile":
+			return fmt.Errorf("%s: --%s can't be set in the config file", cfg.Path, name)
+		case f == nil && s.Scoped:
+			return fmt.Errorf("%s: %s has no --%s flag", cfg.Path, cmd.CommandPath(), name)
+		case f == nil || f.Changed:
+			continue // another command's flag, or given on the command line
+		}
+		for _, v := range s.Values {
+			if err := flags.Set(name, v); err != nil {
+				return fmt.Errorf("%s: --%s: %w", cfg.Path, name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// findSubcommand returns c's subcommand with the given name, or nil.
+func findSubcommand(c *cobra.Command, name string) *cobra.Command {
+	for _, sub := range c.Commands() {
+		if sub.Name() == name {
+			return sub
+		}
+	}
+	return nil
+}
+
+// joinInts joins ids with commas.
+func joinInts(ids []int64) string {
+	strs := make([]string, len(ids))
+	for i, id := range ids {
+		strs[i] = strconv.FormatInt(id, 10)
+	}
+	return strings.Join(strs, ",")
+}
+
+func parseNPIs(s string) ([]int64, error) {
+	parts := strings.Split(s, ",")
+	var npis []int64
+	for _, p := range parts {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(p, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid NPI %q: %w", p, err)
+		}
+		if n < 1000000000 || n > 9999999999 {
+			return nil, fmt.Errorf("NPI %d is not a valid 10-digit NPI", n)
+		}
+		npis = append(npis, n)
+	}
+	return npis, nil
+}
+
+func newDownloadCmd() *cobra.Command {
 	var (
-		urlsFile     string   // Used during Cloud mode or local mode
-		urlsList     []string // URLs passed directly on the command line
-		npiList      string
-		providerName string
-		state        string
-		outputFile   string
-		workers      int
-		tmpDir       string
-		noProgress   bool
-		logProgress  bool
-		noFIFO       bool
-		streamMode   bool
-		noSimd       bool
-
-		// TOC resolution flags
-		planID string
-		tocURL string
-
-		// Cloud mode flags (Modal orchestration)
-		cloudMode    bool
-		shards       int
-		cloudWorkers int
+		outputPath string
+		tmpDir     string
 	)
 
 	cmd := &cobra.Command{
-		Use:   "search",
-		Short: "Search MRF files for negotiated rates matching specified NPIs",
+		Use:   "download <url>",
+		Short: "Download and decompress a single MRF file",
+		Args:  cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			if noSimd {
-				mrf.DisableSimd()
+			url := args[0]
+			filename := worker.FileNameFromURL(url)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			sigCh := make(chan os.Signal, 2)
+			signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+			go func() {
+				<-sigCh
+				cancel()
+				<-sigCh
+				os.Exit(1)
+			}()
+
+			if tmpDir == "" {
+				tmpDir = "."
 			}
 
-			// Resolve NPIs — either from --npi or --provider-name
-			var npis []int64
-			if providerName != "" {
-				selected, err := searchAndSelectProvider(providerName, state)
-				if err != nil {
-					return err
+			if predicted, compressed, err := worker.ProbeDecompressedSize(ctx, url); err == nil {
+				logx.Infof("Predicted decompressed size: ~%s", humanize.Bytes(uint64(predicted)))
+				if compressed > 0 {
+					logx.Infof(" (%s compressed)", humanize.Bytes(uint64(compressed)))
 				}
-				npis = []int64{selected.NPI}
-			} else if npiList != "" {
-				var err error
-				npis, err = parseNPIs(npiList)
-				if err != nil {
-					return fmt.Errorf("parsing NPIs: %w", err)
+				logx.Infof("\n")
+			}
+
+			logx.Infof("Downloading %s ...\n", filename)
+			startTime := time.Now()
+
+			result, err := worker.DownloadAndDecompress(ctx, url, tmpDir, false, func(downloaded, total int64) {
+				// periodic progress is printed below
+			})
+			if err != nil {
+				return fmt.Errorf("download failed: %w", err)
+			}
+
+			// Move temp file to the final output path
+			dest := outputPath
+			if dest == "" {
+				// Strip .gz suffix for the default name
+				dest = strings.TrimSuffix(filename, ".gz")
+			}
+			if err := os.Rename(result.FilePath, dest); err != nil {
+				// Rename failed (cross-device), fall back to keeping temp file
+				dest = result.FilePath
+			}
+
+			elapsed := time.Since(startTime).Truncate(time.Second)
+			info, _ := os.Stat(dest)
+			decompressedSize := int64(0)
+			if info != nil {
+				decompressedSize = info.Size()
+			}
+
+			fmt.Fprintf(os.Stderr, "Downloaded and decompressed in %s\n", elapsed)
+			if result.TotalBytes > 0 {
+				fmt.Fprintf(os.Stderr, "  Compressed:   %s\n", humanize.Bytes(uint64(result.TotalBytes)))
+			}
+			if decompressedSize > 0 {
+				fmt.Fprintf(os.Stderr, "  Decompressed: %s\n", humanize.Bytes(uint64(decompressedSize)))
+			}
+			if ratio := result.Ratio(); ratio > 0 {
+				fmt.Fprintf(os.Stderr, "  Ratio:        %.1fx\n", ratio)
+			}
+			fmt.Fprintf(os.Stderr, "  Output: %s\n", dest)
+
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&outputPath, "output", "o", "", "Output file path (default: filename without .gz)")
+	cmd.Flags().StringVar(&tmpDir, "tmp-dir", "", "Temp directory for intermediate files (default: current dir)")
+
+	return cmd
+}
+
+func newValidateCmd() *cobra.Command {
+	var jsonOut bool
+
+	cmd := &cobra.Command{
+		Use:   "validate <url-or-file>",
+		Short: "Check an MRF file against the CMS in-network schema",
+		Long: `Stream an in-network MRF file (a URL or a local path, compressed or not)
+and report spec violations: missing required keys, values of the wrong type,
+duplicate provider_group_ids, provider_references no rate uses, references to
+undefined groups, and truncation. Exits with status 1 if any are found.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			src := args[0]
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			sigCh := make(chan os.Signal, 2)
+			signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+			go func() {
+				<-sigCh
+				cancel()
+				<-sigCh
+				os.Exit(1)
+			}()
+
+			r, err := openMRF(ctx, src)
+			if err != nil {
+				return err
+			}
+
+			logx.Infof("Validating %s ...\n", worker.FileNameFromURL(src))
+			startTime := time.Now()
+			rep, err := mrf.Validate(r)
+			r.Close()
+			if err != nil {
+				return fmt.Errorf("reading %s: %w", src, err)
+			}
+
+			if jsonOut {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				if err := enc.Encode(rep); err != nil {
+					return err
 				}
+			} else {
+				printValidationReport(rep, time.Since(startTime))
 			}
-			if len(npis) == 0 {
-				return fmt.Errorf("specify either --npi or --provider-name")
+			if !rep.Valid {
+				os.Exit(1)
 			}
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&jsonOut, "json", false, "Write the report as JSON to stdout")
+	return cmd
+}
+
+// mrfFile is an opened MRF, decompressed; closing it closes the source too.
+type mrfFile struct {
+	io.ReadCloser
+	src io.Closer
+}
+
+func (f *mrfFile) Close() error {
+	f.ReadCloser.Close()
+	return f.src.Close()
+}
+
+// openMRF opens src, an MRF URL or local path, decompressing it if needed,
+// for the commands that read a single file whole.
+func openMRF(ctx context.Context, src string) (io.ReadCloser, error) {
+	var body io.ReadCloser
+	if worker.IsRemote(src) {
+		resp, err := worker.DownloadHTTP(ctx, src)
+		if err != nil {
+			return nil, fmt.Errorf("download failed: %w", err)
+		}
+		body = resp.Body
+	} else {
+		f, err := os.Open(src)
+		if err != nil {
+			return nil, err
+		}
+		body = f
+	}
+	r, err := worker.NewDecompressReader(body, false)
+	if err != nil {
+		body.Close()
+		return nil, fmt.Errorf("reading %s: %w", src, err)
+	}
+	return &mrfFile{ReadCloser: r, src: body}, nil
+}
+
+// printValidationReport prints a validate report for people.
+func printValidationReport(rep *mrf.ValidationReport, elapsed time.Duration) {
+	version := rep.Version
+	if version == "" {
+		version = "unknown"
+	}
+	fmt.Printf("Schema version %s: %s provider references, %s in_network items (%s)\n",
+		version, humanize.Count(rep.ProviderReferences), humanize.Count(rep.InNetworkItems), elapsed.Truncate(time.Second))
+	if rep.Valid {
+		fmt.Println("No spec violations found.")
+		return
+	}
+	fmt.Printf("%d kind(s) of spec violation:\n", len(rep.Issues))
+	for _, is := range rep.Issues {
+		fmt.Printf("  %-32s %s x %s\n", is.Kind, is.Field, humanize.Count(is.Count))
+		fmt.Printf("      %s", is.Message)
+		if is.Example != "" {
+			fmt.Printf(" (first at %s)", is.Example)
+		}
+		fmt.Println()
+	}
+}
+
+func newStatsCmd() *cobra.Command {
+	var jsonOut bool
+
+	cmd := &cobra.Command{
+		Use:   "stats <url-or-file>",
+		Short: "Summarize an MRF file without filtering by provider",
+		Long: `Stream an in-network MRF file (a URL or a local path, compressed or not)
+and profile all of it: provider reference and group counts, distinct NPIs and
+TINs, in_network items by billing code type and negotiation arrangement, and
+the min, median and max negotiated rate in each unit.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			src := args[0]
 
-			// Handle signals: first ^C cancels context for graceful shutdown,
-			// second ^C force-exits immediately.
 			ctx, cancel := context.WithCancel(context.Background())
 			defer cancel()
 			sigCh := make(chan os.Signal, 2)
 			signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 			go func() {
-				sig := <-sigCh
-				fmt.Fprintf(os.Stderr, "\nReceived %s, shutting down... (^C again to force quit)\n", sig)
+				<-sigCh
 				cancel()
-				sig = <-sigCh
-				fmt.Fprintf(os.Stderr, "\nReceived %s, force quit.\n", sig)
+				<-sigCh
 				os.Exit(1)
 			}()
 
-			// Default output filename with timestamp
-			if outputFile == "" {
-				outputFile = fmt.Sprintf("results_%s.json", time.Now().Format("20060102_150405"))
+			r, err := openMRF(ctx, src)
+			if err != nil {
+				return err
 			}
 
-			// Look up NPI provider info
-			if !logProgress {
-				if notFound := printProviderInfo(ctx, npis); len(notFound) > 0 {
-					if !confirmContinue(notFound) {
-						return fmt.Errorf("aborted: %d NPI(s) not found in NPPES registry", len(notFound))
-					}
+			logx.Infof("Profiling %s ...\n", worker.FileNameFromURL(src))
+			startTime := time.Now()
+			st, err := mrf.Stats(r)
+			r.Close()
+			if err != nil {
+				return fmt.Errorf("reading %s: %w", src, err)
+			}
+
+			if jsonOut {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				return enc.Encode(st)
+			}
+			printFileStats(st, time.Since(startTime))
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&jsonOut, "json", false, "Write the summary as JSON to stdout")
+	return cmd
+}
+
+// printFileStats prints a stats summary for people.
+func printFileStats(st *mrf.FileStats, elapsed time.Duration) {
+	if st.ReportingEntityName != "" {
+		fmt.Printf("Reporting entity:    %s\n", st.ReportingEntityName)
+	}
+	if st.Version != "" {
+		fmt.Printf("Schema version:      %s\n", st.Version)
+	}
+	fmt.Printf("Provider references: %s (%s provider groups)\n", humanize.Count(st.ProviderReferences), humanize.Count(st.ProviderGroups))
+	fmt.Printf("Distinct NPIs:       %s\n", humanize.Count(st.DistinctNPIs))
+	fmt.Printf("Distinct TINs:       %s\n", humanize.Count(st.DistinctTINs))
+	fmt.Printf("In-network items:    %s (%s negotiated rates, %s prices)\n",
+		humanize.Count(st.InNetworkItems), humanize.Count(st.NegotiatedRates), humanize.Count(st.NegotiatedPrices))
+
+	printCounts("Billing code types:", st.BillingCodeTypes)
+	printCounts("Negotiation arrangements:", st.Arrangements)
+
+	if len(st.Rates) > 0 {
+		fmt.Println("Negotiated rates:")
+		units := make([]string, 0, len(st.Rates))
+		for u := range st.Rates {
+			units = append(units, u)
+		}
+		sort.Strings(units)
+		for _, u := range units {
+			d := st.Rates[u]
+			median := "median"
+			if d.MedianApproximate {
+				median = "median~"
+			}
+			fmt.Printf("  %-12s %12s prices  min %-12g %-7s %-12g max %g\n", u, humanize.Count(d.Count), d.Min, median, d.Median, d.Max)
+		}
+		if approximate(st.Rates) {
+			fmt.Println("  (median~ is an approximate median of a 100,000-price sample)")
+		}
+	}
+	fmt.Printf("(%s)\n", elapsed.Truncate(time.Second))
+}
+
+// printCounts prints counts under a heading, largest first.
+// approximate reports whether any distribution's median was sampled.
+func approximate(rates map[string]*mrf.RateDistribution) bool {
+	for _, d := range rates {
+		if d.MedianApproximate {
+			return true
+		}
+	}
+	return false
+}
+
+func printCounts(heading string, counts map[string]int64) {
+	if len(counts) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	fmt.Println(heading)
+	for _, k := range keys {
+		name := k
+		if name == "" {
+			name = "(none)"
+		}
+		fmt.Printf("  %-12s %s\n", name, humanize.Count(counts[k]))
+	}
+}
+
+func newCompatCmd() *cobra.Command {
+	var (
+		urlsFile string
+		sliceMB  int
+		workers  int
+		jsonOut  bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "compat [url-or-file ...]",
+		Short: "Check that the start of each MRF still parses (format drift check)",
+		Long: `Fetch the first --slice-mb MB of each in-network file (a Range request, so
+the rest is never downloaded), decompress it and parse as much as it holds,
+decoding provider_references and in_network elements as a search would. Each
+file is graded ok, warn (parsed, but fields of unexpected types or no
+elements) or fail (unreadable), in a table or, with --json, as JSON. Exits
+with status 1 if any file fails, for use in a scheduled job.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			srcs := args
+			if urlsFile != "" {
+				urls, _, err := readURLs(urlsFile)
+				if err != nil {
+					return fmt.Errorf("reading URLs file: %w", err)
+				}
+				srcs = append(srcs, urls...)
+			}
+			if len(srcs) == 0 {
+				return fmt.Errorf("no files; pass URLs or --urls-file")
+			}
+			if sliceMB <= 0 {
+				return fmt.Errorf("--slice-mb must be positive, got %d", sliceMB)
+			}
+
+			ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+			defer cancel()
+
+			results := make([]compatResult, len(srcs))
+			sem := make(chan struct{}, max(workers, 1))
+			var wg sync.WaitGroup
+			for i, src := range srcs {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					sem <- struct{}{}
+					defer func() { <-sem }()
+					results[i] = checkCompat(ctx, src, int64(sliceMB)<<20)
+				}()
+			}
+			wg.Wait()
+
+			failed := 0
+			for _, r := range results {
+				if r.Status == mrf.CompatFail {
+					failed++
 				}
 			}
-
-			// Validate TOC flags: must be provided together.
-			if (planID != "") != (tocURL != "") {
-				return fmt.Errorf("--plan-id and --toc-url must be provided together")
+			if jsonOut {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				if err := enc.Encode(results); err != nil {
+					return err
+				}
+			} else {
+				printCompatTable(results)
+			}
+			if failed > 0 {
+				// The table says which; usage would only bury it.
+				cmd.SilenceUsage = true
+				return fmt.Errorf("%d of %d files failed", failed, len(results))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&urlsFile, "urls-file", "", "File of MRF URLs to check, one per line (as for search)")
+	cmd.Flags().IntVar(&sliceMB, "slice-mb", 4, "Compressed MB to fetch from the start of each file")
+	cmd.Flags().IntVar(&workers, "workers", 4, "Files checked at once")
+	cmd.Flags().BoolVar(&jsonOut, "json", false, "Write the results as JSON to stdout")
+	return cmd
+}
+
+// compatResult is one file's row in the compat report.
+type compatResult struct {
+	URL    string            `json:"url"`
+	Host   string            `json:"host"`
+	Status string            `json:"status"`
+	Report *mrf.CompatReport `json:"report"`
+}
+
+// checkCompat reads the first sliceBytes of src, a URL or local path, and
+// checks what it holds.
+func checkCompat(ctx context.Context, src string, sliceBytes int64) compatResult {
+	res := compatResult{URL: src, Host: "local"}
+	if u, err := url.Parse(src); err == nil && u.Host != "" {
+		res.Host = u.Host
+	}
+	fail := func(err error) compatResult {
+		res.Report = &mrf.CompatReport{Err: err.Error()}
+		res.Status = mrf.CompatFail
+		return res
+	}
+
+	var body io.ReadCloser
+	if worker.IsRemote(src) {
+		var err error
+		if body, err = worker.OpenSlice(ctx, src, sliceBytes); err != nil {
+			return fail(fmt.Errorf("download: %w", err))
+		}
+	} else {
+		f, err := os.Open(src)
+		if err != nil {
+			return fail(err)
+		}
+		body = struct {
+			io.Reader
+			io.Closer
+		}{io.LimitReader(f, sliceBytes), f}
+	}
+	defer body.Close()
+
+	r, err := worker.NewDecompressReader(body, true)
+	if err != nil {
+		return fail(fmt.Errorf("decompress: %w", err))
+	}
+	defer r.Close()
+	res.Report = mrf.CheckCompat(r)
+	res.Status = res.Report.Status()
+	return res
+}
+
+// printCompatTable prints compat results for people, one file per line.
+func printCompatTable(results []compatResult) {
+	fmt.Printf("%-6s %-32s %-40s %-8s %10s %10s  %s\n", "STATUS", "HOST", "FILE", "VERSION", "REFS", "ITEMS", "NOTE")
+	counts := map[string]int{}
+	for _, r := range results {
+		rep := r.Report
+		counts[r.Status]++
+		note := rep.Err
+		switch {
+		case note != "":
+		case rep.FirstTypeError != "":
+			note = fmt.Sprintf("%d type error(s), first: %s", rep.TypeErrors, rep.FirstTypeError)
+		case rep.InNetworkItems == 0 && rep.Truncated && !slices.Contains(rep.Keys, "in_network"):
+			note = "in_network not reached in the slice"
+		case rep.InNetworkItems == 0 && rep.Truncated:
+			note = "slice ends inside the first in_network item; try a larger --slice-mb"
+		}
+		version := rep.Version
+		if version == "" {
+			version = "-"
+		}
+		fmt.Printf("%-6s %-32s %-40s %-8s %10s %10s  %s\n", r.Status, truncateLeft(r.Host, 32),
+			truncateLeft(worker.FileNameFromURL(r.URL), 40), version,
+			humanize.Count(rep.ProviderReferences), humanize.Count(rep.InNetworkItems), note)
+	}
+	fmt.Printf("\n%d ok, %d warn, %d fail\n", counts[mrf.CompatOK], counts[mrf.CompatWarn], counts[mrf.CompatFail])
+}
+
+// truncateLeft shortens s to n characters, keeping its end.
+func truncateLeft(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return "..." + s[len(s)-(n-3):]
+}
+
+func newVerifyCmd() *cobra.Command {
+	var (
+		urlsFile string
+		npiList  string
+		tins     []string
+		compare  string
+		sample   int
+		seed     int64
+		tmpDir   string
+		workers  int
+		show     int
+		jsonOut  bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "verify [url ...]",
+		Short: "Check that two pipeline modes or parsers find the same rates",
+		Long: `Search a random sample of files twice, with two configurations, and compare
+the results of each file as multisets (order aside, every result must appear
+as often in both). A configuration is a pipeline mode, stream, split (with
+FIFO streaming) or file (split, downloading to disk first), optionally with
+a parser: stream+simd, split+stdlib. Prints a row per file and, for files
+that differ, examples of the results only one side found. Exits with status 1
+if any file differs or fails, so it can guard a change of flags.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			srcs := args
+			if urlsFile != "" {
+				urls, _, err := readURLs(urlsFile)
+				if err != nil {
+					return fmt.Errorf("reading URLs file: %w", err)
+				}
+				srcs = append(srcs, urls...)
+			}
+			if len(srcs) == 0 {
+				return fmt.Errorf("no files; pass URLs or --urls-file")
+			}
+			npis, err := parseNPIs(npiList)
+			if err != nil {
+				return fmt.Errorf("parsing NPIs: %w", err)
+			}
+			if len(npis) == 0 && len(tins) == 0 {
+				return fmt.Errorf("specify --npi or --tin")
+			}
+			sides := strings.Split(compare, ",")
+			if len(sides) != 2 {
+				return fmt.Errorf("--compare takes two configurations, e.g. stream,split")
+			}
+			var configs [2]verifyConfig
+			for i, s := range sides {
+				if configs[i], err = parseVerifyConfig(s); err != nil {
+					return err
+				}
+			}
+			if configs[0].same(configs[1]) {
+				return fmt.Errorf("--compare %s: both sides run the same way", compare)
+			}
+			if sample <= 0 {
+				return fmt.Errorf("--sample must be positive, got %d", sample)
+			}
+
+			// A sample is reproducible from its seed.
+			if !cmd.Flags().Changed("seed") {
+				seed = rand.Int63()
+			}
+			picked := pickSample(srcs, sample, seed)
+			logx.Infof("Verifying %d of %d file(s) (--seed %d), %s against %s\n\n",
+				len(picked), len(srcs), seed, configs[0].name, configs[1].name)
+
+			ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+			defer cancel()
+
+			npiSet := make(map[int64]struct{}, len(npis))
+			for _, n := range npis {
+				npiSet[n] = struct{}{}
+			}
+			search := &mrf.SearchOptions{NPIs: npiSet, TINs: tins}
+			defer mrf.SetSimd(mrf.Simd())
+			if tmpDir == "" {
+				tmpDir = os.TempDir()
+			}
+
+			var runs [2][]worker.PipelineResult
+			for i, c := range configs {
+				mrf.SetSimd(c.simd)
+				logx.Infof("Searching with %s (%s)...\n", c.name, mrf.ParserName())
+				pool := &worker.Pool{
+					Workers:  workers,
+					Search:   search,
+					TmpDir:   tmpDir,
+					Progress: &progress.NoopManager{},
+					NoFIFO:   c.noFIFO,
+					Stream:   c.stream,
+				}
+				runs[i] = pool.Run(ctx, picked)
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+			}
+
+			results := make([]verifyResult, len(picked))
+			bad := 0
+			for i, u := range picked {
+				a, b := runs[0][i], runs[1][i]
+				r := verifyResult{URL: u, A: len(a.Results), B: len(b.Results)}
+				switch {
+				case a.Err != nil:
+					r.Err = fmt.Sprintf("%s: %v", configs[0].name, a.Err)
+				case b.Err != nil:
+					r.Err = fmt.Sprintf("%s: %v", configs[1].name, b.Err)
+				default:
+					r.Diff = worker.DiffResults(a.Results, b.Results)
+				}
+				if r.Err != "" || !r.Diff.Same() {
+					bad++
+				}
+				results[i] = r
+			}
+
+			if jsonOut {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				if err := enc.Encode(results); err != nil {
+					return err
+				}
+			} else {
+				printVerifyReport(results, configs[0].name, configs[1].name, show)
+			}
+			if bad > 0 {
+				// The report says which; usage would only bury it.
+				cmd.SilenceUsage = true
+				return fmt.Errorf("%d of %d file(s) differ or failed", bad, len(results))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&urlsFile, "urls-file", "", "File of MRF URLs to sample from, one per line (as for search)")
+	cmd.Flags().StringVar(&npiList, "npi", "", "Comma-separated NPI numbers to search for")
+	cmd.Flags().StringSliceVar(&tins, "tin", nil, "Provider group TINs (EINs) to search for (can be repeated)")
+	cmd.Flags().StringVar(&compare, "compare", "stream,split", "The two configurations to compare, each a mode (stream, split, file) with an optional +simd or +stdlib parser")
+	cmd.Flags().IntVar(&sample, "sample", 3, "Files to pick at random")
+	cmd.Flags().Int64Var(&seed, "seed", 0, "Seed for picking the sample, to repeat an earlier one (default: random, printed)")
+	cmd.Flags().StringVar(&tmpDir, "tmp-dir", "", "Temp directory for split and file modes (default: system temp)")
+	cmd.Flags().IntVar(&workers, "workers", 3, "Files searched at once")
+	cmd.Flags().IntVar(&show, "show", 5, "Results to print per side for each file that differs")
+	cmd.Flags().BoolVar(&jsonOut, "json", false, "Write the results, with every differing result, as JSON to stdout")
+	return cmd
+}
+
+// pickSample returns n of srcs picked at random with seed, in their order
+// in srcs, or all of them if there are no more than n.
+func pickSample(srcs []string, n int, seed int64) []string {
+	if n >= len(srcs) {
+		return srcs
+	}
+	idx := rand.New(rand.NewSource(seed)).Perm(len(srcs))[:n]
+	sort.Ints(idx)
+	picked := make([]string, len(idx))
+	for i, j := range idx {
+		picked[i] = srcs[j]
+	}
+	return picked
+}
+
+// verifyConfig is one side of a verify run.
+type verifyConfig struct {
+	name   string
+	stream bool
+	noFIFO bool
+	simd   bool
+}
+
+// parseVerifyConfig parses "mode[+parser]"; the parser defaults to simd,
+// which falls back to stdlib on CPUs without simdjson support.
+func parseVerifyConfig(s string) (verifyConfig, error) {
+	s = strings.TrimSpace(s)
+	mode, parser, _ := strings.Cut(s, "+")
+	c := verifyConfig{name: s, simd: true}
+	switch mode {
+	case "stream":
+		c.stream = true
+	case "split":
+	case "file":
+		c.noFIFO = true
+	default:
+		return c, fmt.Errorf("--compare: unknown mode %q (want stream, split or file)", mode)
+	}
+	switch parser {
+	case "", "simd":
+	case "stdlib":
+		c.simd = false
+	default:
+		return c, fmt.Errorf("--compare: unknown parser %q (want simd or stdlib)", parser)
+	}
+	return c, nil
+}
+
+// same reports whether c and o would run identically. The parsers differ
+// whenever simd does: without simdjson support, simd is the structural
+// scanner.
+func (c verifyConfig) same(o verifyConfig) bool {
+	return c.stream == o.stream && c.noFIFO == o.noFIFO && c.simd == o.simd
+}
+
+// verifyResult is one file's row in the verify report.
+type verifyResult struct {
+	URL  string             `json:"url"`
+	A    int                `json:"a_rates"`
+	B    int                `json:"b_rates"`
+	Err  string             `json:"error,omitempty"`
+	Diff *worker.ResultDiff `json:"diff,omitempty"`
+}
+
+func printVerifyReport(results []verifyResult, a, b string, show int) {
+	fmt.Printf("\n%-7s %-48s %10s %10s %8s %8s\n", "STATUS", "FILE", "A RATES", "B RATES", "ONLY A", "ONLY B")
+	same := 0
+	for _, r := range results {
+		status, onlyA, onlyB := "same", "-", "-"
+		switch {
+		case r.Err != "":
+			status = "error"
+		case !r.Diff.Same():
+			status = "DIFF"
+		default:
+			same++
+		}
+		if r.Diff != nil {
+			onlyA, onlyB = humanize.Count(int64(len(r.Diff.OnlyA))), humanize.Count(int64(len(r.Diff.OnlyB)))
+		}
+		fmt.Printf("%-7s %-48s %10s %10s %8s %8s\n", status, truncateLeft(worker.FileNameFromURL(r.URL), 48),
+			humanize.Count(int64(r.A)), humanize.Count(int64(r.B)), onlyA, onlyB)
+	}
+	for _, r := range results {
+		if r.Err != "" {
+			fmt.Printf("\n%s: %s\n", worker.FileNameFromURL(r.URL), r.Err)
+			continue
+		}
+		if r.Diff.Same() {
+			continue
+		}
+		fmt.Printf("\n%s:\n", worker.FileNameFromURL(r.URL))
+		for _, side := range []struct {
+			name    string
+			results []mrf.RateResult
+		}{{a, r.Diff.OnlyA}, {b, r.Diff.OnlyB}} {
+			for i, res := range side.results {
+				if show > 0 && i == show {
+					fmt.Printf("  only %s: ... %s more\n", side.name, humanize.Count(int64(len(side.results)-show)))
+					break
+				}
+				data, _ := json.Marshal(res)
+				fmt.Printf("  only %s: %s\n", side.name, data)
+			}
+		}
+	}
+	fmt.Printf("\n%d of %d file(s) the same with %s and %s\n", same, len(results), a, b)
+}
+
+func newCompareCmd() *cobra.Command {
+	var (
+		perNPI  bool
+		top     int
+		jsonOut bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "compare <a.json> <b.json>",
+		Short: "Compare the rates in two search result files",
+		Long: `Compare two search outputs (JSON or NDJSON), e.g. from two payers or two
+months. Rates are grouped per billing code, billing class, setting and unit
+(and NPI, with --per-npi) and the median of each group in b is compared with
+its median in a. Prints the largest changes and the codes found in only one
+file, or with --json all of them.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var aggs [2][]mrf.RateAggregate
+			for i, path := range args {
+				agg := mrf.NewAggregator()
+				err := output.ReadResults(path, func(r *mrf.RateResult) {
+					if !perNPI {
+						r.NPI, r.NPIs = 0, nil
+					}
+					agg.Add(r)
+				})
+				if err != nil {
+					return err
+				}
+				aggs[i] = agg.Aggregates()
+			}
+			c := mrf.CompareAggregates(aggs[0], aggs[1])
+
+			if jsonOut {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				return enc.Encode(c)
+			}
+			printComparison(c, args[0], args[1], top)
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&perNPI, "per-npi", false, "Compare each NPI's rates separately instead of pooling providers")
+	cmd.Flags().IntVar(&top, "top", 30, "Rows to print per section (0: all)")
+	cmd.Flags().BoolVar(&jsonOut, "json", false, "Write the full comparison as JSON to stdout")
+	return cmd
+}
+
+// printComparison prints the largest rate changes between two result files
+// and the codes only one has, up to top rows each.
+func printComparison(c *mrf.Comparison, a, b string, top int) {
+	limit := func(n int) int {
+		if top > 0 {
+			return min(n, top)
+		}
+		return n
+	}
+	code := func(r *mrf.RateComparison) string {
+		s := r.BillingCodeType + " " + r.BillingCode
+		if r.NPI != 0 {
+			s = strconv.FormatInt(r.NPI, 10) + " " + s
+		}
+		return s
+	}
+
+	fmt.Printf("a: %s\nb: %s\n\n", a, b)
+	fmt.Printf("%s codes in both: %s up, %s down, %s unchanged\n", humanize.Count(int64(len(c.Both))),
+		humanize.Count(int64(c.Increased)), humanize.Count(int64(c.Decreased)), humanize.Count(int64(c.Unchanged)))
+	if n := limit(len(c.Both)); n > 0 {
+		fmt.Printf("\n%-28s %-14s %-12s %-8s %12s %12s %12s %9s\n", "CODE", "CLASS", "SETTING", "UNIT", "MEDIAN A", "MEDIAN B", "DELTA", "CHANGE")
+		for _, r := range c.Both[:n] {
+			change := "-"
+			if r.PercentChange != nil {
+				change = fmt.Sprintf("%+.1f%%", *r.PercentChange)
+			}
+			fmt.Printf("%-28s %-14s %-12s %-8s %12.2f %12.2f %+12.2f %9s\n", truncateLeft(code(&r), 28), r.BillingClass, r.Setting, r.Unit,
+				r.A.Median, r.B.Median, *r.Delta, change)
+		}
+		if n < len(c.Both) {
+			fmt.Printf("... %s more (--top 0 for all)\n", humanize.Count(int64(len(c.Both)-n)))
+		}
+	}
+	for _, only := range []struct {
+		name string
+		rows []mrf.RateComparison
+	}{{a, c.OnlyA}, {b, c.OnlyB}} {
+		if len(only.rows) == 0 {
+			continue
+		}
+		fmt.Printf("\nOnly in %s: %s\n", only.name, humanize.Count(int64(len(only.rows))))
+		n := limit(len(only.rows))
+		for _, r := range only.rows[:n] {
+			d := r.A
+			if d == nil {
+				d = r.B
+			}
+			fmt.Printf("  %-28s %-14s %-12s %-8s median %.2f (%s rates)\n", truncateLeft(code(&r), 28), r.BillingClass, r.Setting, r.Unit,
+				d.Median, humanize.Count(d.Count))
+		}
+		if n < len(only.rows) {
+			fmt.Printf("  ... %s more\n", humanize.Count(int64(len(only.rows)-n)))
+		}
+	}
+}
+
+func newCacheCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cache",
+		Short: "Show and prune the download, split and NPPES caches",
+	}
+	cmd.AddCommand(newCacheStatsCmd())
+	cmd.AddCommand(newCacheGCCmd())
+	return cmd
+}
+
+// cacheDirs holds the cache locations the cache subcommands act on. The
+// flags are named as search's, so one config file setting serves both.
+type cacheDirs struct {
+	downloads, splits, nppes string
+}
+
+func (d *cacheDirs) addFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&d.downloads, "cache-dir", "", "Download cache, as passed to search --cache-dir")
+	cmd.Flags().StringVar(&d.splits, "keep-split", "", "Kept split output, as passed to search --keep-split")
+	cmd.Flags().StringVar(&d.nppes, "nppes-cache-dir", npi.DefaultClient.CacheDir, "NPPES lookup cache (\"\" to leave it out)")
+}
+
+func (d *cacheDirs) stores() []cache.Store {
+	return cacheStores(d.downloads, d.splits, d.nppes)
+}
+
+// cacheStores returns the caches whose dirs are set.
+func cacheStores(downloads, splits, nppes string) []cache.Store {
+	var stores []cache.Store
+	if downloads != "" {
+		stores = append(stores, cache.Store{Name: "downloads", Dir: downloads, Entries: worker.DownloadCacheEntries})
+	}
+	if splits != "" {
+		stores = append(stores, cache.Store{Name: "splits", Dir: splits, Entries: worker.KeptSplitEntries})
+	}
+	if nppes != "" {
+		stores = append(stores, cache.Store{Name: "nppes", Dir: nppes, Entries: npi.CacheEntries})
+	}
+	return stores
+}
+
+// parseCachePolicy parses --cache-max-size and --cache-max-age.
+func parseCachePolicy(maxSize string, maxAge time.Duration) (cache.Policy, error) {
+	p := cache.Policy{MaxAge: maxAge}
+	if maxSize != "" {
+		n, err := humanize.ParseBytes(maxSize)
+		if err != nil {
+			return p, fmt.Errorf("--cache-max-size: %w", err)
+		}
+		p.MaxSize = n
+	}
+	if p.MaxAge < 0 {
+		return p, fmt.Errorf("--cache-max-age must not be negative")
+	}
+	return p, nil
+}
+
+func newCacheStatsCmd() *cobra.Command {
+	var (
+		dirs    cacheDirs
+		jsonOut bool
+	)
+	cmd := &cobra.Command{
+		Use:   "stats",
+		Short: "Show each cache's size, entry count and age",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var all []cache.Stats
+			for _, s := range dirs.stores() {
+				st, err := s.Stats()
+				if err != nil {
+					return fmt.Errorf("%s cache: %w", s.Name, err)
+				}
+				all = append(all, st)
+			}
+			if jsonOut {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				return enc.Encode(all)
+			}
+			fmt.Printf("%-10s %9s %10s %-17s %-17s %s\n", "CACHE", "ENTRIES", "SIZE", "LEAST RECENT", "MOST RECENT", "DIR")
+			var total int64
+			for _, st := range all {
+				when := func(t time.Time) string {
+					if t.IsZero() {
+						return "-"
+					}
+					return t.Local().Format("2006-01-02 15:04")
+				}
+				fmt.Printf("%-10s %9s %10s %-17s %-17s %s\n", st.Name, humanize.Count(int64(st.Entries)),
+					humanize.Bytes(uint64(st.Size)), when(st.Oldest), when(st.Newest), st.Dir)
+				if st.Trash > 0 {
+					fmt.Printf("%-10s %9s %10s (left by an interrupted gc; the next gc deletes it)\n", "", "trash", humanize.Bytes(uint64(st.Trash)))
+				}
+				total += st.Size + st.Trash
+			}
+			fmt.Printf("Total: %s\n", humanize.Bytes(uint64(total)))
+			return nil
+		},
+	}
+	dirs.addFlags(cmd)
+	cmd.Flags().BoolVar(&jsonOut, "json", false, "Write the stats as JSON to stdout")
+	return cmd
+}
+
+func newCacheGCCmd() *cobra.Command {
+	var (
+		dirs    cacheDirs
+		maxSize string
+		maxAge  time.Duration
+		dryRun  bool
+		jsonOut bool
+	)
+	cmd := &cobra.Command{
+		Use:   "gc",
+		Short: "Evict cache entries past an age limit, then the least recently used over a size quota",
+		Long: `Remove cache entries not used for --cache-max-age, then the least recently
+used of the rest until the caches together fit in --cache-max-size. Entries
+are first moved into a hidden trash dir, so a search never finds one half
+removed, and the trash is deleted after.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if maxSize == "" && maxAge == 0 {
+				return fmt.Errorf("give --cache-max-size, --cache-max-age or both")
+			}
+			policy, err := parseCachePolicy(maxSize, maxAge)
+			if err != nil {
+				return err
+			}
+			evicted, err := cache.GC(dirs.stores(), policy, time.Now(), dryRun)
+			if jsonOut {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				if encErr := enc.Encode(evicted); encErr != nil {
+					return encErr
+				}
+				return err
+			}
+			verb := "Removed"
+			if dryRun {
+				verb = "Would remove"
+			}
+			var freed int64
+			for _, e := range evicted {
+				fmt.Printf("%-10s %10s  %-4s  %s\n", e.Store, humanize.Bytes(uint64(e.Size)), e.Reason, e.Entry)
+				freed += e.Size
+			}
+			fmt.Printf("%s %s entries, %s\n", verb, humanize.Count(int64(len(evicted))), humanize.Bytes(uint64(freed)))
+			return err
+		},
+	}
+	dirs.addFlags(cmd)
+	cmd.Flags().StringVar(&maxSize, "cache-max-size", "", "Evict least recently used entries until the caches together fit in this size (e.g. 200GB)")
+	cmd.Flags().DurationVar(&maxAge, "cache-max-age", 0, "Evict entries not used for this long (e.g. 720h)")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "List what would be removed without removing it")
+	cmd.Flags().BoolVar(&jsonOut, "json", false, "Write the evicted entries as JSON to stdout")
+	return cmd
+}
+
+func newSplitCmd() *cobra.Command {
+	var outputDir string
+
+	cmd := &cobra.Command{
+		Use:   "split <file>",
+		Short: "Split a decompressed MRF JSON file into NDJSON chunks",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			inputPath := args[0]
+
+			// Verify the input file exists
+			info, err := os.Stat(inputPath)
+			if err != nil {
+				return fmt.Errorf("cannot read input file: %w", err)
+			}
+
+			if outputDir == "" {
+				outputDir = strings.TrimSuffix(inputPath, ".json") + "_split"
+			}
+			if err := os.MkdirAll(outputDir, 0o755); err != nil {
+				return fmt.Errorf("creating output dir: %w", err)
+			}
+
+			logx.Infof("Splitting %s (%s) ...\n", inputPath, humanize.Bytes(uint64(info.Size())))
+			startTime := time.Now()
+
+			result, err := mrf.SplitFile(inputPath, outputDir)
+			if err != nil {
+				return fmt.Errorf("split failed: %w", err)
+			}
+
+			elapsed := time.Since(startTime).Truncate(time.Second)
+
+			fmt.Fprintf(os.Stderr, "Split complete in %s\n", elapsed)
+			fmt.Fprintf(os.Stderr, "  Output dir: %s\n", outputDir)
+			fmt.Fprintf(os.Stderr, "  provider_references: %d file(s)\n", len(result.ProviderReferenceFiles))
+			fmt.Fprintf(os.Stderr, "  in_network:          %d file(s)\n", len(result.InNetworkFiles))
+
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&outputDir, "output-dir", "o", "", "Output directory for split files (default: <input>_split)")
+
+	return cmd
+}
+
+func newExploreCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "explore <split-dir>",
+		Short: "Query split NDJSON output interactively",
+		Long: `Open a directory written by split (or kept from a search) and answer
+commands read from stdin: counts, the most common billing codes, where an NPI
+appears, pretty-printed records and quick stats. Nothing in the directory is
+changed. Type "help" at the prompt for the commands; they can also be piped
+in:
+
+  echo "grep 1770671182" | npi-rates explore in_network_split`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir, err := mrf.OpenSplitDir(args[0])
+			if err != nil {
+				return err
+			}
+			if len(dir.ProviderReferenceFiles) == 0 && len(dir.InNetworkFiles) == 0 {
+				return fmt.Errorf("%s has no split files (provider_references_*.jsonl, in_network_*.jsonl)", args[0])
+			}
+			interactive := false
+			if fi, err := os.Stdin.Stat(); err == nil {
+				interactive = fi.Mode()&os.ModeCharDevice != 0
+			}
+			if interactive {
+				fmt.Printf("%s: %d provider_references and %d in_network file(s). Type \"help\" for commands.\n",
+					dir.Dir, len(dir.ProviderReferenceFiles), len(dir.InNetworkFiles))
+			}
+			sc := bufio.NewScanner(os.Stdin)
+			for {
+				if interactive {
+					fmt.Print("explore> ")
+				}
+				if !sc.Scan() {
+					break
+				}
+				words := strings.Fields(sc.Text())
+				if len(words) == 0 {
+					continue
+				}
+				if words[0] == "quit" || words[0] == "exit" {
+					return nil
+				}
+				if err := exploreCommand(dir, words); err != nil {
+					fmt.Printf("error: %v\n", err)
+				}
+			}
+			if interactive {
+				fmt.Println()
+			}
+			return sc.Err()
+		},
+	}
+	return cmd
+}
+
+// exploreHelp lists the explore commands.
+const exploreHelp = `Commands:
+  files                    List the split files and their sizes
+  count [refs|items|codes] Count provider_references entries, in_network items, or distinct billing codes
+  codes [n]                The n billing codes with the most in_network items (default 20)
+  grep <npi>               Provider groups listing an NPI, and in_network items with rates for it
+  show <file>:<line>       Pretty-print a record, e.g. show in_network_00.jsonl:42
+  show code <code> [n]     Pretty-print the first n in_network items for a billing code (default 1)
+  show group <id>          Pretty-print the provider_references entry for a provider_group_id
+  stats                    Counts, billing code types, arrangements and rate distributions
+  help                     This list
+  quit                     Leave (also ^D)
+`
+
+// exploreCommand runs one explore command.
+func exploreCommand(dir *mrf.SplitResult, words []string) error {
+	arg := func(i int) string {
+		if i < len(words) {
+			return words[i]
+		}
+		return ""
+	}
+	count := func(i, def int) (int, error) {
+		if arg(i) == "" {
+			return def, nil
+		}
+		n, err := strconv.Atoi(arg(i))
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("expected a positive count, got %q", arg(i))
+		}
+		return n, nil
+	}
+
+	switch words[0] {
+	case "help", "?":
+		fmt.Print(exploreHelp)
+
+	case "files":
+		for _, f := range append(append([]string(nil), dir.ProviderReferenceFiles...), dir.InNetworkFiles...) {
+			size := int64(0)
+			if info, err := os.Stat(f); err == nil {
+				size = info.Size()
+			}
+			fmt.Printf("  %-32s %10s\n", filepath.Base(f), humanize.Bytes(uint64(size)))
+		}
+
+	case "count":
+		if arg(1) == "codes" {
+			codes, err := dir.CodeCounts()
+			if err != nil {
+				return err
+			}
+			byType := make(map[string]int64)
+			for _, c := range codes {
+				byType[c.BillingCodeType]++
+			}
+			fmt.Printf("%s distinct billing codes\n", humanize.Count(int64(len(codes))))
+			printCounts("By type:", byType)
+			return nil
+		}
+		refs, items, err := dir.CountRecords()
+		if err != nil {
+			return err
+		}
+		switch arg(1) {
+		case "refs":
+			fmt.Printf("%s provider_references entries\n", humanize.Count(refs))
+		case "items":
+			fmt.Printf("%s in_network items\n", humanize.Count(items))
+		case "":
+			fmt.Printf("%s provider_references entries, %s in_network items\n", humanize.Count(refs), humanize.Count(items))
+		default:
+			return fmt.Errorf("count what? refs, items or codes")
+		}
+
+	case "codes":
+		n, err := count(1, 20)
+		if err != nil {
+			return err
+		}
+		codes, err := dir.CodeCounts()
+		if err != nil {
+			return err
+		}
+		for i, c := range codes {
+			if i == n {
+				fmt.Printf("  ... %d more\n", len(codes)-n)
+				break
+			}
+			fmt.Printf("  %-8s %-10s %10s items %12s prices\n", c.BillingCodeType, c.BillingCode, humanize.Count(c.Items), humanize.Count(c.Prices))
+		}
+
+	case "grep":
+		npiNum, err := strconv.ParseInt(arg(1), 10, 64)
+		if err != nil {
+			return fmt.Errorf("usage: grep <npi>")
+		}
+		const maxItems = 20
+		m, err := dir.FindNPI(npiNum, maxItems)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d provider group(s):\n", len(m.Groups))
+		for _, r := range m.Groups {
+			var ref mrf.ProviderReference
+			json.Unmarshal(r.Raw, &ref)
+			var tins []string
+			for _, pg := range ref.ProviderGroups {
+				tins = append(tins, pg.TIN.Value)
+			}
+			fmt.Printf("  %-32s provider_group_id %s, TIN %s\n", r.Location(),
+				strconv.FormatFloat(ref.ProviderGroupID, 'f', -1, 64), strings.Join(tins, ", "))
+		}
+		more := ""
+		if m.MoreItems {
+			more = fmt.Sprintf(" (first %d)", maxItems)
+		}
+		fmt.Printf("%d in_network item(s)%s:\n", len(m.Items), more)
+		for _, r := range m.Items {
+			var item mrf.InNetworkItem
+			json.Unmarshal(r.Raw, &item)
+			fmt.Printf("  %-32s %s %s %s\n", r.Location(), item.BillingCodeType, item.BillingCode, item.Name)
+		}
+
+	case "show":
+		var recs []mrf.Record
+		switch arg(1) {
+		case "code":
+			if arg(2) == "" {
+				return fmt.Errorf("usage: show code <code> [n]")
+			}
+			n, err := count(3, 1)
+			if err != nil {
+				return err
+			}
+			if recs, err = dir.FindCode(arg(2), n); err != nil {
+				return err
+			}
+			if len(recs) == 0 {
+				return fmt.Errorf("no in_network item for billing code %s", arg(2))
+			}
+		case "group":
+			id, err := strconv.ParseFloat(arg(2), 64)
+			if err != nil {
+				return fmt.Errorf("usage: show group <provider_group_id>")
+			}
+			r, err := dir.FindGroup(id)
+			if err != nil {
+				return err
+			}
+			if r == nil {
+				return fmt.Errorf("no provider_references entry with provider_group_id %s", arg(2))
+			}
+			recs = []mrf.Record{*r}
+		default:
+			file, lineStr, ok := strings.Cut(arg(1), ":")
+			line, err := strconv.Atoi(lineStr)
+			if !ok || err != nil || line <= 0 {
+				return fmt.Errorf("usage: show <file>:<line>, show code <code> [n] or show group <id>")
+			}
+			r, err := dir.ReadRecord(file, line)
+			if err != nil {
+				return err
+			}
+			recs = []mrf.Record{*r}
+		}
+		for _, r := range recs {
+			var buf bytes.Buffer
+			if err := json.Indent(&buf, r.Raw, "", "  "); err != nil {
+				return fmt.Errorf("%s: %w", r.Location(), err)
 			}
+			fmt.Printf("# %s\n%s\n", r.Location(), buf.String())
+		}
 
-			// --- Gather URLs ---
-			var urls []string
+	case "stats":
+		start := time.Now()
+		st, err := dir.Stats()
+		if err != nil {
+			return err
+		}
+		printFileStats(st, time.Since(start))
 
-			// Source 1: TOC resolution
-			if tocURL != "" {
-				fmt.Fprintf(os.Stderr, "Resolving TOC for plan %s...\n", planID)
-				tocResult, tocErr := toc.FetchAndResolve(ctx, tocURL, planID, func(d, t int64) {
-					// optional progress
-				})
-				if tocErr != nil {
-					return fmt.Errorf("TOC resolution failed: %w", tocErr)
-				}
-				if len(tocResult.URLs) == 0 {
-					return fmt.Errorf("TOC resolution found 0 in-network URLs for plan %s", planID)
-				}
-				fmt.Fprintf(os.Stderr, "TOC: %d MRF URLs from %d matching structures (entity: %s)\n",
-					len(tocResult.URLs), tocResult.MatchedStructures, tocResult.ReportingEntityName)
-				urls = tocResult.URLs
-			}
-
-			// Source 2: explicit URLs (combinable with TOC)
-			if len(urlsList) > 0 {
-				urls = append(urls, urlsList...)
-			} else if urlsFile != "" {
-				fileURLs, readErr := readURLs(urlsFile)
-				if readErr != nil {
-					return fmt.Errorf("reading URLs: %w", readErr)
-				}
-				urls = append(urls, fileURLs...)
-			}
+	default:
+		return fmt.Errorf("unknown command %q (try \"help\")", words[0])
+	}
+	return nil
+}
 
-			if len(urls) == 0 {
-				return fmt.Errorf("no URLs; use --toc-url + --plan-id, --urls-file, or --url")
-			}
-			logURLInfo(ctx, urls)
+func newTOCCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "toc",
+		Short: "Work with CMS Table of Contents (TOC) index files",
+	}
+	cmd.AddCommand(newTOCResolveCmd())
+	cmd.AddCommand(newTOCListPlansCmd())
+	return cmd
+}
 
-			// --- Cloud mode: distribute to Modal functions ---
-			if cloudMode {
-				npiStrs := make([]string, len(npis))
-				for i, n := range npis {
-					npiStrs[i] = fmt.Sprintf("%d", n)
-				}
+func newTOCResolveCmd() *cobra.Command {
+	var (
+		planID     string
+		outputFile string
+	)
 
-				return modalorch.RunSearch(ctx, modalorch.Config{
-					NPI:             strings.Join(npiStrs, ","),
-					URLsFile:        urlsFile,
-					URLs:            urlsList,
-					OutputFile:      outputFile,
-					Shards:          shards,
-					WorkersPerShard: cloudWorkers,
-				})
-			}
+	cmd := &cobra.Command{
+		Use:   "resolve <toc-url>",
+		Short: "Resolve a plan's in-network MRF URLs from a TOC file",
+		Long: `Download a Table of Contents file, find the reporting structures covering
+--plan-id, and write their in-network file URLs as a URL list (the --urls-file
+format). With no --output the list goes to stdout, so it can be piped into
+search:
+
+  npi-rates toc resolve <toc-url> --plan-id 12345 | npi-rates search --npi 1770671182 --from-toc`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			tocURL := args[0]
 
-			// Build NPI lookup set
-			npiSet := make(map[int64]struct{}, len(npis))
-			for _, n := range npis {
-				npiSet[n] = struct{}{}
-			}
+			ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+			defer cancel()
 
-			// Set up temp dir
-			if tmpDir == "" {
-				tmpDir = os.TempDir()
+			// Progress goes to stderr; stdout may be the URL list.
+			mgr := &progress.NoopManager{}
+			phase := mgr.StartPhase(fmt.Sprintf("Resolving TOC for plan %s", planID))
+			result, err := toc.FetchAndResolve(ctx, tocURL, planID, nil)
+			if err == nil && len(result.URLs) == 0 {
+				err = fmt.Errorf("found 0 in-network URLs for plan %s", planID)
 			}
-			if err := os.MkdirAll(tmpDir, 0o755); err != nil {
-				return fmt.Errorf("creating temp dir: %w", err)
+			if err != nil {
+				phase.Done(err)
+				return fmt.Errorf("TOC resolution failed: %w", err)
 			}
+			phase.Update(fmt.Sprintf("%d MRF URLs from %d matching structures, entity: %s",
+				len(result.URLs), result.MatchedStructures, result.ReportingEntityName))
+			phase.Done(nil)
 
-			// Check available disk space and warn if low (skip for streaming mode — no disk used)
-			var avail uint64
-			if !streamMode {
-				avail = availableDiskSpace(tmpDir)
-				if avail > 0 && avail < 50*1024*1024*1024 { // < 50 GB
-					fmt.Fprintf(os.Stderr, "WARNING: Only %s available in temp dir %s\n", humanBytesCLI(avail), tmpDir)
-					fmt.Fprintf(os.Stderr, "  MRF files decompress to 5-40 GB each. Use --tmp-dir to point to a larger volume.\n")
-					fmt.Fprintf(os.Stderr, "  Consider --workers 1 to reduce concurrent disk usage.\n\n")
+			var w io.Writer = os.Stdout
+			if outputFile != "" && outputFile != "-" {
+				f, err := os.Create(outputFile)
+				if err != nil {
+					return fmt.Errorf("creating output file: %w", err)
 				}
+				defer f.Close()
+				w = f
 			}
 
-			// Set up progress
-			var mgr progress.Manager
-			if logProgress {
-				mgr = progress.NewLogManager()
-			} else if noProgress {
-				mgr = &progress.NoopManager{}
-			} else {
-				mgr = progress.NewMPBManager()
+			bw := bufio.NewWriter(w)
+			fmt.Fprintf(bw, "# TOC: %s\n", tocURL)
+			fmt.Fprintf(bw, "# Plan: %s (%s)\n", planID, result.ReportingEntityName)
+			for _, u := range result.URLs {
+				fmt.Fprintln(bw, u)
 			}
-
-			// Log environment info
-			fmt.Fprintf(os.Stderr, "Parser: %s\n", mrf.ParserName())
-			if streamMode {
-				fmt.Fprintf(os.Stderr, "Mode: streaming (no disk)\n")
-			} else {
-				fmt.Fprintf(os.Stderr, "Temp dir: %s (%s available)\n", tmpDir, humanBytesCLI(avail))
+			if err := bw.Flush(); err != nil {
+				return fmt.Errorf("writing URLs: %w", err)
+			}
+			if w != os.Stdout {
+				logx.Infof("Wrote %d URLs to %s\n", len(result.URLs), outputFile)
 			}
-			fmt.Fprintf(os.Stderr, "Workers: %d\n\n", workers)
+			return nil
+		},
+	}
 
-			// Run the worker pool
-			startTime := time.Now()
+	cmd.Flags().StringVar(&planID, "plan-id", "", "Healthcare plan identifier (HIOS ID or EIN) to resolve")
+	cmd.Flags().StringVarP(&outputFile, "output", "o", "", "Write the URL list to this file (default: stdout)")
+	cmd.MarkFlagRequired("plan-id")
 
-			pool := &worker.Pool{
-				Workers:    workers,
-				TargetNPIs: npiSet,
-				TmpDir:     tmpDir,
-				Progress:   mgr,
-				NoFIFO:     noFIFO,
-				Stream:     streamMode,
-			}
+	return cmd
+}
 
-			results := pool.Run(ctx, urls)
-			mgr.Wait()
+func newTOCListPlansCmd() *cobra.Command {
+	var name string
 
-			// Collect results
-			var allRates []mrf.RateResult
-			matchedFiles := 0
-			for _, r := range results {
-				if r.Err != nil {
-					return fmt.Errorf("fatal: error processing %s: %w", worker.FileNameFromURL(r.URL), r.Err)
-				}
-				if len(r.Results) > 0 {
-					matchedFiles++
-					allRates = append(allRates, r.Results...)
-				}
-			}
+	cmd := &cobra.Command{
+		Use:   "list-plans <toc-url>",
+		Short: "List the plans in a TOC file, to find the plan ID to resolve",
+		Long: `Download a Table of Contents file and print each distinct plan in it (plan
+ID type, plan ID and name) with the number of in-network files listed for it,
+sorted by name. --name keeps the plans whose name contains the text, ignoring
+case. The plan ID is what "toc resolve --plan-id" takes:
+
+  npi-rates toc list-plans <toc-url> --name "choice plus"
+  npi-rates toc resolve <toc-url> --plan-id 12345 | npi-rates search --npi 1770671182 --from-toc`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			tocURL := args[0]
 
-			duration := time.Since(startTime)
+			ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+			defer cancel()
 
-			// Write output
-			params := mrf.SearchParams{
-				NPIs:            npis,
-				SearchedFiles:   len(urls),
-				MatchedFiles:    matchedFiles,
-				DurationSeconds: duration.Seconds(),
+			mgr := &progress.NoopManager{}
+			phase := mgr.StartPhase("Listing TOC plans")
+			result, err := toc.FetchAndListPlans(ctx, tocURL, nil)
+			if err != nil {
+				phase.Done(err)
+				return fmt.Errorf("listing plans failed: %w", err)
 			}
-
-			if err := output.WriteResults(outputFile, params, allRates); err != nil {
-				return fmt.Errorf("writing output: %w", err)
+			phase.Update(fmt.Sprintf("%d plans in %d structures, entity: %s",
+				len(result.Plans), result.Structures, result.ReportingEntityName))
+			phase.Done(nil)
+
+			want := strings.ToLower(name)
+			bw := bufio.NewWriter(os.Stdout)
+			fmt.Fprintf(bw, "%-12s %-20s %6s  %s\n", "ID_TYPE", "PLAN_ID", "FILES", "PLAN_NAME")
+			shown := 0
+			for _, p := range result.Plans {
+				if want != "" && !strings.Contains(strings.ToLower(p.PlanName), want) {
+					continue
+				}
+				fmt.Fprintf(bw, "%-12s %-20s %6d  %s\n", p.PlanIDType, p.PlanID, p.Files, p.PlanName)
+				shown++
+			}
+			if err := bw.Flush(); err != nil {
+				return err
+			}
+			if want != "" && shown == 0 {
+				return fmt.Errorf("no plan name contains %q (%d plans in the TOC)", name, len(result.Plans))
 			}
-
-			fmt.Fprintf(os.Stderr, "\nSearch complete: %d files searched, %d matched, %d rates found in %.1fs\n",
-				len(urls), matchedFiles, len(allRates), duration.Seconds())
-			fmt.Fprintf(os.Stderr, "Results written to %s\n", outputFile)
-
 			return nil
 		},
 	}
 
-	// Standard flags
-	cmd.Flags().StringVar(&urlsFile, "urls-file", "", "File containing MRF URLs (one per line)")
-	cmd.Flags().StringSliceVar(&urlsList, "url", nil, "MRF URL(s) to search (can be repeated or comma-separated)")
-	cmd.Flags().StringVar(&npiList, "npi", "", "Comma-separated NPI numbers to search for")
-	cmd.Flags().StringVar(&providerName, "provider-name", "", "Search by provider name (\"First Last\")")
-	cmd.Flags().StringVar(&state, "state", "", "State filter for provider name search (2-letter code, e.g. NY)")
-	cmd.Flags().StringVarP(&outputFile, "output", "o", "", "Output file path (default: results_<timestamp>.json, use '-' for stdout)")
-	cmd.Flags().IntVar(&workers, "workers", 3, "Number of concurrent file workers")
-	cmd.Flags().StringVar(&tmpDir, "tmp-dir", "", "Temp directory for intermediate files (default: system temp)")
-	cmd.Flags().BoolVar(&noProgress, "no-progress", false, "Disable progress bars")
-	cmd.Flags().BoolVar(&logProgress, "log-progress", false, "Use line-based progress logging (for non-TTY environments)")
-	cmd.Flags().BoolVar(&noFIFO, "no-fifo", false, "Use file-based pipeline instead of FIFO streaming")
-	cmd.Flags().BoolVar(&streamMode, "stream", true, "Stream directly from download to parsing (no disk, constant memory)")
-	cmd.Flags().BoolVar(&noSimd, "no-simd", false, "Disable simdjson and use stdlib encoding/json")
-
-	// TOC resolution flags
-	cmd.Flags().StringVar(&planID, "plan-id", "", "Healthcare plan identifier (HIOS ID or EIN) for TOC lookup")
-	cmd.Flags().StringVar(&tocURL, "toc-url", "", "URL of CMS Table of Contents file (.json or .json.gz)")
-
-	// Cloud mode flags (Modal orchestration)
-	cmd.Flags().BoolVar(&cloudMode, "cloud", false, "Run in cloud mode (distribute to Modal functions)")
-	cmd.Flags().IntVar(&shards, "shards", 100, "Number of URL shards (cloud mode)")
-	cmd.Flags().IntVar(&cloudWorkers, "cloud-workers", 1, "Workers per shard (cloud mode)")
+	cmd.Flags().StringVar(&name, "name", "", "Only list plans whose name contains this text (case-insensitive)")
 
 	return cmd
 }
 
-func parseNPIs(s string) ([]int64, error) {
-	parts := strings.Split(s, ",")
-	var npis []int64
-	for _, p := range parts {
-		p = strings.TrimSpace(p)
-		if p == "" {
-			continue
-		}
-		n, err := strconv.ParseInt(p, 10, 64)
-		if err != nil {
-			return nil, fmt.Errorf("invalid NPI %q: %w", p, err)
-		}
-		if n < 1000000000 || n > 9999999999 {
-			return nil, fmt.Errorf("NPI %d is not a valid 10-digit NPI", n)
-		}
-		npis = append(npis, n)
-	}
-	return npis, nil
-}
-
-func newDownloadCmd() *cobra.Command {
+func newDiscoverCmd() *cobra.Command {
 	var (
-		outputPath string
-		tmpDir     string
+		payer      string
+		month      string
+		endpoint   string
+		outputFile string
 	)
 
 	cmd := &cobra.Command{
-		Use:   "download <url>",
-		Short: "Download and decompress a single MRF file",
-		Args:  cobra.ExactArgs(1),
+		Use:   "discover",
+		Short: "Find a payer's current TOC file URLs",
+		Long: fmt.Sprintf(`Look up a major payer's Table of Contents files for a month at the page or
+API where the payer publishes them, and write their URLs one per line. If the
+month isn't published yet, the latest earlier month is used. Each TOC then
+gives a plan's in-network files with "toc resolve":
+
+  npi-rates discover --payer anthem -o tocs.txt
+  npi-rates toc resolve "$(grep -v '^#' tocs.txt | head -1)" --plan-id 12345 | npi-rates search --npi 1770671182 --from-toc
+
+Payers: %s. Payers move their files from time to time; --endpoint
+points discovery at the new location.`, strings.Join(toc.Payers(), ", ")),
+		Args: cobra.NoArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			url := args[0]
-			filename := worker.FileNameFromURL(url)
-
-			ctx, cancel := context.WithCancel(context.Background())
-			defer cancel()
-			sigCh := make(chan os.Signal, 2)
-			signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
-			go func() {
-				<-sigCh
-				cancel()
-				<-sigCh
-				os.Exit(1)
-			}()
-
-			if tmpDir == "" {
-				tmpDir = "."
+			p, ok := toc.LookupPayer(payer)
+			if !ok {
+				return fmt.Errorf("unknown --payer %q (known: %s)", payer, strings.Join(toc.Payers(), ", "))
+			}
+			when := time.Now()
+			if month != "" {
+				var err error
+				if when, err = time.Parse("2006-01", month); err != nil {
+					return fmt.Errorf("--month must be YYYY-MM, got %q", month)
+				}
+			}
+			if endpoint == "" {
+				endpoint = p.Endpoint
 			}
 
-			fmt.Fprintf(os.Stderr, "Downloading %s ...\n", filename)
-			startTime := time.Now()
+			ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+			defer cancel()
 
-			result, err := worker.DownloadAndDecompress(ctx, url, tmpDir, false, func(downloaded, total int64) {
-				// periodic progress is printed below
-			})
+			mgr := &progress.NoopManager{}
+			phase := mgr.StartPhase(fmt.Sprintf("Discovering %s TOC files", p.Name))
+			result, err := toc.DiscoverAt(ctx, p, endpoint, when)
 			if err != nil {
-				return fmt.Errorf("download failed: %w", err)
+				phase.Done(err)
+				return fmt.Errorf("discovery failed: %w", err)
 			}
-
-			// Move temp file to the final output path
-			dest := outputPath
-			if dest == "" {
-				// Strip .gz suffix for the default name
-				dest = strings.TrimSuffix(filename, ".gz")
+			detail := fmt.Sprintf("%d TOC URL(s)", len(result.URLs))
+			if result.Month != "" {
+				detail += " for " + result.Month
 			}
-			if err := os.Rename(result.FilePath, dest); err != nil {
-				// Rename failed (cross-device), fall back to keeping temp file
-				dest = result.FilePath
+			phase.Update(detail)
+			phase.Done(nil)
+			if result.Month != "" && result.Month != when.Format("2006-01") {
+				logx.Infof("%s hasn't published %s yet; using %s\n", p.Name, when.Format("2006-01"), result.Month)
 			}
 
-			elapsed := time.Since(startTime).Truncate(time.Second)
-			info, _ := os.Stat(dest)
-			decompressedSize := int64(0)
-			if info != nil {
-				decompressedSize = info.Size()
+			var w io.Writer = os.Stdout
+			if outputFile != "" && outputFile != "-" {
+				f, err := os.Create(outputFile)
+				if err != nil {
+					return fmt.Errorf("creating output file: %w", err)
+				}
+				defer f.Close()
+				w = f
 			}
 
-			fmt.Fprintf(os.Stderr, "Downloaded and decompressed in %s\n", elapsed)
-			if result.TotalBytes > 0 {
-				fmt.Fprintf(os.Stderr, "  Compressed:   %s\n", humanBytesCLI(uint64(result.TotalBytes)))
+			bw := bufio.NewWriter(w)
+			fmt.Fprintf(bw, "# Payer: %s (%s)\n", p.Name, endpoint)
+			if result.Month != "" {
+				fmt.Fprintf(bw, "# Month: %s\n", result.Month)
 			}
-			if decompressedSize > 0 {
-				fmt.Fprintf(os.Stderr, "  Decompressed: %s\n", humanBytesCLI(uint64(decompressedSize)))
+			for _, u := range result.URLs {
+				fmt.Fprintln(bw, u)
+			}
+			if err := bw.Flush(); err != nil {
+				return fmt.Errorf("writing URLs: %w", err)
+			}
+			if w != os.Stdout {
+				logx.Infof("Wrote %d TOC URL(s) to %s\n", len(result.URLs), outputFile)
 			}
-			fmt.Fprintf(os.Stderr, "  Output: %s\n", dest)
-
 			return nil
 		},
 	}
 
-	cmd.Flags().StringVarP(&outputPath, "output", "o", "", "Output file path (default: filename without .gz)")
-	cmd.Flags().StringVar(&tmpDir, "tmp-dir", "", "Temp directory for intermediate files (default: current dir)")
+	cmd.Flags().StringVar(&payer, "payer", "", "Payer to discover: "+strings.Join(toc.Payers(), ", "))
+	cmd.Flags().StringVar(&month, "month", "", "Month of the TOC files, YYYY-MM (default: the current month)")
+	cmd.Flags().StringVar(&endpoint, "endpoint", "", "Page or API listing the payer's files, replacing the built-in one (for a payer whose files are found by URL template, the template, with {date} for YYYY-MM-01)")
+	cmd.Flags().StringVarP(&outputFile, "output", "o", "", "Write the URL list to this file (default: stdout)")
+	cmd.MarkFlagRequired("payer")
 
 	return cmd
 }
 
-func newSplitCmd() *cobra.Command {
-	var outputDir string
+func newServeCmd() *cobra.Command {
+	var (
+		host       string
+		port       int
+		workers    int
+		tmpDir     string
+		resultsDir string
+		noClean    bool
+		minFree    string
+		keepJobs   int
+	)
 
 	cmd := &cobra.Command{
-		Use:   "split <file>",
-		Short: "Split a decompressed MRF JSON file into NDJSON chunks",
-		Args:  cobra.ExactArgs(1),
+		Use:   "serve",
+		Short: "Serve a REST API for submitting searches and fetching results",
+		Long: `Run an HTTP server that accepts searches, runs them one at a time, and
+keeps their results on disk:
+
+  POST   /searches               {"npis": [...], "urls": [...]}; returns the job
+  GET    /searches               list jobs
+  GET    /searches/{id}          job status and per-file progress
+  GET    /searches/{id}/results  results as NDJSON, once the job is done
+  DELETE /searches/{id}          cancel a job
+  GET    /healthz                200 while the server runs (liveness probe)
+  GET    /readyz                 200 when it can take jobs, else 503 and why (readiness probe)
+  GET    /status                 queue depth, active jobs, free disk and the last job error
+
+The server has no authentication; bind it to a trusted network.`,
+		Args: cobra.NoArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			inputPath := args[0]
+			ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+			defer cancel()
 
-			// Verify the input file exists
-			info, err := os.Stat(inputPath)
-			if err != nil {
-				return fmt.Errorf("cannot read input file: %w", err)
+			if tmpDir == "" {
+				tmpDir = os.TempDir()
 			}
-
-			if outputDir == "" {
-				outputDir = strings.TrimSuffix(inputPath, ".json") + "_split"
+			if !noClean {
+				cleanTmpDirs([]string{tmpDir}, defaultCleanAge)
 			}
-			if err := os.MkdirAll(outputDir, 0o755); err != nil {
-				return fmt.Errorf("creating output dir: %w", err)
+			srv := server.New(workers, tmpDir, resultsDir)
+			if minFree != "" {
+				n, err := humanize.ParseBytes(minFree)
+				if err != nil {
+					return fmt.Errorf("--min-free: %w", err)
+				}
+				srv.MinFree = uint64(n)
 			}
+			srv.KeepJobs = keepJobs
+			go srv.Run(ctx)
 
-			fmt.Fprintf(os.Stderr, "Splitting %s (%s) ...\n", inputPath, humanBytesCLI(uint64(info.Size())))
-			startTime := time.Now()
-
-			result, err := mrf.SplitFile(inputPath, outputDir)
-			if err != nil {
-				return fmt.Errorf("split failed: %w", err)
+			httpSrv := &http.Server{
+				Addr:              net.JoinHostPort(host, strconv.Itoa(port)),
+				Handler:           srv.Handler(),
+				ReadHeaderTimeout: 10 * time.Second,
 			}
-
-			elapsed := time.Since(startTime).Truncate(time.Second)
-
-			fmt.Fprintf(os.Stderr, "Split complete in %s\n", elapsed)
-			fmt.Fprintf(os.Stderr, "  Output dir: %s\n", outputDir)
-			fmt.Fprintf(os.Stderr, "  provider_references: %d file(s)\n", len(result.ProviderReferenceFiles))
-			fmt.Fprintf(os.Stderr, "  in_network:          %d file(s)\n", len(result.InNetworkFiles))
-
-			return nil
+			errCh := make(chan error, 1)
+			go func() { errCh <- httpSrv.ListenAndServe() }()
+			logx.Infof("Serving on http://%s (results in %s)\n", httpSrv.Addr, resultsDir)
+
+			select {
+			case err := <-errCh:
+				return fmt.Errorf("serving: %w", err)
+			case <-ctx.Done():
+			}
+			logx.Infof("Shutting down...\n")
+			shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer shutdownCancel()
+			err := httpSrv.Shutdown(shutdownCtx)
+			// Let a running job record its cancellation and close its results.
+			select {
+			case <-srv.Done():
+			case <-shutdownCtx.Done():
+				logx.Infof("Running job did not stop in time\n")
+			}
+			return err
 		},
 	}
 
-	cmd.Flags().StringVarP(&outputDir, "output-dir", "o", "", "Output directory for split files (default: <input>_split)")
+	cmd.Flags().StringVar(&host, "host", "localhost", "Address to listen on (use 0.0.0.0 for all interfaces)")
+	cmd.Flags().IntVar(&port, "port", 8080, "Port to listen on")
+	cmd.Flags().IntVar(&workers, "workers", 3, "Files searched at once within a job")
+	cmd.Flags().StringVar(&tmpDir, "tmp-dir", "", "Temp directory for intermediate files (default: system temp)")
+	cmd.Flags().StringVar(&resultsDir, "results-dir", "npi-rates-results", "Directory for job results")
+	cmd.Flags().BoolVar(&noClean, "no-clean", false, "Keep temp files left in --tmp-dir by earlier runs that crashed")
+	cmd.Flags().StringVar(&minFree, "min-free", "10GB", "Free space --tmp-dir and --results-dir each need for /readyz to report ready (0 disables)")
+	cmd.Flags().IntVar(&keepJobs, "keep-jobs", server.DefaultKeepJobs, "Finished jobs kept, with their results; older ones are deleted (0 keeps all)")
 
 	return cmd
 }
 
 // printProviderInfo looks up and displays provider details for each NPI.
 // Returns the list of NPI numbers that were not found in the NPPES registry.
-func printProviderInfo(ctx context.Context, npis []int64) []int64 {
-	lookupCtx, lookupCancel := context.WithTimeout(ctx, 15*time.Second)
+// mgr is waited on once the lookup is done, so an MPBManager passed here
+// can't be reused for the run.
+func printProviderInfo(ctx context.Context, mgr progress.Manager, npis []int64) []int64 {
+	lookupCtx, lookupCancel := context.WithTimeout(ctx, time.Minute)
 	defer lookupCancel()
 
+	phase := mgr.StartPhase("NPPES lookup")
+	phase.Update(fmt.Sprintf("%d NPIs", len(npis)))
 	results, errs := npi.LookupAll(lookupCtx, npis)
+	var failed int
+	var lookupErr error
+	for _, err := range errs {
+		if err != nil {
+			failed++
+			lookupErr = err
+		}
+	}
+	if failed > 0 {
+		phase.Done(fmt.Errorf("%d of %d lookups failed, last: %w", failed, len(npis), lookupErr))
+	} else {
+		phase.Done(nil)
+	}
+	// Let the phase line finish drawing before the details print below it.
+	mgr.Wait()
 
 	var notFound []int64
 	for i, info := range results {
 		if errs[i] != nil {
-			fmt.Fprintf(os.Stderr, "NPI %d: lookup failed (%v)\n", npis[i], errs[i])
+			logx.Infof("NPI %d: lookup failed (%v)\n", npis[i], errs[i])
 			continue
 		}
 		if info == nil {
-			fmt.Fprintf(os.Stderr, "NPI %d: not found in NPPES registry\n", npis[i])
+			logx.Infof("NPI %d: not found in NPPES registry\n", npis[i])
 			notFound = append(notFound, npis[i])
 			continue
 		}
 
 		// Build display line
-		fmt.Fprintf(os.Stderr, "NPI %d: %s", info.NPI, info.Name)
+		logx.Infof("NPI %d: %s", info.NPI, info.Name)
 		if info.Credential != "" {
-			fmt.Fprintf(os.Stderr, ", %s", info.Credential)
+			logx.Infof(", %s", info.Credential)
 		}
-		fmt.Fprintln(os.Stderr)
+		logx.Infof("\n")
 
 		if info.PrimaryTaxonomy != "" {
-			fmt.Fprintf(os.Stderr, "  Specialty: %s\n", info.PrimaryTaxonomy)
+			logx.Infof("  Specialty: %s\n", info.PrimaryTaxonomy)
 		}
 		if info.PracticeAddress != "" {
 			line := "  Location:  " + info.PracticeAddress
 			if info.PracticePhone != "" {
 				line += "  |  " + info.PracticePhone
 			}
-			fmt.Fprintln(os.Stderr, line)
+			logx.Infof("%s\n", line)
 		}
 		if info.Status != "A" {
-			fmt.Fprintf(os.Stderr, "  WARNING:   NPI status is %q (not active)\n", info.Status)
+			logx.Infof("  WARNING:   NPI status is %q (not active)\n", info.Status)
 		}
 	}
-	fmt.Fprintln(os.Stderr)
+	logx.Infof("\n")
 	return notFound
 }
 
+// transferSummary reports the bytes downloaded from each host this run,
+// priced at costPerGB if it is positive.
+func transferSummary(costPerGB float64) *mrf.TransferSummary {
+	t := &mrf.TransferSummary{Hosts: worker.TransferredBytes()}
+	for _, n := range t.Hosts {
+		t.TotalBytes += n
+	}
+	if costPerGB > 0 {
+		t.CostPerGB = costPerGB
+		t.EstimatedCost = math.Round(float64(t.TotalBytes)/1e9*costPerGB*100) / 100
+	}
+	return t
+}
+
+// parseStopAt parses a --stop-at value: a local HH:MM, taken as its next
+// occurrence after now, or an RFC 3339 timestamp.
+func parseStopAt(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	hm, err := time.Parse("15:04", s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid --stop-at %q: want HH:MM or an RFC 3339 time", s)
+	}
+	t := time.Date(now.Year(), now.Month(), now.Day(), hm.Hour(), hm.Minute(), 0, 0, now.Location())
+	if !t.After(now) {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// openNPPESFile opens the index for an NPPES dissemination file, building it
+// on first use (a few minutes for the full file).
+func openNPPESFile(path string) (*npi.Index, error) {
+	if !strings.HasSuffix(path, ".idx") {
+		if _, err := os.Stat(path + ".idx"); err != nil {
+			logx.Infof("Indexing NPPES file %s (first use only)...\n", path)
+		}
+	}
+	idx, err := npi.OpenBulkFile(path, func(rows int) {
+		logx.Infof("  %s providers indexed\n", humanize.Count(int64(rows)))
+	})
+	if err != nil {
+		return nil, fmt.Errorf("opening NPPES file: %w", err)
+	}
+	return idx, nil
+}
+
 // confirmContinue prompts the user to continue despite not-found NPIs.
 // Returns true if the user wants to continue, false to abort.
 func confirmContinue(notFound []int64) bool {
@@ -552,7 +2025,53 @@ func searchAndSelectProvider(name, state string) (*npi.ProviderInfo, error) {
 			fmt.Fprintf(os.Stderr, "      Location:  %s\n", p.PracticeAddress)
 		}
 	}
+	return promptProvider(providers)
+}
+
+// searchAndSelectOrganization queries the NPPES registry for organizations
+// (NPI-2) by name and prompts the user to select one from the results.
+func searchAndSelectOrganization(name, state string) (*npi.ProviderInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	fmt.Fprintf(os.Stderr, "Searching NPPES registry for organizations named \"%s\"", name)
+	if state != "" {
+		fmt.Fprintf(os.Stderr, " in %s", strings.ToUpper(state))
+	}
+	fmt.Fprintln(os.Stderr, "...")
+
+	orgs, err := npi.SearchOrganization(ctx, name, strings.ToUpper(state))
+	if err != nil {
+		return nil, fmt.Errorf("searching NPI registry: %w", err)
+	}
+	if len(orgs) == 0 {
+		return nil, fmt.Errorf("no organizations found matching \"%s\"", name)
+	}
+
+	fmt.Fprintf(os.Stderr, "\nFound %d organization(s):\n\n", len(orgs))
+	for i, p := range orgs {
+		fmt.Fprintf(os.Stderr, "  [%d] %s (NPI %d)", i+1, p.Name, p.NPI)
+		if p.Status == "D" {
+			fmt.Fprint(os.Stderr, ", deactivated")
+		}
+		fmt.Fprintln(os.Stderr)
+		if p.PrimaryTaxonomy != "" {
+			taxonomy := p.PrimaryTaxonomy
+			if p.TaxonomyCode != "" && p.TaxonomyCode != taxonomy {
+				taxonomy += " (" + p.TaxonomyCode + ")"
+			}
+			fmt.Fprintf(os.Stderr, "      Taxonomy: %s\n", taxonomy)
+		}
+		if p.PracticeAddress != "" {
+			fmt.Fprintf(os.Stderr, "      Location: %s\n", p.PracticeAddress)
+		}
+	}
+	return promptProvider(orgs)
+}
 
+// promptProvider picks one of the listed providers: the only one, or the
+// one the user selects by number.
+func promptProvider(providers []*npi.ProviderInfo) (*npi.ProviderInfo, error) {
 	// Single result — auto-select
 	if len(providers) == 1 {
 		fmt.Fprintf(os.Stderr, "\nAuto-selected the only match: NPI %d\n\n", providers[0].NPI)
@@ -576,24 +2095,87 @@ func searchAndSelectProvider(name, state string) (*npi.ProviderInfo, error) {
 	return selected, nil
 }
 
-func readURLs(path string) ([]string, error) {
+// orAny joins a filter list for display, or returns "any" if it is empty.
+func orAny(vals []string) string {
+	if len(vals) == 0 {
+		return "any"
+	}
+	return strings.Join(vals, ",")
+}
+
+// bandwidthShare returns the throughput each of workers concurrent
+// downloads is held to when all run at once, or 0 if uncapped.
+func bandwidthShare(total, perWorker int64, workers int) int64 {
+	share := perWorker
+	if total > 0 && workers > 0 {
+		if even := total / int64(workers); share <= 0 || even < share {
+			share = even
+		}
+	}
+	return share
+}
+
+// describeBandwidth describes the bandwidth caps for the run header.
+func describeBandwidth(total, perWorker int64) string {
+	var parts []string
+	if total > 0 {
+		parts = append(parts, humanize.Rate(float64(total))+" total")
+	}
+	if perWorker > 0 {
+		parts = append(parts, humanize.Rate(float64(perWorker))+" per worker")
+	}
+	return strings.Join(parts, ", ")
+}
+
+// defaultCleanAge is how long a crashed run's temp files must have been
+// untouched before a new run removes them. It is well past the time a live
+// run's files go without changing, such as while a large file is parsed.
+const defaultCleanAge = 24 * time.Hour
+
+// cleanTmpDirs removes temp files crashed runs left in dirs (see
+// worker.CleanTmpDir).
+func cleanTmpDirs(dirs []string, olderThan time.Duration) {
+	for _, dir := range dirs {
+		if n, freed := worker.CleanTmpDir(dir, olderThan); n > 0 {
+			logx.Infof("Removed %d temp file(s) (%s) left by earlier runs in %s\n", n, humanize.Bytes(uint64(freed)), dir)
+		}
+	}
+}
+
+// readURLs reads a URL list file, or stdin when path is "-".
+func readURLs(path string) ([]string, map[string][]string, error) {
+	if path == "-" {
+		return readURLsFrom(os.Stdin)
+	}
 	f, err := os.Open(path)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	defer f.Close()
+	return readURLsFrom(f)
+}
 
+// readURLsFrom parses a URL list: one URL per line, blank lines and
+// '#' comments ignored, further whitespace-separated URLs on a line are
+// mirrors of the first.
+func readURLsFrom(r io.Reader) ([]string, map[string][]string, error) {
 	var urls []string
-	scanner := bufio.NewScanner(f)
+	mirrors := make(map[string][]string)
+	scanner := bufio.NewScanner(r)
 	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024) // URLs can be long (signed URLs)
 	for scanner.Scan() {
 		line := strings.TrimSpace(scanner.Text())
 		if line == "" || strings.HasPrefix(line, "#") {
 			continue
 		}
-		urls = append(urls, line)
+		// Whitespace-separated URLs after the first are mirrors of it.
+		fields := strings.Fields(line)
+		urls = append(urls, fields[0])
+		if len(fields) > 1 {
+			mirrors[fields[0]] = append(mirrors[fields[0]], fields[1:]...)
+		}
 	}
-	return urls, scanner.Err()
+	return urls, mirrors, scanner.Err()
 }
 
 // availableDiskSpace returns the available bytes on the filesystem containing path.
@@ -606,13 +2188,16 @@ func availableDiskSpace(path string) uint64 {
 	return stat.Bavail * uint64(stat.Bsize)
 }
 
-// logURLInfo analyzes the URLs and logs CDN/vendor, region, and file size distribution.
-func logURLInfo(ctx context.Context, urls []string) {
+// logURLInfo analyzes the URLs and logs CDN/vendor, region, and file size
+// distribution. When no URL's host names its region, the first URL's is
+// looked up with locate. It returns the compressed sizes, 0 where unknown,
+// and the location locate found.
+func logURLInfo(ctx context.Context, urls []string, locate func(rawURL string) string) ([]int64, string) {
 	if len(urls) == 0 {
-		return
+		return nil, ""
 	}
 
-	fmt.Fprintf(os.Stderr, "Files: %d\n", len(urls))
+	logx.Infof("Files: %d\n", len(urls))
 
 	// Detect CDN/vendor and region from URL hostnames
 	vendors := map[string]int{}
@@ -636,12 +2221,13 @@ func logURLInfo(ctx context.Context, urls []string) {
 			}
 		}
 		sort.Strings(parts)
-		fmt.Fprintf(os.Stderr, "CDN: %s\n", strings.Join(parts, ", "))
+		logx.Infof("CDN: %s\n", strings.Join(parts, ", "))
 	}
 	// If no region detected from URLs, try IP-based geolocation on first URL's host
+	var location string
 	if len(regions) == 0 && len(urls) > 0 {
-		if r := detectRegionFromIP(ctx, urls[0]); r != "" {
-			regions[r] = len(urls)
+		if location = locate(urls[0]); location != "" {
+			regions[location] = len(urls)
 		}
 	}
 	if len(regions) > 0 {
@@ -654,7 +2240,7 @@ func logURLInfo(ctx context.Context, urls []string) {
 			}
 		}
 		sort.Strings(parts)
-		fmt.Fprintf(os.Stderr, "Region: %s\n", strings.Join(parts, ", "))
+		logx.Infof("Region: %s\n", strings.Join(parts, ", "))
 	}
 
 	// Fetch file sizes via HEAD requests (concurrent, with timeout)
@@ -673,14 +2259,15 @@ func logURLInfo(ctx context.Context, urls []string) {
 		}
 		min, max := known[0], known[len(known)-1]
 		avg := total / int64(len(known))
-		fmt.Fprintf(os.Stderr, "Size (compressed): %s total, %s avg, %s min, %s max",
-			humanBytesCLI(uint64(total)), humanBytesCLI(uint64(avg)),
-			humanBytesCLI(uint64(min)), humanBytesCLI(uint64(max)))
+		logx.Infof("Size (compressed): %s total, %s avg, %s min, %s max",
+			humanize.Bytes(uint64(total)), humanize.Bytes(uint64(avg)),
+			humanize.Bytes(uint64(min)), humanize.Bytes(uint64(max)))
 		if len(known) < len(urls) {
-			fmt.Fprintf(os.Stderr, " (%d/%d responded)", len(known), len(urls))
+			logx.Infof(" (%d/%d responded)", len(known), len(urls))
 		}
-		fmt.Fprintln(os.Stderr)
+		logx.Infof("\n")
 	}
+	return sizes, location
 }
 
 // detectCDN identifies the CDN vendor and region from a URL.
@@ -756,7 +2343,7 @@ func detectRegionFromIP(ctx context.Context, rawURL string) string {
 	if err != nil {
 		return ""
 	}
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := httpx.Short.Do(req)
 	if err != nil {
 		return ""
 	}
@@ -799,7 +2386,8 @@ func detectRegionFromIP(ctx context.Context, rawURL string) string {
 	return location
 }
 
-// fetchFileSizes does concurrent HEAD requests to get Content-Length for each URL.
+// fetchFileSizes does concurrent HEAD requests to get Content-Length for each URL
+// (object store lookups for s3:// and gs:// URLs).
 func fetchFileSizes(ctx context.Context, urls []string) []int64 {
 	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
 	defer cancel()
@@ -808,8 +2396,6 @@ func fetchFileSizes(ctx context.Context, urls []string) []int64 {
 	var wg sync.WaitGroup
 	sem := make(chan struct{}, 10) // limit concurrent HEAD requests
 
-	client := &http.Client{Timeout: 10 * time.Second}
-
 	for i, rawURL := range urls {
 		wg.Add(1)
 		go func(idx int, u string) {
@@ -817,11 +2403,19 @@ func fetchFileSizes(ctx context.Context, urls []string) []int64 {
 			sem <- struct{}{}
 			defer func() { <-sem }()
 
+			u = worker.RewriteURL(u)
+			if objstore.IsObjectURL(u) {
+				if n, err := objstore.Size(ctx, u); err == nil {
+					sizes[idx] = n
+				}
+				return
+			}
 			req, err := http.NewRequestWithContext(ctx, "HEAD", u, nil)
 			if err != nil {
 				return
 			}
-			resp, err := client.Do(req)
+			worker.AddRequestHeaders(req)
+			resp, err := httpx.Short.Do(req)
 			if err != nil {
 				return
 			}
@@ -843,24 +2437,3 @@ func isTerminal() bool {
 	}
 	return fi.Mode()&os.ModeCharDevice != 0
 }
-
-func humanBytesCLI(b uint64) string {
-	const (
-		kb = 1024
-		mb = 1024 * kb
-		gb = 1024 * mb
-		tb = 1024 * gb
-	)
-	switch {
-	case b >= tb:
-		return fmt.Sprintf("%.1f TB", float64(b)/float64(tb))
-	case b >= gb:
-		return fmt.Sprintf("%.1f GB", float64(b)/float64(gb))
-	case b >= mb:
-		return fmt.Sprintf("%.1f MB", float64(b)/float64(mb))
-	case b >= kb:
-		return fmt.Sprintf("%.1f KB", float64(b)/float64(kb))
-	default:
-		return fmt.Sprintf("%d B", b)
-	}
-}
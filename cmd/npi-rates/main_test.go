@@ -0,0 +1,245 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gyeh/npi-rates/internal/mrf"
+	"github.com/gyeh/npi-rates/internal/output"
+)
+
+func TestReadURLsFrom_TOCResolveOutput(t *testing.T) {
+	// The layout "npi-rates toc resolve" writes for --from-toc.
+	input := `# TOC: https://example.com/toc.json
+# Plan: 12345 (Test Health Plan)
+https://example.com/mrf1.json.gz
+https://example.com/mrf2.json.gz
+`
+	urls, mirrors, err := readURLsFrom(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("readURLsFrom: %v", err)
+	}
+	want := []string{"https://example.com/mrf1.json.gz", "https://example.com/mrf2.json.gz"}
+	if !reflect.DeepEqual(urls, want) {
+		t.Errorf("urls = %v, want %v", urls, want)
+	}
+	if len(mirrors) != 0 {
+		t.Errorf("mirrors = %v, want none", mirrors)
+	}
+}
+
+func TestReadURLsFrom_BlankLinesAndMirrors(t *testing.T) {
+	input := "\n  https://a.example/1.json.gz  \n\n" +
+		"https://a.example/2.json.gz https://m1.example/2.json.gz\thttps://m2.example/2.json.gz\n" +
+		"   # indented comment\n" +
+		"https://a.example/2.json.gz https://m3.example/2.json.gz\n"
+	urls, mirrors, err := readURLsFrom(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("readURLsFrom: %v", err)
+	}
+	wantURLs := []string{"https://a.example/1.json.gz", "https://a.example/2.json.gz", "https://a.example/2.json.gz"}
+	if !reflect.DeepEqual(urls, wantURLs) {
+		t.Errorf("urls = %v, want %v", urls, wantURLs)
+	}
+	wantMirrors := map[string][]string{
+		"https://a.example/2.json.gz": {"https://m1.example/2.json.gz", "https://m2.example/2.json.gz", "https://m3.example/2.json.gz"},
+	}
+	if !reflect.DeepEqual(mirrors, wantMirrors) {
+		t.Errorf("mirrors = %v, want %v", mirrors, wantMirrors)
+	}
+}
+
+func TestReadURLsFrom_LongSignedURL(t *testing.T) {
+	long := "https://bucket.s3.amazonaws.com/mrf.json.gz?X-Amz-Signature=" + strings.Repeat("a", 200*1024)
+	urls, _, err := readURLsFrom(strings.NewReader(long + "\n"))
+	if err != nil {
+		t.Fatalf("readURLsFrom: %v", err)
+	}
+	if len(urls) != 1 || urls[0] != long {
+		t.Errorf("long URL not read back intact (got %d URLs)", len(urls))
+	}
+}
+
+func TestReadURLsFrom_LineTooLong(t *testing.T) {
+	if _, _, err := readURLsFrom(strings.NewReader(strings.Repeat("x", 2*1024*1024))); err == nil {
+		t.Error("expected an error for a line over the 1 MiB limit")
+	}
+}
+
+func TestValidRunID(t *testing.T) {
+	for id, want := range map[string]bool{
+		"20260101-120000-abc123": true,
+		"nightly_ny.2026":        true,
+		"_x":                     true,
+		strings.Repeat("a", 40):  true,
+		"":                       false,
+		strings.Repeat("a", 41):  false,
+		".hidden":                false,
+		"-flag":                  false,
+		"a/b":                    false,
+		"a b":                    false,
+		"run:1":                  false,
+		"naïve":                  false,
+	} {
+		if got := validRunID(id); got != want {
+			t.Errorf("validRunID(%q) = %v, want %v", id, got, want)
+		}
+	}
+}
+
+// TestProgressJSONBareFlag verifies that a file given to --progress-json
+// as a separate argument is refused rather than taken as a stray argument
+// while the events go to stderr.
+func TestProgressJSONBareFlag(t *testing.T) {
+	cmd := newSearchCmd()
+	cmd.SetArgs([]string{"--progress-json", "out.json"})
+	cmd.SilenceUsage, cmd.SilenceErrors = true, true
+	if err := cmd.Execute(); err == nil || !strings.Contains(err.Error(), "--progress-json=FILE") {
+		t.Errorf("expected an error asking for --progress-json=FILE, got %v", err)
+	}
+}
+
+// TestCompatFailure verifies that compat reports a failing file as an
+// error, for the exit status, rather than exiting itself.
+func TestCompatFailure(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good.json")
+	bad := filepath.Join(dir, "bad.json")
+	os.WriteFile(good, []byte(`{"reporting_entity_name": "Payer", "version": "1.3.1", "provider_references": [], "in_network": []}`), 0o644)
+	os.WriteFile(bad, []byte("not json"), 0o644)
+
+	cmd := newCompatCmd()
+	cmd.SilenceErrors = true
+	cmd.SetArgs([]string{"--json", good, bad})
+	if err := cmd.Execute(); err == nil || err.Error() != "1 of 2 files failed" {
+		t.Errorf("expected 1 of 2 files failed, got %v", err)
+	}
+}
+
+// TestVerifyFailure verifies that verify reports a failing file as an
+// error rather than exiting itself, and leaves the parser as it found it.
+func TestVerifyFailure(t *testing.T) {
+	// A refused download fails at once, without retries.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+	bad := server.URL + "/in-network.json"
+	defer mrf.SetSimd(mrf.Simd())
+	mrf.SetSimd(false)
+
+	cmd := newVerifyCmd()
+	cmd.SilenceErrors = true
+	cmd.SetArgs([]string{"--json", "--npi", "1316924913", "--compare", "stream+stdlib,stream", bad})
+	if err := cmd.Execute(); err == nil || err.Error() != "1 of 1 file(s) differ or failed" {
+		t.Errorf("expected 1 of 1 file(s) differ or failed, got %v", err)
+	}
+	if mrf.Simd() {
+		t.Error("expected verify to restore the parser")
+	}
+}
+
+func TestVerifyConfig(t *testing.T) {
+	defer mrf.SetSimd(mrf.Simd())
+	mrf.SetSimd(true)
+	parse := func(s string) verifyConfig {
+		c, err := parseVerifyConfig(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	if !parse("split").same(parse("split+simd")) {
+		t.Error("expected split and split+simd to be the same")
+	}
+	if parse("split").same(parse("split+stdlib")) || parse("stream").same(parse("split")) || parse("split").same(parse("file")) {
+		t.Error("expected different modes or parsers to differ")
+	}
+	if !mrf.Simd() {
+		t.Error("expected same to leave the parser alone")
+	}
+	for _, s := range []string{"fast", "split+sonic"} {
+		if _, err := parseVerifyConfig(s); err == nil {
+			t.Errorf("%s: expected an error", s)
+		}
+	}
+}
+
+func TestPickSample(t *testing.T) {
+	srcs := []string{"a", "b", "c", "d", "e", "f"}
+	got := pickSample(srcs, 3, 0)
+	if len(got) != 3 || !reflect.DeepEqual(got, pickSample(srcs, 3, 0)) {
+		t.Errorf("expected a repeatable sample of 3, got %v", got)
+	}
+	for i := 1; i < len(got); i++ {
+		if got[i-1] >= got[i] {
+			t.Errorf("expected the sample in input order, got %v", got)
+		}
+	}
+	if got := pickSample(srcs, 10, 1); !reflect.DeepEqual(got, srcs) {
+		t.Errorf("expected every file when the sample is larger, got %v", got)
+	}
+}
+
+func TestParseNPISets(t *testing.T) {
+	sets, all, err := parseNPISets([]string{"acme=1770671182,1234567893", " zenith = 1234567893, 1111111112"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sets) != 2 || sets[0].Name != "acme" || sets[1].Name != "zenith" {
+		t.Fatalf("unexpected sets %+v", sets)
+	}
+	if want := []int64{1234567893, 1111111112}; !reflect.DeepEqual(sets[1].NPIs, want) {
+		t.Errorf("zenith NPIs = %v, want %v", sets[1].NPIs, want)
+	}
+	if want := []int64{1770671182, 1234567893, 1111111112}; !reflect.DeepEqual(all, want) {
+		t.Errorf("union = %v, want %v", all, want)
+	}
+
+	for _, tt := range []struct {
+		specs []string
+		err   string
+	}{
+		{[]string{"1770671182"}, "expected name=npi"},
+		{[]string{"=1770671182"}, "expected name=npi"},
+		{[]string{"a.b=1770671182"}, "name may only use"},
+		{[]string{"a=1770671182", "a=1234567893"}, "set a given twice"},
+		{[]string{"a="}, "a: no NPIs"},
+		{[]string{"a=12x"}, "--npi-set a:"},
+	} {
+		if _, _, err := parseNPISets(tt.specs); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("parseNPISets(%q): got %v, want an error containing %q", tt.specs, err, tt.err)
+		}
+	}
+}
+
+func TestBuildNPISetSinks(t *testing.T) {
+	sets := []output.NPISet{{Name: "acme", NPIs: []int64{1}}, {Name: "zenith", NPIs: []int64{2}}}
+	specs := []string{"csv:out/rates.csv", "ndjson:s3://bucket/2026-02/rates.ndjson", "json:https://up.example.com/r.json?sig=a.b"}
+	sink, outputs, err := buildNPISetSinks(sets, "results.json", "", nil, specs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sink == nil || sets[0].Sink == nil || sets[1].Sink == nil {
+		t.Fatal("expected a sink for each set")
+	}
+	want := []string{
+		"results.acme.json", "csv:out/rates.acme.csv", "ndjson:s3://bucket/2026-02/rates.acme.ndjson", "json:https://up.example.com/r.acme.json?sig=a.b",
+		"results.zenith.json", "csv:out/rates.zenith.csv", "ndjson:s3://bucket/2026-02/rates.zenith.ndjson", "json:https://up.example.com/r.zenith.json?sig=a.b",
+	}
+	if !reflect.DeepEqual(outputs, want) {
+		t.Errorf("outputs =\n%q\nwant\n%q", outputs, want)
+	}
+
+	if _, _, err := buildNPISetSinks(sets, "results.json", "", nil, []string{"s3://bucket/rates.csv"}); err == nil || !strings.Contains(err.Error(), "expected kind:path") {
+		t.Errorf("expected a spec without a kind refused, got %v", err)
+	}
+	if _, _, err := buildNPISetSinks(sets, "-", "", nil, nil); err == nil {
+		t.Error("expected --output - refused")
+	}
+}
@@ -0,0 +1,1106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gyeh/npi-rates/internal/cache"
+	"github.com/gyeh/npi-rates/internal/humanize"
+	"github.com/gyeh/npi-rates/internal/logx"
+	"github.com/gyeh/npi-rates/internal/mrf"
+	"github.com/gyeh/npi-rates/internal/npi"
+	"github.com/gyeh/npi-rates/internal/output"
+	"github.com/gyeh/npi-rates/internal/progress"
+	"github.com/gyeh/npi-rates/internal/refdata"
+	"github.com/gyeh/npi-rates/internal/toc"
+	"github.com/gyeh/npi-rates/internal/worker"
+	"github.com/spf13/cobra"
+)
+
+// searchFlags holds the search command's flags.
+type searchFlags struct {
+	urlsFile     string   // Used during Cloud mode or local mode
+	urlsList     []string // URLs passed directly on the command line
+	npiList      string
+	npiSetSpecs  []string
+	providerName string
+	orgName      string
+	state        string
+	nppesFile    string
+	nppesTTL     time.Duration
+	cacheMaxSize string
+	cacheMaxAge  time.Duration
+	outputFile   string
+	sinkSpecs    []string
+	outputFormat string
+	aggregate    bool
+	aggMinCount  int64
+	aggRound     float64
+	feeSchedule  string
+	codeDescs    string
+	noDedup      bool
+	dedupKey     string
+	workers      int
+	maxWorkers   int
+	tmpDirs      []string
+	noProgress   bool
+	logProgress  bool
+	progressJSON string
+	cacheDir     string
+	keepSplit    string
+	noFIFO       bool
+	noClean      bool
+	cleanAge     time.Duration
+	streamMode   bool
+	spillPass    bool
+	noSimd       bool
+	parseThreads int
+	queueDepth   int
+	maxGroupSize int
+	maxStreamMB  int
+	minSpeedKBps int
+	maxBandwidth string
+	maxWorkerBW  string
+	speedWindow  time.Duration
+	rotateIPs    bool
+	fifoStall    time.Duration
+	fileLogs     bool
+	checkpoint   string
+	snapEvery    time.Duration
+	deadline     time.Duration
+	costPerGB    float64
+	stopAt       string
+	runID        string
+	headers      []string
+	delivHeaders []string
+	sftpKey      string
+	knownHosts   string
+	notifyURL    string
+	slackWebhook string
+	billingCodes []string
+	codeTypes    []string
+	tins         []string
+	groupIDList  []string
+	collapse     string
+	noBundles    bool
+	provenance   bool
+	minRate      float64
+	maxRate      float64
+	itemHookCmd  string
+	refreshCmd   string
+
+	// TOC resolution flags
+	planID  string
+	tocURL  string
+	fromTOC bool
+
+	// Cloud mode flags (Modal orchestration)
+	cloudMode    bool
+	workerMode   bool
+	cdnLocation  string
+	shards       int
+	cloudWorkers int
+	taskRetries  int
+	modalSecrets []string
+	maxCost      float64
+	maxShardGB   float64
+	rerunMissing int
+	allowPartial bool
+}
+
+func newSearchCmd() *cobra.Command {
+	f := &searchFlags{}
+	cmd := &cobra.Command{
+		Use:   "search",
+		Short: "Search MRF files for negotiated rates matching specified NPIs",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := f.validate(args); err != nil {
+				return err
+			}
+			return runSearch(cmd, f)
+		},
+	}
+	f.addFlags(cmd)
+	return cmd
+}
+
+func (f *searchFlags) addFlags(cmd *cobra.Command) {
+	// Standard flags
+	cmd.Flags().StringVar(&f.urlsFile, "urls-file", "", "File containing MRF URLs (one per line; further URLs on a line are mirrors of the first)")
+	cmd.Flags().StringSliceVar(&f.urlsList, "url", nil, "MRF URL(s) to search (can be repeated or comma-separated)")
+	cmd.Flags().StringVar(&f.npiList, "npi", "", "Comma-separated NPI numbers to search for")
+	cmd.Flags().StringArrayVar(&f.npiSetSpecs, "npi-set", nil, "Named NPI set as name=npi,npi,... searched in the same pass as the others, with its own output (results.json becomes results.<name>.json); can be repeated")
+	cmd.Flags().StringVar(&f.providerName, "provider-name", "", "Search by provider name (\"First Last\")")
+	cmd.Flags().StringVar(&f.orgName, "org-name", "", "Search by organization name (hospital, clinic, group practice), e.g. \"Mount Sinai Hospital\"")
+	cmd.Flags().StringSliceVar(&f.tins, "tin", nil, "Provider group TINs (EINs) to search for, e.g. 12-3456789 (can be repeated)")
+	cmd.Flags().StringSliceVar(&f.groupIDList, "provider-group-id", nil, "provider_group_ids (positive) to search for directly, skipping the provider_references scan; results have NPI 0 (can be repeated)")
+	cmd.Flags().StringVar(&f.collapse, "collapse-providers", "none", "none: one result per matched NPI; group: one per provider group TIN and price, listing its matched NPIs")
+	cmd.Flags().StringVar(&f.refreshCmd, "url-refresh-cmd", "", "Shell command run when a URL is refused with HTTP 403 (e.g. an expired signed link); it gets the URL as $1 and prints a fresh one, which is retried")
+	cmd.Flags().StringVar(&f.itemHookCmd, "item-hook", "", "Shell command that receives every matching in_network item, whole, as NDJSON on stdin")
+	cmd.Flags().BoolVar(&f.provenance, "provenance", false, "Record where each rate's in_network item is in the payer's file (split file and line, or element index when streaming) for spot audits; JSON and NDJSON outputs only")
+	cmd.Flags().BoolVar(&f.noBundles, "no-bundles", false, "Skip bundle and capitation rates, which cover a set of services rather than the billing code alone")
+	cmd.Flags().Float64Var(&f.minRate, "min-rate", 0, "Drop results with a negotiated rate below this")
+	cmd.Flags().Float64Var(&f.maxRate, "max-rate", 0, "Drop results with a negotiated rate above this, as payer data errors (0: no upper bound)")
+	cmd.Flags().StringSliceVar(&f.billingCodes, "billing-code", nil, "Only search these billing codes, e.g. 99213,J0129 (can be repeated)")
+	cmd.Flags().StringSliceVar(&f.codeTypes, "billing-code-type", nil, "Only search these billing code types, e.g. CPT,HCPCS (can be repeated)")
+	cmd.Flags().StringVar(&f.state, "state", "", "State filter for provider and organization name search (2-letter code, e.g. NY)")
+	cmd.Flags().StringVar(&f.cacheMaxSize, "cache-max-size", "", "After the run, evict least recently used entries of --cache-dir, --keep-split and the NPPES cache until together they fit in this size (e.g. 200GB)")
+	cmd.Flags().DurationVar(&f.cacheMaxAge, "cache-max-age", 0, "After the run, evict cache entries not used for this long (e.g. 720h)")
+	cmd.Flags().DurationVar(&f.nppesTTL, "nppes-cache-ttl", npi.DefaultClient.CacheTTL, "Reuse NPPES registry lookups cached in ~/.cache/npi-rates for this long (0 to disable)")
+	cmd.Flags().StringVar(&f.nppesFile, "nppes-file", "", "Look up providers in this NPPES dissemination file (.csv or .zip, indexed on first use) instead of the registry API")
+	cmd.Flags().StringVarP(&f.outputFile, "output", "o", "", "Output file path, or s3://, gs://, sftp:// or http(s):// URL to deliver it to (default: results_<timestamp>.json, use '-' for stdout; .ndjson/.csv/.db select those formats)")
+	cmd.Flags().StringVar(&f.outputFormat, "format", "", "Output format for --output: json, ndjson, csv, or sqlite (default: from extension, else json)")
+	cmd.Flags().StringArrayVar(&f.sinkSpecs, "sink", nil, "Additional output as kind:path (json, ndjson, csv, sqlite; path may be a URL as for --output); can be repeated")
+	cmd.Flags().StringVar(&f.feeSchedule, "medicare-fee-schedule", "", "Physician Fee Schedule CSV (one locality) to annotate CPT/HCPCS dollar rates with medicare_rate and percent_of_medicare")
+	cmd.Flags().StringVar(&f.codeDescs, "code-descriptions", "", "CSV of CPT/HCPCS code descriptions used to fill in blank billing_code_description")
+	cmd.Flags().BoolVar(&f.aggregate, "aggregate", false, "Write one row per NPI, billing code, billing class, setting and unit with the count, min, median and max rate to --output, instead of every price (--sink outputs stay unaggregated)")
+	cmd.Flags().Int64Var(&f.aggMinCount, "aggregate-min-count", 0, "With --aggregate, leave out rows summarizing fewer than this many rates, for publishing statistics that don't reveal single contracts")
+	cmd.Flags().Float64Var(&f.aggRound, "aggregate-round", 0, "With --aggregate, round min, median and max to the nearest multiple of this, in the row's unit (e.g. 1 for whole dollars)")
+	cmd.Flags().BoolVar(&f.noDedup, "no-dedup", false, "Keep results identical to one already written (e.g. a file listed twice, or a payer repeating a rate), and the 16 bytes per distinct result dedup holds in memory")
+	cmd.Flags().StringVar(&f.dedupKey, "dedup-key", "", "Comma-separated result fields that identify a duplicate, e.g. npi,tin,billing_code,negotiated_rate,source_file (default: all fields)")
+	cmd.Flags().IntVar(&f.workers, "workers", 3, "Number of concurrent file workers")
+	cmd.Flags().IntVar(&f.maxWorkers, "max-workers", 0, "Schedule files by size: largest first, up to this many at once, with --workers the most over 1 GB and, without --stream, only as many as fit in --tmp-dir")
+	cmd.Flags().StringVar(&f.cacheDir, "cache-dir", "", "Keep downloaded MRFs here and reuse them while the server reports them unchanged (ETag/Last-Modified)")
+	cmd.Flags().StringVar(&f.keepSplit, "keep-split", "", "Keep each file's split output here and reuse it while the server reports the file unchanged, skipping download and split (uses the split pipeline, not --stream)")
+	cmd.Flags().StringSliceVar(&f.tmpDirs, "tmp-dir", nil, "Temp directories for intermediate files; several spread files across volumes (default: system temp)")
+	cmd.Flags().BoolVar(&f.noProgress, "no-progress", false, "Disable progress bars")
+	cmd.Flags().BoolVar(&f.logProgress, "log-progress", false, "Use line-based progress logging (for non-TTY environments)")
+	cmd.Flags().StringVar(&f.progressJSON, "progress-json", "", "Write progress as NDJSON events instead of progress bars, to stderr, or to FILE given as --progress-json=FILE")
+	cmd.Flags().Lookup("progress-json").NoOptDefVal = "-"
+	cmd.Flags().BoolVar(&f.noFIFO, "no-fifo", false, "Use file-based pipeline instead of FIFO streaming")
+	cmd.Flags().BoolVar(&f.noClean, "no-clean", false, "Keep temp files left in --tmp-dir by earlier runs that crashed")
+	cmd.Flags().DurationVar(&f.cleanAge, "clean-older-than", defaultCleanAge, "Remove temp files left by crashed runs once untouched for this long")
+	cmd.Flags().BoolVar(&f.streamMode, "stream", true, "Stream directly from download to parsing (no disk, constant memory)")
+	cmd.Flags().BoolVar(&f.spillPass, "spill-second-pass", false, "With --stream, keep a copy of each download in --tmp-dir until its layout is known, so a file with in_network before provider_references isn't downloaded twice")
+	cmd.Flags().BoolVar(&f.noSimd, "no-simd", false, "Disable simdjson (or the portable scanner on CPUs without it) and use stdlib encoding/json")
+	cmd.Flags().IntVar(&f.parseThreads, "parse-parallelism", 0, "Goroutines per file for in_network parsing (default: GOMAXPROCS split across concurrent files)")
+	cmd.Flags().IntVar(&f.queueDepth, "parse-queue-depth", mrf.DefaultQueueDepth, "in_network elements buffered per parse goroutine, within --max-stream-memory")
+	cmd.Flags().IntVar(&f.maxStreamMB, "max-stream-memory", 1024, "MB of raw in_network JSON buffered for parsing across all files; reading pauses at the cap (0: no cap)")
+	cmd.Flags().IntVar(&f.maxGroupSize, "max-group-providers", 50000, "Most matched providers kept per provider group; larger groups are truncated with a warning (0: no cap)")
+	cmd.Flags().IntVar(&f.minSpeedKBps, "min-speed-kbps", 50, "Abort and retry a download whose throughput stays below this many KB/s for --min-speed-window (0 to disable)")
+	cmd.Flags().StringVar(&f.maxBandwidth, "max-bandwidth", "", "Cap total download throughput across workers, per second, e.g. 20MB")
+	cmd.Flags().StringVar(&f.maxWorkerBW, "max-worker-bandwidth", "", "Cap each worker's download throughput, per second, e.g. 5MB")
+	cmd.Flags().DurationVar(&f.speedWindow, "min-speed-window", 5*time.Minute, "Window over which --min-speed-kbps is measured")
+	cmd.Flags().DurationVar(&f.fifoStall, "fifo-stall-timeout", 10*time.Minute, "Abort a FIFO attempt that neither downloads nor splits anything for this long, and retry with the file pipeline (0 to disable)")
+	cmd.Flags().BoolVar(&f.rotateIPs, "rotate-ips", false, "Start each new download connection from the next resolved CDN address")
+	cmd.Flags().StringVar(&f.checkpoint, "checkpoint", "", "Record completed files and their results here; rerunning with the same file skips them")
+	cmd.Flags().Float64Var(&f.costPerGB, "transfer-cost-per-gb", 0, "Estimate the run's data-transfer cost at this price per GB downloaded (e.g. 0.045 for a cloud NAT gateway)")
+	cmd.Flags().DurationVar(&f.snapEvery, "snapshot-every", 0, "Every this often (e.g. 10m), write the results found so far to <output>.partial.json, so a crash keeps them; removed when the run completes")
+	cmd.Flags().DurationVar(&f.deadline, "deadline", 0, "Stop starting new files this long after launch (e.g. 6h); files in flight finish and the output is marked truncated")
+	cmd.Flags().StringVar(&f.stopAt, "stop-at", "", "Like --deadline, but at a clock time (HH:MM, next occurrence, or RFC 3339)")
+	cmd.Flags().StringVar(&f.notifyURL, "notify-url", "", "POST a JSON summary of the run (status, files searched and matched, rates, duration, failures) to this URL when the search completes or fails")
+	cmd.Flags().StringVar(&f.slackWebhook, "slack-webhook", "", "Post a summary message to this Slack incoming webhook URL when the search completes or fails")
+	cmd.Flags().StringArrayVar(&f.delivHeaders, "deliver-header", nil, "Extra header for http(s):// outputs, which are uploaded with PUT, as \"Name: value\" (can be repeated; also read from $"+output.DeliverHeadersEnv+", one per line)")
+	cmd.Flags().StringVar(&f.sftpKey, "sftp-key", "", "Private key for sftp:// outputs (default: ssh-agent, then unencrypted ~/.ssh/id_* keys; password from the URL or $"+output.SFTPPasswordEnv+")")
+	cmd.Flags().StringVar(&f.knownHosts, "known-hosts", "", "known_hosts file sftp:// output hosts are checked against (default: ~/.ssh/known_hosts)")
+	cmd.Flags().StringArrayVar(&f.headers, "header", nil, "Extra request header for MRF downloads as \"Name: value\", sent to the hosts of the searched URLs, or \"host=Name: value\" for one host (can be repeated; also read from $"+worker.HeadersEnv+", one per line)")
+	cmd.Flags().StringVar(&f.runID, "run-id", "", "ID tagging this run's logs, output and cloud resources (default: start time plus random hex)")
+	cmd.Flags().BoolVar(&f.fileLogs, "file-logs", false, "Write a debug log per file (attempts, response headers, retries, warnings, timing) to logs/ next to the output")
+
+	// TOC resolution flags
+	cmd.Flags().StringVar(&f.planID, "plan-id", "", "Healthcare plan identifier (HIOS ID or EIN) for TOC lookup")
+	cmd.Flags().StringVar(&f.tocURL, "toc-url", "", "URL of CMS Table of Contents file (.json or .json.gz)")
+	cmd.Flags().BoolVar(&f.fromTOC, "from-toc", false, "Read the URL list from stdin, as written by \"npi-rates toc resolve\"")
+
+	// Cloud mode flags (Modal orchestration)
+	cmd.Flags().BoolVar(&f.cloudMode, "cloud", false, "Run in cloud mode (distribute to Modal functions)")
+	cmd.Flags().BoolVar(&f.workerMode, "worker-mode", false, "Run as one worker of a distributed search: make no NPPES registry or IP geolocation calls, leaving them to the orchestrator (set on cloud shards)")
+	cmd.Flags().StringVar(&f.cdnLocation, "cdn-location", "", "Report this as the file servers' location instead of looking it up by IP geolocation (passed to cloud shards by the orchestrator)")
+	cmd.Flags().IntVar(&f.shards, "shards", 100, "Number of URL shards, balanced by compressed size (cloud mode)")
+	cmd.Flags().Float64Var(&f.maxShardGB, "max-shard-gb", 0, "Add shards as needed so none holds more than this many GB compressed, beyond a single larger file (cloud mode, 0: no cap)")
+	cmd.Flags().IntVar(&f.cloudWorkers, "cloud-workers", 1, "Workers per shard (cloud mode)")
+	cmd.Flags().IntVar(&f.taskRetries, "task-retries", 2, "Re-run a failed shard up to this many times before failing the search (cloud mode, max 10)")
+	cmd.Flags().IntVar(&f.rerunMissing, "rerun-missing", 0, "Give shards that still failed this many further rounds once the others are done (cloud mode)")
+	cmd.Flags().BoolVar(&f.allowPartial, "allow-partial", false, "If shards are still missing, write the others' results marked incomplete, listing the unsearched URLs, instead of failing (cloud mode)")
+	cmd.Flags().StringArrayVar(&f.modalSecrets, "modal-secret", nil, "Modal secret to expose to shards as environment variables, e.g. with "+worker.HeadersEnv+" or HTTPS_PROXY (cloud mode, can be repeated)")
+	cmd.Flags().Float64Var(&f.maxCost, "max-cost", 0, "Abort a cloud search whose estimated compute cost exceeds this many USD (cloud mode)")
+}
+
+// validate checks the flags against each other, before anything is looked
+// up or downloaded.
+func (f *searchFlags) validate(args []string) error {
+	// search takes no arguments, so one here is most likely a file
+	// meant for a bare --progress-json, which writes to stderr.
+	if f.progressJSON == "-" && len(args) > 0 {
+		return fmt.Errorf("--progress-json takes a file only as --progress-json=FILE; unexpected argument %q", args[0])
+	}
+	if f.collapse != "none" && f.collapse != "group" {
+		return fmt.Errorf("--collapse-providers must be none or group, got %q", f.collapse)
+	}
+	if f.maxRate > 0 && f.minRate > f.maxRate {
+		return fmt.Errorf("--min-rate (%g) is above --max-rate (%g)", f.minRate, f.maxRate)
+	}
+	if f.maxCost > 0 && !f.cloudMode {
+		return fmt.Errorf("--max-cost only applies with --cloud")
+	}
+	if f.runID != "" && !validRunID(f.runID) {
+		return fmt.Errorf("--run-id must be 1-40 letters, digits, '.', '_' or '-', got %q", f.runID)
+	}
+
+	// Stdin carries the URL list with --from-toc (or --urls-file -),
+	// so it can't also answer prompts.
+	if f.fromTOC && f.urlsFile != "" {
+		return fmt.Errorf("--from-toc and --urls-file are mutually exclusive")
+	}
+	if f.fromTOC || f.urlsFile == "-" {
+		if f.providerName != "" {
+			return fmt.Errorf("--provider-name prompts on stdin; use --npi with --from-toc")
+		}
+		if f.orgName != "" {
+			return fmt.Errorf("--org-name prompts on stdin; use --npi with --from-toc")
+		}
+	}
+	if f.providerName != "" && f.orgName != "" {
+		return fmt.Errorf("--provider-name and --org-name are mutually exclusive")
+	}
+	if len(f.npiSetSpecs) > 0 && (f.npiList != "" || f.providerName != "" || f.orgName != "" || len(f.tins) > 0 || len(f.groupIDList) > 0) {
+		return fmt.Errorf("--npi-set can't be combined with --npi, --provider-name, --org-name, --tin or --provider-group-id")
+	}
+
+	if f.noDedup && f.dedupKey != "" {
+		return fmt.Errorf("--no-dedup and --dedup-key are mutually exclusive")
+	}
+	if !f.aggregate && (f.aggMinCount != 0 || f.aggRound != 0) {
+		return fmt.Errorf("--aggregate-min-count and --aggregate-round need --aggregate")
+	}
+	if f.aggMinCount < 0 || f.aggRound < 0 {
+		return fmt.Errorf("--aggregate-min-count and --aggregate-round must not be negative")
+	}
+	if f.deadline > 0 && f.stopAt != "" {
+		return fmt.Errorf("--deadline and --stop-at are mutually exclusive")
+	}
+	if (f.planID != "") != (f.tocURL != "") {
+		return fmt.Errorf("--plan-id and --toc-url must be provided together")
+	}
+	if f.maxWorkers > 0 && f.maxWorkers < f.workers {
+		return fmt.Errorf("--max-workers (%d) must be at least --workers (%d)", f.maxWorkers, f.workers)
+	}
+	if f.cloudMode {
+		return f.validateCloud()
+	}
+	return nil
+}
+
+// targets resolves what the search looks for: the NPIs from --npi,
+// --provider-name or --org-name (prompting to pick a match for the names),
+// the --npi-set sets, whose union is searched, and the --provider-group-id
+// IDs.
+func (f *searchFlags) targets() (npis []int64, npiSets []output.NPISet, groupIDs []int64, err error) {
+	if f.providerName != "" {
+		selected, err := searchAndSelectProvider(f.providerName, f.state)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		npis = []int64{selected.NPI}
+	} else if f.orgName != "" {
+		selected, err := searchAndSelectOrganization(f.orgName, f.state)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		npis = []int64{selected.NPI}
+	} else if f.npiList != "" {
+		if npis, err = parseNPIs(f.npiList); err != nil {
+			return nil, nil, nil, fmt.Errorf("parsing NPIs: %w", err)
+		}
+	}
+	if len(f.npiSetSpecs) > 0 {
+		if npiSets, npis, err = parseNPISets(f.npiSetSpecs); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	for _, s := range f.groupIDList {
+		id, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+		if err != nil || id < 0 {
+			return nil, nil, nil, fmt.Errorf("--provider-group-id: invalid ID %q", s)
+		}
+		// Results carry the ID in provider_group_id, where 0 means
+		// none and is left out, so their group couldn't be told.
+		if id == 0 {
+			return nil, nil, nil, fmt.Errorf("--provider-group-id: 0 is not supported; results could not name the group")
+		}
+		groupIDs = append(groupIDs, id)
+	}
+	if len(groupIDs) > 0 {
+		// Phase A is skipped, so NPIs and TINs would only match
+		// inline provider groups.
+		if len(npis) > 0 || len(f.tins) > 0 {
+			return nil, nil, nil, fmt.Errorf("--provider-group-id can't be combined with --npi, --provider-name, --org-name or --tin")
+		}
+	} else if len(npis) == 0 && len(f.tins) == 0 {
+		return nil, nil, nil, fmt.Errorf("specify --npi, --npi-set, --provider-name, --org-name, --tin, or --provider-group-id")
+	}
+	return npis, npiSets, groupIDs, nil
+}
+
+// runSearch runs the search f describes, once its flags are validated.
+func runSearch(cmd *cobra.Command, f *searchFlags) (err error) {
+	if f.noSimd {
+		mrf.DisableSimd()
+	}
+	if f.runID == "" {
+		f.runID = logx.NewRunID()
+	}
+	logx.SetRunID(f.runID)
+	reqHeaders, err := worker.ParseRequestHeaders(append(worker.EnvHeaderLines(), f.headers...))
+	if err != nil {
+		return fmt.Errorf("--header: %w", err)
+	}
+	worker.SetRequestHeaders(reqHeaders)
+	delivery, err := worker.ParseHeaders(append(output.EnvDeliverHeaderLines(), f.delivHeaders...))
+	if err != nil {
+		return fmt.Errorf("--deliver-header: %w", err)
+	}
+	output.SetDelivery(output.DeliveryOptions{Headers: delivery, SFTPKey: f.sftpKey, KnownHosts: f.knownHosts})
+	output.SetCSVNPIs(f.collapse == "group")
+
+	// Whatever ends the run from here on is reported.
+	var note *output.Notification
+	if f.notifyURL != "" || f.slackWebhook != "" {
+		note = output.NewNotification(f.runID)
+		runStart := time.Now()
+		defer func() {
+			note.DurationSeconds = time.Since(runStart).Seconds()
+			if err != nil {
+				note.Status, note.Error = "failed", output.RedactText(err.Error())
+			}
+			if nerr := output.Notify(note, f.notifyURL, f.slackWebhook); nerr != nil {
+				fmt.Fprintf(os.Stderr, "WARNING: sending notification: %v\n", nerr)
+			}
+		}()
+	}
+
+	if f.fromTOC {
+		f.urlsFile = "-"
+	}
+
+	npi.DefaultClient.CacheTTL = f.nppesTTL
+	npi.DefaultClient.Offline = f.workerMode
+	if f.nppesFile != "" {
+		idx, err := openNPPESFile(f.nppesFile)
+		if err != nil {
+			return err
+		}
+		defer idx.Close()
+		npi.SetIndex(idx)
+	}
+	npis, npiSets, groupIDs, err := f.targets()
+	if err != nil {
+		return err
+	}
+
+	// --deadline and --stop-at bound when new files may start,
+	// counted from launch.
+	var stopTime time.Time
+	if f.deadline > 0 {
+		stopTime = time.Now().Add(f.deadline)
+	} else if f.stopAt != "" {
+		if stopTime, err = parseStopAt(f.stopAt, time.Now()); err != nil {
+			return err
+		}
+	}
+
+	// Handle signals: first ^C cancels context for graceful shutdown,
+	// second ^C force-exits immediately.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigCh := make(chan os.Signal, 2)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigCh
+		fmt.Fprintf(os.Stderr, "\nReceived %s, shutting down... (^C again to force quit)\n", sig)
+		cancel()
+		sig = <-sigCh
+		fmt.Fprintf(os.Stderr, "\nReceived %s, force quit.\n", sig)
+		os.Exit(1)
+	}()
+
+	logx.Infof("Run ID: %s\n", f.runID)
+
+	// Default output filename with timestamp
+	if f.outputFile == "" {
+		f.outputFile = fmt.Sprintf("results_%s.json", time.Now().Format("20060102_150405"))
+	}
+
+	// Set up progress. Cloud mode streams modal's own output to
+	// stderr, which would tear through progress bars.
+	var mgr progress.Manager
+	var jsonProgress *progress.JSONManager
+	if f.progressJSON != "" {
+		w := io.Writer(os.Stderr)
+		if f.progressJSON != "-" {
+			pf, err := os.Create(f.progressJSON)
+			if err != nil {
+				return fmt.Errorf("--progress-json: %w", err)
+			}
+			defer pf.Close()
+			w = pf
+		}
+		jsonProgress = progress.NewJSONManager(w, f.runID)
+		mgr = jsonProgress
+		// A run that fails still ends its event stream.
+		defer func() {
+			if err != nil {
+				jsonProgress.Emit(progress.Event{Event: "complete", Status: "failed", Error: err.Error()})
+			}
+		}()
+	} else if logx.IsQuiet() {
+		mgr = &progress.NoopManager{Quiet: true}
+	} else if f.logProgress {
+		mgr = progress.NewLogManager()
+	} else if f.noProgress || f.cloudMode {
+		mgr = &progress.NoopManager{}
+	} else if f.outputFile == "-" {
+		// Results are being piped: keep stderr to warnings only.
+		mgr = &progress.NoopManager{Quiet: true}
+	}
+
+	// Look up NPI provider info; in worker mode the orchestrator has.
+	// The bars are only started after the prompt below, which they
+	// would otherwise redraw over, so the lookup gets its own.
+	if !f.logProgress && !f.workerMode && len(npis) > 0 {
+		lookupMgr := mgr
+		if lookupMgr == nil {
+			lookupMgr = progress.NewMPBManager()
+		}
+		if notFound := printProviderInfo(ctx, lookupMgr, npis); len(notFound) > 0 {
+			if f.urlsFile == "-" {
+				logx.Infof("Continuing despite NPI(s) missing from NPPES (stdin holds the URL list, so no prompt)\n")
+			} else if !confirmContinue(notFound) {
+				return fmt.Errorf("aborted: %d NPI(s) not found in NPPES registry", len(notFound))
+			}
+		}
+	}
+	if mgr == nil {
+		mgr = progress.NewMPBManager()
+	}
+
+	// --- Gather URLs ---
+	var urls []string
+	var mirrors map[string][]string
+
+	// Source 1: TOC resolution
+	if f.tocURL != "" {
+		phase := mgr.StartPhase(fmt.Sprintf("Resolving TOC for plan %s", f.planID))
+		tocResult, tocErr := toc.FetchAndResolve(ctx, f.tocURL, f.planID, func(d, t int64) {
+			if t > 0 {
+				phase.Update(fmt.Sprintf("%s / %s", humanize.Bytes(uint64(d)), humanize.Bytes(uint64(t))))
+			} else {
+				phase.Update(humanize.Bytes(uint64(d)))
+			}
+		})
+		if tocErr == nil && len(tocResult.URLs) == 0 {
+			tocErr = fmt.Errorf("found 0 in-network URLs for plan %s", f.planID)
+		}
+		if tocErr != nil {
+			phase.Done(tocErr)
+			return fmt.Errorf("TOC resolution failed: %w", tocErr)
+		}
+		phase.Update(fmt.Sprintf("%d MRF URLs from %d matching structures, entity: %s",
+			len(tocResult.URLs), tocResult.MatchedStructures, tocResult.ReportingEntityName))
+		phase.Done(nil)
+		urls = tocResult.URLs
+	}
+
+	// Source 2: explicit URLs (combinable with TOC)
+	if len(f.urlsList) > 0 {
+		urls = append(urls, f.urlsList...)
+	} else if f.urlsFile != "" {
+		fileURLs, fileMirrors, readErr := readURLs(f.urlsFile)
+		if readErr != nil {
+			return fmt.Errorf("reading URLs: %w", readErr)
+		}
+		urls = append(urls, fileURLs...)
+		mirrors = fileMirrors
+	}
+
+	if len(urls) == 0 {
+		return fmt.Errorf("no URLs; use --toc-url + --plan-id, --urls-file, or --url")
+	}
+	worker.SetSearchedURLs(urls)
+	sizes, location := logURLInfo(ctx, urls, func(u string) string {
+		switch {
+		case f.cdnLocation != "":
+			return f.cdnLocation
+		case f.workerMode:
+			return ""
+		}
+		return detectRegionFromIP(ctx, u)
+	})
+
+	// --- Cloud mode: distribute to Modal functions ---
+	if f.cloudMode {
+		return runCloudSearch(ctx, cmd, f, cloudSearch{
+			npis:     npis,
+			groupIDs: groupIDs,
+			urls:     urls,
+			mirrors:  mirrors,
+			sizes:    sizes,
+			location: location,
+			progress: mgr,
+		})
+	}
+
+	// Build NPI lookup set
+	npiSet := make(map[int64]struct{}, len(npis))
+	for _, n := range npis {
+		npiSet[n] = struct{}{}
+	}
+
+	// Kept split output comes from the split pipeline only.
+	if f.keepSplit != "" && f.streamMode {
+		if cmd.Flags().Changed("stream") {
+			return fmt.Errorf("--keep-split needs the split pipeline; drop --stream")
+		}
+		f.streamMode = false
+	}
+
+	// Set up temp dirs; files are spread across them round-robin.
+	if len(f.tmpDirs) == 0 {
+		f.tmpDirs = []string{os.TempDir()}
+	}
+	for _, dir := range f.tmpDirs {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("creating temp dir: %w", err)
+		}
+	}
+	if err := worker.SetKeepSplitDir(f.keepSplit); err != nil {
+		return err
+	}
+	if !f.noClean {
+		cleanTmpDirs(f.tmpDirs, f.cleanAge)
+		if f.keepSplit != "" {
+			cleanTmpDirs([]string{f.keepSplit}, f.cleanAge)
+		}
+	}
+
+	// Check available disk space and warn if low (skip for streaming mode — no disk used)
+	avail := make([]uint64, len(f.tmpDirs))
+	if !f.streamMode {
+		for i, dir := range f.tmpDirs {
+			avail[i] = availableDiskSpace(dir)
+			if avail[i] > 0 && avail[i] < 50*1024*1024*1024 { // < 50 GB
+				logx.Infof("WARNING: Only %s available in temp dir %s\n", humanize.Bytes(avail[i]), dir)
+				logx.Infof("  MRF files decompress to 5-40 GB each. Use --tmp-dir to point to a larger volume.\n")
+				logx.Infof("  Consider --workers 1 to reduce concurrent disk usage.\n\n")
+			}
+		}
+	}
+
+	search := &mrf.SearchOptions{
+		NPIs:              npiSet,
+		TINs:              f.tins,
+		GroupIDs:          groupIDs,
+		BillingCodes:      f.billingCodes,
+		BillingCodeTypes:  f.codeTypes,
+		SkipBundled:       f.noBundles,
+		MinRate:           f.minRate,
+		MaxRate:           f.maxRate,
+		CollapseProviders: f.collapse == "group",
+		Provenance:        f.provenance,
+		Counts:            &mrf.RateCounts{},
+	}
+	worker.SetSpeedFloor(int64(f.minSpeedKBps)*1024, f.speedWindow)
+	worker.SetSecondPassSpill(f.spillPass)
+	var bwTotal, bwWorker int64
+	if f.maxBandwidth != "" {
+		if bwTotal, err = humanize.ParseBytes(f.maxBandwidth); err != nil {
+			return fmt.Errorf("--max-bandwidth: %w", err)
+		}
+	}
+	if f.maxWorkerBW != "" {
+		if bwWorker, err = humanize.ParseBytes(f.maxWorkerBW); err != nil {
+			return fmt.Errorf("--max-worker-bandwidth: %w", err)
+		}
+	}
+	// A download held under the speed floor would be aborted and
+	// retried forever.
+	if share := bandwidthShare(bwTotal, bwWorker, max(f.workers, f.maxWorkers)); share > 0 && f.minSpeedKBps > 0 && share < int64(f.minSpeedKBps)*1024 {
+		return fmt.Errorf("bandwidth caps leave %s per worker, below --min-speed-kbps %d; lower --min-speed-kbps (0 disables it)",
+			humanize.Rate(float64(share)), f.minSpeedKBps)
+	}
+	worker.SetMaxBandwidth(bwTotal, bwWorker)
+	worker.SetIPRotation(f.rotateIPs)
+	worker.SetFIFOStallTimeout(f.fifoStall)
+	if err := worker.SetCacheDir(f.cacheDir); err != nil {
+		return err
+	}
+	// The caches are brought back within their limits however the
+	// run ends.
+	if f.cacheMaxSize != "" || f.cacheMaxAge > 0 {
+		policy, err := parseCachePolicy(f.cacheMaxSize, f.cacheMaxAge)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			evicted, err := cache.GC(cacheStores(f.cacheDir, f.keepSplit, npi.DefaultClient.CacheDir), policy, time.Now(), false)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "WARNING: cache gc: %v\n", err)
+			}
+			if len(evicted) > 0 {
+				var freed int64
+				for _, e := range evicted {
+					freed += e.Size
+				}
+				logx.Infof("Cache: removed %d entries (%s) to stay within --cache-max-size/--cache-max-age\n", len(evicted), humanize.Bytes(uint64(freed)))
+			}
+		}()
+	}
+	// Descriptions are filled before fees are looked up, so enrichers
+	// see the most complete result.
+	var enrichers []mrf.Enricher
+	var descs *refdata.CodeTable
+	if f.codeDescs != "" {
+		if descs, err = refdata.LoadCodeDescriptions(f.codeDescs); err != nil {
+			return fmt.Errorf("--code-descriptions: %w", err)
+		}
+		enrichers = append(enrichers, refdata.DescriptionFiller{Provider: descs})
+	}
+	var fees *refdata.FeeSchedule
+	if f.feeSchedule != "" {
+		if fees, err = refdata.LoadFeeSchedule(f.feeSchedule); err != nil {
+			return fmt.Errorf("--medicare-fee-schedule: %w", err)
+		}
+		enrichers = append(enrichers, fees)
+	}
+	search.Enrichers = enrichers
+
+	// Split parse fan-out across concurrently parsed files unless set explicitly.
+	if f.parseThreads <= 0 {
+		f.parseThreads = worker.DefaultParseParallelism(f.workers, len(urls))
+	}
+	mrf.SetParseParallelism(f.parseThreads)
+	mrf.SetQueueDepth(f.queueDepth)
+	mrf.SetMaxGroupProviders(f.maxGroupSize)
+	mrf.SetMaxStreamMemory(int64(f.maxStreamMB) << 20)
+
+	// Log environment info
+	logx.Infof("Parser: %s\n", mrf.ParserName())
+	if f.streamMode {
+		logx.Infof("Mode: streaming (no disk), parse parallelism %d per file\n", mrf.ParseParallelism())
+	} else {
+		for i, dir := range f.tmpDirs {
+			logx.Infof("Temp dir: %s (%s available)\n", dir, humanize.Bytes(avail[i]))
+		}
+	}
+	if len(f.tins) > 0 {
+		logx.Infof("TINs: %s\n", strings.Join(f.tins, ", "))
+	}
+	if len(groupIDs) > 0 {
+		logx.Infof("Provider group IDs: %s (provider_references not scanned)\n", joinInts(groupIDs))
+	}
+	if len(f.billingCodes) > 0 || len(f.codeTypes) > 0 {
+		logx.Infof("Billing code filter: codes=%s types=%s\n",
+			orAny(f.billingCodes), orAny(f.codeTypes))
+	}
+	// Per-file debug logs go in logs/ next to the output.
+	var logDir string
+	if f.fileLogs {
+		logDir = "logs"
+		if f.outputFile != "-" && !output.IsRemote(f.outputFile) {
+			logDir = filepath.Join(filepath.Dir(f.outputFile), "logs")
+		}
+		if err := os.MkdirAll(logDir, 0o755); err != nil {
+			return fmt.Errorf("creating log dir: %w", err)
+		}
+		logx.Infof("Per-file logs: %s\n", logDir)
+	}
+	if !stopTime.IsZero() {
+		logx.Infof("Stop starting files at: %s\n", stopTime.Format("Mon 15:04:05"))
+	}
+	if f.cacheDir != "" {
+		logx.Infof("Download cache: %s\n", f.cacheDir)
+	}
+	if f.keepSplit != "" {
+		logx.Infof("Keeping split output: %s\n", f.keepSplit)
+	}
+	if bwTotal > 0 || bwWorker > 0 {
+		logx.Infof("Bandwidth cap: %s\n", describeBandwidth(bwTotal, bwWorker))
+	}
+	if f.itemHookCmd != "" {
+		logx.Infof("Item hook: %s\n", f.itemHookCmd)
+	}
+	if f.refreshCmd != "" {
+		logx.Infof("URL refresh: %s\n", f.refreshCmd)
+	}
+	if fees != nil {
+		logx.Infof("Medicare fee schedule: %s (%s codes)\n", f.feeSchedule, humanize.Count(int64(fees.Len())))
+	}
+	if descs != nil {
+		logx.Infof("Code descriptions: %s (%s codes)\n", f.codeDescs, humanize.Count(int64(descs.Len())))
+	}
+	// --max-workers schedules files by the sizes logURLInfo got.
+	var poolSizes []int64
+	if f.maxWorkers > 0 {
+		poolSizes = sizes
+		var large, unknown int
+		for _, n := range sizes {
+			if n <= 0 {
+				unknown++
+			} else if n >= worker.LargeFileSize {
+				large++
+			}
+		}
+		logx.Infof("Workers: up to %d, %d for files over %s (%d large, %d of unknown size)\n\n",
+			f.maxWorkers, f.workers, humanize.Bytes(worker.LargeFileSize), large, unknown)
+	} else {
+		logx.Infof("Workers: %d\n\n", f.workers)
+	}
+
+	// Build output sinks up front so a bad --sink fails before any downloading.
+	chain, err := buildSinkChain(ctx, f, npiSets, npis, groupIDs)
+	if err != nil {
+		return err
+	}
+	sink := chain.sink
+
+	// Files completed by an earlier, interrupted run of the same
+	// search are skipped and their recorded results merged in.
+	var ckpt *worker.Checkpoint
+	if f.checkpoint != "" {
+		ckpt, err = worker.OpenCheckpoint(f.checkpoint, searchKey(npis, f.tins, groupIDs, f.billingCodes, f.codeTypes, f.collapse, f.noBundles, f.provenance, f.minRate, f.maxRate, f.feeSchedule, f.codeDescs))
+		if err != nil {
+			return err
+		}
+		defer ckpt.Close()
+		if n := ckpt.Len(); n > 0 {
+			logx.Infof("Resuming from %s: %d file(s) already complete\n\n", f.checkpoint, n)
+		}
+	}
+
+	// Results are streamed to the sinks as each file completes rather
+	// than collected in memory. Outputs are still delivered after a
+	// first ^C, which only stops the search.
+	if err := sink.Open(context.WithoutCancel(ctx)); err != nil {
+		return fmt.Errorf("opening output: %w", err)
+	}
+	if snap := chain.snap; snap != nil {
+		logx.Infof("Snapshots: %s every %s\n\n", output.SnapshotPath(f.outputFile), f.snapEvery)
+		defer func() {
+			if err == nil {
+				return
+			}
+			if n, serr := snap.Abort(); serr != nil {
+				fmt.Fprintf(os.Stderr, "WARNING: writing snapshot: %v\n", serr)
+			} else if n > 0 {
+				fmt.Fprintf(os.Stderr, "Saved %s results found so far to %s\n", humanize.Count(n), output.SnapshotPath(f.outputFile))
+			}
+		}()
+	}
+
+	// Matching in_network items are also piped, whole, to --item-hook.
+	var hook *output.ItemHookProcess
+	if f.itemHookCmd != "" {
+		hook = output.NewItemHookProcess(f.itemHookCmd)
+		if err := hook.Start(); err != nil {
+			return err
+		}
+		defer hook.Kill()
+		search.ItemHook = hook.Item
+	}
+
+	// Run the worker pool
+	startTime := time.Now()
+
+	pool := &worker.Pool{
+		Workers:    f.workers,
+		Search:     search,
+		TmpDir:     f.tmpDirs[0],
+		TmpDirs:    f.tmpDirs,
+		Progress:   mgr,
+		NoFIFO:     f.noFIFO,
+		Stream:     f.streamMode,
+		Mirrors:    mirrors,
+		LogDir:     logDir,
+		Checkpoint: ckpt,
+		OnResults:  sink.WriteBatch,
+		Deadline:   stopTime,
+		Sizes:      poolSizes,
+		MaxWorkers: f.maxWorkers,
+	}
+	if f.refreshCmd != "" {
+		pool.RefreshURL = worker.RefreshCommand(f.refreshCmd)
+	}
+
+	results := pool.Run(ctx, urls)
+	mgr.Wait()
+
+	// Collect results
+	totalRates := 0
+	matchedFiles := 0
+	var skipped []string
+	var compressedBytes, decompressedBytes int64
+	for _, r := range results {
+		reportPanic(r)
+	}
+	summary := worker.Summarize(results)
+	if note != nil {
+		note.SetSummary(summary)
+		for _, r := range results {
+			if r.Count > 0 {
+				note.FilesMatched++
+			}
+		}
+	}
+	for _, r := range results {
+		if errors.Is(r.Err, worker.ErrDeadline) {
+			skipped = append(skipped, r.URL)
+			continue
+		}
+		if r.Err != nil {
+			if len(urls) > 1 {
+				printRunSummary(summary)
+			} else if hint := worker.ErrorHint(r.Err); hint != "" {
+				fmt.Fprintf(os.Stderr, "\nHint: %s\n", hint)
+			}
+			return fmt.Errorf("fatal: error processing %s: %w", worker.FileNameFromURL(r.URL), r.Err)
+		}
+		compressedBytes += r.CompressedBytes
+		decompressedBytes += r.DecompressedBytes
+		if r.Count > 0 {
+			matchedFiles++
+			totalRates += r.Count
+		}
+	}
+
+	duration := time.Since(startTime)
+
+	// Write output
+	params := mrf.SearchParams{
+		RunID:           f.runID,
+		NPIs:            npis,
+		TINs:            f.tins,
+		GroupIDs:        groupIDs,
+		SearchedFiles:   len(urls) - len(skipped),
+		MatchedFiles:    matchedFiles,
+		DurationSeconds: duration.Seconds(),
+		Status:          mrf.StatusComplete,
+	}
+	if len(skipped) > 0 {
+		params.Status = mrf.StatusTruncated
+		params.SkippedFiles = len(skipped)
+	}
+	params.Transfer = transferSummary(f.costPerGB)
+	dropped, zero := search.Counts.Dropped(), search.Counts.Zero()
+	params.MinRate, params.MaxRate, params.DroppedRates = f.minRate, f.maxRate, dropped
+	params.Summary = summary
+
+	if err := sink.Close(params); err != nil {
+		return fmt.Errorf("writing output: %w", err)
+	}
+	// The hook's failure is its own; the results are written.
+	if hook != nil {
+		if err := hook.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "WARNING: %v\n", err)
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "\nSearch complete: %s files searched, %s matched, %s rates found in %.1fs\n",
+		humanize.Count(int64(len(urls))), humanize.Count(int64(matchedFiles)), humanize.Count(int64(totalRates)), duration.Seconds())
+	if compressedBytes > 0 && decompressedBytes > 0 {
+		fmt.Fprintf(os.Stderr, "Transferred %s, decompressed to %s (%s)\n",
+			humanize.Bytes(uint64(compressedBytes)), humanize.Bytes(uint64(decompressedBytes)),
+			humanize.Ratio(float64(decompressedBytes)/float64(compressedBytes)))
+	}
+	if t := params.Transfer; t.TotalBytes > 0 {
+		fmt.Fprintf(os.Stderr, "Network: %s received from %d host(s)", humanize.Bytes(uint64(t.TotalBytes)), len(t.Hosts))
+		if t.CostPerGB > 0 {
+			fmt.Fprintf(os.Stderr, ", est. transfer cost $%.2f at $%g/GB", t.EstimatedCost, t.CostPerGB)
+		}
+		fmt.Fprintln(os.Stderr)
+	}
+	if dropped > 0 {
+		bounds := fmt.Sprintf("below $%g", f.minRate)
+		if f.maxRate > 0 {
+			bounds = fmt.Sprintf("outside $%g..$%g", f.minRate, f.maxRate)
+		}
+		fmt.Fprintf(os.Stderr, "WARNING: dropped %s results with prices %s (set --min-rate/--max-rate to keep them)\n",
+			humanize.Count(dropped), bounds)
+	}
+	if dedup := chain.dedup; dedup != nil && dedup.Dropped() > 0 {
+		fmt.Fprintf(os.Stderr, "Removed %s duplicate results (set --no-dedup to keep them)\n", humanize.Count(dedup.Dropped()))
+	}
+	if zero > 0 {
+		fmt.Fprintf(os.Stderr, "Note: %s results have a $0 price; payers use it both for real $0 rates and as a placeholder\n",
+			humanize.Count(zero))
+	}
+	if len(urls) > 1 {
+		printRunSummary(summary)
+	}
+	for _, out := range chain.outputs {
+		fmt.Fprintf(os.Stderr, "Results written to %s\n", output.Redact(out))
+	}
+	if note != nil {
+		note.Status = params.Status
+		for _, out := range chain.outputs {
+			note.Outputs = append(note.Outputs, output.Redact(out))
+		}
+	}
+	if len(skipped) > 0 {
+		fmt.Fprintf(os.Stderr, "\nTRUNCATED: stopped at the deadline with %s of %s files not searched:\n",
+			humanize.Count(int64(len(skipped))), humanize.Count(int64(len(urls))))
+		for _, u := range skipped {
+			fmt.Fprintf(os.Stderr, "  %s\n", u)
+		}
+		if f.checkpoint != "" {
+			fmt.Fprintf(os.Stderr, "Rerun with --checkpoint %s to search them.\n", f.checkpoint)
+		}
+	}
+	if jsonProgress != nil {
+		jsonProgress.Emit(progress.Event{
+			Event:         "complete",
+			Status:        params.Status,
+			FilesSearched: params.SearchedFiles,
+			FilesMatched:  matchedFiles,
+			Rates:         int64(totalRates),
+			Seconds:       duration.Seconds(),
+		})
+	}
+
+	return nil
+}
+
+// printRunSummary prints a run's per-host table, slowest files and most
+// frequent errors to stderr.
+func printRunSummary(s *mrf.RunSummary) {
+	w := os.Stderr
+	fmt.Fprintf(w, "\n%-40s %6s %6s %6s %7s %10s %10s\n", "Host", "Files", "OK", "Failed", "Skipped", "Bytes", "Rates")
+	for _, h := range s.Hosts {
+		host := h.Host
+		if len(host) > 40 {
+			host = "..." + host[len(host)-37:]
+		}
+		fmt.Fprintf(w, "%-40s %6d %6d %6d %7d %10s %10s\n", host, h.Files, h.Succeeded, h.Failed, h.Skipped,
+			humanize.Bytes(uint64(h.Bytes)), humanize.Count(h.Rates))
+	}
+	if len(s.Slowest) > 0 {
+		fmt.Fprintln(w, "Slowest files:")
+		for _, f := range s.Slowest {
+			d := time.Duration(f.Seconds * float64(time.Second)).Truncate(time.Second)
+			fmt.Fprintf(w, "  %10s  %s\n", d, worker.FileNameFromURL(f.URL))
+		}
+	}
+	if len(s.Errors) > 0 {
+		fmt.Fprintln(w, "Errors:")
+		for _, e := range s.Errors {
+			fmt.Fprintf(w, "  %5d  %-20s e.g. %s\n", e.Count, e.Category, e.Example)
+			if e.Hint != "" {
+				fmt.Fprintf(w, "  %5s  %-20s %s\n", "", "", e.Hint)
+			}
+		}
+	}
+	fmt.Fprintln(w)
+}
+
+// reportPanic prints the stack trace of a file that failed with a recovered
+// panic, for inclusion in a bug report. Other results are ignored.
+func reportPanic(r worker.PipelineResult) {
+	var pe *mrf.PanicError
+	if !errors.As(r.Err, &pe) {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "\nInternal error while processing %s: %v\n%s\n", r.URL, pe.Value, pe.Stack)
+}
+
+// validRunID reports whether id can name the run's cloud resources and
+// paths: 1-40 letters, digits, '.', '_' or '-', not starting with '.' or '-'.
+func validRunID(id string) bool {
+	if id == "" || len(id) > 40 || id[0] == '.' || id[0] == '-' {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '.', c == '_', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// searchKey identifies a search's targets and filters, so a checkpoint is
+// only resumed by the search that wrote it.
+func searchKey(npis []int64, tins []string, groupIDs []int64, codes, codeTypes []string, collapse string, noBundles, provenance bool, minRate, maxRate float64, feeSchedule, codeDescs string) string {
+	npiStrs := make([]string, len(npis))
+	for i, n := range npis {
+		npiStrs[i] = strconv.FormatInt(n, 10)
+	}
+	sorted := func(vals []string) string {
+		vals = append([]string(nil), vals...)
+		sort.Strings(vals)
+		return strings.Join(vals, ",")
+	}
+	key := fmt.Sprintf("npi=%s tin=%s billing-code=%s billing-code-type=%s",
+		sorted(npiStrs), sorted(tins), sorted(codes), sorted(codeTypes))
+	if len(groupIDs) > 0 {
+		ids := slices.Sorted(slices.Values(groupIDs))
+		key += " provider-group-id=" + joinInts(ids)
+	}
+	if collapse != "none" {
+		key += " collapse-providers=" + collapse
+	}
+	if noBundles {
+		key += " no-bundles"
+	}
+	if provenance {
+		key += " provenance"
+	}
+	if minRate != 0 || maxRate != 0 {
+		key += fmt.Sprintf(" rate=%g..%g", minRate, maxRate)
+	}
+	if feeSchedule != "" {
+		key += " medicare-fee-schedule=" + feeSchedule
+	}
+	if codeDescs != "" {
+		key += " code-descriptions=" + codeDescs
+	}
+	return key
+}
+
+// parseNPISets parses --npi-set values, "name=npi,npi,...", into sets (with
+// no sinks yet) and returns them with the union of their NPIs.
+func parseNPISets(specs []string) ([]output.NPISet, []int64, error) {
+	var sets []output.NPISet
+	var all []int64
+	seen := make(map[int64]bool)
+	for _, spec := range specs {
+		name, list, ok := strings.Cut(spec, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, nil, fmt.Errorf("--npi-set %q: expected name=npi,npi,...", spec)
+		}
+		if strings.Trim(name, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_-") != "" {
+			return nil, nil, fmt.Errorf("--npi-set %q: name may only use letters, digits, '-' and '_'", spec)
+		}
+		for _, set := range sets {
+			if set.Name == name {
+				return nil, nil, fmt.Errorf("--npi-set: set %s given twice", name)
+			}
+		}
+		npis, err := parseNPIs(list)
+		if err != nil {
+			return nil, nil, fmt.Errorf("--npi-set %s: %w", name, err)
+		}
+		if len(npis) == 0 {
+			return nil, nil, fmt.Errorf("--npi-set %s: no NPIs", name)
+		}
+		sets = append(sets, output.NPISet{Name: name, NPIs: npis})
+		for _, n := range npis {
+			if !seen[n] {
+				seen[n] = true
+				all = append(all, n)
+			}
+		}
+	}
+	return sets, all, nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gyeh/npi-rates/internal/logx"
+	"github.com/gyeh/npi-rates/internal/mrf"
+	"github.com/gyeh/npi-rates/internal/output"
+)
+
+// sinkChain is the sink a local search writes its results to, with the
+// snapshot and dedup sinks wrapped around the outputs, if any.
+type sinkChain struct {
+	sink    output.Sink
+	outputs []string // where the results are written, for the run summary
+	snap    *output.SnapshotSink
+	dedup   *output.DedupSink
+}
+
+// buildSinkChain builds the outputs f asks for, split by NPI set if there
+// are npiSets, and checks that remote ones can be delivered to, so a bad
+// --output or --sink fails before any downloading. npis and groupIDs are
+// the search's targets, recorded in snapshots.
+func buildSinkChain(ctx context.Context, f *searchFlags, npiSets []output.NPISet, npis, groupIDs []int64) (*sinkChain, error) {
+	chain := &sinkChain{outputs: append([]string{f.outputFile}, f.sinkSpecs...)}
+	var aggOpts *output.AggregateOptions
+	if f.aggregate {
+		aggOpts = &output.AggregateOptions{MinCount: f.aggMinCount, Round: f.aggRound}
+	}
+	var err error
+	if len(npiSets) > 0 {
+		chain.sink, chain.outputs, err = buildNPISetSinks(npiSets, f.outputFile, f.outputFormat, aggOpts, f.sinkSpecs)
+	} else {
+		chain.sink, err = buildSinks(f.outputFile, f.outputFormat, aggOpts, f.sinkSpecs)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// The snapshot sits inside the dedup sink so it sees what the
+	// outputs will.
+	if f.snapEvery > 0 {
+		if f.outputFile == "-" || output.IsRemote(f.outputFile) {
+			return nil, fmt.Errorf("--snapshot-every needs a local --output file")
+		}
+		snapStart := time.Now()
+		chain.snap = output.NewSnapshotSink(chain.sink, output.SnapshotPath(f.outputFile), f.snapEvery, func() mrf.SearchParams {
+			return mrf.SearchParams{RunID: f.runID, NPIs: npis, TINs: f.tins, GroupIDs: groupIDs,
+				DurationSeconds: time.Since(snapStart).Seconds()}
+		})
+		chain.sink = chain.snap
+	}
+	if !f.noDedup {
+		if chain.dedup, err = output.NewDedupSink(chain.sink, f.dedupKey); err != nil {
+			return nil, fmt.Errorf("--dedup-key: %w", err)
+		}
+		chain.sink = chain.dedup
+	}
+	// A remote output that can't be delivered to should fail now,
+	// not after the search.
+	for _, p := range outputPaths(f.outputFile, f.sinkSpecs) {
+		if err := output.CheckRemote(ctx, p); err != nil {
+			return nil, fmt.Errorf("output: %w", err)
+		}
+	}
+	removeOrphanOutputs(f.outputFile, f.sinkSpecs)
+	return chain, nil
+}
+
+// buildSinks returns the sink for --output (in --format, if given, and
+// aggregated with --aggregate when aggregate is set) plus any --sink specs,
+// fanned out through a MultiSink when there is more than one.
+func buildSinks(outputFile, format string, aggregate *output.AggregateOptions, specs []string) (output.Sink, error) {
+	var primary output.Sink
+	var err error
+	if aggregate != nil {
+		primary, err = output.NewAggregateSink(format, outputFile, *aggregate)
+	} else if format != "" {
+		primary, err = output.NewSink(format + ":" + outputFile)
+	} else {
+		primary, err = output.NewFileSink(outputFile)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("--output: %w", err)
+	}
+	sinks := output.MultiSink{primary}
+	for _, spec := range specs {
+		s, err := output.NewSink(spec)
+		if err != nil {
+			return nil, fmt.Errorf("--sink: %w", err)
+		}
+		sinks = append(sinks, s)
+	}
+	if len(sinks) == 1 {
+		return primary, nil
+	}
+	return sinks, nil
+}
+
+// buildNPISetSinks gives each NPI set the outputs buildSinks would, with the
+// set's name in every path (see output.NPISetPath), and returns the sink
+// routing results to them with the outputs it writes.
+func buildNPISetSinks(sets []output.NPISet, outputFile, format string, aggregate *output.AggregateOptions, specs []string) (output.Sink, []string, error) {
+	if outputFile == "-" {
+		return nil, nil, fmt.Errorf("--npi-set writes a file per set; --output - is not supported")
+	}
+	var outputs []string
+	for i := range sets {
+		name := sets[i].Name
+		path := output.NPISetPath(outputFile, name)
+		setSpecs := make([]string, len(specs))
+		for j, spec := range specs {
+			setSpecs[j] = spec // a malformed spec fails in buildSinks
+			if kind, p, err := output.SplitSinkSpec(spec); err == nil && p != "" {
+				setSpecs[j] = kind + ":" + output.NPISetPath(p, name)
+			}
+		}
+		s, err := buildSinks(path, format, aggregate, setSpecs)
+		if err != nil {
+			return nil, nil, err
+		}
+		sets[i].Sink = s
+		outputs = append(append(outputs, path), setSpecs...)
+	}
+	return output.NewNPISetSink(sets), outputs, nil
+}
+
+// outputPaths returns the paths of --output and the --sink specs.
+func outputPaths(outputFile string, specs []string) []string {
+	paths := []string{outputFile}
+	for _, spec := range specs {
+		if _, path, ok := strings.Cut(spec, ":"); ok {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// removeOrphanOutputs deletes temp outputs left next to the configured
+// outputs by earlier runs that crashed before committing them.
+func removeOrphanOutputs(outputFile string, specs []string) {
+	seen := make(map[string]bool)
+	for _, p := range outputPaths(outputFile, specs) {
+		if p == "-" {
+			continue
+		}
+		dir := filepath.Dir(p)
+		if output.IsRemote(p) {
+			dir = output.UploadTmpDir()
+		}
+		if seen[dir] {
+			continue
+		}
+		seen[dir] = true
+		if n := output.RemoveOrphans(dir); n > 0 {
+			logx.Infof("Removed %d incomplete output file(s) from an earlier run in %s\n", n, dir)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+// Package cache keeps the on-disk caches (downloaded MRFs, kept split
+// output, NPPES lookups) within a size quota and a maximum age, so that
+// caching doesn't silently fill a disk. Each cache's owner lists its
+// entries; this package measures them and evicts the least recently used.
+package cache
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Entry is one cached item: every path that makes it up, removed together
+// and in order, so that the path a lookup checks first goes first.
+type Entry struct {
+	Name     string // what it caches, e.g. a URL
+	Paths    []string
+	Size     int64
+	LastUsed time.Time
+}
+
+// Store is one cache.
+type Store struct {
+	Name string // e.g. "downloads"
+	Dir  string
+	// Entries lists the cache's entries; a missing Dir is an empty cache.
+	Entries func(dir string) ([]Entry, error)
+}
+
+// Stats summarizes a store.
+type Stats struct {
+	Name    string    `json:"name"`
+	Dir     string    `json:"dir"`
+	Entries int       `json:"entries"`
+	Size    int64     `json:"size"`
+	Oldest  time.Time `json:"oldest,omitempty"` // least recently used
+	Newest  time.Time `json:"newest,omitempty"`
+	// Trash is what an interrupted gc left to delete; the next gc does.
+	Trash int64 `json:"trash,omitempty"`
+}
+
+// Stats measures s.
+func (s Store) Stats() (Stats, error) {
+	st := Stats{Name: s.Name, Dir: s.Dir}
+	entries, err := s.Entries(s.Dir)
+	if err != nil {
+		return st, err
+	}
+	st.Entries = len(entries)
+	for _, e := range entries {
+		st.Size += e.Size
+		if st.Oldest.IsZero() || e.LastUsed.Before(st.Oldest) {
+			st.Oldest = e.LastUsed
+		}
+		if e.LastUsed.After(st.Newest) {
+			st.Newest = e.LastUsed
+		}
+	}
+	for _, t := range trashDirs(s.Dir) {
+		st.Trash += TreeSize(t)
+	}
+	return st, nil
+}
+
+// Policy bounds a set of stores. Zero values don't limit.
+type Policy struct {
+	MaxSize int64         // across all the stores
+	MaxAge  time.Duration // since an entry was last used
+}
+
+// Eviction is an entry GC removed, or would remove on a dry run.
+type Eviction struct {
+	Store  string `json:"store"`
+	Entry  string `json:"entry"`
+	Size   int64  `json:"size"`
+	Reason string `json:"reason"` // "age" or "size"
+}
+
+// GC removes entries of stores last used more than p.MaxAge ago, then the
+// least recently used of the rest, across all stores, until they fit in
+// p.MaxSize. With dryRun set it only reports what it would remove.
+//
+// Removal is a soft delete: an entry's paths are first renamed into a
+// hidden trash dir, which makes it disappear from lookups at once, and the
+// trash is deleted after. A gc that is interrupted leaves trash, never a
+// half-removed entry; the next one deletes it.
+func GC(stores []Store, p Policy, now time.Time, dryRun bool) ([]Eviction, error) {
+	type owned struct {
+		store *Store
+		Entry
+	}
+	var all []owned
+	var total int64
+	for i := range stores {
+		s := &stores[i]
+		if !dryRun {
+			for _, t := range trashDirs(s.Dir) {
+				if pid, ok := trashPID(t); !ok || !processAlive(pid) {
+					os.RemoveAll(t)
+				}
+			}
+		}
+		entries, err := s.Entries(s.Dir)
+		if err != nil {
+			return nil, fmt.Errorf("%s cache: %w", s.Name, err)
+		}
+		for _, e := range entries {
+			all = append(all, owned{s, e})
+			total += e.Size
+		}
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].LastUsed.Before(all[j].LastUsed) })
+
+	var evicted []Eviction
+	trash := make(map[*Store]string)
+	for _, e := range all {
+		reason := ""
+		switch {
+		case p.MaxAge > 0 && now.Sub(e.LastUsed) > p.MaxAge:
+			reason = "age"
+		case p.MaxSize > 0 && total > p.MaxSize:
+			reason = "size"
+		default:
+			continue
+		}
+		if !dryRun {
+			dir, ok := trash[e.store]
+			if !ok {
+				dir = filepath.Join(e.store.Dir, trashPrefix+strconv.Itoa(os.Getpid()))
+				if err := os.MkdirAll(dir, 0o755); err != nil {
+					return evicted, fmt.Errorf("%s cache: %w", e.store.Name, err)
+				}
+				trash[e.store] = dir
+			}
+			if err := discard(e.Paths, dir); err != nil {
+				return evicted, fmt.Errorf("%s cache: removing %s: %w", e.store.Name, e.Name, err)
+			}
+		}
+		total -= e.Size
+		evicted = append(evicted, Eviction{Store: e.store.Name, Entry: e.Name, Size: e.Size, Reason: reason})
+	}
+	for _, dir := range trash {
+		os.RemoveAll(dir)
+	}
+	return evicted, nil
+}
+
+// trashPrefix names the dirs evicted entries are moved into before they
+// are deleted, followed by the gc's PID.
+const trashPrefix = ".trash-"
+
+// discard moves paths into trash, under unique names.
+func discard(paths []string, trash string) error {
+	for _, p := range paths {
+		f, err := os.CreateTemp(trash, filepath.Base(p)+".*")
+		if err != nil {
+			return err
+		}
+		f.Close()
+		os.Remove(f.Name())
+		if err := os.Rename(p, f.Name()); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func trashDirs(dir string) []string {
+	matches, _ := filepath.Glob(filepath.Join(dir, trashPrefix+"*"))
+	return matches
+}
+
+func trashPID(path string) (int, bool) {
+	pid, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(path), trashPrefix))
+	return pid, err == nil
+}
+
+func processAlive(pid int) bool {
+	if pid == os.Getpid() {
+		return false // an earlier gc of this process is over
+	}
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
+
+// TreeSize returns the total size of the files under path, for listing
+// entries that are directories.
+func TreeSize(path string) int64 {
+	var size int64
+	filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// Touch marks path as just used, for least-recently-used eviction. Errors
+// are ignored: a stale time only makes the entry an earlier candidate.
+func Touch(path string) {
+	now := time.Now()
+	os.Chtimes(path, now, now)
+}
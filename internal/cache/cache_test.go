@@ -0,0 +1,112 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fileEntries lists every file in dir as an entry, last used at its mtime.
+func fileEntries(dir string) ([]Entry, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	for _, f := range files {
+		info, err := f.Info()
+		if err != nil || f.IsDir() {
+			continue
+		}
+		entries = append(entries, Entry{
+			Name:     f.Name(),
+			Paths:    []string{filepath.Join(dir, f.Name())},
+			Size:     info.Size(),
+			LastUsed: info.ModTime(),
+		})
+	}
+	return entries, nil
+}
+
+func writeAged(t *testing.T, dir, name string, size int, age time.Duration, now time.Time) {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, make([]byte, size), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, now.Add(-age), now.Add(-age)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGC(t *testing.T) {
+	now := time.Now()
+	a, b := t.TempDir(), t.TempDir()
+	writeAged(t, a, "old", 100, 90*24*time.Hour, now)
+	writeAged(t, a, "lru", 100, 3*time.Hour, now)
+	writeAged(t, b, "mid", 100, 2*time.Hour, now)
+	writeAged(t, b, "new", 100, time.Hour, now)
+	stores := []Store{{Name: "a", Dir: a, Entries: fileEntries}, {Name: "b", Dir: b, Entries: fileEntries}}
+	policy := Policy{MaxSize: 250, MaxAge: 30 * 24 * time.Hour}
+
+	dry, err := GC(stores, policy, now, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	evicted, err := GC(stores, policy, now, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Eviction{
+		{Store: "a", Entry: "old", Size: 100, Reason: "age"},
+		{Store: "a", Entry: "lru", Size: 100, Reason: "size"},
+	}
+	for name, got := range map[string][]Eviction{"dry run": dry, "gc": evicted} {
+		if len(got) != len(want) {
+			t.Fatalf("%s: evicted %+v, want %+v", name, got, want)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("%s: eviction %d = %+v, want %+v", name, i, got[i], want[i])
+			}
+		}
+	}
+
+	for _, dir := range []string{a, b} {
+		if trash := trashDirs(dir); len(trash) > 0 {
+			t.Errorf("trash left behind: %v", trash)
+		}
+	}
+	st, err := stores[1].Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Entries != 2 || st.Size != 200 {
+		t.Errorf("store b after gc: %d entries, %d bytes; want 2, 200", st.Entries, st.Size)
+	}
+	if st, _ := stores[0].Stats(); st.Entries != 0 {
+		t.Errorf("store a after gc: %d entries, want 0", st.Entries)
+	}
+}
+
+// TestGCRemovesStaleTrash verifies that trash an interrupted gc left is
+// deleted by the next one, and counted by Stats until then.
+func TestGCRemovesStaleTrash(t *testing.T) {
+	dir := t.TempDir()
+	stale := filepath.Join(dir, trashPrefix+"999999999")
+	if err := os.MkdirAll(stale, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeAged(t, stale, "entry", 10, 0, time.Now())
+	s := Store{Name: "x", Dir: dir, Entries: fileEntries}
+	if st, _ := s.Stats(); st.Trash != 10 {
+		t.Errorf("Stats().Trash = %d, want 10", st.Trash)
+	}
+	if _, err := GC([]Store{s}, Policy{MaxAge: time.Hour}, time.Now(), false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("stale trash still there: %v", err)
+	}
+}
@@ -0,0 +1,222 @@
+// Package config reads the npi-rates config file, which sets defaults for
+// command-line flags so long invocations don't have to repeat them.
+//
+// The file is YAML. Top-level keys are flag names and apply to every
+// command that has the flag; a key naming a command (e.g. "search", or
+// "toc" then "resolve") holds flags for that command only; "profiles" holds
+// named sets of the same, selected with --profile:
+//
+//	tmp-dir: /mnt/scratch
+//	search:
+//	  workers: 6
+//	  header: ["Authorization: Bearer x"]
+//	profiles:
+//	  big:
+//	    search:
+//	      cloud: true
+//	      shards: 200
+//
+// Flag values are scalars or lists of scalars, in block ("- x") or flow
+// ("[x, y]") style; anchors and aliases may be used to share them.
+package config
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Setting is one flag value from the config file.
+type Setting struct {
+	Flag   string
+	Values []string // one per Set call: a scalar has one, a list one per item
+	// Scoped is set for settings under a command's own section, which must
+	// name one of its flags; top-level settings apply where the flag exists.
+	Scoped bool
+}
+
+// File is a parsed config file.
+type File struct {
+	Path string
+	root *node
+}
+
+// node is a YAML value: a map (keys in file order), a list or a scalar.
+type node struct {
+	keys   []string
+	fields map[string]*node
+	list   []string
+	scalar string
+	isList bool
+	line   int
+}
+
+func (n *node) isMap() bool { return n.fields != nil }
+
+// DefaultPath returns $XDG_CONFIG_HOME/npi-rates/config.yaml, or
+// ~/.config/npi-rates/config.yaml, or "" if there is no home directory.
+func DefaultPath() string {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "npi-rates", "config.yaml")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", "npi-rates", "config.yaml")
+}
+
+// Load reads and parses the config file at path.
+func Load(path string) (*File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var doc yaml.Node
+	if err := yaml.NewDecoder(f).Decode(&doc); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	root := &node{fields: make(map[string]*node)} // an empty file
+	if len(doc.Content) > 0 {
+		if root, err = convert(doc.Content[0]); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if !root.isMap() {
+			return nil, fmt.Errorf("%s: line %d: expected \"key: value\"", path, doc.Content[0].Line)
+		}
+	}
+	return &File{Path: path, root: root}, nil
+}
+
+// Settings returns the settings for the command at path (e.g. ["toc",
+// "resolve"]) in order of increasing precedence: top-level flags, the
+// command's sections, then the same from the named profile, if any.
+func (f *File) Settings(path []string, profile string) ([]Setting, error) {
+	var out []Setting
+	collect := func(scope *node) {
+		section, scoped := scope, false
+		for i := -1; i < len(path); i++ {
+			if i >= 0 {
+				next := section.fields[path[i]]
+				if next == nil || !next.isMap() {
+					return
+				}
+				section, scoped = next, true
+			}
+			for _, k := range section.keys {
+				v := section.fields[k]
+				if v.isMap() {
+					continue // another command's section, or profiles
+				}
+				s := Setting{Flag: k, Values: []string{v.scalar}, Scoped: scoped}
+				if v.isList {
+					s.Values = v.list
+				}
+				out = append(out, s)
+			}
+		}
+	}
+	collect(f.root)
+	if profile == "" {
+		return out, nil
+	}
+	profiles := f.root.fields["profiles"]
+	var p *node
+	if profiles != nil && profiles.isMap() {
+		p = profiles.fields[profile]
+	}
+	if p == nil || !p.isMap() {
+		return nil, fmt.Errorf("%s: no profile %q", f.Path, profile)
+	}
+	collect(p)
+	return out, nil
+}
+
+// Validate checks that every section names a command, as reported by
+// isCommand, so a misspelled one isn't silently ignored.
+func (f *File) Validate(isCommand func(path []string) bool) error {
+	var check func(n *node, path []string) error
+	check = func(n *node, path []string) error {
+		for _, k := range n.keys {
+			v := n.fields[k]
+			if !v.isMap() {
+				continue
+			}
+			if len(path) == 0 && k == "profiles" && n == f.root {
+				for _, name := range v.keys {
+					if p := v.fields[name]; p.isMap() {
+						if err := check(p, nil); err != nil {
+							return err
+						}
+					}
+				}
+				continue
+			}
+			sub := append(path[:len(path):len(path)], k)
+			if !isCommand(sub) {
+				return fmt.Errorf("%s: line %d: no command %q", f.Path, v.line, strings.Join(sub, " "))
+			}
+			if err := check(v, sub); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return check(f.root, nil)
+}
+
+// convert turns a parsed YAML value into a node. Flag values must be
+// scalars or lists of scalars.
+func convert(y *yaml.Node) (*node, error) {
+	if y.Kind == yaml.AliasNode {
+		return convert(y.Alias)
+	}
+	n := &node{line: y.Line}
+	switch y.Kind {
+	case yaml.MappingNode:
+		n.fields = make(map[string]*node)
+		for i := 0; i+1 < len(y.Content); i += 2 {
+			k := y.Content[i]
+			if k.Kind != yaml.ScalarNode || k.Value == "" {
+				return nil, fmt.Errorf("line %d: expected \"key: value\"", k.Line)
+			}
+			if _, dup := n.fields[k.Value]; dup {
+				return nil, fmt.Errorf("line %d: %q is set twice", k.Line, k.Value)
+			}
+			v, err := convert(y.Content[i+1])
+			if err != nil {
+				return nil, err
+			}
+			v.line = k.Line
+			n.keys = append(n.keys, k.Value)
+			n.fields[k.Value] = v
+		}
+	case yaml.SequenceNode:
+		n.isList, n.list = true, []string{}
+		for _, item := range y.Content {
+			if item.Kind == yaml.AliasNode {
+				item = item.Alias
+			}
+			if item.Kind != yaml.ScalarNode {
+				return nil, fmt.Errorf("line %d: list items must be plain values", item.Line)
+			}
+			n.list = append(n.list, scalar(item))
+		}
+	case yaml.ScalarNode:
+		n.scalar = scalar(y)
+	}
+	return n, nil
+}
+
+// scalar returns a scalar's text, with null ("key:", "~") as "".
+func scalar(y *yaml.Node) string {
+	if y.ShortTag() == "!!null" {
+		return ""
+	}
+	return y.Value
+}
@@ -0,0 +1,148 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// writeConfig writes content to a config file and returns its path.
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const testConfig = `# defaults for every command
+tmp-dir: /mnt/scratch
+header:
+  - "Authorization: Bearer x"
+  - 'X-Team: rates # not a comment'
+search:
+  workers: 6
+  header: [a, "b, c"]
+  empty:
+toc:
+  resolve:
+    workers: 2
+profiles:
+  big:
+    workers: &w 8
+    search:
+      cloud: true
+      shards: *w
+  empty: {}
+`
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name, content, err string
+	}{
+		{"valid", testConfig, ""},
+		{"empty file", "", ""},
+		{"only comments", "# nothing yet\n", ""},
+		{"document marker", "---\nworkers: 2\n", ""},
+		{"not a map", "- workers\n", "line 1: expected \"key: value\""},
+		{"duplicate key", "workers: 2\nworkers: 3\n", "line 2: \"workers\" is set twice"},
+		{"nested list", "header:\n  - [a, b]\n", "line 2: list items must be plain values"},
+		{"tab indent", "search:\n\tworkers: 2\n", "yaml:"},
+		{"bad indent", "search:\n  workers: 2\n    shards: 3\n", "yaml:"},
+		{"unterminated quote", "tmp-dir: \"/mnt\n", "yaml:"},
+	}
+	for _, tt := range tests {
+		path := writeConfig(t, tt.content)
+		_, err := Load(path)
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("%s: got %v, want an error containing %q", tt.name, err, tt.err)
+		case err != nil && !strings.HasPrefix(err.Error(), path+": "):
+			t.Errorf("%s: error %q doesn't name the file", tt.name, err)
+		}
+	}
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.yaml")); !os.IsNotExist(err) {
+		t.Errorf("expected a not-exist error, got %v", err)
+	}
+}
+
+func TestSettings(t *testing.T) {
+	f, err := Load(writeConfig(t, testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	top := []Setting{
+		{Flag: "tmp-dir", Values: []string{"/mnt/scratch"}},
+		{Flag: "header", Values: []string{"Authorization: Bearer x", "X-Team: rates # not a comment"}},
+	}
+	search := append(slices.Clone(top),
+		Setting{Flag: "workers", Values: []string{"6"}, Scoped: true},
+		Setting{Flag: "header", Values: []string{"a", "b, c"}, Scoped: true},
+		Setting{Flag: "empty", Values: []string{""}, Scoped: true},
+	)
+	tests := []struct {
+		path    []string
+		profile string
+		want    []Setting
+		err     string
+	}{
+		{nil, "", top, ""},
+		{[]string{"search"}, "", search, ""},
+		{[]string{"toc", "resolve"}, "", append(slices.Clone(top), Setting{Flag: "workers", Values: []string{"2"}, Scoped: true}), ""},
+		{[]string{"toc"}, "", top, ""},                 // resolve is a subcommand's section
+		{[]string{"search", "nested"}, "", search, ""}, // no section of its own
+		{[]string{"search"}, "big", append(slices.Clone(search),
+			Setting{Flag: "workers", Values: []string{"8"}},
+			Setting{Flag: "cloud", Values: []string{"true"}, Scoped: true},
+			Setting{Flag: "shards", Values: []string{"8"}, Scoped: true},
+		), ""},
+		{[]string{"search"}, "empty", search, ""},
+		{[]string{"search"}, "missing", nil, "no profile \"missing\""},
+	}
+	for _, tt := range tests {
+		got, err := f.Settings(tt.path, tt.profile)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Settings(%q, %q): got %v, want an error containing %q", tt.path, tt.profile, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Settings(%q, %q): %v", tt.path, tt.profile, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Settings(%q, %q) =\n%+v\nwant\n%+v", tt.path, tt.profile, got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	commands := map[string]bool{"search": true, "toc": true, "toc resolve": true}
+	isCommand := func(path []string) bool { return commands[strings.Join(path, " ")] }
+	tests := []struct {
+		name, content, err string
+	}{
+		{"valid", testConfig, ""},
+		{"misspelled command", "serach:\n  workers: 2\n", "line 1: no command \"serach\""},
+		{"misspelled subcommand", "toc:\n  reslove:\n    workers: 2\n", "line 2: no command \"toc reslove\""},
+		{"in a profile", "profiles:\n  p:\n    serach:\n      workers: 2\n", "line 3: no command \"serach\""},
+		{"profiles below the top", "search:\n  profiles:\n    p: {}\n", "no command \"search profiles\""},
+	}
+	for _, tt := range tests {
+		f, err := Load(writeConfig(t, tt.content))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		err = f.Validate(isCommand)
+		if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("%s: got %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+// Package httpx holds the HTTP clients every package that talks to the
+// network uses, so that they share one connection pool, honor the proxy
+// environment and dial through the same dialer (and so --resolve and
+// --ip-version), and so that timeouts are chosen by the kind of request
+// rather than by whoever wrote the call.
+package httpx
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// DialFunc dials a connection, as http.Transport.DialContext does.
+type DialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+var dial atomic.Pointer[DialFunc]
+
+func init() {
+	d := DialFunc((&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext)
+	dial.Store(&d)
+}
+
+// SetDialer makes the clients dial new connections with d. It is safe to
+// call while requests are in flight; pooled connections are kept.
+func SetDialer(d DialFunc) {
+	dial.Store(&d)
+}
+
+// Dial connects with the clients' dialer, so --resolve and --ip-version
+// apply to connections other than HTTP, such as SFTP.
+func Dial(ctx context.Context, network, address string) (net.Conn, error) {
+	return (*dial.Load())(ctx, network, address)
+}
+
+// Transport is shared by all the clients.
+var Transport = &http.Transport{
+	Proxy:               http.ProxyFromEnvironment, // HTTPS_PROXY, NO_PROXY
+	DialContext:         Dial,
+	MaxIdleConnsPerHost: 10,
+	MaxIdleConns:        100,
+	IdleConnTimeout:     90 * time.Second,
+	TLSHandshakeTimeout: 10 * time.Second,
+}
+
+// keptOnRedirect are the headers a request keeps when it is redirected to
+// another host: those describing the request itself rather than who sends
+// it.
+var keptOnRedirect = map[string]bool{
+	"Accept":            true,
+	"Accept-Encoding":   true,
+	"Content-Type":      true,
+	"If-Modified-Since": true,
+	"If-None-Match":     true,
+	"If-Range":          true,
+	"Range":             true,
+	"User-Agent":        true,
+}
+
+// checkRedirect is the clients' redirect policy: at most 10 redirects, as
+// by default, and a request redirected to another host than the one first
+// asked keeps only the keptOnRedirect headers. Go itself only drops
+// Authorization and cookies, not credentials sent under other names, such
+// as an API key header for a payer or an upload endpoint.
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	if req.URL.Host != via[0].URL.Host {
+		for name := range req.Header {
+			if !keptOnRedirect[name] {
+				delete(req.Header, name)
+			}
+		}
+	}
+	return nil
+}
+
+var (
+	// Short is for API calls and probes that return a small response at
+	// once: NPPES lookups, geolocation, size and version probes.
+	Short = &http.Client{Transport: Transport, CheckRedirect: checkRedirect, Timeout: 10 * time.Second}
+
+	// Long is for downloading whole files. Large files (50GB+) at slow CDN
+	// speeds can take over an hour.
+	Long = &http.Client{Transport: Transport, CheckRedirect: checkRedirect, Timeout: 3 * time.Hour}
+
+	// Streaming has no overall timeout; callers bound requests with their
+	// context. It is for bodies of unbounded size, such as result uploads,
+	// and requests with a deadline of their own, such as webhooks.
+	Streaming = &http.Client{Transport: Transport, CheckRedirect: checkRedirect}
+)
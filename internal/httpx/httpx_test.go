@@ -0,0 +1,75 @@
+package httpx
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSetDialer(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	prev := *dial.Load()
+	defer SetDialer(prev)
+
+	// Every client dials through the dialer set, here one that sends a
+	// made-up host to the test server.
+	var dials atomic.Int32
+	SetDialer(func(ctx context.Context, network, _ string) (net.Conn, error) {
+		dials.Add(1)
+		return (&net.Dialer{}).DialContext(ctx, network, srv.Listener.Addr().String())
+	})
+	Transport.CloseIdleConnections()
+	for _, c := range []*http.Client{Short, Long, Streaming} {
+		resp, err := c.Get("http://mrf.invalid/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	if dials.Load() == 0 {
+		t.Error("requests did not go through the dialer")
+	}
+}
+
+// TestRedirectHeaders verifies that a redirect to another host drops the
+// headers that may be credentials, and keeps the request's own.
+func TestRedirectHeaders(t *testing.T) {
+	var got http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/same":
+			http.Redirect(w, r, "/final", http.StatusFound)
+		case "/other":
+			// The same server under another name.
+			http.Redirect(w, r, "http://"+strings.Replace(r.Host, "127.0.0.1", "localhost", 1)+"/final", http.StatusFound)
+		default:
+			got = r.Header.Clone()
+		}
+	}))
+	defer srv.Close()
+
+	get := func(path string) {
+		t.Helper()
+		req, _ := http.NewRequest("GET", srv.URL+path, nil)
+		req.Header.Set("X-Api-Key", "secret")
+		req.Header.Set("Range", "bytes=10-")
+		resp, err := Short.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	get("/same")
+	if got.Get("X-Api-Key") != "secret" || got.Get("Range") != "bytes=10-" {
+		t.Errorf("headers after a same-host redirect: %v", got)
+	}
+	get("/other")
+	if got.Get("X-Api-Key") != "" || got.Get("Range") != "bytes=10-" {
+		t.Errorf("headers after a cross-host redirect: %v", got)
+	}
+}
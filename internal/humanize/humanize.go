@@ -0,0 +1,221 @@
+// Package humanize formats byte sizes, rates, and counts for log and
+// progress output. Separators follow the user's locale (LC_ALL, LC_NUMERIC,
+// or LANG), and raw mode switches every formatter to plain integers so logs
+// can be parsed by machines.
+package humanize
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// separators holds the digit-group and decimal separators for a locale.
+type separators struct {
+	group   string
+	decimal string
+}
+
+var (
+	english = separators{group: ",", decimal: "."}
+
+	// locales maps a language (or language_TERRITORY) to its number
+	// formatting. Territory-specific entries take precedence.
+	locales = map[string]separators{
+		"en":    english,
+		"ja":    english,
+		"ko":    english,
+		"zh":    english,
+		"de":    {group: ".", decimal: ","},
+		"es":    {group: ".", decimal: ","},
+		"it":    {group: ".", decimal: ","},
+		"nl":    {group: ".", decimal: ","},
+		"pt":    {group: ".", decimal: ","},
+		"da":    {group: ".", decimal: ","},
+		"fr":    {group: " ", decimal: ","},
+		"ru":    {group: " ", decimal: ","},
+		"pl":    {group: " ", decimal: ","},
+		"sv":    {group: " ", decimal: ","},
+		"fi":    {group: " ", decimal: ","},
+		"nb":    {group: " ", decimal: ","},
+		"de_CH": {group: "’", decimal: "."},
+		"fr_CH": {group: " ", decimal: "."},
+		"en_ZA": {group: " ", decimal: ","},
+	}
+
+	current atomic.Pointer[separators]
+	raw     atomic.Bool
+)
+
+func init() {
+	SetLocale(localeFromEnv())
+}
+
+// localeFromEnv returns the locale governing numeric formatting, following
+// POSIX precedence.
+func localeFromEnv() string {
+	for _, name := range []string{"LC_ALL", "LC_NUMERIC", "LANG"} {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// SetLocale selects separators for a POSIX locale name such as "de_DE.UTF-8".
+// Unknown locales, "C", and "POSIX" use English separators.
+func SetLocale(locale string) {
+	name, _, _ := strings.Cut(locale, ".")
+	name, _, _ = strings.Cut(name, "@")
+	seps := english
+	if s, ok := locales[name]; ok {
+		seps = s
+	} else if lang, _, _ := strings.Cut(name, "_"); lang != "" {
+		if s, ok := locales[lang]; ok {
+			seps = s
+		}
+	}
+	current.Store(&seps)
+}
+
+// SetRaw enables raw mode: sizes are printed as plain byte counts, rates as
+// bytes per second, and counts without separators.
+func SetRaw(enabled bool) {
+	raw.Store(enabled)
+}
+
+// Raw reports whether raw mode is enabled.
+func Raw() bool {
+	return raw.Load()
+}
+
+// Bytes formats a byte count using binary units (e.g. "1.5 GB"), or as a
+// plain integer in raw mode.
+func Bytes(b uint64) string {
+	if raw.Load() {
+		return strconv.FormatUint(b, 10)
+	}
+	const (
+		kb uint64 = 1024
+		mb        = 1024 * kb
+		gb        = 1024 * mb
+		tb        = 1024 * gb
+	)
+	switch {
+	case b >= tb:
+		return decimal(float64(b)/float64(tb)) + " TB"
+	case b >= gb:
+		return decimal(float64(b)/float64(gb)) + " GB"
+	case b >= mb:
+		return decimal(float64(b)/float64(mb)) + " MB"
+	case b >= kb:
+		return decimal(float64(b)/float64(kb)) + " KB"
+	default:
+		return fmt.Sprintf("%d B", b)
+	}
+}
+
+// SignedBytes is Bytes for int64 sizes; negative (unknown) sizes print as "?".
+func SignedBytes(b int64) string {
+	if b < 0 {
+		return "?"
+	}
+	return Bytes(uint64(b))
+}
+
+// Rate formats a transfer rate in bytes per second (e.g. "12.3 MB/s").
+func Rate(bytesPerSec float64) string {
+	if bytesPerSec < 0 {
+		bytesPerSec = 0
+	}
+	if raw.Load() {
+		return strconv.FormatUint(uint64(bytesPerSec), 10) + " B/s"
+	}
+	return Bytes(uint64(bytesPerSec)) + "/s"
+}
+
+// ParseBytes parses a size in the units Bytes prints (binary), such as
+// "512K", "20MB" or "1.5 GB"; a plain number is bytes. A trailing "/s" is
+// allowed, so rates read back too.
+func ParseBytes(s string) (int64, error) {
+	in := s
+	s = strings.ToUpper(strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(s), "/s")))
+	s = strings.TrimSuffix(s, "B")
+	mult := float64(1)
+	if n := len(s); n > 0 {
+		switch s[n-1] {
+		case 'K':
+			mult = 1 << 10
+		case 'M':
+			mult = 1 << 20
+		case 'G':
+			mult = 1 << 30
+		case 'T':
+			mult = 1 << 40
+		}
+		if mult > 1 {
+			s = s[:n-1]
+		}
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("invalid size %q (want e.g. 512K, 20MB or 1.5GB)", in)
+	}
+	return int64(f * mult), nil
+}
+
+// Count formats an integer with digit grouping (e.g. "1,234,567").
+func Count(n int64) string {
+	if raw.Load() {
+		return strconv.FormatInt(n, 10)
+	}
+	return group(strconv.FormatInt(n, 10), current.Load().group)
+}
+
+// Ratio formats a multiplier with one decimal place (e.g. "8.4x").
+func Ratio(f float64) string {
+	if raw.Load() {
+		return strconv.FormatFloat(f, 'f', 2, 64)
+	}
+	return decimal(f) + "x"
+}
+
+// decimal formats f with one decimal place and the locale's decimal mark.
+func decimal(f float64) string {
+	s := strconv.FormatFloat(f, 'f', 1, 64)
+	if d := current.Load().decimal; d != "." {
+		s = strings.Replace(s, ".", d, 1)
+	}
+	return s
+}
+
+// group inserts sep between each group of three digits of a formatted
+// integer.
+func group(digits, sep string) string {
+	neg := strings.HasPrefix(digits, "-")
+	if neg {
+		digits = digits[1:]
+	}
+	if len(digits) <= 3 {
+		if neg {
+			return "-" + digits
+		}
+		return digits
+	}
+	var b strings.Builder
+	if neg {
+		b.WriteByte('-')
+	}
+	lead := len(digits) % 3
+	if lead == 0 {
+		lead = 3
+	}
+	b.WriteString(digits[:lead])
+	for i := lead; i < len(digits); i += 3 {
+		b.WriteString(sep)
+		b.WriteString(digits[i : i+3])
+	}
+	return b.String()
+}
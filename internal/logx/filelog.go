@@ -0,0 +1,71 @@
+package logx
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// FileLog is a debug log for a single MRF file: download attempts, response
+// headers, retries, parse warnings and timing, written regardless of the
+// global level so one problematic file can be diagnosed after the fact. A nil
+// *FileLog discards everything.
+type FileLog struct {
+	mu    sync.Mutex
+	f     *os.File
+	start time.Time
+}
+
+// OpenFileLog creates (or truncates) the log at path.
+func OpenFileLog(path string) (*FileLog, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &FileLog{f: f, start: time.Now()}, nil
+}
+
+// Printf appends a line stamped with the wall clock and the time elapsed
+// since the log was opened.
+func (l *FileLog) Printf(format string, args ...any) {
+	if l == nil {
+		return
+	}
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fmt.Fprintf(l.f, "%s +%-8s %s%s\n", now.Format("15:04:05.000"),
+		now.Sub(l.start).Truncate(time.Millisecond), runTag(), fmt.Sprintf(format, args...))
+}
+
+// Close closes the log file.
+func (l *FileLog) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Close()
+}
+
+type fileLogKey struct{}
+
+// WithFileLog returns a context whose Debugf calls also go to l.
+func WithFileLog(ctx context.Context, l *FileLog) context.Context {
+	return context.WithValue(ctx, fileLogKey{}, l)
+}
+
+// FileLogFrom returns the context's file log, or nil.
+func FileLogFrom(ctx context.Context) *FileLog {
+	l, _ := ctx.Value(fileLogKey{}).(*FileLog)
+	return l
+}
+
+// Debugf is Verbosef that also records the line in the context's file log,
+// if any.
+func Debugf(ctx context.Context, format string, args ...any) {
+	FileLogFrom(ctx).Printf(format, args...)
+	Verbosef(format, args...)
+}
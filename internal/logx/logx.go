@@ -0,0 +1,87 @@
+// Package logx controls how chatty the CLI is on stderr. Informational output
+// goes through Infof, which --quiet suppresses; diagnostic detail goes through
+// Verbosef, which only --verbose enables. Errors, prompts, and final summaries
+// are printed directly and are not affected.
+package logx
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// Level is an output verbosity level.
+type Level int32
+
+const (
+	Quiet   Level = -1
+	Normal  Level = 0
+	Verbose Level = 1
+)
+
+var level atomic.Int32
+
+var runID atomic.Value // string
+
+// NewRunID returns a random run ID: the start date and time followed by
+// random hex, so IDs sort by start and concurrent runs don't collide.
+func NewRunID() string {
+	var b [3]byte
+	rand.Read(b[:])
+	return time.Now().Format("20060102-150405") + "-" + hex.EncodeToString(b[:])
+}
+
+// SetRunID sets the ID of the current run, which then tags diagnostic and
+// per-file log lines.
+func SetRunID(id string) {
+	runID.Store(id)
+}
+
+// RunID returns the ID set with SetRunID, or "".
+func RunID() string {
+	id, _ := runID.Load().(string)
+	return id
+}
+
+// runTag is the run ID in brackets followed by a space, or "" if unset.
+func runTag() string {
+	if id := RunID(); id != "" {
+		return "[" + id + "] "
+	}
+	return ""
+}
+
+// SetLevel sets the process-wide verbosity.
+func SetLevel(l Level) {
+	level.Store(int32(l))
+}
+
+// IsQuiet reports whether informational output is suppressed.
+func IsQuiet() bool {
+	return Level(level.Load()) <= Quiet
+}
+
+// IsVerbose reports whether diagnostic output is enabled.
+func IsVerbose() bool {
+	return Level(level.Load()) >= Verbose
+}
+
+// Infof prints to stderr unless quiet. Like fmt.Printf, no newline is added.
+func Infof(format string, args ...any) {
+	if IsQuiet() {
+		return
+	}
+	fmt.Fprintf(os.Stderr, format, args...)
+}
+
+// Verbosef prints a timestamped diagnostic line to stderr when verbose.
+func Verbosef(format string, args ...any) {
+	if !IsVerbose() {
+		return
+	}
+	ts := time.Now().Format("15:04:05.000")
+	fmt.Fprintf(os.Stderr, "%s %s[debug] %s\n", ts, runTag(), fmt.Sprintf(format, args...))
+}
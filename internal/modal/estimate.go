@@ -0,0 +1,72 @@
+package modal
+
+import "time"
+
+// Shard resources and Modal's list prices, for cost estimates. The resources
+// mirror the defaults in python/deploy_modal.py; keep them in sync.
+const (
+	ShardCPU      = 2    // physical cores per shard container
+	ShardMemoryMB = 4096 // memory per shard container
+
+	CPUPricePerCoreSecond = 0.0000131  // USD
+	MemPricePerGiBSecond  = 0.00000222 // USD
+)
+
+// Throughput assumptions behind the estimate: each worker in a shard streams
+// this many compressed bytes a second through download, gunzip and parse, and
+// each shard container spends shardStartup starting and reporting back.
+// Payer CDNs vary widely, so treat estimates as order-of-magnitude.
+const (
+	workerBytesPerSecond = 20 << 20
+	shardStartup         = 30 * time.Second
+)
+
+// CostEstimate is the projected cost of a cloud search.
+type CostEstimate struct {
+	Files      int
+	SizedFiles int   // files whose size HEAD reported; the rest count as the average
+	Bytes      int64 // compressed, including the extrapolated sizes
+
+	Shards    int
+	TaskHours float64       // container-hours summed over shards
+	WallTime  time.Duration // longest shard
+	Cost      float64       // USD, compute only; Modal does not bill ingress
+}
+
+// EstimateCost projects the cost of searching files of the given compressed
+// sizes (0 where unknown) split into shards as plan (see PlanShards) lists
+// them, each shard running workersPerShard workers (0 for one per CPU).
+// Without any known size there is nothing to go on and ok is false.
+func EstimateCost(sizes []int64, plan [][]int, workersPerShard int) (est CostEstimate, ok bool) {
+	est.Files = len(sizes)
+	for _, s := range sizes {
+		if s > 0 {
+			est.SizedFiles++
+		}
+	}
+	if est.SizedFiles == 0 || len(plan) == 0 {
+		return est, false
+	}
+	if workersPerShard <= 0 {
+		workersPerShard = ShardCPU
+	}
+	sizes = estimatedSizes(sizes)
+	est.Shards = len(plan)
+
+	var total time.Duration
+	for _, files := range plan {
+		var b int64
+		for _, f := range files {
+			b += sizes[f]
+		}
+		est.Bytes += b
+		rate := float64(workerBytesPerSecond * min(workersPerShard, len(files)))
+		d := shardStartup + time.Duration(float64(b)/rate*float64(time.Second))
+		total += d
+		est.WallTime = max(est.WallTime, d)
+	}
+	est.TaskHours = total.Hours()
+	perSecond := ShardCPU*CPUPricePerCoreSecond + ShardMemoryMB/1024.0*MemPricePerGiBSecond
+	est.Cost = total.Seconds() * perSecond
+	return est, true
+}
@@ -0,0 +1,81 @@
+package modal
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestEstimateCost(t *testing.T) {
+	const mib = 1 << 20
+	perSecond := ShardCPU*CPUPricePerCoreSecond + ShardMemoryMB/1024.0*MemPricePerGiBSecond
+
+	tests := []struct {
+		name     string
+		sizes    []int64
+		plan     [][]int
+		workers  int
+		ok       bool
+		want     CostEstimate
+		shardDur []time.Duration // per shard, to derive TaskHours and Cost
+	}{
+		{
+			name:  "unknown size counts as the average",
+			sizes: []int64{100 * mib, 0, 300 * mib},
+			plan:  [][]int{{0, 1}, {2}},
+			ok:    true,
+			want:  CostEstimate{Files: 3, SizedFiles: 2, Bytes: 600 * mib, Shards: 2, WallTime: 45 * time.Second},
+			// 300 MiB at two workers' 40 MiB/s, and 300 MiB at one worker's 20 MiB/s.
+			shardDur: []time.Duration{37500 * time.Millisecond, 45 * time.Second},
+		},
+		{
+			name:     "workers beyond the shard's files don't help",
+			sizes:    []int64{200 * mib},
+			plan:     [][]int{{0}},
+			workers:  8,
+			ok:       true,
+			want:     CostEstimate{Files: 1, SizedFiles: 1, Bytes: 200 * mib, Shards: 1, WallTime: 40 * time.Second},
+			shardDur: []time.Duration{40 * time.Second},
+		},
+		{
+			name:     "more workers per shard",
+			sizes:    []int64{200 * mib, 200 * mib, 200 * mib, 200 * mib},
+			plan:     [][]int{{0, 1, 2, 3}},
+			workers:  4,
+			ok:       true,
+			want:     CostEstimate{Files: 4, SizedFiles: 4, Bytes: 800 * mib, Shards: 1, WallTime: 40 * time.Second},
+			shardDur: []time.Duration{40 * time.Second},
+		},
+		{
+			name:  "no known size",
+			sizes: []int64{0, 0},
+			plan:  [][]int{{0, 1}},
+			want:  CostEstimate{Files: 2},
+		},
+		{
+			name:  "no plan",
+			sizes: []int64{100 * mib},
+			want:  CostEstimate{Files: 1, SizedFiles: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := EstimateCost(tt.sizes, tt.plan, tt.workers)
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			var total time.Duration
+			for _, d := range tt.shardDur {
+				total += d
+			}
+			want := tt.want
+			want.TaskHours = total.Hours()
+			want.Cost = total.Seconds() * perSecond
+			if got.Files != want.Files || got.SizedFiles != want.SizedFiles || got.Bytes != want.Bytes ||
+				got.Shards != want.Shards || got.WallTime != want.WallTime ||
+				math.Abs(got.TaskHours-want.TaskHours) > 1e-9 || math.Abs(got.Cost-want.Cost) > 1e-9 {
+				t.Errorf("EstimateCost = %+v\nwant           %+v", got, want)
+			}
+		})
+	}
+}
@@ -5,27 +5,50 @@ package modal
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/gyeh/npi-rates/internal/humanize"
+	"github.com/gyeh/npi-rates/internal/logx"
+	"github.com/gyeh/npi-rates/internal/progress"
+	"github.com/gyeh/npi-rates/internal/worker"
 )
 
 // Config holds configuration for a Modal-based distributed search.
 type Config struct {
+	RunID           string // names the Modal app and shard work dirs; tags shard logs and output
 	NPI             string
 	URLsFile        string   // path to URLs file (already exists on disk)
 	URLs            []string // if set, written to temp file
 	OutputFile      string
 	Shards          int
+	ShardURLs       [][]string // URL lines (with any mirrors) per shard, e.g. from PlanShards; overrides Shards
 	WorkersPerShard int
+	TaskRetries     int              // re-runs of a failed shard before the search fails
+	RerunMissing    int              // further rounds for shards still failing once the rest are done
+	AllowPartial    bool             // write the other shards' results, marked incomplete, if shards are missing
+	Headers         []string         // extra request headers ("Name: value"), passed to shards by environment
+	Secrets         []string         // Modal secrets attached to the shard function
+	SearchArgs      []string         // extra search flags forwarded to every shard
+	NoDedup         bool             // keep duplicate results when merging shard outputs
+	DedupKey        string           // result fields identifying a duplicate; "" for all
+	Progress        progress.Manager // renders the run's phases; nil for none
 }
 
 // RunSearch executes a distributed search by shelling out to `modal run python/deploy_modal.py`.
 func RunSearch(ctx context.Context, cfg Config) error {
+	mgr := cfg.Progress
+	if mgr == nil {
+		mgr = &progress.NoopManager{Quiet: true}
+	}
+
 	// Resolve URLs file: use existing file or write URLs to a temp file
 	urlsFile := cfg.URLsFile
 	if urlsFile == "" && len(cfg.URLs) > 0 {
@@ -53,29 +76,115 @@ func RunSearch(ctx context.Context, cfg Config) error {
 		return err
 	}
 
-	args := []string{
-		"run", scriptPath,
+	args := []string{"run"}
+	if logx.IsQuiet() {
+		args = append(args, "--quiet") // hide modal's own progress indicators
+	}
+	args = append(args,
+		scriptPath,
 		"--npi", cfg.NPI,
 		"--urls-file", urlsFile,
 		"--shards", strconv.Itoa(cfg.Shards),
 		"--workers", strconv.Itoa(cfg.WorkersPerShard),
+		"--task-retries", strconv.Itoa(cfg.TaskRetries),
+	)
+	if cfg.RunID != "" {
+		args = append(args, "--run-id", cfg.RunID)
+	}
+	if cfg.RerunMissing > 0 {
+		args = append(args, "--rerun-missing", strconv.Itoa(cfg.RerunMissing))
+	}
+	if cfg.AllowPartial {
+		args = append(args, "--allow-partial")
+	}
+	if len(cfg.Secrets) > 0 {
+		args = append(args, "--secrets", strings.Join(cfg.Secrets, ","))
+	}
+	if len(cfg.ShardURLs) > 0 {
+		f, err := os.CreateTemp("", "npi-shards-*.json")
+		if err != nil {
+			return fmt.Errorf("creating temp shards file: %w", err)
+		}
+		defer os.Remove(f.Name())
+		err = json.NewEncoder(f).Encode(cfg.ShardURLs)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fmt.Errorf("writing temp shards file: %w", err)
+		}
+		args = append(args, "--shards-file", f.Name())
+	}
+	if len(cfg.SearchArgs) > 0 {
+		args = append(args, "--extra-args", shellJoin(cfg.SearchArgs))
+	}
+	if cfg.NoDedup {
+		args = append(args, "--no-dedup")
+	} else if cfg.DedupKey != "" {
+		args = append(args, "--dedup-key", cfg.DedupKey)
+	}
+
+	// deploy_modal.py writes the merged results to a file, so for stdout
+	// output collect them in a temp file and copy it out afterwards.
+	outputFile := cfg.OutputFile
+	if outputFile == "-" {
+		f, err := os.CreateTemp("", "npi-results-*.json")
+		if err != nil {
+			return fmt.Errorf("creating temp output file: %w", err)
+		}
+		f.Close()
+		outputFile = f.Name()
+		defer os.Remove(outputFile)
 	}
-	if cfg.OutputFile != "" {
-		args = append(args, "--output", cfg.OutputFile)
+	if outputFile != "" {
+		args = append(args, "--output", outputFile)
 	}
 
 	logf("Running: modal %s", strings.Join(args, " "))
 	start := time.Now()
 
 	cmd := exec.CommandContext(ctx, "modal", args...)
+	// Headers can carry credentials, so they go by environment rather than
+	// on the logged command line; deploy_modal.py forwards the variable (and
+	// any proxy settings) to the shards.
+	if len(cfg.Headers) > 0 {
+		lines := append(worker.EnvHeaderLines(), cfg.Headers...)
+		cmd.Env = append(os.Environ(), worker.HeadersEnv+"="+strings.Join(lines, "\n"))
+	}
 	cmd.Stderr = os.Stderr
-	cmd.Stdout = os.Stdout
+	// modal's own status output goes to stderr; stdout is reserved for the
+	// result document when OutputFile is "-".
+	cmd.Stdout = os.Stderr
 
+	shards := cfg.Shards
+	if len(cfg.ShardURLs) > 0 {
+		shards = len(cfg.ShardURLs)
+	}
+	phase := mgr.StartPhase(fmt.Sprintf("Modal search across %d shards", shards))
 	if err := cmd.Run(); err != nil {
+		phase.Done(err)
 		return fmt.Errorf("modal run failed: %w", err)
 	}
+	phase.Done(nil)
 
 	logf("modal run completed in %.1fs", time.Since(start).Seconds())
+
+	if cfg.OutputFile == "-" {
+		phase := mgr.StartPhase("Collecting results")
+		f, err := os.Open(outputFile)
+		if err != nil {
+			phase.Done(err)
+			return fmt.Errorf("reading merged results: %w", err)
+		}
+		defer f.Close()
+		n, err := io.Copy(os.Stdout, f)
+		if err != nil {
+			phase.Done(err)
+			return fmt.Errorf("writing results to stdout: %w", err)
+		}
+		phase.Update(humanize.Bytes(uint64(n)))
+		phase.Done(nil)
+	}
 	return nil
 }
 
@@ -99,7 +208,19 @@ func findScript(name string) (string, error) {
 	return "", fmt.Errorf("%s not found in working directory or any parent", name)
 }
 
+// shellJoin quotes args so that Python's shlex.split recovers them exactly.
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		quoted[i] = "'" + strings.ReplaceAll(a, "'", `'"'"'`) + "'"
+	}
+	return strings.Join(quoted, " ")
+}
+
 func logf(format string, args ...any) {
+	if logx.IsQuiet() {
+		return
+	}
 	ts := time.Now().Format("15:04:05")
 	fmt.Fprintf(os.Stderr, "%s %s\n", ts, fmt.Sprintf(format, args...))
 }
@@ -0,0 +1,80 @@
+package modal
+
+import "sort"
+
+// PlanShards splits files of the given compressed sizes (0 where unknown)
+// into at most shards shards of about equal total size, returning each
+// shard's file indexes in their original order. Payer files range from
+// megabytes to tens of gigabytes, so splitting by count leaves some shards
+// running long after the rest are done.
+//
+// Files are placed largest first, each on the shard with the least so far.
+// Unknown sizes count as the average known size. With maxShardBytes > 0,
+// more shards are added as needed so that no shard exceeds it, except a
+// shard holding a single larger file.
+func PlanShards(sizes []int64, shards int, maxShardBytes int64) [][]int {
+	if len(sizes) == 0 || shards <= 0 {
+		return nil
+	}
+	est := estimatedSizes(sizes)
+	var total int64
+	for _, s := range est {
+		total += s
+	}
+	n := min(shards, len(sizes))
+	if maxShardBytes > 0 {
+		n = min(max(n, int((total+maxShardBytes-1)/maxShardBytes)), len(sizes))
+	}
+
+	order := make([]int, len(sizes))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return est[order[a]] > est[order[b]] })
+
+	plan := make([][]int, n)
+	bytes := make([]int64, n)
+	for _, f := range order {
+		least := 0
+		for i := range bytes {
+			if bytes[i] < bytes[least] {
+				least = i
+			}
+		}
+		if maxShardBytes > 0 && len(plan[least]) > 0 && bytes[least]+est[f] > maxShardBytes {
+			plan = append(plan, nil)
+			bytes = append(bytes, 0)
+			least = len(plan) - 1
+		}
+		plan[least] = append(plan[least], f)
+		bytes[least] += est[f]
+	}
+	for _, p := range plan {
+		sort.Ints(p)
+	}
+	return plan
+}
+
+// estimatedSizes fills in unknown sizes with the average known size, or 1
+// if none is known, so that files are then spread evenly by count.
+func estimatedSizes(sizes []int64) []int64 {
+	var known, n int64
+	for _, s := range sizes {
+		if s > 0 {
+			known += s
+			n++
+		}
+	}
+	avg := int64(1)
+	if n > 0 {
+		avg = known / n
+	}
+	est := make([]int64, len(sizes))
+	for i, s := range sizes {
+		if s <= 0 {
+			s = avg
+		}
+		est[i] = s
+	}
+	return est
+}
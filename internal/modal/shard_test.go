@@ -0,0 +1,39 @@
+package modal
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPlanShards(t *testing.T) {
+	tests := []struct {
+		name          string
+		sizes         []int64
+		shards        int
+		maxShardBytes int64
+		want          [][]int
+	}{
+		{"empty", nil, 4, 0, nil},
+		{"no shards", []int64{1}, 0, 0, nil},
+		{"fewer files than shards", []int64{5, 3}, 4, 0, [][]int{{0}, {1}}},
+		// 10 alone, then 6+3+2 and 5+4, each shard in file order.
+		{"largest first onto the emptiest", []int64{3, 10, 4, 6, 5, 2}, 3, 0, [][]int{{1}, {0, 3, 5}, {2, 4}}},
+		// The unknown sizes count as the average known size, 5.
+		{"unknown sizes", []int64{0, 8, 2, 0}, 2, 0, [][]int{{1, 2}, {0, 3}}},
+		{"no known sizes spread by count", []int64{0, 0, 0, 0}, 2, 0, [][]int{{0, 2}, {1, 3}}},
+		// 30 bytes under a 10-byte cap needs at least three shards.
+		{"cap adds shards", []int64{5, 5, 5, 5, 5, 5}, 1, 10, [][]int{{0, 3}, {1, 4}, {2, 5}}},
+		// Two shards would do by total, but 8+8 is over the cap.
+		{"cap adds a shard while placing", []int64{8, 8, 8}, 1, 12, [][]int{{0}, {1}, {2}}},
+		// A file over the cap gets a shard to itself.
+		{"file over the cap", []int64{25, 1, 1}, 1, 20, [][]int{{0}, {1, 2}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := PlanShards(tt.sizes, tt.shards, tt.maxShardBytes)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PlanShards(%v, %d, %d) = %v, want %v", tt.sizes, tt.shards, tt.maxShardBytes, got, tt.want)
+			}
+		})
+	}
+}
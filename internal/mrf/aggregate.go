@@ -0,0 +1,100 @@
+package mrf
+
+import "sort"
+
+// RateAggregate summarizes the negotiated rates one provider has for one
+// billing code in one billing class and setting. Rates in different units
+// are never pooled: each unit gets its own aggregate.
+type RateAggregate struct {
+	NPI                    int64  `json:"npi"`
+	BillingCodeType        string `json:"billing_code_type"`
+	BillingCode            string `json:"billing_code"`
+	BillingCodeDescription string `json:"billing_code_description"`
+	BillingClass           string `json:"billing_class"`
+	Setting                string `json:"setting"`
+	Unit                   string `json:"unit"`
+	RateDistribution
+}
+
+// AggregateOutput is the JSON document written in aggregate mode.
+type AggregateOutput struct {
+	SearchParams SearchParams    `json:"search_params"`
+	Aggregates   []RateAggregate `json:"aggregates"`
+}
+
+type aggregateKey struct {
+	npi                                  int64
+	codeType, code, class, setting, unit string
+}
+
+// Aggregator folds RateResults into RateAggregates. It is not safe for
+// concurrent use.
+type Aggregator struct {
+	m map[aggregateKey]*RateAggregate
+}
+
+// NewAggregator returns an empty Aggregator.
+func NewAggregator() *Aggregator {
+	return &Aggregator{m: make(map[aggregateKey]*RateAggregate)}
+}
+
+// Add counts r's rate towards each of its NPIs (all of them, for a result
+// collapsed per provider group).
+func (a *Aggregator) Add(r *RateResult) {
+	npis := r.NPIs
+	if len(npis) == 0 {
+		npis = []int64{r.NPI}
+	}
+	unit := r.Unit
+	if unit == "" {
+		unit = UnitFor(r.NegotiatedType)
+	}
+	for _, npi := range npis {
+		k := aggregateKey{npi, r.BillingCodeType, r.BillingCode, r.BillingClass, r.Setting, unit}
+		agg := a.m[k]
+		if agg == nil {
+			agg = &RateAggregate{
+				NPI:                    npi,
+				BillingCodeType:        r.BillingCodeType,
+				BillingCode:            r.BillingCode,
+				BillingCodeDescription: r.BillingCodeDescription,
+				BillingClass:           r.BillingClass,
+				Setting:                r.Setting,
+				Unit:                   unit,
+			}
+			a.m[k] = agg
+		}
+		agg.add(r.NegotiatedRate)
+	}
+}
+
+// Len returns the number of aggregates so far.
+func (a *Aggregator) Len() int { return len(a.m) }
+
+// Aggregates returns the aggregates ordered by NPI, billing code, class,
+// setting and unit, computing their medians. The Aggregator must not be
+// added to afterwards.
+func (a *Aggregator) Aggregates() []RateAggregate {
+	out := make([]RateAggregate, 0, len(a.m))
+	for _, agg := range a.m {
+		agg.finish()
+		out = append(out, *agg)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		x, y := &out[i], &out[j]
+		switch {
+		case x.NPI != y.NPI:
+			return x.NPI < y.NPI
+		case x.BillingCodeType != y.BillingCodeType:
+			return x.BillingCodeType < y.BillingCodeType
+		case x.BillingCode != y.BillingCode:
+			return x.BillingCode < y.BillingCode
+		case x.BillingClass != y.BillingClass:
+			return x.BillingClass < y.BillingClass
+		case x.Setting != y.Setting:
+			return x.Setting < y.Setting
+		}
+		return x.Unit < y.Unit
+	})
+	return out
+}
@@ -0,0 +1,64 @@
+package mrf
+
+import "testing"
+
+func TestAggregator(t *testing.T) {
+	a := NewAggregator()
+	add := func(npi int64, rate float64, typ string) {
+		a.Add(&RateResult{NPI: npi, BillingCodeType: "CPT", BillingCode: "99213", BillingClass: "professional",
+			Setting: "outpatient", NegotiatedRate: rate, NegotiatedType: typ})
+	}
+	add(2, 100, "negotiated")
+	add(2, 300, "negotiated")
+	add(2, 120, "negotiated")
+	add(2, 80, "percentage") // a different unit, never pooled with dollars
+	add(1, 50, "negotiated")
+	// A result collapsed per provider group counts for each of its NPIs.
+	a.Add(&RateResult{NPI: 1, NPIs: []int64{1, 2}, BillingCodeType: "CPT", BillingCode: "99213",
+		BillingClass: "professional", Setting: "outpatient", NegotiatedRate: 60, NegotiatedType: "negotiated"})
+
+	got := a.Aggregates()
+	if len(got) != 3 {
+		t.Fatalf("expected 3 aggregates, got %d: %+v", len(got), got)
+	}
+	want := []struct {
+		npi              int64
+		unit             string
+		count            int64
+		min, median, max float64
+	}{
+		{1, UnitUSD, 2, 50, 55, 60},
+		{2, UnitUSD, 4, 60, 110, 300},
+		{2, UnitPercent, 1, 80, 80, 80},
+	}
+	for i, w := range want {
+		g := got[i]
+		if g.NPI != w.npi || g.Unit != w.unit || g.Count != w.count || g.Min != w.min || g.Median != w.median || g.Max != w.max {
+			t.Errorf("aggregate %d: got %+v, want %+v", i, g, w)
+		}
+	}
+}
+
+func TestAggregator_ApproximateMedian(t *testing.T) {
+	median := func(n int) RateAggregate {
+		a := NewAggregator()
+		for i := range n {
+			a.Add(&RateResult{NPI: 1, BillingCode: "99213", NegotiatedRate: float64(i % 1000), NegotiatedType: "negotiated"})
+		}
+		return a.Aggregates()[0]
+	}
+	if g := median(rateSampleSize); g.MedianApproximate || g.Median != 499.5 {
+		t.Errorf("expected the exact median 499.5 at the sample size, got %+v", g.RateDistribution)
+	}
+	g := median(rateSampleSize * 3)
+	if !g.MedianApproximate || g.Count != rateSampleSize*3 {
+		t.Fatalf("expected an approximate median of %d rates, got %+v", rateSampleSize*3, g.RateDistribution)
+	}
+	if g.Median < 480 || g.Median > 520 {
+		t.Errorf("approximate median %g is far from 499.5", g.Median)
+	}
+	// The sample is seeded, so the same rates in the same order agree.
+	if again := median(rateSampleSize * 3); again.Median != g.Median {
+		t.Errorf("approximate median changed between runs: %g, then %g", g.Median, again.Median)
+	}
+}
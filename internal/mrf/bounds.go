@@ -0,0 +1,36 @@
+package mrf
+
+import "sync/atomic"
+
+// RateCounts counts, across the files of a search, the results dropped for
+// a price outside SearchOptions.MinRate and MaxRate and the results kept
+// with a zero price. It is safe for concurrent use.
+type RateCounts struct {
+	dropped, zero atomic.Int64
+}
+
+// Dropped returns how many results have been dropped for an out-of-bounds
+// price.
+func (c *RateCounts) Dropped() int64 {
+	return c.dropped.Load()
+}
+
+// Zero returns how many results have been kept with a zero price.
+func (c *RateCounts) Zero() int64 {
+	return c.zero.Load()
+}
+
+// rateInBounds reports whether a result with this price should be emitted,
+// counting it if not, or if the price is zero.
+func (s *search) rateInBounds(rate float64) bool {
+	if rate < s.MinRate || (s.MaxRate > 0 && rate > s.MaxRate) {
+		if s.Counts != nil {
+			s.Counts.dropped.Add(1)
+		}
+		return false
+	}
+	if rate == 0 && s.Counts != nil {
+		s.Counts.zero.Add(1)
+	}
+	return true
+}
@@ -0,0 +1,70 @@
+package mrf
+
+import "sync"
+
+// streamBudget caps the raw in_network bytes queued for or being parsed by
+// the streaming workers of every file at once; see SetMaxStreamMemory.
+var streamBudget = &byteBudget{}
+
+// SetMaxStreamMemory caps the raw JSON held between the streaming decoder
+// and its parse workers, across all files, at n bytes. When the cap is
+// reached the decoder waits, which in turn stops reading the download. An
+// element larger than the cap is still parsed, alone. n <= 0 removes the
+// cap.
+func SetMaxStreamMemory(n int64) {
+	streamBudget.setMax(n)
+}
+
+// byteBudget is a FIFO counting semaphore over bytes.
+type byteBudget struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	max     int64 // 0: unlimited
+	used    int64
+	next    uint64 // ticket for the next acquire
+	serving uint64 // ticket allowed to acquire next
+}
+
+func (b *byteBudget) setMax(n int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.max = max(n, 0)
+	if b.cond != nil {
+		b.cond.Broadcast()
+	}
+}
+
+// acquire waits until n bytes fit in the budget and takes them, returning
+// the amount taken, to be passed to release. Waiters are served in order,
+// so a large element isn't starved by a stream of small ones.
+func (b *byteBudget) acquire(n int64) int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.max == 0 {
+		return 0
+	}
+	if b.cond == nil {
+		b.cond = sync.NewCond(&b.mu)
+	}
+	n = min(n, b.max)
+	t := b.next
+	b.next++
+	for t != b.serving || (b.max > 0 && b.used > 0 && b.used+n > b.max) {
+		b.cond.Wait()
+	}
+	b.serving++
+	b.used += n
+	b.cond.Broadcast()
+	return n
+}
+
+// release returns n bytes taken by acquire.
+func (b *byteBudget) release(n int64) {
+	if n == 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.used -= n
+	b.cond.Broadcast()
+}
@@ -0,0 +1,33 @@
+package mrf
+
+import (
+	"testing"
+	"time"
+)
+
+func TestByteBudget(t *testing.T) {
+	b := &byteBudget{}
+	if n := b.acquire(100); n != 0 {
+		t.Fatalf("unlimited budget took %d bytes, want 0", n)
+	}
+
+	b.setMax(10)
+	first := b.acquire(6)
+	acquired := make(chan int64)
+	go func() { acquired <- b.acquire(6) }()
+	select {
+	case <-acquired:
+		t.Fatal("acquire went over the budget")
+	case <-time.After(50 * time.Millisecond):
+	}
+	b.release(first)
+	if n := <-acquired; n != 6 {
+		t.Errorf("second acquire took %d bytes, want 6", n)
+	}
+	b.release(6)
+
+	// An element bigger than the whole budget takes all of it.
+	if n := b.acquire(100); n != 10 {
+		t.Errorf("oversized acquire took %d bytes, want 10", n)
+	}
+}
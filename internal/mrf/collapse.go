@@ -0,0 +1,39 @@
+package mrf
+
+// collapseByTIN merges providers sharing a TIN into one entry, in order of
+// first appearance, returning the entries and each one's distinct NPIs.
+// Groups targeted by ID are kept apart, having no TIN to tell them by. The
+// entry's NPI is its first NPI (0 for a group listing none), and it is
+// MatchedByTIN only if all of its NPIs were.
+func collapseByTIN(providers []ProviderInfo) ([]ProviderInfo, [][]int64) {
+	var out []ProviderInfo
+	var npis [][]int64
+	for _, p := range providers {
+		i := 0
+		for i < len(out) && (out[i].TIN != p.TIN || out[i].GroupID != p.GroupID) {
+			i++
+		}
+		if i == len(out) {
+			out = append(out, ProviderInfo{NPI: p.NPI, TIN: p.TIN, MatchedByTIN: p.MatchedByTIN, GroupID: p.GroupID})
+			npis = append(npis, nil)
+		} else {
+			out[i].MatchedByTIN = out[i].MatchedByTIN && p.MatchedByTIN
+			if out[i].NPI == 0 {
+				out[i].NPI = p.NPI
+			}
+		}
+		if p.NPI != 0 && !containsNPI(npis[i], p.NPI) {
+			npis[i] = append(npis[i], p.NPI)
+		}
+	}
+	return out, npis
+}
+
+func containsNPI(npis []int64, n int64) bool {
+	for _, v := range npis {
+		if v == n {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,123 @@
+package mrf
+
+import (
+	"math"
+	"sort"
+)
+
+// RateComparison sets the rates two result sets have for one billing code
+// (per NPI, billing class, setting and unit, as RateAggregate) side by side.
+type RateComparison struct {
+	NPI                    int64  `json:"npi,omitempty"`
+	BillingCodeType        string `json:"billing_code_type"`
+	BillingCode            string `json:"billing_code"`
+	BillingCodeDescription string `json:"billing_code_description"`
+	BillingClass           string `json:"billing_class"`
+	Setting                string `json:"setting"`
+	Unit                   string `json:"unit"`
+
+	A *RateDistribution `json:"a,omitempty"`
+	B *RateDistribution `json:"b,omitempty"`
+
+	// Delta is B's median less A's, and PercentChange the same relative to
+	// A's median (unset when that is 0). Both are set only when the code is
+	// in both.
+	Delta         *float64 `json:"delta,omitempty"`
+	PercentChange *float64 `json:"percent_change,omitempty"`
+}
+
+// Comparison is the difference between two result sets' aggregates.
+type Comparison struct {
+	Both  []RateComparison `json:"both"`      // by size of the change, largest first
+	OnlyA []RateComparison `json:"only_in_a"` // in A's order
+	OnlyB []RateComparison `json:"only_in_b"`
+
+	Increased int `json:"increased"`
+	Decreased int `json:"decreased"`
+	Unchanged int `json:"unchanged"`
+}
+
+// CompareAggregates matches the aggregates of two result sets (see
+// Aggregator) by NPI, billing code, class, setting and unit, and compares
+// the medians of those in both.
+func CompareAggregates(a, b []RateAggregate) *Comparison {
+	type key struct {
+		npi                                  int64
+		codeType, code, class, setting, unit string
+	}
+	keyOf := func(g *RateAggregate) key {
+		return key{g.NPI, g.BillingCodeType, g.BillingCode, g.BillingClass, g.Setting, g.Unit}
+	}
+	row := func(g *RateAggregate) RateComparison {
+		return RateComparison{
+			NPI:                    g.NPI,
+			BillingCodeType:        g.BillingCodeType,
+			BillingCode:            g.BillingCode,
+			BillingCodeDescription: g.BillingCodeDescription,
+			BillingClass:           g.BillingClass,
+			Setting:                g.Setting,
+			Unit:                   g.Unit,
+		}
+	}
+
+	inA := make(map[key]bool, len(a))
+	inB := make(map[key]*RateAggregate, len(b))
+	for i := range a {
+		inA[keyOf(&a[i])] = true
+	}
+	for i := range b {
+		inB[keyOf(&b[i])] = &b[i]
+	}
+
+	c := &Comparison{Both: []RateComparison{}, OnlyA: []RateComparison{}, OnlyB: []RateComparison{}}
+	for i := range a {
+		ga := &a[i]
+		r := row(ga)
+		r.A = &ga.RateDistribution
+		gb := inB[keyOf(ga)]
+		if gb == nil {
+			c.OnlyA = append(c.OnlyA, r)
+			continue
+		}
+		if r.BillingCodeDescription == "" {
+			r.BillingCodeDescription = gb.BillingCodeDescription
+		}
+		r.B = &gb.RateDistribution
+		delta := gb.Median - ga.Median
+		r.Delta = &delta
+		if ga.Median != 0 {
+			pct := delta / ga.Median * 100
+			r.PercentChange = &pct
+		}
+		switch {
+		case delta > 0:
+			c.Increased++
+		case delta < 0:
+			c.Decreased++
+		default:
+			c.Unchanged++
+		}
+		c.Both = append(c.Both, r)
+	}
+	for i := range b {
+		if !inA[keyOf(&b[i])] {
+			r := row(&b[i])
+			r.B = &b[i].RateDistribution
+			c.OnlyB = append(c.OnlyB, r)
+		}
+	}
+
+	// Percentage changes first, largest either way; then changes with no
+	// percentage (from a median of 0) by absolute delta.
+	sort.SliceStable(c.Both, func(i, j int) bool {
+		pi, pj := c.Both[i].PercentChange, c.Both[j].PercentChange
+		if (pi == nil) != (pj == nil) {
+			return pi != nil
+		}
+		if pi != nil {
+			return math.Abs(*pi) > math.Abs(*pj)
+		}
+		return math.Abs(*c.Both[i].Delta) > math.Abs(*c.Both[j].Delta)
+	})
+	return c
+}
@@ -0,0 +1,45 @@
+package mrf
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestCompareAggregates(t *testing.T) {
+	agg := func(rates map[string][]float64) []RateAggregate {
+		a := NewAggregator()
+		for code, rs := range rates {
+			for _, r := range rs {
+				a.Add(&RateResult{BillingCodeType: "CPT", BillingCode: code, NegotiatedRate: r, NegotiatedType: "negotiated"})
+			}
+		}
+		return a.Aggregates()
+	}
+	c := CompareAggregates(
+		agg(map[string][]float64{"99213": {100, 120}, "99214": {200}, "99215": {0}, "A": {1}}),
+		agg(map[string][]float64{"99213": {110, 130}, "99214": {100}, "99215": {5}, "B": {1}}),
+	)
+	if len(c.Both) != 3 || len(c.OnlyA) != 1 || len(c.OnlyB) != 1 {
+		t.Fatalf("expected 3 in both and 1 only in each, got %d, %d, %d", len(c.Both), len(c.OnlyA), len(c.OnlyB))
+	}
+	if c.Increased != 2 || c.Decreased != 1 {
+		t.Errorf("expected 2 up and 1 down, got %d and %d", c.Increased, c.Decreased)
+	}
+	// Largest percentage change first; a change from 0 has none and sorts last.
+	var order []string
+	for _, r := range c.Both {
+		order = append(order, r.BillingCode)
+	}
+	if fmt.Sprint(order) != "[99214 99213 99215]" {
+		t.Errorf("unexpected order %v", order)
+	}
+	if p := c.Both[0].PercentChange; p == nil || *p != -50 {
+		t.Errorf("expected -50%% for 99214, got %v", p)
+	}
+	if c.Both[2].PercentChange != nil || *c.Both[2].Delta != 5 {
+		t.Errorf("expected a delta of 5 and no percentage for 99215, got %+v", c.Both[2])
+	}
+	if c.OnlyA[0].BillingCode != "A" || c.OnlyB[0].BillingCode != "B" {
+		t.Errorf("unexpected one-sided codes %+v, %+v", c.OnlyA, c.OnlyB)
+	}
+}
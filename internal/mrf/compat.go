@@ -0,0 +1,115 @@
+package mrf
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+)
+
+// Compatibility statuses; see CompatReport.Status.
+const (
+	CompatOK   = "ok"
+	CompatWarn = "warn"
+	CompatFail = "fail"
+)
+
+// CompatReport is the parse health of the beginning of an MRF, as read by
+// CheckCompat.
+type CompatReport struct {
+	ReportingEntityName string   `json:"reporting_entity_name,omitempty"`
+	Version             string   `json:"version,omitempty"`
+	Keys                []string `json:"keys"` // top-level keys reached, sorted
+
+	ProviderReferences int64 `json:"provider_references"` // elements parsed
+	InNetworkItems     int64 `json:"in_network_items"`
+	NegotiatedPrices   int64 `json:"negotiated_prices"`
+
+	// TypeErrors counts elements whose fields don't have the types the
+	// parser expects (e.g. a numeric billing_code), which lose data.
+	TypeErrors     int64  `json:"type_errors"`
+	FirstTypeError string `json:"first_type_error,omitempty"`
+
+	Truncated bool   `json:"truncated"`       // the input ended mid-document
+	Err       string `json:"error,omitempty"` // any other failure to parse
+}
+
+// Status grades the report: fail if the input couldn't be parsed, warn if
+// it parsed but had type errors or no array elements, else ok.
+func (r *CompatReport) Status() string {
+	switch {
+	case r.Err != "" || len(r.Keys) == 0:
+		return CompatFail
+	case r.TypeErrors > 0 || r.ProviderReferences+r.InNetworkItems == 0:
+		return CompatWarn
+	}
+	return CompatOK
+}
+
+// CheckCompat parses as much of an uncompressed MRF from r as it holds,
+// decoding each provider_references and in_network element the way a search
+// does. r may end partway through, as when reading the first megabytes of a
+// file; that is reported as Truncated rather than as an error.
+func CheckCompat(r io.Reader) *CompatReport {
+	rep := &CompatReport{}
+	dec := json.NewDecoder(r)
+	typeErr := func(err error) bool {
+		var te *json.UnmarshalTypeError
+		if !errors.As(err, &te) {
+			return false
+		}
+		rep.TypeErrors++
+		if rep.FirstTypeError == "" {
+			rep.FirstTypeError = err.Error()
+		}
+		return true
+	}
+
+	value := func(key string, val any) {
+		switch key {
+		case "version":
+			rep.Version, _ = val.(string)
+		case "reporting_entity_name":
+			rep.ReportingEntityName, _ = val.(string)
+		}
+	}
+	element := func(key string, _ int64) error {
+		if key == "provider_references" {
+			var ref ProviderReference
+			if err := dec.Decode(&ref); err != nil {
+				if typeErr(err) {
+					return nil
+				}
+				return err
+			}
+			rep.ProviderReferences++
+			return nil
+		}
+		var item InNetworkItem
+		if err := dec.Decode(&item); err != nil {
+			if typeErr(err) {
+				return nil
+			}
+			return err
+		}
+		rep.InNetworkItems++
+		for _, nr := range item.NegotiatedRates {
+			rep.NegotiatedPrices += int64(len(nr.NegotiatedPrices))
+		}
+		return nil
+	}
+
+	seen, err := walkTopLevel(dec, value, element)
+	for k := range seen {
+		rep.Keys = append(rep.Keys, k)
+	}
+	sort.Strings(rep.Keys)
+	switch {
+	case err == nil:
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		rep.Truncated = true
+	default:
+		rep.Err = err.Error()
+	}
+	return rep
+}
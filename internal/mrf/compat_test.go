@@ -0,0 +1,34 @@
+package mrf
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckCompat(t *testing.T) {
+	full := `{"reporting_entity_name": "Payer", "version": "1.0.0",
+	"provider_references": [{"provider_group_id": 1, "provider_groups": [{"npi": [1111111111], "tin": {"type": "ein", "value": "11-1111111"}}]}],
+	"in_network": [
+		{"billing_code_type": "CPT", "billing_code": "99213", "negotiated_rates": [{"provider_references": [1], "negotiated_prices": [{"negotiated_rate": 100}]}]},
+		{"billing_code_type": "CPT", "billing_code": 99214, "negotiated_rates": []},
+		{"billing_code_type": "CPT", "billing_code": "99215", "negotiated_rates": [{"provider_references": [1], "negotiated_prices": [{"negotiated_rate": 200}]}]}
+	]}`
+
+	rep := CheckCompat(strings.NewReader(full))
+	if rep.Status() != CompatWarn || rep.TypeErrors != 1 || rep.Truncated || rep.Err != "" {
+		t.Errorf("expected a warning for the numeric billing_code, got %s: %+v", rep.Status(), rep)
+	}
+	if rep.ProviderReferences != 1 || rep.InNetworkItems != 2 || rep.NegotiatedPrices != 2 || rep.Version != "1.0.0" {
+		t.Errorf("unexpected counts: %+v", rep)
+	}
+
+	// A slice that ends partway through is truncated, not broken.
+	rep = CheckCompat(strings.NewReader(full[:strings.Index(full, `"99215"`)]))
+	if !rep.Truncated || rep.Err != "" || rep.InNetworkItems != 1 {
+		t.Errorf("expected a truncated report with 1 item, got %+v", rep)
+	}
+
+	if rep := CheckCompat(strings.NewReader(`[1, 2]`)); rep.Status() != CompatFail {
+		t.Errorf("expected fail for a non-object, got %+v", rep)
+	}
+}
@@ -0,0 +1,8 @@
+package mrf
+
+// Enricher annotates results from reference data, such as a fee schedule,
+// before they are emitted. Enrich is called concurrently from every parsing
+// goroutine.
+type Enricher interface {
+	Enrich(r *RateResult)
+}
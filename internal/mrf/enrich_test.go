@@ -0,0 +1,45 @@
+package mrf
+
+import (
+	"strings"
+	"testing"
+)
+
+type enrichFunc func(r *RateResult)
+
+func (f enrichFunc) Enrich(r *RateResult) { f(r) }
+
+func TestEnrichers(t *testing.T) {
+	mrfJSON := `{
+	"provider_references": [],
+	"in_network": [
+		{"billing_code_type": "CPT", "billing_code": "99213", "negotiated_rates": [{
+			"provider_groups": [{"npi": [1111111111], "tin": {"type": "ein", "value": "1"}}],
+			"negotiated_prices": [{"negotiated_rate": 100}]
+		}]}
+	]
+}`
+	parse := func(es ...Enricher) RateResult {
+		var results []RateResult
+		opts := &SearchOptions{NPIs: map[int64]struct{}{1111111111: {}}, Enrichers: es}
+		_, err := StreamParse(strings.NewReader(mrfJSON), opts, "src", StreamCallbacks{},
+			func(rs []RateResult) { results = append(results, rs...) }, nil)
+		if err != nil || len(results) != 1 {
+			t.Fatalf("expected one result, got %+v (%v)", results, err)
+		}
+		return results[0]
+	}
+
+	// Enrichers run in order, each seeing the one before's changes.
+	r := parse(
+		enrichFunc(func(r *RateResult) { r.BillingCodeDescription = "Office visit" }),
+		enrichFunc(func(r *RateResult) { r.BillingCodeDescription += " (filled)" }),
+	)
+	if r.BillingCodeDescription != "Office visit (filled)" {
+		t.Errorf("unexpected description %q", r.BillingCodeDescription)
+	}
+
+	if r := parse(); r.BillingCodeDescription != "" {
+		t.Errorf("expected no description without enrichers, got %q", r.BillingCodeDescription)
+	}
+}
@@ -0,0 +1,316 @@
+package mrf
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// The methods below query split output in place for ad-hoc investigation
+// (see the explore command). Each reads the files it needs line by line,
+// so memory stays small and nothing is written.
+
+// Record is one element of a split file: a provider_references entry or an
+// in_network item.
+type Record struct {
+	File string // path of the NDJSON file
+	Line int    // 1-based
+	Raw  json.RawMessage
+}
+
+// Location returns "file:line", with the file's base name.
+func (r Record) Location() string {
+	return fmt.Sprintf("%s:%d", filepath.Base(r.File), r.Line)
+}
+
+// errStopScan ends a scanLines walk early without error.
+var errStopScan = errors.New("stop scan")
+
+// scanLines calls fn for every non-empty line of files, in order. fn may
+// return errStopScan to end the walk. The line is only valid during the call.
+func scanLines(files []string, fn func(file string, line int, raw []byte) error) error {
+	for _, path := range files {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		scanner, release := newLineScanner(f)
+		n := 0
+		for scanner.Scan() {
+			n++
+			raw := bytes.TrimSpace(scanner.Bytes())
+			if len(raw) == 0 {
+				continue
+			}
+			if err = fn(path, n, raw); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = scanner.Err()
+		}
+		release()
+		f.Close()
+		if err == errStopScan {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", filepath.Base(path), err)
+		}
+	}
+	return nil
+}
+
+func newRecord(file string, line int, raw []byte) Record {
+	return Record{File: file, Line: line, Raw: append(json.RawMessage(nil), raw...)}
+}
+
+// Stats profiles the split output like Stats does the whole file, with the
+// version and reporting entity read from root.json when it is there.
+func (s *SplitResult) Stats() (*FileStats, error) {
+	c := newStatsCollector()
+	if data, err := os.ReadFile(filepath.Join(s.Dir, "root.json")); err == nil {
+		var root struct {
+			Version             string `json:"version"`
+			ReportingEntityName string `json:"reporting_entity_name"`
+		}
+		if json.Unmarshal(data, &root) == nil {
+			c.st.Version, c.st.ReportingEntityName = root.Version, root.ReportingEntityName
+		}
+	}
+	err := scanLines(s.ProviderReferenceFiles, func(_ string, _ int, raw []byte) error {
+		var ref ProviderReference
+		if err := json.Unmarshal(raw, &ref); err != nil {
+			return err
+		}
+		c.addRef(&ref)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = scanLines(s.InNetworkFiles, func(_ string, _ int, raw []byte) error {
+		var item InNetworkItem
+		if err := json.Unmarshal(raw, &item); err != nil {
+			return err
+		}
+		c.addItem(&item)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c.finish(), nil
+}
+
+// CodeCount is how often a billing code appears in in_network.
+type CodeCount struct {
+	BillingCodeType string
+	BillingCode     string
+	Items           int64 // in_network items for the code
+	Prices          int64 // negotiated prices across those items
+}
+
+// CodeCounts counts the in_network items and prices of every billing code,
+// most items first.
+func (s *SplitResult) CodeCounts() ([]CodeCount, error) {
+	type key struct{ typ, code string }
+	counts := make(map[key]*CodeCount)
+	err := scanLines(s.InNetworkFiles, func(_ string, _ int, raw []byte) error {
+		var item InNetworkItem
+		if err := json.Unmarshal(raw, &item); err != nil {
+			return err
+		}
+		k := key{item.BillingCodeType, item.BillingCode}
+		c := counts[k]
+		if c == nil {
+			c = &CodeCount{BillingCodeType: k.typ, BillingCode: k.code}
+			counts[k] = c
+		}
+		c.Items++
+		for _, nr := range item.NegotiatedRates {
+			c.Prices += int64(len(nr.NegotiatedPrices))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	out := make([]CodeCount, 0, len(counts))
+	for _, c := range counts {
+		out = append(out, *c)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Items != out[j].Items {
+			return out[i].Items > out[j].Items
+		}
+		if out[i].BillingCodeType != out[j].BillingCodeType {
+			return out[i].BillingCodeType < out[j].BillingCodeType
+		}
+		return out[i].BillingCode < out[j].BillingCode
+	})
+	return out, nil
+}
+
+// FindCode returns up to limit in_network items for the billing code (of
+// any type), in file order. limit <= 0 means no limit.
+func (s *SplitResult) FindCode(code string, limit int) ([]Record, error) {
+	pattern := []byte(strconv.Quote(code))
+	var out []Record
+	err := scanLines(s.InNetworkFiles, func(file string, line int, raw []byte) error {
+		if !bytes.Contains(raw, pattern) {
+			return nil
+		}
+		var item struct {
+			BillingCode string `json:"billing_code"`
+		}
+		if json.Unmarshal(raw, &item) != nil || item.BillingCode != code {
+			return nil
+		}
+		out = append(out, newRecord(file, line, raw))
+		if limit > 0 && len(out) >= limit {
+			return errStopScan
+		}
+		return nil
+	})
+	return out, err
+}
+
+// FindGroup returns the provider_references entry with the given
+// provider_group_id, or nil if there is none.
+func (s *SplitResult) FindGroup(id float64) (*Record, error) {
+	var found *Record
+	err := scanLines(s.ProviderReferenceFiles, func(file string, line int, raw []byte) error {
+		var ref struct {
+			ProviderGroupID float64 `json:"provider_group_id"`
+		}
+		if json.Unmarshal(raw, &ref) != nil || ref.ProviderGroupID != id {
+			return nil
+		}
+		r := newRecord(file, line, raw)
+		found = &r
+		return errStopScan
+	})
+	return found, err
+}
+
+// NPIMatches are the places an NPI appears in split output.
+type NPIMatches struct {
+	// Groups are the provider_references entries listing the NPI.
+	Groups []Record
+	// Items are the in_network items that list it in an inline provider
+	// group or reference one of Groups; at most the limit given to FindNPI.
+	Items []Record
+	// MoreItems is set if items beyond the limit were left out.
+	MoreItems bool
+}
+
+// FindNPI finds the provider groups listing npi, then the in_network items
+// with rates for it, up to limit of them (<= 0: no limit).
+func (s *SplitResult) FindNPI(npi int64, limit int) (*NPIMatches, error) {
+	pattern := []byte(strconv.FormatInt(npi, 10))
+	m := npiMatcher{npi: {}}
+	res := &NPIMatches{}
+	groups := make(map[float64]struct{})
+	err := scanLines(s.ProviderReferenceFiles, func(file string, line int, raw []byte) error {
+		if !bytes.Contains(raw, pattern) {
+			return nil
+		}
+		var ref ProviderReference
+		if err := json.Unmarshal(raw, &ref); err != nil {
+			return err
+		}
+		for _, pg := range ref.ProviderGroups {
+			if len(m.Match(nil, Group{NPIs: pg.NPI})) > 0 {
+				groups[ref.ProviderGroupID] = struct{}{}
+				res.Groups = append(res.Groups, newRecord(file, line, raw))
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = scanLines(s.InNetworkFiles, func(file string, line int, raw []byte) error {
+		// Items without the NPI inline can only match by reference.
+		if len(groups) == 0 && !bytes.Contains(raw, pattern) {
+			return nil
+		}
+		var item InNetworkItem
+		if err := json.Unmarshal(raw, &item); err != nil {
+			return err
+		}
+		if !itemMentions(&item, m, groups) {
+			return nil
+		}
+		if limit > 0 && len(res.Items) >= limit {
+			res.MoreItems = true
+			return errStopScan
+		}
+		res.Items = append(res.Items, newRecord(file, line, raw))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// itemMentions reports whether any of item's rates lists an NPI m matches
+// inline or references one of groups.
+func itemMentions(item *InNetworkItem, m Matcher, groups map[float64]struct{}) bool {
+	for _, nr := range item.NegotiatedRates {
+		for _, id := range nr.ProviderReferences {
+			if _, ok := groups[id]; ok {
+				return true
+			}
+		}
+		for _, pg := range nr.ProviderGroups {
+			if len(m.Match(nil, Group{Inline: true, NPIs: pg.NPI})) > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ReadRecord returns line (1-based) of the split file name, a base name
+// such as "in_network_00.jsonl".
+func (s *SplitResult) ReadRecord(name string, line int) (*Record, error) {
+	path := filepath.Join(s.Dir, filepath.Base(name))
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("no split file %s in %s", filepath.Base(name), s.Dir)
+	}
+	var found *Record
+	err := scanLines([]string{path}, func(file string, n int, raw []byte) error {
+		if n < line {
+			return nil
+		}
+		if n == line {
+			r := newRecord(file, n, raw)
+			found = &r
+		}
+		return errStopScan
+	})
+	if err == nil && found == nil {
+		err = fmt.Errorf("%s has no record on line %d", filepath.Base(name), line)
+	}
+	return found, err
+}
+
+// CountRecords counts the provider_references entries and in_network items
+// without parsing them.
+func (s *SplitResult) CountRecords() (refs, items int64, err error) {
+	if err = scanLines(s.ProviderReferenceFiles, func(string, int, []byte) error { refs++; return nil }); err != nil {
+		return 0, 0, err
+	}
+	err = scanLines(s.InNetworkFiles, func(string, int, []byte) error { items++; return nil })
+	return refs, items, err
+}
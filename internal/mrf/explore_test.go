@@ -0,0 +1,73 @@
+package mrf
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestSplitDirQueries checks the explore queries over a split directory.
+func TestSplitDirQueries(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "root.json", `{"reporting_entity_name": "Test Insurer", "version": "1.3.1"}`)
+	writeTestFile(t, dir, "provider_references_00.jsonl",
+		`{"provider_group_id": 1, "provider_groups": [{"npi": [1234567890], "tin": {"type": "ein", "value": "12-3456789"}}]}
+{"provider_group_id": 2, "provider_groups": [{"npi": [1111111111], "tin": {"type": "ein", "value": "98-7654321"}}]}
+`)
+	writeTestFile(t, dir, "in_network_00.jsonl",
+		`{"billing_code_type": "CPT", "billing_code": "99213", "negotiated_rates": [{"provider_references": [1], "negotiated_prices": [{"negotiated_rate": 100}, {"negotiated_rate": 110}]}]}
+{"billing_code_type": "CPT", "billing_code": "99214", "negotiated_rates": [{"provider_references": [2], "negotiated_prices": [{"negotiated_rate": 200}]}]}
+{"billing_code_type": "CPT", "billing_code": "99213", "negotiated_rates": [{"provider_groups": [{"npi": [1234567890], "tin": {"type": "ein", "value": "12-3456789"}}], "negotiated_prices": [{"negotiated_rate": 120}]}]}
+`)
+
+	s, err := OpenSplitDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	refs, items, err := s.CountRecords()
+	if err != nil || refs != 2 || items != 3 {
+		t.Errorf("CountRecords: expected 2 refs and 3 items, got %d %d (%v)", refs, items, err)
+	}
+
+	codes, err := s.CodeCounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != 2 || codes[0].BillingCode != "99213" || codes[0].Items != 2 || codes[0].Prices != 3 {
+		t.Errorf("CodeCounts: expected 99213 first with 2 items and 3 prices, got %+v", codes)
+	}
+
+	m, err := s.FindNPI(1234567890, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Groups) != 1 || m.Groups[0].Location() != "provider_references_00.jsonl:1" {
+		t.Errorf("FindNPI: expected group on line 1, got %+v", m.Groups)
+	}
+	if len(m.Items) != 2 || m.Items[0].Line != 1 || m.Items[1].Line != 3 {
+		t.Errorf("FindNPI: expected items on lines 1 and 3, got %+v", m.Items)
+	}
+	if m, _ := s.FindNPI(1234567890, 1); len(m.Items) != 1 || !m.MoreItems {
+		t.Errorf("FindNPI with limit 1: expected 1 item and MoreItems, got %+v", m)
+	}
+
+	if recs, err := s.FindCode("99214", 0); err != nil || len(recs) != 1 || recs[0].Line != 2 {
+		t.Errorf("FindCode: expected line 2, got %+v (%v)", recs, err)
+	}
+	if r, err := s.FindGroup(2); err != nil || r == nil || r.Line != 2 {
+		t.Errorf("FindGroup: expected line 2, got %+v (%v)", r, err)
+	}
+	if r, err := s.ReadRecord("in_network_00.jsonl", 3); err != nil || !strings.Contains(string(r.Raw), "120") {
+		t.Errorf("ReadRecord: expected the third item, got %+v (%v)", r, err)
+	}
+	if _, err := s.ReadRecord("in_network_00.jsonl", 4); err == nil {
+		t.Error("ReadRecord: expected an error past the last line")
+	}
+
+	st, err := s.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.ReportingEntityName != "Test Insurer" || st.ProviderReferences != 2 || st.InNetworkItems != 3 || st.NegotiatedPrices != 4 || st.DistinctNPIs != 2 {
+		t.Errorf("Stats: unexpected %+v", st)
+	}
+}
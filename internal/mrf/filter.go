@@ -0,0 +1,77 @@
+package mrf
+
+import (
+	"bytes"
+	"strings"
+)
+
+// codeFilter restricts in_network parsing to a set of billing codes and/or
+// billing code types. A nil *codeFilter accepts everything.
+type codeFilter struct {
+	codes    map[string]struct{}
+	types    map[string]struct{} // upper-cased
+	patterns [][]byte            // quoted codes, for the raw-bytes pre-filter
+}
+
+// newCodeFilter returns the filter for SearchOptions.BillingCodes and
+// BillingCodeTypes, nil if both are empty.
+func newCodeFilter(codes, codeTypes []string) *codeFilter {
+	if len(codes) == 0 && len(codeTypes) == 0 {
+		return nil
+	}
+	f := &codeFilter{}
+	if len(codes) > 0 {
+		f.codes = make(map[string]struct{}, len(codes))
+		for _, c := range codes {
+			c = strings.TrimSpace(c)
+			f.codes[c] = struct{}{}
+			f.patterns = append(f.patterns, []byte(`"`+c+`"`))
+		}
+	}
+	if len(codeTypes) > 0 {
+		f.types = make(map[string]struct{}, len(codeTypes))
+		for _, t := range codeTypes {
+			f.types[strings.ToUpper(strings.TrimSpace(t))] = struct{}{}
+		}
+	}
+	return f
+}
+
+// prefilter reports whether raw, a serialized in_network element, may match.
+// It only looks for the quoted code anywhere in the element, so false means
+// a certain miss and true must be confirmed with match.
+func (f *codeFilter) prefilter(raw []byte) bool {
+	if f == nil || len(f.patterns) == 0 {
+		return true
+	}
+	for _, p := range f.patterns {
+		if bytes.Contains(raw, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// match reports whether an item with the given code and type passes.
+func (f *codeFilter) match(code, codeType string) bool {
+	if f == nil {
+		return true
+	}
+	if f.codes != nil {
+		if _, ok := f.codes[code]; !ok {
+			return false
+		}
+	}
+	if f.types != nil {
+		if _, ok := f.types[strings.ToUpper(codeType)]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// isBundled reports whether a negotiation_arrangement prices several
+// services together.
+func isBundled(arrangement string) bool {
+	return strings.EqualFold(arrangement, "bundle") || strings.EqualFold(arrangement, "capitation")
+}
@@ -0,0 +1,101 @@
+package mrf
+
+import "fmt"
+
+// maxGroupProviders caps the matched providers indexed for one
+// provider_group_id. Zero means no cap.
+var maxGroupProviders = 50000
+
+// SetMaxGroupProviders caps how many matched providers are kept for a single
+// provider group. Groups matched by TIN contribute every NPI they list, and a
+// few payers list groups of hundreds of thousands of NPIs; each would
+// multiply every rate referencing the group. n <= 0 removes the cap.
+func SetMaxGroupProviders(n int) {
+	maxGroupProviders = max(n, 0)
+}
+
+// add indexes a group's matched providers, merging them with any already
+// recorded for its ID (a group may be listed more than once, or across split
+// files), dropping duplicate (NPI, TIN) pairs, and truncating the group at
+// maxGroupProviders.
+func (m *MatchedProviders) add(groupID float64, infos []ProviderInfo) {
+	existing := m.ByGroupID[groupID]
+	if maxGroupProviders > 0 && len(existing) >= maxGroupProviders {
+		m.markCapped(groupID)
+		return
+	}
+	merged := dedupeProviders(append(existing, infos...), len(existing))
+	if maxGroupProviders > 0 && len(merged) > maxGroupProviders {
+		merged = merged[:maxGroupProviders]
+		m.markCapped(groupID)
+	}
+	m.ByGroupID[groupID] = merged
+}
+
+func (m *MatchedProviders) markCapped(groupID float64) {
+	if m.capped == nil {
+		m.capped = make(map[float64]struct{})
+	}
+	m.capped[groupID] = struct{}{}
+}
+
+// CappedWarning describes the groups truncated at the cap (see
+// SetMaxGroupProviders), or returns "" if none were.
+func (m *MatchedProviders) CappedWarning() string {
+	if len(m.capped) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d provider group(s) matched more than %d providers and were truncated; rates for the rest of their NPIs are missing",
+		len(m.capped), maxGroupProviders)
+}
+
+// dedupeSmall is the size up to which dedupeProviders compares entries
+// pairwise instead of building a set; almost all groups are this small.
+const dedupeSmall = 32
+
+// dedupeProviders removes entries of ps[from:] whose (NPI, TIN) pair already
+// appears in ps, in place, keeping the first of each. An entry matched by NPI
+// wins over a duplicate matched only by TIN.
+func dedupeProviders(ps []ProviderInfo, from int) []ProviderInfo {
+	if len(ps) < 2 || from >= len(ps) {
+		return ps
+	}
+	out := ps[:max(from, 1)]
+	if len(ps) <= dedupeSmall {
+		for _, p := range ps[len(out):] {
+			dup := false
+			for j := range out {
+				if out[j].NPI == p.NPI && out[j].TIN == p.TIN {
+					out[j].MatchedByTIN = out[j].MatchedByTIN && p.MatchedByTIN
+					dup = true
+					break
+				}
+			}
+			if !dup {
+				out = append(out, p)
+			}
+		}
+		return out
+	}
+
+	type key struct {
+		npi int64
+		tin TIN
+	}
+	seen := make(map[key]int, len(ps))
+	for i, p := range out {
+		if _, ok := seen[key{p.NPI, p.TIN}]; !ok {
+			seen[key{p.NPI, p.TIN}] = i
+		}
+	}
+	for _, p := range ps[len(out):] {
+		k := key{p.NPI, p.TIN}
+		if j, ok := seen[k]; ok {
+			out[j].MatchedByTIN = out[j].MatchedByTIN && p.MatchedByTIN
+			continue
+		}
+		seen[k] = len(out)
+		out = append(out, p)
+	}
+	return out
+}
@@ -0,0 +1,15 @@
+package mrf
+
+// SeededProviders returns the index of the groups in o.GroupIDs, to use in
+// place of one built from provider_references, or nil if there are none.
+// Each call returns a new index, so concurrent files don't share one.
+func (o *SearchOptions) SeededProviders() *MatchedProviders {
+	if o == nil || len(o.GroupIDs) == 0 {
+		return nil
+	}
+	m := &MatchedProviders{ByGroupID: make(map[float64][]ProviderInfo, len(o.GroupIDs))}
+	for _, id := range o.GroupIDs {
+		m.ByGroupID[float64(id)] = []ProviderInfo{{GroupID: float64(id)}}
+	}
+	return m
+}
@@ -0,0 +1,7 @@
+package mrf
+
+// ItemHook receives each in_network item that produced results, with the
+// file it came from. It is called concurrently from every parsing
+// goroutine, before the item's results are emitted, and must not modify or
+// keep item.
+type ItemHook func(item *InNetworkItem, sourceFile string)
@@ -0,0 +1,147 @@
+package mrf
+
+import "strconv"
+
+// Group is a provider group as presented to a Matcher: an entry of a
+// provider_references element, or a provider group given inline in an
+// in_network negotiated rate.
+type Group struct {
+	// RefID is the provider_group_id of the provider_references element the
+	// group belongs to; it is meaningless when Inline is set.
+	RefID  float64
+	Inline bool
+
+	NPIs []int64
+	TIN  TIN
+}
+
+// Matcher decides which providers of a provider group are search targets.
+// Both parser implementations (simdjson and encoding/json) and both
+// pipelines go through the active Matcher, so a new kind of target only
+// needs a Matcher, added to SearchOptions.Matchers.
+type Matcher interface {
+	// Patterns returns byte strings at least one of which occurs in the raw
+	// JSON of any provider_references element that Match could accept;
+	// elements containing none are skipped without being parsed. A nil
+	// result means there is no such pre-filter and every element is parsed.
+	Patterns() [][]byte
+
+	// Match appends the providers of g that are targets to dst. Entries
+	// matched other than by NPI should set MatchedByTIN.
+	Match(dst []ProviderInfo, g Group) []ProviderInfo
+}
+
+// matcher returns the matcher for o's target NPIs, TINs and Matchers.
+func (o *SearchOptions) matcher() Matcher {
+	// TINs go first so that a group's providers keep the group's NPI order,
+	// with the NPI matcher then clearing MatchedByTIN on requested NPIs.
+	var ms anyMatcher
+	if tins := newTINSet(o.TINs); tins != nil {
+		ms = append(ms, tinMatcher{tins})
+	}
+	ms = append(ms, npiMatcher(o.NPIs))
+	ms = append(ms, o.Matchers...)
+	return ms
+}
+
+// npiMatcher matches the providers whose NPI is in the set.
+type npiMatcher map[int64]struct{}
+
+func (m npiMatcher) Patterns() [][]byte {
+	patterns := make([][]byte, 0, len(m))
+	for npi := range m {
+		patterns = append(patterns, []byte(strconv.FormatInt(npi, 10)))
+	}
+	return patterns
+}
+
+func (m npiMatcher) Match(dst []ProviderInfo, g Group) []ProviderInfo {
+	for _, npi := range g.NPIs {
+		if _, ok := m[npi]; ok {
+			dst = append(dst, ProviderInfo{NPI: npi, TIN: g.TIN})
+		}
+	}
+	return dst
+}
+
+// matchesAny reports whether any of npis is in the set.
+func (m npiMatcher) matchesAny(npis []int64) bool {
+	for _, npi := range npis {
+		if _, ok := m[npi]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// onlyNPIs returns m's NPI matcher if m matches target NPIs and nothing
+// else, so that callers only deciding whether a group matches can skip
+// reading its TIN.
+func onlyNPIs(m Matcher) (npiMatcher, bool) {
+	switch m := m.(type) {
+	case npiMatcher:
+		return m, true
+	case anyMatcher:
+		if len(m) == 1 {
+			nm, ok := m[0].(npiMatcher)
+			return nm, ok
+		}
+	}
+	return nil, false
+}
+
+// tinMatcher matches every provider of a group whose TIN is in the set (a
+// single NPI-less entry if the group lists no NPIs), flagged MatchedByTIN.
+type tinMatcher struct{ set *tinSet }
+
+func (m tinMatcher) Patterns() [][]byte { return m.set.patterns }
+
+func (m tinMatcher) Match(dst []ProviderInfo, g Group) []ProviderInfo {
+	if !m.set.has(g.TIN.Value) {
+		return dst
+	}
+	if len(g.NPIs) == 0 {
+		return append(dst, ProviderInfo{TIN: g.TIN, MatchedByTIN: true})
+	}
+	for _, npi := range g.NPIs {
+		dst = append(dst, ProviderInfo{NPI: npi, TIN: g.TIN, MatchedByTIN: true})
+	}
+	return dst
+}
+
+// anyMatcher matches what any of its matchers match. A provider matched by
+// several is taken once (see dedupeProviders), as is an NPI listed more than
+// once in a group.
+type anyMatcher []Matcher
+
+func (ms anyMatcher) Patterns() [][]byte {
+	var patterns [][]byte
+	for _, m := range ms {
+		p := m.Patterns()
+		if p == nil {
+			return nil
+		}
+		patterns = append(patterns, p...)
+	}
+	if patterns == nil {
+		patterns = [][]byte{}
+	}
+	return patterns
+}
+
+func (ms anyMatcher) Match(dst []ProviderInfo, g Group) []ProviderInfo {
+	start := len(dst)
+	for _, m := range ms {
+		dst = m.Match(dst, g)
+	}
+	if len(dst)-start < 2 {
+		return dst
+	}
+	return dst[:start+len(dedupeProviders(dst[start:], 0))]
+}
+
+// prefilter reports whether raw may hold a match under patterns, as
+// returned by Matcher.Patterns.
+func prefilter(raw []byte, patterns [][]byte) bool {
+	return patterns == nil || lineContainsAny(raw, patterns)
+}
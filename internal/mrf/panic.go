@@ -0,0 +1,28 @@
+package mrf
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// PanicError is a panic recovered while processing a file, turned into an
+// error so that one malformed file fails on its own instead of taking the
+// whole run down with it.
+type PanicError struct {
+	Value any
+	Stack []byte // stack of the panicking goroutine
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// RecoverPanic stores a recovered panic in *errp as a *PanicError. It must
+// be deferred directly:
+//
+//	defer mrf.RecoverPanic(&err)
+func RecoverPanic(errp *error) {
+	if v := recover(); v != nil {
+		*errp = &PanicError{Value: v, Stack: debug.Stack()}
+	}
+}
@@ -5,8 +5,12 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
-	"strconv"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"sync/atomic"
 
 	simdjson "github.com/minio/simdjson-go"
 )
@@ -14,9 +18,73 @@ import (
 // useSimd is true if the CPU supports AVX2+CLMUL for simdjson acceleration.
 var useSimd = simdjson.SupportedCPU()
 
-// DisableSimd forces the stdlib JSON parser even on CPUs that support simdjson.
+// DisableSimd forces the stdlib JSON parser even on CPUs that support
+// simdjson, turning off the portable structural scanner too.
 func DisableSimd() {
 	useSimd = false
+	useScan = false
+}
+
+// SetSimd selects the fast path, simdjson on CPUs that support it and the
+// portable structural scanner on others, or the plain stdlib parser.
+func SetSimd(on bool) {
+	useSimd = on && simdjson.SupportedCPU()
+	useScan = on
+}
+
+// Simd reports whether the fast path is selected, as SetSimd(Simd())
+// leaves it.
+func Simd() bool {
+	return useScan
+}
+
+// parseParallelism is the number of goroutines each in_network parse, of
+// a stream or of split files, fans out to. Zero means GOMAXPROCS.
+var parseParallelism int
+
+// SetParseParallelism sets how many goroutines each file's in_network parsing
+// fans out to. n <= 0 restores the default (GOMAXPROCS).
+func SetParseParallelism(n int) {
+	if n < 0 {
+		n = 0
+	}
+	parseParallelism = n
+}
+
+// DefaultQueueDepth is the default of SetQueueDepth.
+const DefaultQueueDepth = 16
+
+// queueDepth is the number of raw in_network elements buffered per parse
+// worker; see SetQueueDepth.
+var queueDepth = DefaultQueueDepth
+
+// SetQueueDepth sets how many raw in_network elements may wait for each
+// parse worker. A deeper queue keeps workers busy while the serial decoder
+// is stalled on a slow read or a single large element; the bytes queued
+// stay capped by SetMaxStreamMemory either way. n <= 0 restores the
+// default.
+func SetQueueDepth(n int) {
+	if n <= 0 {
+		n = DefaultQueueDepth
+	}
+	queueDepth = n
+}
+
+// ParseParallelism returns the effective per-file parse fan-out.
+func ParseParallelism() int {
+	if parseParallelism > 0 {
+		return parseParallelism
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+// splitProvenance returns the provenance of line of a split file, or nil
+// if it is not being recorded.
+func (s *search) splitProvenance(filePath string, line int) *Provenance {
+	if !s.Provenance {
+		return nil
+	}
+	return &Provenance{SplitFile: filepath.Base(filePath), Line: line}
 }
 
 // ParserName returns which JSON parser is active.
@@ -24,21 +92,40 @@ func ParserName() string {
 	if useSimd {
 		return "simdjson (SIMD-accelerated)"
 	}
+	if useScan {
+		return "structural scan + encoding/json (portable)"
+	}
 	return "encoding/json (standard)"
 }
 
 // MatchedProviders maps provider_group_id → list of ProviderInfo that matched target NPIs.
 type MatchedProviders struct {
 	ByGroupID map[float64][]ProviderInfo
+
+	// schema carries StreamParse's schema detection over to a second pass.
+	schema *schemaCheck
+
+	// capped holds the groups truncated at the provider cap.
+	capped map[float64]struct{}
 }
 
-// npiBytePatterns builds byte patterns for pre-filtering raw JSON lines.
-func npiBytePatterns(targetNPIs map[int64]struct{}) [][]byte {
-	patterns := make([][]byte, 0, len(targetNPIs))
-	for npi := range targetNPIs {
-		patterns = append(patterns, []byte(strconv.FormatInt(npi, 10)))
-	}
-	return patterns
+// scanBufPool recycles the initial 4 MB buffers used by the NDJSON line
+// scanners, which are otherwise allocated fresh for every split file.
+var scanBufPool = sync.Pool{
+	New: func() any {
+		buf := make([]byte, 0, 4*1024*1024)
+		return &buf
+	},
+}
+
+// newLineScanner returns a scanner over NDJSON lines of up to 512 MB backed by
+// a pooled buffer. The returned release func must be called once scanning is
+// done and no line slices are retained.
+func newLineScanner(r io.Reader) (*bufio.Scanner, func()) {
+	buf := scanBufPool.Get().(*[]byte)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer((*buf)[:0], 512*1024*1024)
+	return scanner, func() { scanBufPool.Put(buf) }
 }
 
 // lineContainsAny returns true if the line contains any of the byte patterns.
@@ -51,68 +138,249 @@ func lineContainsAny(line []byte, patterns [][]byte) bool {
 	return false
 }
 
-// ParseProviderReferences scans provider_references NDJSON files for NPI matches (Phase A).
-func ParseProviderReferences(files []string, targetNPIs map[int64]struct{}, onRefScanned func()) (*MatchedProviders, error) {
-	matched := &MatchedProviders{
-		ByGroupID: make(map[float64][]ProviderInfo),
+// ParseProviderReferences scans provider_references NDJSON files for the
+// providers opts searches for (Phase A).
+//
+// Files are independent, so they are scanned concurrently (up to GOMAXPROCS at
+// a time), each into its own index. The per-file indexes are merged in file
+// order once all scans finish, so no locking is needed on the hot path and the
+// result is identical to a serial scan. onRefScanned may be called from
+// multiple goroutines and must be safe for concurrent use.
+func ParseProviderReferences(files []string, opts *SearchOptions, onRefScanned func()) (*MatchedProviders, error) {
+	// The matcher and its byte patterns are read-only and shared by all
+	// file scanners.
+	m := opts.matcher()
+	patterns := m.Patterns()
+
+	perFile := make([]*MatchedProviders, len(files))
+	errs := make([]error, len(files))
+
+	sem := make(chan struct{}, runtime.GOMAXPROCS(0))
+	var wg sync.WaitGroup
+	for i, filePath := range files {
+		wg.Add(1)
+		go func(idx int, path string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			local := &MatchedProviders{
+				ByGroupID: make(map[float64][]ProviderInfo),
+			}
+			var err error
+			defer func() {
+				if err != nil {
+					errs[idx] = fmt.Errorf("parsing %s: %w", path, err)
+				}
+			}()
+			defer RecoverPanic(&err)
+			if useSimd {
+				err = scanProviderRefFileSimd(path, m, patterns, local, onRefScanned)
+			} else {
+				err = scanProviderRefFileStdlib(path, m, patterns, local, onRefScanned)
+			}
+			if err == nil {
+				perFile[idx] = local
+			}
+		}(i, filePath)
 	}
+	wg.Wait()
 
-	patterns := npiBytePatterns(targetNPIs)
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	for _, filePath := range files {
-		var err error
-		if useSimd {
-			err = scanProviderRefFileSimd(filePath, targetNPIs, patterns, matched, onRefScanned)
-		} else {
-			err = scanProviderRefFileStdlib(filePath, targetNPIs, patterns, matched, onRefScanned)
+	matched := &MatchedProviders{
+		ByGroupID: make(map[float64][]ProviderInfo),
+	}
+	for _, local := range perFile {
+		for groupID, infos := range local.ByGroupID {
+			matched.add(groupID, infos)
 		}
-		if err != nil {
-			return nil, fmt.Errorf("parsing %s: %w", filePath, err)
+		for groupID := range local.capped {
+			matched.markCapped(groupID)
 		}
 	}
 
 	return matched, nil
 }
 
-// ParseInNetwork scans in_network NDJSON files and emits RateResults for matching NPIs (Phase B).
+// ParseInNetwork scans in_network NDJSON files and emits RateResults for
+// the providers opts searches for (Phase B).
+// emit receives one batch per matching in_network item.
+//
+// Files are read concurrently and their lines parsed by a pool of
+// ParseParallelism goroutines, as StreamParse parses in_network elements,
+// under the same memory cap (see SetMaxStreamMemory). Batches therefore
+// arrive in no particular order, and emit and onCodeScanned must be safe
+// for concurrent use. A file that fails to read stops the others; the
+// error of the first such file in files is returned.
 func ParseInNetwork(
 	files []string,
-	targetNPIs map[int64]struct{},
+	opts *SearchOptions,
 	matchedProviders *MatchedProviders,
 	sourceFile string,
 	onCodeScanned func(),
-	emit func(RateResult),
+	emit func([]RateResult),
 ) error {
-	for _, filePath := range files {
-		var err error
-		if useSimd {
-			err = scanInNetworkFileSimd(filePath, targetNPIs, matchedProviders, sourceFile, onCodeScanned, emit)
-		} else {
-			err = scanInNetworkFileStdlib(filePath, targetNPIs, matchedProviders, sourceFile, onCodeScanned, emit)
-		}
+	search := opts.prepare()
+	numWorkers := ParseParallelism()
+
+	type element struct {
+		raw    *json.RawMessage
+		budget int64
+		at     *Provenance
+	}
+	ch := make(chan element, numWorkers*queueDepth)
+
+	// As in streamInNetwork, a panic in one worker is kept as the error;
+	// the worker then drains the channel so the readers can finish.
+	var (
+		wg       sync.WaitGroup
+		panicErr error
+		panicMu  sync.Mutex
+		stop     atomic.Bool
+	)
+	for i := 0; i < numWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var (
+				err error
+				cur element
+			)
+			defer func() {
+				if err == nil {
+					return
+				}
+				stop.Store(true)
+				panicMu.Lock()
+				if panicErr == nil {
+					panicErr = err
+				}
+				panicMu.Unlock()
+				streamBudget.release(cur.budget)
+				for el := range ch {
+					streamBudget.release(el.budget)
+					putRaw(el.raw)
+				}
+			}()
+			defer RecoverPanic(&err)
+			var pj *simdjson.ParsedJson
+			for cur = range ch {
+				processInNetworkElement(*cur.raw, cur.at, search, matchedProviders, sourceFile, &pj, emit)
+				streamBudget.release(cur.budget)
+				putRaw(cur.raw)
+				cur = element{}
+			}
+		}()
+	}
+
+	// Readers take the files in order, as many at once as there are workers.
+	errs := make([]error, len(files))
+	var (
+		readers sync.WaitGroup
+		next    atomic.Int64
+	)
+	for i := 0; i < min(len(files), numWorkers); i++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for !stop.Load() {
+				idx := int(next.Add(1)) - 1
+				if idx >= len(files) {
+					return
+				}
+				path := files[idx]
+				errs[idx] = readLines(path, &stop, func(line []byte, lineNo int) {
+					if onCodeScanned != nil {
+						onCodeScanned()
+					}
+					budget := streamBudget.acquire(int64(len(line)))
+					raw := rawPool.Get().(*json.RawMessage)
+					*raw = append((*raw)[:0], line...)
+					ch <- element{raw, budget, search.splitProvenance(path, lineNo)}
+				})
+				if errs[idx] != nil {
+					stop.Store(true)
+				}
+			}
+		}()
+	}
+	readers.Wait()
+	close(ch)
+	wg.Wait()
+
+	if panicErr != nil {
+		return fmt.Errorf("processing in_network item: %w", panicErr)
+	}
+	for i, err := range errs {
 		if err != nil {
-			return fmt.Errorf("parsing %s: %w", filePath, err)
+			return fmt.Errorf("parsing %s: %w", files[i], err)
 		}
 	}
 	return nil
 }
 
-// emitInNetworkResults extracts and emits rate results from a parsed InNetworkItem.
-// Shared by both stdlib and simd code paths.
+// readLines calls line for each non-empty line of an NDJSON file, with its
+// 1-based line number, until the file ends or stop is set. The line is only
+// valid during the call.
+func readLines(filePath string, stop *atomic.Bool, line func([]byte, int)) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner, release := newLineScanner(f)
+	defer release()
+
+	lineNo := 0
+	for !stop.Load() && scanner.Scan() {
+		lineNo++
+		if b := scanner.Bytes(); len(b) > 0 {
+			line(b, lineNo)
+		}
+	}
+	return scanner.Err()
+}
+
+// emitInNetworkResults extracts rate results from a parsed InNetworkItem and
+// emits them as a single batch. Shared by both stdlib and simd code paths.
+//
+// Batching per item means concurrent callers (the streaming fan-out workers)
+// take the caller's lock once per matching item instead of once per rate.
+// The batch is freshly allocated and owned by emit. at, if non-nil, is
+// shared by every result of the batch.
 func emitInNetworkResults(
 	item *InNetworkItem,
-	targetNPIs map[int64]struct{},
+	s *search,
 	matchedProviders *MatchedProviders,
 	sourceFile string,
-	emit func(RateResult),
+	at *Provenance,
+	emit func([]RateResult),
 ) {
+	if !s.filter.match(item.BillingCode, item.BillingCodeType) {
+		return
+	}
+	if s.SkipBundled && isBundled(item.NegotiationArrangement) {
+		return
+	}
+
 	description := item.Name
 	if description == "" {
 		description = item.Description
 	}
 
+	var batch []RateResult
+
+	// Reused across negotiated_rates entries; most items have many rate
+	// entries and only a few providers match each one.
+	var providers []ProviderInfo
 	for _, nr := range item.NegotiatedRates {
-		var providers []ProviderInfo
+		providers = providers[:0]
 
 		// Case A: provider_references IDs
 		if matchedProviders != nil {
@@ -125,22 +393,35 @@ func emitInNetworkResults(
 
 		// Case B: inline provider_groups
 		for _, pg := range nr.ProviderGroups {
-			for _, npi := range pg.NPI {
-				if _, ok := targetNPIs[npi]; ok {
-					providers = append(providers, ProviderInfo{NPI: npi, TIN: pg.TIN})
-				}
-			}
+			providers = s.m.Match(providers, Group{Inline: true, NPIs: pg.NPI, TIN: pg.TIN})
 		}
 
 		if len(providers) == 0 {
 			continue
 		}
 
-		for _, prov := range providers {
+		rows := providers
+		var npiLists [][]int64
+		if s.CollapseProviders {
+			rows, npiLists = collapseByTIN(providers)
+		}
+
+		if batch == nil {
+			batch = make([]RateResult, 0, len(rows)*len(nr.NegotiatedPrices))
+		}
+		for i, prov := range rows {
+			var npis []int64
+			if npiLists != nil {
+				npis = npiLists[i]
+			}
 			for _, price := range nr.NegotiatedPrices {
-				emit(RateResult{
+				if !s.rateInBounds(price.NegotiatedRate) {
+					continue
+				}
+				batch = append(batch, RateResult{
 					SourceFile:             sourceFile,
 					NPI:                    prov.NPI,
+					NPIs:                   npis,
 					TIN:                    prov.TIN,
 					BillingCodeType:        item.BillingCodeType,
 					BillingCode:            item.BillingCode,
@@ -148,28 +429,46 @@ func emitInNetworkResults(
 					NegotiationArrangement: item.NegotiationArrangement,
 					NegotiatedRate:         price.NegotiatedRate,
 					NegotiatedType:         price.NegotiatedType,
+					Unit:                   UnitFor(price.NegotiatedType),
 					BillingClass:           price.BillingClass,
 					Setting:                price.Setting,
 					ExpirationDate:         price.ExpirationDate,
 					ServiceCode:            price.ServiceCode,
 					BillingCodeModifier:    price.BillingCodeModifier,
+					MatchedByTIN:           prov.MatchedByTIN,
+					ProviderGroupID:        prov.GroupID,
+					BundledCodes:           item.BundledCodes,
+					CoveredServices:        item.CoveredServices,
+					Provenance:             at,
 				})
 			}
 		}
 	}
+
+	if len(batch) > 0 {
+		if s.ItemHook != nil {
+			s.ItemHook(item, sourceFile)
+		}
+		for _, e := range s.Enrichers {
+			for i := range batch {
+				e.Enrich(&batch[i])
+			}
+		}
+		emit(batch)
+	}
 }
 
 // --- stdlib (encoding/json) implementations ---
 
-func scanProviderRefFileStdlib(filePath string, targetNPIs map[int64]struct{}, npiPatterns [][]byte, matched *MatchedProviders, onRefScanned func()) error {
+func scanProviderRefFileStdlib(filePath string, m Matcher, patterns [][]byte, matched *MatchedProviders, onRefScanned func()) error {
 	f, err := os.Open(filePath)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
 
-	scanner := bufio.NewScanner(f)
-	scanner.Buffer(make([]byte, 0, 4*1024*1024), 512*1024*1024)
+	scanner, release := newLineScanner(f)
+	defer release()
 
 	for scanner.Scan() {
 		line := scanner.Bytes()
@@ -181,9 +480,9 @@ func scanProviderRefFileStdlib(filePath string, targetNPIs map[int64]struct{}, n
 			onRefScanned()
 		}
 
-		// Pre-filter: skip lines that don't contain any target NPI as a substring.
-		// This avoids expensive json.Unmarshal on 99.99%+ of lines.
-		if !lineContainsAny(line, npiPatterns) {
+		// Pre-filter: skip lines that don't contain any target NPI (or TIN) as a
+		// substring. This avoids expensive json.Unmarshal on 99.99%+ of lines.
+		if !prefilter(line, patterns) {
 			continue
 		}
 
@@ -193,54 +492,11 @@ func scanProviderRefFileStdlib(filePath string, targetNPIs map[int64]struct{}, n
 		}
 
 		for _, pg := range ref.ProviderGroups {
-			for _, npi := range pg.NPI {
-				if _, ok := targetNPIs[npi]; ok {
-					matched.ByGroupID[ref.ProviderGroupID] = append(
-						matched.ByGroupID[ref.ProviderGroupID],
-						ProviderInfo{NPI: npi, TIN: pg.TIN},
-					)
-				}
+			if infos := m.Match(nil, Group{RefID: ref.ProviderGroupID, NPIs: pg.NPI, TIN: pg.TIN}); len(infos) > 0 {
+				matched.add(ref.ProviderGroupID, infos)
 			}
 		}
 	}
 
 	return scanner.Err()
 }
-
-func scanInNetworkFileStdlib(
-	filePath string,
-	targetNPIs map[int64]struct{},
-	matchedProviders *MatchedProviders,
-	sourceFile string,
-	onCodeScanned func(),
-	emit func(RateResult),
-) error {
-	f, err := os.Open(filePath)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	scanner := bufio.NewScanner(f)
-	scanner.Buffer(make([]byte, 0, 4*1024*1024), 512*1024*1024)
-
-	for scanner.Scan() {
-		line := scanner.Bytes()
-		if len(line) == 0 {
-			continue
-		}
-
-		var item InNetworkItem
-		if err := json.Unmarshal(line, &item); err != nil {
-			continue
-		}
-
-		if onCodeScanned != nil {
-			onCodeScanned()
-		}
-
-		emitInNetworkResults(&item, targetNPIs, matchedProviders, sourceFile, emit)
-	}
-
-	return scanner.Err()
-}
@@ -1,8 +1,6 @@
 package mrf
 
 import (
-	"bufio"
-	"encoding/json"
 	"os"
 
 	simdjson "github.com/minio/simdjson-go"
@@ -10,15 +8,15 @@ import (
 
 // scanProviderRefFileSimd parses provider_references NDJSON using simdjson.
 // Full native extraction — no json.Unmarshal needed.
-func scanProviderRefFileSimd(filePath string, targetNPIs map[int64]struct{}, npiPatterns [][]byte, matched *MatchedProviders, onRefScanned func()) error {
+func scanProviderRefFileSimd(filePath string, m Matcher, patterns [][]byte, matched *MatchedProviders, onRefScanned func()) error {
 	f, err := os.Open(filePath)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
 
-	scanner := bufio.NewScanner(f)
-	scanner.Buffer(make([]byte, 0, 4*1024*1024), 512*1024*1024)
+	scanner, release := newLineScanner(f)
+	defer release()
 
 	var pj *simdjson.ParsedJson
 
@@ -33,7 +31,7 @@ func scanProviderRefFileSimd(filePath string, targetNPIs map[int64]struct{}, npi
 		}
 
 		// Pre-filter: skip lines that don't contain any target NPI as a substring.
-		if !lineContainsAny(line, npiPatterns) {
+		if !prefilter(line, patterns) {
 			continue
 		}
 
@@ -43,7 +41,7 @@ func scanProviderRefFileSimd(filePath string, targetNPIs map[int64]struct{}, npi
 		}
 
 		pj.ForEach(func(i simdjson.Iter) error {
-			extractProviderRef(i, targetNPIs, matched)
+			extractProviderRef(i, m, matched)
 			return nil
 		})
 	}
@@ -51,8 +49,8 @@ func scanProviderRefFileSimd(filePath string, targetNPIs map[int64]struct{}, npi
 	return scanner.Err()
 }
 
-// extractProviderRef extracts provider_group_id and checks NPIs using simdjson.
-func extractProviderRef(i simdjson.Iter, targetNPIs map[int64]struct{}, matched *MatchedProviders) {
+// extractProviderRef extracts provider_group_id and matches its groups using simdjson.
+func extractProviderRef(i simdjson.Iter, m Matcher, matched *MatchedProviders) {
 	// Get provider_group_id (FindElement resets position each call)
 	idElem, err := i.FindElement(nil, "provider_group_id")
 	if err != nil {
@@ -74,115 +72,23 @@ func extractProviderRef(i simdjson.Iter, targetNPIs map[int64]struct{}, matched
 	}
 
 	pgArr.ForEach(func(pgIter simdjson.Iter) {
-		// Get NPI array
-		npiElem, npiErr := pgIter.FindElement(nil, "npi")
-		if npiErr != nil {
-			return
-		}
-		npiArr, npiErr := npiElem.Iter.Array(nil)
-		if npiErr != nil {
-			return
-		}
-		npis, npiErr := npiArr.AsInteger()
-		if npiErr != nil {
-			return
-		}
-
-		// Quick check: does any NPI match?
-		hasMatch := false
-		for _, npi := range npis {
-			if _, ok := targetNPIs[npi]; ok {
-				hasMatch = true
-				break
+		// Get NPI array. A group matched by TIN may list no NPIs at all.
+		var npis []int64
+		if npiElem, npiErr := pgIter.FindElement(nil, "npi"); npiErr == nil {
+			if npiArr, arrErr := npiElem.Iter.Array(nil); arrErr == nil {
+				npis, _ = npiArr.AsInteger()
 			}
 		}
-		if !hasMatch {
-			return
-		}
-
-		// Match found — extract TIN (only for matched groups)
-		var tin TIN
-		if e, tinErr := pgIter.FindElement(nil, "tin", "type"); tinErr == nil {
-			tin.Type, _ = e.Iter.String()
-		}
-		if e, tinErr := pgIter.FindElement(nil, "tin", "value"); tinErr == nil {
-			tin.Value, _ = e.Iter.String()
-		}
 
-		for _, npi := range npis {
-			if _, ok := targetNPIs[npi]; ok {
-				matched.ByGroupID[groupID] = append(
-					matched.ByGroupID[groupID],
-					ProviderInfo{NPI: npi, TIN: tin},
-				)
-			}
+		if infos := m.Match(nil, Group{RefID: groupID, NPIs: npis, TIN: groupTINSimd(pgIter)}); len(infos) > 0 {
+			matched.add(groupID, infos)
 		}
 	})
 }
 
-// scanInNetworkFileSimd uses simdjson for fast NPI match checking,
-// then stdlib json.Unmarshal only for records that actually match.
-// This is a hybrid approach: simdjson filters (fast), stdlib extracts (simple).
-func scanInNetworkFileSimd(
-	filePath string,
-	targetNPIs map[int64]struct{},
-	matchedProviders *MatchedProviders,
-	sourceFile string,
-	onCodeScanned func(),
-	emit func(RateResult),
-) error {
-	f, err := os.Open(filePath)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	scanner := bufio.NewScanner(f)
-	scanner.Buffer(make([]byte, 0, 4*1024*1024), 512*1024*1024)
-
-	var pj *simdjson.ParsedJson
-
-	for scanner.Scan() {
-		line := scanner.Bytes()
-		if len(line) == 0 {
-			continue
-		}
-
-		pj, err = simdjson.Parse(line, pj)
-		if err != nil {
-			continue
-		}
-
-		if onCodeScanned != nil {
-			onCodeScanned()
-		}
-
-		// Quick match check using simdjson — skip non-matching records fast
-		matched := false
-		pj.ForEach(func(i simdjson.Iter) error {
-			matched = checkNPIMatchSimd(i, targetNPIs, matchedProviders)
-			return nil
-		})
-
-		if !matched {
-			continue
-		}
-
-		// Match found — full extraction via stdlib (simpler for deeply nested structures)
-		var item InNetworkItem
-		if err := json.Unmarshal(line, &item); err != nil {
-			continue
-		}
-
-		emitInNetworkResults(&item, targetNPIs, matchedProviders, sourceFile, emit)
-	}
-
-	return scanner.Err()
-}
-
-// checkNPIMatchSimd quickly checks if an in_network record contains any matching NPIs.
+// checkNPIMatchSimd quickly checks if an in_network record contains any matching providers.
 // Checks both provider_references (via matchedProviders) and inline provider_groups.
-func checkNPIMatchSimd(i simdjson.Iter, targetNPIs map[int64]struct{}, matchedProviders *MatchedProviders) bool {
+func checkNPIMatchSimd(i simdjson.Iter, m Matcher, matchedProviders *MatchedProviders) bool {
 	ratesElem, err := i.FindElement(nil, "negotiated_rates")
 	if err != nil {
 		return false
@@ -192,7 +98,15 @@ func checkNPIMatchSimd(i simdjson.Iter, targetNPIs map[int64]struct{}, matchedPr
 		return false
 	}
 
+	// With only target NPIs, as in most searches, a group's NPIs decide the
+	// match, and its TIN needn't be read.
+	npiOnly, isNPIOnly := onlyNPIs(m)
+
 	found := false
+	var buf []ProviderInfo
+	var npiElem *simdjson.Element
+	var npiArr *simdjson.Array
+	var npis []int64
 	ratesArr.ForEach(func(rateIter simdjson.Iter) {
 		if found {
 			return // already found a match, skip remaining
@@ -221,18 +135,19 @@ func checkNPIMatchSimd(i simdjson.Iter, targetNPIs map[int64]struct{}, matchedPr
 					if found {
 						return
 					}
-					if npiElem, npiErr := pgIter.FindElement(nil, "npi"); npiErr == nil {
-						if npiArr, arrErr := npiElem.Iter.Array(nil); arrErr == nil {
-							if npis, intErr := npiArr.AsInteger(); intErr == nil {
-								for _, npi := range npis {
-									if _, ok := targetNPIs[npi]; ok {
-										found = true
-										return
-									}
-								}
-							}
+					npis = npis[:0]
+					var err error
+					if npiElem, err = pgIter.FindElement(npiElem, "npi"); err == nil {
+						if npiArr, err = npiElem.Iter.Array(npiArr); err == nil {
+							npis = appendIntegers(npis, npiArr)
 						}
 					}
+					if isNPIOnly {
+						found = npiOnly.matchesAny(npis)
+						return
+					}
+					buf = m.Match(buf[:0], Group{Inline: true, NPIs: npis, TIN: groupTINSimd(pgIter)})
+					found = len(buf) > 0
 				})
 			}
 		}
@@ -240,3 +155,16 @@ func checkNPIMatchSimd(i simdjson.Iter, targetNPIs map[int64]struct{}, matchedPr
 
 	return found
 }
+
+// appendIntegers appends the integers in arr to dst, skipping other values.
+// Array.AsInteger would size a new slice by the rest of the document, which
+// for an array early in a large item is far more than it holds.
+func appendIntegers(dst []int64, arr *simdjson.Array) []int64 {
+	it := arr.Iter()
+	for it.Advance() != simdjson.TypeNone {
+		if v, err := it.Int(); err == nil {
+			dst = append(dst, v)
+		}
+	}
+	return dst
+}
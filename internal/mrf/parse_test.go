@@ -1,10 +1,16 @@
 package mrf
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
+
+	simdjson "github.com/minio/simdjson-go"
 )
 
 func writeTestFile(t *testing.T, dir, name, content string) string {
@@ -28,7 +34,7 @@ func TestParseProviderReferences_MatchesByNPI(t *testing.T) {
 
 	targetNPIs := map[int64]struct{}{1234567890: {}}
 	var scanned int
-	matched, err := ParseProviderReferences([]string{f}, targetNPIs, func() { scanned++ })
+	matched, err := ParseProviderReferences([]string{f}, &SearchOptions{NPIs: targetNPIs}, func() { scanned++ })
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -62,7 +68,7 @@ func TestParseProviderReferences_NoMatches(t *testing.T) {
 	f := writeTestFile(t, dir, "provider_references_00.jsonl", ndjson)
 
 	targetNPIs := map[int64]struct{}{1234567890: {}}
-	matched, err := ParseProviderReferences([]string{f}, targetNPIs, nil)
+	matched, err := ParseProviderReferences([]string{f}, &SearchOptions{NPIs: targetNPIs}, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -72,6 +78,57 @@ func TestParseProviderReferences_NoMatches(t *testing.T) {
 	}
 }
 
+// TestParseProviderReferences_MultipleFiles verifies that matches from
+// concurrently scanned files are merged, including the same group ID appearing
+// in more than one file (merged in file order).
+func TestParseProviderReferences_MultipleFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	var files []string
+	for i := 0; i < 8; i++ {
+		ndjson := fmt.Sprintf(`{"provider_group_id":%d,"provider_groups":[{"npi":[1234567890],"tin":{"type":"ein","value":"12-%07d"}}]}
+{"provider_group_id":100,"provider_groups":[{"npi":[1234567890],"tin":{"type":"ein","value":"file-%d"}}]}
+{"provider_group_id":999,"provider_groups":[{"npi":[9999999999],"tin":{"type":"ein","value":"99-9999999"}}]}`, i, i, i)
+		files = append(files, writeTestFile(t, dir, fmt.Sprintf("provider_references_%02d.jsonl", i), ndjson))
+	}
+
+	targetNPIs := map[int64]struct{}{1234567890: {}}
+	var scanned atomic.Int64
+	matched, err := ParseProviderReferences(files, &SearchOptions{NPIs: targetNPIs}, func() { scanned.Add(1) })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if scanned.Load() != 24 {
+		t.Errorf("expected 24 refs scanned, got %d", scanned.Load())
+	}
+	// Groups 0-7 plus the shared group 100.
+	if len(matched.ByGroupID) != 9 {
+		t.Errorf("expected 9 matched groups, got %d", len(matched.ByGroupID))
+	}
+	shared := matched.ByGroupID[100]
+	if len(shared) != 8 {
+		t.Fatalf("expected 8 entries for shared group 100, got %d", len(shared))
+	}
+	for i, info := range shared {
+		if want := fmt.Sprintf("file-%d", i); info.TIN.Value != want {
+			t.Errorf("shared[%d]: expected TIN %s, got %s", i, want, info.TIN.Value)
+		}
+	}
+}
+
+func TestParseProviderReferences_MissingFile(t *testing.T) {
+	dir := t.TempDir()
+	good := writeTestFile(t, dir, "provider_references_00.jsonl",
+		`{"provider_group_id":1,"provider_groups":[{"npi":[1234567890],"tin":{"type":"ein","value":"12-3456789"}}]}`)
+
+	targetNPIs := map[int64]struct{}{1234567890: {}}
+	_, err := ParseProviderReferences([]string{good, filepath.Join(dir, "missing.jsonl")}, &SearchOptions{NPIs: targetNPIs}, nil)
+	if err == nil {
+		t.Fatal("expected error for missing file")
+	}
+}
+
 func TestParseInNetwork_ViaProviderReferences(t *testing.T) {
 	dir := t.TempDir()
 
@@ -90,11 +147,11 @@ func TestParseInNetwork_ViaProviderReferences(t *testing.T) {
 
 	err := ParseInNetwork(
 		[]string{f},
-		targetNPIs,
+		&SearchOptions{NPIs: targetNPIs},
 		matchedProviders,
 		"https://example.com/test.json.gz",
 		func() { scanned++ },
-		func(r RateResult) { results = append(results, r) },
+		func(rs []RateResult) { results = append(results, rs...) },
 	)
 	if err != nil {
 		t.Fatal(err)
@@ -137,11 +194,11 @@ func TestParseInNetwork_InlineProviderGroups(t *testing.T) {
 	var results []RateResult
 	err := ParseInNetwork(
 		[]string{f},
-		targetNPIs,
+		&SearchOptions{NPIs: targetNPIs},
 		matchedProviders,
 		"https://example.com/test.json.gz",
 		nil,
-		func(r RateResult) { results = append(results, r) },
+		func(rs []RateResult) { results = append(results, rs...) },
 	)
 	if err != nil {
 		t.Fatal(err)
@@ -159,6 +216,31 @@ func TestParseInNetwork_InlineProviderGroups(t *testing.T) {
 	}
 }
 
+func TestParseInNetwork_Provenance(t *testing.T) {
+	dir := t.TempDir()
+	item := func(code string, npi int) string {
+		return `{"billing_code_type":"CPT","billing_code":"` + code + `","negotiated_rates":[{"provider_groups":[{"npi":[` +
+			strconv.Itoa(npi) + `],"tin":{"type":"ein","value":"1"}}],"negotiated_prices":[{"negotiated_rate":10}]}]}`
+	}
+	f := writeTestFile(t, dir, "in_network_02.jsonl", item("1", 2222222222)+"\n\n"+item("2", 1234567890)+"\n")
+
+	defer SetSimd(Simd())
+	for _, simd := range []bool{false, true} {
+		SetSimd(simd)
+		var results []RateResult
+		opts := &SearchOptions{NPIs: map[int64]struct{}{1234567890: {}}, Provenance: true}
+		err := ParseInNetwork([]string{f}, opts, nil, "src", nil,
+			func(rs []RateResult) { results = append(results, rs...) })
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := Provenance{SplitFile: "in_network_02.jsonl", Line: 3}
+		if len(results) != 1 || results[0].Provenance == nil || *results[0].Provenance != want {
+			t.Errorf("%s: expected provenance %+v, got %+v", ParserName(), want, results)
+		}
+	}
+}
+
 func TestParseInNetwork_NoMatchSkipped(t *testing.T) {
 	dir := t.TempDir()
 
@@ -171,11 +253,11 @@ func TestParseInNetwork_NoMatchSkipped(t *testing.T) {
 	var results []RateResult
 	err := ParseInNetwork(
 		[]string{f},
-		targetNPIs,
+		&SearchOptions{NPIs: targetNPIs},
 		matchedProviders,
 		"https://example.com/test.json.gz",
 		nil,
-		func(r RateResult) { results = append(results, r) },
+		func(rs []RateResult) { results = append(results, rs...) },
 	)
 	if err != nil {
 		t.Fatal(err)
@@ -204,11 +286,11 @@ func TestParseInNetwork_MultipleProvidersPrices(t *testing.T) {
 	var results []RateResult
 	err := ParseInNetwork(
 		[]string{f},
-		targetNPIs,
+		&SearchOptions{NPIs: targetNPIs},
 		matchedProviders,
 		"https://example.com/test.json.gz",
 		nil,
-		func(r RateResult) { results = append(results, r) },
+		func(rs []RateResult) { results = append(results, rs...) },
 	)
 	if err != nil {
 		t.Fatal(err)
@@ -316,7 +398,7 @@ func TestEndToEnd(t *testing.T) {
 
 	// Step 2: Parse provider references
 	targetNPIs := map[int64]struct{}{1234567890: {}}
-	matched, err := ParseProviderReferences(splitResult.ProviderReferenceFiles, targetNPIs, nil)
+	matched, err := ParseProviderReferences(splitResult.ProviderReferenceFiles, &SearchOptions{NPIs: targetNPIs}, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -329,11 +411,11 @@ func TestEndToEnd(t *testing.T) {
 	var results []RateResult
 	err = ParseInNetwork(
 		splitResult.InNetworkFiles,
-		targetNPIs,
+		&SearchOptions{NPIs: targetNPIs},
 		matched,
 		"test-source",
 		nil,
-		func(r RateResult) { results = append(results, r) },
+		func(rs []RateResult) { results = append(results, rs...) },
 	)
 	if err != nil {
 		t.Fatal(err)
@@ -365,10 +447,10 @@ func TestParserName(t *testing.T) {
 		if useSimd {
 			t.Errorf("useSimd should be false on %s, got true", runtime.GOARCH)
 		}
-		if name != "encoding/json (standard)" {
-			t.Errorf("expected stdlib parser on %s, got %s", runtime.GOARCH, name)
+		if name != "structural scan + encoding/json (portable)" {
+			t.Errorf("expected the portable scanner on %s, got %s", runtime.GOARCH, name)
 		}
-		t.Logf("Parser on %s: %s (correctly using stdlib fallback)", runtime.GOARCH, name)
+		t.Logf("Parser on %s: %s (correctly using the portable fallback)", runtime.GOARCH, name)
 	}
 }
 
@@ -381,8 +463,8 @@ func TestStdlibProviderRefsDirectly(t *testing.T) {
 	targetNPIs := map[int64]struct{}{1234567890: {}}
 	matched := &MatchedProviders{ByGroupID: make(map[float64][]ProviderInfo)}
 
-	patterns := npiBytePatterns(targetNPIs)
-	err := scanProviderRefFileStdlib(f, targetNPIs, patterns, matched, nil)
+	m := (&SearchOptions{NPIs: targetNPIs}).matcher()
+	err := scanProviderRefFileStdlib(f, m, m.Patterns(), matched, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -404,8 +486,8 @@ func TestSimdProviderRefsDirectly(t *testing.T) {
 	targetNPIs := map[int64]struct{}{1234567890: {}}
 	matched := &MatchedProviders{ByGroupID: make(map[float64][]ProviderInfo)}
 
-	patterns := npiBytePatterns(targetNPIs)
-	err := scanProviderRefFileSimd(f, targetNPIs, patterns, matched, nil)
+	m := (&SearchOptions{NPIs: targetNPIs}).matcher()
+	err := scanProviderRefFileSimd(f, m, m.Patterns(), matched, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -439,8 +521,8 @@ func TestSimdInNetworkDirectly(t *testing.T) {
 	}
 
 	var results []RateResult
-	err := scanInNetworkFileSimd(f, targetNPIs, matchedProviders, "test", nil,
-		func(r RateResult) { results = append(results, r) })
+	err := ParseInNetwork([]string{f}, &SearchOptions{NPIs: targetNPIs}, matchedProviders, "test", nil,
+		func(rs []RateResult) { results = append(results, rs...) })
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -466,8 +548,8 @@ func TestSimdInNetworkInlineProviderGroups(t *testing.T) {
 	matchedProviders := &MatchedProviders{ByGroupID: map[float64][]ProviderInfo{}}
 
 	var results []RateResult
-	err := scanInNetworkFileSimd(f, targetNPIs, matchedProviders, "test", nil,
-		func(r RateResult) { results = append(results, r) })
+	err := ParseInNetwork([]string{f}, &SearchOptions{NPIs: targetNPIs}, matchedProviders, "test", nil,
+		func(rs []RateResult) { results = append(results, rs...) })
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -478,3 +560,84 @@ func TestSimdInNetworkInlineProviderGroups(t *testing.T) {
 		t.Errorf("simd: expected J0129, got %s", results[0].BillingCode)
 	}
 }
+
+func TestParseInNetwork_Parallel(t *testing.T) {
+	SetParseParallelism(4)
+	defer SetParseParallelism(0)
+
+	dir := t.TempDir()
+	var files []string
+	for i := 0; i < 3; i++ {
+		var lines []string
+		for j := 0; j < 100; j++ {
+			ref := 2
+			if j%10 == 0 {
+				ref = 1
+			}
+			lines = append(lines, fmt.Sprintf(`{"billing_code":"%d","negotiated_rates":[{"provider_references":[%d],"negotiated_prices":[{"negotiated_rate":1}]}]}`, j, ref))
+		}
+		files = append(files, writeTestFile(t, dir, fmt.Sprintf("in_network_%02d.jsonl", i), strings.Join(lines, "\n")))
+	}
+	targetNPIs := map[int64]struct{}{1234567890: {}}
+	matchedProviders := &MatchedProviders{ByGroupID: map[float64][]ProviderInfo{1: {{NPI: 1234567890}}}}
+
+	var results, scanned atomic.Int64
+	err := ParseInNetwork(files, &SearchOptions{NPIs: targetNPIs}, matchedProviders, "test", func() { scanned.Add(1) },
+		func(rs []RateResult) { results.Add(int64(len(rs))) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results.Load() != 30 || scanned.Load() != 300 {
+		t.Errorf("expected 30 results from 300 items, got %d from %d", results.Load(), scanned.Load())
+	}
+
+	// A missing file fails the parse, naming the file.
+	missing := filepath.Join(dir, "in_network_09.jsonl")
+	err = ParseInNetwork(append(files, missing), &SearchOptions{NPIs: targetNPIs}, matchedProviders, "test", nil, func([]RateResult) {})
+	if err == nil || !strings.Contains(err.Error(), missing) {
+		t.Errorf("expected an error for %s, got %v", missing, err)
+	}
+}
+
+// BenchmarkCheckNPIMatchSimd measures the in_network pre-check on an item
+// whose inline provider groups don't match, the common case, searching by
+// NPI alone and with a TIN as well.
+func BenchmarkCheckNPIMatchSimd(b *testing.B) {
+	if !simdjson.SupportedCPU() {
+		b.Skip("simdjson not supported on this CPU")
+	}
+	var sb strings.Builder
+	sb.WriteString(`{"billing_code": "99213", "negotiated_rates": [`)
+	for i := 0; i < 200; i++ {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		fmt.Fprintf(&sb, `{"provider_groups": [{"npi": [%d, %d, %d, %d, %d], "tin": {"type": "ein", "value": "%02d-%07d"}}],
+			"negotiated_prices": [{"negotiated_rate": 100}]}`, 1000000000+5*i, 1000000001+5*i, 1000000002+5*i, 1000000003+5*i, 1000000004+5*i, i%100, i)
+	}
+	sb.WriteString(`]}`)
+	pj, err := simdjson.Parse([]byte(sb.String()), nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	targetNPIs := map[int64]struct{}{1234567890: {}}
+	matched := &MatchedProviders{ByGroupID: map[float64][]ProviderInfo{}}
+
+	for _, bc := range []struct {
+		name string
+		tins []string
+	}{{"npi", nil}, {"npi+tin", []string{"99-9999999"}}} {
+		b.Run(bc.name, func(b *testing.B) {
+			m := (&SearchOptions{NPIs: targetNPIs, TINs: bc.tins}).matcher()
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				pj.ForEach(func(it simdjson.Iter) error {
+					if checkNPIMatchSimd(it, m, matched) {
+						b.Fatal("unexpected match")
+					}
+					return nil
+				})
+			}
+		})
+	}
+}
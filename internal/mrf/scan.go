@@ -0,0 +1,315 @@
+package mrf
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+)
+
+// useScan selects the structural scanner below to skip non-matching
+// in_network records where simdjson is unavailable (arm64, or amd64 without
+// AVX2), so that only matching ones go through json.Unmarshal.
+var useScan = true
+
+// checkNPIMatchScan is the portable counterpart of checkNPIMatchSimd: it
+// walks just the parts of an in_network record that decide a match
+// (negotiated_rates[].provider_references and provider_groups) and skips
+// everything else, negotiated_prices included, without decoding it.
+//
+// It never rules out a record json.Unmarshal would match: keys compare
+// case-insensitively, as encoding/json's do, and anything it does not
+// expect, such as malformed JSON, reports a possible match and is left for
+// json.Unmarshal to decide.
+func checkNPIMatchScan(raw []byte, m Matcher, matchedProviders *MatchedProviders) bool {
+	s := jsonScan{b: raw}
+	found := false
+	var buf []ProviderInfo
+	var npis []int64
+	byRef := matchedProviders != nil && len(matchedProviders.ByGroupID) > 0
+
+	group := func() bool {
+		npis = npis[:0]
+		var tin TIN
+		ok := s.object(func(key []byte) bool {
+			switch {
+			case keyIs(key, "npi"):
+				return s.array(func() bool {
+					npi, err := strconv.ParseInt(string(s.number()), 10, 64)
+					npis = append(npis, npi)
+					return err == nil
+				})
+			case keyIs(key, "tin"):
+				return s.object(func(key []byte) bool {
+					switch {
+					case keyIs(key, "type"):
+						v, ok := s.str()
+						tin.Type = v
+						return ok
+					case keyIs(key, "value"):
+						v, ok := s.str()
+						tin.Value = v
+						return ok
+					}
+					return s.skip()
+				})
+			}
+			return s.skip()
+		})
+		if !ok {
+			return false
+		}
+		buf = m.Match(buf[:0], Group{Inline: true, NPIs: npis, TIN: tin})
+		found = len(buf) > 0
+		return !found
+	}
+
+	rate := func(key []byte) bool {
+		switch {
+		case keyIs(key, "provider_references") && byRef:
+			return s.array(func() bool {
+				ref, err := strconv.ParseFloat(string(s.number()), 64)
+				if err != nil {
+					return false
+				}
+				_, found = matchedProviders.ByGroupID[ref]
+				return !found
+			})
+		case keyIs(key, "provider_groups"):
+			return s.array(group)
+		}
+		return s.skip()
+	}
+
+	ok := s.object(func(key []byte) bool {
+		if !keyIs(key, "negotiated_rates") {
+			return s.skip()
+		}
+		return s.array(func() bool { return s.object(rate) })
+	})
+	return found || !ok
+}
+
+// keyIs reports whether an object key names field, matched the way
+// encoding/json matches struct fields (the MRF field names are ASCII).
+func keyIs(key []byte, field string) bool {
+	return len(key) == len(field) && (string(key) == field || bytes.EqualFold(key, []byte(field)))
+}
+
+// jsonScan walks a JSON value without decoding what it skips. Its methods
+// return false on anything unexpected, leaving the position undefined.
+type jsonScan struct {
+	b []byte
+	i int
+}
+
+func (s *jsonScan) ws() {
+	for s.i < len(s.b) {
+		switch s.b[s.i] {
+		case ' ', '\t', '\n', '\r':
+			s.i++
+		default:
+			return
+		}
+	}
+}
+
+// peek skips whitespace and returns the next byte, or 0 at the end.
+func (s *jsonScan) peek() byte {
+	s.ws()
+	if s.i < len(s.b) {
+		return s.b[s.i]
+	}
+	return 0
+}
+
+// null consumes a null, which encoding/json decodes into an empty slice or
+// a zero struct.
+func (s *jsonScan) null() bool {
+	if bytes.HasPrefix(s.b[s.i:], []byte("null")) {
+		s.i += 4
+		return true
+	}
+	return false
+}
+
+// object calls field for each key of an object, which must consume the
+// key's value, stopping when it returns false.
+func (s *jsonScan) object(field func(key []byte) bool) bool {
+	switch s.peek() {
+	case 'n':
+		return s.null()
+	case '{':
+	default:
+		return false
+	}
+	s.i++
+	if s.peek() == '}' {
+		s.i++
+		return true
+	}
+	for {
+		if s.peek() != '"' {
+			return false
+		}
+		key, ok := s.rawString()
+		if !ok {
+			return false
+		}
+		if bytes.IndexByte(key, '\\') >= 0 {
+			var k string
+			if json.Unmarshal(s.b[s.i-len(key)-2:s.i], &k) != nil {
+				return false
+			}
+			key = []byte(k)
+		}
+		if s.peek() != ':' {
+			return false
+		}
+		s.i++
+		if !field(key) {
+			return false
+		}
+		switch s.peek() {
+		case ',':
+			s.i++
+		case '}':
+			s.i++
+			return true
+		default:
+			return false
+		}
+	}
+}
+
+// array calls elem for each element of an array, which must consume it,
+// stopping when it returns false.
+func (s *jsonScan) array(elem func() bool) bool {
+	switch s.peek() {
+	case 'n':
+		return s.null()
+	case '[':
+	default:
+		return false
+	}
+	s.i++
+	if s.peek() == ']' {
+		s.i++
+		return true
+	}
+	for {
+		if !elem() {
+			return false
+		}
+		switch s.peek() {
+		case ',':
+			s.i++
+		case ']':
+			s.i++
+			return true
+		default:
+			return false
+		}
+	}
+}
+
+// rawString consumes a string and returns its contents, still escaped.
+func (s *jsonScan) rawString() ([]byte, bool) {
+	start := s.i + 1
+	for j := start; ; {
+		k := bytes.IndexByte(s.b[j:], '"')
+		if k < 0 {
+			return nil, false
+		}
+		j += k
+		// The quote is escaped if an odd number of backslashes precede it.
+		n := 0
+		for j-n-1 >= start && s.b[j-n-1] == '\\' {
+			n++
+		}
+		if n%2 == 0 {
+			s.i = j + 1
+			return s.b[start:j], true
+		}
+		j++
+	}
+}
+
+// str consumes a string (or null, as "") and returns it unescaped.
+func (s *jsonScan) str() (string, bool) {
+	switch s.peek() {
+	case 'n':
+		return "", s.null()
+	case '"':
+	default:
+		return "", false
+	}
+	start := s.i
+	v, ok := s.rawString()
+	if !ok {
+		return "", false
+	}
+	if bytes.IndexByte(v, '\\') < 0 {
+		return string(v), true
+	}
+	var out string
+	return out, json.Unmarshal(s.b[start:s.i], &out) == nil
+}
+
+// number consumes a number and returns its text, which is empty if there
+// is no number there.
+func (s *jsonScan) number() []byte {
+	s.ws()
+	start := s.i
+	for s.i < len(s.b) {
+		switch c := s.b[s.i]; {
+		case c >= '0' && c <= '9', c == '-', c == '+', c == '.', c == 'e', c == 'E':
+			s.i++
+		default:
+			return s.b[start:s.i]
+		}
+	}
+	return s.b[start:s.i]
+}
+
+// skip consumes any value.
+func (s *jsonScan) skip() bool {
+	switch c := s.peek(); c {
+	case '"':
+		_, ok := s.rawString()
+		return ok
+	case '{', '[':
+		depth := 0
+		for s.i < len(s.b) {
+			switch s.b[s.i] {
+			case '"':
+				if _, ok := s.rawString(); !ok {
+					return false
+				}
+				continue
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+				if depth == 0 {
+					s.i++
+					return true
+				}
+			}
+			s.i++
+		}
+		return false
+	case 0:
+		return false
+	default:
+		// A number or literal runs up to the next delimiter.
+		start := s.i
+		for s.i < len(s.b) {
+			switch s.b[s.i] {
+			case ',', '}', ']', ' ', '\t', '\n', '\r':
+				return s.i > start
+			}
+			s.i++
+		}
+		return s.i > start
+	}
+}
@@ -0,0 +1,91 @@
+package mrf
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestCheckNPIMatchScan(t *testing.T) {
+	m := (&SearchOptions{NPIs: map[int64]struct{}{1234567890: {}}}).matcher()
+	matched := &MatchedProviders{ByGroupID: map[float64][]ProviderInfo{7: {{NPI: 1234567890}}}}
+	prices := `"negotiated_prices":[{"negotiated_rate":1.5,"service_code":["11","22"],"billing_code_modifier":[]}]`
+
+	tests := []struct {
+		name string
+		line string
+		want bool
+	}{
+		{"matching reference", `{"billing_code":"99213","negotiated_rates":[{` + prices + `,"provider_references":[3,7.0]}]}`, true},
+		{"other references", `{"negotiated_rates":[{"provider_references":[3,4],` + prices + `}]}`, false},
+		{"matching inline NPI", `{"negotiated_rates":[{"provider_groups":[{"npi":[1],"tin":{"type":"ein","value":"x"}},{"npi":[1234567890],"tin":{"type":"ein","value":"y"}}]}]}`, true},
+		{"other inline NPIs", `{"negotiated_rates":[{"provider_groups":[{"npi":[1,2],"tin":null}],` + prices + `}]}`, false},
+		{"later rate matches", `{"negotiated_rates":[{"provider_references":[1]},{"provider_references":[7]}]}`, true},
+		{"no rates", `{"billing_code":"99213","negotiated_rates":null}`, false},
+		{"NPI in skipped fields", `{"name":"1234567890 \"negotiated_rates\"","negotiated_rates":[{"provider_references":[1],"x":{"npi":[1234567890]}}]}`, false},
+		{"keys fold case", `{"Negotiated_Rates":[{"Provider_References":[7]}]}`, true},
+		{"escaped key", `{"negotiated_rates":[{"provider_reference\u0073":[7]}]}`, true},
+		{"whitespace", "{ \"negotiated_rates\" : [ { \"provider_references\" : [ 7 ] } ] }", true},
+		{"malformed is left to json.Unmarshal", `{"negotiated_rates":[{"provider_references":[1]`, true},
+		{"string reference is left to json.Unmarshal", `{"negotiated_rates":[{"provider_references":["1"]}]}`, true},
+	}
+	for _, tt := range tests {
+		if got := checkNPIMatchScan([]byte(tt.line), m, matched); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCheckNPIMatchScan_TIN(t *testing.T) {
+	m := (&SearchOptions{TINs: []string{"123456789"}}).matcher()
+
+	if !checkNPIMatchScan([]byte(`{"negotiated_rates":[{"provider_groups":[{"npi":[5],"tin":{"type":"ein","value":"12-3456789"}}]}]}`), m, nil) {
+		t.Error("group with a target TIN not matched")
+	}
+	if checkNPIMatchScan([]byte(`{"negotiated_rates":[{"provider_groups":[{"npi":[5],"tin":{"type":"ein","value":"98-7654321"}}]}]}`), m, nil) {
+		t.Error("group with another TIN matched")
+	}
+}
+
+// TestParseInNetwork_ScanMatchesStdlib checks that the structural scanner
+// finds the same results as the plain stdlib parser.
+func TestParseInNetwork_ScanMatchesStdlib(t *testing.T) {
+	dir := t.TempDir()
+	f := writeTestFile(t, dir, "in_network_00.jsonl", strings.Join([]string{
+		`{"billing_code_type":"CPT","billing_code":"99213","name":"Office visit","negotiation_arrangement":"ffs","negotiated_rates":[{"provider_references":[2],"negotiated_prices":[{"negotiated_rate":10,"negotiated_type":"negotiated"}]},{"provider_references":[1],"negotiated_prices":[{"negotiated_rate":125.5,"negotiated_type":"negotiated"}]}]}`,
+		`{"billing_code_type":"CPT","billing_code":"99214","name":"Office visit","negotiation_arrangement":"ffs","negotiated_rates":[{"provider_groups":[{"npi":[1234567890],"tin":{"type":"ein","value":"12-3456789"}}],"negotiated_prices":[{"negotiated_rate":50,"negotiated_type":"negotiated"}]}]}`,
+		`{"billing_code_type":"CPT","billing_code":"99215","name":"1234567890","negotiation_arrangement":"ffs","negotiated_rates":[{"provider_references":[2],"negotiated_prices":[{"negotiated_rate":99,"negotiated_type":"negotiated"}]}]}`,
+	}, "\n"))
+	targetNPIs := map[int64]struct{}{1234567890: {}}
+	matchedProviders := &MatchedProviders{
+		ByGroupID: map[float64][]ProviderInfo{1: {{NPI: 1234567890}}},
+	}
+
+	prevSimd, prevScan := useSimd, useScan
+	defer func() { useSimd, useScan = prevSimd, prevScan }()
+	run := func(scan bool) []RateResult {
+		useSimd, useScan = false, scan
+		var mu sync.Mutex
+		var results []RateResult
+		err := ParseInNetwork([]string{f}, &SearchOptions{NPIs: targetNPIs}, matchedProviders, "test", nil,
+			func(rs []RateResult) {
+				mu.Lock()
+				results = append(results, rs...)
+				mu.Unlock()
+			})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Slice(results, func(i, j int) bool { return results[i].BillingCode < results[j].BillingCode })
+		return results
+	}
+	stdlib, scan := run(false), run(true)
+	if len(stdlib) != 2 {
+		t.Fatalf("stdlib: expected 2 results, got %d", len(stdlib))
+	}
+	if !reflect.DeepEqual(scan, stdlib) {
+		t.Errorf("scan found %+v, stdlib %+v", scan, stdlib)
+	}
+}
@@ -0,0 +1,189 @@
+package mrf
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// schemaProfile describes a family of CMS in-network-rates schema versions:
+// the fields each kind of object may carry, keyed by its path in the file
+// ("" is the top-level object). A field outside its profile means the file
+// follows a schema this parser doesn't know, and may carry data that would
+// otherwise be dropped without notice.
+type schemaProfile struct {
+	name   string
+	fields map[string][]string
+}
+
+var schemaV1 = &schemaProfile{
+	name: "1.x",
+	fields: map[string][]string{
+		"": {"reporting_entity_name", "reporting_entity_type", "plan_name", "plan_id_type", "plan_id",
+			"plan_market_type", "last_updated_on", "version", "provider_references", "in_network"},
+		"provider_references[]":                   {"provider_group_id", "provider_groups", "location"},
+		"provider_references[].provider_groups[]": {"npi", "tin"},
+		"in_network[]": {"negotiation_arrangement", "name", "billing_code_type", "billing_code_type_version",
+			"billing_code", "description", "negotiated_rates", "bundled_codes", "covered_services"},
+		"in_network[].negotiated_rates[]":                   {"provider_groups", "provider_references", "negotiated_prices"},
+		"in_network[].negotiated_rates[].provider_groups[]": {"npi", "tin"},
+		"in_network[].negotiated_rates[].negotiated_prices[]": {"negotiated_type", "negotiated_rate", "expiration_date",
+			"service_code", "billing_class", "setting", "billing_code_modifier", "additional_information"},
+	},
+}
+
+// schemaV2 adds issuer and plan sponsor names, network names on provider
+// references, and severity of illness for DRG-style codes.
+var schemaV2 = schemaV1.extend("2.x", map[string][]string{
+	"":                      {"issuer_name", "plan_sponsor_name"},
+	"provider_references[]": {"network_name"},
+	"in_network[]":          {"severity_of_illness"},
+})
+
+// latestSchema is used for files whose version is missing or unknown.
+var latestSchema = schemaV2
+
+// extend returns a profile with p's fields plus more.
+func (p *schemaProfile) extend(name string, more map[string][]string) *schemaProfile {
+	out := &schemaProfile{name: name, fields: make(map[string][]string, len(p.fields))}
+	for path, keys := range p.fields {
+		out.fields[path] = append([]string(nil), keys...)
+	}
+	for path, keys := range more {
+		out.fields[path] = append(out.fields[path], keys...)
+	}
+	return out
+}
+
+// schemaForVersion returns the profile for a file's version field, and
+// whether the version is one this parser knows.
+func schemaForVersion(version string) (*schemaProfile, bool) {
+	switch major, _, _ := strings.Cut(strings.TrimPrefix(strings.TrimSpace(version), "v"), "."); major {
+	case "1":
+		return schemaV1, true
+	case "2":
+		return schemaV2, true
+	}
+	return latestSchema, false
+}
+
+// schemaSampleSize is how many elements of each top-level array are checked
+// against the profile, and schemaSampleChildren how many of each nested
+// array. Files are uniform enough that this finds unknown fields without
+// costing anything noticeable on multi-GB arrays.
+const (
+	schemaSampleSize     = 50
+	schemaSampleChildren = 5
+)
+
+// schemaCheck detects a file's schema version and warns, once each, about
+// an unknown version and about fields outside the version's profile.
+type schemaCheck struct {
+	version string
+	profile *schemaProfile
+	warn    func(msg string)
+
+	mu      sync.Mutex
+	sampled map[string]int  // elements checked per top-level array
+	warned  map[string]bool // unknown field paths already reported
+}
+
+func newSchemaCheck(warn func(msg string)) *schemaCheck {
+	return &schemaCheck{
+		profile: latestSchema,
+		warn:    warn,
+		sampled: make(map[string]int),
+		warned:  make(map[string]bool),
+	}
+}
+
+// setVersion selects the profile for the file's version field.
+func (s *schemaCheck) setVersion(version string) {
+	s.version = version
+	profile, known := schemaForVersion(version)
+	s.profile = profile
+	if !known {
+		s.report(fmt.Sprintf("unknown MRF schema version %q; parsing as %s, fields it does not define may be missed", version, profile.name))
+	}
+}
+
+// topLevelKey checks a top-level key against the profile.
+func (s *schemaCheck) topLevelKey(key string) {
+	s.checkKey("", key)
+}
+
+// element checks one element of the top-level array named key (an
+// early sample only; later elements return immediately).
+func (s *schemaCheck) element(key string, raw json.RawMessage) {
+	s.mu.Lock()
+	n := s.sampled[key]
+	s.sampled[key] = n + 1
+	s.mu.Unlock()
+	if n >= schemaSampleSize {
+		return
+	}
+	path := key + "[]"
+	s.object(path, raw)
+	if key == "provider_references" && n == 0 {
+		var ref struct {
+			Location string `json:"location"`
+		}
+		if json.Unmarshal(raw, &ref) == nil && ref.Location != "" {
+			s.report("provider_references point to remote files (location), which are not fetched; their providers are not matched")
+		}
+	}
+}
+
+// object checks raw's keys at path, recursing into the nested arrays the
+// profile describes.
+func (s *schemaCheck) object(path string, raw json.RawMessage) {
+	var obj map[string]json.RawMessage
+	if json.Unmarshal(raw, &obj) != nil {
+		return // not an object; the parser reports malformed elements
+	}
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s.checkKey(path, k)
+		child := path + "." + k + "[]"
+		if _, ok := s.profile.fields[child]; !ok {
+			continue
+		}
+		var elems []json.RawMessage
+		if json.Unmarshal(obj[k], &elems) != nil {
+			continue
+		}
+		for _, e := range elems[:min(len(elems), schemaSampleChildren)] {
+			s.object(child, e)
+		}
+	}
+}
+
+func (s *schemaCheck) checkKey(path, key string) {
+	for _, k := range s.profile.fields[path] {
+		if k == key {
+			return
+		}
+	}
+	field := key
+	if path != "" {
+		field = path + "." + key
+	}
+	s.report(fmt.Sprintf("field %s is not in MRF schema %s and is not extracted", field, s.profile.name))
+}
+
+// report passes msg to warn the first time it is seen.
+func (s *schemaCheck) report(msg string) {
+	s.mu.Lock()
+	seen := s.warned[msg]
+	s.warned[msg] = true
+	s.mu.Unlock()
+	if !seen && s.warn != nil {
+		s.warn(msg)
+	}
+}
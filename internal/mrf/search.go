@@ -0,0 +1,97 @@
+package mrf
+
+// SearchOptions are what a search looks for, and what it does with the
+// rates it finds. ParseProviderReferences, ParseInNetwork and StreamParse
+// each take them, so searches with different options can run at once in one
+// process. A nil *SearchOptions searches for nothing. Options must not
+// change while a file is being parsed with them.
+type SearchOptions struct {
+	// NPIs are the providers searched for.
+	NPIs map[int64]struct{}
+
+	// TINs are searched for alongside the NPIs: a provider group whose
+	// tin.value is one of them matches as if each of its NPIs had been
+	// requested, and its results are flagged MatchedByTIN. Only digits are
+	// compared, so "12-3456789" and "123456789" match each other.
+	TINs []string
+
+	// GroupIDs target provider groups by provider_group_id, such as ones
+	// found in an earlier search, instead of finding groups by NPI or TIN.
+	// provider_references are then not scanned at all (Phase A is skipped):
+	// every rate referencing one of the groups is a match, reported with
+	// NPI 0, no TIN and ProviderGroupID set, since the group's members are
+	// never read. Inline provider groups have no ID and only match the NPI
+	// and TIN targets. IDs must be positive: a ProviderGroupID of 0 means
+	// none, so results for group 0 could not be told apart.
+	GroupIDs []int64
+
+	// Matchers are further search targets, alongside the NPIs and TINs; a
+	// provider matched by any of them is a match.
+	Matchers []Matcher
+
+	// BillingCodes and BillingCodeTypes limit in_network parsing to items
+	// whose billing_code is one of BillingCodes and whose
+	// billing_code_type is one of BillingCodeTypes (compared
+	// case-insensitively). Either may be empty to leave that field
+	// unfiltered. Non-matching items are skipped before provider matching,
+	// and when codes are given most are rejected from their raw bytes
+	// without being parsed at all.
+	BillingCodes     []string
+	BillingCodeTypes []string
+
+	// SkipBundled skips items whose negotiation_arrangement is "bundle" or
+	// "capitation", whose rates pay for a set of services rather than the
+	// billing code alone.
+	SkipBundled bool
+
+	// MinRate and MaxRate drop prices outside them at emit time; a MaxRate
+	// of 0 sets no upper bound. Negative rates are never valid, so MinRate
+	// defaults to 0; zero rates are kept but counted (see Counts), since
+	// payers use them both for real $0 rates and as placeholders.
+	MinRate, MaxRate float64
+
+	// CollapseProviders makes each negotiated rate yield one result per
+	// provider group TIN and price, with the group's matched NPIs listed in
+	// RateResult.NPIs, instead of one per matched NPI. Searching many NPIs
+	// under one TIN otherwise repeats every rate once per NPI.
+	CollapseProviders bool
+
+	// Provenance records where each result's in_network item is in the
+	// payer's file (see Provenance), so a disputed rate can be found again
+	// without repeating the search: with --keep-split output, explore's
+	// "show file:line" prints the item. It adds a little to every result.
+	Provenance bool
+
+	// ItemHook, if set, sees every in_network item that matched the search
+	// (providers, billing codes and the other filters) in full, for
+	// extractions the rate results don't cover.
+	ItemHook ItemHook
+
+	// Enrichers run, in order, on every result before it is emitted.
+	Enrichers []Enricher
+
+	// Counts, if set, counts the results dropped for a price outside
+	// MinRate and MaxRate and those kept with a zero price, across every
+	// file parsed with these options.
+	Counts *RateCounts
+}
+
+// search is a SearchOptions prepared for parsing, with its matcher and
+// billing code filter built.
+type search struct {
+	*SearchOptions
+	m      Matcher
+	filter *codeFilter
+}
+
+// prepare builds the search o describes; o may be nil.
+func (o *SearchOptions) prepare() *search {
+	if o == nil {
+		o = &SearchOptions{}
+	}
+	return &search{
+		SearchOptions: o,
+		m:             o.matcher(),
+		filter:        newCodeFilter(o.BillingCodes, o.BillingCodeTypes),
+	}
+}
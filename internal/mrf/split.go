@@ -19,12 +19,20 @@ type SplitResult struct {
 
 // SplitFile splits a JSON file (optionally gzipped) into NDJSON files using jsplit.
 // Returns the paths to the provider_references and in_network NDJSON files.
-func SplitFile(inputPath, outputDir string) (*SplitResult, error) {
-	err := jsplit.Split(inputPath, outputDir, true)
+// A panic inside jsplit is returned as a *PanicError.
+func SplitFile(inputPath, outputDir string) (_ *SplitResult, err error) {
+	defer RecoverPanic(&err)
+
+	err = jsplit.Split(inputPath, outputDir, true)
 	if err != nil {
 		return nil, fmt.Errorf("jsplit split failed: %w", err)
 	}
 
+	return OpenSplitDir(outputDir)
+}
+
+// OpenSplitDir lists the NDJSON files of a directory SplitFile wrote.
+func OpenSplitDir(outputDir string) (*SplitResult, error) {
 	result := &SplitResult{Dir: outputDir}
 
 	entries, err := os.ReadDir(outputDir)
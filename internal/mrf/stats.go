@@ -0,0 +1,185 @@
+package mrf
+
+import (
+	"encoding/json"
+	"io"
+	"math/rand/v2"
+	"sort"
+)
+
+// rateSampleSize bounds the prices kept per unit for the median, which is
+// estimated from a uniform sample once a file has more.
+const rateSampleSize = 100_000
+
+// sampleSeed seeds each distribution's sampling, so that the same rates
+// added in the same order give the same approximate median.
+const sampleSeed = 0x6e70692d72617465
+
+// FileStats profiles a whole MRF file, without filtering by provider.
+type FileStats struct {
+	Version             string `json:"version,omitempty"`
+	ReportingEntityName string `json:"reporting_entity_name,omitempty"`
+
+	ProviderReferences int64 `json:"provider_references"`
+	ProviderGroups     int64 `json:"provider_groups"` // in provider_references and inline
+	DistinctNPIs       int64 `json:"distinct_npis"`
+	DistinctTINs       int64 `json:"distinct_tins"`
+
+	InNetworkItems   int64 `json:"in_network_items"`
+	NegotiatedRates  int64 `json:"negotiated_rates"`
+	NegotiatedPrices int64 `json:"negotiated_prices"`
+
+	BillingCodeTypes map[string]int64 `json:"billing_code_types"`       // in_network items per type
+	Arrangements     map[string]int64 `json:"negotiation_arrangements"` // in_network items per arrangement
+
+	// Rates describes negotiated_rate by unit (see UnitFor), since rates in
+	// different units can't be pooled.
+	Rates map[string]*RateDistribution `json:"rates"`
+}
+
+// RateDistribution summarizes the negotiated rates in one unit.
+type RateDistribution struct {
+	Count int64   `json:"count"`
+	Min   float64 `json:"min"`
+	// Median is exact up to rateSampleSize rates. Beyond that it is an
+	// approximate median, of a uniform sample, and MedianApproximate is set.
+	Median            float64 `json:"median"`
+	MedianApproximate bool    `json:"median_approximate,omitempty"`
+	Max               float64 `json:"max"`
+
+	sample []float64
+	rng    *rand.Rand // picks the sample once it is full
+}
+
+func (d *RateDistribution) add(v float64) {
+	d.Count++
+	if d.Count == 1 || v < d.Min {
+		d.Min = v
+	}
+	if d.Count == 1 || v > d.Max {
+		d.Max = v
+	}
+	// Reservoir sampling keeps a uniform sample of every price seen.
+	if len(d.sample) < rateSampleSize {
+		d.sample = append(d.sample, v)
+		return
+	}
+	if d.rng == nil {
+		d.rng = rand.New(rand.NewPCG(sampleSeed, sampleSeed))
+	}
+	if i := d.rng.Int64N(d.Count); i < rateSampleSize {
+		d.sample[i] = v
+	}
+}
+
+func (d *RateDistribution) finish() {
+	sort.Float64s(d.sample)
+	if n := len(d.sample); n%2 == 1 {
+		d.Median = d.sample[n/2]
+	} else if n > 0 {
+		d.Median = (d.sample[n/2-1] + d.sample[n/2]) / 2
+	}
+	d.MedianApproximate = d.Count > int64(len(d.sample))
+	d.sample, d.rng = nil, nil
+}
+
+// Stats streams an uncompressed MRF in-network file from r and profiles it:
+// provider reference, group, NPI and TIN counts, in_network item counts by
+// billing code type and arrangement, and the distribution of negotiated
+// rates. Memory grows with the number of distinct NPIs and TINs only.
+func Stats(r io.Reader) (*FileStats, error) {
+	c := newStatsCollector()
+	dec := json.NewDecoder(r)
+	value := func(key string, val any) {
+		switch key {
+		case "version":
+			c.st.Version, _ = val.(string)
+		case "reporting_entity_name":
+			c.st.ReportingEntityName, _ = val.(string)
+		}
+	}
+	element := func(key string, _ int64) error {
+		if key == "provider_references" {
+			var ref ProviderReference
+			if err := dec.Decode(&ref); err != nil {
+				return err
+			}
+			c.addRef(&ref)
+			return nil
+		}
+		var item InNetworkItem
+		if err := dec.Decode(&item); err != nil {
+			return err
+		}
+		c.addItem(&item)
+		return nil
+	}
+	if _, err := walkTopLevel(dec, value, element); err != nil {
+		return nil, err
+	}
+	return c.finish(), nil
+}
+
+// statsCollector builds FileStats from a file's elements.
+type statsCollector struct {
+	st   *FileStats
+	npis map[int64]struct{}
+	tins map[TIN]struct{}
+}
+
+func newStatsCollector() *statsCollector {
+	return &statsCollector{
+		st: &FileStats{
+			BillingCodeTypes: make(map[string]int64),
+			Arrangements:     make(map[string]int64),
+			Rates:            make(map[string]*RateDistribution),
+		},
+		npis: make(map[int64]struct{}),
+		tins: make(map[TIN]struct{}),
+	}
+}
+
+func (c *statsCollector) addGroups(groups []ProviderGroup) {
+	c.st.ProviderGroups += int64(len(groups))
+	for _, g := range groups {
+		for _, n := range g.NPI {
+			c.npis[n] = struct{}{}
+		}
+		c.tins[g.TIN] = struct{}{}
+	}
+}
+
+func (c *statsCollector) addRef(ref *ProviderReference) {
+	c.st.ProviderReferences++
+	c.addGroups(ref.ProviderGroups)
+}
+
+func (c *statsCollector) addItem(item *InNetworkItem) {
+	st := c.st
+	st.InNetworkItems++
+	st.BillingCodeTypes[item.BillingCodeType]++
+	st.Arrangements[item.NegotiationArrangement]++
+	for _, nr := range item.NegotiatedRates {
+		st.NegotiatedRates++
+		c.addGroups(nr.ProviderGroups)
+		for _, p := range nr.NegotiatedPrices {
+			st.NegotiatedPrices++
+			unit := UnitFor(p.NegotiatedType)
+			d := st.Rates[unit]
+			if d == nil {
+				d = &RateDistribution{}
+				st.Rates[unit] = d
+			}
+			d.add(p.NegotiatedRate)
+		}
+	}
+}
+
+func (c *statsCollector) finish() *FileStats {
+	c.st.DistinctNPIs = int64(len(c.npis))
+	c.st.DistinctTINs = int64(len(c.tins))
+	for _, d := range c.st.Rates {
+		d.finish()
+	}
+	return c.st
+}
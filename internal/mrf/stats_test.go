@@ -0,0 +1,50 @@
+package mrf
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	input := `{
+	"reporting_entity_name": "Payer", "version": "1.3.1",
+	"provider_references": [
+		{"provider_group_id": 1, "provider_groups": [{"npi": [1111111111, 2222222222], "tin": {"type": "ein", "value": "11-1111111"}}]},
+		{"provider_group_id": 2, "provider_groups": [{"npi": [2222222222], "tin": {"type": "ein", "value": "22-2222222"}}]}
+	],
+	"in_network": [
+		{"negotiation_arrangement": "ffs", "billing_code_type": "CPT", "billing_code": "99213", "negotiated_rates": [
+			{"provider_references": [1], "negotiated_prices": [
+				{"negotiated_type": "negotiated", "negotiated_rate": 100},
+				{"negotiated_type": "negotiated", "negotiated_rate": 300},
+				{"negotiated_type": "percentage", "negotiated_rate": 80}]},
+			{"provider_groups": [{"npi": [3333333333], "tin": {"type": "ein", "value": "11-1111111"}}],
+				"negotiated_prices": [{"negotiated_type": "fee schedule", "negotiated_rate": 200}]}]},
+		{"negotiation_arrangement": "bundle", "billing_code_type": "MS-DRG", "billing_code": "470", "negotiated_rates": [
+			{"provider_references": [2], "negotiated_prices": [{"negotiated_type": "negotiated", "negotiated_rate": 50}]}]}
+	]
+}`
+	st, err := Stats(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Stats failed: %v", err)
+	}
+	if st.ReportingEntityName != "Payer" || st.Version != "1.3.1" {
+		t.Errorf("unexpected header fields: %+v", st)
+	}
+	if st.ProviderReferences != 2 || st.ProviderGroups != 3 || st.DistinctNPIs != 3 || st.DistinctTINs != 2 {
+		t.Errorf("unexpected provider counts: %+v", st)
+	}
+	if st.InNetworkItems != 2 || st.NegotiatedRates != 3 || st.NegotiatedPrices != 5 {
+		t.Errorf("unexpected in_network counts: %+v", st)
+	}
+	if st.BillingCodeTypes["CPT"] != 1 || st.BillingCodeTypes["MS-DRG"] != 1 || st.Arrangements["bundle"] != 1 {
+		t.Errorf("unexpected distributions: %v %v", st.BillingCodeTypes, st.Arrangements)
+	}
+	usd := st.Rates[UnitUSD]
+	if usd == nil || usd.Count != 4 || usd.Min != 50 || usd.Median != 150 || usd.Max != 300 {
+		t.Errorf("unexpected USD rates: %+v", usd)
+	}
+	if pct := st.Rates[UnitPercent]; pct == nil || pct.Count != 1 || pct.Median != 80 {
+		t.Errorf("unexpected percent rates: %+v", pct)
+	}
+}
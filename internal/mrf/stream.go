@@ -4,17 +4,41 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"runtime"
 	"sync"
 
+	"github.com/gyeh/npi-rates/internal/logx"
 	simdjson "github.com/minio/simdjson-go"
 )
 
+// maxPooledRawSize caps the capacity of raw element buffers returned to
+// rawPool. Pathological in_network elements can be hundreds of MB; keeping
+// those alive in the pool would pin that memory for the rest of the run.
+const maxPooledRawSize = 16 * 1024 * 1024
+
+// rawPool recycles the raw JSON buffers handed from the serial decode loop to
+// the in_network workers, so the decoder doesn't allocate a fresh slice for
+// every element.
+var rawPool = sync.Pool{
+	New: func() any { return new(json.RawMessage) },
+}
+
+// putRaw returns a raw element buffer to rawPool unless it has grown too large.
+func putRaw(raw *json.RawMessage) {
+	if cap(*raw) > maxPooledRawSize {
+		return
+	}
+	*raw = (*raw)[:0]
+	rawPool.Put(raw)
+}
+
 // StreamResult is the return value from StreamParse, indicating whether a
 // second pass is needed (when in_network appeared before provider_references).
 type StreamResult struct {
 	NeedSecondPass   bool
 	MatchedProviders *MatchedProviders
+
+	// SchemaVersion is the file's version field, "" if it has none.
+	SchemaVersion string
 }
 
 // StreamCallbacks holds all callbacks for StreamParse progress reporting.
@@ -23,6 +47,12 @@ type StreamCallbacks struct {
 	OnCodeScanned func()             // called for each in_network element
 	OnStageChange func(stage string) // called when transitioning between phases
 	OnWarning     func(msg string)   // called for non-fatal issues
+
+	// OnLayout is called once on a first pass, as soon as it is known
+	// whether the file will need a second one: with true when in_network
+	// is skipped for preceding provider_references, with false when
+	// provider_references comes first.
+	OnLayout func(inNetworkFirst bool)
 }
 
 // StreamParse walks a top-level MRF JSON object from r using a streaming
@@ -36,13 +66,23 @@ type StreamCallbacks struct {
 // re-download and re-parse with the prebuilt MatchedProviders.
 //
 // If prebuilt is non-nil (second pass), provider_references is skipped and
-// in_network is processed using the prebuilt index.
+// in_network is processed using the prebuilt index. Groups targeted by ID
+// (see SearchOptions.GroupIDs) are such an index, so one pass always
+// suffices.
+//
+// The file's version field selects the schema profile that elements are
+// checked against; an unknown version, or fields the profile doesn't define,
+// are reported once each through OnWarning.
+//
+// emit receives one batch of results per matching in_network item, for the
+// providers opts searches for, and may be called concurrently from multiple
+// goroutines.
 func StreamParse(
 	r io.Reader,
-	targetNPIs map[int64]struct{},
+	opts *SearchOptions,
 	sourceFile string,
 	cb StreamCallbacks,
-	emit func(RateResult),
+	emit func([]RateResult),
 	prebuilt *MatchedProviders,
 ) (*StreamResult, error) {
 	dec := json.NewDecoder(r)
@@ -57,6 +97,9 @@ func StreamParse(
 	}
 
 	// On second pass, use the prebuilt index directly.
+	if prebuilt == nil {
+		prebuilt = opts.SeededProviders()
+	}
 	var matched *MatchedProviders
 	if prebuilt != nil {
 		matched = prebuilt
@@ -65,7 +108,20 @@ func StreamParse(
 			ByGroupID: make(map[float64][]ProviderInfo),
 		}
 	}
-	patterns := npiBytePatterns(targetNPIs)
+	search := opts.prepare()
+	m := search.m
+	patterns := m.Patterns()
+
+	if matched.schema == nil {
+		matched.schema = newSchemaCheck(nil)
+	}
+	schema := matched.schema
+	schema.warn = func(msg string) {
+		logx.Verbosef("%s: %s", sourceFile, msg)
+		if cb.OnWarning != nil {
+			cb.OnWarning(msg)
+		}
+	}
 
 	var pj *simdjson.ParsedJson // reused across simdjson.Parse calls
 
@@ -85,6 +141,18 @@ func StreamParse(
 		}
 
 		switch key {
+		case "version":
+			tok, err := dec.Token()
+			if err != nil {
+				return nil, fmt.Errorf("reading version: %w", err)
+			}
+			version, ok := tok.(string)
+			if !ok {
+				version = fmt.Sprint(tok)
+			}
+			logx.Verbosef("%s: schema version %s", sourceFile, version)
+			schema.setVersion(version)
+
 		case "provider_references":
 			if prebuilt != nil {
 				// Second pass: skip provider_references, already indexed.
@@ -93,6 +161,9 @@ func StreamParse(
 				}
 				continue
 			}
+			if !seenProviderRefs && !skippedInNetwork && cb.OnLayout != nil {
+				cb.OnLayout(false)
+			}
 			seenProviderRefs = true
 			if cb.OnStageChange != nil {
 				cb.OnStageChange("Streaming: provider_references")
@@ -103,10 +174,14 @@ func StreamParse(
 					cb.OnRefScanned()
 				}
 			}
-			pj, err = streamProviderReferences(dec, targetNPIs, patterns, matched, pj, countingOnRef)
+			pj, err = streamProviderReferences(dec, m, patterns, matched, pj, schema, countingOnRef)
 			if err != nil {
 				return nil, fmt.Errorf("streaming provider_references: %w", err)
 			}
+			logx.Verbosef("%s: provider_references: %d scanned, %d groups matched", sourceFile, refsCount, len(matched.ByGroupID))
+			if msg := matched.CappedWarning(); msg != "" && cb.OnWarning != nil {
+				cb.OnWarning(msg)
+			}
 
 		case "in_network":
 			if prebuilt == nil && !seenProviderRefs {
@@ -114,6 +189,10 @@ func StreamParse(
 				if cb.OnWarning != nil {
 					cb.OnWarning("in_network appeared before provider_references; will require second pass")
 				}
+				logx.Verbosef("%s: in_network precedes provider_references; skipping it on this pass", sourceFile)
+				if !skippedInNetwork && cb.OnLayout != nil {
+					cb.OnLayout(true)
+				}
 				skippedInNetwork = true
 				if err := skipValue(dec); err != nil {
 					return nil, fmt.Errorf("skipping in_network (reversed order): %w", err)
@@ -122,6 +201,7 @@ func StreamParse(
 			}
 			if prebuilt == nil && seenProviderRefs && refsCount > 0 && len(matched.ByGroupID) == 0 {
 				// provider_references had entries but yielded no NPI matches; skip in_network.
+				logx.Verbosef("%s: no provider_references matched; skipping in_network", sourceFile)
 				if cb.OnStageChange != nil {
 					cb.OnStageChange("Skipping: in_network (no matching providers)")
 				}
@@ -133,13 +213,14 @@ func StreamParse(
 			if cb.OnStageChange != nil {
 				cb.OnStageChange("Streaming: in_network")
 			}
-			pj, err = streamInNetwork(dec, targetNPIs, matched, sourceFile, pj, cb.OnCodeScanned, emit)
+			pj, err = streamInNetwork(dec, search, matched, sourceFile, pj, schema, cb.OnCodeScanned, emit)
 			if err != nil {
 				return nil, fmt.Errorf("streaming in_network: %w", err)
 			}
 
 		default:
 			// Skip unneeded keys (reporting_entity_name, etc.)
+			schema.topLevelKey(key)
 			if err := skipValue(dec); err != nil {
 				return nil, fmt.Errorf("skipping key %q: %w", key, err)
 			}
@@ -155,9 +236,14 @@ func StreamParse(
 		return nil, fmt.Errorf("expected '}', got %v", tok)
 	}
 
+	if schema.version == "" {
+		logx.Verbosef("%s: no schema version field; parsing as %s", sourceFile, schema.profile.name)
+	}
+
 	return &StreamResult{
 		NeedSecondPass:   skippedInNetwork && len(matched.ByGroupID) > 0,
 		MatchedProviders: matched,
+		SchemaVersion:    schema.version,
 	}, nil
 }
 
@@ -165,10 +251,11 @@ func StreamParse(
 // element, building MatchedProviders. Returns the (possibly reused) ParsedJson.
 func streamProviderReferences(
 	dec *json.Decoder,
-	targetNPIs map[int64]struct{},
+	m Matcher,
 	patterns [][]byte,
 	matched *MatchedProviders,
 	pj *simdjson.ParsedJson,
+	schema *schemaCheck,
 	onRefScanned func(),
 ) (*simdjson.ParsedJson, error) {
 	// Expect opening '['.
@@ -180,9 +267,11 @@ func streamProviderReferences(
 		return pj, fmt.Errorf("expected '[', got %v", tok)
 	}
 
+	// Elements are processed serially, so a single buffer is reused for all of
+	// them. Matches are copied out by the parsers before the next Decode.
+	var raw json.RawMessage
 	for dec.More() {
-		// Read one element as raw JSON (byte array).
-		var raw json.RawMessage
+		raw = raw[:0]
 		if err := dec.Decode(&raw); err != nil {
 			return pj, fmt.Errorf("decoding element: %w", err)
 		}
@@ -190,9 +279,10 @@ func streamProviderReferences(
 		if onRefScanned != nil {
 			onRefScanned()
 		}
+		schema.element("provider_references", raw)
 
-		// Pre-filter: skip elements that don't contain any target NPI as substring.
-		if !lineContainsAny(raw, patterns) {
+		// Pre-filter: skip elements that don't contain any target NPI or TIN as substring.
+		if !prefilter(raw, patterns) {
 			continue
 		}
 
@@ -203,7 +293,7 @@ func streamProviderReferences(
 				continue // skip malformed
 			}
 			pj.ForEach(func(i simdjson.Iter) error {
-				extractProviderRef(i, targetNPIs, matched)
+				extractProviderRef(i, m, matched)
 				return nil
 			})
 		} else {
@@ -212,13 +302,8 @@ func streamProviderReferences(
 				continue
 			}
 			for _, pg := range ref.ProviderGroups {
-				for _, npi := range pg.NPI {
-					if _, ok := targetNPIs[npi]; ok {
-						matched.ByGroupID[ref.ProviderGroupID] = append(
-							matched.ByGroupID[ref.ProviderGroupID],
-							ProviderInfo{NPI: npi, TIN: pg.TIN},
-						)
-					}
+				if infos := m.Match(nil, Group{RefID: ref.ProviderGroupID, NPIs: pg.NPI, TIN: pg.TIN}); len(infos) > 0 {
+					matched.add(ref.ProviderGroupID, infos)
 				}
 			}
 		}
@@ -235,16 +320,17 @@ func streamProviderReferences(
 
 // streamInNetwork reads the in_network JSON array element by element.
 // Decoding is serial (json.Decoder requires it), but simdjson matching and
-// stdlib unmarshalling are fanned out to GOMAXPROCS workers for parallel
-// processing. Each worker holds its own *simdjson.ParsedJson.
+// stdlib unmarshalling are fanned out to ParseParallelism() workers for
+// parallel processing. Each worker holds its own *simdjson.ParsedJson.
 func streamInNetwork(
 	dec *json.Decoder,
-	targetNPIs map[int64]struct{},
+	s *search,
 	matched *MatchedProviders,
 	sourceFile string,
 	pj *simdjson.ParsedJson,
+	schema *schemaCheck,
 	onCodeScanned func(),
-	emit func(RateResult),
+	emit func([]RateResult),
 ) (*simdjson.ParsedJson, error) {
 	// Expect opening '['.
 	tok, err := dec.Token()
@@ -256,26 +342,69 @@ func streamInNetwork(
 	}
 
 	// Fan out element processing to workers.
-	numWorkers := runtime.GOMAXPROCS(0)
-	ch := make(chan json.RawMessage, numWorkers*2)
-
-	var wg sync.WaitGroup
+	numWorkers := ParseParallelism()
+	// Besides the queue depth, the bytes held are capped by streamBudget.
+	type element struct {
+		raw    *json.RawMessage
+		budget int64
+		index  int64 // 1-based position in the array
+	}
+	ch := make(chan element, numWorkers*queueDepth)
+
+	// A panic in one worker is kept as the pass's error; the worker then
+	// drains the channel so the decode loop can finish.
+	var (
+		wg       sync.WaitGroup
+		panicErr error
+		panicMu  sync.Mutex
+	)
 	for i := 0; i < numWorkers; i++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
+			var (
+				err error
+				cur element // being processed, so a panic still releases its budget
+			)
+			defer func() {
+				if err == nil {
+					return
+				}
+				panicMu.Lock()
+				if panicErr == nil {
+					panicErr = err
+				}
+				panicMu.Unlock()
+				streamBudget.release(cur.budget)
+				for el := range ch {
+					streamBudget.release(el.budget)
+					putRaw(el.raw)
+				}
+			}()
+			defer RecoverPanic(&err)
 			var workerPJ *simdjson.ParsedJson
-			for raw := range ch {
-				processInNetworkElement(raw, targetNPIs, matched, sourceFile, &workerPJ, emit)
+			for cur = range ch {
+				var at *Provenance
+				if s.Provenance {
+					at = &Provenance{Element: cur.index}
+				}
+				processInNetworkElement(*cur.raw, at, s, matched, sourceFile, &workerPJ, emit)
+				streamBudget.release(cur.budget)
+				putRaw(cur.raw)
+				cur = element{}
 			}
 		}()
 	}
 
 	// Decode loop — serial, feeds workers via channel.
-	var decErr error
+	var (
+		decErr error
+		index  int64
+	)
 	for dec.More() {
-		var raw json.RawMessage
-		if err := dec.Decode(&raw); err != nil {
+		raw := rawPool.Get().(*json.RawMessage)
+		if err := dec.Decode(raw); err != nil {
+			putRaw(raw)
 			decErr = fmt.Errorf("decoding element: %w", err)
 			break
 		}
@@ -283,12 +412,17 @@ func streamInNetwork(
 		if onCodeScanned != nil {
 			onCodeScanned()
 		}
+		schema.element("in_network", *raw)
 
-		ch <- raw
+		index++
+		ch <- element{raw, streamBudget.acquire(int64(len(*raw))), index}
 	}
 	close(ch)
 	wg.Wait()
 
+	if panicErr != nil {
+		return nil, fmt.Errorf("processing element: %w", panicErr)
+	}
 	if decErr != nil {
 		return nil, decErr
 	}
@@ -303,16 +437,22 @@ func streamInNetwork(
 }
 
 // processInNetworkElement checks a single in_network element for NPI matches
-// and emits results. Called from worker goroutines — targetNPIs and matched
-// are read-only at this point; emit must be safe for concurrent calls.
+// and emits results, located by at (nil unless provenance is recorded).
+// Called from worker goroutines — s and matched are read-only at this
+// point; emit must be safe for concurrent calls.
 func processInNetworkElement(
 	raw json.RawMessage,
-	targetNPIs map[int64]struct{},
+	at *Provenance,
+	s *search,
 	matched *MatchedProviders,
 	sourceFile string,
 	pj **simdjson.ParsedJson,
-	emit func(RateResult),
+	emit func([]RateResult),
 ) {
+	if !s.filter.prefilter(raw) {
+		return
+	}
+
 	if useSimd {
 		var err error
 		*pj, err = simdjson.Parse(raw, *pj)
@@ -321,12 +461,14 @@ func processInNetworkElement(
 		}
 		isMatch := false
 		(*pj).ForEach(func(i simdjson.Iter) error {
-			isMatch = checkNPIMatchSimd(i, targetNPIs, matched)
+			isMatch = checkNPIMatchSimd(i, s.m, matched)
 			return nil
 		})
 		if !isMatch {
 			return
 		}
+	} else if useScan && !checkNPIMatchScan(raw, s.m, matched) {
+		return
 	}
 
 	var item InNetworkItem
@@ -334,7 +476,7 @@ func processInNetworkElement(
 		return
 	}
 
-	emitInNetworkResults(&item, targetNPIs, matched, sourceFile, emit)
+	emitInNetworkResults(&item, s, matched, sourceFile, at, emit)
 }
 
 // skipValue reads and discards the next JSON value from the decoder.
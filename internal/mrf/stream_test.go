@@ -1,8 +1,13 @@
 package mrf
 
 import (
+	"errors"
+	"fmt"
 	"strings"
+	"sync"
 	"testing"
+
+	simdjson "github.com/minio/simdjson-go"
 )
 
 func TestStreamParse_BasicMRF(t *testing.T) {
@@ -53,14 +58,14 @@ func TestStreamParse_BasicMRF(t *testing.T) {
 
 	sr, err := StreamParse(
 		strings.NewReader(mrfJSON),
-		targetNPIs,
+		&SearchOptions{NPIs: targetNPIs},
 		"test-source.json.gz",
 		StreamCallbacks{
 			OnRefScanned:  func() { refsScanned++ },
 			OnCodeScanned: func() { codesScanned++ },
 			OnStageChange: func(stage string) { stages = append(stages, stage) },
 		},
-		func(r RateResult) { results = append(results, r) },
+		func(rs []RateResult) { results = append(results, rs...) },
 		nil,
 	)
 	if err != nil {
@@ -93,6 +98,9 @@ func TestStreamParse_BasicMRF(t *testing.T) {
 	if results[0].NPI != 1234567890 {
 		t.Errorf("expected NPI 1234567890, got %d", results[0].NPI)
 	}
+	if results[0].Unit != UnitUSD {
+		t.Errorf("expected unit USD, got %q", results[0].Unit)
+	}
 	if results[0].SourceFile != "test-source.json.gz" {
 		t.Errorf("expected source test-source.json.gz, got %s", results[0].SourceFile)
 	}
@@ -123,10 +131,10 @@ func TestStreamParse_InlineProviderGroups(t *testing.T) {
 
 	_, err := StreamParse(
 		strings.NewReader(mrfJSON),
-		targetNPIs,
+		&SearchOptions{NPIs: targetNPIs},
 		"test.json.gz",
 		StreamCallbacks{},
-		func(r RateResult) { results = append(results, r) },
+		func(rs []RateResult) { results = append(results, rs...) },
 		nil,
 	)
 	if err != nil {
@@ -191,12 +199,12 @@ func TestStreamParse_InNetworkBeforeProviderRefs(t *testing.T) {
 	// First pass: should skip in_network, process provider_references, signal second pass needed.
 	sr, err := StreamParse(
 		strings.NewReader(mrfJSON),
-		targetNPIs,
+		&SearchOptions{NPIs: targetNPIs},
 		"test.json.gz",
 		StreamCallbacks{
 			OnWarning: func(msg string) { warnings = append(warnings, msg) },
 		},
-		func(r RateResult) { results = append(results, r) },
+		func(rs []RateResult) { results = append(results, rs...) },
 		nil,
 	)
 	if err != nil {
@@ -223,10 +231,10 @@ func TestStreamParse_InNetworkBeforeProviderRefs(t *testing.T) {
 	results = nil
 	sr2, err := StreamParse(
 		strings.NewReader(mrfJSON),
-		targetNPIs,
+		&SearchOptions{NPIs: targetNPIs},
 		"test.json.gz",
 		StreamCallbacks{},
-		func(r RateResult) { results = append(results, r) },
+		func(rs []RateResult) { results = append(results, rs...) },
 		sr.MatchedProviders,
 	)
 	if err != nil {
@@ -288,10 +296,10 @@ func TestStreamParse_InNetworkBeforeProviderRefs_NoMatch(t *testing.T) {
 
 	sr, err := StreamParse(
 		strings.NewReader(mrfJSON),
-		targetNPIs,
+		&SearchOptions{NPIs: targetNPIs},
 		"test.json.gz",
 		StreamCallbacks{},
-		func(r RateResult) { results = append(results, r) },
+		func(rs []RateResult) { results = append(results, rs...) },
 		nil,
 	)
 	if err != nil {
@@ -335,12 +343,12 @@ func TestStreamParse_NoMatches(t *testing.T) {
 
 	sr, err := StreamParse(
 		strings.NewReader(mrfJSON),
-		targetNPIs,
+		&SearchOptions{NPIs: targetNPIs},
 		"test.json.gz",
 		StreamCallbacks{
 			OnCodeScanned: func() { codesScanned++ },
 		},
-		func(r RateResult) { results = append(results, r) },
+		func(rs []RateResult) { results = append(results, rs...) },
 		nil,
 	)
 	if err != nil {
@@ -373,10 +381,10 @@ func TestStreamParse_SkipsUnknownKeys(t *testing.T) {
 
 	_, err := StreamParse(
 		strings.NewReader(mrfJSON),
-		targetNPIs,
+		&SearchOptions{NPIs: targetNPIs},
 		"test.json.gz",
 		StreamCallbacks{},
-		func(r RateResult) { results = append(results, r) },
+		func(rs []RateResult) { results = append(results, rs...) },
 		nil,
 	)
 	if err != nil {
@@ -388,6 +396,61 @@ func TestStreamParse_SkipsUnknownKeys(t *testing.T) {
 	}
 }
 
+// TestStreamParse_SchemaVersion verifies that the version field selects a
+// schema profile, and that unknown versions and fields outside the profile
+// are each reported once.
+func TestStreamParse_SchemaVersion(t *testing.T) {
+	mrfJSON := func(version string) string {
+		return `{
+	"version": "` + version + `",
+	"issuer_name": "Test Issuer",
+	"provider_references": [],
+	"in_network": [
+		{"billing_code": "99213", "billing_code_type": "CPT", "negotiated_rates": [
+			{"provider_groups": [{"npi": [1], "tin": {"type": "ein", "value": "1"}}],
+			 "negotiated_prices": [{"negotiated_rate": 1, "price_tier": "A"}]}
+		]},
+		{"billing_code": "99214", "billing_code_type": "CPT", "negotiated_rates": [
+			{"negotiated_prices": [{"negotiated_rate": 2, "price_tier": "B"}]}
+		]}
+	]
+}`
+	}
+	parse := func(version string) (*StreamResult, []string) {
+		var warnings []string
+		sr, err := StreamParse(strings.NewReader(mrfJSON(version)), &SearchOptions{NPIs: map[int64]struct{}{1: {}}}, "test.json",
+			StreamCallbacks{OnWarning: func(msg string) { warnings = append(warnings, msg) }},
+			func([]RateResult) {}, nil)
+		if err != nil {
+			t.Fatalf("StreamParse(%s) failed: %v", version, err)
+		}
+		return sr, warnings
+	}
+
+	sr, warnings := parse("1.0.0")
+	if sr.SchemaVersion != "1.0.0" {
+		t.Errorf("expected SchemaVersion 1.0.0, got %q", sr.SchemaVersion)
+	}
+	want := []string{
+		"field issuer_name is not in MRF schema 1.x and is not extracted",
+		"field in_network[].negotiated_rates[].negotiated_prices[].price_tier is not in MRF schema 1.x and is not extracted",
+	}
+	if strings.Join(warnings, "\n") != strings.Join(want, "\n") {
+		t.Errorf("1.0.0 warnings:\n%s\nwant:\n%s", strings.Join(warnings, "\n"), strings.Join(want, "\n"))
+	}
+
+	// issuer_name is part of 2.x.
+	_, warnings = parse("2.0.0")
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "price_tier") {
+		t.Errorf("2.0.0 warnings: %q", warnings)
+	}
+
+	_, warnings = parse("7.1")
+	if len(warnings) != 2 || !strings.Contains(warnings[0], `unknown MRF schema version "7.1"`) {
+		t.Errorf("7.1 warnings: %q", warnings)
+	}
+}
+
 func TestStreamParse_FloatProviderGroupIDs(t *testing.T) {
 	mrfJSON := `{
 	"provider_references": [
@@ -413,10 +476,10 @@ func TestStreamParse_FloatProviderGroupIDs(t *testing.T) {
 
 	_, err := StreamParse(
 		strings.NewReader(mrfJSON),
-		targetNPIs,
+		&SearchOptions{NPIs: targetNPIs},
 		"test.json.gz",
 		StreamCallbacks{},
-		func(r RateResult) { results = append(results, r) },
+		func(rs []RateResult) { results = append(results, rs...) },
 		nil,
 	)
 	if err != nil {
@@ -434,3 +497,593 @@ func TestStreamParse_FloatProviderGroupIDs(t *testing.T) {
 		t.Errorf("expected 99213, got %s", results[0].BillingCode)
 	}
 }
+
+// TestStreamParse_BillingCodeFilter verifies that the billing code filter
+// drops non-matching items, including ones that would match the NPI, and that
+// code types compare case-insensitively.
+func TestStreamParse_BillingCodeFilter(t *testing.T) {
+	mrfJSON := `{
+	"provider_references": [
+		{"provider_group_id": 1, "provider_groups": [{"npi": [1234567890], "tin": {"type": "ein", "value": "12-3456789"}}]}
+	],
+	"in_network": [
+		{"billing_code_type": "CPT", "billing_code": "99213", "negotiated_rates": [{"provider_references": [1], "negotiated_prices": [{"negotiated_rate": 100}]}]},
+		{"billing_code_type": "CPT", "billing_code": "99214", "negotiated_rates": [{"provider_references": [1], "negotiated_prices": [{"negotiated_rate": 200}]}]},
+		{"billing_code_type": "HCPCS", "billing_code": "99213", "negotiated_rates": [{"provider_references": [1], "negotiated_prices": [{"negotiated_rate": 300}]}]}
+	]
+}`
+
+	tests := []struct {
+		name  string
+		codes []string
+		types []string
+		want  []float64
+	}{
+		{"code only", []string{"99213"}, nil, []float64{100, 300}},
+		{"code and type", []string{"99213"}, []string{"cpt"}, []float64{100}},
+		{"type only", nil, []string{"HCPCS"}, []float64{300}},
+		{"no filter", nil, nil, []float64{100, 200, 300}},
+	}
+	for _, tt := range tests {
+		var mu sync.Mutex
+		got := map[float64]bool{}
+		opts := &SearchOptions{NPIs: map[int64]struct{}{1234567890: {}}, BillingCodes: tt.codes, BillingCodeTypes: tt.types}
+		_, err := StreamParse(strings.NewReader(mrfJSON), opts, "src",
+			StreamCallbacks{}, func(rs []RateResult) {
+				mu.Lock()
+				for _, r := range rs {
+					got[r.NegotiatedRate] = true
+				}
+				mu.Unlock()
+			}, nil)
+		if err != nil {
+			t.Fatalf("%s: StreamParse failed: %v", tt.name, err)
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: expected rates %v, got %v", tt.name, tt.want, got)
+			continue
+		}
+		for _, w := range tt.want {
+			if !got[w] {
+				t.Errorf("%s: missing rate %v (got %v)", tt.name, w, got)
+			}
+		}
+	}
+}
+
+// TestStreamParse_MatchByTIN verifies that a target TIN matches provider
+// groups by tin.value regardless of dash formatting, through both
+// provider_references and inline groups, and that such results are flagged.
+func TestStreamParse_MatchByTIN(t *testing.T) {
+	mrfJSON := `{
+	"provider_references": [
+		{"provider_group_id": 1, "provider_groups": [{"npi": [1111111111, 2222222222], "tin": {"type": "ein", "value": "12-3456789"}}]},
+		{"provider_group_id": 2, "provider_groups": [{"npi": [3333333333], "tin": {"type": "ein", "value": "98-7654321"}}]}
+	],
+	"in_network": [
+		{"billing_code_type": "CPT", "billing_code": "99213", "negotiated_rates": [{"provider_references": [1], "negotiated_prices": [{"negotiated_rate": 100}]}]},
+		{"billing_code_type": "CPT", "billing_code": "99214", "negotiated_rates": [{"provider_references": [2], "negotiated_prices": [{"negotiated_rate": 200}]}]},
+		{"billing_code_type": "CPT", "billing_code": "99215", "negotiated_rates": [{"provider_groups": [{"npi": [4444444444], "tin": {"type": "ein", "value": "123456789"}}], "negotiated_prices": [{"negotiated_rate": 300}]}]}
+	]
+}`
+
+	opts := &SearchOptions{NPIs: map[int64]struct{}{2222222222: {}}, TINs: []string{"123456789"}}
+	for _, simd := range []bool{true, false} {
+		prev := useSimd
+		useSimd = simd
+		var mu sync.Mutex
+		var results []RateResult
+		_, err := StreamParse(strings.NewReader(mrfJSON), opts, "src",
+			StreamCallbacks{}, func(rs []RateResult) {
+				mu.Lock()
+				results = append(results, rs...)
+				mu.Unlock()
+			}, nil)
+		useSimd = prev
+		if err != nil {
+			t.Fatalf("simd=%v: StreamParse failed: %v", simd, err)
+		}
+
+		got := map[int64]RateResult{}
+		for _, r := range results {
+			got[r.NPI] = r
+		}
+		if len(got) != 3 {
+			t.Fatalf("simd=%v: expected 3 NPIs, got %+v", simd, results)
+		}
+		if r := got[1111111111]; !r.MatchedByTIN || r.NegotiatedRate != 100 {
+			t.Errorf("simd=%v: NPI 1111111111 should match via TIN at 100, got %+v", simd, r)
+		}
+		if r := got[2222222222]; r.MatchedByTIN {
+			t.Errorf("simd=%v: NPI 2222222222 was requested directly and should not be flagged", simd)
+		}
+		if r := got[4444444444]; !r.MatchedByTIN || r.NegotiatedRate != 300 {
+			t.Errorf("simd=%v: inline group should match via TIN at 300, got %+v", simd, r)
+		}
+	}
+}
+
+// TestStreamParse_DuplicateGroupNPIs verifies that an NPI repeated within a
+// provider group, or in a group listed twice, yields one result per price,
+// and that a group over the provider cap is truncated with a warning.
+func TestStreamParse_DuplicateGroupNPIs(t *testing.T) {
+	mrfJSON := `{
+	"provider_references": [
+		{"provider_group_id": 1, "provider_groups": [{"npi": [1111111111, 1111111111, 1111111111], "tin": {"type": "ein", "value": "11-1111111"}}]},
+		{"provider_group_id": 1, "provider_groups": [{"npi": [1111111111], "tin": {"type": "ein", "value": "11-1111111"}}]},
+		{"provider_group_id": 2, "provider_groups": [{"npi": [2000000001, 2000000002, 2000000003, 2000000004], "tin": {"type": "ein", "value": "22-2222222"}}]}
+	],
+	"in_network": [
+		{"billing_code_type": "CPT", "billing_code": "99213", "negotiated_rates": [{"provider_references": [1, 2], "negotiated_prices": [{"negotiated_rate": 100}]}]},
+		{"billing_code_type": "CPT", "billing_code": "99214", "negotiated_rates": [{"provider_groups": [{"npi": [1111111111, 1111111111], "tin": {"type": "ein", "value": "11-1111111"}}], "negotiated_prices": [{"negotiated_rate": 200}]}]}
+	]
+}`
+
+	opts := &SearchOptions{NPIs: map[int64]struct{}{1111111111: {}}, TINs: []string{"22-2222222"}}
+	SetMaxGroupProviders(2)
+	defer SetMaxGroupProviders(50000)
+
+	for _, simd := range []bool{true, false} {
+		prev := useSimd
+		useSimd = simd
+		var mu sync.Mutex
+		var results []RateResult
+		var warnings []string
+		_, err := StreamParse(strings.NewReader(mrfJSON), opts, "src",
+			StreamCallbacks{OnWarning: func(msg string) { warnings = append(warnings, msg) }},
+			func(rs []RateResult) {
+				mu.Lock()
+				results = append(results, rs...)
+				mu.Unlock()
+			}, nil)
+		useSimd = prev
+		if err != nil {
+			t.Fatalf("simd=%v: StreamParse failed: %v", simd, err)
+		}
+
+		perCode := map[string]int{}
+		for _, r := range results {
+			perCode[r.BillingCode]++
+		}
+		// 99213: NPI 1111111111 once, plus group 2 truncated to 2 NPIs.
+		if perCode["99213"] != 3 || perCode["99214"] != 1 {
+			t.Errorf("simd=%v: expected 3 results for 99213 and 1 for 99214, got %+v", simd, results)
+		}
+		if len(warnings) != 1 || !strings.Contains(warnings[0], "truncated") {
+			t.Errorf("simd=%v: expected one truncation warning, got %q", simd, warnings)
+		}
+	}
+}
+
+// TestStreamParse_CollapseProviders verifies that with results collapsed per
+// provider group, each TIN yields one result per price listing its NPIs.
+func TestStreamParse_CollapseProviders(t *testing.T) {
+	mrfJSON := `{
+	"provider_references": [
+		{"provider_group_id": 1, "provider_groups": [{"npi": [1111111111, 2222222222, 3333333333], "tin": {"type": "ein", "value": "11-1111111"}}]},
+		{"provider_group_id": 2, "provider_groups": [{"npi": [4444444444], "tin": {"type": "ein", "value": "22-2222222"}}]}
+	],
+	"in_network": [
+		{"billing_code_type": "CPT", "billing_code": "99213", "negotiated_rates": [{"provider_references": [1, 2], "negotiated_prices": [{"negotiated_rate": 100}, {"negotiated_rate": 110}]}]}
+	]
+}`
+
+	var results []RateResult
+	opts := &SearchOptions{
+		NPIs:              map[int64]struct{}{1111111111: {}, 3333333333: {}, 4444444444: {}},
+		CollapseProviders: true,
+	}
+	_, err := StreamParse(strings.NewReader(mrfJSON), opts, "src", StreamCallbacks{},
+		func(rs []RateResult) { results = append(results, rs...) }, nil)
+	if err != nil {
+		t.Fatalf("StreamParse failed: %v", err)
+	}
+
+	if len(results) != 4 {
+		t.Fatalf("expected 2 groups x 2 prices = 4 results, got %d: %+v", len(results), results)
+	}
+	for _, r := range results {
+		switch r.TIN.Value {
+		case "11-1111111":
+			if fmt.Sprint(r.NPIs) != "[1111111111 3333333333]" || r.NPI != 1111111111 {
+				t.Errorf("group 1: expected NPIs [1111111111 3333333333], got NPI %d NPIs %v", r.NPI, r.NPIs)
+			}
+		case "22-2222222":
+			if fmt.Sprint(r.NPIs) != "[4444444444]" {
+				t.Errorf("group 2: expected NPIs [4444444444], got %v", r.NPIs)
+			}
+		default:
+			t.Errorf("unexpected TIN %q", r.TIN.Value)
+		}
+	}
+}
+
+// TestStreamParse_TargetGroupIDs verifies that groups targeted by ID match
+// without provider_references being scanned, even when in_network comes
+// first, and that collapsing keeps the groups apart.
+func TestStreamParse_TargetGroupIDs(t *testing.T) {
+	mrfJSON := `{
+	"in_network": [
+		{"billing_code_type": "CPT", "billing_code": "99213", "negotiated_rates": [{"provider_references": [1, 2], "negotiated_prices": [{"negotiated_rate": 100}]}]},
+		{"billing_code_type": "CPT", "billing_code": "99214", "negotiated_rates": [{"provider_references": [3], "negotiated_prices": [{"negotiated_rate": 200}]}]}
+	],
+	"provider_references": [
+		{"provider_group_id": 1, "provider_groups": [{"npi": [1111111111], "tin": {"type": "ein", "value": "11-1111111"}}]}
+	]
+}`
+
+	opts := &SearchOptions{GroupIDs: []int64{1, 2}, CollapseProviders: true}
+
+	for _, simd := range []bool{true, false} {
+		prev := useSimd
+		useSimd = simd
+		var results []RateResult
+		refs := 0
+		sr, err := StreamParse(strings.NewReader(mrfJSON), opts, "src",
+			StreamCallbacks{OnRefScanned: func() { refs++ }},
+			func(rs []RateResult) { results = append(results, rs...) }, nil)
+		useSimd = prev
+		if err != nil {
+			t.Fatalf("simd=%v: StreamParse failed: %v", simd, err)
+		}
+		if sr.NeedSecondPass || refs != 0 {
+			t.Errorf("simd=%v: expected a single pass without scanning provider_references, got NeedSecondPass=%v refs=%d", simd, sr.NeedSecondPass, refs)
+		}
+		if len(results) != 2 {
+			t.Fatalf("simd=%v: expected one 99213 result per group, got %+v", simd, results)
+		}
+		for i, r := range results {
+			if r.BillingCode != "99213" || r.ProviderGroupID != float64(i+1) || r.NPI != 0 || r.TIN.Value != "" {
+				t.Errorf("simd=%v: result %d: expected 99213 for group %d with no NPI or TIN, got %+v", simd, i, i+1, r)
+			}
+		}
+	}
+}
+
+// TestStreamParse_RateBounds verifies that prices outside the rate bounds
+// are dropped and counted, and zero prices are kept and counted.
+func TestStreamParse_RateBounds(t *testing.T) {
+	mrfJSON := `{
+	"provider_references": [],
+	"in_network": [
+		{"billing_code_type": "CPT", "billing_code": "99213", "negotiated_rates": [{
+			"provider_groups": [{"npi": [1111111111], "tin": {"type": "ein", "value": "11-1111111"}}],
+			"negotiated_prices": [{"negotiated_rate": -5}, {"negotiated_rate": 0}, {"negotiated_rate": 120}, {"negotiated_rate": 99999999.99}]
+		}]}
+	]
+}`
+
+	counts := &RateCounts{}
+	opts := &SearchOptions{NPIs: map[int64]struct{}{1111111111: {}}, MaxRate: 1000, Counts: counts}
+
+	var results []RateResult
+	_, err := StreamParse(strings.NewReader(mrfJSON), opts, "src", StreamCallbacks{},
+		func(rs []RateResult) { results = append(results, rs...) }, nil)
+	if err != nil {
+		t.Fatalf("StreamParse failed: %v", err)
+	}
+
+	if len(results) != 2 || results[0].NegotiatedRate != 0 || results[1].NegotiatedRate != 120 {
+		t.Errorf("expected the 0 and 120 prices, got %+v", results)
+	}
+	if counts.Dropped() != 2 || counts.Zero() != 1 {
+		t.Errorf("expected 2 dropped and 1 zero, got %d and %d", counts.Dropped(), counts.Zero())
+	}
+}
+
+func TestStreamParse_Provenance(t *testing.T) {
+	mrfJSON := `{
+	"provider_references": [],
+	"in_network": [
+		{"billing_code_type": "CPT", "billing_code": "1", "negotiated_rates": [{
+			"provider_groups": [{"npi": [2222222222], "tin": {"type": "ein", "value": "1"}}],
+			"negotiated_prices": [{"negotiated_rate": 10}]
+		}]},
+		{"billing_code_type": "CPT", "billing_code": "2", "negotiated_rates": [{
+			"provider_groups": [{"npi": [1111111111], "tin": {"type": "ein", "value": "1"}}],
+			"negotiated_prices": [{"negotiated_rate": 20}, {"negotiated_rate": 30}]
+		}]}
+	]
+}`
+
+	var results []RateResult
+	opts := &SearchOptions{NPIs: map[int64]struct{}{1111111111: {}}, Provenance: true}
+	_, err := StreamParse(strings.NewReader(mrfJSON), opts, "src", StreamCallbacks{},
+		func(rs []RateResult) { results = append(results, rs...) }, nil)
+	if err != nil {
+		t.Fatalf("StreamParse failed: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(results))
+	}
+	for _, r := range results {
+		if r.Provenance == nil || *r.Provenance != (Provenance{Element: 2}) {
+			t.Errorf("expected element 2, got %+v", r.Provenance)
+		}
+	}
+}
+
+// TestStreamParse_CoveredServices verifies that bundle and capitation items
+// carry their services into results, and that SkipBundled drops them.
+func TestStreamParse_CoveredServices(t *testing.T) {
+	mrfJSON := `{
+	"provider_references": [],
+	"in_network": [
+		{"negotiation_arrangement": "ffs", "billing_code_type": "CPT", "billing_code": "99213", "negotiated_rates": [{
+			"provider_groups": [{"npi": [1111111111], "tin": {"type": "ein", "value": "11-1111111"}}],
+			"negotiated_prices": [{"negotiated_rate": 100}]
+		}]},
+		{"negotiation_arrangement": "bundle", "billing_code_type": "CPT", "billing_code": "27447",
+			"bundled_codes": [{"billing_code_type": "CPT", "billing_code": "01402", "description": "Anesthesia"}],
+			"negotiated_rates": [{
+				"provider_groups": [{"npi": [1111111111], "tin": {"type": "ein", "value": "11-1111111"}}],
+				"negotiated_prices": [{"negotiated_rate": 25000}]
+			}]},
+		{"negotiation_arrangement": "capitation", "billing_code_type": "CSTM-ALL", "billing_code": "CAP",
+			"covered_services": [{"billing_code_type": "CPT", "billing_code": "99213"}, {"billing_code_type": "CPT", "billing_code": "99214"}],
+			"negotiated_rates": [{
+				"provider_groups": [{"npi": [1111111111], "tin": {"type": "ein", "value": "11-1111111"}}],
+				"negotiated_prices": [{"negotiated_rate": 40}]
+			}]}
+	]
+}`
+
+	parse := func(skipBundled bool) []RateResult {
+		var results []RateResult
+		opts := &SearchOptions{NPIs: map[int64]struct{}{1111111111: {}}, SkipBundled: skipBundled}
+		_, err := StreamParse(strings.NewReader(mrfJSON), opts, "src", StreamCallbacks{},
+			func(rs []RateResult) { results = append(results, rs...) }, nil)
+		if err != nil {
+			t.Fatalf("StreamParse failed: %v", err)
+		}
+		return results
+	}
+
+	results := parse(false)
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(results))
+	}
+	byCode := map[string]RateResult{}
+	for _, r := range results {
+		byCode[r.BillingCode] = r
+	}
+	if r := byCode["99213"]; r.BundledCodes != nil || r.CoveredServices != nil {
+		t.Errorf("ffs result has services: %+v", r)
+	}
+	if r := byCode["27447"]; len(r.BundledCodes) != 1 || r.BundledCodes[0].BillingCode != "01402" || r.BundledCodes[0].Description != "Anesthesia" {
+		t.Errorf("bundle result has bundled codes %+v", r.BundledCodes)
+	}
+	if r := byCode["CAP"]; len(r.CoveredServices) != 2 || r.CoveredServices[1].BillingCode != "99214" {
+		t.Errorf("capitation result has covered services %+v", r.CoveredServices)
+	}
+
+	results = parse(true)
+	if len(results) != 1 || results[0].BillingCode != "99213" {
+		t.Errorf("expected only the ffs result with bundles skipped, got %+v", results)
+	}
+}
+
+// refIDMatcher matches every provider of the provider_references groups
+// with the given IDs; it has no byte pre-filter.
+type refIDMatcher map[float64]bool
+
+func (m refIDMatcher) Patterns() [][]byte { return nil }
+
+func (m refIDMatcher) Match(dst []ProviderInfo, g Group) []ProviderInfo {
+	if g.Inline || !m[g.RefID] {
+		return dst
+	}
+	for _, npi := range g.NPIs {
+		dst = append(dst, ProviderInfo{NPI: npi, TIN: g.TIN, MatchedByTIN: true})
+	}
+	return dst
+}
+
+// TestStreamParse_CustomMatcher verifies that a matcher in
+// SearchOptions.Matchers is consulted alongside the target NPIs, including for
+// elements the NPI pre-filter alone would skip.
+func TestStreamParse_CustomMatcher(t *testing.T) {
+	mrfJSON := `{
+	"provider_references": [
+		{"provider_group_id": 1, "provider_groups": [{"npi": [1111111111], "tin": {"type": "ein", "value": "11-1111111"}}]},
+		{"provider_group_id": 2, "provider_groups": [{"npi": [2222222222, 3333333333], "tin": {"type": "ein", "value": "22-2222222"}}]},
+		{"provider_group_id": 3, "provider_groups": [{"npi": [4444444444], "tin": {"type": "ein", "value": "33-3333333"}}]}
+	],
+	"in_network": [
+		{"billing_code_type": "CPT", "billing_code": "99213", "negotiated_rates": [{"provider_references": [1, 2, 3], "negotiated_prices": [{"negotiated_rate": 100}]}]}
+	]
+}`
+
+	var results []RateResult
+	opts := &SearchOptions{NPIs: map[int64]struct{}{1111111111: {}}, Matchers: []Matcher{refIDMatcher{2: true}}}
+	_, err := StreamParse(strings.NewReader(mrfJSON), opts, "src", StreamCallbacks{},
+		func(rs []RateResult) { results = append(results, rs...) }, nil)
+	if err != nil {
+		t.Fatalf("StreamParse failed: %v", err)
+	}
+
+	var npis []int64
+	for _, r := range results {
+		npis = append(npis, r.NPI)
+	}
+	if fmt.Sprint(npis) != "[1111111111 2222222222 3333333333]" {
+		t.Errorf("expected NPIs of groups 1 and 2, got %v", npis)
+	}
+}
+
+// TestStreamParse_PanicBecomesError verifies that a panic in a fan-out
+// worker is returned as a *PanicError with its stack instead of crashing,
+// and that the decode loop still finishes with many elements queued.
+func TestStreamParse_PanicBecomesError(t *testing.T) {
+	var items []string
+	for i := 0; i < 200; i++ {
+		items = append(items, fmt.Sprintf(`{"billing_code_type": "CPT", "billing_code": "%d", "negotiated_rates": [{"provider_references": [1], "negotiated_prices": [{"negotiated_rate": 100}]}]}`, i))
+	}
+	mrfJSON := `{
+	"provider_references": [
+		{"provider_group_id": 1, "provider_groups": [{"npi": [1234567890], "tin": {"type": "ein", "value": "12-3456789"}}]}
+	],
+	"in_network": [` + strings.Join(items, ",") + `]
+}`
+
+	_, err := StreamParse(strings.NewReader(mrfJSON), &SearchOptions{NPIs: map[int64]struct{}{1234567890: {}}}, "src",
+		StreamCallbacks{}, func([]RateResult) { panic("emit exploded") }, nil)
+	var pe *PanicError
+	if !errors.As(err, &pe) {
+		t.Fatalf("expected a *PanicError, got %v", err)
+	}
+	if pe.Value != "emit exploded" || !strings.Contains(string(pe.Stack), "processInNetworkElement") {
+		t.Errorf("unexpected panic error %v with stack:\n%s", pe.Value, pe.Stack)
+	}
+}
+
+// buildBenchMRF generates a synthetic MRF with numRefs provider_references and
+// numItems in_network items, where every matchEvery-th item references the
+// target NPI's group.
+func buildBenchMRF(numRefs, numItems, matchEvery int) string {
+	var sb strings.Builder
+	sb.WriteString(`{"reporting_entity_name":"Bench Plan","provider_references":[`)
+	for i := 0; i < numRefs; i++ {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		npi := 2000000000 + i
+		if i == 0 {
+			npi = 1234567890
+		}
+		fmt.Fprintf(&sb, `{"provider_group_id":%d,"provider_groups":[{"npi":[%d,%d],"tin":{"type":"ein","value":"12-%07d"}}]}`,
+			i, npi, npi+1, i)
+	}
+	sb.WriteString(`],"in_network":[`)
+	for i := 0; i < numItems; i++ {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		ref := 1 + i%(numRefs-1)
+		if i%matchEvery == 0 {
+			ref = 0
+		}
+		fmt.Fprintf(&sb, `{"billing_code_type":"CPT","billing_code":"%05d","name":"Code %d","negotiation_arrangement":"ffs",`+
+			`"negotiated_rates":[{"provider_references":[%d],"negotiated_prices":[`+
+			`{"negotiated_rate":%d.50,"negotiated_type":"negotiated","billing_class":"professional","setting":"outpatient","expiration_date":"2025-12-31","service_code":["11","22"]},`+
+			`{"negotiated_rate":%d.75,"negotiated_type":"negotiated","billing_class":"institutional","setting":"inpatient","expiration_date":"2025-12-31"}]}]}`,
+			10000+i, i, ref, 100+i%500, 150+i%500)
+	}
+	sb.WriteString(`]}`)
+	return sb.String()
+}
+
+func BenchmarkStreamParse(b *testing.B) {
+	mrfJSON := buildBenchMRF(2000, 20000, 10)
+	targetNPIs := map[int64]struct{}{1234567890: {}}
+
+	for _, bc := range []struct {
+		name       string
+		simd, scan bool
+	}{{"stdlib", false, false}, {"scan", false, true}, {"simd", true, false}} {
+		simd, scan := bc.simd, bc.scan
+		b.Run(bc.name, func(b *testing.B) {
+			if simd && !simdjson.SupportedCPU() {
+				b.Skip("simdjson not supported on this CPU")
+			}
+			prevSimd, prevScan := useSimd, useScan
+			useSimd, useScan = simd, scan
+			defer func() { useSimd, useScan = prevSimd, prevScan }()
+
+			b.SetBytes(int64(len(mrfJSON)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				var mu sync.Mutex
+				var n int
+				_, err := StreamParse(strings.NewReader(mrfJSON), &SearchOptions{NPIs: targetNPIs}, "bench.json.gz", StreamCallbacks{},
+					func(rs []RateResult) {
+						mu.Lock()
+						n += len(rs)
+						mu.Unlock()
+					}, nil)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if n != 4000 {
+					b.Fatalf("expected 4000 results, got %d", n)
+				}
+			}
+		})
+	}
+}
+
+func TestStreamParse_ItemHook(t *testing.T) {
+	mrfJSON := `{
+	"provider_references": [],
+	"in_network": [
+		{"billing_code_type": "CPT", "billing_code": "99213", "negotiated_rates": [{
+			"provider_groups": [{"npi": [1111111111], "tin": {"type": "ein", "value": "11-1111111"}}],
+			"negotiated_prices": [{"negotiated_rate": 100}]
+		}]},
+		{"billing_code_type": "CPT", "billing_code": "99214", "negotiated_rates": [{
+			"provider_groups": [{"npi": [2222222222], "tin": {"type": "ein", "value": "22-2222222"}}],
+			"negotiated_prices": [{"negotiated_rate": 150}]
+		}]}
+	]
+}`
+
+	var mu sync.Mutex
+	var codes []string
+	opts := &SearchOptions{
+		NPIs: map[int64]struct{}{1111111111: {}},
+		ItemHook: func(item *InNetworkItem, sourceFile string) {
+			mu.Lock()
+			defer mu.Unlock()
+			codes = append(codes, sourceFile+":"+item.BillingCode)
+		},
+	}
+	_, err := StreamParse(strings.NewReader(mrfJSON), opts, "src", StreamCallbacks{},
+		func([]RateResult) {}, nil)
+	if err != nil {
+		t.Fatalf("StreamParse failed: %v", err)
+	}
+	if len(codes) != 1 || codes[0] != "src:99213" {
+		t.Errorf("hook saw %v, want only the matching item", codes)
+	}
+}
+
+func TestStreamParse_MaxStreamMemory(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString(`{"provider_references": [], "in_network": [`)
+	for i := 0; i < 200; i++ {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		fmt.Fprintf(&sb, `{"billing_code_type": "CPT", "billing_code": "%d", "negotiated_rates": [{
+			"provider_groups": [{"npi": [1111111111], "tin": {"type": "ein", "value": "11-1111111"}}],
+			"negotiated_prices": [{"negotiated_rate": 100}]}]}`, i)
+	}
+	sb.WriteString(`]}`)
+
+	// A cap smaller than one element, and a queue of one element per
+	// worker, still parse every element.
+	SetMaxStreamMemory(64)
+	defer SetMaxStreamMemory(0)
+	SetParseParallelism(4)
+	defer SetParseParallelism(0)
+	SetQueueDepth(1)
+	defer SetQueueDepth(0)
+
+	var mu sync.Mutex
+	count := 0
+	_, err := StreamParse(strings.NewReader(sb.String()), &SearchOptions{NPIs: map[int64]struct{}{1111111111: {}}}, "src", StreamCallbacks{},
+		func(rs []RateResult) {
+			mu.Lock()
+			count += len(rs)
+			mu.Unlock()
+		}, nil)
+	if err != nil {
+		t.Fatalf("StreamParse failed: %v", err)
+	}
+	if count != 200 {
+		t.Errorf("expected 200 results, got %d", count)
+	}
+	if streamBudget.used != 0 {
+		t.Errorf("%d bytes still held after the parse", streamBudget.used)
+	}
+}
@@ -0,0 +1,66 @@
+package mrf
+
+import (
+	"strings"
+
+	simdjson "github.com/minio/simdjson-go"
+)
+
+// tinSet is the set of TINs searched for alongside the target NPIs. Values
+// are normalized to digits only, so "12-3456789" and "123456789" match each
+// other. A nil *tinSet matches nothing.
+type tinSet struct {
+	values   map[string]struct{}
+	patterns [][]byte // raw-bytes pre-filter forms (digits and EIN-dashed)
+}
+
+// newTINSet returns the set of tins, nil if there are none.
+func newTINSet(tins []string) *tinSet {
+	if len(tins) == 0 {
+		return nil
+	}
+	s := &tinSet{values: make(map[string]struct{}, len(tins))}
+	for _, t := range tins {
+		d := normalizeTIN(t)
+		if d == "" {
+			continue
+		}
+		s.values[d] = struct{}{}
+		s.patterns = append(s.patterns, []byte(`"`+d+`"`))
+		if len(d) == 9 {
+			s.patterns = append(s.patterns, []byte(`"`+d[:2]+"-"+d[2:]+`"`))
+		}
+	}
+	return s
+}
+
+// normalizeTIN strips everything but digits from a TIN value.
+func normalizeTIN(v string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, v)
+}
+
+// has reports whether value is a target TIN.
+func (s *tinSet) has(value string) bool {
+	if s == nil || value == "" {
+		return false
+	}
+	_, ok := s.values[normalizeTIN(value)]
+	return ok
+}
+
+// groupTINSimd extracts a provider group's tin object.
+func groupTINSimd(pgIter simdjson.Iter) TIN {
+	var tin TIN
+	if e, err := pgIter.FindElement(nil, "tin", "type"); err == nil {
+		tin.Type, _ = e.Iter.String()
+	}
+	if e, err := pgIter.FindElement(nil, "tin", "value"); err == nil {
+		tin.Value, _ = e.Iter.String()
+	}
+	return tin
+}
@@ -36,6 +36,14 @@ type NegotiatedRate struct {
 	NegotiatedPrices   []NegotiatedPrice `json:"negotiated_prices"`
 }
 
+// CoveredService is a service paid for by a bundle or capitation rate, from
+// an in_network item's bundled_codes or covered_services.
+type CoveredService struct {
+	BillingCodeType string `json:"billing_code_type"`
+	BillingCode     string `json:"billing_code"`
+	Description     string `json:"description"`
+}
+
 // InNetworkItem represents a single in_network array entry.
 type InNetworkItem struct {
 	BillingCodeType        string           `json:"billing_code_type"`
@@ -44,12 +52,22 @@ type InNetworkItem struct {
 	Description            string           `json:"description"`
 	NegotiationArrangement string           `json:"negotiation_arrangement"`
 	NegotiatedRates        []NegotiatedRate `json:"negotiated_rates"`
+
+	// Set for "bundle" and "capitation" arrangements.
+	BundledCodes    []CoveredService `json:"bundled_codes"`
+	CoveredServices []CoveredService `json:"covered_services"`
 }
 
 // ProviderInfo holds matched provider details from Phase A.
 type ProviderInfo struct {
 	NPI int64
 	TIN TIN
+	// MatchedByTIN is set when the provider was matched through its group's
+	// TIN rather than its NPI (see SearchOptions.TINs).
+	MatchedByTIN bool
+	// GroupID is set for a group targeted by ID (see SearchOptions.GroupIDs),
+	// whose members are unknown.
+	GroupID float64
 }
 
 // RateResult is a single output record for a matched rate.
@@ -63,11 +81,44 @@ type RateResult struct {
 	NegotiationArrangement string   `json:"negotiation_arrangement"`
 	NegotiatedRate         float64  `json:"negotiated_rate"`
 	NegotiatedType         string   `json:"negotiated_type"`
+	Unit                   string   `json:"unit"` // of NegotiatedRate, see UnitFor
 	BillingClass           string   `json:"billing_class"`
 	Setting                string   `json:"setting"`
 	ExpirationDate         string   `json:"expiration_date"`
 	ServiceCode            []string `json:"service_code"`
 	BillingCodeModifier    []string `json:"billing_code_modifier"`
+	MatchedByTIN           bool     `json:"matched_by_tin,omitempty"`
+
+	// NPIs lists the provider group's matched NPIs when results are
+	// collapsed per group (NPI is then the first of them).
+	NPIs []int64 `json:"npis,omitempty"`
+
+	// ProviderGroupID is the provider_group_id a rate matched through when
+	// groups are searched by ID (see SearchOptions.GroupIDs).
+	ProviderGroupID float64 `json:"provider_group_id,omitempty"`
+
+	// The services a bundle or capitation rate covers, as listed by the item.
+	BundledCodes    []CoveredService `json:"bundled_codes,omitempty"`
+	CoveredServices []CoveredService `json:"covered_services,omitempty"`
+
+	// Set from a Medicare fee schedule (see SearchOptions.Enrichers) for
+	// dollar rates of codes it lists: the Medicare rate in the same
+	// setting, and NegotiatedRate as a percentage of it.
+	MedicareRate      float64 `json:"medicare_rate,omitempty"`
+	PercentOfMedicare float64 `json:"percent_of_medicare,omitempty"`
+
+	// Provenance locates the in_network item the rate came from, when
+	// recording it is on (see SearchOptions.Provenance).
+	Provenance *Provenance `json:"provenance,omitempty"`
+}
+
+// Provenance locates an in_network item: by split output file and line
+// when the file went through the split pipeline, or by its position in the
+// in_network array when it was streamed.
+type Provenance struct {
+	SplitFile string `json:"split_file,omitempty"` // base name, e.g. "in_network_03.jsonl"
+	Line      int    `json:"line,omitempty"`       // 1-based, in SplitFile
+	Element   int64  `json:"element,omitempty"`    // 1-based, in the in_network array
 }
 
 // SearchOutput is the top-level output JSON structure.
@@ -78,8 +129,106 @@ type SearchOutput struct {
 
 // SearchParams holds metadata about the search.
 type SearchParams struct {
-	NPIs            []int64 `json:"npis"`
-	SearchedFiles   int     `json:"searched_files"`
-	MatchedFiles    int     `json:"matched_files"`
-	DurationSeconds float64 `json:"duration_seconds"`
+	// RunID identifies the run that produced the output; it also tags the
+	// run's log lines and cloud resources.
+	RunID string `json:"run_id,omitempty"`
+
+	// NPISet names the NPI set the output is for when a run searches
+	// several at once (see --npi-set); NPIs are then that set's.
+	NPISet string `json:"npi_set,omitempty"`
+
+	NPIs            []int64  `json:"npis"`
+	TINs            []string `json:"tins,omitempty"`
+	GroupIDs        []int64  `json:"provider_group_ids,omitempty"`
+	SearchedFiles   int      `json:"searched_files"`
+	MatchedFiles    int      `json:"matched_files"`
+	DurationSeconds float64  `json:"duration_seconds"`
+
+	// Status is StatusComplete, StatusTruncated when the run stopped at
+	// its deadline with SkippedFiles files not searched, or, for a cloud
+	// run, StatusIncomplete when MissingShards shards returned no results
+	// and UnsearchedURLs were not searched.
+	Status       string `json:"status,omitempty"`
+	SkippedFiles int    `json:"skipped_files,omitempty"`
+
+	MissingShards  int      `json:"missing_shards,omitempty"`
+	UnsearchedURLs []string `json:"unsearched_urls,omitempty"`
+
+	Transfer *TransferSummary `json:"transfer,omitempty"`
+
+	// DroppedRates counts results dropped because their price was outside
+	// MinRate..MaxRate.
+	MinRate      float64 `json:"min_rate,omitempty"`
+	MaxRate      float64 `json:"max_rate,omitempty"`
+	DroppedRates int64   `json:"dropped_rates,omitempty"`
+
+	// DuplicateRates counts results dropped as duplicates of one already
+	// in the output.
+	DuplicateRates int64 `json:"duplicate_rates,omitempty"`
+
+	// In aggregate output, SuppressedAggregates counts the rows left out
+	// for summarizing fewer than AggregateMinCount rates, and
+	// AggregateRound is the multiple their statistics were rounded to.
+	AggregateMinCount    int64   `json:"aggregate_min_count,omitempty"`
+	AggregateRound       float64 `json:"aggregate_round,omitempty"`
+	SuppressedAggregates int64   `json:"suppressed_aggregates,omitempty"`
+
+	Summary *RunSummary `json:"summary,omitempty"`
+}
+
+// RunSummary breaks a search down by host, for seeing what happened in a
+// large run at a glance.
+type RunSummary struct {
+	Hosts   []HostSummary `json:"hosts"`
+	Slowest []FileTiming  `json:"slowest,omitempty"` // top few
+	Errors  []ErrorCount  `json:"errors,omitempty"`  // most frequent few
+}
+
+// HostSummary counts the files from one host and what came of them. Bytes
+// are the compressed bytes downloaded, including those of files that
+// failed; files skipped at the deadline count only in Files and Skipped.
+type HostSummary struct {
+	Host      string `json:"host"`
+	Files     int    `json:"files"`
+	Succeeded int    `json:"succeeded"`
+	Failed    int    `json:"failed"`
+	Skipped   int    `json:"skipped,omitempty"`
+	Bytes     int64  `json:"bytes"`
+	Rates     int64  `json:"rates"`
 }
+
+// FileTiming is how long one file took to search.
+type FileTiming struct {
+	URL     string  `json:"url"`
+	Seconds float64 `json:"seconds"`
+}
+
+// ErrorCount counts the files that failed with one category of error.
+type ErrorCount struct {
+	Category string `json:"category"` // e.g. "HTTP 403", "timeout"
+	Count    int    `json:"count"`
+	Example  string `json:"example"` // first such error
+	Hint     string `json:"hint,omitempty"` // what to do about it, if known
+}
+
+// TransferSummary accounts for the network transfer of a search, for
+// attributing data-transfer costs to it. Bytes include failed and retried
+// attempts.
+type TransferSummary struct {
+	TotalBytes int64            `json:"total_bytes"`
+	Hosts      map[string]int64 `json:"hosts"`
+
+	// CostPerGB is the price per GB (10^9 bytes) the estimate was made at,
+	// e.g. a cloud NAT gateway's data processing charge.
+	CostPerGB     float64 `json:"cost_per_gb,omitempty"`
+	EstimatedCost float64 `json:"estimated_cost,omitempty"`
+}
+
+// SearchParams.Status values.
+const (
+	StatusComplete   = "complete"
+	StatusTruncated  = "truncated"
+	StatusIncomplete = "incomplete"
+	// StatusPartial marks a snapshot written while the run was in progress.
+	StatusPartial = "partial"
+)
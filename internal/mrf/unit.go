@@ -0,0 +1,29 @@
+package mrf
+
+import "strings"
+
+// Units of RateResult.NegotiatedRate. Rates in different units can't be
+// compared or aggregated together: a "percentage" rate of 80 is 80% of
+// billed charges, and a per diem rate is dollars per day of stay.
+const (
+	UnitUSD     = "USD"
+	UnitPercent = "percent"
+	UnitPerDiem = "per_diem"
+	UnitUnknown = "unknown"
+)
+
+// UnitFor returns the unit of a rate with the given negotiated_type. The
+// CMS schema defines negotiated, derived and fee schedule (dollar amounts),
+// percentage and per diem; payers vary the spelling.
+func UnitFor(negotiatedType string) string {
+	t := strings.NewReplacer("_", " ", "-", " ").Replace(strings.ToLower(strings.TrimSpace(negotiatedType)))
+	switch t {
+	case "negotiated", "derived", "fee schedule", "feeschedule":
+		return UnitUSD
+	case "percentage", "percent":
+		return UnitPercent
+	case "per diem", "perdiem":
+		return UnitPerDiem
+	}
+	return UnitUnknown
+}
@@ -0,0 +1,22 @@
+package mrf
+
+import (
+	"testing"
+)
+
+func TestUnitFor(t *testing.T) {
+	for typ, want := range map[string]string{
+		"negotiated":   UnitUSD,
+		"Fee Schedule": UnitUSD,
+		"fee_schedule": UnitUSD,
+		"percentage":   UnitPercent,
+		"per diem":     UnitPerDiem,
+		"per-diem":     UnitPerDiem,
+		"":             UnitUnknown,
+		"capitation":   UnitUnknown,
+	} {
+		if got := UnitFor(typ); got != want {
+			t.Errorf("UnitFor(%q) = %q, want %q", typ, got, want)
+		}
+	}
+}
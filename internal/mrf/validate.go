@@ -0,0 +1,477 @@
+package mrf
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// Issue kinds reported by Validate.
+const (
+	IssueMissingKey         = "missing_key"
+	IssueWrongType          = "wrong_type"
+	IssueDuplicateGroupID   = "duplicate_group_id"
+	IssueUnreferencedGroup  = "unreferenced_provider_reference"
+	IssueUndefinedReference = "undefined_provider_reference"
+	IssueTruncated          = "truncated"
+	IssueMalformed          = "malformed_json"
+)
+
+// ValidationIssue is one kind of spec violation at one field. Repeats are
+// counted rather than listed; Example locates the first occurrence.
+type ValidationIssue struct {
+	Kind    string `json:"kind"`
+	Field   string `json:"field"` // e.g. in_network[].negotiated_rates[].provider_references
+	Message string `json:"message"`
+	Example string `json:"example"` // e.g. in_network[12].negotiated_rates[0].provider_references
+	Count   int64  `json:"count"`
+}
+
+// ValidationReport is the result of Validate.
+type ValidationReport struct {
+	Valid              bool              `json:"valid"`
+	Version            string            `json:"version,omitempty"`
+	ProviderReferences int64             `json:"provider_references"`
+	InNetworkItems     int64             `json:"in_network_items"`
+	Truncated          bool              `json:"truncated,omitempty"`
+	Issues             []ValidationIssue `json:"issues"`
+}
+
+// jsonKind is the JSON type a field must have.
+type jsonKind int
+
+const (
+	kindString jsonKind = iota
+	kindNumber
+	kindInteger // a number with no fractional part
+	kindArray
+	kindObject
+)
+
+func (k jsonKind) String() string {
+	return [...]string{"string", "number", "integer", "array", "object"}[k]
+}
+
+// fieldSpec is a field of an MRF object and whether the schema requires it.
+type fieldSpec struct {
+	name     string
+	kind     jsonKind
+	required bool
+}
+
+// validateSpecs are the fields Validate checks, by object path as in
+// schemaProfile ("" is the top level; array elements are path[]). Fields
+// whose requirement depends on other fields (provider_groups vs.
+// provider_references, service_code by billing_class) are optional here
+// and checked in members.
+var validateSpecs = map[string][]fieldSpec{
+	"": {
+		{"reporting_entity_name", kindString, true},
+		{"reporting_entity_type", kindString, true},
+		{"last_updated_on", kindString, true},
+		{"version", kindString, true},
+		{"provider_references", kindArray, false},
+		{"in_network", kindArray, true},
+	},
+	"provider_references[]": {
+		{"provider_group_id", kindInteger, true},
+		{"provider_groups", kindArray, false},
+		{"location", kindString, false},
+	},
+	"provider_references[].provider_groups[]": {
+		{"npi", kindArray, true},
+		{"tin", kindObject, true},
+	},
+	"provider_references[].provider_groups[].tin": {
+		{"type", kindString, true},
+		{"value", kindString, true},
+	},
+	"in_network[]": {
+		{"negotiation_arrangement", kindString, true},
+		{"name", kindString, true},
+		{"billing_code_type", kindString, true},
+		{"billing_code_type_version", kindString, true},
+		{"billing_code", kindString, true},
+		{"description", kindString, true},
+		{"negotiated_rates", kindArray, true},
+		{"bundled_codes", kindArray, false},
+		{"covered_services", kindArray, false},
+	},
+	"in_network[].negotiated_rates[]": {
+		{"provider_groups", kindArray, false},
+		{"provider_references", kindArray, false},
+		{"negotiated_prices", kindArray, true},
+	},
+	"in_network[].negotiated_rates[].provider_groups[]": {
+		{"npi", kindArray, true},
+		{"tin", kindObject, true},
+	},
+	"in_network[].negotiated_rates[].provider_groups[].tin": {
+		{"type", kindString, true},
+		{"value", kindString, true},
+	},
+	"in_network[].negotiated_rates[].negotiated_prices[]": {
+		{"negotiated_type", kindString, true},
+		{"negotiated_rate", kindNumber, true},
+		{"expiration_date", kindString, true},
+		{"billing_class", kindString, true},
+		{"service_code", kindArray, false},
+		{"billing_code_modifier", kindArray, false},
+	},
+}
+
+// validator accumulates a ValidationReport.
+type validator struct {
+	report  ValidationReport
+	issues  map[[2]string]*ValidationIssue // by kind and field
+	defined map[float64]string             // provider_group_id -> example path
+	refs    map[float64]string             // referenced ID -> example path
+}
+
+// Validate streams an uncompressed MRF in-network file from r and reports
+// violations of the CMS schema: missing required keys, values of the wrong
+// type (such as a fractional provider_group_id), duplicate provider group
+// IDs, provider_references no rate uses, references to groups the file
+// does not define, and truncation. The error is only for failures reading
+// r; a malformed or truncated file is reported as an issue.
+func Validate(r io.Reader) (*ValidationReport, error) {
+	v := &validator{
+		issues:  make(map[[2]string]*ValidationIssue),
+		defined: make(map[float64]string),
+		refs:    make(map[float64]string),
+	}
+	if err := v.stream(r); err != nil {
+		var syntax *json.SyntaxError
+		switch {
+		case errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.EOF):
+			v.report.Truncated = true
+			v.add(IssueTruncated, "", "", "file ends mid-document")
+		case errors.As(err, &syntax):
+			v.add(IssueMalformed, "", "", fmt.Sprintf("%v at byte %d", err, syntax.Offset))
+		default:
+			return nil, err
+		}
+	}
+	v.crossCheck()
+
+	rep := &v.report
+	rep.Issues = make([]ValidationIssue, 0, len(v.issues))
+	for _, is := range v.issues {
+		rep.Issues = append(rep.Issues, *is)
+	}
+	sort.Slice(rep.Issues, func(i, j int) bool {
+		a, b := rep.Issues[i], rep.Issues[j]
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.Field < b.Field
+	})
+	rep.Valid = len(rep.Issues) == 0
+	return rep, nil
+}
+
+// stream walks the file token by token, so that no element, however
+// large, is held in memory whole.
+func (v *validator) stream(r io.Reader) error {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	value := func(key string, val any) {
+		if key == "version" {
+			v.report.Version, _ = val.(string)
+		}
+		v.checkKind("", key, "", val)
+	}
+	element := func(key string, i int64) error {
+		if key == "provider_references" {
+			v.report.ProviderReferences++
+		} else {
+			v.report.InNetworkItems++
+		}
+		_, err := v.object(dec, key+"[]", fmt.Sprintf("%s[%d]", key, i))
+		return err
+	}
+	seen, err := walkTopLevel(dec, value, element)
+	if errors.Is(err, errNotObject) {
+		v.add(IssueWrongType, "", "", err.Error())
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, f := range validateSpecs[""] {
+		if f.required && !seen[f.name] {
+			v.add(IssueMissingKey, f.name, "", "required key "+f.name+" is missing")
+		}
+	}
+	return nil
+}
+
+// object validates the next value in dec against the specs for path. It
+// returns the object's members as members does, or nil if it is not an
+// object.
+func (v *validator) object(dec *json.Decoder, path, at string) (map[string]json.Token, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	if tok != json.Delim('{') {
+		v.add(IssueWrongType, path, at, path+" element should be object")
+		return nil, skipOpened(dec, tok)
+	}
+	return v.members(dec, path, at)
+}
+
+// members validates the members of the object at path whose '{' was just
+// read, recursing into the fields that have specs of their own. It returns
+// each key's first token: the value itself for a scalar, else its opening
+// delimiter.
+func (v *validator) members(dec *json.Decoder, path, at string) (map[string]json.Token, error) {
+	keys := make(map[string]json.Token)
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key, _ := tok.(string)
+		val, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		keys[key] = val
+		if err := v.field(dec, path, key, at, val); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := dec.Token(); err != nil { // '}'
+		return nil, err
+	}
+
+	for _, f := range validateSpecs[path] {
+		if _, ok := keys[f.name]; !ok && f.required {
+			v.add(IssueMissingKey, path+"."+f.name, at, "required key "+f.name+" is missing")
+		}
+	}
+	switch path {
+	case "provider_references[]":
+		v.providerReference(keys, at)
+	case "in_network[].negotiated_rates[]":
+		_, groups := keys["provider_groups"]
+		if !groups && !hasKind(keys["provider_references"], kindArray) {
+			v.add(IssueMissingKey, "in_network[].negotiated_rates[].provider_references", at,
+				"negotiated rate has neither provider_references nor provider_groups")
+		}
+	}
+	return keys, nil
+}
+
+func (v *validator) providerReference(keys map[string]json.Token, at string) {
+	if _, ok := keys["provider_groups"]; !ok {
+		if _, ok := keys["location"]; !ok {
+			v.add(IssueMissingKey, "provider_references[].provider_groups", at,
+				"provider reference has neither provider_groups nor location")
+		}
+	}
+	if id, ok := number(keys["provider_group_id"]); ok {
+		if first, dup := v.defined[id]; dup {
+			v.add(IssueDuplicateGroupID, "provider_references[].provider_group_id", at,
+				fmt.Sprintf("provider_group_id %s is defined more than once (first at %s)", fmtID(id), first))
+		} else {
+			v.defined[id] = at
+		}
+	}
+}
+
+// field validates the value of field name of the object at path, whose
+// first token tok was just read, and consumes the rest of it.
+func (v *validator) field(dec *json.Decoder, path, name, at string, tok json.Token) error {
+	spec := v.checkKind(path, name, at, tok)
+	if spec == nil {
+		return skipOpened(dec, tok)
+	}
+	field, fieldAt := path+"."+name, at+"."+name
+
+	switch field {
+	case "provider_references[].provider_groups[].npi", "in_network[].negotiated_rates[].provider_groups[].npi":
+		return v.integers(dec, field+"[]", fieldAt, "npi", nil)
+	case "in_network[].negotiated_rates[].provider_references":
+		return v.integers(dec, field+"[]", fieldAt, "provider reference", func(id float64, at string) {
+			if _, seen := v.refs[id]; !seen {
+				v.refs[id] = at
+			}
+		})
+	}
+
+	child := field + "[]"
+	if _, ok := validateSpecs[child]; ok {
+		for i := 0; dec.More(); i++ {
+			if _, err := v.object(dec, child, fmt.Sprintf("%s[%d]", fieldAt, i)); err != nil {
+				return err
+			}
+		}
+		_, err := dec.Token() // ']'
+		return err
+	}
+	if _, ok := validateSpecs[field]; ok && spec.kind == kindObject {
+		_, err := v.members(dec, field, fieldAt)
+		return err
+	}
+	return skipOpened(dec, tok)
+}
+
+// integers streams the array at field whose '[' was just read, reporting
+// the elements that are not integers and passing the numbers to each, if it
+// is non-nil.
+func (v *validator) integers(dec *json.Decoder, field, at, what string, each func(id float64, at string)) error {
+	for i := 0; dec.More(); i++ {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		elemAt := fmt.Sprintf("%s[%d]", at, i)
+		if !hasKind(tok, kindInteger) {
+			v.add(IssueWrongType, field, elemAt, what+" should be integer, got "+describe(tok))
+			if err := skipOpened(dec, tok); err != nil {
+				return err
+			}
+		}
+		if id, ok := number(tok); ok && each != nil {
+			each(id, elemAt)
+		}
+	}
+	_, err := dec.Token() // ']'
+	return err
+}
+
+// checkKind reports val, the value or first token of field name of the
+// object at path, if it has the wrong type. It returns the field's spec, or
+// nil if the field has none or val is of the wrong type.
+func (v *validator) checkKind(path, name, at string, val any) *fieldSpec {
+	var spec *fieldSpec
+	for i, f := range validateSpecs[path] {
+		if f.name == name {
+			spec = &validateSpecs[path][i]
+		}
+	}
+	if spec == nil {
+		return nil // unknown fields are the schema check's business
+	}
+	if !hasKind(val, spec.kind) {
+		field, fieldAt := name, name
+		if path != "" {
+			field, fieldAt = path+"."+name, at+"."+name
+		}
+		v.add(IssueWrongType, field, fieldAt, fmt.Sprintf("%s should be %s, got %s", name, spec.kind, describe(val)))
+		return nil
+	}
+	return spec
+}
+
+// skipOpened consumes the rest of the value whose first token tok was just
+// read: nothing for a scalar.
+func skipOpened(dec *json.Decoder, tok json.Token) error {
+	if tok == json.Delim('{') || tok == json.Delim('[') {
+		return skipRest(dec)
+	}
+	return nil
+}
+
+// crossCheck reports provider group IDs defined but never referenced, and
+// referenced but never defined.
+func (v *validator) crossCheck() {
+	for _, id := range sortedIDs(v.defined) {
+		if _, ok := v.refs[id]; !ok {
+			v.add(IssueUnreferencedGroup, "provider_references[].provider_group_id", v.defined[id],
+				fmt.Sprintf("provider_group_id %s is not referenced by any negotiated rate", fmtID(id)))
+		}
+	}
+	for _, id := range sortedIDs(v.refs) {
+		if _, ok := v.defined[id]; !ok {
+			v.add(IssueUndefinedReference, "in_network[].negotiated_rates[].provider_references[]", v.refs[id],
+				fmt.Sprintf("provider reference %s has no provider_references entry", fmtID(id)))
+		}
+	}
+}
+
+// add counts an issue, keeping the first message and location of its kind
+// at field.
+func (v *validator) add(kind, field, at, msg string) {
+	key := [2]string{kind, field}
+	if is, ok := v.issues[key]; ok {
+		is.Count++
+		return
+	}
+	v.issues[key] = &ValidationIssue{Kind: kind, Field: field, Message: msg, Example: at, Count: 1}
+}
+
+func hasKind(val any, k jsonKind) bool {
+	switch k {
+	case kindString:
+		_, ok := val.(string)
+		return ok
+	case kindNumber:
+		_, ok := number(val)
+		return ok
+	case kindInteger:
+		n, ok := number(val)
+		return ok && n == math.Trunc(n)
+	case kindArray:
+		_, ok := val.([]any)
+		return ok || val == json.Delim('[')
+	case kindObject:
+		_, ok := val.(map[string]any)
+		return ok || val == json.Delim('{')
+	}
+	return false
+}
+
+// number returns val as a float64 if it is a JSON number.
+func number(val any) (float64, bool) {
+	n, ok := val.(json.Number)
+	if !ok {
+		return 0, false
+	}
+	f, err := n.Float64()
+	return f, err == nil
+}
+
+// describe names val's JSON type, and its value for numbers.
+func describe(val any) string {
+	switch x := val.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case json.Number:
+		return "number " + x.String()
+	case json.Delim:
+		if x == '{' {
+			return "object"
+		}
+		return "array"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", val)
+}
+
+func sortedIDs(m map[float64]string) []float64 {
+	ids := make([]float64, 0, len(m))
+	for id := range m {
+		ids = append(ids, id)
+	}
+	sort.Float64s(ids)
+	return ids
+}
+
+func fmtID(id float64) string {
+	return strconv.FormatFloat(id, 'f', -1, 64)
+}
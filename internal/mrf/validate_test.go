@@ -0,0 +1,117 @@
+package mrf
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestValidate verifies the spec violations Validate reports on a small
+// file with one of each, and that a clean file passes.
+func TestValidate(t *testing.T) {
+	valid := `{
+	"reporting_entity_name": "Payer", "reporting_entity_type": "health insurance issuer",
+	"last_updated_on": "2026-01-01", "version": "1.3.1",
+	"provider_references": [
+		{"provider_group_id": 1, "provider_groups": [{"npi": [1111111111], "tin": {"type": "ein", "value": "11-1111111"}}]}
+	],
+	"in_network": [
+		{"negotiation_arrangement": "ffs", "name": "Visit", "billing_code_type": "CPT", "billing_code_type_version": "2026",
+			"billing_code": "99213", "description": "Visit", "negotiated_rates": [{"provider_references": [1],
+			"negotiated_prices": [{"negotiated_type": "negotiated", "negotiated_rate": 100, "expiration_date": "9999-12-31", "billing_class": "professional"}]}]}
+	]
+}`
+	rep, err := Validate(strings.NewReader(valid))
+	if err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	if !rep.Valid || rep.ProviderReferences != 1 || rep.InNetworkItems != 1 || rep.Version != "1.3.1" {
+		t.Errorf("expected a valid report with 1 reference and 1 item, got %+v", rep)
+	}
+
+	invalid := `{
+	"reporting_entity_name": "Payer", "reporting_entity_type": "health insurance issuer", "version": "1.3.1",
+	"provider_references": [
+		{"provider_group_id": 1.5, "provider_groups": [{"npi": ["1111111111"], "tin": {"type": "ein", "value": "11-1111111"}}]},
+		{"provider_group_id": 2, "provider_groups": []},
+		{"provider_group_id": 2, "provider_groups": []}
+	],
+	"in_network": [
+		{"negotiation_arrangement": "ffs", "name": "Visit", "billing_code_type": "CPT", "billing_code_type_version": "2026",
+			"billing_code": 99213, "description": "Visit", "negotiated_rates": [{"provider_references": [1.5, 7],
+			"negotiated_prices": [{"negotiated_type": "negotiated", "negotiated_rate": 100, "expiration_date": "9999-12-31", "billing_class": "professional"}]}]},
+		{"negotiation_arrangement": "ffs", "name": "Visit", "billing_code_type": "CPT", "billing_code_type_version": "2026",
+			"billing_code": "99214", "description": "Visit", "negotiated_rates": [{"provider_references": [1.5], "negotiated_prices": [{"negot`
+	rep, err = Validate(strings.NewReader(invalid))
+	if err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	got := map[string]int64{}
+	for _, is := range rep.Issues {
+		got[is.Kind+" "+is.Field] = is.Count
+	}
+	want := map[string]int64{
+		"wrong_type provider_references[].provider_group_id":                                 1,
+		"wrong_type provider_references[].provider_groups[].npi[]":                           1,
+		"wrong_type in_network[].billing_code":                                               1,
+		"wrong_type in_network[].negotiated_rates[].provider_references[]":                   2, // the truncated item is checked up to its end
+		"duplicate_group_id provider_references[].provider_group_id":                         1,
+		"unreferenced_provider_reference provider_references[].provider_group_id":            1,
+		"undefined_provider_reference in_network[].negotiated_rates[].provider_references[]": 1,
+		"truncated ": 1,
+	}
+	for k, n := range want {
+		if got[k] != n {
+			t.Errorf("issue %q: expected count %d, got %d", k, n, got[k])
+		}
+	}
+	if len(got) != len(want) || rep.Valid || !rep.Truncated {
+		t.Errorf("unexpected report: %+v", rep)
+	}
+}
+
+// TestValidate_WrongContainers verifies that values of the wrong type are
+// skipped whole, so that the fields after them are still checked.
+func TestValidate_WrongContainers(t *testing.T) {
+	input := `{
+	"reporting_entity_name": "Payer", "reporting_entity_type": "health insurance issuer",
+	"last_updated_on": "2026-01-01", "version": "1.3.1",
+	"provider_references": [
+		{"provider_group_id": 1, "provider_groups": [{"npi": [{"n": [1]}, 1111111111], "tin": ["ein"]}]},
+		"not an object",
+		{"provider_group_id": 2, "location": "https://example.com/2.json"}
+	],
+	"in_network": [
+		{"negotiation_arrangement": "ffs", "name": "Visit", "billing_code_type": "CPT", "billing_code_type_version": "2026",
+			"billing_code": "99213", "description": {"text": "Visit"}, "negotiated_rates": [
+			{"provider_references": {"id": 1}, "negotiated_prices": []},
+			{"provider_references": [2], "negotiated_prices": [{"negotiated_type": "negotiated", "negotiated_rate": "100",
+				"expiration_date": "9999-12-31", "billing_class": "professional", "service_code": ["11"]}]}]}
+	]
+}`
+	rep, err := Validate(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	got := map[string]string{}
+	for _, is := range rep.Issues {
+		got[is.Kind+" "+is.Field] = is.Example
+	}
+	want := map[string]string{
+		"wrong_type provider_references[]":                                               "provider_references[1]",
+		"wrong_type provider_references[].provider_groups[].npi[]":                       "provider_references[0].provider_groups[0].npi[0]",
+		"wrong_type provider_references[].provider_groups[].tin":                         "provider_references[0].provider_groups[0].tin",
+		"wrong_type in_network[].description":                                            "in_network[0].description",
+		"wrong_type in_network[].negotiated_rates[].provider_references":                 "in_network[0].negotiated_rates[0].provider_references",
+		"missing_key in_network[].negotiated_rates[].provider_references":                "in_network[0].negotiated_rates[0]",
+		"wrong_type in_network[].negotiated_rates[].negotiated_prices[].negotiated_rate": "in_network[0].negotiated_rates[1].negotiated_prices[0].negotiated_rate",
+		"unreferenced_provider_reference provider_references[].provider_group_id":        "provider_references[0]",
+	}
+	for k, at := range want {
+		if got[k] != at {
+			t.Errorf("issue %q: expected at %q, got %q", k, at, got[k])
+		}
+	}
+	if len(got) != len(want) || rep.ProviderReferences != 3 || rep.InNetworkItems != 1 {
+		t.Errorf("unexpected report: %+v", rep)
+	}
+}
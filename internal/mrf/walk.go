@@ -0,0 +1,90 @@
+package mrf
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// errNotObject is walkTopLevel's error for a document that is not a JSON
+// object.
+var errNotObject = errors.New("top level is not an object")
+
+// walkTopLevel streams the top-level object of an MRF from dec, for whole-
+// file passes (validate, stats) that look at every element rather than
+// searching. Each element of the provider_references and in_network arrays
+// is passed to element with dec positioned at it, to be decoded one at a
+// time; element must consume it. Every other top-level value is decoded and
+// passed to value, as is the first token of an array key whose value is not
+// an array (the rest of it is skipped). It returns the keys seen.
+func walkTopLevel(dec *json.Decoder, value func(key string, val any), element func(key string, i int64) error) (map[string]bool, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	if d, ok := tok.(json.Delim); !ok || d != '{' {
+		return nil, errNotObject
+	}
+
+	seen := make(map[string]bool)
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return seen, err
+		}
+		key, _ := tok.(string)
+		seen[key] = true
+
+		if key != "provider_references" && key != "in_network" {
+			var val any
+			if err := dec.Decode(&val); err != nil {
+				return seen, err
+			}
+			value(key, val)
+			continue
+		}
+
+		tok, err = dec.Token()
+		if err != nil {
+			return seen, err
+		}
+		if d, ok := tok.(json.Delim); !ok || d != '[' {
+			value(key, tok)
+			if ok {
+				if err := skipRest(dec); err != nil {
+					return seen, err
+				}
+			}
+			continue
+		}
+		for i := int64(0); dec.More(); i++ {
+			if err := element(key, i); err != nil {
+				return seen, err
+			}
+		}
+		if _, err := dec.Token(); err != nil { // ']'
+			return seen, err
+		}
+	}
+	if _, err := dec.Token(); err != nil { // '}'
+		return seen, err
+	}
+	return seen, nil
+}
+
+// skipRest consumes the rest of an object or array whose opening delimiter
+// was read.
+func skipRest(dec *json.Decoder) error {
+	for depth := 1; depth > 0; {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+	}
+	return nil
+}
@@ -0,0 +1,483 @@
+package npi
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/binary"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// The NPPES monthly dissemination file (npidata_pfile_*.csv, ~9 GB, one row
+// per NPI with ~330 columns) has everything the registry API returns except
+// taxonomy descriptions. Rather than scan it per lookup, it is indexed once
+// into a file next to it:
+//
+//	magic | records | NPI table | name table | organization table | footer
+//
+// Records are tab-separated lines with the fields of a ProviderInfo. The NPI
+// table holds (NPI, record offset) pairs sorted by NPI; the name table holds
+// (hash of last+first name, record offset) pairs for individuals, sorted by
+// hash; the organization table holds (hash of a word's first orgPrefixLen
+// letters, record offset) pairs for each word of an organization's name
+// (see orgWords), sorted by hash. The footer locates the tables.
+
+const (
+	indexMagic     = "NPPESIX2"
+	indexEntrySize = 16
+	indexFooter    = 6 * 8
+	maxRecordSize  = 4096
+	maxNameResults = 20 // same limit as the registry search
+)
+
+// Index serves Lookup and SearchByName from an indexed NPPES dissemination
+// file, for offline runs and for runs with more NPIs than the registry's
+// rate limit comfortably allows.
+type Index struct {
+	f                  *os.File
+	npiOff, npiCount   int64
+	nameOff, nameCount int64
+	orgOff, orgCount   int64
+}
+
+var bulk *Index
+
+// SetIndex makes Lookup, LookupAll, SearchByName and SearchOrganization use
+// x instead of the registry API. A nil x restores the API.
+func SetIndex(x *Index) {
+	bulk = x
+}
+
+// OpenBulkFile returns the index for the dissemination file at path (a .csv,
+// or the .zip it is distributed as), building it first if it is missing,
+// older than the file, or written by an earlier version. onRow, if non-nil, is called periodically with the
+// number of rows indexed so far. A path ending in .idx is opened directly.
+func OpenBulkFile(path string, onRow func(rows int)) (*Index, error) {
+	if strings.HasSuffix(path, ".idx") {
+		return OpenIndex(path)
+	}
+	src, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	idxPath := path + ".idx"
+	if idx, err := os.Stat(idxPath); err == nil && !idx.ModTime().Before(src.ModTime()) {
+		x, err := OpenIndex(idxPath)
+		if !errors.Is(err, errNotIndex) {
+			return x, err
+		}
+	}
+	if err := BuildIndex(path, idxPath, onRow); err != nil {
+		return nil, err
+	}
+	return OpenIndex(idxPath)
+}
+
+// errNotIndex is returned by OpenIndex for a file that isn't an index, or
+// is one in an older format.
+var errNotIndex = errors.New("not an NPPES index")
+
+// OpenIndex opens an index written by BuildIndex.
+func OpenIndex(path string) (*Index, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	magic := make([]byte, len(indexMagic))
+	footer := make([]byte, indexFooter)
+	if _, err := f.ReadAt(magic, 0); err != nil || string(magic) != indexMagic || info.Size() < int64(len(indexMagic)+indexFooter) {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w (rebuild it from the dissemination file)", path, errNotIndex)
+	}
+	if _, err := f.ReadAt(footer, info.Size()-indexFooter); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("reading NPPES index: %w", err)
+	}
+	x := &Index{f: f}
+	for i, v := range []*int64{&x.npiOff, &x.npiCount, &x.nameOff, &x.nameCount, &x.orgOff, &x.orgCount} {
+		*v = int64(binary.LittleEndian.Uint64(footer[i*8:]))
+	}
+	return x, nil
+}
+
+// Close closes the index file.
+func (x *Index) Close() error {
+	return x.f.Close()
+}
+
+// Lookup returns the provider with the given NPI, or nil if it is not in
+// the index.
+func (x *Index) Lookup(number int64) (*ProviderInfo, error) {
+	i, err := x.search(x.npiOff, x.npiCount, uint64(number))
+	if err != nil || i == x.npiCount {
+		return nil, err
+	}
+	key, off, err := x.entry(x.npiOff, i)
+	if err != nil || key != uint64(number) {
+		return nil, err
+	}
+	info, _, err := x.record(off)
+	return info, err
+}
+
+// SearchByName returns up to 20 individual providers whose first and last
+// names match (ignoring case), optionally only those practicing in state.
+func (x *Index) SearchByName(firstName, lastName, state string) ([]*ProviderInfo, error) {
+	h := nameHash(lastName, firstName)
+	i, err := x.search(x.nameOff, x.nameCount, h)
+	if err != nil {
+		return nil, err
+	}
+	var results []*ProviderInfo
+	for ; i < x.nameCount && len(results) < maxNameResults; i++ {
+		key, off, err := x.entry(x.nameOff, i)
+		if err != nil {
+			return nil, err
+		}
+		if key != h {
+			break
+		}
+		info, recState, err := x.record(off)
+		if err != nil {
+			return nil, err
+		}
+		if state != "" && !strings.EqualFold(recState, state) {
+			continue
+		}
+		results = append(results, info)
+	}
+	return results, nil
+}
+
+// search returns the position of the first entry in the table at off whose
+// key is >= key.
+func (x *Index) search(off, count int64, key uint64) (int64, error) {
+	lo, hi := int64(0), count
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		k, _, err := x.entry(off, mid)
+		if err != nil {
+			return 0, err
+		}
+		if k < key {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return lo, nil
+}
+
+func (x *Index) entry(table, i int64) (key uint64, off int64, err error) {
+	var buf [indexEntrySize]byte
+	if _, err := x.f.ReadAt(buf[:], table+i*indexEntrySize); err != nil {
+		return 0, 0, fmt.Errorf("reading NPPES index: %w", err)
+	}
+	return binary.LittleEndian.Uint64(buf[:8]), int64(binary.LittleEndian.Uint64(buf[8:])), nil
+}
+
+// record decodes the record at off, also returning its practice state.
+func (x *Index) record(off int64) (*ProviderInfo, string, error) {
+	line, err := bufio.NewReaderSize(io.NewSectionReader(x.f, off, maxRecordSize), maxRecordSize).ReadString('\n')
+	if err != nil {
+		return nil, "", fmt.Errorf("reading NPPES index record: %w", err)
+	}
+	f := strings.Split(strings.TrimSuffix(line, "\n"), "\t")
+	if len(f) != 10 {
+		return nil, "", fmt.Errorf("corrupt NPPES index record at offset %d", off)
+	}
+	return decodeRecord(f), f[9], nil
+}
+
+// decodeRecord returns the provider in the fields of a record.
+func decodeRecord(f []string) *ProviderInfo {
+	number, _ := strconv.ParseInt(f[0], 10, 64)
+	return &ProviderInfo{
+		NPI:             number,
+		Type:            f[1],
+		Name:            f[2],
+		Credential:      f[3],
+		PrimaryTaxonomy: f[4], // the file has codes only; show the code
+		TaxonomyCode:    f[4],
+		PracticeAddress: f[5],
+		PracticePhone:   f[6],
+		EnumerationDate: f[7],
+		Status:          f[8],
+	}
+}
+
+// nameHash keys the name table. Names are compared case-insensitively, as
+// the registry does.
+func nameHash(last, first string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(strings.ToUpper(strings.TrimSpace(last))))
+	h.Write([]byte{0})
+	h.Write([]byte(strings.ToUpper(strings.TrimSpace(first))))
+	return h.Sum64()
+}
+
+// orgPrefixLen is how much of each organization name word keys the
+// organization table. Query words at least this long find every name with
+// a word they start; shorter ones need a scan of the records.
+const orgPrefixLen = 4
+
+// orgPrefixHash keys the organization table: the hash of word's first
+// orgPrefixLen letters, or of all of a shorter word. word is from orgWords.
+func orgPrefixHash(word string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(word[:min(len(word), orgPrefixLen)]))
+	return h.Sum64()
+}
+
+// bulkColumns are the dissemination file columns the index keeps.
+var bulkColumns = []string{
+	"NPI",
+	"Entity Type Code",
+	"Provider Organization Name (Legal Business Name)",
+	"Provider Last Name (Legal Name)",
+	"Provider First Name",
+	"Provider Middle Name",
+	"Provider Credential Text",
+	"Provider Business Practice Location Address City Name",
+	"Provider Business Practice Location Address State Name",
+	"Provider Business Practice Location Address Postal Code",
+	"Provider Business Practice Location Address Telephone Number",
+	"Provider Enumeration Date",
+	"NPI Deactivation Date",
+	"NPI Reactivation Date",
+}
+
+// Indexes into bulkColumns.
+const (
+	colNPI = iota
+	colEntityType
+	colOrgName
+	colLastName
+	colFirstName
+	colMiddleName
+	colCredential
+	colCity
+	colState
+	colPostalCode
+	colPhone
+	colEnumerationDate
+	colDeactivationDate
+	colReactivationDate
+)
+
+const maxTaxonomies = 15 // Healthcare Provider Taxonomy Code_1 .. _15
+
+// BuildIndex indexes the dissemination file at src (.csv or .zip) into dst.
+// The tables are sorted in memory, which takes ~250 MB for the full file.
+func BuildIndex(src, dst string, onRow func(rows int)) error {
+	r, closeSrc, err := openBulkCSV(src)
+	if err != nil {
+		return err
+	}
+	defer closeSrc()
+
+	tmp := dst + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("creating NPPES index: %w", err)
+	}
+	defer os.Remove(tmp) // no-op after the rename
+	if err := writeIndex(r, out, onRow); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("writing NPPES index: %w", err)
+	}
+	return os.Rename(tmp, dst)
+}
+
+// openBulkCSV opens src, or for a .zip the npidata_pfile CSV inside it.
+func openBulkCSV(src string) (io.Reader, func(), error) {
+	if !strings.EqualFold(filepath.Ext(src), ".zip") {
+		f, err := os.Open(src)
+		if err != nil {
+			return nil, nil, err
+		}
+		return f, func() { f.Close() }, nil
+	}
+	zr, err := zip.OpenReader(src)
+	if err != nil {
+		return nil, nil, fmt.Errorf("opening %s: %w", src, err)
+	}
+	for _, zf := range zr.File {
+		name := filepath.Base(zf.Name)
+		if strings.HasPrefix(name, "npidata_pfile_") && !strings.Contains(name, "fileheader") {
+			f, err := zf.Open()
+			if err != nil {
+				zr.Close()
+				return nil, nil, fmt.Errorf("opening %s in %s: %w", name, src, err)
+			}
+			return f, func() { f.Close(); zr.Close() }, nil
+		}
+	}
+	zr.Close()
+	return nil, nil, fmt.Errorf("%s has no npidata_pfile CSV", src)
+}
+
+type indexEntry struct {
+	key uint64
+	off int64
+}
+
+func writeIndex(r io.Reader, out io.Writer, onRow func(rows int)) error {
+	cr := csv.NewReader(bufio.NewReaderSize(r, 1<<20))
+	cr.ReuseRecord = true
+	cr.LazyQuotes = true
+
+	header, err := cr.Read()
+	if err != nil {
+		return fmt.Errorf("reading NPPES file header: %w", err)
+	}
+	cols := make([]int, len(bulkColumns))
+	for i, name := range bulkColumns {
+		if cols[i] = slices.Index(header, name); cols[i] < 0 {
+			return fmt.Errorf("NPPES file has no %q column; is this an npidata_pfile CSV?", name)
+		}
+	}
+	var taxCode, taxPrimary []int
+	for n := 1; n <= maxTaxonomies; n++ {
+		c := slices.Index(header, fmt.Sprintf("Healthcare Provider Taxonomy Code_%d", n))
+		p := slices.Index(header, fmt.Sprintf("Healthcare Provider Primary Taxonomy Switch_%d", n))
+		if c >= 0 && p >= 0 {
+			taxCode, taxPrimary = append(taxCode, c), append(taxPrimary, p)
+		}
+	}
+
+	w := bufio.NewWriterSize(out, 1<<20)
+	off := int64(len(indexMagic))
+	w.WriteString(indexMagic)
+
+	var npis, names, orgs []indexEntry
+	for rows := 1; ; rows++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("reading NPPES file: %w", err)
+		}
+		field := func(col int) string {
+			return strings.ReplaceAll(cleanField(rec[cols[col]]), "\t", " ")
+		}
+		number, err := strconv.ParseInt(field(colNPI), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		var typ, name string
+		if field(colEntityType) == "1" {
+			typ = "Individual"
+			name = formatIndividualName(apiBasic{
+				FirstName:  field(colFirstName),
+				MiddleName: field(colMiddleName),
+				LastName:   field(colLastName),
+			})
+			names = append(names, indexEntry{nameHash(field(colLastName), field(colFirstName)), off})
+		} else {
+			typ = "Organization"
+			name = field(colOrgName)
+			var keys []uint64
+			for _, w := range orgWords(name) {
+				if k := orgPrefixHash(w); !slices.Contains(keys, k) {
+					keys = append(keys, k)
+					orgs = append(orgs, indexEntry{k, off})
+				}
+			}
+		}
+		var taxonomy string
+		for i := range taxCode {
+			if code := cleanField(rec[taxCode[i]]); code != "" && (taxonomy == "" || rec[taxPrimary[i]] == "Y") {
+				taxonomy = code
+				if rec[taxPrimary[i]] == "Y" {
+					break
+				}
+			}
+		}
+		status := "A"
+		if field(colDeactivationDate) != "" && field(colReactivationDate) == "" {
+			status = "D"
+		}
+		state := field(colState)
+		line := strings.Join([]string{
+			strconv.FormatInt(number, 10),
+			typ,
+			name,
+			field(colCredential),
+			taxonomy,
+			formatAddress(apiAddress{City: field(colCity), State: state, PostalCode: field(colPostalCode)}),
+			formatPhone(field(colPhone)),
+			isoDate(field(colEnumerationDate)),
+			status,
+			state,
+		}, "\t") + "\n"
+
+		npis = append(npis, indexEntry{uint64(number), off})
+		n, err := w.WriteString(line)
+		if err != nil {
+			return fmt.Errorf("writing NPPES index: %w", err)
+		}
+		off += int64(n)
+		if onRow != nil && rows%1_000_000 == 0 {
+			onRow(rows)
+		}
+	}
+
+	var footer [indexFooter]byte
+	for i, table := range [][]indexEntry{npis, names, orgs} {
+		slices.SortFunc(table, func(a, b indexEntry) int {
+			if a.key != b.key {
+				if a.key < b.key {
+					return -1
+				}
+				return 1
+			}
+			return int(a.off - b.off)
+		})
+		binary.LittleEndian.PutUint64(footer[i*16:], uint64(off))
+		binary.LittleEndian.PutUint64(footer[i*16+8:], uint64(len(table)))
+		var buf [indexEntrySize]byte
+		for _, e := range table {
+			binary.LittleEndian.PutUint64(buf[:8], e.key)
+			binary.LittleEndian.PutUint64(buf[8:], uint64(e.off))
+			if _, err := w.Write(buf[:]); err != nil {
+				return fmt.Errorf("writing NPPES index: %w", err)
+			}
+		}
+		off += int64(len(table)) * indexEntrySize
+	}
+	w.Write(footer[:])
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("writing NPPES index: %w", err)
+	}
+	if len(npis) == 0 {
+		return errors.New("NPPES file has no provider rows")
+	}
+	return nil
+}
+
+// isoDate converts the file's MM/DD/YYYY dates to the API's YYYY-MM-DD.
+func isoDate(s string) string {
+	if len(s) == 10 && s[2] == '/' && s[5] == '/' {
+		return s[6:] + "-" + s[:2] + "-" + s[3:5]
+	}
+	return s
+}
@@ -0,0 +1,234 @@
+package npi
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// bulkRow is one provider of a test dissemination file.
+type bulkRow struct {
+	npi, entityType, org, last, first, middle, credential string
+	city, state, zip, phone, enumerated, deactivated      string
+	taxonomies                                            [][2]string // code, primary switch
+}
+
+// testBulkRows is a small dissemination file: two individuals sharing a
+// name in different states, a deactivated one, and two organizations.
+var testBulkRows = []bulkRow{
+	{npi: "1770671182", entityType: "1", last: "Smith", first: "Jane", middle: "A", credential: "MD",
+		city: "BOSTON", state: "MA", zip: "021151234", phone: "617-555-0100", enumerated: "05/14/2008",
+		taxonomies: [][2]string{{"207Q00000X", "N"}, {"207R00000X", "Y"}}},
+	{npi: "1234567893", entityType: "1", last: "SMITH", first: "JANE", credential: "DO",
+		city: "AUSTIN", state: "TX", zip: "73301", enumerated: "01/02/2010"},
+	{npi: "1111111112", entityType: "1", last: "Doe", first: "John", state: "NY",
+		enumerated: "03/03/2003", deactivated: "06/30/2020"},
+	{npi: "1497758544", entityType: "2", org: "Mount Sinai Hospital", city: "NEW YORK", state: "NY",
+		taxonomies: [][2]string{{"282N00000X", "Y"}}},
+	{npi: "1588667638", entityType: "2", org: "The Mount Sinai Hospital of Queens, Inc.", city: "ASTORIA", state: "NY"},
+}
+
+// writeBulkCSV writes rows as an npidata_pfile CSV in dir and returns its
+// path.
+func writeBulkCSV(t *testing.T, dir string, rows []bulkRow) string {
+	t.Helper()
+	path := filepath.Join(dir, "npidata_pfile_20260101-20260131.csv")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	header := append([]string{}, bulkColumns...)
+	header = append(header, "Unused Column",
+		"Healthcare Provider Taxonomy Code_1", "Healthcare Provider Primary Taxonomy Switch_1",
+		"Healthcare Provider Taxonomy Code_2", "Healthcare Provider Primary Taxonomy Switch_2")
+	w.Write(header)
+	for _, r := range rows {
+		rec := []string{r.npi, r.entityType, r.org, r.last, r.first, r.middle, r.credential,
+			r.city, r.state, r.zip, r.phone, r.enumerated, r.deactivated, "", "x"}
+		for i := 0; i < 2; i++ {
+			if i < len(r.taxonomies) {
+				rec = append(rec, r.taxonomies[i][0], r.taxonomies[i][1])
+			} else {
+				rec = append(rec, "", "")
+			}
+		}
+		w.Write(rec)
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// openTestIndex builds and opens the index of testBulkRows.
+func openTestIndex(t *testing.T) *Index {
+	t.Helper()
+	x, err := OpenBulkFile(writeBulkCSV(t, t.TempDir(), testBulkRows), nil)
+	if err != nil {
+		t.Fatalf("OpenBulkFile: %v", err)
+	}
+	t.Cleanup(func() { x.Close() })
+	return x
+}
+
+func TestIndexLookup(t *testing.T) {
+	x := openTestIndex(t)
+
+	info, err := x.Lookup(1770671182)
+	if err != nil || info == nil {
+		t.Fatalf("Lookup: info=%v err=%v", info, err)
+	}
+	want := ProviderInfo{
+		NPI:             1770671182,
+		Type:            "Individual",
+		Name:            "Smith, Jane A",
+		Credential:      "MD",
+		PrimaryTaxonomy: "207R00000X",
+		TaxonomyCode:    "207R00000X",
+		PracticeAddress: "BOSTON, MA 02115",
+		PracticePhone:   "(617) 555-0100",
+		EnumerationDate: "2008-05-14",
+		Status:          "A",
+	}
+	if *info != want {
+		t.Errorf("Lookup = %+v\nwant     %+v", *info, want)
+	}
+
+	if info, _ := x.Lookup(1111111112); info == nil || info.Status != "D" {
+		t.Errorf("expected the deactivated NPI with status D, got %+v", info)
+	}
+	if info, _ := x.Lookup(1497758544); info == nil || info.Type != "Organization" || info.Name != "Mount Sinai Hospital" {
+		t.Errorf("unexpected organization record %+v", info)
+	}
+	for _, missing := range []int64{1000000000, 1500000000, 9999999999} {
+		if info, err := x.Lookup(missing); info != nil || err != nil {
+			t.Errorf("Lookup(%d) = %+v, %v; want nil, nil", missing, info, err)
+		}
+	}
+}
+
+func TestIndexSearchByName(t *testing.T) {
+	x := openTestIndex(t)
+
+	tests := []struct {
+		first, last, state string
+		want               []int64
+	}{
+		{"jane", "smith", "", []int64{1770671182, 1234567893}},
+		{" Jane ", "SMITH", "tx", []int64{1234567893}},
+		{"Jane", "Smith", "CA", nil},
+		{"John", "Smith", "", nil},
+		{"Mount", "Sinai", "", nil}, // organizations aren't in the name table
+	}
+	for _, tt := range tests {
+		got, err := x.SearchByName(tt.first, tt.last, tt.state)
+		if err != nil {
+			t.Fatalf("SearchByName(%q, %q, %q): %v", tt.first, tt.last, tt.state, err)
+		}
+		var npis []int64
+		for _, p := range got {
+			npis = append(npis, p.NPI)
+		}
+		if len(npis) != len(tt.want) {
+			t.Errorf("SearchByName(%q, %q, %q) = %v, want %v", tt.first, tt.last, tt.state, npis, tt.want)
+			continue
+		}
+		for i := range npis {
+			if npis[i] != tt.want[i] {
+				t.Errorf("SearchByName(%q, %q, %q) = %v, want %v", tt.first, tt.last, tt.state, npis, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestOpenBulkFile_ZipAndReuse(t *testing.T) {
+	dir := t.TempDir()
+	csvPath := writeBulkCSV(t, dir, testBulkRows)
+	data, err := os.ReadFile(csvPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zipPath := filepath.Join(dir, "NPPES_Data_Dissemination_January_2026.zip")
+	zf, err := os.Create(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(zf)
+	for name, body := range map[string][]byte{
+		"npidata_pfile_20260101-20260131_fileheader.csv": []byte("NPI\n"),
+		"npidata_pfile_20260101-20260131.csv":            data,
+	} {
+		w, _ := zw.Create(name)
+		w.Write(body)
+	}
+	zw.Close()
+	zf.Close()
+
+	x, err := OpenBulkFile(zipPath, nil)
+	if err != nil {
+		t.Fatalf("OpenBulkFile(zip): %v", err)
+	}
+	if info, _ := x.Lookup(1234567893); info == nil || info.Name != "SMITH, JANE" {
+		t.Errorf("unexpected record from the zip's index: %+v", info)
+	}
+	x.Close()
+
+	// A second open reuses the index rather than rebuilding it.
+	idx := zipPath + ".idx"
+	before, err := os.Stat(idx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x, err = OpenBulkFile(zipPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x.Close()
+	if after, _ := os.Stat(idx); !after.ModTime().Equal(before.ModTime()) {
+		t.Error("index rebuilt although it was newer than the file")
+	}
+
+	// And the .idx can be opened directly.
+	x, err = OpenBulkFile(idx, nil)
+	if err != nil {
+		t.Fatalf("OpenBulkFile(.idx): %v", err)
+	}
+	x.Close()
+}
+
+func TestBuildIndex_Errors(t *testing.T) {
+	dir := t.TempDir()
+
+	notCSV := filepath.Join(dir, "other.csv")
+	os.WriteFile(notCSV, []byte("a,b,c\n1,2,3\n"), 0o644)
+	if err := BuildIndex(notCSV, notCSV+".idx", nil); err == nil || !strings.Contains(err.Error(), "is this an npidata_pfile CSV") {
+		t.Errorf("expected a missing column error, got %v", err)
+	}
+	if _, err := os.Stat(notCSV + ".idx.tmp"); !os.IsNotExist(err) {
+		t.Error("temporary index left behind")
+	}
+
+	empty := writeBulkCSV(t, t.TempDir(), nil)
+	if err := BuildIndex(empty, empty+".idx", nil); err == nil {
+		t.Error("expected an error for a file with no provider rows")
+	}
+
+	emptyZip := filepath.Join(dir, "empty.zip")
+	zf, _ := os.Create(emptyZip)
+	zip.NewWriter(zf).Close()
+	zf.Close()
+	if err := BuildIndex(emptyZip, emptyZip+".idx", nil); err == nil || !strings.Contains(err.Error(), "no npidata_pfile CSV") {
+		t.Errorf("expected an error for a zip without the CSV, got %v", err)
+	}
+
+	if _, err := OpenIndex(notCSV); err == nil {
+		t.Error("OpenIndex accepted a file that is not an index")
+	}
+}
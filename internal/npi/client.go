@@ -0,0 +1,322 @@
+package npi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gyeh/npi-rates/internal/cache"
+	"github.com/gyeh/npi-rates/internal/httpx"
+)
+
+// Client queries the NPPES NPI Registry API. It bounds how many requests
+// are in flight, backs off when the registry throttles (429) or errors
+// (5xx), and caches lookups on disk so repeated runs for the same NPIs
+// don't query the registry again.
+type Client struct {
+	HTTP *http.Client
+
+	// Concurrency is the most requests LookupAll has in flight at once.
+	Concurrency int
+
+	// MaxRetries is how many times a throttled or failed request is
+	// retried, with exponential backoff starting at one second.
+	MaxRetries int
+
+	// CacheDir, if set, holds one file per NPI found by Lookup. Entries
+	// older than CacheTTL are queried again.
+	CacheDir string
+	CacheTTL time.Duration
+
+	// Offline, if set, answers from the cache only: anything else fails
+	// with ErrOffline instead of querying the registry. Workers of a
+	// distributed run set it, so that hundreds of them don't get their
+	// shared IPs blocked; the orchestrator does the lookups once.
+	Offline bool
+
+	// Clock, if set, times retry backoff and cache expiry instead of the
+	// system clock. worker.Clock implementations satisfy it.
+	Clock Clock
+}
+
+// Clock is the source of time for a Client.
+type Clock interface {
+	Now() time.Time
+	// Sleep waits for d, returning ctx.Err() early if ctx is done first.
+	Sleep(ctx context.Context, d time.Duration) error
+}
+
+func (c *Client) now() time.Time {
+	if c.Clock != nil {
+		return c.Clock.Now()
+	}
+	return time.Now()
+}
+
+func (c *Client) sleep(ctx context.Context, d time.Duration) error {
+	if c.Clock != nil {
+		return c.Clock.Sleep(ctx, d)
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ErrOffline is returned for lookups a Client with Offline set would have
+// to make against the registry.
+var ErrOffline = errors.New("NPI registry lookups are disabled on this worker")
+
+// DefaultClient is the client used by Lookup, LookupAll, SearchByName and
+// SearchOrganization.
+var DefaultClient = &Client{
+	HTTP:        httpx.Short,
+	Concurrency: 4,
+	MaxRetries:  4,
+	CacheDir:    defaultCacheDir(),
+	CacheTTL:    7 * 24 * time.Hour,
+}
+
+// defaultCacheDir returns ~/.cache/npi-rates/nppes (or the platform's
+// equivalent), or "" if there is no user cache directory.
+func defaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "npi-rates", "nppes")
+}
+
+// SearchByName is the package-level SearchByName, always using the API.
+func (c *Client) SearchByName(ctx context.Context, firstName, lastName, state string) ([]*ProviderInfo, error) {
+	u := fmt.Sprintf("%s&enumeration_type=NPI-1&limit=20&first_name=%s&last_name=%s",
+		registryURL, firstName, lastName)
+	if state != "" {
+		u += "&state=" + state
+	}
+	apiResp, err := c.get(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	var results []*ProviderInfo
+	for _, r := range apiResp.Results {
+		results = append(results, resultToProviderInfo(r))
+	}
+	return results, nil
+}
+
+// Lookup is the package-level Lookup, always using the API (or the cache).
+func (c *Client) Lookup(ctx context.Context, number int64) (*ProviderInfo, error) {
+	if info := c.cached(number); info != nil {
+		return info, nil
+	}
+	apiResp, err := c.get(ctx, fmt.Sprintf("%s&number=%d", registryURL, number))
+	if err != nil {
+		return nil, err
+	}
+	if len(apiResp.Results) == 0 {
+		return nil, nil
+	}
+	info := resultToProviderInfo(apiResp.Results[0])
+	c.store(info)
+	return info, nil
+}
+
+// LookupAll is the package-level LookupAll, with at most c.Concurrency
+// lookups in flight.
+func (c *Client) LookupAll(ctx context.Context, npis []int64) ([]*ProviderInfo, []error) {
+	results := make([]*ProviderInfo, len(npis))
+	errs := make([]error, len(npis))
+
+	sem := make(chan struct{}, max(c.Concurrency, 1))
+	var wg sync.WaitGroup
+	for i, n := range npis {
+		wg.Add(1)
+		go func(idx int, number int64) {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				errs[idx] = ctx.Err()
+				return
+			}
+			defer func() { <-sem }()
+			results[idx], errs[idx] = c.Lookup(ctx, number)
+		}(i, n)
+	}
+	wg.Wait()
+	return results, errs
+}
+
+// get queries the registry, retrying 429s and 5xx responses with
+// exponential backoff (or the registry's Retry-After, if longer).
+func (c *Client) get(ctx context.Context, url string) (*apiResponse, error) {
+	if c.Offline {
+		return nil, ErrOffline
+	}
+	var lastErr error
+	for attempt := 0; attempt <= c.MaxRetries; attempt++ {
+		if attempt > 0 {
+			delay := time.Duration(1<<(attempt-1)) * time.Second
+			if ra, ok := lastErr.(retryAfterError); ok && ra.after > delay {
+				delay = ra.after
+			}
+			if err := c.sleep(ctx, delay); err != nil {
+				return nil, fmt.Errorf("querying NPI registry: %w (last error: %v)", err, lastErr)
+			}
+		}
+
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.HTTP.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, fmt.Errorf("querying NPI registry: %w", err)
+			}
+			lastErr = fmt.Errorf("querying NPI registry: %w", err)
+			continue
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+			lastErr = retryAfterError{
+				err:   fmt.Errorf("NPI registry returned HTTP %d", resp.StatusCode),
+				after: parseRetryAfter(resp.Header.Get("Retry-After")),
+			}
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("NPI registry returned HTTP %d", resp.StatusCode)
+		}
+		if err != nil {
+			lastErr = fmt.Errorf("reading NPI registry response: %w", err)
+			continue
+		}
+
+		var apiResp apiResponse
+		if err := json.Unmarshal(body, &apiResp); err != nil {
+			return nil, fmt.Errorf("parsing NPI registry response: %w", err)
+		}
+		return &apiResp, nil
+	}
+	return nil, lastErr
+}
+
+// retryAfterError is a retryable registry response, with the delay it asked
+// for (zero if none).
+type retryAfterError struct {
+	err   error
+	after time.Duration
+}
+
+func (e retryAfterError) Error() string { return e.err.Error() }
+
+// parseRetryAfter parses a Retry-After header given in seconds. HTTP dates
+// aren't used by the registry and are ignored.
+func parseRetryAfter(v string) time.Duration {
+	secs, err := strconv.Atoi(v)
+	if err != nil || secs < 0 {
+		return 0
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// cacheEntry is the on-disk form of a cached lookup.
+type cacheEntry struct {
+	Fetched time.Time     `json:"fetched"`
+	Info    *ProviderInfo `json:"info"`
+}
+
+func (c *Client) cachePath(number int64) string {
+	return filepath.Join(c.CacheDir, strconv.FormatInt(number, 10)+".json")
+}
+
+// cached returns the cached lookup for number, or nil if there is none or
+// it has expired.
+func (c *Client) cached(number int64) *ProviderInfo {
+	if c.CacheDir == "" || c.CacheTTL <= 0 {
+		return nil
+	}
+	data, err := os.ReadFile(c.cachePath(number))
+	if err != nil {
+		return nil
+	}
+	var e cacheEntry
+	if json.Unmarshal(data, &e) != nil || e.Info == nil || c.now().Sub(e.Fetched) > c.CacheTTL {
+		return nil
+	}
+	cache.Touch(c.cachePath(number))
+	return e.Info
+}
+
+// store caches a successful lookup. Failures to write are ignored; the
+// cache only saves requests.
+func (c *Client) store(info *ProviderInfo) {
+	if c.CacheDir == "" || c.CacheTTL <= 0 {
+		return
+	}
+	data, err := json.Marshal(cacheEntry{Fetched: c.now(), Info: info})
+	if err != nil || os.MkdirAll(c.CacheDir, 0o755) != nil {
+		return
+	}
+	tmp, err := os.CreateTemp(c.CacheDir, ".tmp-*")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil && closeErr == nil {
+		os.Rename(tmp.Name(), c.cachePath(info.NPI))
+	} else {
+		os.Remove(tmp.Name())
+	}
+}
+
+// CacheEntries lists the lookups cached in dir (see Client.CacheDir) for
+// the cache package. An entry was last used when it was fetched or last
+// read; expired ones are listed too.
+func CacheEntries(dir string) ([]cache.Entry, error) {
+	files, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []cache.Entry
+	for _, f := range files {
+		number, ok := strings.CutSuffix(f.Name(), ".json")
+		if !ok || f.IsDir() {
+			continue
+		}
+		if _, err := strconv.ParseInt(number, 10, 64); err != nil {
+			continue
+		}
+		info, err := f.Info()
+		if err != nil {
+			continue
+		}
+		entries = append(entries, cache.Entry{
+			Name:     "NPI " + number,
+			Paths:    []string{filepath.Join(dir, f.Name())},
+			Size:     info.Size(),
+			LastUsed: info.ModTime(),
+		})
+	}
+	return entries, nil
+}
@@ -0,0 +1,304 @@
+package npi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeClock records requested sleeps instead of waiting.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	sleeps []time.Duration
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Sleep(ctx context.Context, d time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sleeps = append(c.sleeps, d)
+	c.now = c.now.Add(d)
+	return ctx.Err()
+}
+
+// redirectTransport sends every request to the test server instead.
+type redirectTransport struct{ target *url.URL }
+
+func (t redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = t.target.Scheme
+	req.URL.Host = t.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// newTestClient returns a Client whose registry is handler, with a cache
+// in a temp dir and a fake clock.
+func newTestClient(t *testing.T, handler http.HandlerFunc) (*Client, *fakeClock) {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	target, _ := url.Parse(server.URL)
+	fc := &fakeClock{now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	return &Client{
+		HTTP:        &http.Client{Transport: redirectTransport{target}},
+		Concurrency: 2,
+		MaxRetries:  3,
+		CacheDir:    t.TempDir(),
+		CacheTTL:    24 * time.Hour,
+		Clock:       fc,
+	}, fc
+}
+
+// registryResult is the registry's response for one individual provider.
+func registryResult(number string) string {
+	return fmt.Sprintf(`{"result_count": 1, "results": [{
+		"number": %q,
+		"enumeration_type": "NPI-1",
+		"basic": {"first_name": "JANE", "last_name": "SMITH", "credential": "M.D.", "enumeration_date": "2008-05-14", "status": "A"},
+		"addresses": [
+			{"city": "CAMBRIDGE", "state": "MA", "postal_code": "02139", "address_purpose": "MAILING"},
+			{"city": "BOSTON", "state": "MA", "postal_code": "021151234", "address_purpose": "LOCATION", "telephone_number": "617-555-0100"}
+		],
+		"taxonomies": [
+			{"code": "207Q00000X", "desc": "Family Medicine", "primary": false},
+			{"code": "207R00000X", "desc": "Internal Medicine", "primary": true}
+		]
+	}]}`, number)
+}
+
+func TestClientLookup(t *testing.T) {
+	c, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if got := r.URL.Query().Get("number"); got != "1770671182" {
+			t.Errorf("queried number %q", got)
+		}
+		fmt.Fprint(w, registryResult("1770671182"))
+	})
+	info, err := c.Lookup(context.Background(), 1770671182)
+	if err != nil {
+		t.Fatalf("Lookup: %v", err)
+	}
+	want := ProviderInfo{
+		NPI:             1770671182,
+		Name:            "SMITH, JANE",
+		Credential:      "M.D.",
+		Type:            "Individual",
+		PrimaryTaxonomy: "Internal Medicine",
+		TaxonomyCode:    "207R00000X",
+		PracticeAddress: "BOSTON, MA 02115",
+		PracticePhone:   "(617) 555-0100",
+		EnumerationDate: "2008-05-14",
+		Status:          "A",
+	}
+	if *info != want {
+		t.Errorf("Lookup = %+v\nwant     %+v", *info, want)
+	}
+}
+
+func TestClientLookup_NotFound(t *testing.T) {
+	c, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"result_count": 0, "results": []}`)
+	})
+	info, err := c.Lookup(context.Background(), 1234567893)
+	if info != nil || err != nil {
+		t.Errorf("Lookup = %+v, %v; want nil, nil", info, err)
+	}
+}
+
+// TestClientRetryBackoff verifies the retry delays, taken from the Client's
+// Clock: exponential from one second, or the registry's longer Retry-After.
+func TestClientRetryBackoff(t *testing.T) {
+	var requests int
+	c, fc := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch requests {
+		case 1:
+			w.WriteHeader(http.StatusBadGateway)
+		case 2:
+			w.Header().Set("Retry-After", "5")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case 3:
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			fmt.Fprint(w, registryResult("1770671182"))
+		}
+	})
+	if _, err := c.Lookup(context.Background(), 1770671182); err != nil {
+		t.Fatalf("Lookup: %v", err)
+	}
+	want := []time.Duration{time.Second, 5 * time.Second, 4 * time.Second}
+	if fmt.Sprint(fc.sleeps) != fmt.Sprint(want) {
+		t.Errorf("sleeps = %v, want %v", fc.sleeps, want)
+	}
+}
+
+func TestClientRetry_GivesUp(t *testing.T) {
+	var requests int
+	c, fc := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	_, err := c.Lookup(context.Background(), 1770671182)
+	if err == nil || !strings.Contains(err.Error(), "HTTP 503") {
+		t.Errorf("expected the last HTTP 503 error, got %v", err)
+	}
+	if requests != c.MaxRetries+1 || len(fc.sleeps) != c.MaxRetries {
+		t.Errorf("expected %d requests and %d sleeps, got %d and %d", c.MaxRetries+1, c.MaxRetries, requests, len(fc.sleeps))
+	}
+}
+
+func TestClientRetry_ClientErrorNotRetried(t *testing.T) {
+	var requests int
+	c, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusBadRequest)
+	})
+	if _, err := c.Lookup(context.Background(), 1770671182); err == nil {
+		t.Error("expected an error for HTTP 400")
+	}
+	if requests != 1 {
+		t.Errorf("HTTP 400 was retried (%d requests)", requests)
+	}
+}
+
+func TestClientRetry_Canceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	c, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		cancel()
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	_, err := c.Lookup(ctx, 1770671182)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+// TestClientCache verifies that lookups are answered from the cache until
+// CacheTTL has passed on the Client's clock.
+func TestClientCache(t *testing.T) {
+	var requests int
+	c, fc := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		fmt.Fprint(w, registryResult("1770671182"))
+	})
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if info, err := c.Lookup(ctx, 1770671182); err != nil || info == nil {
+			t.Fatalf("Lookup %d: %+v, %v", i, info, err)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("expected the second lookup from the cache, got %d requests", requests)
+	}
+
+	fc.now = fc.now.Add(25 * time.Hour)
+	if _, err := c.Lookup(ctx, 1770671182); err != nil {
+		t.Fatal(err)
+	}
+	if requests != 2 {
+		t.Errorf("expected an expired entry to be queried again, got %d requests", requests)
+	}
+
+	entries, err := CacheEntries(c.CacheDir)
+	if err != nil || len(entries) != 1 || entries[0].Name != "NPI 1770671182" {
+		t.Errorf("CacheEntries = %+v, %v", entries, err)
+	}
+}
+
+// TestClientOffline verifies that an Offline client answers from the cache
+// and fails anything else with ErrOffline, without querying the registry.
+func TestClientOffline(t *testing.T) {
+	var requests int
+	c, fc := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		fmt.Fprint(w, registryResult(r.URL.Query().Get("number")))
+	})
+	ctx := context.Background()
+	if _, err := c.Lookup(ctx, 1770671182); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Offline = true
+	requests = 0
+	if info, err := c.Lookup(ctx, 1770671182); err != nil || info == nil || info.NPI != 1770671182 {
+		t.Errorf("cached Lookup = %+v, %v", info, err)
+	}
+	if info, err := c.Lookup(ctx, 1234567893); info != nil || !errors.Is(err, ErrOffline) {
+		t.Errorf("uncached Lookup = %+v, %v; want ErrOffline", info, err)
+	}
+	if _, err := c.SearchByName(ctx, "JANE", "SMITH", "MA"); !errors.Is(err, ErrOffline) {
+		t.Errorf("SearchByName: %v; want ErrOffline", err)
+	}
+	if _, err := c.SearchOrganization(ctx, "Acme Health", "MA"); !errors.Is(err, ErrOffline) {
+		t.Errorf("SearchOrganization: %v; want ErrOffline", err)
+	}
+	_, errs := c.LookupAll(ctx, []int64{1770671182, 1234567893})
+	if errs[0] != nil || !errors.Is(errs[1], ErrOffline) {
+		t.Errorf("LookupAll errors = %v", errs)
+	}
+
+	fc.now = fc.now.Add(25 * time.Hour)
+	if _, err := c.Lookup(ctx, 1770671182); !errors.Is(err, ErrOffline) {
+		t.Errorf("expired Lookup: %v; want ErrOffline", err)
+	}
+	if requests != 0 {
+		t.Errorf("expected no registry requests offline, got %d", requests)
+	}
+}
+
+// TestClientLookupAll verifies that results keep the input order and that
+// at most Concurrency requests are in flight.
+func TestClientLookupAll(t *testing.T) {
+	var inFlight, peak atomic.Int32
+	c, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		number := r.URL.Query().Get("number")
+		if number == "1111111112" {
+			fmt.Fprint(w, `{"result_count": 0, "results": []}`)
+			return
+		}
+		fmt.Fprint(w, registryResult(number))
+	})
+	npis := []int64{1770671182, 1111111112, 1234567893, 1497758544, 1588667638}
+	results, errs := c.LookupAll(context.Background(), npis)
+	for i, n := range npis {
+		if errs[i] != nil {
+			t.Errorf("NPI %d: %v", n, errs[i])
+			continue
+		}
+		if n == 1111111112 {
+			if results[i] != nil {
+				t.Errorf("expected nil for the unknown NPI, got %+v", results[i])
+			}
+			continue
+		}
+		if results[i] == nil || results[i].NPI != n {
+			t.Errorf("result %d = %+v, want NPI %d", i, results[i], n)
+		}
+	}
+	if p := peak.Load(); p > int32(c.Concurrency) {
+		t.Errorf("%d requests in flight, want at most %d", p, c.Concurrency)
+	}
+}
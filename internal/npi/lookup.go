@@ -2,19 +2,13 @@ package npi
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
 	"strconv"
 	"strings"
-	"time"
 )
 
 const registryURL = "https://npiregistry.cms.hhs.gov/api/?version=2.1"
 
-var client = &http.Client{Timeout: 10 * time.Second}
-
 // ProviderInfo holds the key details returned by the NPPES NPI Registry.
 type ProviderInfo struct {
 	NPI              int64
@@ -78,84 +72,19 @@ type apiTaxonomy struct {
 // the given first/last name. An optional state (2-letter code) narrows results.
 // Returns up to 20 matching providers.
 func SearchByName(ctx context.Context, firstName, lastName, state string) ([]*ProviderInfo, error) {
-	u := fmt.Sprintf("%s&enumeration_type=NPI-1&limit=20&first_name=%s&last_name=%s",
-		registryURL, firstName, lastName)
-	if state != "" {
-		u += "&state=" + state
-	}
-
-	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
-	if err != nil {
-		return nil, err
-	}
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("querying NPI registry: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("NPI registry returned HTTP %d", resp.StatusCode)
-	}
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
+	if bulk != nil {
+		return bulk.SearchByName(firstName, lastName, state)
 	}
-
-	var apiResp apiResponse
-	if err := json.Unmarshal(body, &apiResp); err != nil {
-		return nil, fmt.Errorf("parsing NPI registry response: %w", err)
-	}
-
-	if apiResp.ResultCount == 0 || len(apiResp.Results) == 0 {
-		return nil, nil
-	}
-
-	var results []*ProviderInfo
-	for _, r := range apiResp.Results {
-		info := resultToProviderInfo(r)
-		results = append(results, info)
-	}
-	return results, nil
+	return DefaultClient.SearchByName(ctx, firstName, lastName, state)
 }
 
 // Lookup queries the NPPES NPI Registry for a single NPI number.
 // Returns nil if the NPI is not found.
 func Lookup(ctx context.Context, number int64) (*ProviderInfo, error) {
-	url := fmt.Sprintf("%s&number=%d", registryURL, number)
-
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-	if err != nil {
-		return nil, err
-	}
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("querying NPI registry: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("NPI registry returned HTTP %d", resp.StatusCode)
-	}
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	var apiResp apiResponse
-	if err := json.Unmarshal(body, &apiResp); err != nil {
-		return nil, fmt.Errorf("parsing NPI registry response: %w", err)
-	}
-
-	if apiResp.ResultCount == 0 || len(apiResp.Results) == 0 {
-		return nil, nil
+	if bulk != nil {
+		return bulk.Lookup(number)
 	}
-
-	return resultToProviderInfo(apiResp.Results[0]), nil
+	return DefaultClient.Lookup(ctx, number)
 }
 
 func resultToProviderInfo(r apiResult) *ProviderInfo {
@@ -208,30 +137,15 @@ func resultToProviderInfo(r apiResult) *ProviderInfo {
 // LookupAll queries the NPPES NPI Registry for multiple NPIs concurrently.
 // Returns results in the same order as input. Missing NPIs have nil entries.
 func LookupAll(ctx context.Context, npis []int64) ([]*ProviderInfo, []error) {
-	results := make([]*ProviderInfo, len(npis))
-	errs := make([]error, len(npis))
-
-	type indexedResult struct {
-		idx  int
-		info *ProviderInfo
-		err  error
-	}
-
-	ch := make(chan indexedResult, len(npis))
-	for i, n := range npis {
-		go func(idx int, number int64) {
-			info, err := Lookup(ctx, number)
-			ch <- indexedResult{idx, info, err}
-		}(i, n)
-	}
-
-	for range npis {
-		r := <-ch
-		results[r.idx] = r.info
-		errs[r.idx] = r.err
+	if bulk != nil {
+		results := make([]*ProviderInfo, len(npis))
+		errs := make([]error, len(npis))
+		for i, n := range npis {
+			results[i], errs[i] = bulk.Lookup(n)
+		}
+		return results, errs
 	}
-
-	return results, errs
+	return DefaultClient.LookupAll(ctx, npis)
 }
 
 func formatIndividualName(b apiBasic) string {
@@ -0,0 +1,219 @@
+package npi
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// maxOrgCandidates is how many registry matches are fetched for ranking.
+const maxOrgCandidates = 200 // the registry's maximum limit
+
+// SearchOrganization returns up to 20 organizations (NPI-2) whose names
+// contain the words of name, best matches first, optionally only those
+// practicing in state (2-letter code). Words match regardless of order,
+// case, punctuation and suffixes such as "Inc" or "LLC", and a word may be
+// abbreviated ("Mem" matches "Memorial").
+func SearchOrganization(ctx context.Context, name, state string) ([]*ProviderInfo, error) {
+	if bulk != nil {
+		return bulk.SearchOrganization(name, state)
+	}
+	return DefaultClient.SearchOrganization(ctx, name, state)
+}
+
+// SearchOrganization is the package-level SearchOrganization, always using
+// the API. The registry only matches names by prefix, so the query is sent
+// as "name*" and, if that finds nothing, as its first word followed by "*";
+// the candidates are then filtered and ranked here. Names with the words
+// further in (e.g. "The Mount Sinai Hospital" for "Mount Sinai") are only
+// found when they start with the first word; an NPPES file finds them all.
+func (c *Client) SearchOrganization(ctx context.Context, name, state string) ([]*ProviderInfo, error) {
+	words := orgWords(name)
+	if len(words) == 0 {
+		return nil, fmt.Errorf("organization name %q has no searchable words", name)
+	}
+	queries := []string{strings.Join(strings.Fields(name), " ") + "*"}
+	if first := words[0] + "*"; !strings.EqualFold(first, queries[0]) && len(words[0]) >= 2 {
+		queries = append(queries, first)
+	}
+	var results []*ProviderInfo
+	for _, q := range queries {
+		u := fmt.Sprintf("%s&enumeration_type=NPI-2&limit=%d&organization_name=%s",
+			registryURL, maxOrgCandidates, url.QueryEscape(q))
+		if state != "" {
+			u += "&state=" + url.QueryEscape(state)
+		}
+		apiResp, err := c.get(ctx, u)
+		if err != nil {
+			return nil, err
+		}
+		var candidates []*ProviderInfo
+		for _, r := range apiResp.Results {
+			candidates = append(candidates, resultToProviderInfo(r))
+		}
+		if results = rankOrganizations(words, candidates); len(results) > 0 {
+			break
+		}
+	}
+	return results, nil
+}
+
+// SearchOrganization is the package-level SearchOrganization, answered from
+// the index's organization table: the records listed under the query word
+// with the fewest of them are read and ranked. A query with no word of
+// orgPrefixLen letters or more scans every record instead, which takes a
+// few seconds for the full file.
+func (x *Index) SearchOrganization(name, state string) ([]*ProviderInfo, error) {
+	words := orgWords(name)
+	if len(words) == 0 {
+		return nil, fmt.Errorf("organization name %q has no searchable words", name)
+	}
+	var first, end int64 = -1, -1
+	for _, w := range words {
+		if len(w) < orgPrefixLen {
+			continue
+		}
+		k := orgPrefixHash(w)
+		lo, err := x.search(x.orgOff, x.orgCount, k)
+		if err != nil {
+			return nil, err
+		}
+		hi := x.orgCount
+		if k < math.MaxUint64 {
+			if hi, err = x.search(x.orgOff, x.orgCount, k+1); err != nil {
+				return nil, err
+			}
+		}
+		if first < 0 || hi-lo < end-first {
+			first, end = lo, hi
+		}
+	}
+	if first < 0 {
+		return x.scanOrganizations(words, state)
+	}
+	var candidates []*ProviderInfo
+	for i := first; i < end; i++ {
+		_, off, err := x.entry(x.orgOff, i)
+		if err != nil {
+			return nil, err
+		}
+		info, recState, err := x.record(off)
+		if err != nil {
+			return nil, err
+		}
+		if state != "" && !strings.EqualFold(recState, state) {
+			continue
+		}
+		candidates = append(candidates, info)
+	}
+	return rankOrganizations(words, candidates), nil
+}
+
+// scanOrganizations is SearchOrganization for queries the organization
+// table can't answer, reading every record.
+func (x *Index) scanOrganizations(words []string, state string) ([]*ProviderInfo, error) {
+	start := int64(len(indexMagic))
+	br := bufio.NewReaderSize(io.NewSectionReader(x.f, start, x.npiOff-start), 1<<20)
+	var candidates []*ProviderInfo
+	for {
+		line, err := br.ReadString('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading NPPES index: %w", err)
+		}
+		f := strings.Split(strings.TrimSuffix(line, "\n"), "\t")
+		if len(f) != 10 || f[1] != "Organization" || state != "" && !strings.EqualFold(f[9], state) {
+			continue
+		}
+		if orgMatches(words, orgWords(f[2])) {
+			candidates = append(candidates, decodeRecord(f))
+		}
+	}
+	return rankOrganizations(words, candidates), nil
+}
+
+// orgStopWords are left out when comparing organization names.
+var orgStopWords = []string{
+	"THE", "OF", "AND", "INC", "LLC", "LLP", "PC", "PLLC", "PA", "LTD",
+	"CORP", "CORPORATION", "CO", "COMPANY",
+}
+
+// orgWords splits an organization name into upper-case words, dropping
+// punctuation and stop words (unless that would leave none).
+func orgWords(name string) []string {
+	all := strings.FieldsFunc(strings.ToUpper(name), func(c rune) bool {
+		return !(c >= 'A' && c <= 'Z' || c >= '0' && c <= '9')
+	})
+	words := slices.DeleteFunc(slices.Clone(all), func(w string) bool {
+		return slices.Contains(orgStopWords, w)
+	})
+	if len(words) == 0 {
+		return all
+	}
+	return words
+}
+
+// orgMatches reports whether every query word is a word, or the start of a
+// word, of name.
+func orgMatches(query, name []string) bool {
+	for _, q := range query {
+		if !slices.ContainsFunc(name, func(w string) bool { return strings.HasPrefix(w, q) }) {
+			return false
+		}
+	}
+	return true
+}
+
+// rankOrganizations returns the candidates matching query, best first:
+// active before deactivated, then by how many query words match whole
+// words, then by how few other words the name has. At most maxNameResults
+// are returned.
+func rankOrganizations(query []string, candidates []*ProviderInfo) []*ProviderInfo {
+	type ranked struct {
+		info         *ProviderInfo
+		exact, extra int
+	}
+	var rs []ranked
+	for _, c := range candidates {
+		words := orgWords(c.Name)
+		if !orgMatches(query, words) {
+			continue
+		}
+		exact := 0
+		for _, q := range query {
+			if slices.Contains(words, q) {
+				exact++
+			}
+		}
+		rs = append(rs, ranked{c, exact, len(words) - len(query)})
+	}
+	slices.SortStableFunc(rs, func(a, b ranked) int {
+		switch {
+		case (a.info.Status == "D") != (b.info.Status == "D"):
+			if a.info.Status == "D" {
+				return 1
+			}
+			return -1
+		case a.exact != b.exact:
+			return b.exact - a.exact
+		case a.extra != b.extra:
+			return a.extra - b.extra
+		}
+		return strings.Compare(a.info.Name, b.info.Name)
+	})
+	var out []*ProviderInfo
+	for _, r := range rs {
+		if len(out) == maxNameResults {
+			break
+		}
+		out = append(out, r.info)
+	}
+	return out
+}
@@ -0,0 +1,172 @@
+package npi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// orgNPIs returns the NPIs of infos, in order.
+func orgNPIs(infos []*ProviderInfo) []int64 {
+	var npis []int64
+	for _, p := range infos {
+		npis = append(npis, p.NPI)
+	}
+	return npis
+}
+
+func TestIndexSearchOrganization(t *testing.T) {
+	x := openTestIndex(t)
+
+	tests := []struct {
+		name, state string
+		want        []int64
+	}{
+		{"Mount Sinai", "", []int64{1497758544, 1588667638}},
+		{"sinai mount", "ny", []int64{1497758544, 1588667638}}, // any order
+		{"Mount Sinai Queens", "", []int64{1588667638}},
+		{"Mt Sinai", "", nil},
+		{"Sina Hosp", "", []int64{1497758544, 1588667638}}, // abbreviated words
+		{"The Mount Sinai Hospital, Inc.", "", []int64{1497758544, 1588667638}},
+		{"Mount Sinai", "MA", nil},
+		{"Smith", "", nil}, // individuals aren't in the organization table
+		{"of Queens", "", []int64{1588667638}},
+		{"MT", "", nil}, // too short for the table: a scan
+		{"Que", "", []int64{1588667638}},
+	}
+	for _, tt := range tests {
+		got, err := x.SearchOrganization(tt.name, tt.state)
+		if err != nil {
+			t.Fatalf("SearchOrganization(%q, %q): %v", tt.name, tt.state, err)
+		}
+		if npis := orgNPIs(got); !slices.Equal(npis, tt.want) {
+			t.Errorf("SearchOrganization(%q, %q) = %v, want %v", tt.name, tt.state, npis, tt.want)
+		}
+	}
+	if _, err := x.SearchOrganization("the, inc.", ""); err != nil {
+		t.Errorf("expected a name of only stop words to be searched, got %v", err)
+	}
+	if _, err := x.SearchOrganization("--", ""); err == nil {
+		t.Error("expected an error for a name with no words")
+	}
+}
+
+// TestIndexSearchOrganization_Table verifies that the organization table
+// finds what a scan of the records does.
+func TestIndexSearchOrganization_Table(t *testing.T) {
+	x := openTestIndex(t)
+	// MOUN, SINA and HOSP for both organizations, and QUEE.
+	if x.orgCount != 7 {
+		t.Errorf("expected 7 organization table entries, got %d", x.orgCount)
+	}
+	for _, name := range []string{"Mount Sinai", "Hospital", "Queens", "Sinai Hospital of"} {
+		table, err := x.SearchOrganization(name, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		scan, err := x.scanOrganizations(orgWords(name), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(orgNPIs(table), orgNPIs(scan)) {
+			t.Errorf("%q: the table found %v, a scan %v", name, orgNPIs(table), orgNPIs(scan))
+		}
+	}
+}
+
+func TestOpenBulkFile_OldIndex(t *testing.T) {
+	csvPath := writeBulkCSV(t, t.TempDir(), testBulkRows)
+	idx := csvPath + ".idx"
+	// An index in the previous format, newer than the file, is rebuilt.
+	if err := os.WriteFile(idx, append([]byte("NPPESIX1"), make([]byte, 32)...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	x, err := OpenBulkFile(csvPath, nil)
+	if err != nil {
+		t.Fatalf("OpenBulkFile: %v", err)
+	}
+	defer x.Close()
+	if got, err := x.SearchOrganization("Queens", ""); err != nil || len(got) != 1 {
+		t.Errorf("unexpected search of the rebuilt index: %v, %v", orgNPIs(got), err)
+	}
+	if _, err := OpenIndex(filepath.Join(t.TempDir(), "missing.idx")); err == nil {
+		t.Error("expected an error for a missing index")
+	}
+}
+
+func TestRankOrganizations(t *testing.T) {
+	org := func(npi int64, name, status string) *ProviderInfo {
+		return &ProviderInfo{NPI: npi, Name: name, Status: status}
+	}
+	candidates := []*ProviderInfo{
+		org(1, "Sinai Hospital Group of Baltimore", "A"),
+		org(2, "Sinai Hospital", "D"),
+		org(3, "Sinai Hospitalist Partners", "A"),
+		org(4, "Sinai Hospital of Baltimore", "A"),
+		org(5, "Mount Sinai Medical Center", "A"),
+	}
+	// Active first, then whole-word matches, then fewer extra words.
+	got := orgNPIs(rankOrganizations(orgWords("Sinai Hospital"), candidates))
+	if want := []int64{4, 1, 3, 2}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	var many []*ProviderInfo
+	for i := range maxNameResults + 5 {
+		many = append(many, org(int64(i), fmt.Sprintf("Clinic %d", i), "A"))
+	}
+	if n := len(rankOrganizations(orgWords("clinic"), many)); n != maxNameResults {
+		t.Errorf("expected %d results, got %d", maxNameResults, n)
+	}
+}
+
+func TestOrgWords(t *testing.T) {
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{"The Mount Sinai Hospital of Queens, Inc.", []string{"MOUNT", "SINAI", "HOSPITAL", "QUEENS"}},
+		{"St. Luke's-Roosevelt", []string{"ST", "LUKE", "S", "ROOSEVELT"}},
+		{"The Company, Inc.", []string{"THE", "COMPANY", "INC"}}, // only stop words
+	}
+	for _, tt := range tests {
+		if got := orgWords(tt.name); !slices.Equal(got, tt.want) {
+			t.Errorf("orgWords(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestClientSearchOrganization(t *testing.T) {
+	var queries []string
+	c, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("enumeration_type") != "NPI-2" || q.Get("state") != "NY" {
+			t.Errorf("unexpected query %s", r.URL.RawQuery)
+		}
+		queries = append(queries, q.Get("organization_name"))
+		if q.Get("organization_name") != "MOUNT*" {
+			fmt.Fprint(w, `{"result_count": 0, "results": []}`)
+			return
+		}
+		fmt.Fprint(w, `{"result_count": 2, "results": [
+			{"number": "1588667638", "enumeration_type": "NPI-2", "basic": {"organization_name": "THE MOUNT SINAI HOSPITAL OF QUEENS", "status": "A"}},
+			{"number": "1497758544", "enumeration_type": "NPI-2", "basic": {"organization_name": "MOUNT SINAI HOSPITAL", "status": "A"}},
+			{"number": "1003000126", "enumeration_type": "NPI-2", "basic": {"organization_name": "MOUNTAIN VIEW CLINIC", "status": "A"}}
+		]}`)
+	})
+	got, err := c.SearchOrganization(context.Background(), "Mount  Sinai", "NY")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The full name finds nothing, so the first word is tried.
+	if want := []string{"Mount Sinai*", "MOUNT*"}; !slices.Equal(queries, want) {
+		t.Errorf("queried %q, want %q", queries, want)
+	}
+	if npis, want := orgNPIs(got), []int64{1497758544, 1588667638}; !slices.Equal(npis, want) {
+		t.Errorf("got %v, want %v", npis, want)
+	}
+}
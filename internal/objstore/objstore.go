@@ -0,0 +1,369 @@
+// Package objstore reads and writes the objects named by s3:// and gs://
+// URLs with the AWS and Google Cloud SDKs, using the credentials those find
+// the usual way: for S3 the AWS_* variables, ~/.aws profiles or an instance
+// role; for GCS application default credentials. Without any, public
+// objects are read anonymously. $AWS_ENDPOINT_URL_S3 (or $AWS_ENDPOINT_URL)
+// points s3:// URLs at an S3-compatible store instead of AWS.
+package objstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+
+	"github.com/gyeh/npi-rates/internal/httpx"
+	"github.com/gyeh/npi-rates/internal/logx"
+)
+
+// IsObjectURL reports whether rawURL is an s3:// or gs:// object URL.
+func IsObjectURL(rawURL string) bool {
+	return strings.HasPrefix(rawURL, "s3://") || strings.HasPrefix(rawURL, "gs://")
+}
+
+// requesterPays holds the SetRequesterPays settings.
+var requesterPays struct {
+	enabled    bool
+	gcsProject string
+}
+
+// SetRequesterPays makes s3:// and gs:// requests to requester-pays
+// buckets bill the caller, who agrees to pay for the transfer: S3 requests
+// carry x-amz-request-payer, and GCS requests bill gcsProject (which must
+// be set for gs:// URLs then). Without it those buckets refuse access.
+func SetRequesterPays(enabled bool, gcsProject string) {
+	requesterPays.enabled = enabled
+	requesterPays.gcsProject = gcsProject
+}
+
+// Info is an object's metadata.
+type Info struct {
+	Size         int64
+	ETag         string
+	LastModified time.Time
+	generation   int64 // GCS only
+}
+
+// HTTPLastModified formats LastModified as an HTTP date, or "".
+func (o *Info) HTTPLastModified() string {
+	if o.LastModified.IsZero() {
+		return ""
+	}
+	return o.LastModified.UTC().Format(http.TimeFormat)
+}
+
+// parseURL splits an object URL into scheme, bucket and key.
+func parseURL(rawURL string) (scheme, bucket, key string, err error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", "", "", err
+	}
+	key = strings.TrimPrefix(u.Path, "/")
+	if u.Host == "" || key == "" {
+		return "", "", "", fmt.Errorf("%s: want %s://bucket/key", rawURL, u.Scheme)
+	}
+	return u.Scheme, u.Host, key, nil
+}
+
+// Stat returns the metadata of the object at rawURL.
+func Stat(ctx context.Context, rawURL string) (*Info, error) {
+	scheme, bucket, key, err := parseURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if scheme == "s3" {
+		client, err := s3Client(ctx, bucket)
+		if err != nil {
+			return nil, err
+		}
+		out, err := client.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket:       aws.String(bucket),
+			Key:          aws.String(key),
+			RequestPayer: s3Payer(),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &Info{
+			Size:         aws.ToInt64(out.ContentLength),
+			ETag:         aws.ToString(out.ETag),
+			LastModified: aws.ToTime(out.LastModified),
+		}, nil
+	}
+	obj, err := gcsObject(ctx, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	attrs, err := obj.Attrs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Info{
+		Size:         attrs.Size,
+		ETag:         strconv.Quote(attrs.Etag),
+		LastModified: attrs.Updated,
+		generation:   attrs.Generation,
+	}, nil
+}
+
+// Size returns the size of the object at an s3:// or gs:// URL.
+func Size(ctx context.Context, rawURL string) (int64, error) {
+	info, err := Stat(ctx, rawURL)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size, nil
+}
+
+// Open opens length bytes (< 0: the rest) of the object described by info
+// from offset, failing if the object has changed since info.
+func Open(ctx context.Context, rawURL string, info *Info, offset, length int64) (io.ReadCloser, error) {
+	scheme, bucket, key, err := parseURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if scheme == "s3" {
+		client, err := s3Client(ctx, bucket)
+		if err != nil {
+			return nil, err
+		}
+		in := &s3.GetObjectInput{
+			Bucket:       aws.String(bucket),
+			Key:          aws.String(key),
+			IfMatch:      aws.String(info.ETag),
+			RequestPayer: s3Payer(),
+		}
+		if offset > 0 || length >= 0 {
+			r := fmt.Sprintf("bytes=%d-", offset)
+			if length >= 0 {
+				r += strconv.FormatInt(offset+length-1, 10)
+			}
+			in.Range = aws.String(r)
+		}
+		out, err := client.GetObject(ctx, in)
+		if err != nil {
+			return nil, err
+		}
+		return out.Body, nil
+	}
+	obj, err := gcsObject(ctx, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	return obj.Generation(info.generation).NewRangeReader(ctx, offset, length)
+}
+
+// Upload writes r to the object at an s3:// or gs:// URL with the given
+// content type, replacing any object there. Objects appear whole or not at
+// all, so a failed upload leaves the old one in place.
+func Upload(ctx context.Context, rawURL string, r io.Reader, contentType string) error {
+	scheme, bucket, key, err := parseURL(rawURL)
+	if err != nil {
+		return err
+	}
+	if scheme == "s3" {
+		client, err := s3Client(ctx, bucket)
+		if err != nil {
+			return err
+		}
+		_, err = manager.NewUploader(client).Upload(ctx, &s3.PutObjectInput{
+			Bucket:       aws.String(bucket),
+			Key:          aws.String(key),
+			Body:         r,
+			ContentType:  aws.String(contentType),
+			RequestPayer: s3Payer(),
+		})
+		return err
+	}
+	obj, err := gcsObject(ctx, bucket, key)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() // abandons the upload on error
+	w := obj.NewWriter(ctx)
+	w.ContentType = contentType
+	if _, err := io.Copy(w, r); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// StatusCode returns the HTTP status of a failed SDK request in err, or 0
+// if err is not one.
+func StatusCode(err error) int {
+	var re interface{ HTTPStatusCode() int } // the AWS SDK's ResponseError
+	var ge *googleapi.Error
+	switch {
+	case errors.As(err, &re):
+		return re.HTTPStatusCode()
+	case errors.As(err, &ge):
+		return ge.Code
+	}
+	return 0
+}
+
+// Clients are made on first use and shared; S3 needs one per bucket region.
+var clients struct {
+	mu        sync.Mutex
+	aws       *aws.Config
+	endpoint  string                // S3-compatible store, if set
+	s3        map[string]*s3.Client // by region
+	s3Regions map[string]string     // by bucket
+	gcs       *storage.Client
+}
+
+// ResetClients drops the shared clients, so the next request loads
+// credentials and endpoints from the environment again.
+func ResetClients() {
+	c := &clients
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.aws, c.endpoint, c.s3, c.s3Regions = nil, "", nil, nil
+	if c.gcs != nil {
+		c.gcs.Close()
+		c.gcs = nil
+	}
+}
+
+func s3Payer() s3types.RequestPayer {
+	if requesterPays.enabled {
+		return s3types.RequestPayerRequester
+	}
+	return ""
+}
+
+// s3Client returns a client for the region bucket is in. The region is
+// looked up once per bucket, without holding clients.mu, so a slow lookup
+// doesn't hold up files in other buckets.
+func s3Client(ctx context.Context, bucket string) (*s3.Client, error) {
+	c := &clients
+	c.mu.Lock()
+	if c.aws == nil {
+		// The config outlives the file it is loaded for.
+		cfg, err := awsconfig.LoadDefaultConfig(context.WithoutCancel(ctx))
+		if err != nil {
+			c.mu.Unlock()
+			return nil, fmt.Errorf("loading AWS config: %w", err)
+		}
+		if cfg.Region == "" {
+			cfg.Region = "us-east-1"
+		}
+		if cfg.Credentials == nil {
+			logx.Debugf(ctx, "no AWS credentials; reading s3:// URLs anonymously")
+			cfg.Credentials = aws.AnonymousCredentials{}
+		} else if _, err := cfg.Credentials.Retrieve(ctx); err != nil {
+			logx.Debugf(ctx, "no AWS credentials (%v); reading s3:// URLs anonymously", err)
+			cfg.Credentials = aws.AnonymousCredentials{}
+		}
+		c.endpoint = os.Getenv("AWS_ENDPOINT_URL_S3")
+		if c.endpoint == "" {
+			c.endpoint = os.Getenv("AWS_ENDPOINT_URL")
+		}
+		c.aws = &cfg
+		c.s3 = make(map[string]*s3.Client)
+		c.s3Regions = make(map[string]string)
+	}
+	region, known := c.s3Regions[bucket]
+	if !known {
+		region = c.aws.Region
+	}
+	lookup := !known && c.endpoint == ""
+	c.mu.Unlock()
+
+	if lookup {
+		r, err := manager.GetBucketRegion(ctx, s3RegionClient(region), bucket)
+		if err != nil {
+			logx.Debugf(ctx, "s3://%s: region lookup failed, using %s: %v", bucket, region, err)
+		} else {
+			region = r
+		}
+		c.mu.Lock()
+		c.s3Regions[bucket] = region
+		c.mu.Unlock()
+	}
+	return s3RegionClient(region), nil
+}
+
+// s3RegionClient returns the shared client for region. clients.aws must be
+// set.
+func s3RegionClient(region string) *s3.Client {
+	c := &clients
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	client := c.s3[region]
+	if client == nil {
+		client = s3.NewFromConfig(*c.aws, func(o *s3.Options) {
+			o.Region = region
+			o.HTTPClient = httpx.Streaming // callers bound requests with ctx
+			if c.endpoint != "" {
+				o.BaseEndpoint = aws.String(c.endpoint)
+				o.UsePathStyle = true
+			}
+		})
+		c.s3[region] = client
+	}
+	return client
+}
+
+// gcsObject returns a handle for the object, billing the requester-pays
+// project if set.
+func gcsObject(ctx context.Context, bucket, key string) (*storage.ObjectHandle, error) {
+	c := &clients
+	c.mu.Lock()
+	if c.gcs == nil {
+		// The client outlives the file it is made for. Its requests, and
+		// those for tokens, go through httpx.Streaming's transport.
+		bg := context.WithValue(context.WithoutCancel(ctx), oauth2.HTTPClient, httpx.Streaming)
+		hc := httpx.Streaming
+		if os.Getenv("STORAGE_EMULATOR_HOST") == "" {
+			// Without credentials, read anonymously; a credentials file
+			// that was named but can't be used is an error.
+			creds, err := google.FindDefaultCredentials(bg, storage.ScopeFullControl)
+			switch {
+			case err != nil && os.Getenv("GOOGLE_APPLICATION_CREDENTIALS") != "":
+				c.mu.Unlock()
+				return nil, fmt.Errorf("loading Google Cloud credentials: %w", err)
+			case err != nil:
+				logx.Debugf(ctx, "no Google Cloud credentials (%v); reading gs:// URLs anonymously", err)
+			default:
+				hc = oauth2.NewClient(bg, creds.TokenSource)
+			}
+		}
+		client, err := storage.NewClient(bg, option.WithHTTPClient(hc))
+		if err != nil {
+			c.mu.Unlock()
+			return nil, fmt.Errorf("creating GCS client: %w", err)
+		}
+		c.gcs = client
+	}
+	client := c.gcs
+	c.mu.Unlock()
+
+	b := client.Bucket(bucket)
+	if requesterPays.enabled {
+		if requesterPays.gcsProject == "" {
+			return nil, fmt.Errorf("gs://%s: requester pays needs a project to bill (--gcs-project)", bucket)
+		}
+		b = b.UserProject(requesterPays.gcsProject)
+	}
+	return b.Object(key), nil
+}
@@ -0,0 +1,228 @@
+package objstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestS3(t *testing.T) {
+	body := "0123456789 object body"
+	modified := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+	var payer string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payer = r.Header.Get("x-amz-request-payer")
+		switch r.URL.Path {
+		case "/mrfs/2026-02/in-network.json":
+			w.Header().Set("ETag", `"abc"`)
+			http.ServeContent(w, r, "", modified, strings.NewReader(body))
+		case "/private/f.json":
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	t.Setenv("AWS_ENDPOINT_URL", server.URL)
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	ResetClients()
+	defer ResetClients()
+	SetRequesterPays(true, "")
+	defer SetRequesterPays(false, "")
+
+	url := "s3://mrfs/2026-02/in-network.json"
+	info, err := Stat(context.Background(), url)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if info.Size != int64(len(body)) || info.ETag != `"abc"` || info.HTTPLastModified() != "Sun, 01 Feb 2026 00:00:00 GMT" {
+		t.Errorf("unexpected info %+v", info)
+	}
+	if payer != "requester" {
+		t.Errorf("expected x-amz-request-payer: requester, got %q", payer)
+	}
+
+	r, err := Open(context.Background(), url, info, 10, 5)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	data, _ := io.ReadAll(r)
+	r.Close()
+	if string(data) != body[10:15] {
+		t.Errorf("read %q, want %q", data, body[10:15])
+	}
+
+	_, err = Stat(context.Background(), "s3://private/f.json")
+	if code := StatusCode(err); code != http.StatusForbidden {
+		t.Errorf("expected HTTP 403, got %d (%v)", code, err)
+	}
+	if _, err := Stat(context.Background(), "s3://mrfs"); err == nil {
+		t.Error("expected an error for a URL without a key")
+	}
+}
+
+func TestUpload_S3(t *testing.T) {
+	var gotPath, gotType, gotBody string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		gotPath, gotType, gotBody = r.URL.Path, r.Header.Get("Content-Type"), string(data)
+		w.Header().Set("ETag", `"def"`)
+	}))
+	defer server.Close()
+
+	t.Setenv("AWS_ENDPOINT_URL", server.URL)
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	ResetClients()
+	defer ResetClients()
+
+	err := Upload(context.Background(), "s3://results/2026-02/rates.csv", strings.NewReader("npi,tin\n"), "text/csv")
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	if gotPath != "/results/2026-02/rates.csv" || gotType != "text/csv" || gotBody != "npi,tin\n" {
+		t.Errorf("got PUT %s (%s) %q", gotPath, gotType, gotBody)
+	}
+}
+
+// gcsEmulator serves the parts of the Cloud Storage JSON and XML APIs that
+// gs:// URLs use, for a client pointed at it with STORAGE_EMULATOR_HOST.
+type gcsEmulator struct {
+	objects  map[string]string // "bucket/key" -> body
+	modified time.Time
+	uploads  map[string]string // "bucket/key" -> content type
+	billed   []string          // the project billed for each request
+}
+
+func (g *gcsEmulator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	project := r.URL.Query().Get("userProject") // the JSON API
+	if project == "" {
+		project = r.Header.Get("X-Goog-User-Project") // the XML API
+	}
+	g.billed = append(g.billed, project)
+	if strings.HasPrefix(r.URL.Path, "/private/") || strings.HasPrefix(r.URL.Path, "/storage/v1/b/private/") {
+		http.Error(w, `{"error": {"code": 403, "message": "denied"}}`, http.StatusForbidden)
+		return
+	}
+	if r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/upload/storage/v1/b/") {
+		bucket := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/upload/storage/v1/b/"), "/o")
+		_, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		mr := multipart.NewReader(r.Body, params["boundary"])
+		var meta struct{ Name, ContentType string }
+		part, err := mr.NextPart()
+		if err == nil {
+			err = json.NewDecoder(part).Decode(&meta)
+		}
+		if err == nil {
+			part, err = mr.NextPart()
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data, _ := io.ReadAll(part)
+		g.objects[bucket+"/"+meta.Name] = string(data)
+		g.uploads[bucket+"/"+meta.Name] = meta.ContentType
+		fmt.Fprintf(w, `{"bucket": %q, "name": %q, "size": "%d"}`, bucket, meta.Name, len(data))
+		return
+	}
+	media := r.URL.Query().Get("alt") == "media"
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	if rest, ok := strings.CutPrefix(r.URL.Path, "/storage/v1/b/"); ok {
+		bucket, key, _ := strings.Cut(rest, "/o/")
+		path = bucket + "/" + key
+	} else {
+		media = true // an XML API read
+	}
+	body, ok := g.objects[path]
+	if !ok {
+		http.Error(w, `{"error": {"code": 404, "message": "not found"}}`, http.StatusNotFound)
+		return
+	}
+	if !media {
+		bucket, key, _ := strings.Cut(path, "/")
+		fmt.Fprintf(w, `{"bucket": %q, "name": %q, "size": "%d", "etag": "CNbE", "generation": "7", "updated": %q}`,
+			bucket, key, len(body), g.modified.Format(time.RFC3339))
+		return
+	}
+	if gen := r.URL.Query().Get("generation"); gen != "7" {
+		http.Error(w, "wrong generation "+gen, http.StatusPreconditionFailed)
+		return
+	}
+	w.Header().Set("X-Goog-Generation", "7")
+	http.ServeContent(w, r, "", g.modified, strings.NewReader(body))
+}
+
+func TestGCS(t *testing.T) {
+	body := "0123456789 object body"
+	g := &gcsEmulator{
+		objects:  map[string]string{"mrfs/2026-02/in-network.json": body},
+		modified: time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC),
+		uploads:  map[string]string{},
+	}
+	server := httptest.NewServer(g)
+	defer server.Close()
+
+	t.Setenv("STORAGE_EMULATOR_HOST", server.URL)
+	ResetClients()
+	defer ResetClients()
+	SetRequesterPays(true, "billing-project")
+	defer SetRequesterPays(false, "")
+
+	url := "gs://mrfs/2026-02/in-network.json"
+	info, err := Stat(context.Background(), url)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if info.Size != int64(len(body)) || info.ETag != `"CNbE"` || info.HTTPLastModified() != "Sun, 01 Feb 2026 00:00:00 GMT" {
+		t.Errorf("unexpected info %+v", info)
+	}
+	r, err := Open(context.Background(), url, info, 10, -1)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	data, _ := io.ReadAll(r)
+	r.Close()
+	if string(data) != body[10:] {
+		t.Errorf("read %q, want %q", data, body[10:])
+	}
+
+	for i, p := range g.billed {
+		if p != "billing-project" {
+			t.Errorf("request %d billed %q, want billing-project", i, p)
+		}
+	}
+
+	_, err = Stat(context.Background(), "gs://private/f.json")
+	if code := StatusCode(err); code != http.StatusForbidden {
+		t.Errorf("expected HTTP 403, got %d (%v)", code, err)
+	}
+
+	err = Upload(context.Background(), "gs://results/2026-02/rates.csv", strings.NewReader("npi,tin\n"), "text/csv")
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	if got := g.objects["results/2026-02/rates.csv"]; got != "npi,tin\n" || g.uploads["results/2026-02/rates.csv"] != "text/csv" {
+		t.Errorf("uploaded %q (%s)", got, g.uploads["results/2026-02/rates.csv"])
+	}
+
+	// Requester pays needs a project to bill.
+	SetRequesterPays(true, "")
+	if _, err := Size(context.Background(), url); err == nil || !strings.Contains(err.Error(), "--gcs-project") {
+		t.Errorf("expected an error without a billing project, got %v", err)
+	}
+}
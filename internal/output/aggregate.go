@@ -0,0 +1,176 @@
+package output
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+
+	"github.com/gyeh/npi-rates/internal/mrf"
+)
+
+// aggregateCSVHeader lists the CSV columns in aggregate mode.
+var aggregateCSVHeader = []string{
+	"npi", "billing_code_type", "billing_code", "billing_code_description",
+	"billing_class", "setting", "unit", "count", "min", "median", "max",
+	"median_approximate",
+}
+
+// AggregateOptions are release controls for aggregates meant to be
+// published.
+type AggregateOptions struct {
+	// MinCount, if above 1, leaves out rows summarizing fewer rates than
+	// this, whose statistics could reveal an individual contract's rate.
+	MinCount int64
+
+	// Round, if positive, rounds each row's min, median and max to the
+	// nearest multiple of it, in the row's unit (e.g. 1 for whole dollars).
+	Round float64
+}
+
+// AggregateSink folds results into one row per NPI, billing code, class,
+// setting and unit with the count, min, median and max of their rates (see
+// mrf.Aggregator), written at Close as json, ndjson or csv. Memory grows
+// with the number of rates, up to a fixed sample per row; a row with more
+// rates has an approximate median, marked median_approximate. Rows are
+// suppressed and rounded as opts say, and search_params records both.
+type AggregateSink struct {
+	kind string
+	path string
+	opts AggregateOptions
+	ctx  context.Context
+	agg  *mrf.Aggregator
+}
+
+// NewAggregateSink returns a sink writing aggregates to path in the given
+// format; kind "" picks it from path's extension as NewFileSink does.
+func NewAggregateSink(kind, path string, opts AggregateOptions) (*AggregateSink, error) {
+	if path == "" {
+		return nil, fmt.Errorf("aggregate: missing path")
+	}
+	if opts.MinCount < 0 || opts.Round < 0 || math.IsInf(opts.Round, 0) || math.IsNaN(opts.Round) {
+		return nil, fmt.Errorf("aggregate: invalid minimum count %d or rounding %g", opts.MinCount, opts.Round)
+	}
+	if kind == "" {
+		kind = kindFromPath(path)
+	}
+	switch kind {
+	case "json", "ndjson", "csv":
+	case "jsonl":
+		kind = "ndjson"
+	default:
+		return nil, fmt.Errorf("aggregate output: unsupported format %q (want json, ndjson, or csv)", kind)
+	}
+	return &AggregateSink{kind: kind, path: path, opts: opts}, nil
+}
+
+func (s *AggregateSink) Open(ctx context.Context) error {
+	s.ctx = ctx
+	s.agg = mrf.NewAggregator()
+	return nil
+}
+
+func (s *AggregateSink) WriteBatch(results []mrf.RateResult) error {
+	for i := range results {
+		s.agg.Add(&results[i])
+	}
+	return nil
+}
+
+func (s *AggregateSink) Close(params mrf.SearchParams) error {
+	aggs := s.release(s.agg.Aggregates())
+	params.AggregateMinCount, params.AggregateRound = s.opts.MinCount, s.opts.Round
+	params.SuppressedAggregates = int64(s.agg.Len() - len(aggs))
+	if s.kind == "json" {
+		data, err := json.MarshalIndent(mrf.AggregateOutput{SearchParams: params, Aggregates: aggs}, "", "  ")
+		if err != nil {
+			return fmt.Errorf("marshaling output: %w", err)
+		}
+		if s.path == "-" {
+			_, err = os.Stdout.Write(data)
+			fmt.Fprintln(os.Stdout)
+			return err
+		}
+		return writeFileAtomic(s.ctx, s.path, data)
+	}
+
+	out, err := openOutput(s.ctx, s.path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriterSize(out, 1<<20)
+	if s.kind == "ndjson" {
+		enc := json.NewEncoder(w)
+		for i := range aggs {
+			if err := enc.Encode(&aggs[i]); err != nil {
+				return fmt.Errorf("writing %s: %w", s.path, err)
+			}
+		}
+	} else {
+		cw := csv.NewWriter(w)
+		cw.Write(aggregateCSVHeader)
+		for _, a := range aggs {
+			cw.Write([]string{
+				strconv.FormatInt(a.NPI, 10),
+				a.BillingCodeType,
+				a.BillingCode,
+				a.BillingCodeDescription,
+				a.BillingClass,
+				a.Setting,
+				a.Unit,
+				strconv.FormatInt(a.Count, 10),
+				strconv.FormatFloat(a.Min, 'f', -1, 64),
+				strconv.FormatFloat(a.Median, 'f', -1, 64),
+				strconv.FormatFloat(a.Max, 'f', -1, 64),
+				strconv.FormatBool(a.MedianApproximate),
+			})
+		}
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return fmt.Errorf("writing %s: %w", s.path, err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("writing %s: %w", s.path, err)
+	}
+	if err := out.Commit(); err != nil {
+		return err
+	}
+	if s.kind == "ndjson" && s.path != "-" {
+		data, err := json.MarshalIndent(params, "", "  ")
+		if err != nil {
+			return fmt.Errorf("marshaling search params: %w", err)
+		}
+		return writeFileAtomic(s.ctx, sidecarPath(s.path, ".params.json"), data)
+	}
+	return nil
+}
+
+// release drops the rows in aggs with fewer rates than s.opts.MinCount and
+// rounds the rest.
+func (s *AggregateSink) release(aggs []mrf.RateAggregate) []mrf.RateAggregate {
+	kept := aggs[:0]
+	for _, a := range aggs {
+		if a.Count < s.opts.MinCount {
+			continue
+		}
+		if s.opts.Round > 0 {
+			a.Min = roundTo(a.Min, s.opts.Round)
+			a.Median = roundTo(a.Median, s.opts.Round)
+			a.Max = roundTo(a.Max, s.opts.Round)
+		}
+		kept = append(kept, a)
+	}
+	return kept
+}
+
+// roundTo rounds v to the nearest multiple of step. The product is rounded
+// again at a billionth so that a step like 0.1 yields 120.3, not
+// 120.30000000000001.
+func roundTo(v, step float64) float64 {
+	return math.Round(math.Round(v/step)*step*1e9) / 1e9
+}
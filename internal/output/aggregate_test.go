@@ -0,0 +1,172 @@
+package output
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/gyeh/npi-rates/internal/mrf"
+)
+
+// writeAggregates runs results through an AggregateSink writing path.
+func writeAggregates(t *testing.T, kind, path string, opts AggregateOptions) {
+	t.Helper()
+	s, err := NewAggregateSink(kind, path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Open(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	rate := func(npi int64, v float64) mrf.RateResult {
+		return mrf.RateResult{NPI: npi, BillingCodeType: "CPT", BillingCode: "99213", BillingClass: "professional",
+			Setting: "outpatient", NegotiatedRate: v, NegotiatedType: "negotiated"}
+	}
+	if err := s.WriteBatch([]mrf.RateResult{rate(2, 100), rate(1, 50)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WriteBatch([]mrf.RateResult{rate(2, 300), rate(2, 120)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(mrf.SearchParams{RunID: "r1"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAggregateSink_JSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.json")
+	writeAggregates(t, "", path, AggregateOptions{})
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got mrf.AggregateOutput
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, data)
+	}
+	if got.SearchParams.RunID != "r1" || len(got.Aggregates) != 2 {
+		t.Fatalf("unexpected output %+v", got)
+	}
+	a := got.Aggregates[1]
+	if a.NPI != 2 || a.Count != 3 || a.Min != 100 || a.Median != 120 || a.Max != 300 || a.MedianApproximate {
+		t.Errorf("unexpected aggregate for NPI 2: %+v", a)
+	}
+}
+
+func TestAggregateSink_NDJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.jsonl")
+	writeAggregates(t, "", path, AggregateOptions{})
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var npis []int64
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var a mrf.RateAggregate
+		if err := json.Unmarshal(sc.Bytes(), &a); err != nil {
+			t.Fatalf("invalid line %q: %v", sc.Text(), err)
+		}
+		npis = append(npis, a.NPI)
+	}
+	if !reflect.DeepEqual(npis, []int64{1, 2}) {
+		t.Errorf("expected rows for NPIs 1 and 2, got %v", npis)
+	}
+
+	data, err := os.ReadFile(path + ".params.json")
+	if err != nil {
+		t.Fatalf("expected a params sidecar: %v", err)
+	}
+	var params mrf.SearchParams
+	if err := json.Unmarshal(data, &params); err != nil || params.RunID != "r1" {
+		t.Errorf("unexpected sidecar %s (%v)", data, err)
+	}
+}
+
+func TestAggregateSink_CSV(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.csv")
+	writeAggregates(t, "", path, AggregateOptions{})
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		aggregateCSVHeader,
+		{"1", "CPT", "99213", "", "professional", "outpatient", "USD", "1", "50", "50", "50", "false"},
+		{"2", "CPT", "99213", "", "professional", "outpatient", "USD", "3", "100", "120", "300", "false"},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("got rows\n%q\nwant\n%q", rows, want)
+	}
+}
+
+// TestAggregateSink_Release verifies that rows from too few rates are left
+// out and counted, and the rest rounded.
+func TestAggregateSink_Release(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.json")
+	writeAggregates(t, "", path, AggregateOptions{MinCount: 2, Round: 25})
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got mrf.AggregateOutput
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, data)
+	}
+	p := got.SearchParams
+	if p.SuppressedAggregates != 1 || p.AggregateMinCount != 2 || p.AggregateRound != 25 {
+		t.Errorf("unexpected search params %+v", p)
+	}
+	if len(got.Aggregates) != 1 {
+		t.Fatalf("expected only NPI 2's row, got %+v", got.Aggregates)
+	}
+	a := got.Aggregates[0]
+	if a.NPI != 2 || a.Count != 3 || a.Min != 100 || a.Median != 125 || a.Max != 300 {
+		t.Errorf("unexpected aggregate %+v", a)
+	}
+}
+
+func TestRoundTo(t *testing.T) {
+	tests := []struct{ v, step, want float64 }{
+		{120.34, 0.1, 120.3},
+		{120.35, 1, 120},
+		{137.5, 5, 140},
+		{0.004, 0.01, 0},
+		{99.99, 0.01, 99.99},
+	}
+	for _, tt := range tests {
+		if got := roundTo(tt.v, tt.step); got != tt.want {
+			t.Errorf("roundTo(%g, %g) = %v, want %v", tt.v, tt.step, got, tt.want)
+		}
+	}
+}
+
+func TestNewAggregateSink_Errors(t *testing.T) {
+	if _, err := NewAggregateSink("json", "", AggregateOptions{}); err == nil {
+		t.Error("expected an error for a missing path")
+	}
+	if _, err := NewAggregateSink("parquet", "out.parquet", AggregateOptions{}); err == nil {
+		t.Error("expected an error for an unsupported format")
+	}
+	if _, err := NewAggregateSink("json", "out.json", AggregateOptions{Round: -1}); err == nil {
+		t.Error("expected an error for negative rounding")
+	}
+	if s, err := NewAggregateSink("jsonl", "out", AggregateOptions{}); err != nil || s.kind != "ndjson" {
+		t.Errorf("expected jsonl to mean ndjson, got %+v, %v", s, err)
+	}
+}
@@ -0,0 +1,169 @@
+package output
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// partialMarker separates the destination name from the writer's PID in
+// temp output names: ".results.json.partial-1234".
+const partialMarker = ".partial-"
+
+// outputFile is a destination that becomes visible only on Commit.
+type outputFile interface {
+	io.Writer
+	Commit() error
+}
+
+// atomicFile writes to a hidden temp file beside the destination and renames
+// it into place on Commit, so a crash mid-write never leaves a truncated
+// output at the destination path. Renames within a directory are atomic on
+// POSIX filesystems.
+//
+// A remote destination (see IsRemote) is written to a temp file in the
+// system temp dir instead (see UploadTmpDir) and delivered on Commit; each
+// deliverer only shows the file once it is complete.
+type atomicFile struct {
+	*os.File
+	ctx  context.Context // bounds the upload
+	path string
+}
+
+// UploadTmpDir is where remote outputs are written before being delivered, and where RemoveOrphans finds those of crashed runs.
+func UploadTmpDir() string { return os.TempDir() }
+
+func createAtomic(ctx context.Context, path string) (*atomicFile, error) {
+	dir, base := filepath.Split(path)
+	if IsRemote(path) {
+		dir, base = UploadTmpDir(), remoteName(path)
+	}
+	tmp := filepath.Join(dir, "."+base+partialMarker+strconv.Itoa(os.Getpid()))
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("creating %s: %w", Redact(path), err)
+	}
+	return &atomicFile{File: f, ctx: ctx, path: path}, nil
+}
+
+// Commit syncs the temp file, renames it over the destination, and syncs the
+// directory so the rename itself survives a power loss.
+func (f *atomicFile) Commit() error {
+	if err := f.Sync(); err != nil {
+		f.abort()
+		return fmt.Errorf("syncing %s: %w", f.path, err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("closing %s: %w", f.path, err)
+	}
+	if IsRemote(f.path) {
+		return f.upload()
+	}
+	if err := os.Rename(f.Name(), f.path); err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("renaming into %s: %w", f.path, err)
+	}
+	if d, err := os.Open(filepath.Dir(f.path)); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// upload delivers the closed temp file to its remote path and removes it.
+func (f *atomicFile) upload() error {
+	defer os.Remove(f.Name())
+	if err := deliver(f.ctx, f.path, f.Name()); err != nil {
+		return fmt.Errorf("delivering %s: %w", Redact(f.path), err)
+	}
+	return nil
+}
+
+// contentType returns the MIME type of an output, by its format.
+func contentType(path string) string {
+	if strings.HasSuffix(remotePath(path), ".params.json") {
+		return "application/json"
+	}
+	switch kindFromPath(path) {
+	case "ndjson":
+		return "application/x-ndjson"
+	case "csv":
+		return "text/csv; charset=utf-8"
+	case "sqlite":
+		return "application/vnd.sqlite3"
+	default:
+		return "application/json"
+	}
+}
+
+func (f *atomicFile) abort() {
+	f.Close()
+	os.Remove(f.Name())
+}
+
+// stdoutFile writes straight to stdout; there is nothing to commit.
+type stdoutFile struct{}
+
+func (stdoutFile) Write(p []byte) (int, error) { return os.Stdout.Write(p) }
+func (stdoutFile) Commit() error               { return nil }
+
+// openOutput opens path for an atomic write, or stdout for "-".
+func openOutput(ctx context.Context, path string) (outputFile, error) {
+	if path == "-" {
+		return stdoutFile{}, nil
+	}
+	return createAtomic(ctx, path)
+}
+
+// writeFileAtomic is os.WriteFile with the same crash safety as openOutput.
+func writeFileAtomic(ctx context.Context, path string, data []byte) error {
+	f, err := createAtomic(ctx, path)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.abort()
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	return f.Commit()
+}
+
+// RemoveOrphans deletes temp outputs in dir left behind by runs that
+// crashed before committing. Temp files whose writer is still running are
+// kept. It returns the number of files removed.
+func RemoveOrphans(dir string) int {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0
+	}
+	removed := 0
+	for _, e := range entries {
+		name := e.Name()
+		i := strings.LastIndex(name, partialMarker)
+		if !strings.HasPrefix(name, ".") || i < 0 || e.IsDir() {
+			continue
+		}
+		pid, err := strconv.Atoi(name[i+len(partialMarker):])
+		if err != nil || processAlive(pid) {
+			continue
+		}
+		if os.Remove(filepath.Join(dir, name)) == nil {
+			removed++
+		}
+	}
+	return removed
+}
+
+func processAlive(pid int) bool {
+	if pid == os.Getpid() {
+		return true
+	}
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
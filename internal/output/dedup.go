@@ -0,0 +1,148 @@
+package output
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+
+	"github.com/gyeh/npi-rates/internal/mrf"
+)
+
+// DedupSink drops results identical to one already written before passing
+// batches on to the wrapped sink. Identity is the whole result unless key
+// names the fields to compare; a result's Provenance never counts, so the
+// same rate listed twice in a file is still a duplicate. Only a 16-byte
+// hash of each distinct result is kept, so memory grows with the number of
+// distinct results.
+type DedupSink struct {
+	next    Sink
+	fields  []int // RateResult field indexes compared
+	seen    map[[16]byte]struct{}
+	buf     []byte // reused by hash
+	dropped int64
+}
+
+// NewDedupSink wraps next. key is a comma-separated list of RateResult JSON
+// field names (e.g. "npi,tin,billing_code,negotiated_rate,source_file"), or
+// "" to compare whole results.
+func NewDedupSink(next Sink, key string) (*DedupSink, error) {
+	fields, err := dedupFields(key)
+	if err != nil {
+		return nil, err
+	}
+	return &DedupSink{next: next, fields: fields}, nil
+}
+
+// dedupFields resolves key's field names to RateResult field indexes; ""
+// is every field but Provenance.
+func dedupFields(key string) ([]int, error) {
+	t := reflect.TypeOf(mrf.RateResult{})
+	if key == "" {
+		var fields []int
+		for i := 0; i < t.NumField(); i++ {
+			if t.Field(i).Name != "Provenance" {
+				fields = append(fields, i)
+			}
+		}
+		return fields, nil
+	}
+	byName := make(map[string]int, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		byName[name] = i
+	}
+	var fields []int
+	for _, name := range strings.Split(key, ",") {
+		name = strings.TrimSpace(name)
+		i, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("dedup key: unknown field %q", name)
+		}
+		fields = append(fields, i)
+	}
+	return fields, nil
+}
+
+func (s *DedupSink) Open(ctx context.Context) error {
+	s.seen = make(map[[16]byte]struct{})
+	s.dropped = 0
+	return s.next.Open(ctx)
+}
+
+func (s *DedupSink) WriteBatch(results []mrf.RateResult) error {
+	kept := make([]mrf.RateResult, 0, len(results))
+	for i := range results {
+		h := s.hash(&results[i])
+		if _, dup := s.seen[h]; dup {
+			s.dropped++
+			continue
+		}
+		s.seen[h] = struct{}{}
+		kept = append(kept, results[i])
+	}
+	if len(kept) == 0 {
+		return nil
+	}
+	return s.next.WriteBatch(kept)
+}
+
+// Close records the number of duplicates dropped in params.
+func (s *DedupSink) Close(params mrf.SearchParams) error {
+	params.DuplicateRates += s.dropped
+	s.seen = nil
+	return s.next.Close(params)
+}
+
+// Dropped returns the number of duplicate results dropped so far.
+func (s *DedupSink) Dropped() int64 { return s.dropped }
+
+// hash identifies r by the compared fields.
+func (s *DedupSink) hash(r *mrf.RateResult) [16]byte {
+	v := reflect.ValueOf(r).Elem()
+	s.buf = s.buf[:0]
+	for _, f := range s.fields {
+		s.buf = appendKey(s.buf, v.Field(f))
+	}
+	sum := sha256.Sum256(s.buf)
+	return [16]byte(sum[:16])
+}
+
+// appendKey appends a binary encoding of v to b. Strings and slices are
+// length-prefixed, so a sequence of values encodes unambiguously.
+func appendKey(b []byte, v reflect.Value) []byte {
+	switch v.Kind() {
+	case reflect.String:
+		b = binary.AppendUvarint(b, uint64(v.Len()))
+		return append(b, v.String()...)
+	case reflect.Bool:
+		if v.Bool() {
+			return append(b, 1)
+		}
+		return append(b, 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return binary.AppendVarint(b, v.Int())
+	case reflect.Float32, reflect.Float64:
+		return binary.LittleEndian.AppendUint64(b, math.Float64bits(v.Float()))
+	case reflect.Slice:
+		b = binary.AppendUvarint(b, uint64(v.Len()))
+		for i := 0; i < v.Len(); i++ {
+			b = appendKey(b, v.Index(i))
+		}
+		return b
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			b = appendKey(b, v.Field(i))
+		}
+		return b
+	case reflect.Pointer:
+		if v.IsNil() {
+			return append(b, 0)
+		}
+		return appendKey(append(b, 1), v.Elem())
+	}
+	panic("dedup: unsupported field kind " + v.Kind().String())
+}
@@ -0,0 +1,156 @@
+package output
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/gyeh/npi-rates/internal/mrf"
+)
+
+// memSink keeps what it is given, for tests of sinks that wrap another.
+type memSink struct {
+	opened  bool
+	results []mrf.RateResult
+	params  *mrf.SearchParams
+}
+
+func (m *memSink) Open(context.Context) error { m.opened = true; return nil }
+
+func (m *memSink) WriteBatch(results []mrf.RateResult) error {
+	m.results = append(m.results, results...)
+	return nil
+}
+
+func (m *memSink) Close(params mrf.SearchParams) error {
+	m.params = &params
+	return nil
+}
+
+func TestDedupSink(t *testing.T) {
+	tin := mrf.TIN{Type: "ein", Value: "16-0960964"}
+	a := mrf.RateResult{SourceFile: "a.json.gz", NPI: 1316924913, TIN: tin, BillingCode: "99213",
+		NegotiatedRate: 125.5, ServiceCode: []string{"11"}}
+	sameRate := a
+	sameRate.SourceFile = "b.json.gz"
+	withProvenance := a
+	withProvenance.Provenance = &mrf.Provenance{Element: 7}
+	nan := a
+	nan.NegotiatedRate = math.NaN()
+
+	tests := []struct {
+		name    string
+		key     string
+		batches [][]mrf.RateResult
+		kept    int
+	}{
+		{"whole result", "", [][]mrf.RateResult{{a, a}, {a, sameRate}}, 2},
+		{"provenance ignored", "", [][]mrf.RateResult{{a}, {withProvenance}}, 1},
+		{"NaN rate", "", [][]mrf.RateResult{{nan, a}}, 2},
+		{"key fields", "npi, billing_code,negotiated_rate", [][]mrf.RateResult{{a, sameRate}}, 1},
+	}
+	for _, tt := range tests {
+		next := &memSink{}
+		s, err := NewDedupSink(next, tt.key)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if err := s.Open(context.Background()); err != nil || !next.opened {
+			t.Fatalf("%s: Open: %v", tt.name, err)
+		}
+		total := 0
+		for _, b := range tt.batches {
+			total += len(b)
+			if err := s.WriteBatch(b); err != nil {
+				t.Fatalf("%s: WriteBatch: %v", tt.name, err)
+			}
+		}
+		if err := s.Close(mrf.SearchParams{DuplicateRates: 1}); err != nil {
+			t.Fatalf("%s: Close: %v", tt.name, err)
+		}
+		dropped := int64(total - tt.kept)
+		if len(next.results) != tt.kept || s.Dropped() != dropped {
+			t.Errorf("%s: kept %d and dropped %d, want %d and %d", tt.name, len(next.results), s.Dropped(), tt.kept, dropped)
+		}
+		if next.params.DuplicateRates != 1+dropped {
+			t.Errorf("%s: duplicate_rates %d, want %d added to 1", tt.name, next.params.DuplicateRates, dropped)
+		}
+	}
+}
+
+// TestDedupSink_EveryField verifies that a difference in any field but
+// Provenance makes a result distinct, so that no field is skipped by the
+// hash, and that no field has a kind appendKey can't encode.
+func TestDedupSink_EveryField(t *testing.T) {
+	s, err := NewDedupSink(&memSink{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[[16]byte]string{s.hash(&mrf.RateResult{}): "zero"}
+	typ := reflect.TypeOf(mrf.RateResult{})
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		if f.Name == "Provenance" {
+			continue
+		}
+		var r mrf.RateResult
+		v := reflect.ValueOf(&r).Elem().Field(i)
+		switch v.Kind() {
+		case reflect.String:
+			v.SetString("x")
+		case reflect.Int64:
+			v.SetInt(1)
+		case reflect.Float64:
+			v.SetFloat(1)
+		case reflect.Bool:
+			v.SetBool(true)
+		case reflect.Struct:
+			v.Field(0).SetString("x")
+		case reflect.Slice:
+			v.Set(reflect.MakeSlice(f.Type, 1, 1))
+		default:
+			t.Fatalf("field %s: kind %s not covered by this test", f.Name, v.Kind())
+		}
+		h := s.hash(&r)
+		if other, dup := seen[h]; dup {
+			t.Errorf("field %s hashes the same as %s", f.Name, other)
+		}
+		seen[h] = f.Name
+	}
+}
+
+// TestAppendKey_Unambiguous verifies that values whose concatenation is
+// the same still encode differently.
+func TestAppendKey_Unambiguous(t *testing.T) {
+	enc := func(vals ...any) string {
+		var b []byte
+		for _, v := range vals {
+			b = appendKey(b, reflect.ValueOf(v))
+		}
+		return string(b)
+	}
+	if enc("ab", "c") == enc("a", "bc") {
+		t.Error(`"ab","c" and "a","bc" encode the same`)
+	}
+	if enc([]string{"a", "b"}, []string{}) == enc([]string{"a"}, []string{"b"}) {
+		t.Error("slices split differently encode the same")
+	}
+}
+
+func TestNewDedupSink_UnknownField(t *testing.T) {
+	if _, err := NewDedupSink(&memSink{}, "npi,rate"); err == nil {
+		t.Error("expected an error for an unknown field")
+	}
+}
+
+func BenchmarkDedupSink(b *testing.B) {
+	s, _ := NewDedupSink(&memSink{}, "")
+	s.Open(context.Background())
+	r := mrf.RateResult{SourceFile: "a.json.gz", NPI: 1316924913, TIN: mrf.TIN{Type: "ein", Value: "16-0960964"},
+		BillingCodeType: "CPT", BillingCode: "99213", NegotiatedRate: 125.5, ServiceCode: []string{"11", "22"}}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		s.hash(&r)
+	}
+}
@@ -0,0 +1,205 @@
+package output
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gyeh/npi-rates/internal/httpx"
+	"github.com/gyeh/npi-rates/internal/objstore"
+)
+
+// DeliverHeadersEnv names the environment variable holding extra headers
+// for HTTP PUT delivery, one "Name: value" per line, so a token need not
+// appear on a command line.
+const DeliverHeadersEnv = "NPI_RATES_DELIVER_HEADERS"
+
+// SFTPPasswordEnv names the environment variable holding the SFTP password,
+// used when the output URL has none.
+const SFTPPasswordEnv = "NPI_RATES_SFTP_PASSWORD"
+
+// deliverer copies a finished output, r of size bytes, to dest. r can be
+// rewound to retry.
+type deliverer func(ctx context.Context, dest string, r io.ReadSeeker, size int64, contentType string) error
+
+// deliverers maps the schemes outputs may be given in to how they are
+// delivered. Anything else is a local path.
+var deliverers = map[string]deliverer{
+	"s3":    deliverObject,
+	"gs":    deliverObject,
+	"sftp":  deliverSFTP,
+	"http":  deliverHTTP,
+	"https": deliverHTTP,
+}
+
+// DeliveryOptions configures SFTP and HTTP PUT delivery.
+type DeliveryOptions struct {
+	// Headers are added to every HTTP PUT, e.g. an Authorization token.
+	Headers http.Header
+	// SFTPKey is a private key file to authenticate with. When empty,
+	// ssh-agent's keys and unencrypted ~/.ssh/id_* keys are tried.
+	SFTPKey string
+	// KnownHosts is the file SFTP host keys are checked against (default
+	// ~/.ssh/known_hosts). Unknown hosts are refused.
+	KnownHosts string
+}
+
+var delivery DeliveryOptions
+
+// SetDelivery configures delivery to sftp:// and http(s):// outputs.
+func SetDelivery(o DeliveryOptions) {
+	delivery = o
+}
+
+// EnvDeliverHeaderLines returns the lines of DeliverHeadersEnv, or nil if
+// unset.
+func EnvDeliverHeaderLines() []string {
+	v := os.Getenv(DeliverHeadersEnv)
+	if v == "" {
+		return nil
+	}
+	return strings.Split(v, "\n")
+}
+
+// IsRemote reports whether path is a URL the output is delivered to on
+// Commit (s3://, gs://, sftp://, http:// or https://) rather than a local
+// file.
+func IsRemote(path string) bool {
+	scheme, _, ok := strings.Cut(path, "://")
+	_, known := deliverers[strings.ToLower(scheme)]
+	return ok && known
+}
+
+// remotePath returns path without the query string a remote output URL
+// may carry, such as a pre-signed URL's signature.
+func remotePath(path string) string {
+	if IsRemote(path) {
+		path, _, _ = strings.Cut(path, "?")
+	}
+	return path
+}
+
+// remoteName returns the last path element of a remote output, without a
+// query string.
+func remoteName(path string) string {
+	path = remotePath(path)
+	return path[strings.LastIndex(path, "/")+1:]
+}
+
+// sidecarPath returns the path of the file written beside path with suffix,
+// e.g. its ".params.json". A URL's query string, such as a signature, is
+// kept after the suffix.
+func sidecarPath(path, suffix string) string {
+	if IsRemote(path) {
+		if base, query, ok := strings.Cut(path, "?"); ok {
+			return base + suffix + "?" + query
+		}
+	}
+	return path + suffix
+}
+
+// Redact returns path, or a "kind:path" sink spec, with any password in it
+// replaced, for messages.
+func Redact(path string) string {
+	if kind, rest, ok := strings.Cut(path, ":"); ok && !IsRemote(path) && IsRemote(rest) {
+		return kind + ":" + Redact(rest)
+	}
+	if !IsRemote(path) {
+		return path
+	}
+	u, err := url.Parse(path)
+	if err != nil {
+		return path
+	}
+	return u.Redacted()
+}
+
+// urlPattern matches the URLs RedactText redacts.
+var urlPattern = regexp.MustCompile(`[a-zA-Z][a-zA-Z0-9+.-]*://[^\s"'<>]+`)
+
+// RedactText returns s, such as an error message, with the password in any
+// URL in it replaced.
+func RedactText(s string) string {
+	return urlPattern.ReplaceAllStringFunc(s, Redact)
+}
+
+// CheckRemote checks, before a search starts, that a remote output can be
+// delivered: for SFTP, that the server accepts the connection and the
+// target directory exists, so a long search does not end in a login
+// failure. Other schemes are not checked.
+func CheckRemote(ctx context.Context, path string) error {
+	if !strings.HasPrefix(strings.ToLower(path), "sftp://") {
+		return nil
+	}
+	if err := checkSFTP(ctx, path); err != nil {
+		return fmt.Errorf("%s: %w", Redact(path), err)
+	}
+	return nil
+}
+
+// deliver sends the closed temp file tmp to dest.
+func deliver(ctx context.Context, dest, tmp string) error {
+	in, err := os.Open(tmp)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	scheme, _, _ := strings.Cut(dest, "://")
+	return deliverers[strings.ToLower(scheme)](ctx, dest, in, info.Size(), contentType(dest))
+}
+
+func deliverObject(ctx context.Context, dest string, r io.ReadSeeker, _ int64, contentType string) error {
+	return objstore.Upload(ctx, dest, r, contentType)
+}
+
+// httpAttempts is how many times an HTTP PUT is tried before giving up.
+const httpAttempts = 3
+
+// deliverHTTP PUTs r to dest with the configured headers. A user and
+// password in the URL are sent as basic auth. Network errors, 429 and 5xx
+// responses are retried.
+func deliverHTTP(ctx context.Context, dest string, r io.ReadSeeker, size int64, contentType string) error {
+	for attempt := 1; ; attempt++ {
+		if _, err := r.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPut, dest, io.NopCloser(r))
+		if err != nil {
+			return err
+		}
+		req.ContentLength = size
+		req.Header.Set("Content-Type", contentType)
+		for name, vals := range delivery.Headers {
+			req.Header[name] = vals
+		}
+		retry := true
+		resp, err := httpx.Streaming.Do(req)
+		if err == nil {
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+			resp.Body.Close()
+			if resp.StatusCode/100 == 2 {
+				return nil
+			}
+			err = fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+			retry = resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		}
+		if !retry || attempt == httpAttempts {
+			return err
+		}
+		select {
+		case <-time.After(time.Duration(attempt) * 2 * time.Second):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package output
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+
+	"github.com/gyeh/npi-rates/internal/mrf"
+)
+
+// ItemHookProcess runs a user command and writes every matching in_network
+// item to its stdin as NDJSON, one {"source_file": ..., "item": {...}} object
+// per line, for custom extractions without changing the parser. The command's
+// stdout and stderr go to our stderr so they can't corrupt results written
+// to stdout. A slow command slows parsing down rather than losing items.
+type ItemHookProcess struct {
+	command string
+	cmd     *exec.Cmd
+	in      io.WriteCloser
+	waited  bool // Close or Kill has reaped the command
+
+	mu  sync.Mutex
+	w   *bufio.Writer
+	err error // first write error; later items are dropped
+}
+
+type hookLine struct {
+	SourceFile string             `json:"source_file"`
+	Item       *mrf.InNetworkItem `json:"item"`
+}
+
+// NewItemHookProcess returns a hook that will run command with sh -c.
+func NewItemHookProcess(command string) *ItemHookProcess {
+	return &ItemHookProcess{command: command}
+}
+
+// Start starts the command.
+func (h *ItemHookProcess) Start() error {
+	h.cmd = exec.Command("sh", "-c", h.command)
+	h.cmd.Stdout = os.Stderr
+	h.cmd.Stderr = os.Stderr
+	var err error
+	if h.in, err = h.cmd.StdinPipe(); err != nil {
+		return fmt.Errorf("starting item hook: %w", err)
+	}
+	if err := h.cmd.Start(); err != nil {
+		return fmt.Errorf("starting item hook: %w", err)
+	}
+	h.w = bufio.NewWriterSize(h.in, 1<<20)
+	return nil
+}
+
+// Item writes one item to the command. It has the mrf.ItemHook signature
+// and is safe for concurrent use.
+func (h *ItemHookProcess) Item(item *mrf.InNetworkItem, sourceFile string) {
+	data, err := json.Marshal(hookLine{SourceFile: sourceFile, Item: item})
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.err != nil {
+		return
+	}
+	if err == nil {
+		_, err = h.w.Write(append(data, '\n'))
+	}
+	h.err = err
+}
+
+// Close ends the command's input and waits for it to exit. It reports a
+// failed write or a non-zero exit.
+func (h *ItemHookProcess) Close() error {
+	h.mu.Lock()
+	werr := h.err
+	if werr == nil {
+		werr = h.w.Flush()
+	}
+	h.mu.Unlock()
+	h.in.Close()
+	h.waited = true
+	if err := h.cmd.Wait(); err != nil {
+		return fmt.Errorf("item hook %q: %w", h.command, err)
+	}
+	if werr != nil {
+		return fmt.Errorf("item hook %q: %w", h.command, werr)
+	}
+	return nil
+}
+
+// Kill stops the command unless Close has already waited for it, for a
+// search that fails before its items are all written.
+func (h *ItemHookProcess) Kill() {
+	if h.waited {
+		return
+	}
+	h.waited = true
+	h.in.Close()
+	h.cmd.Process.Kill()
+	h.cmd.Wait()
+}
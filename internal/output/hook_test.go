@@ -0,0 +1,63 @@
+package output
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gyeh/npi-rates/internal/mrf"
+)
+
+func TestItemHookProcess(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "items.ndjson")
+	h := NewItemHookProcess("cat > " + out)
+	if err := h.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	h.Item(&mrf.InNetworkItem{BillingCode: "99213"}, "a.json.gz")
+	h.Item(&mrf.InNetworkItem{BillingCode: "99214"}, "b.json.gz")
+	if err := h.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	h.Kill() // a no-op once closed
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], `"source_file":"a.json.gz"`) || !strings.Contains(lines[1], `"99214"`) {
+		t.Errorf("unexpected hook input:\n%s", data)
+	}
+}
+
+func TestItemHookProcess_Failure(t *testing.T) {
+	h := NewItemHookProcess("exit 3")
+	if err := h.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if err := h.Close(); err == nil || !strings.Contains(err.Error(), "exit status 3") {
+		t.Errorf("expected the exit status, got %v", err)
+	}
+}
+
+// TestItemHookProcess_Kill verifies that Kill stops a command that would
+// otherwise outlive a failed search.
+func TestItemHookProcess_Kill(t *testing.T) {
+	h := NewItemHookProcess("sleep 60")
+	if err := h.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		h.Kill()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Kill did not stop the command")
+	}
+}
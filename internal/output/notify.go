@@ -0,0 +1,132 @@
+package output
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gyeh/npi-rates/internal/httpx"
+	"github.com/gyeh/npi-rates/internal/humanize"
+	"github.com/gyeh/npi-rates/internal/mrf"
+)
+
+// Notification summarizes a finished search, for posting to a webhook or
+// Slack when nobody is watching the terminal.
+type Notification struct {
+	RunID           string  `json:"run_id"`
+	Host            string  `json:"host"`
+	Status          string  `json:"status"` // "complete", "truncated" or "failed"
+	Error           string  `json:"error,omitempty"`
+	Files           int     `json:"files"`
+	FilesSearched   int     `json:"files_searched"`
+	FilesMatched    int     `json:"files_matched"`
+	FilesFailed     int     `json:"files_failed"`
+	Rates           int64   `json:"rates"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	// Outputs are where the results were written, with passwords redacted.
+	Outputs  []string         `json:"outputs,omitempty"`
+	Failures []mrf.ErrorCount `json:"failures,omitempty"` // most frequent kinds
+}
+
+// NewNotification starts a notification for run runID.
+func NewNotification(runID string) *Notification {
+	host, _ := os.Hostname()
+	return &Notification{RunID: runID, Host: host}
+}
+
+// SetSummary fills in the file and rate counts from a run's summary.
+func (n *Notification) SetSummary(s *mrf.RunSummary) {
+	n.Files, n.FilesSearched, n.FilesFailed, n.Rates = 0, 0, 0, 0
+	for _, h := range s.Hosts {
+		n.Files += h.Files
+		n.FilesSearched += h.Succeeded
+		n.FilesFailed += h.Failed
+		n.Rates += h.Rates
+	}
+	n.Failures = s.Errors
+}
+
+// notifyTimeout bounds each post, so an unreachable endpoint can't hold up
+// the end of a run.
+const notifyTimeout = 30 * time.Second
+
+// Notify posts n as JSON to webhookURL and as a message to the Slack
+// incoming webhook slackURL; either may be empty. Both are attempted, and
+// the first error is returned.
+func Notify(n *Notification, webhookURL, slackURL string) error {
+	var firstErr error
+	if webhookURL != "" {
+		if err := postJSON(webhookURL, n); err != nil {
+			firstErr = fmt.Errorf("--notify-url: %w", err)
+		}
+	}
+	if slackURL != "" {
+		if err := postJSON(slackURL, map[string]string{"text": n.slackText()}); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("--slack-webhook: %w", err)
+		}
+	}
+	return firstErr
+}
+
+func postJSON(url string, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := httpx.Streaming.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// slackText formats n as a Slack message.
+func (n *Notification) slackText() string {
+	var b strings.Builder
+	icon := ":white_check_mark:"
+	switch n.Status {
+	case mrf.StatusTruncated:
+		icon = ":warning:"
+	case "failed":
+		icon = ":x:"
+	}
+	duration := time.Duration(n.DurationSeconds * float64(time.Second)).Round(time.Second)
+	fmt.Fprintf(&b, "%s npi-rates search %s on %s after %s (run %s)\n", icon, n.Status, n.Host, duration, n.RunID)
+	if n.Files > 0 {
+		fmt.Fprintf(&b, "%s of %s files searched, %s matched, %s rates found",
+			humanize.Count(int64(n.FilesSearched)), humanize.Count(int64(n.Files)),
+			humanize.Count(int64(n.FilesMatched)), humanize.Count(n.Rates))
+		if n.FilesFailed > 0 {
+			fmt.Fprintf(&b, ", %s failed", humanize.Count(int64(n.FilesFailed)))
+		}
+		b.WriteString("\n")
+	}
+	if n.Error != "" {
+		fmt.Fprintf(&b, "Error: %s\n", n.Error)
+	}
+	for _, f := range n.Failures {
+		fmt.Fprintf(&b, "• %s: %s file(s), e.g. %s\n", f.Category, humanize.Count(int64(f.Count)), f.Example)
+	}
+	for _, out := range n.Outputs {
+		fmt.Fprintf(&b, "Results: %s\n", out)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
@@ -0,0 +1,99 @@
+package output
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gyeh/npi-rates/internal/mrf"
+)
+
+func testNotification() *Notification {
+	n := &Notification{RunID: "r1", Host: "worker-1", Status: mrf.StatusComplete, DurationSeconds: 75}
+	n.SetSummary(&mrf.RunSummary{
+		Hosts: []mrf.HostSummary{
+			{Host: "a.example.com", Files: 3, Succeeded: 2, Failed: 1, Rates: 1500},
+			{Host: "b.example.com", Files: 1, Succeeded: 1, Rates: 10},
+		},
+		Errors: []mrf.ErrorCount{{Category: "http", Count: 1, Example: "HTTP 404"}},
+	})
+	n.FilesMatched = 2
+	n.Outputs = []string{"results.json"}
+	return n
+}
+
+func TestNotify(t *testing.T) {
+	var webhook, slack []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("%s: Content-Type %q", r.URL.Path, r.Header.Get("Content-Type"))
+		}
+		switch r.URL.Path {
+		case "/hook":
+			webhook = data
+		case "/slack":
+			slack = data
+		default:
+			http.Error(w, "no such hook", http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	n := testNotification()
+	if err := Notify(n, server.URL+"/hook", server.URL+"/slack"); err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+	var got Notification
+	if err := json.Unmarshal(webhook, &got); err != nil {
+		t.Fatalf("invalid webhook body %s: %v", webhook, err)
+	}
+	if got.RunID != "r1" || got.Files != 4 || got.FilesSearched != 3 || got.FilesFailed != 1 || got.Rates != 1510 {
+		t.Errorf("unexpected webhook body %+v", got)
+	}
+	var msg map[string]string
+	if err := json.Unmarshal(slack, &msg); err != nil || msg["text"] != n.slackText() {
+		t.Errorf("unexpected Slack body %s (%v)", slack, err)
+	}
+
+	// A failing webhook is reported, and Slack is still posted to.
+	slack = nil
+	err := Notify(n, server.URL+"/missing", server.URL+"/slack")
+	if err == nil || !strings.Contains(err.Error(), "--notify-url: HTTP 404: no such hook") {
+		t.Errorf("expected the webhook's failure, got %v", err)
+	}
+	if slack == nil {
+		t.Error("expected Slack to be posted to after the webhook failed")
+	}
+	err = Notify(n, "", server.URL+"/missing")
+	if err == nil || !strings.Contains(err.Error(), "--slack-webhook") {
+		t.Errorf("expected Slack's failure, got %v", err)
+	}
+	if err := Notify(n, "", ""); err != nil {
+		t.Errorf("expected nothing to post, got %v", err)
+	}
+}
+
+func TestSlackText(t *testing.T) {
+	n := testNotification()
+	want := ":white_check_mark: npi-rates search complete on worker-1 after 1m15s (run r1)\n" +
+		"3 of 4 files searched, 2 matched, 1,510 rates found, 1 failed\n" +
+		"• http: 1 file(s), e.g. HTTP 404\n" +
+		"Results: results.json"
+	if got := n.slackText(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	n = &Notification{RunID: "r2", Host: "worker-1", Status: "failed", Error: "no URLs to search"}
+	want = ":x: npi-rates search failed on worker-1 after 0s (run r2)\nError: no URLs to search"
+	if got := n.slackText(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	n.Status = mrf.StatusTruncated
+	if got := n.slackText(); !strings.HasPrefix(got, ":warning:") {
+		t.Errorf("expected a warning for a truncated run, got %q", got)
+	}
+}
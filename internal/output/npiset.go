@@ -0,0 +1,152 @@
+package output
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/gyeh/npi-rates/internal/mrf"
+)
+
+// NPISet is a named set of NPIs with the sink for its results.
+type NPISet struct {
+	Name string
+	NPIs []int64
+	Sink Sink
+}
+
+// NPISetSink routes each result to the sinks of the NPI sets holding its
+// NPI, so one run can search several sets at once and still write each its
+// own output. A result whose NPI is in several sets goes to each of them;
+// one collapsed per provider group (see mrf.RateResult.NPIs) goes to every
+// set holding any of its NPIs, listing only that set's. Results for no set
+// are dropped.
+type NPISetSink struct {
+	sets  []NPISet
+	byNPI map[int64][]int // indexes into sets
+	files []map[string]struct{}
+}
+
+// NewNPISetSink routes results to sets.
+func NewNPISetSink(sets []NPISet) *NPISetSink {
+	s := &NPISetSink{sets: sets, byNPI: make(map[int64][]int)}
+	for i, set := range sets {
+		for _, n := range set.NPIs {
+			if ids := s.byNPI[n]; len(ids) == 0 || ids[len(ids)-1] != i {
+				s.byNPI[n] = append(ids, i)
+			}
+		}
+	}
+	return s
+}
+
+func (s *NPISetSink) Open(ctx context.Context) error {
+	s.files = make([]map[string]struct{}, len(s.sets))
+	for i := range s.files {
+		s.files[i] = make(map[string]struct{})
+	}
+	return s.each(func(set NPISet) error { return set.Sink.Open(ctx) })
+}
+
+func (s *NPISetSink) WriteBatch(results []mrf.RateResult) error {
+	batches := make([][]mrf.RateResult, len(s.sets))
+	for _, r := range results {
+		if len(r.NPIs) == 0 {
+			for _, i := range s.byNPI[r.NPI] {
+				batches[i] = append(batches[i], r)
+			}
+			continue
+		}
+		for i := range s.sets {
+			var npis []int64
+			for _, n := range r.NPIs {
+				if s.inSet(n, i) {
+					npis = append(npis, n)
+				}
+			}
+			if len(npis) > 0 {
+				c := r
+				c.NPI, c.NPIs = npis[0], npis
+				batches[i] = append(batches[i], c)
+			}
+		}
+	}
+	var errs []error
+	for i, batch := range batches {
+		if len(batch) == 0 {
+			continue
+		}
+		for _, r := range batch {
+			s.files[i][r.SourceFile] = struct{}{}
+		}
+		if err := s.sets[i].Sink.WriteBatch(batch); err != nil {
+			errs = append(errs, fmt.Errorf("NPI set %s: %w", s.sets[i].Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close closes each set's sink with params narrowed to the set: its name and
+// NPIs, and the files that had results for it.
+func (s *NPISetSink) Close(params mrf.SearchParams) error {
+	var errs []error
+	for i, set := range s.sets {
+		p := params
+		p.NPISet, p.NPIs = set.Name, set.NPIs
+		if s.files != nil {
+			p.MatchedFiles = len(s.files[i])
+		}
+		if err := set.Sink.Close(p); err != nil {
+			errs = append(errs, fmt.Errorf("NPI set %s: %w", set.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *NPISetSink) inSet(npi int64, set int) bool {
+	for _, i := range s.byNPI[npi] {
+		if i == set {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *NPISetSink) each(fn func(NPISet) error) error {
+	var errs []error
+	for _, set := range s.sets {
+		if err := fn(set); err != nil {
+			errs = append(errs, fmt.Errorf("NPI set %s: %w", set.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// NPISetPath returns the output path for set name derived from path, with
+// the name before the extension: "out/results.json" becomes
+// "out/results.clientA.json". Of a remote output only the last element of
+// the URL's path changes, so "https://h/up/r.csv?sig=a.b" becomes
+// "https://h/up/r.clientA.csv?sig=a.b", and "https://h" or "https://h/"
+// becomes "https://h/clientA".
+func NPISetPath(p, name string) string {
+	if !IsRemote(p) {
+		ext := filepath.Ext(p)
+		return strings.TrimSuffix(p, ext) + "." + name + ext
+	}
+	var query string
+	if i := strings.IndexAny(p, "?#"); i >= 0 {
+		p, query = p[:i], p[i:]
+	}
+	_, rest, _ := strings.Cut(p, "://")
+	switch {
+	case !strings.Contains(rest, "/"):
+		return p + "/" + name + query
+	case strings.HasSuffix(p, "/"):
+		return p + name + query
+	}
+	ext := path.Ext(p)
+	return strings.TrimSuffix(p, ext) + "." + name + ext + query
+}
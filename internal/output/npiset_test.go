@@ -0,0 +1,122 @@
+package output
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gyeh/npi-rates/internal/mrf"
+)
+
+// failSink is a memSink whose WriteBatch and Close fail.
+type failSink struct{ memSink }
+
+func (f *failSink) WriteBatch([]mrf.RateResult) error { return errors.New("disk full") }
+func (f *failSink) Close(mrf.SearchParams) error      { return errors.New("disk full") }
+
+func TestNPISetSink(t *testing.T) {
+	a, b := &memSink{}, &memSink{}
+	s := NewNPISetSink([]NPISet{
+		{Name: "acme", NPIs: []int64{1, 2, 2}, Sink: a},
+		{Name: "zenith", NPIs: []int64{2, 3}, Sink: b},
+	})
+	if err := s.Open(context.Background()); err != nil || !a.opened || !b.opened {
+		t.Fatalf("Open: %v", err)
+	}
+	err := s.WriteBatch([]mrf.RateResult{
+		{SourceFile: "f1", NPI: 1},
+		{SourceFile: "f1", NPI: 2}, // in both sets
+		{SourceFile: "f2", NPI: 4}, // in neither
+		// Collapsed per provider group: each set gets its own NPIs.
+		{SourceFile: "f2", NPI: 1, NPIs: []int64{1, 3, 4}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	type got struct {
+		npi  int64
+		npis []int64
+	}
+	results := func(m *memSink) []got {
+		var out []got
+		for _, r := range m.results {
+			out = append(out, got{r.NPI, r.NPIs})
+		}
+		return out
+	}
+	if r, want := results(a), []got{{1, nil}, {2, nil}, {1, []int64{1}}}; !reflect.DeepEqual(r, want) {
+		t.Errorf("acme got %v, want %v", r, want)
+	}
+	if r, want := results(b), []got{{2, nil}, {3, []int64{3}}}; !reflect.DeepEqual(r, want) {
+		t.Errorf("zenith got %v, want %v", r, want)
+	}
+
+	if err := s.Close(mrf.SearchParams{RunID: "r1", NPIs: []int64{1, 2, 3}, MatchedFiles: 5}); err != nil {
+		t.Fatal(err)
+	}
+	if p := a.params; p.RunID != "r1" || p.NPISet != "acme" || !reflect.DeepEqual(p.NPIs, []int64{1, 2, 2}) || p.MatchedFiles != 2 {
+		t.Errorf("unexpected acme params %+v", p)
+	}
+	if p := b.params; p.NPISet != "zenith" || !reflect.DeepEqual(p.NPIs, []int64{2, 3}) || p.MatchedFiles != 2 {
+		t.Errorf("unexpected zenith params %+v", p)
+	}
+}
+
+func TestNPISetSink_Errors(t *testing.T) {
+	ok := &memSink{}
+	s := NewNPISetSink([]NPISet{
+		{Name: "acme", NPIs: []int64{1}, Sink: &failSink{}},
+		{Name: "zenith", NPIs: []int64{1}, Sink: ok},
+	})
+	s.Open(context.Background())
+	err := s.WriteBatch([]mrf.RateResult{{NPI: 1}})
+	if err == nil || !strings.Contains(err.Error(), "NPI set acme: disk full") {
+		t.Errorf("expected the failing set named, got %v", err)
+	}
+	if len(ok.results) != 1 {
+		t.Error("expected the other set still written")
+	}
+	if err := s.Close(mrf.SearchParams{}); err == nil || ok.params == nil {
+		t.Errorf("expected a Close error with the other set closed, got %v", err)
+	}
+}
+
+func TestNPISetPath(t *testing.T) {
+	tests := []struct{ path, want string }{
+		{"results.json", "results.acme.json"},
+		{"out/results.ndjson", "out/results.acme.ndjson"},
+		{"out.d/results", "out.d/results.acme"},
+		{"s3://bucket/2026-02/rates.csv", "s3://bucket/2026-02/rates.acme.csv"},
+		{"gs://bucket.example/rates", "gs://bucket.example/rates.acme"},
+		{"https://up.example.com/r.csv?X-Amz-Date=20260201&v=1.2", "https://up.example.com/r.acme.csv?X-Amz-Date=20260201&v=1.2"},
+		{"https://hooks.example.com", "https://hooks.example.com/acme"},
+		{"https://hooks.example.com/?token=a.b", "https://hooks.example.com/acme?token=a.b"},
+	}
+	for _, tt := range tests {
+		if got := NPISetPath(tt.path, "acme"); got != tt.want {
+			t.Errorf("NPISetPath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestSplitSinkSpec(t *testing.T) {
+	tests := []struct {
+		spec, kind, path string
+		ok               bool
+	}{
+		{"csv:rates.csv", "csv", "rates.csv", true},
+		{"json:s3://bucket/rates.json", "json", "s3://bucket/rates.json", true},
+		{"ndjson:https://up.example.com/r.ndjson?sig=a:b", "ndjson", "https://up.example.com/r.ndjson?sig=a:b", true},
+		{"csv:", "csv", "", true}, // newSink reports the missing path
+		{"rates.csv", "", "", false},
+		{"s3://bucket/rates.csv", "", "", false},
+	}
+	for _, tt := range tests {
+		kind, path, err := SplitSinkSpec(tt.spec)
+		if kind != tt.kind || path != tt.path || (err == nil) != tt.ok {
+			t.Errorf("SplitSinkSpec(%q) = %q, %q, %v", tt.spec, kind, path, err)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package output
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/gyeh/npi-rates/internal/mrf"
+)
+
+// ReadResults calls fn with each result in a search output file: a JSON
+// document, or NDJSON if path has an .ndjson/.jsonl extension. The results
+// are streamed rather than loaded at once.
+func ReadResults(path string, fn func(r *mrf.RateResult)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	br := bufio.NewReaderSize(f, 1<<20)
+
+	switch kind := kindFromPath(path); kind {
+	case "ndjson":
+		dec := json.NewDecoder(br)
+		for {
+			var r mrf.RateResult
+			if err := dec.Decode(&r); err == io.EOF {
+				return nil
+			} else if err != nil {
+				return fmt.Errorf("reading %s: %w", path, err)
+			}
+			fn(&r)
+		}
+	case "json":
+		if err := readResultsDocument(json.NewDecoder(br), fn); err != nil {
+			return fmt.Errorf("reading %s: %w", path, err)
+		}
+		return nil
+	default:
+		return fmt.Errorf("reading %s: %s results can't be read back; use json or ndjson output", path, kind)
+	}
+}
+
+// readResultsDocument streams the results array of a SearchOutput document.
+func readResultsDocument(dec *json.Decoder, fn func(r *mrf.RateResult)) error {
+	if tok, err := dec.Token(); err != nil {
+		return err
+	} else if tok != json.Delim('{') {
+		return errors.New("not a search output document")
+	}
+	found := false
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if tok != "results" {
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return err
+			}
+			continue
+		}
+		found = true
+		if tok, err := dec.Token(); err != nil {
+			return err
+		} else if tok == nil {
+			continue // "results": null
+		} else if tok != json.Delim('[') {
+			return errors.New(`"results" is not an array`)
+		}
+		for dec.More() {
+			var r mrf.RateResult
+			if err := dec.Decode(&r); err != nil {
+				return err
+			}
+			fn(&r)
+		}
+		if _, err := dec.Token(); err != nil {
+			return err
+		}
+	}
+	if !found {
+		return errors.New(`no "results" array; not a search output document`)
+	}
+	return nil
+}
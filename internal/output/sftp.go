@@ -0,0 +1,281 @@
+package output
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/gyeh/npi-rates/internal/httpx"
+)
+
+// sftpConn is an SFTP session over its own SSH connection.
+type sftpConn struct {
+	ssh  *ssh.Client
+	sftp *sftp.Client
+	// posixRename is set when the server offers posix-rename@openssh.com,
+	// which replaces the target atomically; plain rename may refuse to.
+	posixRename bool
+}
+
+// sftpPath returns the remote path of an sftp:// URL. As with curl, a path
+// starting "/~/" is relative to the login directory.
+func sftpPath(u *url.URL) string {
+	if rest, ok := strings.CutPrefix(u.Path, "/~/"); ok {
+		return rest
+	}
+	return u.Path
+}
+
+func dialSFTP(ctx context.Context, u *url.URL) (*sftpConn, error) {
+	hostKeys, err := sftpHostKeys()
+	if err != nil {
+		return nil, err
+	}
+	auth, closeAgent := sftpAuth(u)
+	defer closeAgent()
+	name := u.User.Username()
+	if name == "" {
+		if cur, err := user.Current(); err == nil {
+			name = cur.Username
+		}
+	}
+	port := u.Port()
+	if port == "" {
+		port = "22"
+	}
+	addr := net.JoinHostPort(u.Hostname(), port)
+	dialCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	conn, err := httpx.Dial(dialCtx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	config := &ssh.ClientConfig{
+		User:            name,
+		Auth:            auth,
+		HostKeyCallback: hostKeys,
+		Timeout:         30 * time.Second,
+	}
+	if dl, ok := dialCtx.Deadline(); ok {
+		conn.SetDeadline(dl)
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	client := ssh.NewClient(c, chans, reqs)
+	// Concurrent writes keep several chunks in flight, so throughput does
+	// not drop to one chunk per round trip.
+	sc, err := sftp.NewClient(client, sftp.UseConcurrentWrites(true))
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("starting sftp: %w", err)
+	}
+	_, posix := sc.HasExtension("posix-rename@openssh.com")
+	return &sftpConn{ssh: client, sftp: sc, posixRename: posix}, nil
+}
+
+// sftpHostKeys returns a callback checking host keys against the
+// known_hosts file.
+func sftpHostKeys() (ssh.HostKeyCallback, error) {
+	file := delivery.KnownHosts
+	if file == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		file = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	cb, err := knownhosts.New(file)
+	if err != nil {
+		return nil, fmt.Errorf("reading known hosts: %w", err)
+	}
+	return func(host string, remote net.Addr, key ssh.PublicKey) error {
+		err := cb(host, remote, key)
+		var keyErr *knownhosts.KeyError
+		if errors.As(err, &keyErr) && len(keyErr.Want) == 0 {
+			return fmt.Errorf("host %s is not in %s; add it with ssh-keyscan", host, file)
+		}
+		return err
+	}, nil
+}
+
+// sftpAuth returns the auth methods to try: the configured key, or the
+// agent's keys and the default key files; then a password from the URL or
+// SFTPPasswordEnv. The returned func closes the agent connection.
+func sftpAuth(u *url.URL) ([]ssh.AuthMethod, func()) {
+	var methods []ssh.AuthMethod
+	closeAgent := func() {}
+	if delivery.SFTPKey != "" {
+		methods = append(methods, ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+			data, err := os.ReadFile(delivery.SFTPKey)
+			if err != nil {
+				return nil, err
+			}
+			signer, err := ssh.ParsePrivateKey(data)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w (keys with a passphrase must be loaded into ssh-agent)", delivery.SFTPKey, err)
+			}
+			return []ssh.Signer{signer}, nil
+		}))
+	} else {
+		if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+			if conn, err := net.Dial("unix", sock); err == nil {
+				methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+				closeAgent = func() { conn.Close() }
+			}
+		}
+		if home, err := os.UserHomeDir(); err == nil {
+			var signers []ssh.Signer
+			for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+				data, err := os.ReadFile(filepath.Join(home, ".ssh", name))
+				if err != nil {
+					continue
+				}
+				if signer, err := ssh.ParsePrivateKey(data); err == nil {
+					signers = append(signers, signer)
+				}
+			}
+			if len(signers) > 0 {
+				methods = append(methods, ssh.PublicKeys(signers...))
+			}
+		}
+	}
+	password, ok := u.User.Password()
+	if !ok {
+		password, ok = os.LookupEnv(SFTPPasswordEnv)
+	}
+	if ok {
+		methods = append(methods, ssh.Password(password))
+	}
+	return methods, closeAgent
+}
+
+func (s *sftpConn) Close() error {
+	s.sftp.Close()
+	return s.ssh.Close()
+}
+
+// put writes r to remote under a temp name beside it and renames it into
+// place, so readers polling the drop never see a partial file.
+func (s *sftpConn) put(remote string, r io.Reader) error {
+	dir, base := path.Split(remote)
+	tmp := dir + "." + base + partialMarker + strconv.Itoa(os.Getpid())
+	if err := s.writeFile(tmp, r); err != nil {
+		return s.discard(tmp, err)
+	}
+	if err := s.rename(tmp, remote); err != nil {
+		return s.discard(tmp, fmt.Errorf("renaming into place: %w", err))
+	}
+	return nil
+}
+
+// discard removes the temp file of a failed put and returns err, with the
+// removal's error if it failed too.
+func (s *sftpConn) discard(tmp string, err error) error {
+	if rerr := s.sftp.Remove(tmp); rerr != nil && !errors.Is(rerr, os.ErrNotExist) {
+		return errors.Join(err, fmt.Errorf("removing %s: %w", tmp, rerr))
+	}
+	return err
+}
+
+// rename moves tmp over remote. Without posix-rename, a target that plain
+// rename won't replace is first moved aside and put back if the rename
+// still fails, so remote is never left missing.
+func (s *sftpConn) rename(tmp, remote string) error {
+	if s.posixRename {
+		return s.sftp.PosixRename(tmp, remote)
+	}
+	err := s.sftp.Rename(tmp, remote)
+	if err == nil {
+		return nil
+	}
+	if _, serr := s.sftp.Stat(remote); serr != nil {
+		return err // not for want of replacing an existing file
+	}
+	dir, base := path.Split(remote)
+	old := dir + "." + base + ".old" + partialMarker + strconv.Itoa(os.Getpid())
+	if err := s.sftp.Rename(remote, old); err != nil {
+		return fmt.Errorf("moving aside %s: %w", remote, err)
+	}
+	if err := s.sftp.Rename(tmp, remote); err != nil {
+		if rerr := s.sftp.Rename(old, remote); rerr != nil {
+			return errors.Join(err, fmt.Errorf("restoring %s from %s: %w", remote, old, rerr))
+		}
+		return err
+	}
+	if err := s.sftp.Remove(old); err != nil {
+		fmt.Fprintf(os.Stderr, "WARNING: removing the replaced file %s: %v\n", old, err)
+	}
+	return nil
+}
+
+// writeFile creates or truncates name and writes r to it.
+func (s *sftpConn) writeFile(name string, r io.Reader) error {
+	f, err := s.sftp.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return fmt.Errorf("creating %s: %w", name, err)
+	}
+	_, err = f.ReadFrom(r)
+	closeErr := f.Close()
+	if err != nil {
+		return fmt.Errorf("writing %s: %w", name, err)
+	}
+	if closeErr != nil {
+		return fmt.Errorf("closing %s: %w", name, closeErr)
+	}
+	return nil
+}
+
+// deliverSFTP uploads r to an sftp://[user[:password]@]host[:port]/path URL.
+func deliverSFTP(ctx context.Context, dest string, r io.ReadSeeker, _ int64, _ string) error {
+	u, err := url.Parse(dest)
+	if err != nil {
+		return err
+	}
+	s, err := dialSFTP(ctx, u)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	return s.put(sftpPath(u), r)
+}
+
+// checkSFTP logs in to the server of an sftp:// output and checks that its
+// directory exists.
+func checkSFTP(ctx context.Context, dest string) error {
+	u, err := url.Parse(dest)
+	if err != nil {
+		return err
+	}
+	if u.Hostname() == "" || strings.HasSuffix(u.Path, "/") || path.Base(u.Path) == "." {
+		return fmt.Errorf("expected sftp://[user@]host[:port]/path/to/file")
+	}
+	s, err := dialSFTP(ctx, u)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	dir := path.Dir(sftpPath(u))
+	if _, err := s.sftp.Stat(dir); err != nil {
+		return fmt.Errorf("directory %s: %w", dir, err)
+	}
+	return nil
+}
@@ -0,0 +1,258 @@
+package output
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// startSFTPServer runs an SSH server offering the sftp subsystem, for user
+// "npi" with password "secret", and points delivery at a known_hosts file
+// holding its key. It serves the local filesystem, or handlers if given.
+// It returns the server's address.
+func startSFTPServer(t *testing.T, handlers *sftp.Handlers) string {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if c.User() == "npi" && string(pass) == "secret" {
+				return nil, nil
+			}
+			return nil, errors.New("wrong password")
+		},
+	}
+	config.AddHostKey(signer)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveSFTP(conn, config, handlers)
+		}
+	}()
+
+	knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{l.Addr().String()}, signer.PublicKey())
+	if err := os.WriteFile(knownHosts, []byte(line+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	SetDelivery(DeliveryOptions{KnownHosts: knownHosts})
+	t.Cleanup(func() { SetDelivery(DeliveryOptions{}) })
+	return l.Addr().String()
+}
+
+func serveSFTP(conn net.Conn, config *ssh.ServerConfig, handlers *sftp.Handlers) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for nc := range chans {
+		if nc.ChannelType() != "session" {
+			nc.Reject(ssh.UnknownChannelType, "sessions only")
+			continue
+		}
+		ch, requests, err := nc.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			for req := range requests {
+				ok := req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if ok {
+					go func() {
+						if handlers != nil {
+							sftp.NewRequestServer(ch, *handlers).Serve()
+						} else if server, err := sftp.NewServer(ch); err == nil {
+							server.Serve()
+						}
+						ch.Close()
+					}()
+				}
+			}
+		}()
+	}
+}
+
+// brokenReader fails after its data.
+type brokenReader struct{ data io.Reader }
+
+func (r brokenReader) Read(p []byte) (int, error) {
+	n, err := r.data.Read(p)
+	if err == io.EOF {
+		return n, errors.New("disk error")
+	}
+	return n, err
+}
+
+// entries returns the names in dir.
+func entries(t *testing.T, dir string) []string {
+	t.Helper()
+	es, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range es {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func TestDeliverSFTP(t *testing.T) {
+	addr := startSFTPServer(t, nil)
+	dir := t.TempDir()
+	dest := "sftp://npi:secret@" + addr + dir + "/rates.csv"
+	body := strings.Repeat("npi,rate\n", 20000) // several chunks
+
+	if err := deliverSFTP(context.Background(), dest, strings.NewReader(body), int64(len(body)), "text/csv"); err != nil {
+		t.Fatalf("deliverSFTP failed: %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "rates.csv")); string(data) != body {
+		t.Errorf("delivered %d bytes, want %d", len(data), len(body))
+	}
+
+	// A second delivery replaces the file, with and without posix-rename.
+	u, _ := url.Parse(dest)
+	for _, posix := range []bool{true, false} {
+		s, err := dialSFTP(context.Background(), u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !s.posixRename {
+			t.Error("expected the server to offer posix-rename")
+		}
+		s.posixRename = posix
+		err = s.put(sftpPath(u), strings.NewReader("replaced"))
+		s.Close()
+		if err != nil {
+			t.Fatalf("put (posix-rename %v) failed: %v", posix, err)
+		}
+		if data, _ := os.ReadFile(filepath.Join(dir, "rates.csv")); string(data) != "replaced" {
+			t.Errorf("posix-rename %v: got %q", posix, data)
+		}
+	}
+	if names := entries(t, dir); len(names) != 1 {
+		t.Errorf("expected only rates.csv, got %v", names)
+	}
+
+	// A failed write leaves the old file and no temp file.
+	s, err := dialSFTP(context.Background(), u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.put(sftpPath(u), brokenReader{strings.NewReader("partial")})
+	s.Close()
+	if err == nil || !strings.Contains(err.Error(), "disk error") {
+		t.Errorf("expected the read error, got %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "rates.csv")); string(data) != "replaced" {
+		t.Errorf("expected the old file kept, got %q", data)
+	}
+	if names := entries(t, dir); len(names) != 1 {
+		t.Errorf("expected the temp file removed, got %v", names)
+	}
+
+	if err := deliverSFTP(context.Background(), "sftp://npi:secret@"+addr+dir+"/missing/rates.csv",
+		strings.NewReader("x"), 1, "text/csv"); err == nil {
+		t.Error("expected an error for a missing directory")
+	}
+}
+
+func TestCheckRemote_SFTP(t *testing.T) {
+	addr := startSFTPServer(t, nil)
+	dir := t.TempDir()
+
+	if err := CheckRemote(context.Background(), "sftp://npi:secret@"+addr+dir+"/rates.csv"); err != nil {
+		t.Errorf("CheckRemote failed: %v", err)
+	}
+	err := CheckRemote(context.Background(), "sftp://npi:secret@"+addr+dir+"/missing/rates.csv")
+	if err == nil || !strings.Contains(err.Error(), "directory") {
+		t.Errorf("expected a missing directory error, got %v", err)
+	}
+	if strings.Contains(err.Error(), "secret") {
+		t.Errorf("expected the password redacted, got %v", err)
+	}
+	if err := CheckRemote(context.Background(), "sftp://npi:wrong@"+addr+dir+"/rates.csv"); err == nil {
+		t.Error("expected a login failure")
+	}
+	if err := CheckRemote(context.Background(), "sftp://npi:secret@"+addr+"/"); err == nil {
+		t.Error("expected an error for a URL without a file name")
+	}
+
+	empty := filepath.Join(t.TempDir(), "known_hosts")
+	os.WriteFile(empty, nil, 0o600)
+	SetDelivery(DeliveryOptions{KnownHosts: empty})
+	err = CheckRemote(context.Background(), "sftp://npi:secret@"+addr+dir+"/rates.csv")
+	if err == nil || !strings.Contains(err.Error(), "is not in") {
+		t.Errorf("expected an unknown host to be refused, got %v", err)
+	}
+}
+
+// TestSFTPRename_MovesAside verifies that, without posix-rename, a target
+// that plain rename refuses to replace is moved aside and removed.
+func TestSFTPRename_MovesAside(t *testing.T) {
+	handlers := sftp.InMemHandler() // plain rename refuses existing targets
+	addr := startSFTPServer(t, &handlers)
+	u, _ := url.Parse("sftp://npi:secret@" + addr + "/rates.csv")
+	s, err := dialSFTP(context.Background(), u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.posixRename = false
+
+	for _, body := range []string{"first", "second"} {
+		if err := s.put(sftpPath(u), strings.NewReader(body)); err != nil {
+			t.Fatalf("put %s failed: %v", body, err)
+		}
+	}
+	f, err := s.sftp.Open("/rates.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(f)
+	f.Close()
+	if string(data) != "second" {
+		t.Errorf("got %q, want second", data)
+	}
+	names, err := s.sftp.ReadDir("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 {
+		var got []string
+		for _, n := range names {
+			got = append(got, n.Name())
+		}
+		t.Errorf("expected only rates.csv, got %v", got)
+	}
+}
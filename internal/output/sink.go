@@ -0,0 +1,113 @@
+package output
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/gyeh/npi-rates/internal/mrf"
+)
+
+// Sink receives search results as they are produced. A run calls Open once,
+// WriteBatch any number of times (never concurrently), then Close once with
+// the final search parameters, which are only known when the run ends.
+// Open's ctx bounds the delivery of remote outputs, up to Close.
+type Sink interface {
+	Open(ctx context.Context) error
+	WriteBatch(results []mrf.RateResult) error
+	Close(params mrf.SearchParams) error
+}
+
+// NewSink builds a sink from a "kind:path" spec, e.g. "csv:rates.csv".
+// Supported kinds are json, ndjson, csv, and sqlite.
+func NewSink(spec string) (Sink, error) {
+	kind, path, err := SplitSinkSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+	return newSink(kind, path)
+}
+
+// SplitSinkSpec splits a "kind:path" sink spec at the ':' ending the kind,
+// so a path that is a URL ("csv:s3://bucket/rates.csv") is kept whole. A
+// spec that is only a URL, with no kind, is an error.
+func SplitSinkSpec(spec string) (kind, path string, err error) {
+	kind, path, ok := strings.Cut(spec, ":")
+	if !ok || strings.HasPrefix(path, "//") {
+		return "", "", fmt.Errorf("sink %q: expected kind:path, e.g. csv:%s", spec, spec)
+	}
+	return kind, path, nil
+}
+
+// NewFileSink builds a sink for path, choosing the format from its extension
+// (.ndjson/.jsonl, .csv, .db/.sqlite, otherwise JSON). "-" writes JSON to stdout.
+func NewFileSink(path string) (Sink, error) {
+	return newSink(kindFromPath(path), path)
+}
+
+func newSink(kind, path string) (Sink, error) {
+	if path == "" {
+		return nil, fmt.Errorf("%s sink: missing path", kind)
+	}
+	switch kind {
+	case "json":
+		return NewJSONSink(path), nil
+	case "ndjson", "jsonl":
+		return NewNDJSONSink(path), nil
+	case "csv":
+		return NewCSVSink(path), nil
+	case "sqlite":
+		return NewSQLiteSink(path), nil
+	default:
+		return nil, fmt.Errorf("unknown sink kind %q (want json, ndjson, csv, or sqlite)", kind)
+	}
+}
+
+func kindFromPath(path string) string {
+	path = remotePath(path)
+	switch {
+	case strings.HasSuffix(path, ".ndjson"), strings.HasSuffix(path, ".jsonl"):
+		return "ndjson"
+	case strings.HasSuffix(path, ".csv"):
+		return "csv"
+	case strings.HasSuffix(path, ".db"), strings.HasSuffix(path, ".sqlite"), strings.HasSuffix(path, ".sqlite3"):
+		return "sqlite"
+	default:
+		return "json"
+	}
+}
+
+// MultiSink fans every call out to several sinks concurrently, so a slow
+// sink only delays the others by its own latency rather than the sum.
+type MultiSink []Sink
+
+func (m MultiSink) Open(ctx context.Context) error {
+	return m.each(func(s Sink) error { return s.Open(ctx) })
+}
+
+func (m MultiSink) WriteBatch(results []mrf.RateResult) error {
+	return m.each(func(s Sink) error { return s.WriteBatch(results) })
+}
+
+func (m MultiSink) Close(params mrf.SearchParams) error {
+	return m.each(func(s Sink) error { return s.Close(params) })
+}
+
+func (m MultiSink) each(fn func(Sink) error) error {
+	if len(m) == 1 {
+		return fn(m[0])
+	}
+	errs := make([]error, len(m))
+	var wg sync.WaitGroup
+	for i, s := range m {
+		wg.Add(1)
+		go func(i int, s Sink) {
+			defer wg.Done()
+			errs[i] = fn(s)
+		}(i, s)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
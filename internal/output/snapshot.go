@@ -0,0 +1,218 @@
+package output
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gyeh/npi-rates/internal/mrf"
+)
+
+// SnapshotSink passes results on to the wrapped sink and, every interval,
+// writes everything seen so far to a JSON snapshot file, replacing it
+// atomically. A run that crashes or loses power keeps the findings of its
+// last snapshot, where the wrapped sinks would have written nothing.
+//
+// Results are spooled to a hidden file beside the snapshot rather than held
+// in memory; each snapshot copies the spool. The spool is named like a
+// temp output, so RemoveOrphans cleans up after a crashed run.
+type SnapshotSink struct {
+	next   Sink
+	path   string
+	every  time.Duration
+	params func() mrf.SearchParams
+	ctx    context.Context
+
+	mu    sync.Mutex
+	spool *os.File
+	w     *bufio.Writer
+	size  int64               // bytes written to the spool
+	count int64               // results written to the spool
+	files map[string]struct{} // source files with results so far
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewSnapshotSink wraps next, writing a snapshot to path every interval.
+// params supplies the search parameters recorded in each snapshot; the
+// sink fills in its status and the number of files matched so far.
+func NewSnapshotSink(next Sink, path string, every time.Duration, params func() mrf.SearchParams) *SnapshotSink {
+	return &SnapshotSink{next: next, path: path, every: every, params: params, files: make(map[string]struct{})}
+}
+
+// SnapshotPath returns where the snapshot of output goes: its name with
+// ".partial.json" in place of the extension, e.g. "results.partial.json"
+// for "results.csv".
+func SnapshotPath(output string) string {
+	ext := filepath.Ext(output)
+	return strings.TrimSuffix(output, ext) + ".partial.json"
+}
+
+func (s *SnapshotSink) Open(ctx context.Context) error {
+	dir, base := filepath.Split(s.path)
+	name := filepath.Join(dir, "."+base+".spool"+partialMarker+strconv.Itoa(os.Getpid()))
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("creating snapshot spool: %w", err)
+	}
+	if err := s.next.Open(ctx); err != nil {
+		f.Close()
+		os.Remove(name)
+		return err
+	}
+	s.ctx = ctx
+	s.spool, s.w = f, bufio.NewWriterSize(f, 1<<20)
+	s.stop, s.done = make(chan struct{}), make(chan struct{})
+	go s.loop()
+	return nil
+}
+
+func (s *SnapshotSink) WriteBatch(results []mrf.RateResult) error {
+	if err := s.next.WriteBatch(results); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range results {
+		line, err := json.Marshal(&results[i])
+		if err != nil {
+			return err
+		}
+		line = append(line, '\n')
+		if _, err := s.w.Write(line); err != nil {
+			return fmt.Errorf("writing snapshot spool: %w", err)
+		}
+		s.size += int64(len(line))
+		s.count++
+		s.files[results[i].SourceFile] = struct{}{}
+	}
+	return nil
+}
+
+// Close stops snapshotting and closes the wrapped sink. Once the wrapped
+// sink has written the real output, the snapshot is removed; if it failed,
+// a final snapshot is written in its place, and an error writing that is
+// returned too.
+func (s *SnapshotSink) Close(params mrf.SearchParams) error {
+	s.stopLoop()
+	defer s.removeSpool()
+	err := s.next.Close(params)
+	if err == nil {
+		os.Remove(s.path)
+	} else if s.spool != nil {
+		if _, serr := s.snapshot(); serr != nil {
+			err = errors.Join(err, fmt.Errorf("writing snapshot %s: %w", s.path, serr))
+		}
+	}
+	return err
+}
+
+// Abort writes a final snapshot and stops, for a run ending in an error
+// that will never Close the sink. It returns the number of results in
+// the snapshot.
+func (s *SnapshotSink) Abort() (int64, error) {
+	if s.spool == nil {
+		return 0, nil
+	}
+	s.stopLoop()
+	n, err := s.snapshot()
+	s.removeSpool()
+	return n, err
+}
+
+func (s *SnapshotSink) loop() {
+	defer close(s.done)
+	t := time.NewTicker(s.every)
+	defer t.Stop()
+	warned := false
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-t.C:
+			if _, err := s.snapshot(); err != nil && !warned {
+				fmt.Fprintf(os.Stderr, "WARNING: writing snapshot %s: %v\n", s.path, err)
+				warned = true
+			}
+		}
+	}
+}
+
+func (s *SnapshotSink) stopLoop() {
+	if s.stop != nil {
+		close(s.stop)
+		<-s.done
+		s.stop = nil
+	}
+}
+
+func (s *SnapshotSink) removeSpool() {
+	if s.spool != nil {
+		s.spool.Close()
+		os.Remove(s.spool.Name())
+		s.spool = nil
+	}
+}
+
+// snapshot writes the results spooled so far to the snapshot file, in the
+// JSON output's layout, and returns how many there were. Only the spool
+// flush holds the lock, so batches keep arriving while the copy is made.
+func (s *SnapshotSink) snapshot() (int64, error) {
+	s.mu.Lock()
+	err := s.w.Flush()
+	size, count, matched := s.size, s.count, len(s.files)
+	s.mu.Unlock()
+	if err != nil {
+		return 0, fmt.Errorf("flushing spool: %w", err)
+	}
+
+	params := s.params()
+	params.Status, params.MatchedFiles = mrf.StatusPartial, matched
+	header, err := json.MarshalIndent(params, "  ", "  ")
+	if err != nil {
+		return 0, err
+	}
+	f, err := createAtomic(s.ctx, s.path)
+	if err != nil {
+		return 0, err
+	}
+	bw := bufio.NewWriterSize(f, 1<<20)
+	fmt.Fprintf(bw, "{\n  \"search_params\": %s,\n  \"results\": [", header)
+	r := bufio.NewReaderSize(io.NewSectionReader(s.spool, 0, size), 1<<20)
+	for i := int64(0); ; i++ {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 {
+			if i > 0 {
+				bw.WriteByte(',')
+			}
+			bw.WriteString("\n    ")
+			bw.Write(line[:len(line)-1])
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			f.abort()
+			return 0, fmt.Errorf("reading spool: %w", err)
+		}
+	}
+	if count > 0 {
+		bw.WriteString("\n  ")
+	}
+	bw.WriteString("]\n}\n")
+	if err := bw.Flush(); err != nil {
+		f.abort()
+		return 0, fmt.Errorf("writing %s: %w", s.path, err)
+	}
+	return count, f.Commit()
+}
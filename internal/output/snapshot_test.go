@@ -0,0 +1,151 @@
+package output
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gyeh/npi-rates/internal/mrf"
+)
+
+// closeFailSink is a memSink whose Close fails.
+type closeFailSink struct{ memSink }
+
+func (f *closeFailSink) Close(mrf.SearchParams) error { return errors.New("disk full") }
+
+// openSnapshot returns an open SnapshotSink wrapping next, writing to
+// results.partial.json in a temp dir, with one batch written.
+func openSnapshot(t *testing.T, next Sink, every time.Duration) *SnapshotSink {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "results.partial.json")
+	s := NewSnapshotSink(next, path, every, func() mrf.SearchParams { return mrf.SearchParams{RunID: "r1"} })
+	if err := s.Open(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	err := s.WriteBatch([]mrf.RateResult{
+		{SourceFile: "a.json.gz", NPI: 1316924913, BillingCode: "99213"},
+		{SourceFile: "b.json.gz", NPI: 1316924913, BillingCode: "99214"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// readSnapshot parses the snapshot at path.
+func readSnapshot(t *testing.T, path string) mrf.SearchOutput {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("expected a snapshot: %v", err)
+	}
+	var out mrf.SearchOutput
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatalf("invalid snapshot: %v\n%s", err, data)
+	}
+	return out
+}
+
+// spools returns the spool files left beside path.
+func spools(t *testing.T, path string) []string {
+	t.Helper()
+	names, err := filepath.Glob(filepath.Join(filepath.Dir(path), ".*.spool*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func TestSnapshotSink(t *testing.T) {
+	next := &memSink{}
+	s := openSnapshot(t, next, 10*time.Millisecond)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(s.path); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no snapshot written")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	out := readSnapshot(t, s.path)
+	if out.SearchParams.RunID != "r1" || out.SearchParams.Status != mrf.StatusPartial ||
+		out.SearchParams.MatchedFiles != 2 || len(out.Results) != 2 {
+		t.Errorf("unexpected snapshot %+v", out)
+	}
+
+	if err := s.Close(mrf.SearchParams{RunID: "r1"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(next.results) != 2 || next.params == nil {
+		t.Errorf("expected the results passed on, got %d", len(next.results))
+	}
+	if _, err := os.Stat(s.path); !os.IsNotExist(err) {
+		t.Errorf("expected the snapshot removed after a good Close: %v", err)
+	}
+	if names := spools(t, s.path); len(names) != 0 {
+		t.Errorf("expected the spool removed, got %v", names)
+	}
+}
+
+// TestSnapshotSink_CloseFails verifies that a failed Close leaves a final
+// snapshot, and that Abort afterwards leaves it alone.
+func TestSnapshotSink_CloseFails(t *testing.T) {
+	s := openSnapshot(t, &closeFailSink{}, time.Hour)
+	if err := s.Close(mrf.SearchParams{}); err == nil || !strings.Contains(err.Error(), "disk full") {
+		t.Fatalf("expected the wrapped sink's error, got %v", err)
+	}
+	if out := readSnapshot(t, s.path); len(out.Results) != 2 {
+		t.Errorf("expected 2 results in the snapshot, got %d", len(out.Results))
+	}
+	if names := spools(t, s.path); len(names) != 0 {
+		t.Errorf("expected the spool removed, got %v", names)
+	}
+
+	if n, err := s.Abort(); n != 0 || err != nil {
+		t.Errorf("Abort after Close: %d, %v", n, err)
+	}
+	if out := readSnapshot(t, s.path); len(out.Results) != 2 {
+		t.Errorf("expected the snapshot kept, got %d results", len(out.Results))
+	}
+}
+
+func TestSnapshotSink_SnapshotFails(t *testing.T) {
+	s := openSnapshot(t, &closeFailSink{}, time.Hour)
+	// A directory in the snapshot's place can't be replaced.
+	if err := os.Mkdir(s.path, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	err := s.Close(mrf.SearchParams{})
+	if err == nil || !strings.Contains(err.Error(), "disk full") || !strings.Contains(err.Error(), "writing snapshot") {
+		t.Errorf("expected both errors, got %v", err)
+	}
+	if names := spools(t, s.path); len(names) != 0 {
+		t.Errorf("expected the spool removed, got %v", names)
+	}
+}
+
+func TestSnapshotSink_Abort(t *testing.T) {
+	next := &memSink{}
+	s := openSnapshot(t, next, time.Hour)
+	n, err := s.Abort()
+	if n != 2 || err != nil {
+		t.Fatalf("Abort: %d, %v", n, err)
+	}
+	if out := readSnapshot(t, s.path); len(out.Results) != 2 {
+		t.Errorf("expected 2 results in the snapshot, got %d", len(out.Results))
+	}
+	if names := spools(t, s.path); len(names) != 0 {
+		t.Errorf("expected the spool removed, got %v", names)
+	}
+	if next.params != nil {
+		t.Error("expected the wrapped sink not closed")
+	}
+}
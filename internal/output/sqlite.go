@@ -0,0 +1,249 @@
+package output
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/gyeh/npi-rates/internal/mrf"
+
+	// Registers the pure Go "sqlite" driver.
+	_ "modernc.org/sqlite"
+)
+
+// sqliteSchema normalizes results into providers and billing codes, each
+// stored once, and rates referencing them. The results view joins them back
+// into the flat RateResult shape.
+const sqliteSchema = `CREATE TABLE providers (
+	id        INTEGER PRIMARY KEY,
+	npi       INTEGER NOT NULL,
+	tin_type  TEXT NOT NULL,
+	tin_value TEXT NOT NULL
+);
+CREATE TABLE codes (
+	id                INTEGER PRIMARY KEY,
+	billing_code_type TEXT NOT NULL,
+	billing_code      TEXT NOT NULL,
+	description       TEXT NOT NULL
+);
+CREATE TABLE rates (
+	provider_id             INTEGER NOT NULL REFERENCES providers(id),
+	code_id                 INTEGER NOT NULL REFERENCES codes(id),
+	source_file             TEXT NOT NULL,
+	negotiation_arrangement TEXT NOT NULL,
+	negotiated_rate         REAL,          -- NULL where the file's rate is not a finite number
+	negotiated_type         TEXT NOT NULL,
+	unit                    TEXT NOT NULL, -- USD, percent, per_diem or unknown
+	billing_class           TEXT NOT NULL,
+	setting                 TEXT NOT NULL,
+	expiration_date         TEXT NOT NULL,
+	service_code            TEXT NOT NULL, -- '|'-joined, as in CSV output
+	billing_code_modifier   TEXT NOT NULL, -- '|'-joined
+	matched_by_tin          INTEGER NOT NULL,
+	npis                    TEXT NOT NULL, -- '|'-joined, set when collapsed per group
+	bundled_codes           TEXT NOT NULL, -- '|'-joined type:code, for bundle arrangements
+	covered_services        TEXT NOT NULL, -- '|'-joined type:code, for capitation arrangements
+	medicare_rate           REAL,          -- with --medicare-fee-schedule, where the code is listed
+	percent_of_medicare     REAL,
+	provider_group_id       INTEGER        -- with --provider-group-id
+);
+CREATE TABLE search_params (params TEXT NOT NULL); -- one row, JSON
+CREATE VIEW results AS
+	SELECT r.source_file, p.npi, p.tin_type, p.tin_value,
+		c.billing_code_type, c.billing_code, c.description AS billing_code_description,
+		r.negotiation_arrangement, r.negotiated_rate, r.negotiated_type, r.unit,
+		r.billing_class, r.setting, r.expiration_date,
+		r.service_code, r.billing_code_modifier, r.matched_by_tin, r.npis,
+		r.bundled_codes, r.covered_services, r.medicare_rate, r.percent_of_medicare,
+		r.provider_group_id
+	FROM rates r JOIN providers p ON p.id = r.provider_id JOIN codes c ON c.id = r.code_id;
+`
+
+// sqliteIndexes are created at Close, after the bulk insert, which is much
+// faster than maintaining them row by row.
+const sqliteIndexes = `CREATE INDEX providers_npi ON providers(npi);
+CREATE INDEX codes_billing_code ON codes(billing_code);
+CREATE INDEX rates_provider ON rates(provider_id);
+CREATE INDEX rates_code ON rates(code_id);
+`
+
+// SQLiteSink writes results into a SQLite database with providers, codes and
+// rates tables, indexed on NPI and billing code. The database is built
+// beside path in one transaction and renamed into place at Close, like the
+// other sinks.
+type SQLiteSink struct {
+	path string
+	f    *atomicFile
+	db   *sql.DB
+	tx   *sql.Tx
+
+	insertProvider, insertCode, insertRate *sql.Stmt
+
+	providers map[providerKey]int64
+	codes     map[codeKey]int64
+}
+
+type providerKey struct {
+	npi int64
+	tin mrf.TIN
+}
+
+type codeKey struct {
+	codeType, code string
+}
+
+// NewSQLiteSink returns a sink writing a SQLite database to path.
+func NewSQLiteSink(path string) *SQLiteSink {
+	return &SQLiteSink{path: path}
+}
+
+func (s *SQLiteSink) Open(ctx context.Context) error {
+	if s.path == "-" {
+		return fmt.Errorf("sqlite output needs a file path, not stdout")
+	}
+	f, err := createAtomic(ctx, s.path)
+	if err != nil {
+		return err
+	}
+	s.f = f
+	// The database is discarded rather than recovered if the run fails, so
+	// it needs no journal.
+	db, err := sql.Open("sqlite", "file:"+f.Name()+"?_pragma=journal_mode(OFF)&_pragma=synchronous(OFF)")
+	if err != nil {
+		f.abort()
+		return fmt.Errorf("opening %s: %w", s.path, err)
+	}
+	// One connection, so the transaction and its statements share it.
+	db.SetMaxOpenConns(1)
+	s.db = db
+	if s.tx, err = db.Begin(); err != nil {
+		return s.fail(err)
+	}
+	if _, err := s.tx.Exec(sqliteSchema); err != nil {
+		return s.fail(err)
+	}
+	for _, st := range []struct {
+		stmt **sql.Stmt
+		sql  string
+	}{
+		{&s.insertProvider, "INSERT INTO providers VALUES (?, ?, ?, ?)"},
+		{&s.insertCode, "INSERT INTO codes VALUES (?, ?, ?, ?)"},
+		{&s.insertRate, "INSERT INTO rates VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"},
+	} {
+		if *st.stmt, err = s.tx.Prepare(st.sql); err != nil {
+			return s.fail(err)
+		}
+	}
+	s.providers = make(map[providerKey]int64)
+	s.codes = make(map[codeKey]int64)
+	return nil
+}
+
+func (s *SQLiteSink) WriteBatch(results []mrf.RateResult) error {
+	for _, r := range results {
+		pk := providerKey{r.NPI, r.TIN}
+		pid, ok := s.providers[pk]
+		if !ok {
+			pid = int64(len(s.providers) + 1)
+			s.providers[pk] = pid
+			if _, err := s.insertProvider.Exec(pid, r.NPI, r.TIN.Type, r.TIN.Value); err != nil {
+				return s.fail(err)
+			}
+		}
+		ck := codeKey{r.BillingCodeType, r.BillingCode}
+		cid, ok := s.codes[ck]
+		if !ok {
+			cid = int64(len(s.codes) + 1)
+			s.codes[ck] = cid
+			if _, err := s.insertCode.Exec(cid, r.BillingCodeType, r.BillingCode, r.BillingCodeDescription); err != nil {
+				return s.fail(err)
+			}
+		}
+		_, err := s.insertRate.Exec(
+			pid, cid,
+			r.SourceFile,
+			r.NegotiationArrangement,
+			sqlRate(r.NegotiatedRate),
+			r.NegotiatedType,
+			r.Unit,
+			r.BillingClass,
+			r.Setting,
+			r.ExpirationDate,
+			strings.Join(r.ServiceCode, "|"),
+			strings.Join(r.BillingCodeModifier, "|"),
+			r.MatchedByTIN,
+			joinNPIs(r.NPIs),
+			joinServices(r.BundledCodes),
+			joinServices(r.CoveredServices),
+			sqlOptional(r.MedicareRate),
+			sqlOptional(r.PercentOfMedicare),
+			sqlOptionalInt(r.ProviderGroupID))
+		if err != nil {
+			return s.fail(err)
+		}
+	}
+	return nil
+}
+
+func (s *SQLiteSink) Close(params mrf.SearchParams) error {
+	data, err := json.Marshal(params)
+	if err != nil {
+		s.fail(err)
+		return fmt.Errorf("marshaling search params: %w", err)
+	}
+	for _, stmt := range []*sql.Stmt{s.insertProvider, s.insertCode, s.insertRate} {
+		stmt.Close()
+	}
+	if _, err := s.tx.Exec(sqliteIndexes); err != nil {
+		return s.fail(err)
+	}
+	if _, err := s.tx.Exec("INSERT INTO search_params VALUES (?)", string(data)); err != nil {
+		return s.fail(err)
+	}
+	if err := s.tx.Commit(); err != nil {
+		return s.fail(err)
+	}
+	if err := s.db.Close(); err != nil {
+		s.f.abort()
+		return fmt.Errorf("writing %s: %w", s.path, err)
+	}
+	return s.f.Commit()
+}
+
+// fail discards the partial database and returns err for its path.
+func (s *SQLiteSink) fail(err error) error {
+	if s.tx != nil {
+		s.tx.Rollback()
+	}
+	s.db.Close()
+	s.f.abort()
+	return fmt.Errorf("writing %s: %w", s.path, err)
+}
+
+// sqlRate returns a rate to store, or nil (NULL) for NaN and infinities.
+// SQLite would store NaN as NULL anyway; an infinite rate is no more useful.
+func sqlRate(v float64) any {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return nil
+	}
+	return v
+}
+
+// sqlOptional returns an optional amount to store, nil (NULL) when unset.
+func sqlOptional(v float64) any {
+	if v == 0 {
+		return nil
+	}
+	return sqlRate(v)
+}
+
+// sqlOptionalInt is sqlOptional for integral values, such as IDs.
+func sqlOptionalInt(v float64) any {
+	if v == 0 {
+		return nil
+	}
+	return int64(v)
+}
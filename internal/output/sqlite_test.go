@@ -0,0 +1,129 @@
+package output
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gyeh/npi-rates/internal/mrf"
+)
+
+func TestSQLiteSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.db")
+	tin := mrf.TIN{Type: "ein", Value: "16-0960964"}
+	results := []mrf.RateResult{
+		{SourceFile: "a.json.gz", NPI: 1316924913, TIN: tin, BillingCodeType: "CPT", BillingCode: "99213",
+			BillingCodeDescription: "Office visit, patient's established", NegotiationArrangement: "ffs",
+			NegotiatedRate: 125.5, NegotiatedType: "negotiated", Unit: "USD", BillingClass: "professional",
+			ServiceCode: []string{"11", "22"}, MedicareRate: 100, PercentOfMedicare: 125.5},
+		{SourceFile: "a.json.gz", NPI: 1316924913, TIN: tin, BillingCodeType: "CPT", BillingCode: "99213",
+			NegotiationArrangement: "ffs", NegotiatedRate: math.NaN(), Unit: "USD", ProviderGroupID: 1e12},
+		{SourceFile: "a.json.gz", NPI: 1316924913, TIN: tin, BillingCodeType: "CPT", BillingCode: "99214",
+			NegotiationArrangement: "ffs", NegotiatedRate: math.Inf(1), Unit: "USD", MatchedByTIN: true},
+	}
+
+	s := NewSQLiteSink(path)
+	if err := s.Open(context.Background()); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if err := s.WriteBatch(results[:1]); err != nil {
+		t.Fatalf("WriteBatch: %v", err)
+	}
+	if err := s.WriteBatch(results[1:]); err != nil {
+		t.Fatalf("WriteBatch: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("database visible before Close")
+	}
+	if err := s.Close(mrf.SearchParams{RunID: "run-1", NPIs: []int64{1316924913}}); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	db, err := sql.Open("sqlite", "file:"+path+"?mode=ro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var providers, codes int
+	if err := db.QueryRow("SELECT (SELECT count(*) FROM providers), (SELECT count(*) FROM codes)").Scan(&providers, &codes); err != nil {
+		t.Fatal(err)
+	}
+	if providers != 1 || codes != 2 {
+		t.Errorf("got %d providers and %d codes, want each stored once (1 and 2)", providers, codes)
+	}
+
+	rows, err := db.Query(`SELECT billing_code, billing_code_description, negotiated_rate, service_code,
+		matched_by_tin, medicare_rate, provider_group_id FROM results ORDER BY billing_code, provider_group_id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	type row struct {
+		code, desc, services string
+		rate, medicare       sql.NullFloat64
+		byTIN                bool
+		groupID              sql.NullInt64
+	}
+	var got []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.code, &r.desc, &r.rate, &r.services, &r.byTIN, &r.medicare, &r.groupID); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, r)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 {
+		t.Fatalf("got %d rows, want 3", len(got))
+	}
+	if r := got[0]; r.desc != "Office visit, patient's established" || r.rate.Float64 != 125.5 || r.services != "11|22" ||
+		r.medicare.Float64 != 100 || r.groupID.Valid {
+		t.Errorf("unexpected first row %+v", r)
+	}
+	if r := got[1]; r.rate.Valid || r.groupID.Int64 != 1e12 {
+		t.Errorf("want a NULL rate for NaN and the group ID in full, got %+v", r)
+	}
+	if r := got[2]; r.code != "99214" || r.rate.Valid || !r.byTIN || r.medicare.Valid {
+		t.Errorf("want a NULL rate for +Inf, got %+v", r)
+	}
+
+	var data string
+	if err := db.QueryRow("SELECT params FROM search_params").Scan(&data); err != nil {
+		t.Fatal(err)
+	}
+	var params mrf.SearchParams
+	if err := json.Unmarshal([]byte(data), &params); err != nil || params.RunID != "run-1" {
+		t.Errorf("search_params = %s (%v)", data, err)
+	}
+}
+
+func TestSQLiteSink_FailedWriteDiscarded(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "results.db")
+	s := NewSQLiteSink(path)
+	if err := s.Open(context.Background()); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	// Closing the transaction under the sink makes the next insert fail.
+	s.tx.Rollback()
+	if err := s.WriteBatch([]mrf.RateResult{{NPI: 1316924913, BillingCode: "99213"}}); err == nil {
+		t.Fatal("expected a write error")
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 0 {
+		t.Errorf("partial database left behind: %v", entries)
+	}
+}
+
+func TestSQLiteSink_Stdout(t *testing.T) {
+	if err := NewSQLiteSink("-").Open(context.Background()); err == nil {
+		t.Error("expected an error for stdout")
+	}
+}
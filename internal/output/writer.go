@@ -1,22 +1,47 @@
 package output
 
 import (
+	"bufio"
+	"context"
+	"encoding/csv"
 	"encoding/json"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/gyeh/npi-rates/internal/mrf"
 )
 
-// WriteResults writes the final JSON output to the specified file.
-func WriteResults(outputPath string, params mrf.SearchParams, results []mrf.RateResult) error {
-	if results == nil {
-		results = []mrf.RateResult{}
-	}
+// JSONSink writes the pretty-printed SearchOutput document. The document
+// carries search_params ahead of the results, so results are buffered until
+// Close.
+type JSONSink struct {
+	path    string
+	ctx     context.Context
+	results []mrf.RateResult
+}
+
+// NewJSONSink returns a sink writing a JSON document to path ("-" for stdout).
+func NewJSONSink(path string) *JSONSink {
+	return &JSONSink{path: path}
+}
+
+func (s *JSONSink) Open(ctx context.Context) error {
+	s.ctx = ctx
+	s.results = []mrf.RateResult{}
+	return nil
+}
+
+func (s *JSONSink) WriteBatch(results []mrf.RateResult) error {
+	s.results = append(s.results, results...)
+	return nil
+}
 
+func (s *JSONSink) Close(params mrf.SearchParams) error {
 	output := mrf.SearchOutput{
 		SearchParams: params,
-		Results:      results,
+		Results:      s.results,
 	}
 
 	data, err := json.MarshalIndent(output, "", "  ")
@@ -24,11 +49,201 @@ func WriteResults(outputPath string, params mrf.SearchParams, results []mrf.Rate
 		return fmt.Errorf("marshaling output: %w", err)
 	}
 
-	if outputPath == "-" {
+	if s.path == "-" {
 		_, err = os.Stdout.Write(data)
 		fmt.Fprintln(os.Stdout)
 		return err
 	}
 
-	return os.WriteFile(outputPath, data, 0o644)
+	return writeFileAtomic(s.ctx, s.path, data)
+}
+
+// NDJSONSink writes one RateResult per line as results arrive. Since the
+// search parameters are only final at Close, they go to a sidecar file
+// "<path>.params.json" (skipped when writing to stdout).
+type NDJSONSink struct {
+	path string
+	ctx  context.Context
+	f    outputFile
+	w    *bufio.Writer
+	enc  *json.Encoder
+}
+
+// NewNDJSONSink returns a sink writing newline-delimited JSON to path.
+func NewNDJSONSink(path string) *NDJSONSink {
+	return &NDJSONSink{path: path}
+}
+
+func (s *NDJSONSink) Open(ctx context.Context) error {
+	s.ctx = ctx
+	out, err := openOutput(ctx, s.path)
+	if err != nil {
+		return err
+	}
+	s.f = out
+	s.w = bufio.NewWriterSize(out, 1<<20)
+	s.enc = json.NewEncoder(s.w)
+	return nil
+}
+
+func (s *NDJSONSink) WriteBatch(results []mrf.RateResult) error {
+	for i := range results {
+		if err := s.enc.Encode(&results[i]); err != nil {
+			return fmt.Errorf("writing %s: %w", s.path, err)
+		}
+	}
+	return nil
+}
+
+func (s *NDJSONSink) Close(params mrf.SearchParams) error {
+	if err := s.w.Flush(); err != nil {
+		return fmt.Errorf("writing %s: %w", s.path, err)
+	}
+	if err := s.f.Commit(); err != nil {
+		return err
+	}
+	if s.path == "-" {
+		return nil
+	}
+	data, err := json.MarshalIndent(params, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshaling search params: %w", err)
+	}
+	return writeFileAtomic(s.ctx, sidecarPath(s.path, ".params.json"), data)
+}
+
+// csvHeader lists the CSV columns. TIN is split into type and value, and
+// the list-valued fields are joined with '|'. npis is only written when
+// results are collapsed per provider group (see SetCSVNPIs).
+var csvHeader = []string{
+	"source_file", "npi", "tin_type", "tin_value",
+	"billing_code_type", "billing_code", "billing_code_description",
+	"negotiation_arrangement", "negotiated_rate", "negotiated_type", "unit",
+	"billing_class", "setting", "expiration_date",
+	"service_code", "billing_code_modifier", "matched_by_tin", "npis",
+	"bundled_codes", "covered_services", "medicare_rate", "percent_of_medicare",
+	"provider_group_id",
+}
+
+// csvNPIs adds the npis column to CSV output.
+var csvNPIs bool
+
+// SetCSVNPIs adds the npis column, listing a collapsed result's NPIs, to CSV
+// outputs opened afterwards. Without --collapse-providers group it would
+// always be empty.
+func SetCSVNPIs(on bool) {
+	csvNPIs = on
+}
+
+// joinNPIs joins a collapsed result's NPIs with '|', like the other
+// list-valued columns.
+func joinNPIs(npis []int64) string {
+	strs := make([]string, len(npis))
+	for i, n := range npis {
+		strs[i] = strconv.FormatInt(n, 10)
+	}
+	return strings.Join(strs, "|")
+}
+
+// joinServices joins a bundle's services as type:code with '|'.
+func joinServices(services []mrf.CoveredService) string {
+	strs := make([]string, len(services))
+	for i, s := range services {
+		strs[i] = s.BillingCodeType + ":" + s.BillingCode
+	}
+	return strings.Join(strs, "|")
+}
+
+// formatOptional formats an optional amount, leaving it blank when unset.
+func formatOptional(v float64) string {
+	if v == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// CSVSink writes results as CSV rows with a header line. Search parameters
+// are not part of the CSV output.
+type CSVSink struct {
+	path string
+	f    outputFile
+	w    *csv.Writer
+	npis bool // write the npis column
+}
+
+// NewCSVSink returns a sink writing CSV to path ("-" for stdout).
+func NewCSVSink(path string) *CSVSink {
+	return &CSVSink{path: path}
+}
+
+func (s *CSVSink) Open(ctx context.Context) error {
+	out, err := openOutput(ctx, s.path)
+	if err != nil {
+		return err
+	}
+	s.f = out
+	s.w = csv.NewWriter(out)
+	s.npis = csvNPIs
+	header := csvHeader
+	if !s.npis {
+		header = withoutColumn(header, "npis")
+	}
+	return s.w.Write(header)
+}
+
+func (s *CSVSink) WriteBatch(results []mrf.RateResult) error {
+	for _, r := range results {
+		rec := []string{
+			r.SourceFile,
+			strconv.FormatInt(r.NPI, 10),
+			r.TIN.Type,
+			r.TIN.Value,
+			r.BillingCodeType,
+			r.BillingCode,
+			r.BillingCodeDescription,
+			r.NegotiationArrangement,
+			strconv.FormatFloat(r.NegotiatedRate, 'f', -1, 64),
+			r.NegotiatedType,
+			r.Unit,
+			r.BillingClass,
+			r.Setting,
+			r.ExpirationDate,
+			strings.Join(r.ServiceCode, "|"),
+			strings.Join(r.BillingCodeModifier, "|"),
+			strconv.FormatBool(r.MatchedByTIN),
+		}
+		if s.npis {
+			rec = append(rec, joinNPIs(r.NPIs))
+		}
+		rec = append(rec,
+			joinServices(r.BundledCodes),
+			joinServices(r.CoveredServices),
+			formatOptional(r.MedicareRate),
+			formatOptional(r.PercentOfMedicare),
+			formatOptional(r.ProviderGroupID),
+		)
+		if err := s.w.Write(rec); err != nil {
+			return fmt.Errorf("writing %s: %w", s.path, err)
+		}
+	}
+	return nil
+}
+
+// withoutColumn returns header without the named column.
+func withoutColumn(header []string, name string) []string {
+	out := make([]string, 0, len(header))
+	for _, c := range header {
+		if c != name {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+func (s *CSVSink) Close(mrf.SearchParams) error {
+	s.w.Flush()
+	if err := s.w.Error(); err != nil {
+		return fmt.Errorf("writing %s: %w", s.path, err)
+	}
+	return s.f.Commit()
 }
@@ -0,0 +1,58 @@
+package output
+
+import (
+	"context"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/gyeh/npi-rates/internal/mrf"
+)
+
+// TestCSVSink_NPIsColumn verifies that the npis column is written only for
+// collapsed results.
+func TestCSVSink_NPIsColumn(t *testing.T) {
+	defer SetCSVNPIs(false)
+	result := mrf.RateResult{NPI: 1316924913, BillingCode: "99213", NegotiatedRate: 125.5,
+		NPIs: []int64{1316924913, 5555555555}, ProviderGroupID: 7}
+
+	for _, collapsed := range []bool{false, true} {
+		SetCSVNPIs(collapsed)
+		path := filepath.Join(t.TempDir(), "results.csv")
+		s := NewCSVSink(path)
+		if err := s.Open(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.WriteBatch([]mrf.RateResult{result}); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Close(mrf.SearchParams{}); err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records, err := csv.NewReader(f).ReadAll()
+		f.Close()
+		if err != nil {
+			t.Fatalf("collapsed=%v: %v", collapsed, err)
+		}
+		if len(records) != 2 {
+			t.Fatalf("collapsed=%v: got %d records, want header and one row", collapsed, len(records))
+		}
+		header, row := records[0], records[1]
+		i := slices.Index(header, "npis")
+		if (i >= 0) != collapsed {
+			t.Errorf("collapsed=%v: header %v", collapsed, header)
+		}
+		if collapsed && row[i] != "1316924913|5555555555" {
+			t.Errorf("npis = %q", row[i])
+		}
+		if last := len(header) - 1; header[last] != "provider_group_id" || row[last] != "7" {
+			t.Errorf("collapsed=%v: columns misaligned: %v / %v", collapsed, header, row)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package progress
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gyeh/npi-rates/internal/humanize"
+)
+
+const (
+	// lowSpaceFraction and lowSpaceBytes: a volume is low on space when
+	// free space drops below either.
+	lowSpaceFraction = 0.10
+	lowSpaceBytes    = 5 << 30
+	// lowInodeFraction: a volume is low on inodes below this fraction free.
+	// The split stage creates many files, so inodes can run out first.
+	lowInodeFraction = 0.10
+)
+
+// volume is one filesystem being watched, identified by device so that
+// several temp dirs on the same filesystem are reported once.
+type volume struct {
+	path     string
+	dev      uint64
+	baseline uint64 // used bytes when monitoring started
+	peak     uint64 // peak growth over baseline
+}
+
+// volumeSample is a point-in-time reading of a volume.
+type volumeSample struct {
+	Path        string
+	Free        uint64 // bytes available to unprivileged users
+	Total       uint64
+	Delta       uint64 // growth in used bytes since monitoring started
+	Peak        uint64
+	FreeInodes  uint64
+	TotalInodes uint64 // 0 when the filesystem doesn't report inodes
+}
+
+// diskSample is one reading of all watched volumes plus the bytes held in
+// each tracker's work dir.
+type diskSample struct {
+	Elapsed  time.Duration
+	Volumes  []volumeSample
+	Workers  map[string]uint64 // tracker name → bytes under its work dir
+	Warnings []string          // thresholds crossed since the last sample
+}
+
+// diskMonitor samples the temp volumes a run writes to, attributes usage to
+// the files being processed via the work dirs their trackers report, and
+// raises each low-space/low-inode warning once. The zero value is ready to
+// use, so managers embed it directly.
+type diskMonitor struct {
+	mu       sync.Mutex
+	workDirs map[string]string // tracker name → work dir
+	warned   map[string]bool
+	stop     chan struct{}
+}
+
+// setWorkDir records the directory holding a tracker's intermediate files;
+// an empty dir clears it.
+func (d *diskMonitor) setWorkDir(name, dir string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if dir == "" {
+		delete(d.workDirs, name)
+		return
+	}
+	if d.workDirs == nil {
+		d.workDirs = make(map[string]string)
+	}
+	d.workDirs[name] = dir
+}
+
+// start samples dirs every interval, passing each sample to report, until
+// stopMonitor is called.
+func (d *diskMonitor) start(dirs []string, interval time.Duration, report func(diskSample)) {
+	vols := watchVolumes(dirs)
+	stop := make(chan struct{})
+	d.mu.Lock()
+	d.stop = stop
+	d.mu.Unlock()
+
+	startTime := time.Now()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			report(d.sample(vols, startTime))
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// stopMonitor stops a monitor started with start. It is safe to call when
+// none is running.
+func (d *diskMonitor) stopMonitor() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.stop != nil {
+		close(d.stop)
+		d.stop = nil
+	}
+}
+
+// watchVolumes resolves dirs to distinct volumes and records their
+// baseline usage.
+func watchVolumes(dirs []string) []*volume {
+	var vols []*volume
+	seen := make(map[uint64]bool)
+	for _, dir := range dirs {
+		var st syscall.Stat_t
+		if syscall.Stat(dir, &st) != nil {
+			continue
+		}
+		dev := uint64(st.Dev)
+		if seen[dev] {
+			continue
+		}
+		seen[dev] = true
+		v := &volume{path: dir, dev: dev}
+		var fsStat syscall.Statfs_t
+		if syscall.Statfs(dir, &fsStat) == nil {
+			v.baseline = (fsStat.Blocks - fsStat.Bavail) * uint64(fsStat.Bsize)
+		}
+		vols = append(vols, v)
+	}
+	return vols
+}
+
+func (d *diskMonitor) sample(vols []*volume, startTime time.Time) diskSample {
+	s := diskSample{Elapsed: time.Since(startTime).Truncate(time.Second)}
+	for _, v := range vols {
+		var st syscall.Statfs_t
+		if syscall.Statfs(v.path, &st) != nil {
+			continue
+		}
+		bsize := uint64(st.Bsize)
+		used := (st.Blocks - st.Bavail) * bsize
+		var delta uint64
+		if used > v.baseline {
+			delta = used - v.baseline
+		}
+		if delta > v.peak {
+			v.peak = delta
+		}
+		s.Volumes = append(s.Volumes, volumeSample{
+			Path:        v.path,
+			Free:        st.Bavail * bsize,
+			Total:       st.Blocks * bsize,
+			Delta:       delta,
+			Peak:        v.peak,
+			FreeInodes:  st.Ffree,
+			TotalInodes: st.Files,
+		})
+	}
+
+	d.mu.Lock()
+	dirs := make(map[string]string, len(d.workDirs))
+	for name, dir := range d.workDirs {
+		dirs[name] = dir
+	}
+	d.mu.Unlock()
+	if len(dirs) > 0 {
+		s.Workers = make(map[string]uint64, len(dirs))
+		for name, dir := range dirs {
+			s.Workers[name] = dirSize(dir)
+		}
+	}
+
+	s.Warnings = d.newWarnings(s.Volumes)
+	return s
+}
+
+// newWarnings returns warnings for thresholds crossed that have not been
+// reported before.
+func (d *diskMonitor) newWarnings(vols []volumeSample) []string {
+	var out []string
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.warned == nil {
+		d.warned = make(map[string]bool)
+	}
+	warn := func(key, msg string) {
+		if !d.warned[key] {
+			d.warned[key] = true
+			out = append(out, msg)
+		}
+	}
+	for _, v := range vols {
+		if v.Total > 0 && (v.Free < lowSpaceBytes || float64(v.Free) < lowSpaceFraction*float64(v.Total)) {
+			warn("space:"+v.Path, fmt.Sprintf("Low disk space on %s: %s free of %s",
+				v.Path, humanize.Bytes(v.Free), humanize.Bytes(v.Total)))
+		}
+		if v.TotalInodes > 0 && float64(v.FreeInodes) < lowInodeFraction*float64(v.TotalInodes) {
+			warn("inodes:"+v.Path, fmt.Sprintf("Low inodes on %s: %s free of %s",
+				v.Path, humanize.Count(int64(v.FreeInodes)), humanize.Count(int64(v.TotalInodes))))
+		}
+	}
+	return out
+}
+
+// dirSize sums the sizes of regular files under dir.
+func dirSize(dir string) uint64 {
+	var total uint64
+	filepath.WalkDir(dir, func(_ string, e fs.DirEntry, err error) error {
+		if err != nil {
+			return nil // files come and go while workers run
+		}
+		if e.Type().IsRegular() {
+			if info, err := e.Info(); err == nil {
+				total += uint64(info.Size())
+			}
+		}
+		return nil
+	})
+	return total
+}
+
+// String renders the sample as a one-line status: per-volume usage, inode
+// headroom when it is getting tight, and the largest work dirs.
+func (s diskSample) String() string {
+	parts := []string{fmt.Sprintf("Elapsed: %s", s.Elapsed)}
+	for _, v := range s.Volumes {
+		label := "Disk"
+		if len(s.Volumes) > 1 {
+			label = "Disk " + v.Path
+		}
+		line := fmt.Sprintf("%s: %s used (peak %s), %s free",
+			label, humanize.Bytes(v.Delta), humanize.Bytes(v.Peak), humanize.Bytes(v.Free))
+		if v.TotalInodes > 0 && v.FreeInodes < v.TotalInodes/2 {
+			line += fmt.Sprintf(", %.0f%% inodes free", 100*float64(v.FreeInodes)/float64(v.TotalInodes))
+		}
+		parts = append(parts, line)
+	}
+	if top := s.topWorkers(3); top != "" {
+		parts = append(parts, top)
+	}
+	return strings.Join(parts, "  |  ")
+}
+
+// topWorkers lists the n work dirs using the most space.
+func (s diskSample) topWorkers(n int) string {
+	type usage struct {
+		name  string
+		bytes uint64
+	}
+	var us []usage
+	for name, b := range s.Workers {
+		if b > 0 {
+			us = append(us, usage{name, b})
+		}
+	}
+	if len(us) == 0 {
+		return ""
+	}
+	sort.Slice(us, func(i, j int) bool { return us[i].bytes > us[j].bytes })
+	if len(us) > n {
+		us = us[:n]
+	}
+	items := make([]string, len(us))
+	for i, u := range us {
+		items[i] = fmt.Sprintf("%s %s", strings.TrimSpace(u.name), humanize.Bytes(u.bytes))
+	}
+	return "Top: " + strings.Join(items, ", ")
+}
@@ -0,0 +1,187 @@
+package progress
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// jsonInterval is the minimum time between progress and counter events for
+// one file; stage changes, warnings and completion are never throttled.
+const jsonInterval = time.Second
+
+// Event is one line of the --progress-json stream. Event says which kind it
+// is; the other fields are set as they apply to that kind:
+//
+//	stage         File, Index, Total, Stage
+//	progress      File, Index, Total, Stage, Current, Size, Percent (if Size is known)
+//	counter       File, Index, Total, Stage, Counter, Value
+//	warning       Message, and File, Index, Total unless it is about the run (e.g. disk space)
+//	file_done     File, Index, Total, Seconds, FilesComplete
+//	phase_start   Phase
+//	phase_update  Phase, Message (the phase's detail, e.g. "12/40 shards done")
+//	phase_done    Phase, Message, Seconds, Error (if it failed)
+//	overall       FilesComplete, FilesMatched, Rates
+//	complete      Status, FilesSearched, FilesMatched, Rates, Seconds, Error
+type Event struct {
+	Time  time.Time `json:"ts"`
+	RunID string    `json:"run_id,omitempty"`
+	Event string    `json:"event"`
+
+	File  string `json:"file,omitempty"`
+	Index int    `json:"index,omitempty"` // 1-based
+	Total int    `json:"total,omitempty"`
+	Stage string `json:"stage,omitempty"`
+
+	Current int64   `json:"current,omitempty"`
+	Size    int64   `json:"size,omitempty"`
+	Percent float64 `json:"percent,omitempty"`
+	Counter string  `json:"counter,omitempty"`
+	Value   int64   `json:"value,omitempty"`
+
+	Phase   string  `json:"phase,omitempty"`
+	Message string  `json:"message,omitempty"`
+	Seconds float64 `json:"seconds,omitempty"`
+	Error   string  `json:"error,omitempty"`
+
+	Status        string `json:"status,omitempty"`
+	FilesSearched int    `json:"files_searched,omitempty"`
+	FilesComplete int    `json:"files_complete,omitempty"`
+	FilesMatched  int    `json:"files_matched,omitempty"`
+	Rates         int64  `json:"rates,omitempty"`
+}
+
+// JSONManager implements Manager by writing Events as NDJSON, for wrappers
+// that track a run programmatically instead of parsing log lines.
+type JSONManager struct {
+	runID     string
+	mu        sync.Mutex
+	enc       *json.Encoder
+	completed int32
+	disk      diskMonitor
+}
+
+// NewJSONManager returns a manager writing events for run runID to w.
+func NewJSONManager(w io.Writer, runID string) *JSONManager {
+	return &JSONManager{runID: runID, enc: json.NewEncoder(w)}
+}
+
+// Emit writes e, stamping its time and run ID. It is safe for concurrent use.
+func (m *JSONManager) Emit(e Event) {
+	e.Time = time.Now().UTC()
+	e.RunID = m.runID
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.enc.Encode(e)
+}
+
+func (m *JSONManager) NewTracker(index, total int, filename string) Tracker {
+	return &jsonTracker{mgr: m, index: index + 1, total: total, name: filename, start: time.Now()}
+}
+
+func (m *JSONManager) Wait() {}
+
+func (m *JSONManager) SetOverallStats(filesComplete, filesMatched int, totalRates int64) {
+	m.Emit(Event{Event: "overall", FilesComplete: filesComplete, FilesMatched: filesMatched, Rates: totalRates})
+}
+
+// StartDiskMonitor emits low-space and low-inode warnings for tmpDirs.
+func (m *JSONManager) StartDiskMonitor(tmpDirs []string) {
+	m.disk.start(tmpDirs, 5*time.Second, func(s diskSample) {
+		for _, w := range s.Warnings {
+			m.Emit(Event{Event: "warning", Message: "disk: " + w})
+		}
+	})
+}
+
+func (m *JSONManager) StopDiskMonitor() { m.disk.stopMonitor() }
+
+// StartPhase emits a phase event when the phase starts, on each update, and
+// when it ends.
+func (m *JSONManager) StartPhase(name string) Phase {
+	m.Emit(Event{Event: "phase_start", Phase: name})
+	return &jsonPhase{phaseState: newPhaseState(name), mgr: m}
+}
+
+type jsonPhase struct {
+	*phaseState
+	mgr *JSONManager
+}
+
+func (p *jsonPhase) Update(detail string) {
+	p.phaseState.Update(detail)
+	p.mgr.Emit(Event{Event: "phase_update", Phase: p.name, Message: detail})
+}
+
+func (p *jsonPhase) Done(err error) {
+	p.once.Do(func() {
+		e := Event{Event: "phase_done", Phase: p.name, Message: p.detail.Load().(string), Seconds: time.Since(p.start).Seconds()}
+		if err != nil {
+			e.Error = err.Error()
+		}
+		p.mgr.Emit(e)
+	})
+}
+
+type jsonTracker struct {
+	mgr     *JSONManager
+	index   int
+	total   int
+	name    string
+	start   time.Time
+	stage   string
+	lastLog time.Time
+}
+
+func (t *jsonTracker) event(kind string) Event {
+	return Event{Event: kind, File: t.name, Index: t.index, Total: t.total, Stage: t.stage}
+}
+
+func (t *jsonTracker) SetStage(stage string) {
+	t.stage = stage
+	t.lastLog = time.Time{}
+	t.mgr.Emit(t.event("stage"))
+}
+
+func (t *jsonTracker) SetProgress(current, total int64) {
+	now := time.Now()
+	if now.Sub(t.lastLog) < jsonInterval {
+		return
+	}
+	t.lastLog = now
+	e := t.event("progress")
+	e.Current, e.Size = current, total
+	if total > 0 {
+		e.Percent = float64(current) / float64(total) * 100
+	}
+	t.mgr.Emit(e)
+}
+
+func (t *jsonTracker) SetCounter(name string, value int64) {
+	now := time.Now()
+	if now.Sub(t.lastLog) < jsonInterval {
+		return
+	}
+	t.lastLog = now
+	e := t.event("counter")
+	e.Counter, e.Value = name, value
+	t.mgr.Emit(e)
+}
+
+func (t *jsonTracker) LogWarning(msg string) {
+	e := t.event("warning")
+	e.Message = msg
+	t.mgr.Emit(e)
+}
+
+func (t *jsonTracker) SetWorkDir(dir string) { t.mgr.disk.setWorkDir(t.name, dir) }
+
+func (t *jsonTracker) Done() {
+	e := t.event("file_done")
+	e.Stage = ""
+	e.Seconds = time.Since(t.start).Seconds()
+	e.FilesComplete = int(atomic.AddInt32(&t.mgr.completed, 1))
+	t.mgr.Emit(e)
+}
@@ -0,0 +1,100 @@
+package progress
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// readEvents parses the NDJSON a JSONManager wrote.
+func readEvents(t *testing.T, data []byte) []Event {
+	t.Helper()
+	var events []Event
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		var e Event
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			t.Fatalf("line %q: %v", sc.Text(), err)
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+func TestJSONManager(t *testing.T) {
+	var buf bytes.Buffer
+	m := NewJSONManager(&buf, "run-1")
+
+	tr := m.NewTracker(0, 2, "a.json.gz")
+	tr.SetStage("downloading")
+	tr.SetProgress(50, 200)
+	tr.SetProgress(100, 200)  // within jsonInterval of the last: dropped
+	tr.SetCounter("rates", 7) // likewise
+	tr.LogWarning("slow host")
+	tr.Done()
+	ph := m.StartPhase("NPPES lookup")
+	ph.Update("1/2 NPIs")
+	ph.Done(errors.New("registry down"))
+	ph.Done(nil) // no effect
+	m.SetOverallStats(1, 1, 7)
+	m.Emit(Event{Event: "complete", Status: "complete", Rates: 7})
+
+	events := readEvents(t, buf.Bytes())
+	var kinds []string
+	for _, e := range events {
+		kinds = append(kinds, e.Event)
+		if e.RunID != "run-1" || e.Time.IsZero() {
+			t.Errorf("%s event not stamped: %+v", e.Event, e)
+		}
+	}
+	want := []string{"stage", "progress", "warning", "file_done", "phase_start", "phase_update", "phase_done", "overall", "complete"}
+	if len(kinds) != len(want) {
+		t.Fatalf("events %v, want %v", kinds, want)
+	}
+	for i := range want {
+		if kinds[i] != want[i] {
+			t.Fatalf("events %v, want %v", kinds, want)
+		}
+	}
+
+	if e := events[1]; e.File != "a.json.gz" || e.Index != 1 || e.Total != 2 || e.Stage != "downloading" ||
+		e.Current != 50 || e.Size != 200 || e.Percent != 25 {
+		t.Errorf("unexpected progress event %+v", e)
+	}
+	if e := events[2]; e.Message != "slow host" || e.File != "a.json.gz" {
+		t.Errorf("unexpected warning event %+v", e)
+	}
+	if e := events[3]; e.FilesComplete != 1 || e.Stage != "" {
+		t.Errorf("unexpected file_done event %+v", e)
+	}
+	if e := events[6]; e.Phase != "NPPES lookup" || e.Message != "1/2 NPIs" || e.Error != "registry down" {
+		t.Errorf("unexpected phase_done event %+v", e)
+	}
+}
+
+// TestJSONManager_StageResetsThrottle verifies that a stage change is
+// followed at once by that stage's progress.
+func TestJSONManager_StageResetsThrottle(t *testing.T) {
+	var buf bytes.Buffer
+	m := NewJSONManager(&buf, "")
+	tr := m.NewTracker(0, 1, "a.json.gz")
+	tr.SetStage("downloading")
+	tr.SetProgress(1, 0)
+	tr.SetStage("parsing")
+	tr.SetProgress(2, 0)
+
+	var progress int
+	for _, e := range readEvents(t, buf.Bytes()) {
+		if e.Event == "progress" {
+			progress++
+			if e.Percent != 0 || e.Size != 0 {
+				t.Errorf("percent set for an unknown size: %+v", e)
+			}
+		}
+	}
+	if progress != 2 {
+		t.Errorf("got %d progress events, want one per stage", progress)
+	}
+}
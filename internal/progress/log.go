@@ -6,13 +6,15 @@ import (
 	"strings"
 	"sync/atomic"
 	"time"
+
+	"github.com/gyeh/npi-rates/internal/humanize"
 )
 
 // LogManager implements Manager with throttled line-based output for
 // non-TTY environments (e.g. Modal, Fargate, CI). Prints periodic
 // status lines instead of interactive progress bars.
 type LogManager struct {
-	diskStop  chan struct{}
+	disk      diskMonitor
 	completed int32
 	totalURLs int32
 	taskID    string
@@ -51,9 +53,22 @@ func (m *LogManager) Wait() {}
 
 func (m *LogManager) SetOverallStats(filesComplete, filesMatched int, totalRates int64) {}
 
-func (m *LogManager) StartDiskMonitor(tmpDir string) {}
+// StartDiskMonitor logs a disk usage line every logInterval, and low-space
+// and low-inode warnings as soon as they are seen.
+func (m *LogManager) StartDiskMonitor(tmpDirs []string) {
+	var last time.Time
+	m.disk.start(tmpDirs, 5*time.Second, func(s diskSample) {
+		for _, w := range s.Warnings {
+			m.log("[disk] WARN: " + w)
+		}
+		if time.Since(last) >= logInterval {
+			last = time.Now()
+			m.log("[disk] " + s.String())
+		}
+	})
+}
 
-func (m *LogManager) StopDiskMonitor() {}
+func (m *LogManager) StopDiskMonitor() { m.disk.stopMonitor() }
 
 func (m *LogManager) log(msg string) {
 	ts := time.Now().Format("15:04:05")
@@ -107,8 +122,7 @@ func (t *logTracker) SetProgress(current, total int64) {
 	if !t.prevTime.IsZero() {
 		elapsed := now.Sub(t.prevTime).Seconds()
 		if elapsed > 0 {
-			mbps := float64(current-t.prevBytes) / elapsed / (1024 * 1024)
-			speedStr = fmt.Sprintf("  %.1f MB/s", mbps)
+			speedStr = "  " + humanize.Rate(float64(current-t.prevBytes)/elapsed)
 		}
 	}
 	t.prevBytes = current
@@ -117,9 +131,9 @@ func (t *logTracker) SetProgress(current, total int64) {
 
 	if total > 0 {
 		pct := float64(current) / float64(total) * 100
-		t.log(fmt.Sprintf("%s  %s / %s (%.0f%%)%s", t.stage, humanBytes(current), humanBytes(total), pct, speedStr))
+		t.log(fmt.Sprintf("%s  %s / %s (%.0f%%)%s", t.stage, humanize.SignedBytes(current), humanize.SignedBytes(total), pct, speedStr))
 	} else if current > 0 {
-		t.log(fmt.Sprintf("%s  %s%s", t.stage, humanBytes(current), speedStr))
+		t.log(fmt.Sprintf("%s  %s%s", t.stage, humanize.SignedBytes(current), speedStr))
 	}
 }
 
@@ -128,13 +142,17 @@ func (t *logTracker) SetCounter(name string, value int64) {
 		return
 	}
 	t.lastLog = time.Now()
-	t.log(fmt.Sprintf("%s  %s: %s", t.stage, name, humanCount(value)))
+	t.log(fmt.Sprintf("%s  %s: %s", t.stage, name, humanize.Count(value)))
 }
 
 func (t *logTracker) LogWarning(msg string) {
 	t.log("WARN: " + msg)
 }
 
+func (t *logTracker) SetWorkDir(dir string) {
+	t.mgr.disk.setWorkDir(t.name, dir)
+}
+
 func (t *logTracker) Done() {
 	done := atomic.AddInt32(&t.mgr.completed, 1)
 	total := atomic.LoadInt32(&t.mgr.totalURLs)
@@ -0,0 +1,143 @@
+package progress
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gyeh/npi-rates/internal/logx"
+	"github.com/vbauerster/mpb/v8"
+	"github.com/vbauerster/mpb/v8/decor"
+)
+
+// Phase tracks one run-level orchestration step — TOC resolution, NPPES
+// lookup, chunk upload, task launch, result collection — as opposed to the
+// per-file work a Tracker follows. Every Manager renders phases in its own
+// style so these steps look the same as the rest of the run's output.
+type Phase interface {
+	// Update replaces the phase's detail text (e.g. "12/40 shards done").
+	Update(detail string)
+	// Done ends the phase. A nil err marks it successful. Calling Done more
+	// than once has no effect.
+	Done(err error)
+}
+
+// phaseSummary formats the line printed when a phase ends.
+func phaseSummary(name, detail string, elapsed time.Duration, err error) string {
+	elapsed = elapsed.Round(100 * time.Millisecond)
+	if err != nil {
+		return fmt.Sprintf("✗ %s failed after %s: %v", name, elapsed, err)
+	}
+	if detail != "" {
+		return fmt.Sprintf("✓ %s (%s, %s)", name, detail, elapsed)
+	}
+	return fmt.Sprintf("✓ %s (%s)", name, elapsed)
+}
+
+// phaseState is the bookkeeping shared by the Phase implementations.
+type phaseState struct {
+	name   string
+	start  time.Time
+	detail atomic.Value // string
+	once   sync.Once
+}
+
+func newPhaseState(name string) *phaseState {
+	s := &phaseState{name: name, start: time.Now()}
+	s.detail.Store("")
+	return s
+}
+
+func (s *phaseState) Update(detail string) { s.detail.Store(detail) }
+
+func (s *phaseState) summary(err error) string {
+	return phaseSummary(s.name, s.detail.Load().(string), time.Since(s.start), err)
+}
+
+// --- MPB ---
+
+// StartPhase shows the phase as a spinner line that is replaced by a
+// permanent summary line when it ends.
+func (m *MPBManager) StartPhase(name string) Phase {
+	p := &mpbPhase{phaseState: newPhaseState(name), mgr: m}
+	m.mu.Lock()
+	p.bar = m.container.AddSpinner(0,
+		mpb.PrependDecorators(decor.Name(name+" ")),
+		mpb.AppendDecorators(decor.Any(func(decor.Statistics) string {
+			return p.detail.Load().(string)
+		})),
+	)
+	m.mu.Unlock()
+	return p
+}
+
+type mpbPhase struct {
+	*phaseState
+	mgr *MPBManager
+	bar *mpb.Bar
+}
+
+func (p *mpbPhase) Done(err error) {
+	p.once.Do(func() {
+		p.mgr.mu.Lock()
+		defer p.mgr.mu.Unlock()
+		p.bar.Abort(true)
+		// As with LogWarning, a completed bar serves as a static log line.
+		line := p.mgr.container.AddBar(0, mpb.PrependDecorators(decor.Name(p.summary(err))))
+		line.Abort(false)
+	})
+}
+
+// --- Log ---
+
+// StartPhase logs a line when the phase starts and when it ends; updates
+// are logged as they arrive.
+func (m *LogManager) StartPhase(name string) Phase {
+	m.phaseLog(name + "...")
+	return &logPhase{phaseState: newPhaseState(name), mgr: m}
+}
+
+func (m *LogManager) phaseLog(msg string) {
+	if m.taskID != "" {
+		msg = fmt.Sprintf("[ID|%s] %s", m.taskID, msg)
+	}
+	m.log("[phase] " + msg)
+}
+
+type logPhase struct {
+	*phaseState
+	mgr *LogManager
+}
+
+func (p *logPhase) Update(detail string) {
+	p.phaseState.Update(detail)
+	p.mgr.phaseLog(p.name + ": " + detail)
+}
+
+func (p *logPhase) Done(err error) {
+	p.once.Do(func() { p.mgr.phaseLog(p.summary(err)) })
+}
+
+// --- Noop ---
+
+// StartPhase prints only the phase's summary line, and only failures when
+// Quiet is set.
+func (m *NoopManager) StartPhase(name string) Phase {
+	return &noopPhase{phaseState: newPhaseState(name), quiet: m.Quiet}
+}
+
+type noopPhase struct {
+	*phaseState
+	quiet bool
+}
+
+func (p *noopPhase) Done(err error) {
+	p.once.Do(func() {
+		if logx.IsQuiet() || (p.quiet && err == nil) {
+			return
+		}
+		fmt.Fprintln(os.Stderr, p.summary(err))
+	})
+}
@@ -2,11 +2,13 @@ package progress
 
 import (
 	"fmt"
+	"os"
 	"sync"
 	"sync/atomic"
-	"syscall"
 	"time"
 
+	"github.com/gyeh/npi-rates/internal/humanize"
+	"github.com/gyeh/npi-rates/internal/logx"
 	"github.com/vbauerster/mpb/v8"
 	"github.com/vbauerster/mpb/v8/decor"
 )
@@ -17,15 +19,20 @@ type Tracker interface {
 	SetProgress(current, total int64)
 	SetCounter(name string, value int64)
 	LogWarning(msg string)
+	// SetWorkDir reports the directory holding this file's intermediate
+	// data so the disk monitor can attribute usage to it ("" clears it).
+	SetWorkDir(dir string)
 	Done()
 }
 
-// Manager creates trackers for individual files.
+// Manager creates trackers for individual files and phases for the
+// run-level steps around them.
 type Manager interface {
 	NewTracker(index, total int, filename string) Tracker
+	StartPhase(name string) Phase
 	Wait()
 	SetOverallStats(filesComplete, filesMatched int, totalRates int64)
-	StartDiskMonitor(tmpDir string)
+	StartDiskMonitor(tmpDirs []string)
 	StopDiskMonitor()
 }
 
@@ -34,12 +41,14 @@ type MPBManager struct {
 	container  *mpb.Progress
 	mu         sync.Mutex
 	overallBar *mpb.Bar
-	diskStop   chan struct{}
+	diskBar    *mpb.Bar
+	disk       diskMonitor
 }
 
 // NewMPBManager creates a new mpb-based progress manager.
 func NewMPBManager() *MPBManager {
-	p := mpb.New(mpb.WithWidth(60))
+	// Bars go to stderr so stdout carries nothing but the result document.
+	p := mpb.New(mpb.WithWidth(60), mpb.WithOutput(os.Stderr))
 	return &MPBManager{container: p}
 }
 
@@ -86,8 +95,10 @@ func (m *MPBManager) SetOverallStats(filesComplete, filesMatched int, totalRates
 	// Could add an overall bar in the future
 }
 
-// StartDiskMonitor adds a status line showing real-time disk usage for tmpDir.
-func (m *MPBManager) StartDiskMonitor(tmpDir string) {
+// StartDiskMonitor adds a status line showing real-time usage of the temp
+// volumes and the work dirs using the most space. Low-space and low-inode
+// warnings are printed above the bars.
+func (m *MPBManager) StartDiskMonitor(tmpDirs []string) {
 	diskVal := &atomic.Value{}
 	diskVal.Store("")
 
@@ -100,54 +111,33 @@ func (m *MPBManager) StartDiskMonitor(tmpDir string) {
 		),
 	)
 	m.mu.Unlock()
+	m.diskBar = bar
 
-	m.diskStop = make(chan struct{})
-	startTime := time.Now()
-	// Snapshot initial usage to track delta from our process
-	var baselineUsed uint64
-	var stat0 syscall.Statfs_t
-	if syscall.Statfs(tmpDir, &stat0) == nil {
-		baselineUsed = (stat0.Blocks - stat0.Bavail) * uint64(stat0.Bsize)
-	}
-	var peakDelta uint64
-	go func() {
-		ticker := time.NewTicker(1 * time.Second)
-		defer ticker.Stop()
-		for {
-			elapsed := time.Since(startTime).Truncate(time.Second)
-			var stat syscall.Statfs_t
-			if err := syscall.Statfs(tmpDir, &stat); err == nil {
-				avail := stat.Bavail * uint64(stat.Bsize)
-				used := (stat.Blocks - stat.Bavail) * uint64(stat.Bsize)
-				delta := uint64(0)
-				if used > baselineUsed {
-					delta = used - baselineUsed
-				}
-				if delta > peakDelta {
-					peakDelta = delta
-				}
-				diskVal.Store(fmt.Sprintf("Elapsed: %s  |  Disk: %s used (peak %s), %s free",
-					elapsed, humanBytesUint(delta), humanBytesUint(peakDelta), humanBytesUint(avail)))
-			} else {
-				diskVal.Store(fmt.Sprintf("Elapsed: %s", elapsed))
-			}
-			select {
-			case <-ticker.C:
-			case <-m.diskStop:
-				bar.Abort(false)
-				return
-			}
+	m.disk.start(tmpDirs, time.Second, func(s diskSample) {
+		diskVal.Store(s.String())
+		for _, w := range s.Warnings {
+			m.logLine("  [disk] WARN: " + w)
 		}
-	}()
+	})
 }
 
 // StopDiskMonitor stops the disk usage monitor.
 func (m *MPBManager) StopDiskMonitor() {
-	if m.diskStop != nil {
-		close(m.diskStop)
+	m.disk.stopMonitor()
+	if m.diskBar != nil {
+		m.diskBar.Abort(false)
 	}
 }
 
+// logLine writes a persistent line above the progress bars; a completed bar
+// acts as a static log line.
+func (m *MPBManager) logLine(msg string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	line := m.container.AddBar(0, mpb.PrependDecorators(decor.Name(msg)))
+	line.Abort(false)
+}
+
 type mpbTracker struct {
 	bar         *mpb.Bar
 	index       int
@@ -198,35 +188,31 @@ func (t *mpbTracker) SetProgress(current, total int64) {
 		t.dlPrevTime = now
 	}
 	if t.dlSpeed > 0 {
-		speedStr = fmt.Sprintf("  %.1f MB/s", t.dlSpeed)
+		speedStr = "  " + humanize.Rate(t.dlSpeed*1024*1024)
 	}
 
 	if total > 0 {
 		pct := int64(float64(current) / float64(total) * 100)
 		t.bar.SetTotal(100, false)
 		t.bar.SetCurrent(pct)
-		t.detailPtr.Store(fmt.Sprintf("%s / %s%s", humanBytes(current), humanBytes(total), speedStr))
+		t.detailPtr.Store(fmt.Sprintf("%s / %s%s", humanize.SignedBytes(current), humanize.SignedBytes(total), speedStr))
 	} else if current > 0 {
 		// Unknown total (Content-Length missing)
-		t.detailPtr.Store(fmt.Sprintf("%s%s", humanBytes(current), speedStr))
+		t.detailPtr.Store(fmt.Sprintf("%s%s", humanize.SignedBytes(current), speedStr))
 	}
 }
 
 func (t *mpbTracker) SetCounter(name string, value int64) {
-	t.detailPtr.Store(fmt.Sprintf("%s: %s", name, humanCount(value)))
+	t.detailPtr.Store(fmt.Sprintf("%s: %s", name, humanize.Count(value)))
 }
 
 func (t *mpbTracker) LogWarning(msg string) {
 	// Write a persistent log line above the progress bars.
-	// mpb.AddBar with a completed bar acts as a static log line.
-	t.mgr.mu.Lock()
-	defer t.mgr.mu.Unlock()
-	logBar := t.mgr.container.AddBar(0,
-		mpb.PrependDecorators(
-			decor.Name(fmt.Sprintf("  [%s] %s", t.name, msg)),
-		),
-	)
-	logBar.Abort(false)
+	t.mgr.logLine(fmt.Sprintf("  [%s] %s", t.name, msg))
+}
+
+func (t *mpbTracker) SetWorkDir(dir string) {
+	t.mgr.disk.setWorkDir(t.name, dir)
 }
 
 func (t *mpbTracker) Done() {
@@ -235,11 +221,16 @@ func (t *mpbTracker) Done() {
 	t.bar.Abort(false) // complete without removing
 }
 
-// NoopManager is a no-op progress manager for non-interactive use.
+// NoopManager is a no-op progress manager for non-interactive use. Stage
+// changes and warnings are printed to stderr; with Quiet set, only warnings,
+// and with logx quiet, nothing.
 type NoopManager struct {
 	FilesComplete int32
 	FilesMatched  int32
 	TotalRates    int64
+	Quiet         bool
+
+	disk diskMonitor
 }
 
 func (m *NoopManager) NewTracker(index, total int, filename string) Tracker {
@@ -247,8 +238,20 @@ func (m *NoopManager) NewTracker(index, total int, filename string) Tracker {
 }
 
 func (m *NoopManager) Wait() {}
-func (m *NoopManager) StartDiskMonitor(tmpDir string) {}
-func (m *NoopManager) StopDiskMonitor()               {}
+
+// StartDiskMonitor prints low-space and low-inode warnings for tmpDirs.
+func (m *NoopManager) StartDiskMonitor(tmpDirs []string) {
+	m.disk.start(tmpDirs, 5*time.Second, func(s diskSample) {
+		if logx.IsQuiet() {
+			return
+		}
+		for _, w := range s.Warnings {
+			fmt.Fprintf(os.Stderr, "  [disk] WARN: %s\n", w)
+		}
+	})
+}
+
+func (m *NoopManager) StopDiskMonitor() { m.disk.stopMonitor() }
 
 func (m *NoopManager) SetOverallStats(filesComplete, filesMatched int, totalRates int64) {
 	atomic.StoreInt32(&m.FilesComplete, int32(filesComplete))
@@ -262,63 +265,19 @@ type noopTracker struct {
 }
 
 func (t *noopTracker) SetStage(stage string) {
-	fmt.Printf("  [%s] %s\n", t.name, stage)
+	if t.mgr.Quiet {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "  [%s] %s\n", t.name, stage)
 }
 
 func (t *noopTracker) SetProgress(current, total int64) {}
 func (t *noopTracker) SetCounter(name string, value int64) {}
 func (t *noopTracker) LogWarning(msg string) {
-	fmt.Printf("  [%s] WARN: %s\n", t.name, msg)
-}
-func (t *noopTracker) Done() {}
-
-// humanBytes formats a byte count as a human-readable string (e.g. "1.5 GB").
-func humanBytes(b int64) string {
-	const (
-		kb = 1024
-		mb = 1024 * kb
-		gb = 1024 * mb
-	)
-	switch {
-	case b >= gb:
-		return fmt.Sprintf("%.1f GB", float64(b)/float64(gb))
-	case b >= mb:
-		return fmt.Sprintf("%.1f MB", float64(b)/float64(mb))
-	case b >= kb:
-		return fmt.Sprintf("%.1f KB", float64(b)/float64(kb))
-	default:
-		return fmt.Sprintf("%d B", b)
-	}
-}
-
-func humanBytesUint(b uint64) string {
-	const (
-		kb uint64 = 1024
-		mb        = 1024 * kb
-		gb        = 1024 * mb
-		tb        = 1024 * gb
-	)
-	switch {
-	case b >= tb:
-		return fmt.Sprintf("%.1f TB", float64(b)/float64(tb))
-	case b >= gb:
-		return fmt.Sprintf("%.1f GB", float64(b)/float64(gb))
-	case b >= mb:
-		return fmt.Sprintf("%.1f MB", float64(b)/float64(mb))
-	case b >= kb:
-		return fmt.Sprintf("%.1f KB", float64(b)/float64(kb))
-	default:
-		return fmt.Sprintf("%d B", b)
-	}
-}
-
-// humanCount formats a number with comma separators (e.g. "1,234,567").
-func humanCount(n int64) string {
-	if n < 0 {
-		return "-" + humanCount(-n)
-	}
-	if n < 1000 {
-		return fmt.Sprintf("%d", n)
+	if logx.IsQuiet() {
+		return
 	}
-	return humanCount(n/1000) + fmt.Sprintf(",%03d", n%1000)
+	fmt.Fprintf(os.Stderr, "  [%s] WARN: %s\n", t.name, msg)
 }
+func (t *noopTracker) SetWorkDir(dir string) { t.mgr.disk.setWorkDir(t.name, dir) }
+func (t *noopTracker) Done()                  {}
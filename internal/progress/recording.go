@@ -0,0 +1,172 @@
+package progress
+
+import (
+	"sync"
+	"time"
+)
+
+// RecordingManager implements Manager by recording what it is told as
+// Events, printing nothing, so tests can assert on the stages, warnings and
+// counters a pipeline reports. Events are those of the --progress-json
+// stream, unthrottled and without Time or RunID, plus "work_dir" events
+// (File, Message) for SetWorkDir. It is safe for concurrent use.
+type RecordingManager struct {
+	mu      sync.Mutex
+	events  []Event
+	tmpDirs []string
+}
+
+func (m *RecordingManager) record(e Event) {
+	m.mu.Lock()
+	m.events = append(m.events, e)
+	m.mu.Unlock()
+}
+
+func (m *RecordingManager) NewTracker(index, total int, filename string) Tracker {
+	return &recordingTracker{mgr: m, index: index + 1, total: total, name: filename}
+}
+
+func (m *RecordingManager) StartPhase(name string) Phase {
+	m.record(Event{Event: "phase_start", Phase: name})
+	return &recordingPhase{phaseState: newPhaseState(name), mgr: m}
+}
+
+func (m *RecordingManager) Wait() {}
+
+func (m *RecordingManager) SetOverallStats(filesComplete, filesMatched int, totalRates int64) {
+	m.record(Event{Event: "overall", FilesComplete: filesComplete, FilesMatched: filesMatched, Rates: totalRates})
+}
+
+// StartDiskMonitor records tmpDirs (see TmpDirs); no monitor is started.
+func (m *RecordingManager) StartDiskMonitor(tmpDirs []string) {
+	m.mu.Lock()
+	m.tmpDirs = append([]string(nil), tmpDirs...)
+	m.mu.Unlock()
+}
+
+func (m *RecordingManager) StopDiskMonitor() {}
+
+// Events returns the events recorded so far, in order.
+func (m *RecordingManager) Events() []Event {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Event(nil), m.events...)
+}
+
+// FileEvents returns the events of the given kind recorded for file.
+func (m *RecordingManager) FileEvents(file, kind string) []Event {
+	var out []Event
+	for _, e := range m.Events() {
+		if e.File == file && e.Event == kind {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// Stages returns the stages file went through, in order.
+func (m *RecordingManager) Stages(file string) []string {
+	var out []string
+	for _, e := range m.FileEvents(file, "stage") {
+		out = append(out, e.Stage)
+	}
+	return out
+}
+
+// Warnings returns the warnings logged for file.
+func (m *RecordingManager) Warnings(file string) []string {
+	var out []string
+	for _, e := range m.FileEvents(file, "warning") {
+		out = append(out, e.Message)
+	}
+	return out
+}
+
+// Counter returns the values published for file's named counter, in order.
+func (m *RecordingManager) Counter(file, name string) []int64 {
+	var out []int64
+	for _, e := range m.FileEvents(file, "counter") {
+		if e.Counter == name {
+			out = append(out, e.Value)
+		}
+	}
+	return out
+}
+
+// TmpDirs returns the directories passed to StartDiskMonitor.
+func (m *RecordingManager) TmpDirs() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.tmpDirs...)
+}
+
+type recordingTracker struct {
+	mgr   *RecordingManager
+	index int
+	total int
+	name  string
+
+	mu    sync.Mutex
+	stage string
+}
+
+func (t *recordingTracker) event(kind string) Event {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return Event{Event: kind, File: t.name, Index: t.index, Total: t.total, Stage: t.stage}
+}
+
+func (t *recordingTracker) SetStage(stage string) {
+	t.mu.Lock()
+	t.stage = stage
+	t.mu.Unlock()
+	t.mgr.record(t.event("stage"))
+}
+
+func (t *recordingTracker) SetProgress(current, total int64) {
+	e := t.event("progress")
+	e.Current, e.Size = current, total
+	t.mgr.record(e)
+}
+
+func (t *recordingTracker) SetCounter(name string, value int64) {
+	e := t.event("counter")
+	e.Counter, e.Value = name, value
+	t.mgr.record(e)
+}
+
+func (t *recordingTracker) LogWarning(msg string) {
+	e := t.event("warning")
+	e.Message = msg
+	t.mgr.record(e)
+}
+
+func (t *recordingTracker) SetWorkDir(dir string) {
+	e := t.event("work_dir")
+	e.Message = dir
+	t.mgr.record(e)
+}
+
+func (t *recordingTracker) Done() {
+	t.mgr.record(t.event("file_done"))
+}
+
+type recordingPhase struct {
+	*phaseState
+	mgr *RecordingManager
+}
+
+func (p *recordingPhase) Update(detail string) {
+	p.phaseState.Update(detail)
+	p.mgr.record(Event{Event: "phase_update", Phase: p.name, Message: detail})
+}
+
+func (p *recordingPhase) Done(err error) {
+	p.once.Do(func() {
+		e := Event{Event: "phase_done", Phase: p.name, Message: p.detail.Load().(string), Seconds: time.Since(p.start).Seconds()}
+		if err != nil {
+			e.Error = err.Error()
+		}
+		p.mgr.record(e)
+	})
+}
@@ -0,0 +1,133 @@
+package refdata
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/gyeh/npi-rates/internal/mrf"
+)
+
+// DescriptionProvider looks up the description of a billing code. codeType
+// is the MRF billing_code_type, e.g. "CPT" or "HCPCS".
+type DescriptionProvider interface {
+	Description(codeType, code string) (string, bool)
+}
+
+// DescriptionFiller is an mrf.Enricher that fills in blank
+// billing_code_descriptions from Provider. Descriptions the payer gave are
+// kept.
+type DescriptionFiller struct {
+	Provider DescriptionProvider
+}
+
+func (f DescriptionFiller) Enrich(r *mrf.RateResult) {
+	if r.BillingCodeDescription != "" {
+		return
+	}
+	if d, ok := f.Provider.Description(r.BillingCodeType, r.BillingCode); ok {
+		r.BillingCodeDescription = d
+	}
+}
+
+// Column names accepted in a code description file (see normalizeHeader),
+// in order of preference: CMS's HCPCS files have both long and short
+// descriptions.
+var (
+	descriptionColumns = []string{"longdescription", "description", "desc", "shortdescription", "name"}
+	codeTypeColumns    = []string{"billingcodetype", "codetype", "type"}
+)
+
+// CodeTable is a DescriptionProvider backed by a CSV file.
+type CodeTable struct {
+	byType map[string]map[string]string // code type (upper case, "" for any) -> code -> description
+}
+
+// LoadCodeDescriptions reads a CSV of billing code descriptions with a
+// header row naming a code column (e.g. HCPCS, CPT or code) and a
+// description column (e.g. LONG DESCRIPTION or description), and optionally
+// a code type column restricting each row to that billing_code_type. Lines
+// before the header are skipped. Where a code appears more than once, as
+// with continuation lines in CMS's HCPCS file, the first row is used.
+func LoadCodeDescriptions(path string) (*CodeTable, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	cr := csv.NewReader(f)
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+
+	codeCol, descCol, typeCol := -1, -1, -1
+	for codeCol < 0 || descCol < 0 {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%s: no header row with code and description columns", path)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		codeCol, descCol, typeCol = findColumn(rec, codeColumns), findColumn(rec, descriptionColumns), findColumn(rec, codeTypeColumns)
+	}
+
+	t := &CodeTable{byType: make(map[string]map[string]string)}
+	n := 0
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if codeCol >= len(rec) || descCol >= len(rec) {
+			continue
+		}
+		code := strings.ToUpper(strings.TrimSpace(rec[codeCol]))
+		desc := strings.TrimSpace(rec[descCol])
+		if code == "" || desc == "" {
+			continue
+		}
+		var typ string
+		if typeCol >= 0 && typeCol < len(rec) {
+			typ = strings.ToUpper(strings.TrimSpace(rec[typeCol]))
+		}
+		codes := t.byType[typ]
+		if codes == nil {
+			codes = make(map[string]string)
+			t.byType[typ] = codes
+		}
+		if _, dup := codes[code]; !dup {
+			codes[code] = desc
+			n++
+		}
+	}
+	if n == 0 {
+		return nil, fmt.Errorf("%s: no code descriptions", path)
+	}
+	return t, nil
+}
+
+// Description returns the description of code, preferring one listed for
+// codeType over one listed without a type.
+func (t *CodeTable) Description(codeType, code string) (string, bool) {
+	code = strings.ToUpper(code)
+	if d, ok := t.byType[strings.ToUpper(codeType)][code]; ok {
+		return d, true
+	}
+	d, ok := t.byType[""][code]
+	return d, ok
+}
+
+// Len returns the number of descriptions in the table.
+func (t *CodeTable) Len() int {
+	n := 0
+	for _, codes := range t.byType {
+		n += len(codes)
+	}
+	return n
+}
@@ -0,0 +1,89 @@
+package refdata
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gyeh/npi-rates/internal/mrf"
+)
+
+func TestLoadCodeDescriptions(t *testing.T) {
+	// The layout of CMS's HCPCS file: title lines, then long and short
+	// descriptions, with continuation lines repeating the code.
+	path := writeCSV(t, `HCPCS 2024 Alpha-Numeric File
+HCPC,SEQNUM,LONG DESCRIPTION,SHORT DESCRIPTION
+a0021,00100,Ambulance service outside state per mile,Outside state ambulance serv
+A0021,00200,continued,
+A0080,00100,,Noninterest escort in non er
+,00100,No code,
+`)
+	tab, err := LoadCodeDescriptions(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tab.Len() != 1 {
+		t.Errorf("expected 1 description, got %d", tab.Len())
+	}
+	if d, ok := tab.Description("HCPCS", "A0021"); !ok || d != "Ambulance service outside state per mile" {
+		t.Errorf("got %q, %v", d, ok)
+	}
+	if _, ok := tab.Description("HCPCS", "A0080"); ok {
+		t.Error("expected a code with a blank long description to be skipped")
+	}
+}
+
+func TestLoadCodeDescriptions_CodeType(t *testing.T) {
+	tab, err := LoadCodeDescriptions(writeCSV(t, `code,type,description
+100,MS-DRG,Drug-eluting stent
+100,rc,Room and board
+99213,,Office visit
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		codeType, code, want string
+		ok                   bool
+	}{
+		{"MS-DRG", "100", "Drug-eluting stent", true},
+		{"RC", "100", "Room and board", true},
+		{"CPT", "100", "", false},
+		{"cpt", "99213", "Office visit", true}, // listed without a type
+	}
+	for _, tt := range tests {
+		if d, ok := tab.Description(tt.codeType, tt.code); d != tt.want || ok != tt.ok {
+			t.Errorf("Description(%q, %q) = %q, %v; want %q, %v", tt.codeType, tt.code, d, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestLoadCodeDescriptions_Errors(t *testing.T) {
+	tests := []struct {
+		name, content, want string
+	}{
+		{"no header", "a,b\n1,2\n", "no header row"},
+		{"no rows", "code,description\n99213,\n", "no code descriptions"},
+	}
+	for _, tt := range tests {
+		_, err := LoadCodeDescriptions(writeCSV(t, tt.content))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got %v, want an error containing %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestDescriptionFiller(t *testing.T) {
+	f := DescriptionFiller{Provider: &CodeTable{byType: map[string]map[string]string{
+		"": {"99213": "Office visit"},
+	}}}
+	blank := mrf.RateResult{BillingCodeType: "CPT", BillingCode: "99213"}
+	f.Enrich(&blank)
+	if blank.BillingCodeDescription != "Office visit" {
+		t.Errorf("expected the blank description filled, got %q", blank.BillingCodeDescription)
+	}
+	given := mrf.RateResult{BillingCodeType: "CPT", BillingCode: "99213", BillingCodeDescription: "Est. patient"}
+	f.Enrich(&given)
+	if given.BillingCodeDescription != "Est. patient" {
+		t.Errorf("expected the payer's description kept, got %q", given.BillingCodeDescription)
+	}
+}
@@ -0,0 +1,212 @@
+// Package refdata loads reference data that search results are annotated
+// with, such as the Medicare Physician Fee Schedule.
+package refdata
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gyeh/npi-rates/internal/mrf"
+)
+
+// FeeSchedule holds Medicare Physician Fee Schedule payment amounts for
+// one locality, by HCPCS code and modifier. It implements mrf.Enricher.
+type FeeSchedule struct {
+	fees map[feeKey]fee
+}
+
+type feeKey struct{ code, modifier string }
+
+// fee is a code's payment in facility settings (hospital, SNF) and in
+// non-facility ones (office); a schedule with a single amount sets both.
+type fee struct{ facility, nonFacility float64 }
+
+// Column names accepted for each field, compared with case, spaces and
+// punctuation ignored. They cover CMS's PFS Look-up Tool exports and
+// hand-made files.
+var (
+	codeColumns        = []string{"hcpcs", "hcpc", "hcpcscode", "hcpcscd", "cpt", "cpthcpcs", "billingcode", "code"}
+	modifierColumns    = []string{"mod", "modifier"}
+	facilityColumns    = []string{"facilityprice", "facilityfee", "facilityrate", "facprice", "facfee"}
+	nonFacilityColumns = []string{"nonfacilityprice", "nonfacilityfee", "nonfacilityrate", "nonfacprice", "nonfacfee"}
+	singleColumns      = []string{"price", "fee", "rate", "amount", "medicarerate", "allowed"}
+)
+
+// LoadFeeSchedule reads a fee schedule CSV with a header row naming a code
+// column (e.g. HCPCS), optionally a modifier column (MOD), and either
+// facility and non-facility price columns or a single price column. Lines
+// before the header, such as the title lines of a PFS Look-up Tool export,
+// are skipped. Amounts may carry "$" and thousands separators. Where a code
+// and modifier appear more than once (e.g. for several localities), the
+// first row is used, so export a single locality.
+func LoadFeeSchedule(path string) (*FeeSchedule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	cr := csv.NewReader(f)
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+
+	var codeCol, modCol, facCol, nonFacCol int
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%s: no header row with a HCPCS/CPT code column", path)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		codeCol, modCol, facCol, nonFacCol, err = feeColumns(rec)
+		if err == nil {
+			break
+		}
+		if codeCol >= 0 {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	s := &FeeSchedule{fees: make(map[feeKey]fee)}
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if codeCol >= len(rec) {
+			continue
+		}
+		code := strings.ToUpper(strings.TrimSpace(rec[codeCol]))
+		if code == "" {
+			continue
+		}
+		k := feeKey{code: code}
+		if modCol >= 0 && modCol < len(rec) {
+			k.modifier = strings.ToUpper(strings.TrimSpace(rec[modCol]))
+		}
+		if _, dup := s.fees[k]; dup {
+			continue
+		}
+		fac, ok1 := parseAmount(rec, facCol)
+		nonFac, ok2 := parseAmount(rec, nonFacCol)
+		if !ok1 && !ok2 {
+			continue
+		}
+		if !ok1 {
+			fac = nonFac
+		}
+		if !ok2 {
+			nonFac = fac
+		}
+		s.fees[k] = fee{facility: fac, nonFacility: nonFac}
+	}
+	if len(s.fees) == 0 {
+		return nil, fmt.Errorf("%s: no fee rows", path)
+	}
+	return s, nil
+}
+
+// feeColumns finds the columns of a header row. codeCol is -1 if rec is
+// not a header; err is set if it is one without usable price columns.
+func feeColumns(rec []string) (codeCol, modCol, facCol, nonFacCol int, err error) {
+	find := func(names []string) int { return findColumn(rec, names) }
+	codeCol, modCol = find(codeColumns), find(modifierColumns)
+	if codeCol < 0 {
+		return -1, -1, -1, -1, errors.New("no code column")
+	}
+	facCol, nonFacCol = find(facilityColumns), find(nonFacilityColumns)
+	if facCol < 0 && nonFacCol < 0 {
+		facCol = find(singleColumns)
+		nonFacCol = facCol
+	}
+	if facCol < 0 {
+		return codeCol, modCol, -1, -1, errors.New("header has a code column but no facility, non-facility or price column")
+	}
+	return codeCol, modCol, facCol, nonFacCol, nil
+}
+
+// findColumn returns the index of the header in rec matching the earliest
+// of names (see normalizeHeader), or -1 if none does.
+func findColumn(rec []string, names []string) int {
+	norm := make([]string, len(rec))
+	for i, h := range rec {
+		norm[i] = normalizeHeader(h)
+	}
+	for _, name := range names {
+		if i := slices.Index(norm, name); i >= 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+// normalizeHeader lowercases h and drops everything but letters and digits.
+func normalizeHeader(h string) string {
+	var b strings.Builder
+	for _, c := range strings.ToLower(h) {
+		if c >= 'a' && c <= 'z' || c >= '0' && c <= '9' {
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+// parseAmount parses rec[col] as a dollar amount such as "$1,234.56".
+func parseAmount(rec []string, col int) (float64, bool) {
+	if col < 0 || col >= len(rec) {
+		return 0, false
+	}
+	v := strings.NewReplacer("$", "", ",", "").Replace(strings.TrimSpace(rec[col]))
+	if v == "" {
+		return 0, false
+	}
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+// Len returns the number of codes (with modifiers) in the schedule.
+func (s *FeeSchedule) Len() int { return len(s.fees) }
+
+// Enrich sets r's MedicareRate and PercentOfMedicare when r is a dollar
+// rate for a CPT or HCPCS code the schedule lists with r's first modifier
+// (or none). Institutional rates are left alone: the physician fee schedule
+// doesn't price facility claims. Inpatient rates are compared with the
+// facility amount, others with the non-facility amount.
+func (s *FeeSchedule) Enrich(r *mrf.RateResult) {
+	if r.Unit != mrf.UnitUSD || strings.EqualFold(r.BillingClass, "institutional") {
+		return
+	}
+	switch strings.ToUpper(r.BillingCodeType) {
+	case "CPT", "HCPCS":
+	default:
+		return
+	}
+	k := feeKey{code: strings.ToUpper(r.BillingCode)}
+	if len(r.BillingCodeModifier) > 0 {
+		k.modifier = strings.ToUpper(r.BillingCodeModifier[0])
+	}
+	f, ok := s.fees[k]
+	if !ok {
+		return
+	}
+	amount := f.nonFacility
+	if strings.EqualFold(r.Setting, "inpatient") {
+		amount = f.facility
+	}
+	r.MedicareRate = amount
+	r.PercentOfMedicare = math.Round(r.NegotiatedRate/amount*10000) / 100
+}
@@ -0,0 +1,134 @@
+package refdata
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gyeh/npi-rates/internal/mrf"
+)
+
+// writeCSV writes content to a file in a test directory and returns its path.
+func writeCSV(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ref.csv")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadFeeSchedule(t *testing.T) {
+	// The title lines and layout of a PFS Look-up Tool export.
+	path := writeCSV(t, `"Physician Fee Schedule Search"
+"Locality: 01 MANHATTAN"
+"HCPCS","MOD","Non-Facility Price","Facility Price"
+"99213","","$112.41","$81.33"
+"99213","26","$40.00","$40.00"
+"99213","","$1.00","$1.00"
+"g0439","","$1,234.50",""
+"J3490","","NA","NA"
+"","","$5.00","$5.00"
+`)
+	s, err := LoadFeeSchedule(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Len() != 3 {
+		t.Errorf("expected 3 codes, got %d", s.Len())
+	}
+	tests := []struct {
+		key  feeKey
+		want fee
+	}{
+		{feeKey{"99213", ""}, fee{81.33, 112.41}}, // the first of two rows
+		{feeKey{"99213", "26"}, fee{40, 40}},
+		{feeKey{"G0439", ""}, fee{1234.5, 1234.5}}, // one amount sets both
+	}
+	for _, tt := range tests {
+		if got, ok := s.fees[tt.key]; !ok || got != tt.want {
+			t.Errorf("%+v: got %+v (%v), want %+v", tt.key, got, ok, tt.want)
+		}
+	}
+	if _, ok := s.fees[feeKey{"J3490", ""}]; ok {
+		t.Error("expected a code without amounts to be skipped")
+	}
+}
+
+func TestLoadFeeSchedule_SinglePrice(t *testing.T) {
+	s, err := LoadFeeSchedule(writeCSV(t, "code,Medicare Rate\n99214,150\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.fees[feeKey{code: "99214"}]; got != (fee{150, 150}) {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestLoadFeeSchedule_Errors(t *testing.T) {
+	tests := []struct {
+		name, content, want string
+	}{
+		{"no header", "a,b\n1,2\n", "no header row"},
+		{"no price column", "HCPCS,Description\n99213,Office visit\n", "no facility, non-facility or price column"},
+		{"no rows", "HCPCS,Price\n99213,NA\n", "no fee rows"},
+	}
+	for _, tt := range tests {
+		_, err := LoadFeeSchedule(writeCSV(t, tt.content))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got %v, want an error containing %q", tt.name, err, tt.want)
+		}
+	}
+	if _, err := LoadFeeSchedule(filepath.Join(t.TempDir(), "missing.csv")); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
+
+func TestFeeSchedule_Enrich(t *testing.T) {
+	s := &FeeSchedule{fees: map[feeKey]fee{
+		{"99213", ""}:   {facility: 80, nonFacility: 100},
+		{"99213", "26"}: {facility: 40, nonFacility: 40},
+	}}
+	rate := func(f func(r *mrf.RateResult)) mrf.RateResult {
+		r := mrf.RateResult{BillingCodeType: "CPT", BillingCode: "99213", BillingClass: "professional",
+			Setting: "outpatient", NegotiatedRate: 150, Unit: mrf.UnitUSD}
+		if f != nil {
+			f(&r)
+		}
+		return r
+	}
+	tests := []struct {
+		name          string
+		r             mrf.RateResult
+		medicare, pct float64
+	}{
+		{"non-facility", rate(nil), 100, 150},
+		{"inpatient uses facility", rate(func(r *mrf.RateResult) { r.Setting = "inpatient" }), 80, 187.5},
+		{"modifier", rate(func(r *mrf.RateResult) { r.BillingCodeModifier = []string{"26", "59"} }), 40, 375},
+		{"lower-case code type", rate(func(r *mrf.RateResult) { r.BillingCodeType = "hcpcs" }), 100, 150},
+		{"unlisted modifier", rate(func(r *mrf.RateResult) { r.BillingCodeModifier = []string{"TC"} }), 0, 0},
+		{"institutional", rate(func(r *mrf.RateResult) { r.BillingClass = "Institutional" }), 0, 0},
+		{"percentage", rate(func(r *mrf.RateResult) { r.Unit = mrf.UnitPercent }), 0, 0},
+		{"other code type", rate(func(r *mrf.RateResult) { r.BillingCodeType = "MS-DRG" }), 0, 0},
+		{"unlisted code", rate(func(r *mrf.RateResult) { r.BillingCode = "99999" }), 0, 0},
+	}
+	for _, tt := range tests {
+		s.Enrich(&tt.r)
+		if tt.r.MedicareRate != tt.medicare || tt.r.PercentOfMedicare != tt.pct {
+			t.Errorf("%s: got %g (%g%%), want %g (%g%%)", tt.name, tt.r.MedicareRate, tt.r.PercentOfMedicare, tt.medicare, tt.pct)
+		}
+	}
+}
+
+func TestNormalizeHeader(t *testing.T) {
+	for in, want := range map[string]string{
+		"Non-Facility Price": "nonfacilityprice",
+		" HCPCS Code ":       "hcpcscode",
+		"CPT/HCPCS":          "cpthcpcs",
+	} {
+		if got := normalizeHeader(in); got != want {
+			t.Errorf("normalizeHeader(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
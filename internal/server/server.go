@@ -0,0 +1,612 @@
+// Package server runs searches submitted over HTTP, so that one machine can
+// serve a team's searches instead of everyone running the CLI.
+//
+//	POST   /searches               submit a search (SearchRequest); 202 with the job
+//	GET    /searches               list jobs
+//	GET    /searches/{id}          job status and per-file progress
+//	GET    /searches/{id}/results  results as NDJSON, once the job has finished
+//	DELETE /searches/{id}          cancel a queued or running job
+//	GET    /healthz                liveness: 200 while the process serves
+//	GET    /readyz                 readiness: 503 with reasons when jobs can't be taken
+//	GET    /status                 queue depth, active jobs, disk headroom, last error
+//
+// Jobs run one at a time in submission order, each with its own search
+// options, so that the transfer byte counts in a job's search_params are
+// its own; within a job, files are searched by a worker.Pool as in the CLI.
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gyeh/npi-rates/internal/humanize"
+	"github.com/gyeh/npi-rates/internal/logx"
+	"github.com/gyeh/npi-rates/internal/mrf"
+	"github.com/gyeh/npi-rates/internal/output"
+	"github.com/gyeh/npi-rates/internal/progress"
+	"github.com/gyeh/npi-rates/internal/worker"
+)
+
+// SearchRequest is the body of POST /searches.
+type SearchRequest struct {
+	NPIs             []int64  `json:"npis"`
+	TINs             []string `json:"tins,omitempty"`
+	URLs             []string `json:"urls"`
+	BillingCodes     []string `json:"billing_codes,omitempty"`
+	BillingCodeTypes []string `json:"billing_code_types,omitempty"`
+}
+
+// Job states.
+const (
+	StateQueued    = "queued"
+	StateRunning   = "running"
+	StateDone      = "done"
+	StateFailed    = "failed"
+	StateCancelled = "cancelled"
+)
+
+// Job is a submitted search and its progress, as returned by the status
+// endpoints.
+type Job struct {
+	ID       string        `json:"id"`
+	State    string        `json:"state"`
+	Request  SearchRequest `json:"request"`
+	Created  time.Time     `json:"created"`
+	Started  *time.Time    `json:"started,omitempty"`
+	Finished *time.Time    `json:"finished,omitempty"`
+	Error    string        `json:"error,omitempty"`
+
+	FilesDone  int          `json:"files_done"`
+	RatesFound int64        `json:"rates_found"`
+	Files      []FileStatus `json:"files,omitempty"`
+
+	// Params is the search summary, set once the job has finished.
+	Params *mrf.SearchParams `json:"search_params,omitempty"`
+
+	cancel context.CancelFunc
+}
+
+// FileStatus is the progress of one file of a job.
+type FileStatus struct {
+	URL        string   `json:"url"`
+	Stage      string   `json:"stage,omitempty"`
+	Downloaded int64    `json:"downloaded,omitempty"`
+	Total      int64    `json:"total,omitempty"`
+	RatesFound int64    `json:"rates_found,omitempty"`
+	Warnings   []string `json:"warnings,omitempty"`
+	Done       bool     `json:"done,omitempty"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// Server holds the job queue. Use New, then serve Handler.
+type Server struct {
+	// Workers is the number of files searched at once within a job.
+	Workers int
+	// TmpDir holds intermediate files.
+	TmpDir string
+	// ResultsDir holds each job's results, as <id>.ndjson.
+	ResultsDir string
+	// MinFree is the free space TmpDir and ResultsDir each need for the
+	// server to report ready; 0 disables the check.
+	MinFree uint64
+	// KeepJobs is how many finished jobs are kept, with their results;
+	// beyond it the oldest are forgotten and their results deleted. 0 keeps
+	// them all.
+	KeepJobs int
+
+	mu      sync.Mutex
+	jobs    map[string]*Job
+	queue   chan *Job
+	started time.Time
+	running bool // Run is processing the queue
+	lastErr *JobError
+	done    chan struct{}
+}
+
+// Status is the body of GET /status.
+type Status struct {
+	Ready         bool           `json:"ready"`
+	NotReady      []string       `json:"not_ready,omitempty"`
+	Started       time.Time      `json:"started"`
+	UptimeSeconds float64        `json:"uptime_seconds"`
+	QueueDepth    int            `json:"queue_depth"`
+	QueueCapacity int            `json:"queue_capacity"`
+	ActiveJobs    []string       `json:"active_jobs"`
+	Jobs          map[string]int `json:"jobs"` // by state
+	Disk          []DiskStatus   `json:"disk"`
+	LastError     *JobError      `json:"last_error,omitempty"`
+}
+
+// DiskStatus is the free space of one of the server's directories.
+type DiskStatus struct {
+	Dir       string `json:"dir"`
+	Use       string `json:"use"` // "tmp" or "results"
+	FreeBytes uint64 `json:"free_bytes"`
+}
+
+// JobError is the most recent job failure.
+type JobError struct {
+	JobID string    `json:"job_id"`
+	Time  time.Time `json:"time"`
+	Error string    `json:"error"`
+}
+
+// New returns a server; Run must be called to start processing jobs.
+func New(workers int, tmpDir, resultsDir string) *Server {
+	return &Server{
+		Workers:    workers,
+		TmpDir:     tmpDir,
+		ResultsDir: resultsDir,
+		jobs:       make(map[string]*Job),
+		queue:      make(chan *Job, 1024),
+		started:    time.Now(),
+		KeepJobs:   DefaultKeepJobs,
+		done:       make(chan struct{}),
+	}
+}
+
+// DefaultKeepJobs is the default KeepJobs.
+const DefaultKeepJobs = 1000
+
+// Handler returns the HTTP API.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /searches", s.handleSubmit)
+	mux.HandleFunc("GET /searches", s.handleList)
+	mux.HandleFunc("GET /searches/{id}", s.handleStatus)
+	mux.HandleFunc("GET /searches/{id}/results", s.handleResults)
+	mux.HandleFunc("DELETE /searches/{id}", s.handleCancel)
+	mux.HandleFunc("GET /healthz", s.handleHealth)
+	mux.HandleFunc("GET /readyz", s.handleReady)
+	mux.HandleFunc("GET /status", s.handleServerStatus)
+	return mux
+}
+
+// Run processes queued jobs until ctx is done. A job running then is
+// cancelled, and Run returns once it has recorded that.
+func (s *Server) Run(ctx context.Context) {
+	defer close(s.done)
+	s.setRunning(true)
+	defer s.setRunning(false)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-s.queue:
+			s.run(ctx, job)
+		}
+	}
+}
+
+// Done is closed when Run has returned.
+func (s *Server) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *Server) handleSubmit(w http.ResponseWriter, r *http.Request) {
+	var req SearchRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		httpError(w, http.StatusBadRequest, fmt.Errorf("parsing request: %w", err))
+		return
+	}
+	if len(req.NPIs) == 0 && len(req.TINs) == 0 {
+		httpError(w, http.StatusBadRequest, errors.New("specify npis or tins"))
+		return
+	}
+	if len(req.URLs) == 0 {
+		httpError(w, http.StatusBadRequest, errors.New("specify urls"))
+		return
+	}
+
+	id, err := newJobID()
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, err)
+		return
+	}
+	job := &Job{ID: id, State: StateQueued, Request: req, Created: time.Now()}
+	s.mu.Lock()
+	select {
+	case s.queue <- job:
+		s.jobs[job.ID] = job
+	default:
+		s.mu.Unlock()
+		httpError(w, http.StatusServiceUnavailable, errors.New("job queue is full"))
+		return
+	}
+	snapshot := *job
+	s.mu.Unlock()
+
+	logx.Infof("Job %s: queued (%d NPIs, %d TINs, %d URLs)\n", job.ID, len(req.NPIs), len(req.TINs), len(req.URLs))
+	w.Header().Set("Location", "/searches/"+job.ID)
+	writeJSON(w, http.StatusAccepted, &snapshot)
+}
+
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	jobs := make([]Job, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		summary := *j
+		summary.Files = nil
+		jobs = append(jobs, summary)
+	}
+	s.mu.Unlock()
+	sort.Slice(jobs, func(a, b int) bool { return jobs[a].Created.Before(jobs[b].Created) })
+	writeJSON(w, http.StatusOK, jobs)
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.snapshot(r.PathValue("id"))
+	if !ok {
+		httpError(w, http.StatusNotFound, errors.New("no such job"))
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+func (s *Server) handleResults(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.snapshot(r.PathValue("id"))
+	if !ok {
+		httpError(w, http.StatusNotFound, errors.New("no such job"))
+		return
+	}
+	if job.State != StateDone {
+		httpError(w, http.StatusConflict, fmt.Errorf("job is %s", job.State))
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	http.ServeFile(w, r, s.resultsPath(job.ID))
+}
+
+func (s *Server) handleCancel(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	job, ok := s.jobs[r.PathValue("id")]
+	if ok {
+		switch job.State {
+		case StateQueued:
+			job.State = StateCancelled
+		case StateRunning:
+			job.cancel()
+		}
+	}
+	s.mu.Unlock()
+	if !ok {
+		httpError(w, http.StatusNotFound, errors.New("no such job"))
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *Server) setRunning(running bool) {
+	s.mu.Lock()
+	s.running = running
+	s.mu.Unlock()
+}
+
+// handleHealth answers liveness probes: a server that can answer is alive.
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "ok")
+}
+
+// handleReady answers readiness probes, so an orchestrator stops routing
+// searches to a server that would queue them behind a full queue or fail
+// them for lack of disk.
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	st := s.status()
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if !st.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		for _, reason := range st.NotReady {
+			fmt.Fprintln(w, reason)
+		}
+		return
+	}
+	fmt.Fprintln(w, "ready")
+}
+
+func (s *Server) handleServerStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.status())
+}
+
+// status reports the server's state and whether it is ready for jobs.
+func (s *Server) status() *Status {
+	s.mu.Lock()
+	st := &Status{
+		Started:       s.started,
+		UptimeSeconds: time.Since(s.started).Seconds(),
+		QueueCapacity: cap(s.queue),
+		ActiveJobs:    []string{},
+		Jobs:          map[string]int{},
+	}
+	for _, j := range s.jobs {
+		st.Jobs[j.State]++
+		switch j.State {
+		case StateQueued:
+			st.QueueDepth++
+		case StateRunning:
+			st.ActiveJobs = append(st.ActiveJobs, j.ID)
+		}
+	}
+	if s.lastErr != nil {
+		e := *s.lastErr
+		st.LastError = &e
+	}
+	running := s.running
+	s.mu.Unlock()
+
+	if !running {
+		st.NotReady = append(st.NotReady, "not processing jobs")
+	}
+	if len(s.queue) >= cap(s.queue) {
+		st.NotReady = append(st.NotReady, "job queue is full")
+	}
+	for _, d := range []DiskStatus{{Dir: s.TmpDir, Use: "tmp"}, {Dir: s.ResultsDir, Use: "results"}} {
+		dir := d.Dir
+		// The results dir is created with the first job.
+		for dir != "" && dir != "." && dir != "/" {
+			if _, err := os.Stat(dir); err == nil {
+				break
+			}
+			dir = filepath.Dir(dir)
+		}
+		d.FreeBytes = worker.AvailableSpace(dir)
+		st.Disk = append(st.Disk, d)
+		if s.MinFree > 0 && d.FreeBytes < s.MinFree {
+			st.NotReady = append(st.NotReady, fmt.Sprintf("%s dir %s has %s free, under %s",
+				d.Use, d.Dir, humanize.Bytes(d.FreeBytes), humanize.Bytes(s.MinFree)))
+		}
+	}
+	st.Ready = len(st.NotReady) == 0
+	return st
+}
+
+// snapshot returns a copy of the job that is safe to encode.
+func (s *Server) snapshot(id string) (*Job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return nil, false
+	}
+	c := *job
+	c.Files = make([]FileStatus, len(job.Files))
+	for i, f := range job.Files {
+		f.Warnings = append([]string(nil), f.Warnings...)
+		c.Files[i] = f
+	}
+	return &c, true
+}
+
+func (s *Server) resultsPath(id string) string {
+	return filepath.Join(s.ResultsDir, id+".ndjson")
+}
+
+// run searches one job's files, streaming results to its results file.
+func (s *Server) run(ctx context.Context, job *Job) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	s.mu.Lock()
+	if job.State == StateCancelled {
+		s.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	job.State = StateRunning
+	job.Started = &now
+	job.cancel = cancel
+	job.Files = make([]FileStatus, len(job.Request.URLs))
+	for i, u := range job.Request.URLs {
+		job.Files[i].URL = u
+	}
+	s.mu.Unlock()
+	logx.Infof("Job %s: running\n", job.ID)
+
+	params, err := s.search(ctx, job)
+
+	defer s.prune()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now = time.Now()
+	job.Finished = &now
+	job.Params = params
+	switch {
+	case ctx.Err() != nil && errors.Is(err, context.Canceled):
+		job.State = StateCancelled
+	case err != nil:
+		job.State = StateFailed
+		job.Error = err.Error()
+		s.lastErr = &JobError{JobID: job.ID, Time: now, Error: job.Error}
+	default:
+		job.State = StateDone
+	}
+	logx.Infof("Job %s: %s\n", job.ID, job.State)
+}
+
+// prune forgets the oldest finished jobs beyond KeepJobs and deletes their
+// results.
+func (s *Server) prune() {
+	if s.KeepJobs <= 0 {
+		return
+	}
+	s.mu.Lock()
+	var finished []*Job
+	for _, j := range s.jobs {
+		if j.State != StateQueued && j.State != StateRunning {
+			finished = append(finished, j)
+		}
+	}
+	if len(finished) <= s.KeepJobs {
+		s.mu.Unlock()
+		return
+	}
+	// Jobs cancelled while queued never finished; they go by submission.
+	ended := func(j *Job) time.Time {
+		if j.Finished != nil {
+			return *j.Finished
+		}
+		return j.Created
+	}
+	sort.Slice(finished, func(a, b int) bool { return ended(finished[a]).Before(ended(finished[b])) })
+	finished = finished[:len(finished)-s.KeepJobs]
+	for _, j := range finished {
+		delete(s.jobs, j.ID)
+	}
+	s.mu.Unlock()
+
+	for _, j := range finished {
+		if err := os.Remove(s.resultsPath(j.ID)); err != nil && !os.IsNotExist(err) {
+			logx.Infof("Job %s: removing old results: %v\n", j.ID, err)
+		}
+	}
+}
+
+func (s *Server) search(ctx context.Context, job *Job) (*mrf.SearchParams, error) {
+	req := job.Request
+	worker.ResetTransferred()
+
+	npis := make(map[int64]struct{}, len(req.NPIs))
+	for _, n := range req.NPIs {
+		npis[n] = struct{}{}
+	}
+	opts := &mrf.SearchOptions{
+		NPIs:             npis,
+		TINs:             req.TINs,
+		BillingCodes:     req.BillingCodes,
+		BillingCodeTypes: req.BillingCodeTypes,
+		Counts:           &mrf.RateCounts{},
+	}
+
+	if err := os.MkdirAll(s.ResultsDir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating results dir: %w", err)
+	}
+	sink := output.NewNDJSONSink(s.resultsPath(job.ID))
+	if err := sink.Open(ctx); err != nil {
+		return nil, fmt.Errorf("opening results: %w", err)
+	}
+
+	start := time.Now()
+	pool := &worker.Pool{
+		Workers:   s.Workers,
+		Search:    opts,
+		TmpDir:    s.TmpDir,
+		Progress:  &jobProgress{NoopManager: &progress.NoopManager{Quiet: true}, s: s, job: job},
+		Stream:    true,
+		OnResults: sink.WriteBatch,
+	}
+	results := pool.Run(ctx, req.URLs)
+
+	params := &mrf.SearchParams{
+		RunID:           job.ID,
+		NPIs:            req.NPIs,
+		TINs:            req.TINs,
+		SearchedFiles:   len(req.URLs),
+		DurationSeconds: time.Since(start).Seconds(),
+		Status:          mrf.StatusComplete,
+		Summary:         worker.Summarize(results),
+		Transfer:        &mrf.TransferSummary{Hosts: worker.TransferredBytes()},
+	}
+	params.DroppedRates = opts.Counts.Dropped()
+	for _, n := range params.Transfer.Hosts {
+		params.Transfer.TotalBytes += n
+	}
+	var firstErr error
+	for _, r := range results {
+		if r.Err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("%s: %w", worker.FileNameFromURL(r.URL), r.Err)
+		}
+		if r.Count > 0 {
+			params.MatchedFiles++
+		}
+	}
+	if err := sink.Close(*params); err != nil && firstErr == nil {
+		firstErr = fmt.Errorf("writing results: %w", err)
+	}
+	return params, firstErr
+}
+
+// jobProgress records a job's per-file progress for the status endpoint.
+type jobProgress struct {
+	*progress.NoopManager
+	s   *Server
+	job *Job
+}
+
+func (p *jobProgress) NewTracker(index, total int, filename string) progress.Tracker {
+	return &jobTracker{p: p, idx: index}
+}
+
+type jobTracker struct {
+	p   *jobProgress
+	idx int
+}
+
+// update applies fn to the file's status under the server lock.
+func (t *jobTracker) update(fn func(*FileStatus)) {
+	t.p.s.mu.Lock()
+	defer t.p.s.mu.Unlock()
+	fn(&t.p.job.Files[t.idx])
+}
+
+func (t *jobTracker) SetStage(stage string) {
+	t.update(func(f *FileStatus) { f.Stage = stage })
+}
+
+func (t *jobTracker) SetProgress(current, total int64) {
+	t.update(func(f *FileStatus) { f.Downloaded, f.Total = current, total })
+}
+
+func (t *jobTracker) SetCounter(name string, value int64) {
+	if name != "rates_found" {
+		return
+	}
+	t.update(func(f *FileStatus) {
+		t.p.job.RatesFound += value - f.RatesFound
+		f.RatesFound = value
+	})
+}
+
+func (t *jobTracker) LogWarning(msg string) {
+	t.update(func(f *FileStatus) { f.Warnings = append(f.Warnings, msg) })
+}
+
+func (t *jobTracker) SetWorkDir(string) {}
+
+func (t *jobTracker) Done() {
+	t.update(func(f *FileStatus) {
+		f.Done = true
+		t.p.job.FilesDone++
+	})
+}
+
+func newJobID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generating job ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func httpError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
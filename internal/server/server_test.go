@@ -0,0 +1,375 @@
+package server
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testMRF = `{
+	"reporting_entity_name": "Test Health Plan",
+	"provider_references": [
+		{"provider_group_id": 1, "provider_groups": [{"npi": [1316924913], "tin": {"type": "ein", "value": "16-0960964"}}]}
+	],
+	"in_network": [
+		{
+			"billing_code_type": "CPT", "billing_code": "99213", "negotiation_arrangement": "ffs",
+			"negotiated_rates": [{"provider_references": [1], "negotiated_prices": [
+				{"negotiated_rate": 125.50, "negotiated_type": "negotiated", "billing_class": "professional", "expiration_date": "2026-12-31"},
+				{"negotiated_rate": 140.00, "negotiated_type": "negotiated", "billing_class": "institutional", "expiration_date": "2026-12-31"}
+			]}]
+		}
+	]
+}`
+
+// serveMRF serves testMRF gzipped; requests block until release is closed,
+// if it is non-nil.
+func serveMRF(t *testing.T, release chan struct{}) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if release != nil {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+				return
+			}
+		}
+		gz := gzip.NewWriter(w)
+		gz.Write([]byte(testMRF))
+		gz.Close()
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// newTestServer returns a server with its API on an httptest server. Run is
+// not started.
+func newTestServer(t *testing.T) (*Server, *httptest.Server) {
+	t.Helper()
+	s := New(1, t.TempDir(), t.TempDir())
+	api := httptest.NewServer(s.Handler())
+	t.Cleanup(api.Close)
+	return s, api
+}
+
+// startRun runs s until the test ends.
+func startRun(t *testing.T, s *Server) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	go s.Run(ctx)
+	t.Cleanup(func() {
+		cancel()
+		<-s.Done()
+	})
+}
+
+func submit(t *testing.T, api *httptest.Server, body string) (*http.Response, Job) {
+	t.Helper()
+	resp, err := http.Post(api.URL+"/searches", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var job Job
+	if resp.StatusCode == http.StatusAccepted {
+		if err := json.NewDecoder(resp.Body).Decode(&job); err != nil {
+			t.Fatalf("decoding job: %v", err)
+		}
+	}
+	return resp, job
+}
+
+func getJob(t *testing.T, api *httptest.Server, id string) (int, Job) {
+	t.Helper()
+	resp, err := http.Get(api.URL + "/searches/" + id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var job Job
+	json.NewDecoder(resp.Body).Decode(&job)
+	return resp.StatusCode, job
+}
+
+// waitState polls the job until it is in state.
+func waitState(t *testing.T, api *httptest.Server, id, state string) Job {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		_, job := getJob(t, api, id)
+		if job.State == state {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s still %s (error %q), want %s", id, job.State, job.Error, state)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSubmit_Invalid(t *testing.T) {
+	_, api := newTestServer(t)
+	for _, body := range []string{
+		`not json`,
+		`{"npis": [1316924913]}`,
+		`{"urls": ["https://example.com/a.json.gz"]}`,
+		`{"npis": [1316924913], "urls": ["https://example.com/a.json.gz"], "npi": 1}`,
+	} {
+		resp, _ := submit(t, api, body)
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: got HTTP %d, want 400", body, resp.StatusCode)
+		}
+	}
+}
+
+func TestJobLifecycle(t *testing.T) {
+	s, api := newTestServer(t)
+	mrfServer := serveMRF(t, nil)
+
+	resp, job := submit(t, api, `{"npis": [1316924913], "urls": ["`+mrfServer.URL+`/in_network.json.gz"]}`)
+	if resp.StatusCode != http.StatusAccepted || job.State != StateQueued {
+		t.Fatalf("submit: HTTP %d, state %q", resp.StatusCode, job.State)
+	}
+	if loc := resp.Header.Get("Location"); loc != "/searches/"+job.ID {
+		t.Errorf("Location = %q", loc)
+	}
+
+	// Results aren't available before the job is done.
+	r, err := http.Get(api.URL + "/searches/" + job.ID + "/results")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Body.Close()
+	if r.StatusCode != http.StatusConflict {
+		t.Errorf("results of a queued job: HTTP %d, want 409", r.StatusCode)
+	}
+
+	startRun(t, s)
+	done := waitState(t, api, job.ID, StateDone)
+	if done.FilesDone != 1 || done.RatesFound != 2 || len(done.Files) != 1 || !done.Files[0].Done {
+		t.Errorf("unexpected progress %+v", done)
+	}
+	if done.Params == nil || done.Params.MatchedFiles != 1 || done.Params.Transfer == nil || done.Params.Transfer.TotalBytes == 0 {
+		t.Errorf("unexpected search params %+v", done.Params)
+	}
+
+	r, err = http.Get(api.URL + "/searches/" + job.ID + "/results")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Body.Close()
+	if ct := r.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	var lines int
+	for sc := bufio.NewScanner(r.Body); sc.Scan(); lines++ {
+		var res map[string]any
+		if err := json.Unmarshal(sc.Bytes(), &res); err != nil {
+			t.Errorf("result line %d: %v", lines+1, err)
+		}
+	}
+	if lines != 2 {
+		t.Errorf("expected 2 result lines, got %d", lines)
+	}
+
+	list, err := http.Get(api.URL + "/searches")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer list.Body.Close()
+	var jobs []Job
+	json.NewDecoder(list.Body).Decode(&jobs)
+	if len(jobs) != 1 || jobs[0].ID != job.ID || jobs[0].Files != nil {
+		t.Errorf("unexpected job list %+v", jobs)
+	}
+
+	if code, _ := getJob(t, api, "nosuchjob"); code != http.StatusNotFound {
+		t.Errorf("unknown job: HTTP %d, want 404", code)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	s, api := newTestServer(t)
+	release := make(chan struct{})
+	defer close(release)
+	mrfServer := serveMRF(t, release)
+	body := `{"npis": [1316924913], "urls": ["` + mrfServer.URL + `/in_network.json.gz"]}`
+
+	_, first := submit(t, api, body)
+	_, second := submit(t, api, body)
+	startRun(t, s)
+	waitState(t, api, first.ID, StateRunning)
+
+	for _, id := range []string{second.ID, first.ID} {
+		req, _ := http.NewRequest("DELETE", api.URL+"/searches/"+id, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusAccepted {
+			t.Errorf("cancel %s: HTTP %d", id, resp.StatusCode)
+		}
+	}
+	waitState(t, api, first.ID, StateCancelled)
+	if job := waitState(t, api, second.ID, StateCancelled); job.Started != nil {
+		t.Error("job cancelled while queued was started")
+	}
+}
+
+// TestRunDone verifies that Done closes only after a job running when Run's
+// context ends has recorded its cancellation.
+func TestRunDone(t *testing.T) {
+	s, api := newTestServer(t)
+	release := make(chan struct{})
+	defer close(release)
+	mrfServer := serveMRF(t, release)
+	_, job := submit(t, api, `{"npis": [1316924913], "urls": ["`+mrfServer.URL+`/in_network.json.gz"]}`)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go s.Run(ctx)
+	waitState(t, api, job.ID, StateRunning)
+	cancel()
+	select {
+	case <-s.Done():
+	case <-time.After(10 * time.Second):
+		t.Fatal("Run did not return")
+	}
+	if _, got := getJob(t, api, job.ID); got.State != StateCancelled || got.Finished == nil {
+		t.Errorf("job left %s after Run returned", got.State)
+	}
+}
+
+// TestKeepJobs verifies that finished jobs beyond KeepJobs are forgotten
+// along with their results.
+func TestKeepJobs(t *testing.T) {
+	s, api := newTestServer(t)
+	s.KeepJobs = 1
+	mrfServer := serveMRF(t, nil)
+	body := `{"npis": [1316924913], "urls": ["` + mrfServer.URL + `/in_network.json.gz"]}`
+	startRun(t, s)
+
+	_, first := submit(t, api, body)
+	waitState(t, api, first.ID, StateDone)
+	if _, err := os.Stat(s.resultsPath(first.ID)); err != nil {
+		t.Fatalf("first job's results: %v", err)
+	}
+	_, second := submit(t, api, body)
+	waitState(t, api, second.ID, StateDone)
+
+	if code, _ := getJob(t, api, first.ID); code != http.StatusNotFound {
+		t.Errorf("oldest job still listed (HTTP %d)", code)
+	}
+	if _, err := os.Stat(s.resultsPath(first.ID)); !os.IsNotExist(err) {
+		t.Errorf("oldest job's results not deleted: %v", err)
+	}
+	if _, err := os.Stat(s.resultsPath(second.ID)); err != nil {
+		t.Errorf("newest job's results: %v", err)
+	}
+}
+
+// ready returns the status and body of GET /readyz.
+func ready(t *testing.T, api *httptest.Server) (int, string) {
+	t.Helper()
+	resp, err := http.Get(api.URL + "/readyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(data)
+}
+
+func serverStatus(t *testing.T, api *httptest.Server) Status {
+	t.Helper()
+	resp, err := http.Get(api.URL + "/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var st Status
+	if err := json.NewDecoder(resp.Body).Decode(&st); err != nil {
+		t.Fatalf("decoding status: %v", err)
+	}
+	return st
+}
+
+func TestReady_NotRunning(t *testing.T) {
+	s, api := newTestServer(t)
+	if code, body := ready(t, api); code != http.StatusServiceUnavailable || !strings.Contains(body, "not processing jobs") {
+		t.Errorf("before Run: HTTP %d %q", code, body)
+	}
+	if st := serverStatus(t, api); st.Ready || len(st.NotReady) != 1 {
+		t.Errorf("before Run: %+v", st)
+	}
+
+	startRun(t, s)
+	if code, body := ready(t, api); code != http.StatusOK || body != "ready\n" {
+		t.Errorf("running: HTTP %d %q", code, body)
+	}
+	st := serverStatus(t, api)
+	if !st.Ready || st.NotReady != nil || st.QueueCapacity != 1024 || len(st.Disk) != 2 {
+		t.Errorf("running: %+v", st)
+	}
+}
+
+// TestReady_QueueFull verifies that a server whose queue is full reports
+// not ready and refuses more jobs.
+func TestReady_QueueFull(t *testing.T) {
+	s, api := newTestServer(t)
+	s.queue = make(chan *Job, 1)
+	release := make(chan struct{})
+	mrfServer := serveMRF(t, release)
+	body := `{"npis": [1316924913], "urls": ["` + mrfServer.URL + `/in_network.json.gz"]}`
+	startRun(t, s)
+	t.Cleanup(func() { close(release) })
+
+	_, first := submit(t, api, body)
+	waitState(t, api, first.ID, StateRunning)
+	if code, _ := ready(t, api); code != http.StatusOK {
+		t.Errorf("with an empty queue: HTTP %d", code)
+	}
+	if resp, _ := submit(t, api, body); resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("second job: HTTP %d", resp.StatusCode)
+	}
+
+	if code, body := ready(t, api); code != http.StatusServiceUnavailable || !strings.Contains(body, "job queue is full") {
+		t.Errorf("with a full queue: HTTP %d %q", code, body)
+	}
+	st := serverStatus(t, api)
+	if st.Ready || st.QueueDepth != 1 || st.QueueCapacity != 1 || len(st.ActiveJobs) != 1 || st.ActiveJobs[0] != first.ID {
+		t.Errorf("with a full queue: %+v", st)
+	}
+	if resp, _ := submit(t, api, body); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("third job: HTTP %d, want 503", resp.StatusCode)
+	}
+}
+
+func TestReady_MinFree(t *testing.T) {
+	s, api := newTestServer(t)
+	s.MinFree = math.MaxUint64
+	startRun(t, s)
+
+	code, body := ready(t, api)
+	if code != http.StatusServiceUnavailable || !strings.Contains(body, "tmp dir") || !strings.Contains(body, "results dir") {
+		t.Errorf("HTTP %d %q", code, body)
+	}
+	st := serverStatus(t, api)
+	if st.Ready || len(st.NotReady) != 2 || len(st.Disk) != 2 || st.Disk[0].FreeBytes == 0 {
+		t.Errorf("unexpected status %+v", st)
+	}
+
+	s.MinFree = 1
+	if code, _ := ready(t, api); code != http.StatusOK {
+		t.Errorf("with 1 byte required: HTTP %d", code)
+	}
+}
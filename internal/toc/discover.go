@@ -0,0 +1,254 @@
+package toc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gyeh/npi-rates/internal/worker"
+)
+
+// Payer describes where a payer publishes its TOC files and how to find
+// the current ones there.
+type Payer struct {
+	Name string
+	// Endpoint is the page or API listing the payer's files, or for a
+	// template payer the TOC URL with "{date}" for the first of the month
+	// (YYYY-MM-01).
+	Endpoint string
+	kind     discoverKind
+}
+
+type discoverKind int
+
+const (
+	// discoverScrape takes the TOC URLs found anywhere in the endpoint's
+	// response: links of an HTML page, or URL strings in JSON.
+	discoverScrape discoverKind = iota
+	// discoverBlobs reads a JSON list of {name, downloadUrl} blobs.
+	discoverBlobs
+	// discoverTemplate fills the month into Endpoint.
+	discoverTemplate
+)
+
+// payers are the payers Discover knows. Their endpoints are public but
+// change without notice; DiscoverAt takes a replacement.
+var payers = map[string]Payer{
+	"uhc": {
+		Name:     "UnitedHealthcare",
+		Endpoint: "https://transparency-in-coverage.uhc.com/api/v1/uhc/blobs/",
+		kind:     discoverBlobs,
+	},
+	"anthem": {
+		Name:     "Anthem",
+		Endpoint: "https://antm-pt-prod-dataz-nogbd-nophi-us-east1.s3.amazonaws.com/anthem/{date}_anthem_index.json.gz",
+		kind:     discoverTemplate,
+	},
+	"aetna": {
+		Name:     "Aetna",
+		Endpoint: "https://mrf.healthsparq.com/aetnacvs-egress.nophi.kyruushsq.com/prd/mrf/AETNACVS_I/ALICSI/latest_metadata.json",
+		kind:     discoverScrape,
+	},
+	"cigna": {
+		Name:     "Cigna",
+		Endpoint: "https://www.cigna.com/legal/compliance/machine-readable-files",
+		kind:     discoverScrape,
+	},
+}
+
+// Payers returns the keys of the payers Discover knows, sorted.
+func Payers() []string {
+	keys := make([]string, 0, len(payers))
+	for k := range payers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// LookupPayer returns the payer with the given key (see Payers).
+func LookupPayer(key string) (Payer, bool) {
+	p, ok := payers[strings.ToLower(key)]
+	return p, ok
+}
+
+// DiscoverResult holds the TOC URLs found for a payer.
+type DiscoverResult struct {
+	URLs []string
+	// Month is the YYYY-MM the URLs are for: the requested month, or the
+	// latest earlier one if the payer hasn't published it yet. It is ""
+	// when the URLs carry no dates.
+	Month string
+}
+
+// Discover finds the payer's TOC URLs for month (only its year and month
+// count) at the payer's usual endpoint.
+func Discover(ctx context.Context, payer string, month time.Time) (*DiscoverResult, error) {
+	p, ok := LookupPayer(payer)
+	if !ok {
+		return nil, fmt.Errorf("unknown payer %q (known: %s)", payer, strings.Join(Payers(), ", "))
+	}
+	return DiscoverAt(ctx, p, p.Endpoint, month)
+}
+
+// DiscoverAt is Discover with the payer's endpoint replaced by endpoint,
+// for when the payer has moved it.
+func DiscoverAt(ctx context.Context, p Payer, endpoint string, month time.Time) (*DiscoverResult, error) {
+	var urls []string
+	var err error
+	switch p.kind {
+	case discoverTemplate:
+		return discoverFromTemplate(ctx, endpoint, month)
+	case discoverBlobs:
+		urls, err = fetchBlobs(ctx, endpoint)
+	default:
+		var body []byte
+		if body, err = fetchBody(ctx, endpoint); err == nil {
+			urls = ScrapeTOCLinks(body)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", endpoint, err)
+	}
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("%s: no TOC URLs found; the payer may have moved its files (see --endpoint)", endpoint)
+	}
+	urls, m := PickMonth(urls, month)
+	return &DiscoverResult{URLs: urls, Month: m}, nil
+}
+
+// maxListingSize caps how much of an endpoint's response is read.
+const maxListingSize = 64 << 20
+
+func fetchBody(ctx context.Context, url string) ([]byte, error) {
+	resp, err := worker.DownloadHTTP(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(io.LimitReader(resp.Body, maxListingSize))
+}
+
+// fetchBlobs reads a blob listing, {"blobs": [{"name", "downloadUrl"}]},
+// returning the download URLs of the TOC files in it.
+func fetchBlobs(ctx context.Context, url string) ([]string, error) {
+	body, err := fetchBody(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	var listing struct {
+		Blobs []struct {
+			Name        string `json:"name"`
+			DownloadURL string `json:"downloadUrl"`
+		} `json:"blobs"`
+	}
+	if err := json.Unmarshal(body, &listing); err != nil {
+		return nil, fmt.Errorf("parsing blob listing: %w", err)
+	}
+	var urls []string
+	for _, b := range listing.Blobs {
+		if b.DownloadURL != "" && isTOCName(b.Name) {
+			urls = append(urls, b.DownloadURL)
+		}
+	}
+	return urls, nil
+}
+
+// discoverFromTemplate tries the template for month, then for up to two
+// months before it, since payers post a month's TOC some days into it.
+func discoverFromTemplate(ctx context.Context, template string, month time.Time) (*DiscoverResult, error) {
+	first := time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, time.UTC)
+	var lastErr error
+	for back := 0; back < 3; back++ {
+		m := first.AddDate(0, -back, 0)
+		url := strings.ReplaceAll(template, "{date}", m.Format("2006-01-02"))
+		resp, err := worker.DownloadHTTP(ctx, url)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			lastErr = fmt.Errorf("%s: %w", url, err)
+			continue
+		}
+		resp.Body.Close()
+		return &DiscoverResult{URLs: []string{url}, Month: m.Format("2006-01")}, nil
+	}
+	return nil, fmt.Errorf("no TOC for %s or the two months before it: %w", first.Format("2006-01"), lastErr)
+}
+
+// tocURLPattern matches absolute URLs of JSON files, plain or gzipped, in
+// HTML or JSON text.
+var tocURLPattern = regexp.MustCompile(`https?://[^\s"'<>\\]+?\.json(?:\.gz)?(?:\?[^\s"'<>\\]*)?`)
+
+// ScrapeTOCLinks returns the TOC URLs in body, in order of appearance and
+// without duplicates: the JSON file URLs whose file name marks a TOC (see
+// isTOCName). Entities and JSON-escaped slashes are decoded first, so links
+// in HTML attributes and in embedded JSON are both found.
+func ScrapeTOCLinks(body []byte) []string {
+	text := html.UnescapeString(strings.ReplaceAll(string(body), `\/`, "/"))
+	var urls []string
+	for _, u := range tocURLPattern.FindAllString(text, -1) {
+		if isTOCName(worker.FileNameFromURL(u)) && !slices.Contains(urls, u) {
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
+// isTOCName reports whether a file name is that of a TOC: CMS names them
+// "<date>_<entity>_index.json", and some payers "table-of-contents".
+func isTOCName(name string) bool {
+	name = strings.ToLower(name)
+	name = strings.TrimSuffix(strings.TrimSuffix(name, ".gz"), ".json")
+	return strings.HasSuffix(name, "_index") || strings.HasSuffix(name, "-index") ||
+		strings.Contains(name, "table-of-contents") || strings.Contains(name, "table_of_contents")
+}
+
+// datePattern matches the YYYY-MM part of a date in a URL.
+var datePattern = regexp.MustCompile(`(?:^|[^0-9])(20[0-9]{2})-?(0[1-9]|1[0-2])(?:-?[0-3][0-9])?(?:[^0-9]|$)`)
+
+// urlMonth returns the YYYY-MM of the first date in u's path, or "". The
+// query is left out: signed URLs carry timestamps there.
+func urlMonth(u string) string {
+	u, _, _ = strings.Cut(u, "?")
+	m := datePattern.FindStringSubmatch(u)
+	if m == nil {
+		return ""
+	}
+	return m[1] + "-" + m[2]
+}
+
+// PickMonth keeps the URLs dated in month, or if there are none, those of
+// the latest month before it, returning them and that month. Undated URLs
+// are kept along with them; if no URL is dated, all are returned with "".
+func PickMonth(urls []string, month time.Time) ([]string, string) {
+	want := month.Format("2006-01")
+	best := ""
+	for _, u := range urls {
+		if m := urlMonth(u); m != "" && m <= want && m > best {
+			best = m
+		}
+	}
+	if best == "" {
+		// Nothing dated on or before month: fall back to the latest of all.
+		for _, u := range urls {
+			if m := urlMonth(u); m > best {
+				best = m
+			}
+		}
+	}
+	var out []string
+	for _, u := range urls {
+		if m := urlMonth(u); m == "" || m == best {
+			out = append(out, u)
+		}
+	}
+	return out, best
+}
@@ -0,0 +1,119 @@
+package toc
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+)
+
+// PlanFiles is a plan listed in a TOC, with how many in-network files it has.
+type PlanFiles struct {
+	ReportingPlan
+	// Structures is how many reporting structures list the plan.
+	Structures int
+	// Files is how many distinct in-network files those structures list:
+	// the number of URLs resolving the plan's plan_id would give, if no
+	// other plan shared it.
+	Files int
+
+	files map[int]struct{}
+}
+
+// PlanList holds the plans in a TOC.
+type PlanList struct {
+	ReportingEntityName string
+	// Plans are the distinct plan_name, plan_id and plan_id_type tuples,
+	// sorted by plan name, then ID.
+	Plans      []PlanFiles
+	Structures int // reporting structures read
+}
+
+// ListPlans streams a TOC JSON file from r and lists its distinct plans
+// with their file counts, so the plan_id to resolve can be looked up
+// instead of guessed. Plans are compared as given, except that surrounding
+// whitespace is ignored.
+//
+// onStructure, if non-nil, is called with the count of structures processed so far.
+func ListPlans(r io.Reader, onStructure func(int)) (*PlanList, error) {
+	result := &PlanList{}
+	plans := map[ReportingPlan]*PlanFiles{}
+	// File URLs are numbered so each plan's set of files stays small.
+	fileIDs := map[string]int{}
+
+	err := walkTOC(r, func(name string) {
+		result.ReportingEntityName = name
+	}, func(raw json.RawMessage) error {
+		result.Structures++
+		if onStructure != nil {
+			onStructure(result.Structures)
+		}
+
+		var entry reportingStructure
+		if err := json.Unmarshal(raw, &entry); err != nil {
+			return nil // skip malformed
+		}
+		ids := make([]int, 0, len(entry.InNetworkFiles))
+		for _, f := range entry.InNetworkFiles {
+			if f.Location == "" {
+				continue
+			}
+			id, ok := fileIDs[f.Location]
+			if !ok {
+				id = len(fileIDs)
+				fileIDs[f.Location] = id
+			}
+			ids = append(ids, id)
+		}
+		for _, plan := range entry.ReportingPlans {
+			key := ReportingPlan{
+				PlanName:   strings.TrimSpace(plan.PlanName),
+				PlanIDType: strings.TrimSpace(plan.PlanIDType),
+				PlanID:     strings.TrimSpace(plan.PlanID),
+			}
+			p := plans[key]
+			if p == nil {
+				p = &PlanFiles{ReportingPlan: key, files: map[int]struct{}{}}
+				plans[key] = p
+			}
+			p.Structures++
+			for _, id := range ids {
+				p.files[id] = struct{}{}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result.Plans = make([]PlanFiles, 0, len(plans))
+	for _, p := range plans {
+		p.Files = len(p.files)
+		p.files = nil
+		result.Plans = append(result.Plans, *p)
+	}
+	sort.Slice(result.Plans, func(i, j int) bool {
+		a, b := result.Plans[i], result.Plans[j]
+		if a.PlanName != b.PlanName {
+			return a.PlanName < b.PlanName
+		}
+		if a.PlanID != b.PlanID {
+			return a.PlanID < b.PlanID
+		}
+		return a.PlanIDType < b.PlanIDType
+	})
+	return result, nil
+}
+
+// FetchAndListPlans downloads a TOC file from tocURL, decompressing it as
+// FetchAndResolve does, and lists its plans.
+func FetchAndListPlans(ctx context.Context, tocURL string, onProgress func(downloaded, total int64)) (*PlanList, error) {
+	r, err := openTOC(ctx, tocURL, onProgress)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ListPlans(r, nil)
+}
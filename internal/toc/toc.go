@@ -37,48 +37,110 @@ type ResolveResult struct {
 //
 // onStructure, if non-nil, is called with the count of structures processed so far.
 func ResolveTOC(r io.Reader, planID string, onStructure func(int)) (*ResolveResult, error) {
+	result := &ResolveResult{}
+	seen := map[string]struct{}{}
+	planIDLower := []byte(strings.ToLower(planID))
+
+	structCount := 0
+	err := walkTOC(r, func(name string) {
+		result.ReportingEntityName = name
+	}, func(raw json.RawMessage) error {
+		structCount++
+		if onStructure != nil {
+			onStructure(structCount)
+		}
+
+		// Pre-filter: skip elements that don't contain the plan ID as substring.
+		if !bytes.Contains(bytes.ToLower(raw), planIDLower) {
+			return nil
+		}
+
+		// Full unmarshal of matching candidate.
+		var entry reportingStructure
+		if err := json.Unmarshal(raw, &entry); err != nil {
+			return nil // skip malformed
+		}
+
+		// Check for exact case-insensitive match on plan_id.
+		matched := false
+		for _, plan := range entry.ReportingPlans {
+			if strings.EqualFold(plan.PlanID, planID) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return nil
+		}
+
+		result.MatchedStructures++
+
+		// Collect deduplicated URLs in insertion order.
+		for _, f := range entry.InNetworkFiles {
+			if f.Location == "" {
+				continue
+			}
+			if _, exists := seen[f.Location]; !exists {
+				seen[f.Location] = struct{}{}
+				result.URLs = append(result.URLs, f.Location)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// reportingStructure is one element of a TOC's reporting_structure.
+type reportingStructure struct {
+	ReportingPlans []ReportingPlan `json:"reporting_plans"`
+	InNetworkFiles []InNetworkFile `json:"in_network_files"`
+}
+
+// walkTOC streams a TOC JSON file from r, calling onEntity with its
+// reporting_entity_name and each with every reporting_structure element as
+// raw JSON. Other keys are skipped. An error from each ends the walk.
+func walkTOC(r io.Reader, onEntity func(string), each func(json.RawMessage) error) error {
 	dec := json.NewDecoder(r)
 
 	// Expect opening '{'.
 	tok, err := dec.Token()
 	if err != nil {
-		return nil, fmt.Errorf("reading opening token: %w", err)
+		return fmt.Errorf("reading opening token: %w", err)
 	}
 	if delim, ok := tok.(json.Delim); !ok || delim != '{' {
-		return nil, fmt.Errorf("expected '{', got %v", tok)
+		return fmt.Errorf("expected '{', got %v", tok)
 	}
 
-	result := &ResolveResult{}
-	seen := map[string]struct{}{}
-	planIDLower := []byte(strings.ToLower(planID))
-
 	for dec.More() {
 		// Read the key name.
 		tok, err = dec.Token()
 		if err != nil {
-			return nil, fmt.Errorf("reading key: %w", err)
+			return fmt.Errorf("reading key: %w", err)
 		}
 		key, ok := tok.(string)
 		if !ok {
-			return nil, fmt.Errorf("expected string key, got %T", tok)
+			return fmt.Errorf("expected string key, got %T", tok)
 		}
 
 		switch key {
 		case "reporting_entity_name":
 			var name string
 			if err := dec.Decode(&name); err != nil {
-				return nil, fmt.Errorf("decoding reporting_entity_name: %w", err)
+				return fmt.Errorf("decoding reporting_entity_name: %w", err)
 			}
-			result.ReportingEntityName = name
+			onEntity(name)
 
 		case "reporting_structure":
-			if err := streamReportingStructure(dec, planID, planIDLower, result, seen, onStructure); err != nil {
-				return nil, fmt.Errorf("streaming reporting_structure: %w", err)
+			if err := streamReportingStructure(dec, each); err != nil {
+				return fmt.Errorf("streaming reporting_structure: %w", err)
 			}
 
 		default:
 			if err := skipValue(dec); err != nil {
-				return nil, fmt.Errorf("skipping key %q: %w", key, err)
+				return fmt.Errorf("skipping key %q: %w", key, err)
 			}
 		}
 	}
@@ -86,26 +148,18 @@ func ResolveTOC(r io.Reader, planID string, onStructure func(int)) (*ResolveResu
 	// Expect closing '}'.
 	tok, err = dec.Token()
 	if err != nil {
-		return nil, fmt.Errorf("reading closing token: %w", err)
+		return fmt.Errorf("reading closing token: %w", err)
 	}
 	if delim, ok := tok.(json.Delim); !ok || delim != '}' {
-		return nil, fmt.Errorf("expected '}', got %v", tok)
+		return fmt.Errorf("expected '}', got %v", tok)
 	}
-
-	return result, nil
+	return nil
 }
 
 // streamReportingStructure reads the reporting_structure array element by
-// element. Each element is decoded as raw JSON, pre-filtered by planID
-// substring, then fully unmarshalled only if it might match.
-func streamReportingStructure(
-	dec *json.Decoder,
-	planID string,
-	planIDLower []byte,
-	result *ResolveResult,
-	seen map[string]struct{},
-	onStructure func(int),
-) error {
+// element, passing each to each as raw JSON, so only one element is held in
+// memory at a time.
+func streamReportingStructure(dec *json.Decoder, each func(json.RawMessage) error) error {
 	// Expect opening '['.
 	tok, err := dec.Token()
 	if err != nil {
@@ -115,75 +169,41 @@ func streamReportingStructure(
 		return fmt.Errorf("expected '[', got %v", tok)
 	}
 
-	structCount := 0
 	for dec.More() {
 		var raw json.RawMessage
 		if err := dec.Decode(&raw); err != nil {
 			return fmt.Errorf("decoding element: %w", err)
 		}
-
-		structCount++
-		if onStructure != nil {
-			onStructure(structCount)
-		}
-
-		// Pre-filter: skip elements that don't contain the plan ID as substring.
-		if !bytes.Contains(bytes.ToLower(raw), planIDLower) {
-			continue
-		}
-
-		// Full unmarshal of matching candidate.
-		var entry struct {
-			ReportingPlans []ReportingPlan `json:"reporting_plans"`
-			InNetworkFiles []InNetworkFile `json:"in_network_files"`
-		}
-		if err := json.Unmarshal(raw, &entry); err != nil {
-			continue // skip malformed
-		}
-
-		// Check for exact case-insensitive match on plan_id.
-		matched := false
-		for _, plan := range entry.ReportingPlans {
-			if strings.EqualFold(plan.PlanID, planID) {
-				matched = true
-				break
-			}
-		}
-		if !matched {
-			continue
-		}
-
-		result.MatchedStructures++
-
-		// Collect deduplicated URLs in insertion order.
-		for _, f := range entry.InNetworkFiles {
-			if f.Location == "" {
-				continue
-			}
-			if _, exists := seen[f.Location]; !exists {
-				seen[f.Location] = struct{}{}
-				result.URLs = append(result.URLs, f.Location)
-			}
+		if err := each(raw); err != nil {
+			return err
 		}
 	}
 
 	// Expect closing ']'.
-	tok, err = dec.Token()
-	if err != nil {
+	if _, err := dec.Token(); err != nil {
 		return fmt.Errorf("reading array end: %w", err)
 	}
 
 	return nil
 }
 
-// FetchAndResolve downloads a TOC file from tocURL, optionally decompresses
-// gzip, and resolves in-network MRF URLs for the given planID.
+// FetchAndResolve downloads a TOC file from tocURL, decompresses it if it is
+// gzip, zstd, or bzip2, and resolves in-network MRF URLs for the given planID.
 func FetchAndResolve(ctx context.Context, tocURL, planID string, onProgress func(downloaded, total int64)) (*ResolveResult, error) {
+	r, err := openTOC(ctx, tocURL, onProgress)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ResolveTOC(r, planID, nil)
+}
+
+// openTOC downloads the TOC at tocURL, returning its decompressed content.
+func openTOC(ctx context.Context, tocURL string, onProgress func(downloaded, total int64)) (io.ReadCloser, error) {
 	resp, err := worker.DownloadHTTP(ctx, tocURL)
 	if err != nil {
 		return nil, fmt.Errorf("downloading TOC: %w", err)
 	}
-	defer resp.Body.Close()
 
 	var reader io.Reader = resp.Body
 	if onProgress != nil {
@@ -194,20 +214,28 @@ func FetchAndResolve(ctx context.Context, tocURL, planID string, onProgress func
 		}
 	}
 
-	// Detect gzip: check Content-Type or URL suffix.
-	contentType := resp.Header.Get("Content-Type")
-	isGzip := strings.Contains(contentType, "gzip") || strings.HasSuffix(strings.ToLower(tocURL), ".gz")
-
-	if isGzip {
-		gzReader, err := worker.NewGzipReader(reader, false)
-		if err != nil {
-			return nil, fmt.Errorf("gzip reader: %w", err)
-		}
-		defer gzReader.Close()
-		reader = gzReader
+	// The compression format (gzip, zstd, bzip2, or none) is sniffed from
+	// the content itself.
+	dr, err := worker.NewDecompressReader(reader, false)
+	if err != nil {
+		resp.Body.Close()
+		return nil, fmt.Errorf("decompress: %w", err)
 	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{dr, closeBoth{dr, resp.Body}}, nil
+}
+
+// closeBoth closes a decompressor and the body under it.
+type closeBoth [2]io.Closer
 
-	return ResolveTOC(reader, planID, nil)
+func (c closeBoth) Close() error {
+	err := c[0].Close()
+	if err2 := c[1].Close(); err == nil {
+		err = err2
+	}
+	return err
 }
 
 // skipValue reads and discards the next JSON value from the decoder.
@@ -1,8 +1,13 @@
 package toc
 
 import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestResolveTOC_BasicMatch(t *testing.T) {
@@ -257,3 +262,125 @@ func TestResolveTOC_ReportingEntityName(t *testing.T) {
 		t.Errorf("expected reporting entity name %q, got %q", "Acme Health Insurance", result.ReportingEntityName)
 	}
 }
+
+func TestListPlans(t *testing.T) {
+	tocJSON := `{
+	"reporting_entity_name": "Acme",
+	"reporting_structure": [
+		{
+			"reporting_plans": [
+				{"plan_name": "Gold", "plan_id_type": "HIOS", "plan_id": "12345"},
+				{"plan_name": "Silver", "plan_id_type": "EIN", "plan_id": "99-000"}
+			],
+			"in_network_files": [
+				{"location": "https://example.com/a.json.gz"},
+				{"location": "https://example.com/b.json.gz"}
+			]
+		},
+		{
+			"reporting_plans": [
+				{"plan_name": "Gold ", "plan_id_type": "HIOS", "plan_id": "12345"}
+			],
+			"in_network_files": [
+				{"location": "https://example.com/b.json.gz"},
+				{"location": "https://example.com/c.json.gz"}
+			]
+		}
+	]
+}`
+
+	result, err := ListPlans(strings.NewReader(tocJSON), nil)
+	if err != nil {
+		t.Fatalf("ListPlans failed: %v", err)
+	}
+	if result.ReportingEntityName != "Acme" || result.Structures != 2 {
+		t.Errorf("got entity %q, %d structures", result.ReportingEntityName, result.Structures)
+	}
+	want := []PlanFiles{
+		{ReportingPlan: ReportingPlan{PlanName: "Gold", PlanIDType: "HIOS", PlanID: "12345"}, Structures: 2, Files: 3},
+		{ReportingPlan: ReportingPlan{PlanName: "Silver", PlanIDType: "EIN", PlanID: "99-000"}, Structures: 1, Files: 2},
+	}
+	if len(result.Plans) != len(want) {
+		t.Fatalf("expected %d plans, got %+v", len(want), result.Plans)
+	}
+	for i, w := range want {
+		if got := result.Plans[i]; got.ReportingPlan != w.ReportingPlan || got.Structures != w.Structures || got.Files != w.Files {
+			t.Errorf("plan %d: got %+v, want %+v", i, got, w)
+		}
+	}
+}
+
+func TestScrapeTOCLinks(t *testing.T) {
+	page := `<html><body>
+<a href="https://cdn.example.com/mrf/2026-09-01_acme_index.json?Expires=1790000000&amp;Signature=abc">September</a>
+<a href="https://cdn.example.com/mrf/2026-10-01_acme_index.json?Expires=1790000000&amp;Signature=def">October</a>
+<a href="https://cdn.example.com/mrf/2026-10-01_acme_in-network-rates.json.gz">Not a TOC</a>
+<script>{"toc":"https:\/\/cdn.example.com\/mrf\/2026-10_table-of-contents.json.gz"}</script>
+<a href="https://cdn.example.com/mrf/2026-10-01_acme_index.json?Expires=1790000000&amp;Signature=def">Again</a>
+</body></html>`
+
+	got := ScrapeTOCLinks([]byte(page))
+	want := []string{
+		"https://cdn.example.com/mrf/2026-09-01_acme_index.json?Expires=1790000000&Signature=abc",
+		"https://cdn.example.com/mrf/2026-10-01_acme_index.json?Expires=1790000000&Signature=def",
+		"https://cdn.example.com/mrf/2026-10_table-of-contents.json.gz",
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("ScrapeTOCLinks:\n got  %v\n want %v", got, want)
+	}
+
+	urls, month := PickMonth(got, time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC))
+	if month != "2026-10" || len(urls) != 2 {
+		t.Errorf("October: expected the 2 October URLs, got %s %v", month, urls)
+	}
+	// November isn't out yet: the latest earlier month is used.
+	urls, month = PickMonth(got, time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC))
+	if month != "2026-10" || len(urls) != 2 {
+		t.Errorf("November: expected to fall back to October, got %s %v", month, urls)
+	}
+	urls, month = PickMonth(got, time.Date(2026, 9, 1, 0, 0, 0, 0, time.UTC))
+	if month != "2026-09" || len(urls) != 1 {
+		t.Errorf("September: expected the September URL, got %s %v", month, urls)
+	}
+}
+
+func TestDiscoverAt(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/blobs":
+			fmt.Fprintf(w, `{"blobs": [
+				{"name": "2026-10-01_Acme_index.json", "downloadUrl": "http://%[1]s/a/2026-10-01_Acme_index.json"},
+				{"name": "2026-10-01_Acme_in-network-rates.json.gz", "downloadUrl": "http://%[1]s/a/rates.json.gz"},
+				{"name": "2026-09-01_Acme_index.json", "downloadUrl": "http://%[1]s/a/2026-09-01_Acme_index.json"}
+			]}`, r.Host)
+		case "/t/2026-09-01_acme_index.json.gz":
+			w.Write([]byte("{}"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+	oct := time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)
+
+	res, err := DiscoverAt(ctx, Payer{Name: "Acme", kind: discoverBlobs}, srv.URL+"/blobs", oct)
+	if err != nil {
+		t.Fatalf("blobs: %v", err)
+	}
+	if res.Month != "2026-10" || len(res.URLs) != 1 || !strings.HasSuffix(res.URLs[0], "/a/2026-10-01_Acme_index.json") {
+		t.Errorf("blobs: expected the October index, got %+v", res)
+	}
+
+	// October's file isn't there yet, so September's is found.
+	res, err = DiscoverAt(ctx, Payer{Name: "Acme", kind: discoverTemplate}, srv.URL+"/t/{date}_acme_index.json.gz", oct)
+	if err != nil {
+		t.Fatalf("template: %v", err)
+	}
+	if res.Month != "2026-09" || len(res.URLs) != 1 || res.URLs[0] != srv.URL+"/t/2026-09-01_acme_index.json.gz" {
+		t.Errorf("template: expected September's file, got %+v", res)
+	}
+
+	if _, err := DiscoverAt(ctx, Payer{Name: "Acme"}, srv.URL+"/missing", oct); err == nil {
+		t.Error("scrape: expected an error for a missing page")
+	}
+}
@@ -0,0 +1,125 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AuthError is returned for downloads the server refused with HTTP 401 or
+// 403. Retrying these doesn't help, so they fail the file at once.
+type AuthError struct {
+	StatusCode int
+	Signed     bool // the URL carries a signature (e.g. X-Amz-Signature)
+	Expired    bool // the URL's expiry time has passed, or the server said it expired
+}
+
+func (e *AuthError) Error() string {
+	switch {
+	case e.Expired:
+		return fmt.Sprintf("HTTP %d: signed URL expired", e.StatusCode)
+	case e.Signed:
+		return fmt.Sprintf("HTTP %d: signed URL refused", e.StatusCode)
+	default:
+		return fmt.Sprintf("HTTP %d: access denied", e.StatusCode)
+	}
+}
+
+// StatusError is a response with an unexpected HTTP status other than the
+// 401s and 403s that are AuthErrors.
+type StatusError struct {
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("HTTP %d", e.StatusCode)
+}
+
+// newAuthError describes a 401 or 403 response to a GET of rawURL. It reads
+// the start of the body, where S3, GCS and Azure say when a signature
+// has expired; the caller closes it.
+func newAuthError(rawURL string, resp *http.Response) *AuthError {
+	e := &AuthError{StatusCode: resp.StatusCode}
+	u, err := url.Parse(rawURL)
+	if err == nil {
+		q := u.Query()
+		e.Signed = isSigned(q)
+		if exp, ok := signatureExpiry(q); ok && time.Now().After(exp) {
+			e.Expired = true
+		}
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 2048))
+	if e.Signed && strings.Contains(strings.ToLower(string(body)), "expired") {
+		e.Expired = true
+	}
+	return e
+}
+
+// signatureParams are query parameters that mark a signed URL: S3 and GCS
+// (v4 and v2), CloudFront, and Azure SAS.
+var signatureParams = []string{"X-Amz-Signature", "X-Goog-Signature", "Signature", "sig"}
+
+func isSigned(q url.Values) bool {
+	for _, p := range signatureParams {
+		if q.Has(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// signatureExpiry returns when a signed URL's signature expires, from its
+// X-Amz-/X-Goog-Date plus -Expires, Expires (Unix seconds) or se (Azure).
+func signatureExpiry(q url.Values) (time.Time, bool) {
+	for _, prefix := range []string{"X-Amz-", "X-Goog-"} {
+		date, secs := q.Get(prefix+"Date"), q.Get(prefix+"Expires")
+		if date == "" || secs == "" {
+			continue
+		}
+		t, err := time.Parse("20060102T150405Z", date)
+		n, nerr := strconv.ParseInt(secs, 10, 64)
+		if err == nil && nerr == nil {
+			return t.Add(time.Duration(n) * time.Second), true
+		}
+	}
+	if v := q.Get("Expires"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return time.Unix(n, 0), true
+		}
+	}
+	if v := q.Get("se"); v != "" {
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func isAuthError(err error) bool {
+	var ae *AuthError
+	return errors.As(err, &ae)
+}
+
+// ErrorHint suggests what to do about a failed file, or returns "" if
+// there is nothing specific to suggest.
+func ErrorHint(err error) string {
+	var ae *AuthError
+	if !errors.As(err, &ae) {
+		return ""
+	}
+	switch {
+	case ae.Expired:
+		return "the URL's signature has expired; resolve the TOC again (toc resolve, or --toc-url with --plan-id) for fresh URLs, or pass --url-refresh-cmd to do so mid-run"
+	case ae.Signed:
+		return "the signed URL was refused; it may have expired or been issued to another client, so resolve the TOC again for fresh URLs"
+	case ae.StatusCode == http.StatusUnauthorized:
+		return "the server wants credentials; pass them with --header \"Authorization: ...\" and check the token is current"
+	default:
+		return "check any --header credentials or token; some hosts also refuse requests from cloud or foreign IP ranges"
+	}
+}
@@ -0,0 +1,310 @@
+package worker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gyeh/npi-rates/internal/cache"
+	"github.com/gyeh/npi-rates/internal/logx"
+)
+
+// downloadCache keeps compressed MRFs between runs; see SetCacheDir.
+var downloadCache *fileCache
+
+// SetCacheDir keeps a copy of every MRF downloaded in full under dir, keyed
+// by URL and ETag (or Last-Modified, for files without one). Later downloads
+// of the URL ask the server whether the file changed since (If-None-Match /
+// If-Modified-Since) and read the copy if not, as they do when a server
+// ignores the question but sends the same strong ETag; a changed file
+// replaces the copy. Files served without an ETag or Last-Modified
+// header are not cached, since there would be no way to tell, nor are those
+// without a Content-Length, whose copy couldn't be checked for truncation.
+// "" disables the cache.
+func SetCacheDir(dir string) error {
+	if dir == "" {
+		downloadCache = nil
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("creating cache dir: %w", err)
+	}
+	downloadCache = &fileCache{dir: dir}
+	return nil
+}
+
+// fileCache stores each URL's entry as <key>.json, and the body it
+// describes as <body key>.body, where the body key covers the URL and the
+// validator too, so that an entry never names the body of another version.
+type fileCache struct {
+	dir string
+}
+
+// cacheEntry describes a cached body.
+type cacheEntry struct {
+	URL          string    `json:"url"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	Size         int64     `json:"size"`
+	Fetched      time.Time `json:"fetched"`
+	Body         string    `json:"body"` // file name in the cache dir
+
+	path string // of the body
+}
+
+// setConditional makes req fetch the file only if it differs from e.
+func (e *cacheEntry) setConditional(req *http.Request) {
+	if e.ETag != "" {
+		req.Header.Set("If-None-Match", e.ETag)
+	}
+	if e.LastModified != "" {
+		req.Header.Set("If-Modified-Since", e.LastModified)
+	}
+}
+
+func (c *fileCache) key(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:16]))
+}
+
+// bodyName names the file holding the version of e.URL that e describes.
+func (e *cacheEntry) bodyName() string {
+	validator := e.ETag
+	if validator == "" {
+		validator = e.LastModified
+	}
+	sum := sha256.Sum256([]byte(e.URL + "\x00" + validator))
+	return hex.EncodeToString(sum[:16]) + ".body"
+}
+
+// sameVersion reports whether a full response for e's URL is the version e
+// describes: it has the same strong ETag and length.
+func (e *cacheEntry) sameVersion(resp *http.Response) bool {
+	etag := resp.Header.Get("ETag")
+	return etag != "" && !strings.HasPrefix(etag, "W/") && etag == e.ETag && resp.ContentLength == e.Size
+}
+
+// lookup returns url's entry, or nil if there is none or its body is gone.
+func (c *fileCache) lookup(url string) *cacheEntry {
+	key := c.key(url)
+	data, err := os.ReadFile(key + ".json")
+	if err != nil {
+		return nil
+	}
+	var e cacheEntry
+	if json.Unmarshal(data, &e) != nil || e.URL != url || e.Body != e.bodyName() {
+		return nil
+	}
+	e.path = filepath.Join(c.dir, e.Body)
+	if fi, err := os.Stat(e.path); err != nil || fi.Size() != e.Size {
+		return nil
+	}
+	return &e
+}
+
+// open is openDownload through the cache: an unchanged file is read from
+// disk; otherwise the response body is copied into the cache as it is read.
+func (c *fileCache) open(ctx context.Context, url string) (*http.Response, error) {
+	cached := c.lookup(url)
+	resp, err := openNetwork(ctx, url, 0, "", cached)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotModified || (cached != nil && cached.sameVersion(resp)) {
+		resp.Body.Close()
+		return c.cachedResponse(ctx, cached, resp)
+	}
+
+	e := &cacheEntry{
+		URL:          url,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		Fetched:      time.Now(),
+	}
+	e.Body = e.bodyName()
+	key := c.key(url)
+	if (e.ETag == "" && e.LastModified == "") || resp.ContentLength < 0 {
+		// Changed, but uncacheable: drop any stale copy.
+		os.Remove(key + ".json")
+		if cached != nil {
+			os.Remove(cached.path)
+		}
+		return resp, nil
+	}
+	tmp, err := os.CreateTemp(c.dir, "*.part")
+	if err != nil {
+		logx.Debugf(ctx, "cache: %v", err)
+		return resp, nil
+	}
+	w := &cacheWriter{
+		ReadCloser: resp.Body,
+		tmp:        tmp,
+		key:        key,
+		entry:      e,
+		want:       resp.ContentLength,
+	}
+	if cached != nil && cached.Body != e.Body {
+		w.prev = cached.path
+	}
+	resp.Body = w
+	return resp, nil
+}
+
+// cachedResponse answers a download from e's body, in place of resp.
+func (c *fileCache) cachedResponse(ctx context.Context, e *cacheEntry, resp *http.Response) (*http.Response, error) {
+	f, err := os.Open(e.path)
+	if err != nil {
+		return nil, fmt.Errorf("reading cached copy: %w", err)
+	}
+	logx.Debugf(ctx, "%s: unchanged since %s, using cached copy", FileNameFromURL(e.URL), e.Fetched.Local().Format("Jan 2 15:04"))
+	cache.Touch(c.key(e.URL) + ".json")
+	h := http.Header{}
+	if e.ETag != "" {
+		h.Set("ETag", e.ETag)
+	}
+	if e.LastModified != "" {
+		h.Set("Last-Modified", e.LastModified)
+	}
+	return &http.Response{
+		Status:        "200 OK (cached)",
+		StatusCode:    http.StatusOK,
+		Header:        h,
+		Body:          f,
+		ContentLength: e.Size,
+		Request:       resp.Request,
+	}, nil
+}
+
+// cacheWriter copies a response body into the cache as it is read, and
+// commits the copy once the body has been read to the end intact.
+type cacheWriter struct {
+	io.ReadCloser
+	tmp   *os.File
+	key   string
+	entry *cacheEntry
+	want  int64  // Content-Length
+	prev  string // body of the version this one replaces, if any
+	n     int64
+	err   error // from writing the copy; the download itself goes on
+	done  bool
+}
+
+func (w *cacheWriter) Read(p []byte) (int, error) {
+	n, err := w.ReadCloser.Read(p)
+	if n > 0 && w.err == nil {
+		_, w.err = w.tmp.Write(p[:n])
+		w.n += int64(n)
+	}
+	if err == io.EOF && !w.done {
+		w.done = true
+		w.commit()
+	}
+	return n, err
+}
+
+func (w *cacheWriter) Close() error {
+	if !w.done {
+		w.done = true
+		w.tmp.Close()
+		os.Remove(w.tmp.Name())
+	}
+	return w.ReadCloser.Close()
+}
+
+// commit moves a complete copy into place, then writes its entry.
+func (w *cacheWriter) commit() {
+	closeErr := w.tmp.Close()
+	if w.err != nil || closeErr != nil || w.n != w.want {
+		os.Remove(w.tmp.Name())
+		return
+	}
+	w.entry.Size = w.n
+	data, err := json.Marshal(w.entry)
+	if err != nil {
+		os.Remove(w.tmp.Name())
+		return
+	}
+	// Each version has its own body file, so the entry can be replaced
+	// once the new body is in place; a crash before that leaves the old
+	// entry describing the old body.
+	dir := filepath.Dir(w.key)
+	if os.Rename(w.tmp.Name(), filepath.Join(dir, w.entry.Body)) != nil {
+		os.Remove(w.tmp.Name())
+		return
+	}
+	if os.WriteFile(w.key+".json.tmp", data, 0o644) != nil || os.Rename(w.key+".json.tmp", w.key+".json") != nil {
+		return
+	}
+	if w.prev != "" {
+		os.Remove(w.prev)
+	}
+}
+
+// staleDownload is how old a .part file must be to count as left by a
+// crashed run rather than a download in progress.
+const staleDownload = 24 * time.Hour
+
+// DownloadCacheEntries lists the download cache in dir (see SetCacheDir)
+// for the cache package. An entry was last used when it was written or last
+// read instead of downloading. Bodies without an entry and partial copies
+// of crashed runs are listed too, so they are collected with the rest.
+func DownloadCacheEntries(dir string) ([]cache.Entry, error) {
+	files, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &fileCache{dir: dir}
+	var entries []cache.Entry
+	listed := make(map[string]bool)
+	for _, f := range files {
+		name := f.Name()
+		if key, ok := strings.CutSuffix(name, ".json"); ok {
+			data, err := os.ReadFile(filepath.Join(dir, name))
+			var e cacheEntry
+			if err != nil || json.Unmarshal(data, &e) != nil || c.key(e.URL) != filepath.Join(dir, key) || e.Body != e.bodyName() {
+				continue
+			}
+			info, err := f.Info()
+			if err != nil {
+				continue
+			}
+			body := filepath.Join(dir, e.Body)
+			entries = append(entries, cache.Entry{
+				Name:     e.URL,
+				Paths:    []string{filepath.Join(dir, name), body},
+				Size:     info.Size() + fileSize(body),
+				LastUsed: info.ModTime(),
+			})
+			listed[e.Body] = true
+		}
+	}
+	for _, f := range files {
+		name := f.Name()
+		orphan := strings.HasSuffix(name, ".body") && !listed[name]
+		if !orphan && !strings.HasSuffix(name, ".part") {
+			continue
+		}
+		info, err := f.Info()
+		if err != nil || (!orphan && time.Since(info.ModTime()) < staleDownload) {
+			continue
+		}
+		entries = append(entries, cache.Entry{
+			Name:     name + " (incomplete)",
+			Paths:    []string{filepath.Join(dir, name)},
+			Size:     info.Size(),
+			LastUsed: info.ModTime(),
+		})
+	}
+	return entries, nil
+}
@@ -0,0 +1,291 @@
+package worker
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/gyeh/npi-rates/internal/mrf"
+)
+
+// checkpointVersion is written in the checkpoint header; files with another
+// version are rejected rather than misread.
+const checkpointVersion = 1
+
+// checkpointLine is one NDJSON line of a checkpoint file. The first line is
+// a header carrying the version and search key. After it, each completed
+// file contributes its result lines followed by a done line; the lines of one
+// file are always contiguous.
+type checkpointLine struct {
+	Version int    `json:"checkpoint,omitempty"`
+	Search  string `json:"search,omitempty"`
+
+	URL               string          `json:"url,omitempty"`
+	Result            *mrf.RateResult `json:"result,omitempty"`
+	Done              bool            `json:"done,omitempty"`
+	Count             int             `json:"count,omitempty"`
+	CompressedBytes   int64           `json:"compressed_bytes,omitempty"`
+	DecompressedBytes int64           `json:"decompressed_bytes,omitempty"`
+	SourceURL         string          `json:"source_url,omitempty"` // if not URL
+}
+
+// checkpointEntry locates a completed file's lines in the checkpoint.
+type checkpointEntry struct {
+	start, end int64
+	result     PipelineResult // URL, SourceURL, Count and transfer sizes only
+}
+
+// Checkpoint records which files of a search have completed, together with
+// their results, so an interrupted search can be restarted without
+// searching them again. Only successful files are recorded. A record that
+// was being written when the process died is discarded on reopening.
+type Checkpoint struct {
+	path string
+
+	mu    sync.Mutex
+	f     *os.File
+	w     *bufio.Writer
+	enc   *json.Encoder
+	off   int64 // file size, including buffered writes
+	start int64 // offset of the first line of the file being recorded
+	done  map[string]checkpointEntry
+}
+
+// OpenCheckpoint opens the checkpoint at path, creating it if needed.
+// search identifies the search (NPIs, TINs, filters); a checkpoint written
+// for a different search is refused, since its results would not belong in
+// this one.
+func OpenCheckpoint(path, search string) (*Checkpoint, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("opening checkpoint: %w", err)
+	}
+	c := &Checkpoint{path: path, f: f, done: make(map[string]checkpointEntry)}
+
+	valid, err := c.load(search)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	// Drop any partial record at the end so new records follow a complete one.
+	if err := f.Truncate(valid); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("truncating checkpoint: %w", err)
+	}
+	if _, err := f.Seek(valid, io.SeekStart); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("seeking checkpoint: %w", err)
+	}
+	c.w = bufio.NewWriterSize(&offsetWriter{w: f, off: &c.off}, 1<<20)
+	c.enc = json.NewEncoder(c.w)
+	c.off = valid
+	c.start = valid
+
+	if valid == 0 {
+		if err := c.enc.Encode(checkpointLine{Version: checkpointVersion, Search: search}); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("writing checkpoint: %w", err)
+		}
+		if err := c.w.Flush(); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("writing checkpoint: %w", err)
+		}
+		c.start = c.off
+	}
+	return c, nil
+}
+
+// load indexes the completed files in an existing checkpoint and returns the
+// offset just past the last complete record (0 for an empty file).
+func (c *Checkpoint) load(search string) (int64, error) {
+	r := bufio.NewReaderSize(c.f, 1<<20)
+	var (
+		off, valid int64
+		curURL     string
+		curStart   int64
+	)
+	for lineNo := 0; ; lineNo++ {
+		raw, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			break // a line without its newline is a partial write
+		}
+		if err != nil {
+			return 0, fmt.Errorf("reading checkpoint: %w", err)
+		}
+		lineStart := off
+		off += int64(len(raw))
+
+		var line checkpointLine
+		if err := json.Unmarshal(bytes.TrimSpace(raw), &line); err != nil {
+			if lineNo == 0 {
+				return 0, fmt.Errorf("%s is not a checkpoint file: %w", c.path, err)
+			}
+			break
+		}
+		if lineNo == 0 {
+			if line.Version != checkpointVersion {
+				return 0, fmt.Errorf("%s is not a version %d checkpoint file", c.path, checkpointVersion)
+			}
+			if line.Search != search {
+				return 0, fmt.Errorf("checkpoint %s was written for a different search (%s); delete it to start over", c.path, line.Search)
+			}
+			valid = off
+			continue
+		}
+		if line.URL != curURL {
+			curURL, curStart = line.URL, lineStart
+		}
+		if line.Done {
+			c.done[line.URL] = checkpointEntry{
+				start: curStart,
+				end:   off,
+				result: PipelineResult{
+					URL:               line.URL,
+					SourceURL:         cmp.Or(line.SourceURL, line.URL),
+					Count:             line.Count,
+					CompressedBytes:   line.CompressedBytes,
+					DecompressedBytes: line.DecompressedBytes,
+				},
+			}
+			valid = off
+			curURL = ""
+		}
+	}
+	return valid, nil
+}
+
+// Len returns the number of completed files recorded.
+func (c *Checkpoint) Len() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.done)
+}
+
+// completed returns the recorded result for url (without its rates), if
+// url has completed.
+func (c *Checkpoint) completed(url string) (PipelineResult, bool) {
+	if c == nil {
+		return PipelineResult{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.done[url]
+	return e.result, ok
+}
+
+// replay feeds the rates recorded for url to fn in batches.
+func (c *Checkpoint) replay(url string, fn func([]mrf.RateResult) error) error {
+	c.mu.Lock()
+	e, ok := c.done[url]
+	c.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	f, err := os.Open(c.path)
+	if err != nil {
+		return fmt.Errorf("reading checkpoint: %w", err)
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(bufio.NewReaderSize(io.NewSectionReader(f, e.start, e.end-e.start), 1<<20))
+	batch := make([]mrf.RateResult, 0, replayBatchSize)
+	for {
+		var line checkpointLine
+		err := dec.Decode(&line)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("reading checkpoint: %w", err)
+		}
+		if line.Result == nil || line.URL != url {
+			continue // lines of an abandoned record may precede url's
+		}
+		batch = append(batch, *line.Result)
+		if len(batch) == replayBatchSize {
+			if err := fn(batch); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+	if len(batch) > 0 {
+		return fn(batch)
+	}
+	return nil
+}
+
+// add appends a batch of url's results. Calls for one file must not be
+// interleaved with another file's; the pool serializes them.
+func (c *Checkpoint) add(url string, batch []mrf.RateResult) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := range batch {
+		if err := c.enc.Encode(checkpointLine{URL: url, Result: &batch[i]}); err != nil {
+			return fmt.Errorf("writing checkpoint: %w", err)
+		}
+	}
+	return nil
+}
+
+// finish marks r's file complete and flushes the record to disk. Until
+// then, the file's results are not considered recorded.
+func (c *Checkpoint) finish(url string, r *PipelineResult) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	line := checkpointLine{
+		URL:               url,
+		Done:              true,
+		Count:             r.Count,
+		CompressedBytes:   r.CompressedBytes,
+		DecompressedBytes: r.DecompressedBytes,
+	}
+	if r.SourceURL != url {
+		line.SourceURL = r.SourceURL
+	}
+	err := c.enc.Encode(line)
+	if err == nil {
+		err = c.w.Flush()
+	}
+	if err != nil {
+		return fmt.Errorf("writing checkpoint: %w", err)
+	}
+	c.done[url] = checkpointEntry{
+		start:  c.start,
+		end:    c.off,
+		result: PipelineResult{URL: url, SourceURL: r.SourceURL, Count: r.Count, CompressedBytes: r.CompressedBytes, DecompressedBytes: r.DecompressedBytes},
+	}
+	c.start = c.off
+	return nil
+}
+
+// Close flushes and closes the checkpoint file.
+func (c *Checkpoint) Close() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return errors.Join(c.w.Flush(), c.f.Close())
+}
+
+// offsetWriter tracks how many bytes have reached the file.
+type offsetWriter struct {
+	w   io.Writer
+	off *int64
+}
+
+func (o *offsetWriter) Write(p []byte) (int, error) {
+	n, err := o.w.Write(p)
+	*o.off += int64(n)
+	return n, err
+}
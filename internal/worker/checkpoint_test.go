@@ -0,0 +1,152 @@
+package worker
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gyeh/npi-rates/internal/mrf"
+	"github.com/gyeh/npi-rates/internal/progress"
+)
+
+// TestCheckpointResume verifies that a rerun with the same checkpoint skips
+// completed files, delivers their recorded results, and searches the rest.
+func TestCheckpointResume(t *testing.T) {
+	server := serveGzippedMRF(t, buildTestMRF())
+	defer server.Close()
+
+	var requests atomic.Int32
+	counting := server.Config.Handler
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		counting.ServeHTTP(w, r)
+	})
+
+	path := filepath.Join(t.TempDir(), "search.ckpt")
+	first := []string{server.URL + "/file1.json.gz", server.URL + "/file2.json.gz"}
+	all := append(first, server.URL+"/file3.json.gz")
+
+	run := func(urls []string, onResults bool) ([]PipelineResult, map[string]int) {
+		ckpt, err := OpenCheckpoint(path, "npi=1316924913")
+		if err != nil {
+			t.Fatalf("OpenCheckpoint: %v", err)
+		}
+		defer ckpt.Close()
+		delivered := map[string]int{}
+		pool := &Pool{
+			Workers:    2,
+			Search:     &mrf.SearchOptions{NPIs: map[int64]struct{}{1316924913: {}}},
+			TmpDir:     t.TempDir(),
+			Progress:   &progress.NoopManager{},
+			Stream:     true,
+			Checkpoint: ckpt,
+		}
+		if onResults {
+			pool.OnResults = func(batch []mrf.RateResult) error {
+				for _, r := range batch {
+					delivered[r.SourceFile]++
+				}
+				return nil
+			}
+		}
+		return pool.Run(context.Background(), urls), delivered
+	}
+
+	results, _ := run(first, false)
+	for i, r := range results {
+		if r.Err != nil || len(r.Results) != 4 {
+			t.Fatalf("first run file %d: err=%v results=%d", i, r.Err, len(r.Results))
+		}
+	}
+
+	requests.Store(0)
+	results, delivered := run(all, true)
+	for i, r := range results {
+		if r.Err != nil || r.Count != 4 {
+			t.Errorf("resumed run file %d: err=%v count=%d", i, r.Err, r.Count)
+		}
+		if delivered[all[i]] != 4 {
+			t.Errorf("resumed run file %d: expected 4 delivered results, got %d", i, delivered[all[i]])
+		}
+	}
+	// Only file3 is fetched; the streaming pipeline makes a single GET.
+	if n := requests.Load(); n != 1 {
+		t.Errorf("expected 1 request on resume, got %d", n)
+	}
+
+	// A third run finds everything recorded, including results spooled
+	// through OnResults.
+	requests.Store(0)
+	results, _ = run(all, false)
+	for i, r := range results {
+		if r.Err != nil || len(r.Results) != 4 {
+			t.Errorf("third run file %d: err=%v results=%d", i, r.Err, len(r.Results))
+		}
+	}
+	if n := requests.Load(); n != 0 {
+		t.Errorf("expected no requests once all files are recorded, got %d", n)
+	}
+}
+
+// TestCheckpointPartialRecord verifies that a record cut off mid-write is
+// discarded and the file searched again.
+func TestCheckpointPartialRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "search.ckpt")
+	ckpt, err := OpenCheckpoint(path, "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch := []mrf.RateResult{{NPI: 1, BillingCode: "99213"}}
+	if err := ckpt.add("a", batch); err != nil {
+		t.Fatal(err)
+	}
+	if err := ckpt.finish("a", &PipelineResult{Count: 1}); err != nil {
+		t.Fatal(err)
+	}
+	ckpt.Close()
+
+	// Simulate dying while writing file b's record.
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"url":"b","result":{"npi":2}}` + "\n" + `{"url":"b","do`)
+	f.Close()
+
+	ckpt, err = OpenCheckpoint(path, "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ckpt.completed("b"); ok {
+		t.Error("partial record for b was treated as complete")
+	}
+	prior, ok := ckpt.completed("a")
+	if !ok || prior.Count != 1 {
+		t.Fatalf("record for a lost: ok=%v count=%d", ok, prior.Count)
+	}
+	if err := ckpt.add("c", batch); err != nil {
+		t.Fatal(err)
+	}
+	if err := ckpt.finish("c", &PipelineResult{Count: 1}); err != nil {
+		t.Fatal(err)
+	}
+	var got []mrf.RateResult
+	ckpt.replay("c", func(b []mrf.RateResult) error { got = append(got, b...); return nil })
+	if len(got) != 1 || got[0].BillingCode != "99213" {
+		t.Errorf("replay of c = %+v", got)
+	}
+	ckpt.Close()
+
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), `"do`+"\n") || strings.Contains(string(data), `"url":"b"`) {
+		t.Errorf("partial record left in checkpoint:\n%s", data)
+	}
+
+	if _, err := OpenCheckpoint(path, "other"); err == nil {
+		t.Error("expected a checkpoint for a different search to be refused")
+	}
+}
@@ -0,0 +1,93 @@
+package worker
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// tmpArtifact matches the names of what the pipeline leaves in a temp dir
+// while a file is in progress: decompressed downloads, per-attempt work
+// dirs (with split output and the FIFO), FIFO probes, resumable partial
+// downloads, result spools and download spills. A crashed run leaves them
+// behind. The random parts are matched exactly, since the default temp dir
+// is shared with other programs.
+var tmpArtifact = regexp.MustCompile(`^(mrf-\d+\.json|work-\d+|fifo-probe-\d+\.fifo|dl-[0-9a-f]{16}\.part|results-\d+\.ndjson|spill-\d+\.mrf)$`)
+
+// CleanTmpDir removes pipeline artifacts in dir that crashed runs left
+// behind: those matching tmpArtifact that haven't been modified for
+// olderThan (for a work dir, nothing in it has), so files of runs still
+// using dir are kept. FIFO probes of live processes are also kept. It
+// returns how many artifacts were removed and the bytes they held.
+func CleanTmpDir(dir string, olderThan time.Duration) (removed int, freed int64) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, 0
+	}
+	cutoff := time.Now().Add(-olderThan)
+	for _, e := range entries {
+		name := e.Name()
+		if !tmpArtifact.MatchString(name) {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		if pid, ok := probePID(name); ok && processAlive(pid) {
+			continue
+		}
+		newest, size := treeStat(path)
+		if newest.After(cutoff) {
+			continue
+		}
+		if os.RemoveAll(path) == nil {
+			removed++
+			freed += size
+		}
+	}
+	return removed, freed
+}
+
+// probePID returns the process ID in a "fifo-probe-<pid>.fifo" name.
+func probePID(name string) (int, bool) {
+	s, ok := strings.CutPrefix(name, "fifo-probe-")
+	if !ok {
+		return 0, false
+	}
+	pid, err := strconv.Atoi(strings.TrimSuffix(s, ".fifo"))
+	return pid, err == nil
+}
+
+// treeStat returns the latest modification time and total size of the
+// files under path (path itself, if it is a file).
+func treeStat(path string) (newest time.Time, size int64) {
+	filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return newest, size
+}
+
+// processAlive reports whether a process with the given ID exists.
+func processAlive(pid int) bool {
+	if pid == os.Getpid() {
+		return true
+	}
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
@@ -0,0 +1,55 @@
+package worker
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestCleanTmpDir verifies that stale pipeline artifacts are removed while
+// fresh ones, other files and a live process's FIFO probe are kept.
+func TestCleanTmpDir(t *testing.T) {
+	dir := t.TempDir()
+	old := time.Now().Add(-48 * time.Hour)
+	write := func(name string, size int, mtime time.Time) {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, make([]byte, size), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(path, mtime, mtime)
+	}
+
+	write("mrf-123.json", 100, old)
+	write("dl-0123456789abcdef.part", 50, old)
+	write("work-1/split/in_network_00.jsonl", 10, old)
+	write("work-2/split/in_network_00.jsonl", 10, old)
+	write("work-2/split/in_network_01.jsonl", 10, time.Now()) // still being written
+	write("results-9.ndjson", 5, time.Now())
+	write("notes.txt", 5, old)
+	write("fifo-probe-99999999.fifo", 0, old)
+	write("fifo-probe-"+strconv.Itoa(os.Getpid())+".fifo", 0, old)
+	for _, d := range []string{"work-1", "work-1/split", "work-2", "work-2/split"} {
+		os.Chtimes(filepath.Join(dir, d), old, old)
+	}
+
+	removed, freed := CleanTmpDir(dir, 24*time.Hour)
+	if removed != 4 || freed != 160 {
+		t.Errorf("expected 4 artifacts (160 bytes) removed, got %d (%d bytes)", removed, freed)
+	}
+	for _, name := range []string{"work-2", "results-9.ndjson", "notes.txt", "fifo-probe-" + strconv.Itoa(os.Getpid()) + ".fifo"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("expected %s to be kept: %v", name, err)
+		}
+	}
+	for _, name := range []string{"mrf-123.json", "dl-0123456789abcdef.part", "work-1", "fifo-probe-99999999.fifo"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed", name)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package worker
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+)
+
+// Clock is the source of time for retry backoff. Tests replace it with
+// SetClock to run retry paths without actually waiting.
+type Clock interface {
+	Now() time.Time
+	// Sleep waits for d, returning ctx.Err() early if ctx is done first.
+	Sleep(ctx context.Context, d time.Duration) error
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) Sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+var (
+	clock Clock = realClock{}
+	// randFloat returns a value in [0, 1) for backoff jitter.
+	randFloat = rand.Float64
+)
+
+// SetClock replaces the clock used for retry delays. A nil clock restores
+// the real one.
+func SetClock(c Clock) {
+	if c == nil {
+		c = realClock{}
+	}
+	clock = c
+}
+
+// SetRandSource replaces the source of backoff jitter, which must return
+// values in [0, 1). A nil source restores the default.
+func SetRandSource(f func() float64) {
+	if f == nil {
+		f = rand.Float64
+	}
+	randFloat = f
+}
+
+// backoffJitter is the spread applied to retry delays, so workers that
+// failed together (e.g. on one CDN hiccup) don't retry in lockstep.
+const backoffJitter = 0.2
+
+// jittered scales d by a random factor in [1-backoffJitter, 1+backoffJitter).
+func jittered(d time.Duration) time.Duration {
+	return time.Duration(float64(d) * (1 - backoffJitter + 2*backoffJitter*randFloat()))
+}
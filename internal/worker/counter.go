@@ -0,0 +1,67 @@
+package worker
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/gyeh/npi-rates/internal/progress"
+)
+
+const (
+	// counterFlushEvery publishes the counter unconditionally every N increments.
+	counterFlushEvery = 10_000
+	// counterFlushInterval publishes the counter if this much time has passed
+	// since the last update, so slow phases (huge elements) still show movement.
+	counterFlushInterval = 100 * time.Millisecond
+	// counterClockEvery limits how often Inc reads the clock.
+	counterClockEvery = 256
+)
+
+// throttledCounter coalesces per-record counter increments before forwarding
+// them to a progress.Tracker. The parse callbacks fire millions of times per
+// file; calling SetCounter on each one costs more than the parsing it reports
+// on. Safe for concurrent use.
+type throttledCounter struct {
+	name     string
+	tracker  progress.Tracker
+	n        atomic.Int64
+	lastNano atomic.Int64
+}
+
+func newThrottledCounter(name string, tracker progress.Tracker) *throttledCounter {
+	c := &throttledCounter{name: name, tracker: tracker}
+	c.lastNano.Store(time.Now().UnixNano())
+	return c
+}
+
+// Inc adds one to the counter, publishing it if either threshold is reached.
+func (c *throttledCounter) Inc() {
+	n := c.n.Add(1)
+	if n%counterFlushEvery == 0 {
+		c.publish(n, time.Now().UnixNano())
+		return
+	}
+	if n%counterClockEvery != 0 {
+		return
+	}
+	now := time.Now().UnixNano()
+	last := c.lastNano.Load()
+	if now-last >= int64(counterFlushInterval) && c.lastNano.CompareAndSwap(last, now) {
+		c.tracker.SetCounter(c.name, n)
+	}
+}
+
+// Value returns the current count.
+func (c *throttledCounter) Value() int64 {
+	return c.n.Load()
+}
+
+// Flush publishes the exact current value. Call when the phase completes.
+func (c *throttledCounter) Flush() {
+	c.publish(c.n.Load(), time.Now().UnixNano())
+}
+
+func (c *throttledCounter) publish(n, now int64) {
+	c.lastNano.Store(now)
+	c.tracker.SetCounter(c.name, n)
+}
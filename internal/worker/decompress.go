@@ -0,0 +1,127 @@
+package worker
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/klauspost/pgzip"
+)
+
+// Format is a compression format recognized by NewDecompressReader.
+type Format string
+
+const (
+	FormatGzip  Format = "gzip"
+	FormatZstd  Format = "zstd"
+	FormatBzip2 Format = "bzip2"
+	FormatNone  Format = "none"
+)
+
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	zstdMagic  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	bzip2Magic = []byte("BZh")
+	utf8BOM    = []byte("\xef\xbb\xbf")
+)
+
+// sniffLen is how many leading bytes SniffFormat is given.
+const sniffLen = 64
+
+// zstdMaxWindow allows frames written with `zstd --long=31`, which some
+// payers use for their largest files. The default limit rejects them.
+const zstdMaxWindow = 1 << 31
+
+// SniffFormat identifies the compression format from the first bytes of a
+// stream. Anything that is not a known compressed format but looks like the
+// start of a JSON document is FormatNone.
+func SniffFormat(head []byte) (Format, error) {
+	switch {
+	case bytes.HasPrefix(head, gzipMagic):
+		return FormatGzip, nil
+	case bytes.HasPrefix(head, zstdMagic):
+		return FormatZstd, nil
+	case bytes.HasPrefix(head, bzip2Magic):
+		return FormatBzip2, nil
+	}
+	trimmed := bytes.TrimLeft(bytes.TrimPrefix(head, utf8BOM), " \t\r\n")
+	if len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') {
+		return FormatNone, nil
+	}
+	if len(head) == 0 {
+		return "", errors.New("empty input")
+	}
+	return "", fmt.Errorf("unrecognized compression format (leading bytes % x)", head[:min(len(head), 4)])
+}
+
+// NewDecompressReader returns a reader that decompresses r, choosing the
+// decoder from the stream's magic bytes: gzip, zstd, bzip2, or uncompressed
+// JSON passed through as is. For gzip, useStdGzip selects the standard
+// library's single-threaded compress/gzip (more reliable) over pgzip
+// (parallel, faster, but can produce mid-stream corruption on very large
+// files); it is ignored for the other formats.
+//
+// Every decoder verifies its format's integrity check (gzip CRC-32/ISIZE,
+// zstd frame checksum, bzip2 block CRCs) when read to EOF.
+func NewDecompressReader(r io.Reader, useStdGzip bool) (io.ReadCloser, error) {
+	br := bufio.NewReaderSize(r, 64*1024)
+	// Enough to see past a BOM and leading whitespace in uncompressed JSON.
+	head, err := br.Peek(sniffLen)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	format, err := SniffFormat(head)
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case FormatGzip:
+		if useStdGzip {
+			return gzip.NewReader(br)
+		}
+		return pgzip.NewReader(br)
+	case FormatZstd:
+		d, err := zstd.NewReader(br, zstd.WithDecoderMaxWindow(zstdMaxWindow))
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	case FormatBzip2:
+		return io.NopCloser(bzip2.NewReader(br)), nil
+	default:
+		// The JSON decoders reject a byte order mark.
+		if bytes.HasPrefix(head, utf8BOM) {
+			br.Discard(len(utf8BOM))
+		}
+		return io.NopCloser(br), nil
+	}
+}
+
+// formatFromURL guesses the compression format from a URL's file extension,
+// returning "" when the extension says nothing.
+func formatFromURL(rawURL string) Format {
+	p := rawURL
+	if u, err := url.Parse(rawURL); err == nil {
+		p = u.Path
+	}
+	switch strings.ToLower(path.Ext(p)) {
+	case ".gz", ".gzip":
+		return FormatGzip
+	case ".zst", ".zstd":
+		return FormatZstd
+	case ".bz2":
+		return FormatBzip2
+	case ".json":
+		return FormatNone
+	}
+	return ""
+}
@@ -0,0 +1,81 @@
+package worker
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// bzip2Doc is `printf '{"a":1}' | bzip2`; the standard library has no bzip2
+// writer.
+var bzip2Doc = []byte{
+	0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0x3a, 0xdf,
+	0x03, 0x60, 0x00, 0x00, 0x02, 0x99, 0x80, 0x10, 0x00, 0x20, 0x10, 0x20,
+	0x00, 0x00, 0x0a, 0x20, 0x00, 0x21, 0x80, 0x0c, 0x02, 0x5b, 0x06, 0xdc,
+	0x5d, 0xc9, 0x14, 0xe1, 0x42, 0x40, 0xeb, 0x7c, 0x0d, 0x80,
+}
+
+// TestNewDecompressReader_SniffsFormat verifies that each supported format
+// is detected from its magic bytes and decoded, with both gzip decoders.
+func TestNewDecompressReader_SniffsFormat(t *testing.T) {
+	const doc = `{"a":1}`
+
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write([]byte(doc))
+	gw.Close()
+
+	var zs bytes.Buffer
+	zw, err := zstd.NewWriter(&zs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw.Write([]byte(doc))
+	zw.Close()
+
+	tests := []struct {
+		name   string
+		input  []byte
+		stdGz  bool
+		format Format
+	}{
+		{"gzip", gz.Bytes(), false, FormatGzip},
+		{"gzip std", gz.Bytes(), true, FormatGzip},
+		{"zstd", zs.Bytes(), false, FormatZstd},
+		{"bzip2", bzip2Doc, false, FormatBzip2},
+		{"plain", []byte(doc), false, FormatNone},
+		{"plain with BOM and whitespace", []byte("\xef\xbb\xbf\n " + doc), false, FormatNone},
+	}
+	for _, tt := range tests {
+		if f, err := SniffFormat(tt.input); err != nil || f != tt.format {
+			t.Errorf("%s: SniffFormat = %q, %v; want %q", tt.name, f, err, tt.format)
+		}
+		r, err := NewDecompressReader(bytes.NewReader(tt.input), tt.stdGz)
+		if err != nil {
+			t.Fatalf("%s: NewDecompressReader: %v", tt.name, err)
+		}
+		got, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatalf("%s: reading: %v", tt.name, err)
+		}
+		if strings.TrimSpace(string(got)) != doc {
+			t.Errorf("%s: got %q, want %q", tt.name, got, doc)
+		}
+	}
+}
+
+// TestNewDecompressReader_RejectsUnknown verifies that input that is neither
+// a known compressed format nor JSON fails up front instead of reaching the
+// parser.
+func TestNewDecompressReader_RejectsUnknown(t *testing.T) {
+	for _, input := range []string{"PK\x03\x04zipdata", "<html>", ""} {
+		if _, err := NewDecompressReader(strings.NewReader(input), false); err == nil {
+			t.Errorf("%q: expected an error", input)
+		}
+	}
+}
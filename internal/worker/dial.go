@@ -0,0 +1,242 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gyeh/npi-rates/internal/httpx"
+	"github.com/gyeh/npi-rates/internal/logx"
+)
+
+const (
+	// raceStagger is the head start each candidate address gets before the
+	// next one is dialed (RFC 8305 recommends 250ms).
+	raceStagger = 250 * time.Millisecond
+	// raceWidth caps how many addresses are dialed for a single connection.
+	raceWidth = 4
+)
+
+// multiIPDialer connects to hosts that publish several A/AAAA records by
+// racing staggered connection attempts across all resolved addresses and
+// keeping the first to complete. Payer CDNs often front a file with many
+// edges of very different quality; racing avoids getting pinned to one that
+// is unreachable or slow to accept.
+//
+// With rotation enabled, each new connection to a host starts from the next
+// address in the list, so a retry after a failed or throttled download lands
+// on a different edge instead of the one the resolver happens to list first.
+type multiIPDialer struct {
+	dialer *net.Dialer
+	lookup func(ctx context.Context, host string) ([]net.IPAddr, error)
+
+	mu     sync.Mutex
+	rotate bool
+	family int            // 4 or 6 to dial that family first; 0 interleaves
+	next   map[string]int // per-host rotation offset
+}
+
+func newMultiIPDialer() *multiIPDialer {
+	return &multiIPDialer{
+		dialer: &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second},
+		lookup: net.DefaultResolver.LookupIPAddr,
+		next:   make(map[string]int),
+	}
+}
+
+// defaultDialer dials the connections of the httpx clients.
+var defaultDialer = newMultiIPDialer()
+
+func init() {
+	httpx.SetDialer(defaultDialer.DialContext)
+}
+
+// SetIPRotation enables rotating through a host's resolved addresses on
+// successive connections (and therefore between download retries).
+func SetIPRotation(enabled bool) {
+	defaultDialer.mu.Lock()
+	defaultDialer.rotate = enabled
+	defaultDialer.mu.Unlock()
+}
+
+// SetIPVersion makes connections try the host's addresses of one IP family,
+// 4 or 6, before any of the other, which is only dialed once all of the
+// first have failed; 0 interleaves the families (the default).
+func SetIPVersion(family int) error {
+	if family != 0 && family != 4 && family != 6 {
+		return fmt.Errorf("IP version must be 4, 6 or auto, got %d", family)
+	}
+	defaultDialer.mu.Lock()
+	defaultDialer.family = family
+	defaultDialer.mu.Unlock()
+	return nil
+}
+
+// DialContext implements http.Transport.DialContext.
+func (d *multiIPDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	if net.ParseIP(host) != nil {
+		return d.dialer.DialContext(ctx, network, address)
+	}
+
+	ips := pinnedAddrs(host, port)
+	if ips != nil {
+		logx.Debugf(ctx, "dial: %s pinned by --resolve", host)
+	} else if ips, err = d.lookup(ctx, host); err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no addresses for %s", host)
+	}
+
+	candidates := d.order(host, ips)
+	first, fallback := d.split(candidates)
+	conn, err := d.race(ctx, network, port, first)
+	if err == nil || len(fallback) == 0 || ctx.Err() != nil {
+		return conn, err
+	}
+	logx.Debugf(ctx, "dial: no %s address of %s connected (%v); falling back", familyName(first[0]), host, err)
+	return d.race(ctx, network, port, fallback)
+}
+
+// split divides ordered candidates into those raced first and, with a
+// preferred family set, those of the other family to fall back to, each
+// capped at raceWidth. With no preference, or addresses of one family
+// only, there is no fallback.
+func (d *multiIPDialer) split(ordered []net.IPAddr) (first, fallback []net.IPAddr) {
+	d.mu.Lock()
+	family := d.family
+	d.mu.Unlock()
+	if family != 0 {
+		for _, ip := range ordered {
+			if (ip.IP.To4() != nil) == (family == 4) {
+				first = append(first, ip)
+			} else {
+				fallback = append(fallback, ip)
+			}
+		}
+		if len(first) == 0 {
+			first, fallback = fallback, nil
+		}
+	} else {
+		first = ordered
+	}
+	if len(first) > raceWidth {
+		first = first[:raceWidth]
+	}
+	if len(fallback) > raceWidth {
+		fallback = fallback[:raceWidth]
+	}
+	return first, fallback
+}
+
+func familyName(ip net.IPAddr) string {
+	if ip.IP.To4() != nil {
+		return "IPv4"
+	}
+	return "IPv6"
+}
+
+// order returns ips interleaved by address family (RFC 8305 §4), rotated by
+// the host's offset when rotation is enabled.
+func (d *multiIPDialer) order(host string, ips []net.IPAddr) []net.IPAddr {
+	var v4, v6 []net.IPAddr
+	for _, ip := range ips {
+		if ip.IP.To4() != nil {
+			v4 = append(v4, ip)
+		} else {
+			v6 = append(v6, ip)
+		}
+	}
+	ordered := make([]net.IPAddr, 0, len(ips))
+	for i := 0; i < len(v4) || i < len(v6); i++ {
+		if i < len(v6) {
+			ordered = append(ordered, v6[i])
+		}
+		if i < len(v4) {
+			ordered = append(ordered, v4[i])
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.rotate || len(ordered) < 2 {
+		return ordered
+	}
+	off := d.next[host] % len(ordered)
+	d.next[host] = off + 1
+	return append(ordered[off:], ordered[:off]...)
+}
+
+// race dials candidates with a staggered start, returning the first
+// connection established and closing any that complete later. A failed
+// attempt starts the next candidate immediately rather than waiting out the
+// stagger.
+func (d *multiIPDialer) race(ctx context.Context, network, port string, candidates []net.IPAddr) (net.Conn, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan dialResult, len(candidates))
+	started, pending := 0, 0
+	startNext := func() {
+		addr := net.JoinHostPort(candidates[started].String(), port)
+		started++
+		pending++
+		go func() {
+			conn, err := d.dialer.DialContext(ctx, network, addr)
+			results <- dialResult{conn, err}
+		}()
+	}
+
+	startNext()
+	stagger := time.NewTimer(raceStagger)
+	defer stagger.Stop()
+
+	var errs []error
+	for pending > 0 {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				logx.Debugf(ctx, "dial: connected to %s (%d of %d candidates tried)", r.conn.RemoteAddr(), started, len(candidates))
+				go closeLosers(results, pending)
+				return r.conn, nil
+			}
+			errs = append(errs, r.err)
+			if started < len(candidates) {
+				startNext()
+			}
+		case <-stagger.C:
+			if started < len(candidates) {
+				startNext()
+				stagger.Reset(raceStagger)
+			}
+		case <-ctx.Done():
+			go closeLosers(results, pending)
+			return nil, ctx.Err()
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+type dialResult struct {
+	conn net.Conn
+	err  error
+}
+
+// closeLosers waits for the n outstanding race attempts and closes any
+// connection they managed to establish. The race context is cancelled by the
+// time this runs, so the remaining dials return promptly.
+func closeLosers(results <-chan dialResult, n int) {
+	for ; n > 0; n-- {
+		if r := <-results; r.conn != nil {
+			r.conn.Close()
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package worker
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gyeh/npi-rates/internal/httpx"
+	"github.com/gyeh/npi-rates/internal/objstore"
+)
+
+func staticLookup(ips ...string) func(context.Context, string) ([]net.IPAddr, error) {
+	return func(context.Context, string) ([]net.IPAddr, error) {
+		var addrs []net.IPAddr
+		for _, ip := range ips {
+			addrs = append(addrs, net.IPAddr{IP: net.ParseIP(ip)})
+		}
+		return addrs, nil
+	}
+}
+
+// TestMultiIPDialer_FallsBackPastDeadAddress verifies that a refused address
+// does not fail the connection when another resolved address is reachable.
+func TestMultiIPDialer_FallsBackPastDeadAddress(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+	_, port, _ := net.SplitHostPort(u.Host)
+
+	d := newMultiIPDialer()
+	// 127.0.0.2 shares loopback but nothing listens on it.
+	d.lookup = staticLookup("127.0.0.2", "127.0.0.1")
+
+	conn, err := d.DialContext(context.Background(), "tcp", net.JoinHostPort("cdn.example.com", port))
+	if err != nil {
+		t.Fatalf("DialContext failed: %v", err)
+	}
+	defer conn.Close()
+	if got := conn.RemoteAddr().(*net.TCPAddr).IP.String(); got != "127.0.0.1" {
+		t.Errorf("expected connection to 127.0.0.1, got %s", got)
+	}
+}
+
+func TestMultiIPDialer_Order(t *testing.T) {
+	ips, _ := staticLookup("10.0.0.1", "10.0.0.2", "2001:db8::1", "10.0.0.3")(context.Background(), "")
+
+	d := newMultiIPDialer()
+	got := d.order("h", ips)
+	want := []string{"2001:db8::1", "10.0.0.1", "10.0.0.2", "10.0.0.3"}
+	for i := range want {
+		if got[i].IP.String() != want[i] {
+			t.Fatalf("interleave: got %v, want %v", got, want)
+		}
+	}
+	if first := d.order("h", ips)[0].IP.String(); first != want[0] {
+		t.Errorf("without rotation expected stable order, got first %s", first)
+	}
+
+	d.rotate = true
+	for i := 0; i < 5; i++ {
+		first := d.order("h", ips)[0].IP.String()
+		if expect := want[i%len(want)]; first != expect {
+			t.Errorf("rotation %d: expected first %s, got %s", i, expect, first)
+		}
+	}
+	if first := d.order("other", ips)[0].IP.String(); first != want[0] {
+		t.Errorf("rotation offset should be per host, got first %s", first)
+	}
+}
+
+// TestSetResolve verifies that a pinned host is dialed at its pinned
+// address without being resolved, and that specs parse as curl's do.
+func TestSetResolve(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Host))
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+	_, port, _ := net.SplitHostPort(u.Host)
+
+	if err := SetResolve([]string{"mrf.invalid:" + port + ":127.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { SetResolve(nil) })
+	resp, err := httpx.Short.Get("http://mrf.invalid:" + port + "/")
+	if err != nil {
+		t.Fatalf("GET pinned host: %v", err)
+	}
+	resp.Body.Close()
+	if _, err := httpx.Short.Get("http://mrf.invalid:1/"); err == nil {
+		t.Error("expected a pin for one port not to apply to another")
+	}
+
+	for spec, want := range map[string]string{
+		"Host.example:10.0.0.1":              "host.example: [10.0.0.1]",
+		"h:443:10.0.0.1,10.0.0.2":            "h:443 [10.0.0.1 10.0.0.2]",
+		"h:[2001:db8::1]":                    "h: [2001:db8::1]",
+		"h:8443:[2001:db8::1],[2001:db8::2]": "h:8443 [2001:db8::1 2001:db8::2]",
+	} {
+		key, ips, err := parseResolve(spec)
+		if err != nil {
+			t.Errorf("%s: %v", spec, err)
+			continue
+		}
+		var got []string
+		for _, ip := range ips {
+			got = append(got, ip.IP.String())
+		}
+		if s := key + " [" + strings.Join(got, " ") + "]"; s != want {
+			t.Errorf("%s: got %s, want %s", spec, s, want)
+		}
+	}
+	for _, spec := range []string{"h", ":10.0.0.1", "h:443:cdn.example"} {
+		if _, _, err := parseResolve(spec); err == nil {
+			t.Errorf("%s: expected an error", spec)
+		}
+	}
+}
+
+func TestMultiIPDialer_Family(t *testing.T) {
+	ips, _ := staticLookup("10.0.0.1", "2001:db8::1", "10.0.0.2", "2001:db8::2")(context.Background(), "")
+	str := func(addrs []net.IPAddr) string {
+		var s []string
+		for _, a := range addrs {
+			s = append(s, a.IP.String())
+		}
+		return strings.Join(s, " ")
+	}
+
+	d := newMultiIPDialer()
+	first, fallback := d.split(d.order("h", ips))
+	if str(first) != "2001:db8::1 10.0.0.1 2001:db8::2 10.0.0.2" || fallback != nil {
+		t.Errorf("auto: got %q then %q, want both families interleaved and no fallback", str(first), str(fallback))
+	}
+	d.family = 4
+	first, fallback = d.split(d.order("h", ips))
+	if str(first) != "10.0.0.1 10.0.0.2" || str(fallback) != "2001:db8::1 2001:db8::2" {
+		t.Errorf("IPv4 first: got %q then %q", str(first), str(fallback))
+	}
+
+	// A host with no address of the preferred family is dialed as usual.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+	_, port, _ := net.SplitHostPort(u.Host)
+	d.family = 6
+	d.lookup = staticLookup("127.0.0.1")
+	conn, err := d.DialContext(context.Background(), "tcp", net.JoinHostPort("cdn.example.com", port))
+	if err != nil {
+		t.Fatalf("DialContext with IPv6 preferred and only IPv4 resolved: %v", err)
+	}
+	conn.Close()
+}
+
+// TestSetResolve_ObjectStore verifies that the object store clients dial
+// through the pins while http.DefaultTransport is left alone.
+func TestSetResolve_ObjectStore(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"abc"`)
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader("{}"))
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+	_, port, _ := net.SplitHostPort(u.Host)
+
+	dial := reflect.ValueOf(http.DefaultTransport.(*http.Transport).DialContext).Pointer()
+	if err := SetResolve([]string{"s3.invalid:" + port + ":127.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { SetResolve(nil) })
+	if err := SetIPVersion(4); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { SetIPVersion(0) })
+	t.Setenv("AWS_ENDPOINT_URL", "http://s3.invalid:"+port)
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	objstore.ResetClients()
+	defer objstore.ResetClients()
+
+	if n, err := objstore.Size(context.Background(), "s3://mrfs/in-network.json"); err != nil || n != 2 {
+		t.Errorf("Size = %d, %v; want 2", n, err)
+	}
+	if reflect.ValueOf(http.DefaultTransport.(*http.Transport).DialContext).Pointer() != dial {
+		t.Error("expected http.DefaultTransport's dialer to be left alone")
+	}
+}
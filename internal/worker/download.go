@@ -1,47 +1,109 @@
 package worker
 
 import (
-	"compress/gzip"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"math"
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/klauspost/pgzip"
+	"github.com/gyeh/npi-rates/internal/httpx"
+	"github.com/gyeh/npi-rates/internal/humanize"
+	"github.com/gyeh/npi-rates/internal/logx"
+	"github.com/gyeh/npi-rates/internal/objstore"
 )
 
-var httpClient = &http.Client{
-	Transport: &http.Transport{
-		MaxIdleConnsPerHost: 10,
-		MaxIdleConns:        100,
-		IdleConnTimeout:     90 * time.Second,
-	},
-	Timeout: 3 * time.Hour, // large files (50GB+) at slow CDN speeds can take over an hour
+// ErrTooSlow is returned by downloads whose throughput stayed below the
+// configured speed floor for a full window (see SetSpeedFloor).
+var ErrTooSlow = errors.New("download throughput below speed floor")
+
+// Errors wrapped into a file's failure to say which stage it failed in, for
+// errorCategory.
+var (
+	errTruncated  = errors.New("download truncated")
+	errDecompress = errors.New("decompress")
+	errCorrupt    = errors.New("decompression corrupt")
+	errSplit      = errors.New("split")
+	errParse      = errors.New("parse")
+)
+
+// speedFloor is the minimum acceptable download throughput. Throttling CDNs
+// tend to keep a connection alive at a trickle rather than drop it, so without
+// a floor such a download only ends when httpx.Long's 3-hour timeout fires.
+var speedFloor struct {
+	minBytesPerSec int64
+	window         time.Duration
+}
+
+// SetSpeedFloor makes downloads abort with ErrTooSlow when fewer than
+// minBytesPerSec bytes/s arrive over a full window. The pipeline treats this
+// like any other failed attempt and retries on a fresh connection.
+// minBytesPerSec <= 0 disables the check.
+func SetSpeedFloor(minBytesPerSec int64, window time.Duration) {
+	speedFloor.minBytesPerSec = minBytesPerSec
+	speedFloor.window = window
 }
 
 // DownloadResult holds the result of a download operation.
 type DownloadResult struct {
-	FilePath   string // path to decompressed temp file
-	TotalBytes int64  // compressed size from Content-Length (or -1)
+	FilePath          string // path to decompressed temp file
+	TotalBytes        int64  // compressed size from Content-Length (or -1)
+	CompressedBytes   int64  // compressed bytes actually received
+	DecompressedBytes int64  // bytes written after decompression
+}
+
+// Ratio returns the decompressed/compressed size ratio, or 0 if unknown.
+func (r *DownloadResult) Ratio() float64 {
+	if r.CompressedBytes <= 0 {
+		return 0
+	}
+	return float64(r.DecompressedBytes) / float64(r.CompressedBytes)
 }
 
 // DownloadHTTP performs an HTTP GET with retries and returns the response.
 // Caller is responsible for closing resp.Body.
 func DownloadHTTP(ctx context.Context, url string) (*http.Response, error) {
+	return downloadHTTP(ctx, url, 0, "", nil)
+}
+
+// downloadHTTP is DownloadHTTP for the bytes of url from offset on. A
+// ranged request carries If-Range with validator (an ETag or Last-Modified
+// value from the response that wrote the first offset bytes), so the server
+// answers 206 with the remainder only if the file is unchanged; otherwise,
+// or if it ignores ranges, it answers 200 with the whole file and the caller
+// must start over. An offset of 0 or an empty validator requests the whole
+// file.
+//
+// With cached set, the request is made conditional on the cached copy's
+// ETag and Last-Modified, and a 304 Not Modified response is returned as is
+// for the caller to serve the copy.
+func downloadHTTP(ctx context.Context, url string, offset int64, validator string, cached *cacheEntry) (*http.Response, error) {
+	if fetch := RewriteURL(url); fetch != url {
+		base, _, _ := strings.Cut(fetch, "?")
+		logx.Debugf(ctx, "GET %s: rewritten to %s", FileNameFromURL(url), base)
+		url = fetch
+	}
+	if objstore.IsObjectURL(url) {
+		return downloadObject(ctx, url, offset, validator, cached)
+	}
+	ranged := offset > 0 && validator != ""
 	var resp *http.Response
 	var err error
 
 	for attempt := 0; attempt < 3; attempt++ {
 		if attempt > 0 {
-			delay := time.Duration(math.Pow(2, float64(attempt))) * time.Second
-			select {
-			case <-ctx.Done():
-				return nil, ctx.Err()
-			case <-time.After(delay):
+			delay := jittered(time.Duration(math.Pow(2, float64(attempt))) * time.Second)
+			if err := clock.Sleep(ctx, delay); err != nil {
+				return nil, err
 			}
 		}
 
@@ -49,16 +111,52 @@ func DownloadHTTP(ctx context.Context, url string) (*http.Response, error) {
 		if reqErr != nil {
 			return nil, fmt.Errorf("creating request: %w", reqErr)
 		}
+		AddRequestHeaders(req)
+		if ranged {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+			req.Header.Set("If-Range", validator)
+		}
+		if cached != nil {
+			cached.setConditional(req)
+		}
 
-		resp, err = httpClient.Do(req)
+		resp, err = httpx.Long.Do(req)
 		if err != nil {
+			logx.Debugf(ctx, "GET %s: attempt %d/3 failed: %v", FileNameFromURL(url), attempt+1, err)
 			continue
 		}
+		countTransfer(resp)
 		if resp.StatusCode == http.StatusOK {
+			logx.Debugf(ctx, "GET %s: HTTP 200, Content-Length %d, proto %s", FileNameFromURL(url), resp.ContentLength, resp.Proto)
+			logResponseHeaders(ctx, resp)
+			limitBody(ctx, resp)
+			return resp, nil
+		}
+		if resp.StatusCode == http.StatusNotModified && cached != nil {
+			logx.Debugf(ctx, "GET %s: HTTP 304", FileNameFromURL(url))
 			return resp, nil
 		}
+		if resp.StatusCode == http.StatusPartialContent && ranged {
+			logx.Debugf(ctx, "GET %s: HTTP 206, resuming at byte %d", FileNameFromURL(url), offset)
+			logResponseHeaders(ctx, resp)
+			if start, ok := contentRangeStart(resp.Header.Get("Content-Range")); !ok || start != offset {
+				resp.Body.Close()
+				return nil, fmt.Errorf("resume at byte %d: unexpected Content-Range %q", offset, resp.Header.Get("Content-Range"))
+			}
+			limitBody(ctx, resp)
+			return resp, nil
+		}
+		logResponseHeaders(ctx, resp)
+		if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+			err = newAuthError(url, resp)
+		} else {
+			err = &StatusError{StatusCode: resp.StatusCode}
+		}
+		// Read a short error page out, so the connection can be reused and
+		// its bytes are counted.
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
 		resp.Body.Close()
-		err = fmt.Errorf("HTTP %d", resp.StatusCode)
+		logx.Debugf(ctx, "GET %s: attempt %d/3 failed: %v", FileNameFromURL(url), attempt+1, err)
 		if resp.StatusCode >= 400 && resp.StatusCode < 500 {
 			return nil, err // don't retry client errors
 		}
@@ -67,22 +165,140 @@ func DownloadHTTP(ctx context.Context, url string) (*http.Response, error) {
 	return nil, fmt.Errorf("download failed after retries: %w", err)
 }
 
-// NewGzipReader creates a gzip decompression reader. When useStdGzip is true,
-// it uses the standard library's single-threaded compress/gzip (more reliable).
-// Otherwise it uses pgzip (parallel, faster, but can produce mid-stream corruption
-// on very large files).
-func NewGzipReader(r io.Reader, useStdGzip bool) (io.ReadCloser, error) {
-	if useStdGzip {
-		return gzip.NewReader(r)
+// logResponseHeaders records a response's status and headers in the
+// context's file log; they are too noisy for --verbose.
+func logResponseHeaders(ctx context.Context, resp *http.Response) {
+	fl := logx.FileLogFrom(ctx)
+	if fl == nil {
+		return
+	}
+	fl.Printf("%s %s", resp.Proto, resp.Status)
+	keys := make([]string, 0, len(resp.Header))
+	for k := range resp.Header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fl.Printf("  %s: %s", k, strings.Join(resp.Header[k], ", "))
+	}
+}
+
+// contentRangeStart parses the first byte position of a Content-Range header
+// such as "bytes 1000-1999/5000".
+func contentRangeStart(v string) (int64, bool) {
+	rest, ok := strings.CutPrefix(v, "bytes ")
+	if !ok {
+		return 0, false
+	}
+	first, _, ok := strings.Cut(rest, "-")
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(first, 10, 64)
+	return n, err == nil
+}
+
+// openDownload issues the GET for url via DownloadHTTP and, when a speed floor
+// is configured, wraps the response body in a watchdog that cancels the
+// request once throughput stays below the floor. Callers must close resp.Body.
+func openDownload(ctx context.Context, url string) (*http.Response, error) {
+	return openDownloadRange(ctx, url, 0, "")
+}
+
+// openDownloadRange is openDownload for a ranged request (see downloadHTTP).
+// Whole-file requests go through the download cache when one is set.
+func openDownloadRange(ctx context.Context, url string, offset int64, validator string) (*http.Response, error) {
+	if offset == 0 && downloadCache != nil {
+		return downloadCache.open(ctx, url)
+	}
+	return openNetwork(ctx, url, offset, validator, nil)
+}
+
+// openNetwork is openDownloadRange without the cache; see downloadHTTP for
+// cached.
+func openNetwork(ctx context.Context, url string, offset int64, validator string, cached *cacheEntry) (*http.Response, error) {
+	if speedFloor.minBytesPerSec <= 0 || speedFloor.window <= 0 {
+		return downloadHTTP(ctx, url, offset, validator, cached)
 	}
-	return pgzip.NewReader(r)
+
+	dlCtx, cancel := context.WithCancelCause(ctx)
+	resp, err := downloadHTTP(dlCtx, url, offset, validator, cached)
+	if err != nil {
+		cancel(nil)
+		return nil, err
+	}
+	w := &speedWatchdog{
+		ReadCloser: resp.Body,
+		ctx:        dlCtx,
+		cancel:     cancel,
+		done:       make(chan struct{}),
+	}
+	go w.run(speedFloor.minBytesPerSec, speedFloor.window)
+	resp.Body = w
+	return resp, nil
 }
 
-// DownloadAndDecompress downloads a gzipped URL, decompresses, and writes to a temp file.
+// speedWatchdog counts body bytes and cancels the request (with ErrTooSlow as
+// the cause) if a full window passes with throughput below the floor. The
+// check runs on a timer, so it also fires when the stream stalls completely.
+type speedWatchdog struct {
+	io.ReadCloser
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	read   atomic.Int64
+	done   chan struct{}
+	once   sync.Once
+}
+
+func (w *speedWatchdog) Read(p []byte) (int, error) {
+	n, err := w.ReadCloser.Read(p)
+	w.read.Add(int64(n))
+	if err != nil && err != io.EOF {
+		// Surface the watchdog's reason instead of a bare "context canceled".
+		if cause := context.Cause(w.ctx); errors.Is(cause, ErrTooSlow) {
+			err = cause
+		}
+	}
+	return n, err
+}
+
+func (w *speedWatchdog) Close() error {
+	w.once.Do(func() {
+		close(w.done)
+		w.cancel(nil)
+	})
+	return w.ReadCloser.Close()
+}
+
+func (w *speedWatchdog) run(minBytesPerSec int64, window time.Duration) {
+	ticker := time.NewTicker(window)
+	defer ticker.Stop()
+	var prev int64
+	for {
+		select {
+		case <-ticker.C:
+			cur := w.read.Load()
+			rate := float64(cur-prev) / window.Seconds()
+			if rate < float64(minBytesPerSec) {
+				logx.Debugf(w.ctx, "speed floor: %s over %s, aborting", humanize.Rate(rate), window)
+				w.cancel(fmt.Errorf("%w: %s over the last %s (floor %s)",
+					ErrTooSlow, humanize.Rate(rate), window, humanize.Rate(float64(minBytesPerSec))))
+				return
+			}
+			prev = cur
+		case <-w.done:
+			return
+		case <-w.ctx.Done():
+			return
+		}
+	}
+}
+
+// DownloadAndDecompress downloads a compressed URL (see NewDecompressReader), decompresses, and writes to a temp file.
 // When useStdGzip is true, uses standard compress/gzip instead of pgzip for more reliable decompression.
 // onProgress is called with (bytesDownloaded, totalBytes) during download.
 func DownloadAndDecompress(ctx context.Context, url string, tmpDir string, useStdGzip bool, onProgress func(downloaded, total int64)) (*DownloadResult, error) {
-	resp, err := DownloadHTTP(ctx, url)
+	resp, err := openDownload(ctx, url)
 	if err != nil {
 		return nil, err
 	}
@@ -97,60 +313,76 @@ func DownloadAndDecompress(ctx context.Context, url string, tmpDir string, useSt
 			reader:   resp.Body,
 			total:    totalBytes,
 			callback: onProgress,
+			url:      url,
 		}
 	}
 
 	// Count compressed bytes actually read
 	countReader := &countingReader{reader: reader}
 
-	// Decompress
-	gzReader, err := NewGzipReader(countReader, useStdGzip)
+	path, written, err := decompressToTemp(countReader, tmpDir, useStdGzip)
 	if err != nil {
-		return nil, fmt.Errorf("gzip reader: %w", err)
+		return nil, err
+	}
+
+	// Verify the full compressed payload was received. Without a Content-Length
+	// (chunked responses) this relies on the gzip reader, which checks the
+	// trailer's CRC-32 and ISIZE at EOF and fails on a truncated stream.
+	if totalBytes > 0 && countReader.n != totalBytes {
+		os.Remove(path)
+		return nil, fmt.Errorf("%w: got %d of %d compressed bytes", errTruncated, countReader.n, totalBytes)
+	}
+	downloadSizes.record(url, countReader.n)
+	downloadSizes.recordRatio(countReader.n, written)
+
+	// Verify decompressed JSON is structurally intact (starts with '{', ends with '}')
+	if err := verifyJSONBrackets(path); err != nil {
+		os.Remove(path)
+		return nil, fmt.Errorf("%w: %w", errCorrupt, err)
+	}
+
+	return &DownloadResult{
+		FilePath:          path,
+		TotalBytes:        totalBytes,
+		CompressedBytes:   countReader.n,
+		DecompressedBytes: written,
+	}, nil
+}
+
+// decompressToTemp decompresses r into a new temp file in tmpDir and returns
+// its path and size. The file is removed on error.
+func decompressToTemp(r io.Reader, tmpDir string, useStdGzip bool) (string, int64, error) {
+	gzReader, err := NewDecompressReader(r, useStdGzip)
+	if err != nil {
+		return "", 0, fmt.Errorf("%w: %w", errDecompress, err)
 	}
 	defer gzReader.Close()
 
-	// Write decompressed data to temp file
 	tmpFile, err := os.CreateTemp(tmpDir, "mrf-*.json")
 	if err != nil {
-		return nil, fmt.Errorf("creating temp file: %w", err)
+		return "", 0, fmt.Errorf("creating temp file: %w", err)
 	}
 
-	_, err = io.Copy(tmpFile, gzReader)
+	written, err := io.Copy(tmpFile, gzReader)
 	if closeErr := tmpFile.Close(); closeErr != nil && err == nil {
 		err = closeErr
 	}
 	if err != nil {
 		os.Remove(tmpFile.Name())
-		return nil, fmt.Errorf("writing decompressed data: %w", err)
-	}
-
-	// Verify the full compressed payload was received
-	if totalBytes > 0 && countReader.n != totalBytes {
-		os.Remove(tmpFile.Name())
-		return nil, fmt.Errorf("download truncated: got %d of %d compressed bytes", countReader.n, totalBytes)
+		return "", 0, fmt.Errorf("writing decompressed data: %w", err)
 	}
-
-	// Verify decompressed JSON is structurally intact (starts with '{', ends with '}')
-	if err := verifyJSONBrackets(tmpFile.Name()); err != nil {
-		os.Remove(tmpFile.Name())
-		return nil, fmt.Errorf("decompression corrupt: %w", err)
-	}
-
-	return &DownloadResult{
-		FilePath:   tmpFile.Name(),
-		TotalBytes: totalBytes,
-	}, nil
+	return tmpFile.Name(), written, nil
 }
 
-// StreamDecompressToPath downloads a gzipped URL, decompresses, and writes
+// StreamDecompressToPath downloads a compressed URL, decompresses, and writes
 // to the specified path. The path can be a regular file or a FIFO (named pipe).
 // When useStdGzip is true, uses standard compress/gzip instead of pgzip.
 // For FIFOs, this blocks on open until a reader opens the other end.
-func StreamDecompressToPath(ctx context.Context, url string, destPath string, useStdGzip bool, onProgress func(downloaded, total int64)) error {
-	resp, err := DownloadHTTP(ctx, url)
+// The returned DownloadResult's FilePath is destPath.
+func StreamDecompressToPath(ctx context.Context, url string, destPath string, useStdGzip bool, onProgress func(downloaded, total int64)) (*DownloadResult, error) {
+	resp, err := openDownload(ctx, url)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
@@ -162,34 +394,44 @@ func StreamDecompressToPath(ctx context.Context, url string, destPath string, us
 			reader:   resp.Body,
 			total:    totalBytes,
 			callback: onProgress,
+			url:      url,
 		}
 	}
 
 	countReader := &countingReader{reader: reader}
 
-	gzReader, err := NewGzipReader(countReader, useStdGzip)
+	gzReader, err := NewDecompressReader(countReader, useStdGzip)
 	if err != nil {
-		return fmt.Errorf("gzip reader: %w", err)
+		return nil, fmt.Errorf("%w: %w", errDecompress, err)
 	}
 	defer gzReader.Close()
 
 	// Open destination for writing. For FIFOs, this blocks until a reader opens the other end.
 	f, err := os.OpenFile(destPath, os.O_WRONLY, 0)
 	if err != nil {
-		return fmt.Errorf("opening dest path: %w", err)
+		return nil, fmt.Errorf("opening dest path: %w", err)
 	}
 	defer f.Close()
 
-	if _, err := io.Copy(f, gzReader); err != nil {
-		return fmt.Errorf("writing decompressed data: %w", err)
+	written, err := io.Copy(f, gzReader)
+	if err != nil {
+		return nil, fmt.Errorf("writing decompressed data: %w", err)
 	}
 
-	// Verify the full compressed payload was received
+	// Verify the full compressed payload was received (see DownloadAndDecompress
+	// for responses without a Content-Length).
 	if totalBytes > 0 && countReader.n != totalBytes {
-		return fmt.Errorf("download truncated: got %d of %d compressed bytes", countReader.n, totalBytes)
+		return nil, fmt.Errorf("%w: got %d of %d compressed bytes", errTruncated, countReader.n, totalBytes)
 	}
+	downloadSizes.record(url, countReader.n)
+	downloadSizes.recordRatio(countReader.n, written)
 
-	return nil
+	return &DownloadResult{
+		FilePath:          destPath,
+		TotalBytes:        totalBytes,
+		CompressedBytes:   countReader.n,
+		DecompressedBytes: written,
+	}, nil
 }
 
 // verifyJSONBrackets checks that a file starts with '{' and ends with '}'.
@@ -276,13 +518,21 @@ type progressReader struct {
 	downloaded int64
 	total      int64
 	callback   func(downloaded, total int64)
+
+	// url, when set and total is unknown, lets the reader report an
+	// estimated total from downloadSizes instead of raw bytes only.
+	url string
 }
 
 func (pr *progressReader) Read(p []byte) (int, error) {
 	n, err := pr.reader.Read(p)
 	if n > 0 {
 		pr.downloaded += int64(n)
-		pr.callback(pr.downloaded, pr.total)
+		total := pr.total
+		if total <= 0 && pr.url != "" {
+			total = downloadSizes.estimate(pr.url, pr.downloaded)
+		}
+		pr.callback(pr.downloaded, total)
 	}
 	return n, err
 }
@@ -0,0 +1,693 @@
+package worker
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gyeh/npi-rates/internal/mrf"
+	"github.com/gyeh/npi-rates/internal/objstore"
+	"github.com/gyeh/npi-rates/internal/progress"
+)
+
+// TestSpeedFloor_AbortsStalledDownload verifies that a body trickling below
+// the floor is aborted with ErrTooSlow instead of running to completion.
+func TestSpeedFloor_AbortsStalledDownload(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "1000000")
+		flusher := w.(http.Flusher)
+		for i := 0; i < 100; i++ {
+			if _, err := w.Write([]byte("x")); err != nil {
+				return
+			}
+			flusher.Flush()
+			select {
+			case <-time.After(20 * time.Millisecond):
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}))
+	defer server.Close()
+
+	SetSpeedFloor(1024*1024, 200*time.Millisecond)
+	defer SetSpeedFloor(0, 0)
+
+	resp, err := openDownload(context.Background(), server.URL)
+	if err != nil {
+		t.Fatalf("openDownload failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	start := time.Now()
+	_, err = io.Copy(io.Discard, resp.Body)
+	if !errors.Is(err, ErrTooSlow) {
+		t.Fatalf("expected ErrTooSlow, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected abort shortly after the window, took %s", elapsed)
+	}
+}
+
+// TestSpeedFloor_FastDownloadUnaffected verifies that a download above the
+// floor completes normally.
+func TestSpeedFloor_FastDownloadUnaffected(t *testing.T) {
+	payload := make([]byte, 256*1024)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(payload)
+	}))
+	defer server.Close()
+
+	SetSpeedFloor(1024, 50*time.Millisecond)
+	defer SetSpeedFloor(0, 0)
+
+	resp, err := openDownload(context.Background(), server.URL)
+	if err != nil {
+		t.Fatalf("openDownload failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	n, err := io.Copy(io.Discard, resp.Body)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n != int64(len(payload)) {
+		t.Errorf("expected %d bytes, got %d", len(payload), n)
+	}
+}
+
+// TestDownloadAndParse_ChunkedMissingTrailer verifies that a chunked response
+// (no Content-Length) cut off inside the gzip trailer is rejected even though
+// the JSON itself parsed completely.
+func TestDownloadAndParse_ChunkedMissingTrailer(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte(buildTestMRF()))
+	gz.Close()
+	truncated := buf.Bytes()[:buf.Len()-4] // drop ISIZE
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(truncated)
+		w.(http.Flusher).Flush() // forces chunked transfer encoding
+	}))
+	defer server.Close()
+
+	tracker := (&progress.NoopManager{}).NewTracker(0, 1, "chunked.json.gz")
+	opts := &mrf.SearchOptions{NPIs: map[int64]struct{}{1316924913: {}}}
+	_, _, err := downloadAndParse(context.Background(), server.URL+"/chunked.json.gz", opts, true,
+		tracker, mrf.StreamCallbacks{}, func([]mrf.RateResult) {}, nil, nil)
+	if err == nil {
+		t.Fatal("expected truncated gzip trailer to fail the download")
+	}
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("expected io.ErrUnexpectedEOF in chain, got %v", err)
+	}
+}
+
+func TestSizeHistory_Estimate(t *testing.T) {
+	h := &sizeHistory{hosts: make(map[string]*hostSizes)}
+	if got := h.estimate("https://cdn.example.com/a.json.gz", 10); got != -1 {
+		t.Errorf("expected -1 without history, got %d", got)
+	}
+
+	h.record("https://cdn.example.com/a.json.gz", 1000)
+	h.record("https://cdn.example.com/b.json.gz?sig=x", 3000)
+
+	if got := h.estimate("https://cdn.example.com/c.json.gz", 100); got != 2000 {
+		t.Errorf("expected host average 2000, got %d", got)
+	}
+	if got := h.estimate("https://cdn.example.com/c.json.gz", 2500); got != 2750 {
+		t.Errorf("expected estimate to stay ahead of download (2750), got %d", got)
+	}
+	if got := h.estimate("https://other.example.com/c.json.gz", 100); got != -1 {
+		t.Errorf("expected -1 for a host without history, got %d", got)
+	}
+}
+
+func TestProbeDecompressedSize(t *testing.T) {
+	payload := bytes.Repeat([]byte(`{"a":1}`), 10000)
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write(payload)
+	gz.Close()
+	gzData := buf.Bytes()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "f.json.gz", time.Time{}, bytes.NewReader(gzData))
+	}))
+	defer server.Close()
+
+	decompressed, compressed, err := ProbeDecompressedSize(context.Background(), server.URL)
+	if err != nil {
+		t.Fatalf("ProbeDecompressedSize failed: %v", err)
+	}
+	if compressed != int64(len(gzData)) {
+		t.Errorf("expected compressed size %d, got %d", len(gzData), compressed)
+	}
+	if decompressed != int64(len(payload)) {
+		t.Errorf("expected decompressed size %d, got %d", len(payload), decompressed)
+	}
+}
+
+// TestRequestHeaders verifies that headers set with SetRequestHeaders are
+// sent with size probes to the hosts they are for, without displacing the
+// probe's own Range header.
+func TestRequestHeaders(t *testing.T) {
+	h, err := ParseRequestHeaders([]string{"Authorization: Bearer a=b", "", " X-Api-Key : k1 ",
+		"LocalHost=X-Cdn-Key: k2", "other.example=X-Other: k3"})
+	if err != nil {
+		t.Fatalf("ParseRequestHeaders failed: %v", err)
+	}
+	for _, bad := range []string{"no colon", "=X-Key: v", "a b=X-Key: v", "host=no colon"} {
+		if _, err := ParseRequestHeaders([]string{bad}); err == nil {
+			t.Errorf("expected an error for %q", bad)
+		}
+	}
+
+	SetRequestHeaders(h)
+	defer SetRequestHeaders(RequestHeaders{})
+	var got http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+	}))
+	defer server.Close()
+	SetSearchedURLs([]string{server.URL + "/f.json.gz"})
+	defer SetSearchedURLs(nil)
+
+	// The searched URL's host gets the plain headers.
+	ProbeDecompressedSize(context.Background(), server.URL+"/f.json.gz")
+	if got.Get("Authorization") != "Bearer a=b" || got.Get("X-Api-Key") != "k1" {
+		t.Errorf("custom headers not sent: %v", got)
+	}
+	if got.Get("X-Cdn-Key") != "" || got.Get("X-Other") != "" {
+		t.Errorf("other hosts' headers sent: %v", got)
+	}
+	if got.Get("Range") != "bytes=-4" {
+		t.Errorf("expected Range bytes=-4, got %q", got.Get("Range"))
+	}
+
+	// The same server under another name, as a mirror would be, gets only
+	// the header given for that name.
+	ProbeDecompressedSize(context.Background(), strings.Replace(server.URL, "127.0.0.1", "localhost", 1)+"/f.json.gz")
+	if got.Get("Authorization") != "" || got.Get("X-Api-Key") != "" || got.Get("X-Cdn-Key") != "k2" {
+		t.Errorf("unexpected headers for another host: %v", got)
+	}
+}
+
+// TestDownloadCache verifies that an unchanged file is read from the cache
+// after a 304, and that a changed one replaces the cached copy.
+func TestDownloadCache(t *testing.T) {
+	etag, body := `"v1"`, "first version"
+	var fullGets int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		fullGets++
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		io.WriteString(w, body)
+	}))
+	defer server.Close()
+
+	if err := SetCacheDir(t.TempDir()); err != nil {
+		t.Fatalf("SetCacheDir failed: %v", err)
+	}
+	defer SetCacheDir("")
+
+	read := func() string {
+		resp, err := openDownload(context.Background(), server.URL+"/f.json.gz")
+		if err != nil {
+			t.Fatalf("openDownload failed: %v", err)
+		}
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("reading body: %v", err)
+		}
+		return string(data)
+	}
+
+	if got := read(); got != "first version" || fullGets != 1 {
+		t.Fatalf("first read: got %q after %d full GETs", got, fullGets)
+	}
+	if got := read(); got != "first version" || fullGets != 1 {
+		t.Errorf("unchanged file: got %q after %d full GETs, want the cached copy", got, fullGets)
+	}
+
+	etag, body = `"v2"`, "second version"
+	if got := read(); got != "second version" || fullGets != 2 {
+		t.Errorf("changed file: got %q after %d full GETs", got, fullGets)
+	}
+	if got := read(); got != "second version" || fullGets != 2 {
+		t.Errorf("changed file, read again: got %q after %d full GETs, want the new cached copy", got, fullGets)
+	}
+
+	// The first version's body went with its entry.
+	entries, err := DownloadCacheEntries(downloadCache.dir)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("DownloadCacheEntries = %+v, %v; want one entry", entries, err)
+	}
+	bodies, _ := filepath.Glob(filepath.Join(downloadCache.dir, "*.body"))
+	if len(bodies) != 1 || bodies[0] != entries[0].Paths[1] {
+		t.Errorf("cached bodies %v, want only %s", bodies, entries[0].Paths[1])
+	}
+}
+
+// TestDownloadCache_IgnoredConditional verifies that a full response with
+// the cached copy's strong ETag is answered from the cache, for servers
+// that ignore If-None-Match.
+func TestDownloadCache_IgnoredConditional(t *testing.T) {
+	etag, body := `"v1"`, "first version"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		io.WriteString(w, body)
+	}))
+	defer server.Close()
+
+	if err := SetCacheDir(t.TempDir()); err != nil {
+		t.Fatalf("SetCacheDir failed: %v", err)
+	}
+	defer SetCacheDir("")
+
+	read := func() (string, string) {
+		resp, err := openDownload(context.Background(), server.URL+"/f.json.gz")
+		if err != nil {
+			t.Fatalf("openDownload failed: %v", err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp.Status, string(data)
+	}
+
+	read()
+	if status, got := read(); status != "200 OK (cached)" || got != "first version" {
+		t.Errorf("same ETag: got %q (%s), want the cached copy", got, status)
+	}
+	etag, body = `W/"v1"`, "other version"
+	if status, got := read(); status == "200 OK (cached)" || got != "other version" {
+		t.Errorf("weak ETag: got %q (%s), want the server's body", got, status)
+	}
+}
+
+func TestResolveISIZE(t *testing.T) {
+	const gib = int64(1) << 30
+	tests := []struct {
+		name       string
+		isize      uint32
+		compressed int64
+		ratio      float64
+		want       int64
+	}{
+		{"no compressed size", 1234, -1, 10, 1234},
+		{"small file", 5000, 500, 10, 5000},
+		// 41 GiB decompressed wraps to 1 GiB; 4 GiB compressed at 10x recovers it.
+		{"wrapped", uint32((41*gib + 5) % (4 * gib)), 4 * gib, 10, 41*gib + 5},
+		{"never below isize", 100, 1, 0.5, 100},
+	}
+	for _, tt := range tests {
+		if got := resolveISIZE(tt.isize, tt.compressed, tt.ratio); got != tt.want {
+			t.Errorf("%s: resolveISIZE = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestDownloadResumable_RangeResume verifies that a download cut off
+// mid-body is resumed with a Range/If-Range request rather than restarted,
+// and that the reassembled file decompresses intact.
+func TestDownloadResumable_RangeResume(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte(buildTestMRF()))
+	gz.Close()
+	gzData := buf.Bytes()
+	half := len(gzData) / 2
+
+	var ranges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		w.Header().Set("ETag", `"v1"`)
+		if len(ranges) == 1 {
+			// Promise the whole body, send half, then drop the connection.
+			w.Header().Set("Content-Length", strconv.Itoa(len(gzData)))
+			w.Write(gzData[:half])
+			w.(http.Flusher).Flush()
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		http.ServeContent(w, r, "f.json.gz", time.Time{}, bytes.NewReader(gzData))
+	}))
+	defer server.Close()
+
+	tmpDir := t.TempDir()
+	part := newPartialDownload(tmpDir, server.URL+"/f.json.gz")
+	res, err := downloadAndDecompressResumable(context.Background(), server.URL+"/f.json.gz", part, tmpDir, true, nil)
+	if err != nil {
+		t.Fatalf("download failed: %v", err)
+	}
+	defer os.Remove(res.FilePath)
+
+	if len(ranges) != 2 || ranges[1] != fmt.Sprintf("bytes=%d-", half) {
+		t.Errorf("expected a resume from byte %d, got requests with Range %q", half, ranges)
+	}
+	if res.CompressedBytes != int64(len(gzData)) {
+		t.Errorf("expected %d compressed bytes, got %d", len(gzData), res.CompressedBytes)
+	}
+	// Both attempts count toward the host's transfer; the resume adds only
+	// the missing half.
+	host := strings.TrimPrefix(server.URL, "http://")
+	if n := TransferredBytes()[host]; n != int64(len(gzData)) {
+		t.Errorf("expected %d bytes transferred from %s, got %d", len(gzData), host, n)
+	}
+	got, _ := os.ReadFile(res.FilePath)
+	if string(got) != buildTestMRF() {
+		t.Error("decompressed file does not match the original")
+	}
+}
+
+// TestDownloadResumable_ChangedFileRestarts verifies that when the file
+// changed since the partial download (If-Range mismatch), the server's full
+// response replaces the stale bytes.
+func TestDownloadResumable_ChangedFileRestarts(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte(buildTestMRF()))
+	gz.Close()
+	gzData := buf.Bytes()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v2"`)
+		http.ServeContent(w, r, "f.json.gz", time.Time{}, bytes.NewReader(gzData))
+	}))
+	defer server.Close()
+
+	tmpDir := t.TempDir()
+	part := newPartialDownload(tmpDir, server.URL)
+	stale := []byte("stale bytes from an older version")
+	part.validator = `"v1"`
+	part.total = int64(len(stale)) * 2
+	part.path = partPath(tmpDir, server.URL, part.validator, part.total)
+	os.WriteFile(part.path, stale, 0o600)
+	stalePath := part.path
+
+	res, err := downloadAndDecompressResumable(context.Background(), server.URL, part, tmpDir, true, nil)
+	if err != nil {
+		t.Fatalf("download failed: %v", err)
+	}
+	defer os.Remove(res.FilePath)
+	if got, _ := os.ReadFile(res.FilePath); string(got) != buildTestMRF() {
+		t.Error("decompressed file does not match the current version")
+	}
+	if part.path == stalePath {
+		t.Error("new version downloaded into the old version's file")
+	}
+	if _, err := os.Stat(stalePath); !os.IsNotExist(err) {
+		t.Errorf("old version's partial download not removed: %v", err)
+	}
+}
+
+// TestDownloadResumable_ResumedSizeMismatch verifies that a resume whose
+// Content-Range reports a different total size than the original response
+// discards the bytes on disk instead of appending to them.
+func TestDownloadResumable_ResumedSizeMismatch(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte(buildTestMRF()))
+	gz.Close()
+	gzData := buf.Bytes()
+	half := len(gzData) / 2
+
+	var ranges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		w.Header().Set("ETag", `"v1"`)
+		switch len(ranges) {
+		case 1:
+			w.Header().Set("Content-Length", strconv.Itoa(len(gzData)))
+			w.Write(gzData[:half])
+			w.(http.Flusher).Flush()
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+		case 2:
+			// Same ETag, but the file grew.
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", half, len(gzData)+9, len(gzData)+10))
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(gzData[half:])
+			w.Write(make([]byte, 10))
+		default:
+			http.ServeContent(w, r, "f.json.gz", time.Time{}, bytes.NewReader(gzData))
+		}
+	}))
+	defer server.Close()
+
+	tmpDir := t.TempDir()
+	part := newPartialDownload(tmpDir, server.URL)
+	_, err := downloadAndDecompressResumable(context.Background(), server.URL, part, tmpDir, true, nil)
+	if err == nil || !strings.Contains(err.Error(), "starting over") {
+		t.Fatalf("expected a size mismatch error, got %v", err)
+	}
+	if part.size() != 0 {
+		t.Errorf("expected the partial download discarded, %d bytes left", part.size())
+	}
+
+	// The next attempt starts from scratch.
+	res, err := downloadAndDecompressResumable(context.Background(), server.URL, part, tmpDir, true, nil)
+	if err != nil {
+		t.Fatalf("retry failed: %v", err)
+	}
+	defer os.Remove(res.FilePath)
+	if ranges[2] != "" {
+		t.Errorf("expected the retry to fetch the whole file, got Range %q", ranges[2])
+	}
+}
+
+// fakeClock records requested sleeps instead of waiting.
+type fakeClock struct {
+	now    time.Time
+	sleeps []time.Duration
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Sleep(ctx context.Context, d time.Duration) error {
+	c.sleeps = append(c.sleeps, d)
+	c.now = c.now.Add(d)
+	return ctx.Err()
+}
+
+// TestDownloadHTTP_RetryBackoff verifies the retry delays on server errors
+// using a fake clock, so the test doesn't wait them out.
+func TestDownloadHTTP_RetryBackoff(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	fc := &fakeClock{now: time.Unix(0, 0)}
+	SetClock(fc)
+	defer SetClock(nil)
+	SetRandSource(func() float64 { return 0.5 }) // no jitter
+	defer SetRandSource(nil)
+
+	resp, err := openDownload(context.Background(), server.URL)
+	if err != nil {
+		t.Fatalf("openDownload failed: %v", err)
+	}
+	resp.Body.Close()
+
+	want := []time.Duration{2 * time.Second, 4 * time.Second}
+	if fmt.Sprint(fc.sleeps) != fmt.Sprint(want) {
+		t.Errorf("expected backoff %v, got %v", want, fc.sleeps)
+	}
+
+	// The jitter spreads delays by ±20%.
+	SetRandSource(func() float64 { return 0 })
+	if d := jittered(10 * time.Second); d != 8*time.Second {
+		t.Errorf("expected minimum jittered delay 8s, got %s", d)
+	}
+}
+
+// TestTransferredBytes_ErrorsAndReset verifies that error responses count
+// toward a host's transfer, and that ResetTransferred starts a new count.
+func TestTransferredBytes_ErrorsAndReset(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			w.Write([]byte("upstream unavailable"))
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	SetClock(&fakeClock{now: time.Unix(0, 0)})
+	defer SetClock(nil)
+	ResetTransferred()
+	defer ResetTransferred()
+
+	resp, err := openDownload(context.Background(), server.URL)
+	if err != nil {
+		t.Fatalf("openDownload failed: %v", err)
+	}
+	io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	if n, want := TransferredBytes()[host], int64(len("upstream unavailable")+len("ok")); n != want {
+		t.Errorf("expected %d bytes transferred, got %d", want, n)
+	}
+	ResetTransferred()
+	if n := TransferredBytes()[host]; n != 0 {
+		t.Errorf("expected 0 bytes after reset, got %d", n)
+	}
+}
+
+// TestDownloadHTTP_AuthFailure verifies that a 403 fails at once, without
+// retries, and tells an expired signed URL from other refusals.
+func TestDownloadHTTP_AuthFailure(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusForbidden)
+		if r.URL.Query().Has("X-Amz-Signature") {
+			io.WriteString(w, "<Error><Code>AccessDenied</Code><Message>Request has expired</Message></Error>")
+		}
+	}))
+	defer server.Close()
+
+	tracker := (&progress.NoopManager{}).NewTracker(0, 1, "in-network.json.gz")
+	err := RunPipeline(context.Background(), server.URL+"/in-network.json.gz?X-Amz-Signature=abc", nil, t.TempDir(), true, true, tracker).Err
+	var ae *AuthError
+	if !errors.As(err, &ae) || !ae.Expired {
+		t.Fatalf("expected expired AuthError, got %v", err)
+	}
+	if requests != 1 {
+		t.Errorf("expected 1 request, got %d", requests)
+	}
+	if cat := errorCategory(err); cat != "expired URL" {
+		t.Errorf("expected category %q, got %q", "expired URL", cat)
+	}
+
+	_, err = DownloadHTTP(context.Background(), server.URL)
+	if !errors.As(err, &ae) || ae.Expired || ae.Signed {
+		t.Fatalf("expected unsigned AuthError, got %v", err)
+	}
+	if cat := errorCategory(err); cat != "HTTP 403" {
+		t.Errorf("expected category %q, got %q", "HTTP 403", cat)
+	}
+	if ErrorHint(err) == "" {
+		t.Error("expected a hint")
+	}
+}
+
+func TestObjectURL_S3(t *testing.T) {
+	body := "0123456789 object body"
+	modified := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+	var payer string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payer = r.Header.Get("x-amz-request-payer")
+		switch r.URL.Path {
+		case "/mrfs/2026-02/in-network.json":
+			w.Header().Set("ETag", `"abc"`)
+			http.ServeContent(w, r, "", modified, strings.NewReader(body))
+		case "/private/f.json":
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	t.Setenv("AWS_ENDPOINT_URL", server.URL)
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	objstore.ResetClients()
+	defer objstore.ResetClients()
+	objstore.SetRequesterPays(true, "")
+	defer objstore.SetRequesterPays(false, "")
+
+	url := "s3://mrfs/2026-02/in-network.json"
+	resp, err := DownloadHTTP(context.Background(), url)
+	if err != nil {
+		t.Fatalf("DownloadHTTP failed: %v", err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(data) != body || resp.ContentLength != int64(len(body)) || resp.Header.Get("ETag") != `"abc"` {
+		t.Errorf("got %q (length %d, ETag %s)", data, resp.ContentLength, resp.Header.Get("ETag"))
+	}
+	if payer != "requester" {
+		t.Errorf("expected x-amz-request-payer: requester, got %q", payer)
+	}
+
+	resp, err = downloadHTTP(context.Background(), url, 10, `"abc"`, nil)
+	if err != nil {
+		t.Fatalf("resume failed: %v", err)
+	}
+	data, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent || string(data) != body[10:] {
+		t.Errorf("resume: got HTTP %d, %q", resp.StatusCode, data)
+	}
+
+	// An unchanged cached copy is a 304, without reading the object.
+	resp, err = downloadHTTP(context.Background(), url, 0, "", &cacheEntry{ETag: `"abc"`, Size: int64(len(body))})
+	if err != nil || resp.StatusCode != http.StatusNotModified {
+		t.Errorf("expected 304 for a cached copy, got %v, %v", resp, err)
+	}
+
+	_, err = DownloadHTTP(context.Background(), "s3://private/f.json")
+	var ae *AuthError
+	if !errors.As(err, &ae) || ae.StatusCode != http.StatusForbidden {
+		t.Errorf("expected a 403 AuthError, got %v", err)
+	}
+}
+
+func TestMaxBandwidth(t *testing.T) {
+	body := bytes.Repeat([]byte("x"), 96<<10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	// The first bucket (32 KB) is free; the other 64 KB take half a second.
+	SetMaxBandwidth(0, 128<<10)
+	defer SetMaxBandwidth(0, 0)
+
+	start := time.Now()
+	resp, err := DownloadHTTP(context.Background(), server.URL)
+	if err != nil {
+		t.Fatalf("DownloadHTTP failed: %v", err)
+	}
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || len(data) != len(body) {
+		t.Fatalf("read %d bytes, err %v", len(data), err)
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("96 KB at 128 KB/s took %v, want about 500ms", elapsed)
+	}
+}
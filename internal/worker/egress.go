@@ -0,0 +1,70 @@
+package worker
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// transferred counts response body bytes received per host since the last
+// ResetTransferred, including attempts that later failed and error
+// responses, so it reflects what was actually pulled over the network
+// rather than the size of the files.
+var transferred struct {
+	mu    sync.Mutex
+	hosts map[string]*atomic.Int64
+}
+
+// hostCounter returns the byte counter for host, creating it if needed.
+func hostCounter(host string) *atomic.Int64 {
+	transferred.mu.Lock()
+	defer transferred.mu.Unlock()
+	if transferred.hosts == nil {
+		transferred.hosts = make(map[string]*atomic.Int64)
+	}
+	c, ok := transferred.hosts[host]
+	if !ok {
+		c = new(atomic.Int64)
+		transferred.hosts[host] = c
+	}
+	return c
+}
+
+// countTransfer makes resp's body count the bytes read from it against its
+// host.
+func countTransfer(resp *http.Response) {
+	resp.Body = &countingBody{ReadCloser: resp.Body, n: hostCounter(resp.Request.URL.Host)}
+}
+
+type countingBody struct {
+	io.ReadCloser
+	n *atomic.Int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n.Add(int64(n))
+	return n, err
+}
+
+// ResetTransferred clears the byte counts, for a new search in the same
+// process.
+func ResetTransferred() {
+	transferred.mu.Lock()
+	defer transferred.mu.Unlock()
+	for _, c := range transferred.hosts {
+		c.Store(0)
+	}
+}
+
+// TransferredBytes returns the bytes downloaded so far from each host.
+func TransferredBytes() map[string]int64 {
+	transferred.mu.Lock()
+	defer transferred.mu.Unlock()
+	out := make(map[string]int64, len(transferred.hosts))
+	for host, c := range transferred.hosts {
+		out[host] = c.Load()
+	}
+	return out
+}
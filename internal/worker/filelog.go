@@ -0,0 +1,86 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/gyeh/npi-rates/internal/humanize"
+	"github.com/gyeh/npi-rates/internal/logx"
+	"github.com/gyeh/npi-rates/internal/mrf"
+	"github.com/gyeh/npi-rates/internal/progress"
+)
+
+var unsafeLogChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// openFileLog creates the debug log for the idx-th URL in dir. The index
+// prefix keeps files with the same name from different URLs apart.
+func openFileLog(dir string, idx int, url string) (*logx.FileLog, error) {
+	name := unsafeLogChars.ReplaceAllString(FileNameFromURL(url), "_")
+	l, err := logx.OpenFileLog(filepath.Join(dir, fmt.Sprintf("%03d_%s.log", idx+1, name)))
+	if err != nil {
+		return nil, err
+	}
+	l.Printf("URL: %s", url)
+	return l, nil
+}
+
+// logTracker copies a tracker's stage changes and warnings into a file log.
+// Progress and counters are left out; they change too often to be useful
+// there.
+type logTracker struct {
+	progress.Tracker
+	log   *logx.FileLog
+	stage string
+}
+
+func (t *logTracker) SetStage(stage string) {
+	if stage != t.stage {
+		t.stage = stage
+		t.log.Printf("stage: %s", stage)
+	}
+	t.Tracker.SetStage(stage)
+}
+
+func (t *logTracker) LogWarning(msg string) {
+	t.log.Printf("warning: %s", msg)
+	t.Tracker.LogWarning(msg)
+}
+
+// withFileLog opens the idx-th URL's log under p.LogDir and wraps ctx and
+// tracker so that everything reported for the file is also written there.
+// The returned finish func records the outcome and closes the log. Without
+// a LogDir, or if the log can't be created, ctx and tracker are returned as is.
+func (p *Pool) withFileLog(ctx context.Context, idx int, url string, tracker progress.Tracker) (context.Context, progress.Tracker, func(*PipelineResult)) {
+	noop := func(*PipelineResult) {}
+	if p.LogDir == "" {
+		return ctx, tracker, noop
+	}
+	fl, err := openFileLog(p.LogDir, idx, url)
+	if err != nil {
+		tracker.LogWarning(fmt.Sprintf("Per-file log unavailable: %v", err))
+		return ctx, tracker, noop
+	}
+	start := clock.Now()
+	finish := func(r *PipelineResult) {
+		elapsed := clock.Now().Sub(start).Truncate(time.Millisecond)
+		if r.Err != nil {
+			fl.Printf("failed after %s: %v", elapsed, r.Err)
+			var pe *mrf.PanicError
+			if errors.As(r.Err, &pe) {
+				fl.Printf("stack:\n%s", pe.Stack)
+			}
+		} else {
+			fl.Printf("done in %s: %d rates, %s downloaded, %s decompressed", elapsed, r.Count,
+				humanize.SignedBytes(r.CompressedBytes), humanize.SignedBytes(r.DecompressedBytes))
+		}
+		if err := fl.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: closing %s log: %v\n", FileNameFromURL(url), err)
+		}
+	}
+	return logx.WithFileLog(ctx, fl), &logTracker{Tracker: tracker, log: fl}, finish
+}
@@ -0,0 +1,130 @@
+package worker
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// HeadersEnv names the environment variable holding extra request headers,
+// one "Name: value" per line. It is how headers reach cloud shards (through
+// a Modal secret) without appearing on a command line.
+const HeadersEnv = "NPI_RATES_HEADERS"
+
+// RequestHeaders are extra headers for MRF requests, such as an
+// Authorization token for a payer's authenticated endpoint. Each is sent
+// to one host only, so that a payer's credentials never reach a mirror, a
+// --rewrite target or another payer's CDN.
+type RequestHeaders struct {
+	// Hosts holds the headers given as "host=Name: value", by lowercased
+	// host name.
+	Hosts map[string]http.Header
+
+	// Searched holds the headers given as plain "Name: value", which go to
+	// the hosts of the searched URLs (see SetSearchedURLs).
+	Searched http.Header
+}
+
+var (
+	// requestHeaders are added to MRF requests; see SetRequestHeaders.
+	requestHeaders RequestHeaders
+
+	// searchedHosts are the host names of the searched URLs.
+	searchedHosts map[string]bool
+)
+
+// SetRequestHeaders adds h to the MRF downloads and size probes of the
+// hosts each header is for. Headers the downloader sets itself, such as
+// Range, take precedence. The HTTP clients drop them when a request is
+// redirected to another host (see httpx).
+func SetRequestHeaders(h RequestHeaders) {
+	requestHeaders = h
+}
+
+// SetSearchedURLs records the URLs being searched, as listed, whose hosts
+// receive the RequestHeaders.Searched headers.
+func SetSearchedURLs(urls []string) {
+	searchedHosts = make(map[string]bool)
+	for _, u := range urls {
+		if parsed, err := url.Parse(u); err == nil && parsed.Hostname() != "" {
+			searchedHosts[strings.ToLower(parsed.Hostname())] = true
+		}
+	}
+}
+
+// AddRequestHeaders sets the headers from SetRequestHeaders meant for req's
+// host on req.
+func AddRequestHeaders(req *http.Request) {
+	host := strings.ToLower(req.URL.Hostname())
+	if searchedHosts[host] {
+		for name, vals := range requestHeaders.Searched {
+			req.Header[name] = vals
+		}
+	}
+	for name, vals := range requestHeaders.Hosts[host] {
+		req.Header[name] = vals
+	}
+}
+
+// ParseRequestHeaders parses --header lines: "Name: value" for the hosts of
+// the searched URLs, or "host=Name: value" for one host. Blank lines are
+// ignored.
+func ParseRequestHeaders(lines []string) (RequestHeaders, error) {
+	h := RequestHeaders{Hosts: map[string]http.Header{}, Searched: http.Header{}}
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		// '=' can't occur in a header name, so it only ever ends a host.
+		dst := h.Searched
+		if host, rest, ok := strings.Cut(line, "="); ok && !strings.Contains(host, ":") {
+			host = strings.ToLower(strings.TrimSpace(host))
+			if host == "" || strings.ContainsAny(host, " \t/") {
+				return RequestHeaders{}, fmt.Errorf("header %q is not \"host=Name: value\"", line)
+			}
+			if h.Hosts[host] == nil {
+				h.Hosts[host] = http.Header{}
+			}
+			dst, line = h.Hosts[host], rest
+		}
+		parsed, err := ParseHeaders([]string{line})
+		if err != nil {
+			return RequestHeaders{}, err
+		}
+		for name, vals := range parsed {
+			dst[name] = append(dst[name], vals...)
+		}
+	}
+	return h, nil
+}
+
+// ParseHeaders parses "Name: value" lines, as given to --header or in
+// HeadersEnv. Blank lines are ignored.
+func ParseHeaders(lines []string) (http.Header, error) {
+	h := http.Header{}
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("header %q is not \"Name: value\"", line)
+		}
+		h.Add(name, strings.TrimSpace(value))
+	}
+	return h, nil
+}
+
+// EnvHeaderLines returns the lines of HeadersEnv, or nil if unset.
+func EnvHeaderLines() []string {
+	v := os.Getenv(HeadersEnv)
+	if v == "" {
+		return nil
+	}
+	return strings.Split(v, "\n")
+}
@@ -0,0 +1,84 @@
+package worker
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gyeh/npi-rates/internal/httpx"
+)
+
+// ProbeDecompressedSize predicts the decompressed size of a gzipped URL
+// without downloading it, by reading the gzip ISIZE footer (the last 4 bytes,
+// little-endian, uncompressed length mod 2^32) with a suffix Range request.
+// It also returns the compressed size from the Content-Range header, or -1.
+//
+// ISIZE wraps for files over 4 GiB, which most in-network files are, so the
+// result is the candidate closest to the compressed size times the typical
+// compression ratio seen so far (see resolveISIZE). Multi-member gzip files
+// only report the last member. Treat the result as an estimate.
+func ProbeDecompressedSize(ctx context.Context, url string) (decompressed, compressed int64, err error) {
+	if f := formatFromURL(url); f != "" && f != FormatGzip {
+		return -1, -1, fmt.Errorf("no size footer in %s files", f)
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", RewriteURL(url), nil)
+	if err != nil {
+		return -1, -1, fmt.Errorf("creating request: %w", err)
+	}
+	AddRequestHeaders(req)
+	req.Header.Set("Range", "bytes=-4")
+	// Ask for the raw bytes; a transparently decoded body would hide the footer.
+	req.Header.Set("Accept-Encoding", "identity")
+
+	resp, err := httpx.Short.Do(req)
+	if err != nil {
+		return -1, -1, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent {
+		return -1, -1, fmt.Errorf("range request not supported (HTTP %d)", resp.StatusCode)
+	}
+	var footer [4]byte
+	if _, err := io.ReadFull(resp.Body, footer[:]); err != nil {
+		return -1, -1, fmt.Errorf("reading gzip footer: %w", err)
+	}
+
+	compressed = contentRangeTotal(resp.Header.Get("Content-Range"))
+	isize := binary.LittleEndian.Uint32(footer[:])
+	return resolveISIZE(isize, compressed, downloadSizes.ratio()), compressed, nil
+}
+
+// resolveISIZE picks the uncompressed size isize + k·2^32 closest to
+// compressed·ratio. Without a compressed size, isize is returned as is.
+func resolveISIZE(isize uint32, compressed int64, ratio float64) int64 {
+	if compressed <= 0 {
+		return int64(isize)
+	}
+	const wrap = 1 << 32
+	expected := float64(compressed) * ratio
+	k := math.Round((expected - float64(isize)) / wrap)
+	if k < 0 {
+		k = 0
+	}
+	return int64(isize) + int64(k)*wrap
+}
+
+// contentRangeTotal parses the complete length from a "bytes a-b/total"
+// Content-Range header, returning -1 if absent or unknown ("*").
+func contentRangeTotal(h string) int64 {
+	i := strings.LastIndexByte(h, '/')
+	if i < 0 {
+		return -1
+	}
+	n, err := strconv.ParseInt(h[i+1:], 10, 64)
+	if err != nil {
+		return -1
+	}
+	return n
+}
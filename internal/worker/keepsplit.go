@@ -0,0 +1,218 @@
+package worker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/gyeh/npi-rates/internal/cache"
+	"github.com/gyeh/npi-rates/internal/httpx"
+	"github.com/gyeh/npi-rates/internal/logx"
+	"github.com/gyeh/npi-rates/internal/mrf"
+	"github.com/gyeh/npi-rates/internal/objstore"
+	"github.com/gyeh/npi-rates/internal/progress"
+)
+
+// keptSplits keeps split output between runs; see SetKeepSplitDir.
+var keptSplits *splitStore
+
+// SetKeepSplitDir keeps the split output of every file that goes through the
+// split pipeline under dir, one subdirectory per file version, and reuses it
+// when the same version is searched again: the file is then neither
+// downloaded nor split, only parsed. A version is the URL with the ETag,
+// Last-Modified and size the server reports for it; files served without an
+// ETag or Last-Modified are not kept, since there would be no way to tell
+// when they change. Kept output is only removed by the cache package's gc
+// (see KeptSplitEntries). "" disables keeping.
+func SetKeepSplitDir(dir string) error {
+	if dir == "" {
+		keptSplits = nil
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("creating keep-split dir: %w", err)
+	}
+	keptSplits = &splitStore{dir: dir}
+	return nil
+}
+
+// splitStore holds each version's split output in <dir>/<key>/, with the
+// version in source.json, written last. Output being split lives in a
+// "work-*" dir next to it until it is complete, so CleanTmpDir on dir
+// removes what a crashed run left.
+type splitStore struct {
+	dir string
+}
+
+// splitSourceFile names a kept split's version file.
+const splitSourceFile = "source.json"
+
+// splitVersion identifies a version of a file.
+type splitVersion struct {
+	URL          string    `json:"url"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	Size         int64     `json:"size"`
+	Split        time.Time `json:"split"`
+}
+
+func (v *splitVersion) same(o *splitVersion) bool {
+	return v.URL == o.URL && v.ETag == o.ETag && v.LastModified == o.LastModified && v.Size == o.Size
+}
+
+func (s *splitStore) path(v *splitVersion) string {
+	sum := sha256.Sum256([]byte(v.URL + "\n" + v.ETag + "\n" + v.LastModified + "\n" + strconv.FormatInt(v.Size, 10)))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:16]))
+}
+
+// probeVersion asks the server for url's version with a one-byte range
+// request (HEAD is not allowed by every signed URL). It returns nil if the
+// server gives neither an ETag nor a Last-Modified.
+func probeVersion(ctx context.Context, url string) (*splitVersion, error) {
+	fetch := RewriteURL(url)
+	if objstore.IsObjectURL(fetch) {
+		info, err := statObject(ctx, fetch)
+		if err != nil {
+			return nil, err
+		}
+		return &splitVersion{URL: url, ETag: info.ETag, LastModified: info.HTTPLastModified(), Size: info.Size}, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", fetch, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	AddRequestHeaders(req)
+	req.Header.Set("Range", "bytes=0-0")
+	req.Header.Set("Accept-Encoding", "identity")
+
+	resp, err := httpx.Short.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	v := &splitVersion{
+		URL:          url,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		v.Size = contentRangeTotal(resp.Header.Get("Content-Range"))
+	case http.StatusOK:
+		// Range ignored; the body is dropped unread.
+		v.Size = resp.ContentLength
+	default:
+		return nil, &StatusError{StatusCode: resp.StatusCode}
+	}
+	if v.ETag == "" && v.LastModified == "" {
+		return nil, nil
+	}
+	return v, nil
+}
+
+// lookup returns the kept split output for v, or nil if there is none.
+func (s *splitStore) lookup(v *splitVersion) *mrf.SplitResult {
+	dir := s.path(v)
+	data, err := os.ReadFile(filepath.Join(dir, splitSourceFile))
+	if err != nil {
+		return nil
+	}
+	var kept splitVersion
+	if json.Unmarshal(data, &kept) != nil || !kept.same(v) {
+		return nil
+	}
+	split, err := mrf.OpenSplitDir(dir)
+	if err != nil {
+		return nil
+	}
+	return split
+}
+
+// newDir returns a dir for an attempt to split into.
+func (s *splitStore) newDir() (string, error) {
+	return os.MkdirTemp(s.dir, "work-*")
+}
+
+// commit moves a successful attempt's split output into place as v's. If
+// another run kept v meanwhile, its copy stays and this one is removed.
+func (s *splitStore) commit(ctx context.Context, splitDir string, v *splitVersion) {
+	kept := *v
+	kept.Split = time.Now()
+	data, err := json.Marshal(&kept)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(splitDir, splitSourceFile), data, 0o644)
+	}
+	if err == nil {
+		err = os.Chmod(splitDir, 0o755)
+	}
+	if err == nil {
+		target := s.path(v)
+		if s.lookup(v) == nil {
+			os.RemoveAll(target) // an unreadable copy
+		}
+		err = os.Rename(splitDir, target)
+	}
+	if err != nil {
+		logx.Debugf(ctx, "%s: not keeping split output: %v", FileNameFromURL(v.URL), err)
+		os.RemoveAll(splitDir)
+		return
+	}
+	logx.Debugf(ctx, "%s: kept split output in %s", FileNameFromURL(v.URL), s.path(v))
+}
+
+// runKeptSplit parses split output kept by an earlier run.
+func runKeptSplit(ctx context.Context, url string, split *mrf.SplitResult, opts *mrf.SearchOptions, spoolDir string, tracker progress.Tracker) *PipelineResult {
+	cache.Touch(filepath.Join(split.Dir, splitSourceFile))
+	logx.Debugf(ctx, "%s: reusing split output in %s", FileNameFromURL(url), split.Dir)
+	tracker.SetStage("Reusing split output")
+	result := newPipelineResult(url, spoolDir)
+	if result.Err == nil {
+		result = runParsePhases(ctx, result, split, opts, url, tracker)
+	}
+	result.finishSpool()
+	return result
+}
+
+// KeptSplitEntries lists the split output kept in dir (see SetKeepSplitDir)
+// for the cache package. An entry was last used when it was kept or last
+// searched.
+func KeptSplitEntries(dir string) ([]cache.Entry, error) {
+	dirs, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []cache.Entry
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+		path := filepath.Join(dir, d.Name())
+		source := filepath.Join(path, splitSourceFile)
+		info, err := os.Stat(source)
+		if err != nil {
+			continue // being split, or not ours
+		}
+		var v splitVersion
+		if data, err := os.ReadFile(source); err != nil || json.Unmarshal(data, &v) != nil {
+			continue
+		}
+		entries = append(entries, cache.Entry{
+			Name:     v.URL,
+			Paths:    []string{path},
+			Size:     cache.TreeSize(path),
+			LastUsed: info.ModTime(),
+		})
+	}
+	return entries, nil
+}
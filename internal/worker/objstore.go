@@ -0,0 +1,96 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gyeh/npi-rates/internal/logx"
+	"github.com/gyeh/npi-rates/internal/objstore"
+)
+
+// Object URLs (see objstore) go through downloadHTTP like any other URL, as
+// responses made up from the object's metadata and body, so resuming, the
+// download cache and everything after the download work unchanged.
+
+// IsRemote reports whether src names something to download (an HTTP(S) or
+// object URL) rather than a local path.
+func IsRemote(src string) bool {
+	return strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") || objstore.IsObjectURL(src)
+}
+
+// statObject returns the metadata of the object at rawURL.
+func statObject(ctx context.Context, rawURL string) (*objstore.Info, error) {
+	info, err := objstore.Stat(ctx, rawURL)
+	return info, objectError(err)
+}
+
+// readObject opens length bytes (< 0: the rest) of the object described
+// by info from offset, failing if the object has changed since info.
+func readObject(ctx context.Context, rawURL string, info *objstore.Info, offset, length int64) (io.ReadCloser, error) {
+	r, err := objstore.Open(ctx, rawURL, info, offset, length)
+	return r, objectError(err)
+}
+
+// downloadObject is downloadHTTP for object URLs: it answers as an HTTP
+// server would, with 200, 206 (the object is unchanged since validator) or
+// 304 (it matches cached).
+func downloadObject(ctx context.Context, rawURL string, offset int64, validator string, cached *cacheEntry) (*http.Response, error) {
+	info, err := statObject(ctx, rawURL)
+	if err != nil {
+		return nil, err
+	}
+	h := http.Header{}
+	h.Set("ETag", info.ETag)
+	if lm := info.HTTPLastModified(); lm != "" {
+		h.Set("Last-Modified", lm)
+	}
+	u, _ := url.Parse(rawURL)
+	resp := &http.Response{Header: h, Request: &http.Request{Method: "GET", URL: u, Header: http.Header{}}}
+
+	if cached != nil && cached.ETag == info.ETag && cached.Size == info.Size {
+		logx.Debugf(ctx, "GET %s: unchanged (ETag %s)", FileNameFromURL(rawURL), info.ETag)
+		resp.Status, resp.StatusCode = "304 Not Modified", http.StatusNotModified
+		resp.Body = http.NoBody
+		return resp, nil
+	}
+
+	if offset > 0 && (validator == info.ETag || validator == info.HTTPLastModified()) && offset < info.Size {
+		body, err := readObject(ctx, rawURL, info, offset, -1)
+		if err != nil {
+			return nil, err
+		}
+		logx.Debugf(ctx, "GET %s: resuming at byte %d", FileNameFromURL(rawURL), offset)
+		resp.Status, resp.StatusCode = "206 Partial Content", http.StatusPartialContent
+		resp.ContentLength = info.Size - offset
+		h.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, info.Size-1, info.Size))
+		resp.Body = body
+		countTransfer(resp)
+		limitBody(ctx, resp)
+		return resp, nil
+	}
+
+	body, err := readObject(ctx, rawURL, info, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	logx.Debugf(ctx, "GET %s: %d bytes", FileNameFromURL(rawURL), info.Size)
+	resp.Status, resp.StatusCode = "200 OK", http.StatusOK
+	resp.ContentLength = info.Size
+	resp.Body = body
+	countTransfer(resp)
+	limitBody(ctx, resp)
+	return resp, nil
+}
+
+// objectError turns the SDKs' access-denied errors into AuthErrors, so the
+// file fails without retries.
+func objectError(err error) error {
+	if code := objstore.StatusCode(err); code == http.StatusUnauthorized || code == http.StatusForbidden {
+		return fmt.Errorf("%w (%v)", &AuthError{StatusCode: code}, err)
+	}
+	return err
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -12,6 +13,8 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/gyeh/npi-rates/internal/humanize"
+	"github.com/gyeh/npi-rates/internal/logx"
 	"github.com/gyeh/npi-rates/internal/mrf"
 	"github.com/gyeh/npi-rates/internal/progress"
 )
@@ -26,10 +29,83 @@ type PipelineResult struct {
 	URL     string
 	Results []mrf.RateResult
 	Err     error
+
+	// SourceURL is the URL the file was read from, which its results name
+	// as their SourceFile: URL itself, or the mirror or refreshed URL
+	// searched in its place. Empty for files never searched.
+	SourceURL string
+
+	// Count is the number of results found. It equals len(Results) unless
+	// the results were spooled to disk (see Pool.OnResults).
+	Count int
+
+	// Transfer sizes of the successful attempt (first pass only in streaming
+	// mode). Zero if unknown.
+	CompressedBytes   int64
+	DecompressedBytes int64
+
+	// Duration is how long the file took, including retries and mirrors.
+	// Zero for files resumed from a checkpoint or never started.
+	Duration time.Duration
+
+	spool *resultSpool
+}
+
+// newPipelineResult returns an empty result for url. With a non-empty
+// spoolDir, results are spooled to a temp file there instead of Results.
+func newPipelineResult(url, spoolDir string) *PipelineResult {
+	result := &PipelineResult{URL: url}
+	if spoolDir != "" {
+		result.spool, result.Err = newResultSpool(spoolDir)
+	}
+	return result
+}
+
+// add records a batch of results and returns the running count. Callers
+// serialize calls.
+func (r *PipelineResult) add(batch []mrf.RateResult) int {
+	r.Count += len(batch)
+	if r.spool != nil {
+		r.spool.write(batch)
+	} else {
+		r.Results = append(r.Results, batch...)
+	}
+	return r.Count
+}
+
+// finishSpool flushes the spool of a successful attempt, turning a write
+// error into the result's error, and discards the spool of a failed one.
+func (r *PipelineResult) finishSpool() {
+	if r.spool == nil {
+		return
+	}
+	if r.Err == nil {
+		r.Err = r.spool.finish()
+	}
+	if r.Err != nil {
+		r.spool.discard()
+		r.spool = nil
+	}
 }
 
 const maxPipelineRetries = 3
 
+// errFIFOStalled is the error of a FIFO attempt aborted by its watchdog.
+var errFIFOStalled = errors.New("FIFO pipeline stalled")
+
+// fifoStallTimeout is how long a FIFO attempt may go without the download
+// or the split advancing before it is aborted (see SetFIFOStallTimeout).
+var fifoStallTimeout = 10 * time.Minute
+
+// SetFIFOStallTimeout sets how long a FIFO attempt may go with neither
+// downloaded bytes nor split output growing. A stalled attempt is aborted
+// and the file retried with the file-based pipeline, so a reader and writer
+// that deadlocked on the FIFO can't hold the file (and the split slot)
+// forever. d <= 0 disables the watchdog.
+func SetFIFOStallTimeout(d time.Duration) {
+	fifoStallTimeout = d
+}
+
 // RunPipeline processes a single MRF URL: download → split → parse → cleanup.
 //
 // Decompression streams directly into jsplit via a FIFO (named pipe), so the full
@@ -43,10 +119,25 @@ const maxPipelineRetries = 3
 func RunPipeline(
 	ctx context.Context,
 	url string,
-	targetNPIs map[int64]struct{},
+	opts *mrf.SearchOptions,
+	tmpDir string,
+	noFIFO bool,
+	stream bool,
+	tracker progress.Tracker,
+) *PipelineResult {
+	return runPipeline(ctx, url, opts, tmpDir, noFIFO, stream, "", tracker)
+}
+
+// runPipeline is RunPipeline with results spooled to spoolDir when non-empty.
+// A successful result then carries the spool for the caller to replay.
+func runPipeline(
+	ctx context.Context,
+	url string,
+	opts *mrf.SearchOptions,
 	tmpDir string,
 	noFIFO bool,
 	stream bool,
+	spoolDir string,
 	tracker progress.Tracker,
 ) *PipelineResult {
 	// Streaming mode: skip all disk operations, pipe HTTP → gzip → parser directly.
@@ -57,28 +148,46 @@ func RunPipeline(
 				return &PipelineResult{URL: url, Err: ctx.Err()}
 			}
 			useStdGzip := attempt > 1
-			result := runPipelineStreaming(ctx, url, targetNPIs, useStdGzip, tracker)
+			logx.Debugf(ctx, "%s: attempt %d/%d (stream, std-gzip=%v)", FileNameFromURL(url), attempt, maxPipelineRetries, useStdGzip)
+			result := runPipelineStreaming(ctx, url, opts, useStdGzip, tmpDir, spoolDir, tracker)
+			result.finishSpool()
 			if result.Err == nil {
 				return result
 			}
 			lastErr = result.Err
-			if ctx.Err() != nil {
+			if ctx.Err() != nil || isPanic(lastErr) || isAuthError(lastErr) {
 				return result
 			}
 			if attempt < maxPipelineRetries {
 				tracker.LogWarning(fmt.Sprintf("Attempt %d/%d failed: %v", attempt, maxPipelineRetries, lastErr))
-				delay := time.Duration(attempt) * 2 * time.Second
-				tracker.SetStage(fmt.Sprintf("Retry %d/%d (waiting %s)", attempt+1, maxPipelineRetries, delay))
-				select {
-				case <-time.After(delay):
-				case <-ctx.Done():
-					return &PipelineResult{URL: url, Err: ctx.Err()}
+				delay := jittered(time.Duration(attempt) * 2 * time.Second)
+				tracker.SetStage(fmt.Sprintf("Retry %d/%d (waiting %s)", attempt+1, maxPipelineRetries, delay.Round(100*time.Millisecond)))
+				if err := clock.Sleep(ctx, delay); err != nil {
+					return &PipelineResult{URL: url, Err: err}
 				}
 			}
 		}
 		return &PipelineResult{URL: url, Err: lastErr}
 	}
 
+	// With --keep-split, reuse this version's split output if an earlier
+	// run kept it, or else keep the output of the attempt that succeeds.
+	var keep *splitVersion
+	if keptSplits != nil {
+		v, err := probeVersion(ctx, url)
+		switch {
+		case err != nil:
+			logx.Debugf(ctx, "%s: not keeping split output: probe: %v", FileNameFromURL(url), err)
+		case v == nil:
+			logx.Debugf(ctx, "%s: not keeping split output: no ETag or Last-Modified", FileNameFromURL(url))
+		default:
+			if split := keptSplits.lookup(v); split != nil {
+				return runKeptSplit(ctx, url, split, opts, spoolDir, tracker)
+			}
+			keep = v
+		}
+	}
+
 	// Check if FIFOs are supported (they aren't on all platforms)
 	fifoSupported := false
 	if !noFIFO {
@@ -87,6 +196,13 @@ func RunPipeline(
 		os.Remove(testFifo)
 	}
 
+	warnIfTooLarge(ctx, url, tmpDir, tracker)
+
+	// File-based attempts keep the compressed download here so a later
+	// attempt can resume it.
+	part := newPartialDownload(tmpDir, url)
+	defer part.discard()
+
 	var lastErr error
 	for attempt := 1; attempt <= maxPipelineRetries; attempt++ {
 		if ctx.Err() != nil {
@@ -96,52 +212,84 @@ func RunPipeline(
 		// Final attempt, no FIFO support, or --no-fifo: use file-based pipeline (more resilient)
 		useFile := !fifoSupported || attempt == maxPipelineRetries
 
-		splitDir, err := os.MkdirTemp(tmpDir, "split-*")
+		// Each attempt keeps its decompressed file, FIFO and split output in
+		// its own work dir, so the disk monitor can attribute usage to it.
+		workDir, err := os.MkdirTemp(tmpDir, "work-*")
 		if err != nil {
+			return &PipelineResult{URL: url, Err: fmt.Errorf("creating work dir: %w", err)}
+		}
+		splitDir := filepath.Join(workDir, "split")
+		if keep != nil {
+			splitDir, err = keptSplits.newDir()
+		} else {
+			err = os.Mkdir(splitDir, 0o700)
+		}
+		if err != nil {
+			os.RemoveAll(workDir)
 			return &PipelineResult{URL: url, Err: fmt.Errorf("creating split dir: %w", err)}
 		}
+		tracker.SetWorkDir(workDir)
 
 		// Use standard gzip (single-threaded, more reliable) on retries
 		useStdGzip := attempt > 1
 
+		logx.Debugf(ctx, "%s: attempt %d/%d (file=%v, fifo=%v, std-gzip=%v)",
+			FileNameFromURL(url), attempt, maxPipelineRetries, useFile, fifoSupported, useStdGzip)
 		var result *PipelineResult
-		if useFile {
-			result = runPipelineWithFile(ctx, url, targetNPIs, tmpDir, splitDir, useStdGzip, tracker)
-		} else {
-			result = runPipelineWithFIFO(ctx, url, targetNPIs, tmpDir, splitDir, useStdGzip, tracker)
-		}
-
+		func() {
+			// Remove the work dir even if the attempt panics (the pool
+			// turns the panic into this file's error).
+			defer func() {
+				if keep != nil {
+					if result != nil && result.Err == nil {
+						keptSplits.commit(ctx, splitDir, keep)
+					} else {
+						os.RemoveAll(splitDir)
+					}
+				}
+				os.RemoveAll(workDir)
+				tracker.SetWorkDir("")
+			}()
+			if useFile {
+				result = runPipelineWithFile(ctx, url, opts, part, workDir, splitDir, useStdGzip, spoolDir, tracker)
+			} else {
+				result = runPipelineWithFIFO(ctx, url, opts, workDir, splitDir, useStdGzip, spoolDir, tracker)
+			}
+			result.finishSpool()
+		}()
 		if result.Err == nil {
-			// Success — splitDir cleanup is handled by the caller via defer in the sub-functions,
-			// but we need to ensure it's cleaned up here since we created it.
-			os.RemoveAll(splitDir)
 			return result
 		}
 
-		// Clean up failed attempt
-		os.RemoveAll(splitDir)
+		// Failed attempt
 		lastErr = result.Err
 
-		if ctx.Err() != nil {
-			return result // context cancelled, don't retry
+		if ctx.Err() != nil || isPanic(lastErr) || isAuthError(lastErr) {
+			return result // context cancelled, a parser bug, or refused access; retrying won't help
+		}
+
+		// A stalled FIFO is no transient failure: go straight to the file
+		// pipeline, without a backoff.
+		if errors.Is(lastErr, errFIFOStalled) && attempt < maxPipelineRetries {
+			tracker.LogWarning(fmt.Sprintf("Attempt %d/%d: %v; falling back to the file pipeline", attempt, maxPipelineRetries, lastErr))
+			fifoSupported = false
+			continue
 		}
 
 		// Don't retry on disk-full — retrying won't help
 		if isDiskFullError(lastErr) {
-			avail := availableSpace(tmpDir)
+			avail := AvailableSpace(tmpDir)
 			result.Err = fmt.Errorf("%w (available: %s in %s — use --tmp-dir for a larger volume or --workers 1 to reduce concurrent usage)",
-				lastErr, humanBytesWorker(avail), tmpDir)
+				lastErr, humanize.Bytes(avail), tmpDir)
 			return result
 		}
 
 		if attempt < maxPipelineRetries {
 			tracker.LogWarning(fmt.Sprintf("Attempt %d/%d failed: %v", attempt, maxPipelineRetries, lastErr))
-			delay := time.Duration(attempt) * 2 * time.Second
-			tracker.SetStage(fmt.Sprintf("Retry %d/%d (waiting %s)", attempt+1, maxPipelineRetries, delay))
-			select {
-			case <-time.After(delay):
-			case <-ctx.Done():
-				return &PipelineResult{URL: url, Err: ctx.Err()}
+			delay := jittered(time.Duration(attempt) * 2 * time.Second)
+			tracker.SetStage(fmt.Sprintf("Retry %d/%d (waiting %s)", attempt+1, maxPipelineRetries, delay.Round(100*time.Millisecond)))
+			if err := clock.Sleep(ctx, delay); err != nil {
+				return &PipelineResult{URL: url, Err: err}
 			}
 		}
 	}
@@ -153,13 +301,17 @@ func RunPipeline(
 func runPipelineWithFIFO(
 	ctx context.Context,
 	url string,
-	targetNPIs map[int64]struct{},
+	opts *mrf.SearchOptions,
 	tmpDir string,
 	splitDir string,
 	useStdGzip bool,
+	spoolDir string,
 	tracker progress.Tracker,
 ) *PipelineResult {
-	result := &PipelineResult{URL: url}
+	result := newPipelineResult(url, spoolDir)
+	if result.Err != nil {
+		return result
+	}
 
 	fifoPath := filepath.Join(tmpDir, fmt.Sprintf("stream-%d-%d.fifo", os.Getpid(), time.Now().UnixNano()))
 	if err := syscall.Mkfifo(fifoPath, 0o600); err != nil {
@@ -180,10 +332,26 @@ func runPipelineWithFIFO(
 		stage += " (std gzip)"
 	}
 	tracker.SetStage(stage)
-	dlErrCh := make(chan error, 1)
+
+	// The watchdog cancels attemptCtx if the transfer stalls; it stops when
+	// the split is done.
+	attemptCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	var downloaded atomic.Int64
+	go watchFIFO(attemptCtx, cancel, &downloaded, splitDir)
+
+	type dlOut struct {
+		result *DownloadResult
+		err    error
+	}
+	dlCh := make(chan dlOut, 1)
 	go func() {
-		dlErrCh <- StreamDecompressToPath(ctx, url, fifoPath, useStdGzip, func(downloaded, total int64) {
-			tracker.SetProgress(downloaded, total)
+		var out dlOut
+		defer func() { dlCh <- out }()
+		defer mrf.RecoverPanic(&out.err)
+		out.result, out.err = StreamDecompressToPath(attemptCtx, url, fifoPath, useStdGzip, func(n, total int64) {
+			downloaded.Store(n)
+			tracker.SetProgress(n, total)
 		})
 	}()
 
@@ -208,32 +376,82 @@ func runPipelineWithFIFO(
 	case out := <-splitCh:
 		splitResult = out.result
 		splitErr = out.err
-	case <-ctx.Done():
-		// Unblock jsplit by opening the write end of the FIFO briefly.
-		// jsplit is blocked on os.Open(fifoPath) waiting for a writer — this
-		// connection lets it proceed, read EOF, and return.
-		if f, openErr := os.OpenFile(fifoPath, os.O_WRONLY, 0); openErr == nil {
-			f.Close()
-		}
+	case <-attemptCtx.Done():
+		unblockFIFO(fifoPath)
 		<-splitCh // wait for jsplit to finish
-		<-dlErrCh // drain download goroutine
-		result.Err = ctx.Err()
+		<-dlCh    // drain download goroutine
+		result.Err = context.Cause(attemptCtx)
 		return result
 	}
+	if splitErr != nil {
+		// jsplit may have failed before opening the FIFO, leaving the
+		// download waiting for a reader.
+		unblockFIFO(fifoPath)
+	}
 
 	// Always drain the download goroutine
-	dlErr := <-dlErrCh
+	dl := <-dlCh
+	dlErr := dl.err
+	cancel(nil)
 
 	if splitErr != nil {
-		result.Err = fmt.Errorf("split: %w", splitErr)
+		result.Err = fmt.Errorf("%w: %w", errSplit, splitErr)
 		return result
 	}
 	if dlErr != nil {
 		result.Err = fmt.Errorf("download: %w", dlErr)
 		return result
 	}
+	result.CompressedBytes = dl.result.CompressedBytes
+	result.DecompressedBytes = dl.result.DecompressedBytes
 
-	return runParsePhases(ctx, result, splitResult, targetNPIs, url, tracker)
+	return runParsePhases(ctx, result, splitResult, opts, url, tracker)
+}
+
+// watchFIFO cancels a FIFO attempt with errFIFOStalled once neither the
+// downloaded byte count nor the size of splitDir has changed for
+// fifoStallTimeout. It returns when ctx is done.
+func watchFIFO(ctx context.Context, cancel context.CancelCauseFunc, downloaded *atomic.Int64, splitDir string) {
+	if fifoStallTimeout <= 0 {
+		return
+	}
+	ticker := time.NewTicker(min(fifoStallTimeout/4, 30*time.Second))
+	defer ticker.Stop()
+	lastDL, lastSplit := downloaded.Load(), dirSize(splitDir)
+	lastMove := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		dl, split := downloaded.Load(), dirSize(splitDir)
+		if dl != lastDL || split != lastSplit {
+			lastDL, lastSplit, lastMove = dl, split, time.Now()
+			continue
+		}
+		if stalled := time.Since(lastMove); stalled >= fifoStallTimeout {
+			cancel(fmt.Errorf("%w: no data downloaded or split for %s (at %s downloaded)",
+				errFIFOStalled, stalled.Round(time.Second), humanize.SignedBytes(dl)))
+			return
+		}
+	}
+}
+
+// unblockFIFO releases whichever side of an abandoned FIFO is stuck. A
+// reader waiting in open gets a writer that closes at once, so it reads EOF;
+// a writer waiting in open or in a full write gets a reader that discards
+// until the writer gives up. Neither open blocks.
+func unblockFIFO(path string) {
+	if f, err := os.OpenFile(path, os.O_WRONLY|syscall.O_NONBLOCK, 0); err == nil {
+		f.Close()
+	}
+	if f, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NONBLOCK, 0); err == nil {
+		go func() {
+			io.Copy(io.Discard, f)
+			f.Close()
+		}()
+	}
 }
 
 // runPipelineWithFile downloads the full decompressed file to disk before splitting.
@@ -241,20 +459,29 @@ func runPipelineWithFIFO(
 func runPipelineWithFile(
 	ctx context.Context,
 	url string,
-	targetNPIs map[int64]struct{},
+	opts *mrf.SearchOptions,
+	part *partialDownload,
 	tmpDir string,
 	splitDir string,
 	useStdGzip bool,
+	spoolDir string,
 	tracker progress.Tracker,
 ) *PipelineResult {
-	result := &PipelineResult{URL: url}
+	result := newPipelineResult(url, spoolDir)
+	if result.Err != nil {
+		return result
+	}
 
 	stage := "Downloading"
 	if useStdGzip {
 		stage += " (std gzip)"
 	}
 	tracker.SetStage(stage)
-	dlResult, err := DownloadAndDecompress(ctx, url, tmpDir, useStdGzip, func(downloaded, total int64) {
+	if n := part.size(); n > 0 && !part.complete {
+		stage = fmt.Sprintf("Resuming at %s", humanize.Bytes(uint64(n)))
+		tracker.SetStage(stage)
+	}
+	dlResult, err := downloadAndDecompressResumable(ctx, url, part, tmpDir, useStdGzip, func(downloaded, total int64) {
 		tracker.SetProgress(downloaded, total)
 	})
 	if err != nil {
@@ -262,6 +489,8 @@ func runPipelineWithFile(
 		return result
 	}
 	defer os.Remove(dlResult.FilePath)
+	result.CompressedBytes = dlResult.CompressedBytes
+	result.DecompressedBytes = dlResult.DecompressedBytes
 
 	// Get decompressed file size for split progress tracking
 	inputSize := fileSize(dlResult.FilePath)
@@ -277,39 +506,45 @@ func runPipelineWithFile(
 	splitMu.Unlock()
 
 	if err != nil {
-		result.Err = fmt.Errorf("split: %w", err)
+		result.Err = fmt.Errorf("%w: %w", errSplit, err)
 		return result
 	}
 
 	// Remove decompressed file immediately to free disk
 	os.Remove(dlResult.FilePath)
 
-	return runParsePhases(ctx, result, splitResult, targetNPIs, url, tracker)
+	return runParsePhases(ctx, result, splitResult, opts, url, tracker)
 }
 
-// runParsePhases runs Phase A (provider_references) and Phase B (in_network) parsing.
+// runParsePhases runs Phase A (provider_references) and Phase B (in_network)
+// parsing. Phase A is skipped when provider groups are targeted by ID.
 func runParsePhases(
 	ctx context.Context,
 	result *PipelineResult,
 	splitResult *mrf.SplitResult,
-	targetNPIs map[int64]struct{},
+	opts *mrf.SearchOptions,
 	url string,
 	tracker progress.Tracker,
 ) *PipelineResult {
 	// Phase A — Parse provider references
-	tracker.SetStage("Parsing: provider_references")
-	var refsScanned int64
-	matchedProviders, err := mrf.ParseProviderReferences(
-		splitResult.ProviderReferenceFiles,
-		targetNPIs,
-		func() {
-			atomic.AddInt64(&refsScanned, 1)
-			tracker.SetCounter("refs_scanned", atomic.LoadInt64(&refsScanned))
-		},
-	)
-	if err != nil {
-		result.Err = fmt.Errorf("parse provider_references: %w", err)
-		return result
+	matchedProviders := opts.SeededProviders()
+	if matchedProviders == nil {
+		tracker.SetStage("Parsing: provider_references")
+		refsScanned := newThrottledCounter("refs_scanned", tracker)
+		var err error
+		matchedProviders, err = mrf.ParseProviderReferences(
+			splitResult.ProviderReferenceFiles,
+			opts,
+			refsScanned.Inc,
+		)
+		refsScanned.Flush()
+		if err != nil {
+			result.Err = fmt.Errorf("%w provider_references: %w", errParse, err)
+			return result
+		}
+		if msg := matchedProviders.CappedWarning(); msg != "" {
+			tracker.LogWarning(msg)
+		}
 	}
 
 	hasRefMatches := len(matchedProviders.ByGroupID) > 0
@@ -322,32 +557,30 @@ func runParsePhases(
 
 	// Phase B — Parse in_network rates
 	tracker.SetStage("Parsing: in_network")
-	var codesScanned int64
+	codesScanned := newThrottledCounter("codes_scanned", tracker)
 	var mu sync.Mutex
 
-	err = mrf.ParseInNetwork(
+	err := mrf.ParseInNetwork(
 		splitResult.InNetworkFiles,
-		targetNPIs,
+		opts,
 		matchedProviders,
 		url,
-		func() {
-			atomic.AddInt64(&codesScanned, 1)
-			tracker.SetCounter("codes_scanned", atomic.LoadInt64(&codesScanned))
-		},
-		func(r mrf.RateResult) {
+		codesScanned.Inc,
+		func(batch []mrf.RateResult) {
 			mu.Lock()
-			result.Results = append(result.Results, r)
+			n := int64(result.add(batch))
 			mu.Unlock()
-			tracker.SetCounter("rates_found", int64(len(result.Results)))
+			tracker.SetCounter("rates_found", n)
 		},
 	)
+	codesScanned.Flush()
 	if err != nil {
-		result.Err = fmt.Errorf("parse in_network: %w", err)
+		result.Err = fmt.Errorf("%w in_network: %w", errParse, err)
 		return result
 	}
 
-	if len(result.Results) > 0 {
-		tracker.SetStage(fmt.Sprintf("Done (%d rates)", len(result.Results)))
+	if result.Count > 0 {
+		tracker.SetStage(fmt.Sprintf("Done (%d rates)", result.Count))
 	} else {
 		tracker.SetStage("Done (no matches)")
 	}
@@ -355,6 +588,33 @@ func runParsePhases(
 	return result
 }
 
+// warnIfTooLarge predicts the file's decompressed size from its gzip footer
+// and warns when it will not fit in tmpDir. The split output alone is about
+// the decompressed size, so this catches files that are certain to fail with
+// ENOSPC before hours are spent downloading them. Probe failures are ignored.
+func warnIfTooLarge(ctx context.Context, url, tmpDir string, tracker progress.Tracker) {
+	predicted, compressed, err := ProbeDecompressedSize(ctx, url)
+	if err != nil {
+		logx.Debugf(ctx, "%s: size probe unavailable: %v", FileNameFromURL(url), err)
+		return
+	}
+	if predicted <= 0 {
+		return
+	}
+	logx.Debugf(ctx, "%s: predicted %s decompressed from %s", FileNameFromURL(url),
+		humanize.Bytes(uint64(predicted)), humanize.Bytes(uint64(compressed)))
+	if avail := AvailableSpace(tmpDir); avail > 0 && uint64(predicted) > avail {
+		tracker.LogWarning(fmt.Sprintf("Predicted decompressed size %s exceeds %s available in %s",
+			humanize.Bytes(uint64(predicted)), humanize.Bytes(avail), tmpDir))
+	}
+}
+
+// isPanic reports whether err carries a recovered panic.
+func isPanic(err error) bool {
+	var pe *mrf.PanicError
+	return errors.As(err, &pe)
+}
+
 // isDiskFullError checks if the error chain contains a "no space left on device" error.
 func isDiskFullError(err error) bool {
 	if err == nil {
@@ -371,8 +631,9 @@ func isDiskFullError(err error) bool {
 	return strings.Contains(err.Error(), "no space left on device")
 }
 
-// availableSpace returns the available bytes on the filesystem containing path.
-func availableSpace(path string) uint64 {
+// AvailableSpace returns the available bytes on the filesystem containing
+// path, or 0 if it cannot be read.
+func AvailableSpace(path string) uint64 {
 	var stat syscall.Statfs_t
 	if err := syscall.Statfs(path, &stat); err != nil {
 		return 0
@@ -424,21 +685,3 @@ func pollSplitProgress(splitDir string, expectedTotal int64, tracker progress.Tr
 	}()
 	return func() { close(done) }
 }
-
-func humanBytesWorker(b uint64) string {
-	const (
-		kb uint64 = 1024
-		mb        = 1024 * kb
-		gb        = 1024 * mb
-	)
-	switch {
-	case b >= gb:
-		return fmt.Sprintf("%.1f GB", float64(b)/float64(gb))
-	case b >= mb:
-		return fmt.Sprintf("%.1f MB", float64(b)/float64(mb))
-	case b >= kb:
-		return fmt.Sprintf("%.1f KB", float64(b)/float64(kb))
-	default:
-		return fmt.Sprintf("%d B", b)
-	}
-}
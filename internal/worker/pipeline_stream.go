@@ -3,54 +3,98 @@ package worker
 import (
 	"context"
 	"fmt"
+	"io"
 	"sync"
-	"sync/atomic"
 
 	"github.com/gyeh/npi-rates/internal/mrf"
 	"github.com/gyeh/npi-rates/internal/progress"
 )
 
 // downloadAndParse downloads the URL, sets up the gzip reader pipeline, and
-// runs StreamParse. Returns the StreamResult or an error.
+// runs StreamParse. Returns the StreamResult and transfer sizes, or an error.
+// If spill is non-nil, the compressed bytes are also copied to it.
 func downloadAndParse(
 	ctx context.Context,
 	url string,
-	targetNPIs map[int64]struct{},
+	opts *mrf.SearchOptions,
 	useStdGzip bool,
 	tracker progress.Tracker,
 	callbacks mrf.StreamCallbacks,
-	emit func(mrf.RateResult),
+	emit func([]mrf.RateResult),
 	prebuilt *mrf.MatchedProviders,
-) (*mrf.StreamResult, error) {
-	resp, err := DownloadHTTP(ctx, url)
+	spill *downloadSpill,
+) (*mrf.StreamResult, *DownloadResult, error) {
+	resp, err := openDownload(ctx, url)
 	if err != nil {
-		return nil, fmt.Errorf("download: %w", err)
+		return nil, nil, fmt.Errorf("download: %w", err)
 	}
 	defer resp.Body.Close()
 
+	var body io.Reader = resp.Body
+	if spill != nil {
+		body = io.TeeReader(resp.Body, spill)
+	}
+	sr, dl, err := parseCompressed(body, resp.ContentLength, url, opts, useStdGzip, tracker, callbacks, emit, prebuilt)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.ContentLength > 0 && dl.CompressedBytes != resp.ContentLength {
+		return nil, nil, fmt.Errorf("%w: got %d of %d compressed bytes", errTruncated, dl.CompressedBytes, resp.ContentLength)
+	}
+	downloadSizes.record(url, dl.CompressedBytes)
+	downloadSizes.recordRatio(dl.CompressedBytes, dl.DecompressedBytes)
+	return sr, dl, nil
+}
+
+// parseCompressed decompresses body, total bytes long (-1 if unknown), and
+// runs StreamParse on it, reporting progress through tracker.
+func parseCompressed(
+	body io.Reader,
+	total int64,
+	url string,
+	opts *mrf.SearchOptions,
+	useStdGzip bool,
+	tracker progress.Tracker,
+	callbacks mrf.StreamCallbacks,
+	emit func([]mrf.RateResult),
+	prebuilt *mrf.MatchedProviders,
+) (*mrf.StreamResult, *DownloadResult, error) {
 	progReader := &progressReader{
-		reader:   resp.Body,
-		total:    resp.ContentLength,
+		reader:   body,
+		total:    total,
 		callback: func(downloaded, total int64) { tracker.SetProgress(downloaded, total) },
+		url:      url,
 	}
 	countReader := &countingReader{reader: progReader}
 
-	gzReader, err := NewGzipReader(countReader, useStdGzip)
+	gzReader, err := NewDecompressReader(countReader, useStdGzip)
 	if err != nil {
-		return nil, fmt.Errorf("gzip reader: %w", err)
+		return nil, nil, fmt.Errorf("%w: %w", errDecompress, err)
 	}
 	defer gzReader.Close()
 
-	sr, err := mrf.StreamParse(gzReader, targetNPIs, url, callbacks, emit, prebuilt)
+	decompCount := &countingReader{reader: gzReader}
+
+	sr, err := mrf.StreamParse(decompCount, opts, url, callbacks, emit, prebuilt)
 	if err != nil {
-		return nil, fmt.Errorf("stream parse: %w", err)
+		return nil, nil, fmt.Errorf("stream %w: %w", errParse, err)
 	}
 
-	if resp.ContentLength > 0 && countReader.n != resp.ContentLength {
-		return nil, fmt.Errorf("download truncated: got %d of %d compressed bytes", countReader.n, resp.ContentLength)
+	// The parser stops at the closing '}', before the decompressor has seen
+	// the trailer. Drain it so the integrity check (gzip CRC-32/ISIZE, zstd
+	// checksum) runs; for chunked responses without a Content-Length this is
+	// the only truncation check. Copying from
+	// the counting wrapper also avoids pgzip's WriteTo, which panics when
+	// resumed after partial Reads.
+	if _, err := io.Copy(io.Discard, decompCount); err != nil {
+		return nil, nil, fmt.Errorf("verifying compressed trailer: %w", err)
 	}
 
-	return sr, nil
+	return sr, &DownloadResult{
+		TotalBytes:        total,
+		CompressedBytes:   countReader.n,
+		DecompressedBytes: decompCount.n,
+	}, nil
 }
 
 // runPipelineStreaming processes a single MRF URL by streaming directly from
@@ -59,27 +103,26 @@ func downloadAndParse(
 func runPipelineStreaming(
 	ctx context.Context,
 	url string,
-	targetNPIs map[int64]struct{},
+	opts *mrf.SearchOptions,
 	useStdGzip bool,
+	tmpDir string,
+	spoolDir string,
 	tracker progress.Tracker,
 ) *PipelineResult {
-	result := &PipelineResult{URL: url}
+	result := newPipelineResult(url, spoolDir)
+	if result.Err != nil {
+		return result
+	}
 
 	tracker.SetStage("Streaming")
 
-	var refsScanned int64
-	var codesScanned int64
+	refsScanned := newThrottledCounter("refs_scanned", tracker)
+	codesScanned := newThrottledCounter("codes_scanned", tracker)
 	var mu sync.Mutex
 
 	callbacks := mrf.StreamCallbacks{
-		OnRefScanned: func() {
-			atomic.AddInt64(&refsScanned, 1)
-			tracker.SetCounter("refs_scanned", atomic.LoadInt64(&refsScanned))
-		},
-		OnCodeScanned: func() {
-			atomic.AddInt64(&codesScanned, 1)
-			tracker.SetCounter("codes_scanned", atomic.LoadInt64(&codesScanned))
-		},
+		OnRefScanned:  refsScanned.Inc,
+		OnCodeScanned: codesScanned.Inc,
 		OnStageChange: func(stage string) {
 			tracker.SetStage(stage)
 		},
@@ -87,32 +130,56 @@ func runPipelineStreaming(
 			tracker.LogWarning(msg)
 		},
 	}
-	emitFunc := func(r mrf.RateResult) {
+
+	// A file with in_network first is read twice. With the spill on, the
+	// first read keeps a copy for the second until the layout is known.
+	var spill *downloadSpill
+	if spillSecondPass && opts.SeededProviders() == nil {
+		var err error
+		if spill, err = newDownloadSpill(tmpDir); err != nil {
+			tracker.LogWarning(fmt.Sprintf("%v; a second pass would download again", err))
+		}
+		defer spill.drop()
+		callbacks.OnLayout = func(inNetworkFirst bool) {
+			if !inNetworkFirst {
+				spill.drop()
+			}
+		}
+	}
+	emitFunc := func(batch []mrf.RateResult) {
 		mu.Lock()
-		result.Results = append(result.Results, r)
-		n := int64(len(result.Results))
+		n := int64(result.add(batch))
 		mu.Unlock()
 		tracker.SetCounter("rates_found", n)
 	}
 
-	streamResult, err := downloadAndParse(ctx, url, targetNPIs, useStdGzip, tracker, callbacks, emitFunc, nil)
+	streamResult, dl, err := downloadAndParse(ctx, url, opts, useStdGzip, tracker, callbacks, emitFunc, nil, spill)
 	if err != nil {
 		result.Err = err
 		return result
 	}
+	result.CompressedBytes = dl.CompressedBytes
+	result.DecompressedBytes = dl.DecompressedBytes
 
 	if streamResult.NeedSecondPass {
-		tracker.SetStage("Re-downloading for in_network")
-
-		_, err = downloadAndParse(ctx, url, targetNPIs, useStdGzip, tracker, callbacks, emitFunc, streamResult.MatchedProviders)
+		if r, size, serr := spill.replay(); serr == nil {
+			tracker.SetStage("Replaying spilled download for in_network")
+			_, _, err = parseCompressed(r, size, url, opts, useStdGzip, tracker, callbacks, emitFunc, streamResult.MatchedProviders)
+		} else {
+			if spill != nil {
+				tracker.LogWarning(fmt.Sprintf("%v; downloading again", serr))
+			}
+			tracker.SetStage("Re-downloading for in_network")
+			_, _, err = downloadAndParse(ctx, url, opts, useStdGzip, tracker, callbacks, emitFunc, streamResult.MatchedProviders, nil)
+		}
 		if err != nil {
 			result.Err = fmt.Errorf("second pass: %w", err)
 			return result
 		}
 	}
 
-	if len(result.Results) > 0 {
-		tracker.SetStage(fmt.Sprintf("Done (%d rates)", len(result.Results)))
+	if result.Count > 0 {
+		tracker.SetStage(fmt.Sprintf("Done (%d rates)", result.Count))
 	} else {
 		tracker.SetStage("Done (no matches)")
 	}
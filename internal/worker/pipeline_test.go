@@ -3,9 +3,20 @@ package worker
 import (
 	"compress/gzip"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/gyeh/npi-rates/internal/mrf"
 	"github.com/gyeh/npi-rates/internal/progress"
@@ -172,14 +183,14 @@ func TestPipelineEndToEnd(t *testing.T) {
 	defer server.Close()
 
 	url := server.URL + "/test-mrf.json.gz"
-	targetNPIs := map[int64]struct{}{1316924913: {}}
+	opts := &mrf.SearchOptions{NPIs: map[int64]struct{}{1316924913: {}}}
 	tmpDir := t.TempDir()
 	tracker := &progress.NoopManager{}
 
 	result := RunPipeline(
 		context.Background(),
 		url,
-		targetNPIs,
+		opts,
 		tmpDir,
 		false, false,
 		tracker.NewTracker(0, 1, "test-mrf.json.gz"),
@@ -301,14 +312,14 @@ func TestPipelineEndToEnd_NoMatch(t *testing.T) {
 	defer server.Close()
 
 	url := server.URL + "/test-mrf.json.gz"
-	targetNPIs := map[int64]struct{}{1111111111: {}} // NPI not in the file
+	opts := &mrf.SearchOptions{NPIs: map[int64]struct{}{1111111111: {}}} // NPI not in the file
 	tmpDir := t.TempDir()
 	tracker := &progress.NoopManager{}
 
 	result := RunPipeline(
 		context.Background(),
 		url,
-		targetNPIs,
+		opts,
 		tmpDir,
 		false, false,
 		tracker.NewTracker(0, 1, "test-mrf.json.gz"),
@@ -323,6 +334,73 @@ func TestPipelineEndToEnd_NoMatch(t *testing.T) {
 	}
 }
 
+// TestPipelineKeepSplit verifies that with a keep-split dir, a second search
+// of an unchanged file parses the kept split output without downloading it,
+// and a changed file is downloaded again.
+func TestPipelineKeepSplit(t *testing.T) {
+	mrfJSON := buildTestMRF()
+	var etag atomic.Value
+	etag.Store(`"v1"`)
+	var downloads atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", etag.Load().(string))
+		if r.Header.Get("Range") == "" {
+			downloads.Add(1)
+		}
+		gz := gzip.NewWriter(w)
+		gz.Write([]byte(mrfJSON))
+		gz.Close()
+	}))
+	defer server.Close()
+
+	keepDir := t.TempDir()
+	if err := SetKeepSplitDir(keepDir); err != nil {
+		t.Fatal(err)
+	}
+	defer SetKeepSplitDir("")
+
+	url := server.URL + "/test-mrf.json.gz"
+	opts := &mrf.SearchOptions{NPIs: map[int64]struct{}{1316924913: {}}}
+	tracker := &progress.NoopManager{}
+	run := func() int {
+		t.Helper()
+		result := RunPipeline(context.Background(), url, opts, t.TempDir(), true, false,
+			tracker.NewTracker(0, 1, "test-mrf.json.gz"))
+		if result.Err != nil {
+			t.Fatalf("pipeline failed: %v", result.Err)
+		}
+		return len(result.Results)
+	}
+
+	if n := run(); n != 4 {
+		t.Fatalf("first run: expected 4 results, got %d", n)
+	}
+	if n := run(); n != 4 {
+		t.Fatalf("second run: expected 4 results, got %d", n)
+	}
+	if got := downloads.Load(); got != 1 {
+		t.Errorf("expected 1 download for an unchanged file, got %d", got)
+	}
+
+	etag.Store(`"v2"`)
+	if n := run(); n != 4 {
+		t.Fatalf("changed file: expected 4 results, got %d", n)
+	}
+	if got := downloads.Load(); got != 2 {
+		t.Errorf("expected the changed file to be downloaded again, got %d downloads", got)
+	}
+
+	entries, _ := os.ReadDir(keepDir)
+	if len(entries) != 2 {
+		t.Errorf("expected 2 kept versions, got %d", len(entries))
+	}
+	for _, e := range entries {
+		if _, err := os.Stat(filepath.Join(keepDir, e.Name(), splitSourceFile)); err != nil {
+			t.Errorf("%s: %v", e.Name(), err)
+		}
+	}
+}
+
 // TestPipelineEndToEnd_MultipleNPIs verifies the pipeline handles multiple target NPIs.
 func TestPipelineEndToEnd_MultipleNPIs(t *testing.T) {
 	mrfJSON := buildTestMRF()
@@ -331,17 +409,17 @@ func TestPipelineEndToEnd_MultipleNPIs(t *testing.T) {
 
 	url := server.URL + "/test-mrf.json.gz"
 	// Target both NPIs that exist in the file
-	targetNPIs := map[int64]struct{}{
+	opts := &mrf.SearchOptions{NPIs: map[int64]struct{}{
 		1316924913: {},
 		9999999999: {},
-	}
+	}}
 	tmpDir := t.TempDir()
 	tracker := &progress.NoopManager{}
 
 	result := RunPipeline(
 		context.Background(),
 		url,
-		targetNPIs,
+		opts,
 		tmpDir,
 		false, false,
 		tracker.NewTracker(0, 1, "test-mrf.json.gz"),
@@ -355,7 +433,7 @@ func TestPipelineEndToEnd_MultipleNPIs(t *testing.T) {
 	// 99213: 2 prices × NPI 1316924913 = 2
 	// 99214: 1 price × NPI 9999999999 = 1
 	// J0129: 1 price × 2 NPIs (1316924913 + 5555555555 but only 1316924913 matches) = 1
-	//        Wait — 5555555555 is NOT in targetNPIs. So only 1316924913 matches = 1
+	//        Wait — 5555555555 is NOT in opts.NPIs. So only 1316924913 matches = 1
 	// 36415: 1 price × NPI 1316924913 = 1
 	// Total: 2 + 1 + 1 + 1 = 5
 	if len(result.Results) != 5 {
@@ -397,10 +475,10 @@ func TestPoolEndToEnd(t *testing.T) {
 	}
 
 	pool := &Pool{
-		Workers:    2,
-		TargetNPIs: map[int64]struct{}{1316924913: {}},
-		TmpDir:     t.TempDir(),
-		Progress:   &progress.NoopManager{},
+		Workers:  2,
+		Search:   &mrf.SearchOptions{NPIs: map[int64]struct{}{1316924913: {}}},
+		TmpDir:   t.TempDir(),
+		Progress: &progress.NoopManager{},
 	}
 
 	results := pool.Run(context.Background(), urls)
@@ -436,6 +514,239 @@ func TestPoolEndToEnd(t *testing.T) {
 	}
 }
 
+// TestPoolEndToEnd_OnResults verifies that with OnResults set, results are
+// delivered through the callback from an on-disk spool rather than returned
+// in memory, and that no spool files are left behind.
+func TestPoolEndToEnd_OnResults(t *testing.T) {
+	mrfJSON := buildTestMRF()
+	server := serveGzippedMRF(t, mrfJSON)
+	defer server.Close()
+
+	urls := []string{
+		server.URL + "/file1.json.gz",
+		server.URL + "/file2.json.gz",
+	}
+
+	for _, stream := range []bool{true, false} {
+		tmpDir := t.TempDir()
+		delivered := map[string]int{}
+		pool := &Pool{
+			Workers:  2,
+			Search:   &mrf.SearchOptions{NPIs: map[int64]struct{}{1316924913: {}}},
+			TmpDir:   tmpDir,
+			Progress: &progress.NoopManager{},
+			Stream:   stream,
+			OnResults: func(batch []mrf.RateResult) error {
+				for _, r := range batch {
+					delivered[r.SourceFile]++
+				}
+				return nil
+			},
+		}
+
+		results := pool.Run(context.Background(), urls)
+		for i, r := range results {
+			if r.Err != nil {
+				t.Fatalf("stream=%v file %d failed: %v", stream, i, r.Err)
+			}
+			if len(r.Results) != 0 {
+				t.Errorf("stream=%v file %d: expected no in-memory results, got %d", stream, i, len(r.Results))
+			}
+			if r.Count != 4 {
+				t.Errorf("stream=%v file %d: expected Count 4, got %d", stream, i, r.Count)
+			}
+			if delivered[urls[i]] != 4 {
+				t.Errorf("stream=%v file %d: expected 4 delivered results, got %d", stream, i, delivered[urls[i]])
+			}
+		}
+
+		spools, _ := filepath.Glob(filepath.Join(tmpDir, "results-*.ndjson"))
+		if len(spools) != 0 {
+			t.Errorf("stream=%v: spool files left behind: %v", stream, spools)
+		}
+	}
+}
+
+// TestPoolEndToEnd_LogDir verifies that each file gets its own debug log
+// recording the response and the outcome.
+func TestPoolEndToEnd_LogDir(t *testing.T) {
+	server := serveGzippedMRF(t, buildTestMRF())
+	defer server.Close()
+
+	urls := []string{
+		server.URL + "/a/rates.json.gz",
+		server.URL + "/b/rates.json.gz",
+	}
+	logDir := t.TempDir()
+	pool := &Pool{
+		Workers:  2,
+		Search:   &mrf.SearchOptions{NPIs: map[int64]struct{}{1316924913: {}}},
+		TmpDir:   t.TempDir(),
+		Progress: &progress.NoopManager{},
+		Stream:   true,
+		LogDir:   logDir,
+	}
+	for i, r := range pool.Run(context.Background(), urls) {
+		if r.Err != nil {
+			t.Fatalf("file %d failed: %v", i, r.Err)
+		}
+	}
+
+	for i, name := range []string{"001_rates.json.gz.log", "002_rates.json.gz.log"} {
+		data, err := os.ReadFile(filepath.Join(logDir, name))
+		if err != nil {
+			t.Fatalf("reading log: %v", err)
+		}
+		log := string(data)
+		for _, want := range []string{"URL: " + urls[i], "HTTP 200", "stage: ", "done in ", "4 rates"} {
+			if !strings.Contains(log, want) {
+				t.Errorf("%s: missing %q in:\n%s", name, want, log)
+			}
+		}
+	}
+}
+
+// deadlineClock is a Clock whose sleeps end only when fire is closed, so a
+// test decides when a deadline passes.
+type deadlineClock struct {
+	now   time.Time
+	fire  chan struct{}
+	slept chan time.Duration
+}
+
+func (c *deadlineClock) Now() time.Time { return c.now }
+
+func (c *deadlineClock) Sleep(ctx context.Context, d time.Duration) error {
+	c.slept <- d
+	select {
+	case <-c.fire:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// TestPoolDeadline verifies that files in flight when the deadline passes
+// complete, while files not yet started are skipped with ErrDeadline.
+func TestPoolDeadline(t *testing.T) {
+	mrfServer := serveGzippedMRF(t, buildTestMRF())
+	defer mrfServer.Close()
+	started := make(chan struct{}, 3)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		mrfServer.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	fc := &deadlineClock{now: time.Unix(1000, 0), fire: make(chan struct{}), slept: make(chan time.Duration, 1)}
+	SetClock(fc)
+	defer SetClock(nil)
+
+	urls := []string{server.URL + "/1.json.gz", server.URL + "/2.json.gz", server.URL + "/3.json.gz"}
+	pool := &Pool{
+		Workers:  1,
+		Search:   &mrf.SearchOptions{NPIs: map[int64]struct{}{1316924913: {}}},
+		TmpDir:   t.TempDir(),
+		Progress: &progress.NoopManager{},
+		Stream:   true,
+		Deadline: fc.now.Add(time.Hour),
+	}
+	resultsCh := make(chan []PipelineResult)
+	go func() { resultsCh <- pool.Run(context.Background(), urls) }()
+
+	if d := <-fc.slept; d != time.Hour {
+		t.Errorf("expected the pool to wait 1h on its clock, got %v", d)
+	}
+	<-started
+	close(fc.fire)
+	time.Sleep(50 * time.Millisecond) // let the pool see the deadline pass
+	close(release)
+
+	var done, skipped int
+	for i, r := range <-resultsCh {
+		switch {
+		case r.Err == nil && len(r.Results) == 4:
+			done++
+		case errors.Is(r.Err, ErrDeadline):
+			skipped++
+		default:
+			t.Errorf("file %d: err=%v results=%d", i, r.Err, len(r.Results))
+		}
+	}
+	if done != 1 || skipped != 2 {
+		t.Errorf("expected 1 file completed and 2 skipped, got %d and %d", done, skipped)
+	}
+}
+
+// TestPoolScheduler checks the order in which a pool scheduling by size
+// starts files: largest first, with only Workers large files at once, and
+// holding back files that don't fit the disk along with those after them.
+func TestPoolScheduler(t *testing.T) {
+	started := func(slots []slot) []int {
+		var idx []int
+		for _, sl := range slots {
+			idx = append(idx, sl.idx)
+		}
+		return idx
+	}
+
+	urls := []string{"http://x/a.json", "http://x/b.json", "http://x/c.json", "http://x/d.json", "http://x/e.json"}
+	p := &Pool{Workers: 1, MaxWorkers: 3, Stream: true, TmpDir: t.TempDir(),
+		Sizes: []int64{2 << 30, 3 << 30, 10 << 20, 20 << 20, 0}}
+	s := newScheduler(p, urls, []int{0, 1, 2, 3, 4})
+	first := s.next()
+	if got := started(first); !slices.Equal(got, []int{1, 3, 2}) {
+		t.Fatalf("first started %v, want [1 3 2]", got)
+	}
+	s.done(first[0])
+	if got := started(s.next()); !slices.Equal(got, []int{0}) {
+		t.Fatalf("after the large file, started %v, want [0]", got)
+	}
+
+	p = &Pool{Workers: 3, MaxWorkers: 3, TmpDir: t.TempDir(), Sizes: []int64{50, 80, 10}}
+	s = newScheduler(p, urls[:3], []int{0, 1, 2})
+	s.free = []int64{100}
+	first = s.next()
+	if got := started(first); !slices.Equal(got, []int{1}) {
+		t.Fatalf("with 100 bytes free, started %v, want [1]", got)
+	}
+	s.done(first[0])
+	if got := started(s.next()); !slices.Equal(got, []int{0, 2}) {
+		t.Fatalf("once space was freed, started %v, want [0 2]", got)
+	}
+}
+
+// TestPipelineModesAgree runs the same file through every pipeline mode and
+// checks with DiffResults that they find the same results.
+func TestPipelineModesAgree(t *testing.T) {
+	server := serveGzippedMRF(t, buildTestMRF())
+	defer server.Close()
+	opts := &mrf.SearchOptions{NPIs: map[int64]struct{}{1316924913: {}}}
+
+	run := func(noFIFO, stream bool) []mrf.RateResult {
+		result := RunPipeline(context.Background(), server.URL+"/f.json.gz", opts, t.TempDir(),
+			noFIFO, stream, (&progress.NoopManager{}).NewTracker(0, 1, "f.json.gz"))
+		if result.Err != nil {
+			t.Fatalf("noFIFO=%v stream=%v: %v", noFIFO, stream, result.Err)
+		}
+		return result.Results
+	}
+	stream := run(false, true)
+	for _, mode := range []struct{ noFIFO, stream bool }{{false, false}, {true, false}} {
+		if d := DiffResults(stream, run(mode.noFIFO, mode.stream)); !d.Same() || d.Matched != 4 {
+			t.Errorf("noFIFO=%v: %d matched, %d only streamed, %d only split", mode.noFIFO, d.Matched, len(d.OnlyA), len(d.OnlyB))
+		}
+	}
+
+	// Multisets: a duplicate on one side is a difference.
+	d := DiffResults(append(stream, stream[0]), stream)
+	if d.Same() || len(d.OnlyA) != 1 || len(d.OnlyB) != 0 || d.Matched != 4 {
+		t.Errorf("with a duplicate: %d matched, %d only a, %d only b", d.Matched, len(d.OnlyA), len(d.OnlyB))
+	}
+}
+
 // TestPipelineEndToEnd_ContextCancellation verifies the pipeline exits cleanly on cancellation.
 func TestPipelineEndToEnd_ContextCancellation(t *testing.T) {
 	// Serve a response that hangs to simulate a slow download
@@ -446,7 +757,7 @@ func TestPipelineEndToEnd_ContextCancellation(t *testing.T) {
 	defer server.Close()
 
 	url := server.URL + "/slow.json.gz"
-	targetNPIs := map[int64]struct{}{1316924913: {}}
+	opts := &mrf.SearchOptions{NPIs: map[int64]struct{}{1316924913: {}}}
 	tmpDir := t.TempDir()
 	tracker := &progress.NoopManager{}
 
@@ -456,7 +767,7 @@ func TestPipelineEndToEnd_ContextCancellation(t *testing.T) {
 	result := RunPipeline(
 		ctx,
 		url,
-		targetNPIs,
+		opts,
 		tmpDir,
 		false, false,
 		tracker.NewTracker(0, 1, "slow.json.gz"),
@@ -513,14 +824,14 @@ func TestPipelineEndToEnd_FloatProviderGroupID(t *testing.T) {
 
 	// Only target NPI 1234567890 — should only match 42.123456789, NOT 42.987654321
 	url := server.URL + "/float-test.json.gz"
-	targetNPIs := map[int64]struct{}{1234567890: {}}
+	opts := &mrf.SearchOptions{NPIs: map[int64]struct{}{1234567890: {}}}
 	tmpDir := t.TempDir()
 	tracker := &progress.NoopManager{}
 
 	result := RunPipeline(
 		context.Background(),
 		url,
-		targetNPIs,
+		opts,
 		tmpDir,
 		false, false,
 		tracker.NewTracker(0, 1, "float-test.json.gz"),
@@ -559,14 +870,14 @@ func TestPipelineEndToEnd_ServiceCodeAndModifiers(t *testing.T) {
 	defer server.Close()
 
 	url := server.URL + "/test-mrf.json.gz"
-	targetNPIs := map[int64]struct{}{1316924913: {}}
+	opts := &mrf.SearchOptions{NPIs: map[int64]struct{}{1316924913: {}}}
 	tmpDir := t.TempDir()
 	tracker := &progress.NoopManager{}
 
 	result := RunPipeline(
 		context.Background(),
 		url,
-		targetNPIs,
+		opts,
 		tmpDir,
 		false, false,
 		tracker.NewTracker(0, 1, "test-mrf.json.gz"),
@@ -603,14 +914,14 @@ func TestStreamPipelineEndToEnd(t *testing.T) {
 	defer server.Close()
 
 	url := server.URL + "/test-mrf.json.gz"
-	targetNPIs := map[int64]struct{}{1316924913: {}}
+	opts := &mrf.SearchOptions{NPIs: map[int64]struct{}{1316924913: {}}}
 	tmpDir := t.TempDir()
 	tracker := &progress.NoopManager{}
 
 	result := RunPipeline(
 		context.Background(),
 		url,
-		targetNPIs,
+		opts,
 		tmpDir,
 		false, true, // stream=true
 		tracker.NewTracker(0, 1, "test-mrf.json.gz"),
@@ -662,14 +973,14 @@ func TestStreamPipelineEndToEnd_NoMatch(t *testing.T) {
 	defer server.Close()
 
 	url := server.URL + "/test-mrf.json.gz"
-	targetNPIs := map[int64]struct{}{1111111111: {}}
+	opts := &mrf.SearchOptions{NPIs: map[int64]struct{}{1111111111: {}}}
 	tmpDir := t.TempDir()
 	tracker := &progress.NoopManager{}
 
 	result := RunPipeline(
 		context.Background(),
 		url,
-		targetNPIs,
+		opts,
 		tmpDir,
 		false, true,
 		tracker.NewTracker(0, 1, "test-mrf.json.gz"),
@@ -683,6 +994,53 @@ func TestStreamPipelineEndToEnd_NoMatch(t *testing.T) {
 	}
 }
 
+// TestStreamPipeline_SecondPassSpill verifies that a file with in_network
+// before provider_references is downloaded twice by default, and once with
+// the spill on, with the same results and no spill left in the temp dir.
+func TestStreamPipeline_SecondPassSpill(t *testing.T) {
+	var parts map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(buildTestMRF()), &parts); err != nil {
+		t.Fatal(err)
+	}
+	reversed := fmt.Sprintf(`{"in_network": %s, "provider_references": %s}`, parts["in_network"], parts["provider_references"])
+
+	for _, tc := range []struct {
+		spill     bool
+		downloads int32
+	}{{false, 2}, {true, 1}} {
+		t.Run(fmt.Sprintf("spill=%v", tc.spill), func(t *testing.T) {
+			SetSecondPassSpill(tc.spill)
+			defer SetSecondPassSpill(false)
+
+			var requests atomic.Int32
+			mrfServer := serveGzippedMRF(t, reversed)
+			defer mrfServer.Close()
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests.Add(1)
+				mrfServer.Config.Handler.ServeHTTP(w, r)
+			}))
+			defer server.Close()
+
+			tmpDir := t.TempDir()
+			result := RunPipeline(context.Background(), server.URL+"/reversed.json.gz",
+				&mrf.SearchOptions{NPIs: map[int64]struct{}{1316924913: {}}}, tmpDir, false, true,
+				(&progress.NoopManager{}).NewTracker(0, 1, "reversed.json.gz"))
+			if result.Err != nil {
+				t.Fatalf("streaming pipeline failed: %v", result.Err)
+			}
+			if len(result.Results) != 4 {
+				t.Errorf("expected 4 results, got %d", len(result.Results))
+			}
+			if n := requests.Load(); n != tc.downloads {
+				t.Errorf("expected %d downloads, got %d", tc.downloads, n)
+			}
+			if spills, _ := filepath.Glob(filepath.Join(tmpDir, "spill-*")); len(spills) != 0 {
+				t.Errorf("spill left behind: %v", spills)
+			}
+		})
+	}
+}
+
 // TestStreamPipelineEndToEnd_FloatIDs validates that streaming mode correctly
 // distinguishes float provider_group_ids.
 func TestStreamPipelineEndToEnd_FloatIDs(t *testing.T) {
@@ -722,14 +1080,14 @@ func TestStreamPipelineEndToEnd_FloatIDs(t *testing.T) {
 	defer server.Close()
 
 	url := server.URL + "/float-test.json.gz"
-	targetNPIs := map[int64]struct{}{1234567890: {}}
+	opts := &mrf.SearchOptions{NPIs: map[int64]struct{}{1234567890: {}}}
 	tmpDir := t.TempDir()
 	tracker := &progress.NoopManager{}
 
 	result := RunPipeline(
 		context.Background(),
 		url,
-		targetNPIs,
+		opts,
 		tmpDir,
 		false, true,
 		tracker.NewTracker(0, 1, "float-test.json.gz"),
@@ -751,3 +1109,239 @@ func TestStreamPipelineEndToEnd_FloatIDs(t *testing.T) {
 	}
 }
 
+// TestPipelineProgress_Stages verifies the stages, counters and work dirs
+// the file-based pipeline reports, in order.
+func TestPipelineProgress_Stages(t *testing.T) {
+	server := serveGzippedMRF(t, buildTestMRF())
+	defer server.Close()
+
+	rec := &progress.RecordingManager{}
+	result := RunPipeline(context.Background(), server.URL+"/test-mrf.json.gz",
+		&mrf.SearchOptions{NPIs: map[int64]struct{}{1316924913: {}}}, t.TempDir(), true, false,
+		rec.NewTracker(0, 1, "test-mrf.json.gz"))
+	if result.Err != nil {
+		t.Fatalf("pipeline failed: %v", result.Err)
+	}
+
+	want := []string{
+		"Downloading",
+		"Waiting for split slot",
+		"Splitting",
+		"Parsing: provider_references",
+		"Parsing: in_network",
+		"Done (4 rates)",
+	}
+	if got := rec.Stages("test-mrf.json.gz"); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("expected stages %q, got %q", want, got)
+	}
+	if w := rec.Warnings("test-mrf.json.gz"); len(w) != 0 {
+		t.Errorf("expected no warnings, got %q", w)
+	}
+
+	// Two of the three provider groups include the NPI.
+	if got := rec.Counter("test-mrf.json.gz", "npi_matches"); fmt.Sprint(got) != "[2]" {
+		t.Errorf("expected npi_matches [2], got %v", got)
+	}
+	rates := rec.Counter("test-mrf.json.gz", "rates_found")
+	if len(rates) == 0 || rates[len(rates)-1] != 4 {
+		t.Errorf("expected rates_found to end at 4, got %v", rates)
+	}
+
+	// The attempt's work dir is reported, then cleared once it's removed.
+	var dirs []string
+	for _, e := range rec.FileEvents("test-mrf.json.gz", "work_dir") {
+		dirs = append(dirs, e.Message)
+	}
+	if len(dirs) != 2 || dirs[0] == "" || dirs[1] != "" {
+		t.Errorf("expected a work dir to be set then cleared, got %q", dirs)
+	}
+}
+
+// TestPipelineProgress_Retry verifies the warning and stage reported when an
+// attempt fails and is retried, using a fake clock for the backoff.
+func TestPipelineProgress_Retry(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := buildTestMRF()
+		if requests.Add(1) == 1 {
+			body = body[:len(body)/2] // a truncated document fails to parse
+		}
+		gz := gzip.NewWriter(w)
+		gz.Write([]byte(body))
+		gz.Close()
+	}))
+	defer server.Close()
+
+	fc := &fakeClock{now: time.Unix(0, 0)}
+	SetClock(fc)
+	defer SetClock(nil)
+	SetRandSource(func() float64 { return 0.5 }) // no jitter
+	defer SetRandSource(nil)
+
+	rec := &progress.RecordingManager{}
+	result := RunPipeline(context.Background(), server.URL+"/test-mrf.json.gz",
+		&mrf.SearchOptions{NPIs: map[int64]struct{}{1316924913: {}}}, t.TempDir(), true, true,
+		rec.NewTracker(0, 1, "test-mrf.json.gz"))
+	if result.Err != nil {
+		t.Fatalf("pipeline failed: %v", result.Err)
+	}
+	if len(result.Results) != 4 {
+		t.Errorf("expected 4 results after the retry, got %d", len(result.Results))
+	}
+
+	warnings := rec.Warnings("test-mrf.json.gz")
+	if len(warnings) != 1 || !strings.HasPrefix(warnings[0], "Attempt 1/3 failed: ") {
+		t.Errorf("expected one \"Attempt 1/3 failed\" warning, got %q", warnings)
+	}
+	stages := rec.Stages("test-mrf.json.gz")
+	retry := slices.Index(stages, "Retry 2/3 (waiting 2s)")
+	if retry < 0 {
+		t.Fatalf("expected a retry stage, got %q", stages)
+	}
+	if last := stages[len(stages)-1]; last != "Done (4 rates)" || retry == len(stages)-1 {
+		t.Errorf("expected the retry to end in \"Done (4 rates)\", got %q", stages)
+	}
+	if fmt.Sprint(fc.sleeps) != "[2s]" {
+		t.Errorf("expected one 2s backoff, got %v", fc.sleeps)
+	}
+}
+
+// TestPipelineFIFOStall verifies that a FIFO attempt whose download stops
+// moving is aborted by the watchdog and the file retried with the file
+// pipeline right away.
+func TestPipelineFIFOStall(t *testing.T) {
+	var buf strings.Builder
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte(buildTestMRF()))
+	gz.Close()
+	body := buf.String()
+
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead || r.Header.Get("Range") != "" {
+			http.ServeContent(w, r, "", time.Time{}, strings.NewReader(body))
+			return
+		}
+		if requests.Add(1) == 1 {
+			// Send part of the file, then hang with the connection open.
+			w.Header().Set("Content-Length", fmt.Sprint(len(body)))
+			io.WriteString(w, body[:len(body)/2])
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+			return
+		}
+		io.WriteString(w, body)
+	}))
+	defer server.Close()
+
+	SetFIFOStallTimeout(200 * time.Millisecond)
+	defer SetFIFOStallTimeout(10 * time.Minute)
+
+	rec := &progress.RecordingManager{}
+	result := RunPipeline(context.Background(), server.URL+"/test-mrf.json.gz",
+		&mrf.SearchOptions{NPIs: map[int64]struct{}{1316924913: {}}}, t.TempDir(), false, false,
+		rec.NewTracker(0, 1, "test-mrf.json.gz"))
+	if result.Err != nil {
+		t.Fatalf("pipeline failed: %v", result.Err)
+	}
+	if len(result.Results) != 4 {
+		t.Errorf("expected 4 results from the file pipeline, got %d", len(result.Results))
+	}
+
+	warnings := rec.Warnings("test-mrf.json.gz")
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "FIFO pipeline stalled") {
+		t.Errorf("expected one stall warning, got %q", warnings)
+	}
+	stages := rec.Stages("test-mrf.json.gz")
+	fifo, file := slices.Index(stages, "Downloading + Splitting"), slices.Index(stages, "Downloading (std gzip)")
+	if fifo < 0 || file < fifo {
+		t.Errorf("expected a FIFO attempt followed by a file attempt, got %q", stages)
+	}
+	if slices.ContainsFunc(stages, func(s string) bool { return strings.HasPrefix(s, "Retry") }) {
+		t.Errorf("expected no backoff before the fallback, got %q", stages)
+	}
+}
+
+// TestThrottledCounter verifies that per-record increments are coalesced and
+// that Flush publishes the exact final count.
+func TestThrottledCounter(t *testing.T) {
+	rec := &progress.RecordingManager{}
+	c := newThrottledCounter("codes_scanned", rec.NewTracker(0, 1, "f.json.gz"))
+
+	const n = 50_000
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < n/4; i++ {
+				c.Inc()
+			}
+		}()
+	}
+	wg.Wait()
+	c.Flush()
+
+	if c.Value() != n {
+		t.Errorf("expected value %d, got %d", n, c.Value())
+	}
+	// 5 forced flushes at 10k boundaries plus a handful of time-based ones.
+	values := rec.Counter("f.json.gz", "codes_scanned")
+	if len(values) > 50 {
+		t.Errorf("expected counter updates to be throttled, got %d SetCounter calls for %d increments", len(values), n)
+	}
+	if last := values[len(values)-1]; last != n {
+		t.Errorf("expected final published value %d, got %d", n, last)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	results := []PipelineResult{
+		{URL: "https://a.example.com/1.json.gz", Count: 3, CompressedBytes: 100, Duration: 2 * time.Second},
+		{URL: "https://a.example.com/2.json.gz", Err: fmt.Errorf("download: download failed after retries: %w", &AuthError{StatusCode: 403}), Duration: time.Second},
+		{URL: "https://b.example.com/3.json.gz", Err: fmt.Errorf("download: download failed after retries: %w", &AuthError{StatusCode: 403}), Duration: 5 * time.Second},
+		{URL: "https://b.example.com/4.json.gz", Err: ErrDeadline},
+		{URL: "https://a.example.com/5.json.gz", Err: ErrTooSlow, Duration: 3 * time.Second},
+	}
+	s := Summarize(results)
+
+	if len(s.Hosts) != 2 {
+		t.Fatalf("expected 2 hosts, got %+v", s.Hosts)
+	}
+	a, b := s.Hosts[0], s.Hosts[1]
+	if a.Host != "a.example.com" || a.Files != 3 || a.Succeeded != 1 || a.Failed != 2 || a.Bytes != 100 || a.Rates != 3 {
+		t.Errorf("unexpected summary for a.example.com: %+v", a)
+	}
+	if b.Host != "b.example.com" || b.Files != 2 || b.Failed != 1 || b.Skipped != 1 {
+		t.Errorf("unexpected summary for b.example.com: %+v", b)
+	}
+	if len(s.Slowest) != 4 || !strings.HasSuffix(s.Slowest[0].URL, "/3.json.gz") || s.Slowest[0].Seconds != 5 {
+		t.Errorf("expected 3.json.gz slowest of 4, got %+v", s.Slowest)
+	}
+	if len(s.Errors) != 2 || s.Errors[0].Category != "HTTP 403" || s.Errors[0].Count != 2 || s.Errors[1].Category != "too slow" {
+		t.Errorf("unexpected error categories: %+v", s.Errors)
+	}
+}
+
+func TestErrorCategory(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{fmt.Errorf("download: download failed after retries: %w", &StatusError{StatusCode: 503}), "HTTP 503"},
+		{&AuthError{StatusCode: 403, Signed: true, Expired: true}, "expired URL"},
+		{fmt.Errorf("%w: got 1 of 2 compressed bytes", errTruncated), "truncated download"},
+		{fmt.Errorf("%w: %w", errDecompress, errors.New("gzip: invalid header")), "decompression"},
+		{fmt.Errorf("%w: %w", errCorrupt, errors.New("unexpected EOF")), "decompression"},
+		{fmt.Errorf("%w in_network: %w", errParse, errors.New("bad token")), "parse"},
+		{fmt.Errorf("%w: %w", errSplit, errors.New("bad token")), "parse"},
+		{fmt.Errorf("download: %w", context.DeadlineExceeded), "timeout"},
+		// Text alone no longer decides the category.
+		{errors.New("parse error: HTTP 404 in a truncated decompress"), "other"},
+	}
+	for _, tt := range tests {
+		if got := errorCategory(tt.err); got != tt.want {
+			t.Errorf("errorCategory(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
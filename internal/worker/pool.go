@@ -2,36 +2,123 @@ package worker
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"runtime"
 	"sync"
+	"time"
 
+	"github.com/gyeh/npi-rates/internal/logx"
+	"github.com/gyeh/npi-rates/internal/mrf"
 	"github.com/gyeh/npi-rates/internal/progress"
 )
 
 // Pool manages concurrent processing of MRF files.
 type Pool struct {
-	Workers    int
-	TargetNPIs map[int64]struct{}
-	TmpDir     string
-	Progress   progress.Manager
-	NoFIFO     bool
-	Stream     bool
+	Workers  int
+	TmpDir   string
+	Progress progress.Manager
+	NoFIFO   bool
+	Stream   bool
+
+	// Search is what every file is searched for.
+	Search *mrf.SearchOptions
+
+	// TmpDirs, if set, spreads files round-robin across several temp
+	// volumes instead of using TmpDir alone.
+	TmpDirs []string
+
+	// LogDir, if set, receives a debug log per file (download attempts,
+	// response headers, retries, warnings, timing), named after the file's
+	// position in the URL list.
+	LogDir string
+
+	// Mirrors lists alternate URLs for the same logical file, keyed by the
+	// primary URL passed to Run. They are tried in order once the primary
+	// has exhausted its retries.
+	Mirrors map[string][]string
+
+	// RefreshURL, if set, is called when a URL is refused with HTTP 403, as
+	// signed CDN links are once they expire mid-run, and returns a fresh URL
+	// for the same file (e.g. by resolving the TOC again). The file is then
+	// searched again from the fresh URL, once per URL or mirror; its results
+	// name the URL they were read from. See RefreshCommand.
+	RefreshURL func(ctx context.Context, url string) (string, error)
+
+	// Checkpoint, if set, records each file that completes successfully
+	// along with its results. Files it already holds are not searched again;
+	// their recorded results are delivered as if just found.
+	Checkpoint *Checkpoint
+
+	// OnResults, if set, receives each file's results once the file has
+	// completed successfully, instead of PipelineResult.Results. Results are
+	// spooled to TmpDir while the file is processed, so memory use does not
+	// grow with the number of matches. Calls are serialized; an error fails
+	// the file.
+	OnResults func([]mrf.RateResult) error
+
+	// Deadline, if set, is when the pool stops starting files. Files already
+	// in flight run to completion; the rest fail with ErrDeadline.
+	Deadline time.Time
+
+	// Sizes, if set, holds the compressed size of each URL passed to Run, in
+	// order (0 if unknown), and has files scheduled by size instead of
+	// Workers at a time; see MaxWorkers.
+	Sizes []int64
+
+	// MaxWorkers is how many files run at once when Sizes is set. Files
+	// start largest first, and at most Workers of them may be large (see
+	// LargeFileSize) or of unknown size, so small files fill the remaining
+	// slots. Outside Stream, a file also waits until its expected
+	// decompressed size fits in what is left of a temp dir's free space.
+	MaxWorkers int
+
+	deliverMu sync.Mutex
 }
 
+// ErrDeadline is the error of a file that was not started because the
+// pool's Deadline had passed.
+var ErrDeadline = errors.New("not started before the deadline")
+
 // Run processes all URLs concurrently and returns all results.
 func (p *Pool) Run(ctx context.Context, urls []string) []PipelineResult {
 	results := make([]PipelineResult, len(urls))
 
-	p.Progress.StartDiskMonitor(p.TmpDir)
+	p.Progress.StartDiskMonitor(p.tmpDirs())
 	defer p.Progress.StopDiskMonitor()
 
 	sem := make(chan struct{}, p.Workers)
 	var wg sync.WaitGroup
 
+	// pastDeadline closes when the deadline passes; nil (never ready) if
+	// there is none.
+	var pastDeadline chan struct{}
+	if !p.Deadline.IsZero() {
+		pastDeadline = make(chan struct{})
+		waitCtx, stopWaiting := context.WithCancel(ctx)
+		defer stopWaiting()
+		go func() {
+			if clock.Sleep(waitCtx, p.Deadline.Sub(clock.Now())) == nil {
+				close(pastDeadline)
+			}
+		}()
+	}
+
+	if p.Sizes != nil {
+		p.runScheduled(ctx, urls, results, pastDeadline)
+		return results
+	}
+
 	for i, url := range urls {
 		wg.Add(1)
 		go func(idx int, u string) {
 			defer wg.Done()
 
+			if prior, ok := p.Checkpoint.completed(u); ok {
+				results[idx] = p.resume(prior)
+				return
+			}
+
 			// Acquire a semaphore slot to limit concurrency to p.Workers.
 			// If all slots are taken, this blocks until one frees up.
 			select {
@@ -41,17 +128,190 @@ func (p *Pool) Run(ctx context.Context, urls []string) []PipelineResult {
 				// Context cancelled while waiting — bail out early.
 				results[idx] = PipelineResult{URL: u, Err: ctx.Err()}
 				return
+			case <-pastDeadline:
+				results[idx] = PipelineResult{URL: u, Err: ErrDeadline}
+				return
 			}
 			// Release the semaphore slot when this goroutine finishes.
 			defer func() { <-sem }()
 
-			tracker := p.Progress.NewTracker(idx, len(urls), FileNameFromURL(u))
-			result := RunPipeline(ctx, u, p.TargetNPIs, p.TmpDir, p.NoFIFO, p.Stream, tracker)
-			results[idx] = *result
-			tracker.Done()
+			results[idx] = p.runFile(ctx, idx, len(urls), u, p.tmpDirFor(idx), pastDeadline)
 		}(i, url)
 	}
 
 	wg.Wait()
 	return results
 }
+
+// runFile searches the idx-th of total URLs, using tmpDir, and delivers its
+// results. A file whose slot came after the deadline is not started.
+func (p *Pool) runFile(ctx context.Context, idx, total int, u, tmpDir string, pastDeadline <-chan struct{}) PipelineResult {
+	// The deadline may have passed while the slot was being acquired.
+	select {
+	case <-pastDeadline:
+		return PipelineResult{URL: u, Err: ErrDeadline}
+	default:
+	}
+
+	tracker := p.Progress.NewTracker(idx, total, FileNameFromURL(u))
+	fileCtx, tracker, finishLog := p.withFileLog(ctx, idx, u, tracker)
+	start := clock.Now()
+	result := p.runIsolated(fileCtx, u, tmpDir, tracker)
+	result.Duration = clock.Now().Sub(start)
+	if result.Err == nil {
+		p.deliverMu.Lock()
+		result.Err = p.deliver(u, result)
+		p.deliverMu.Unlock()
+	}
+	finishLog(result)
+	tracker.Done()
+	return *result
+}
+
+// deliver hands a successful file's spooled results to OnResults and
+// records the file in the checkpoint. Callers hold deliverMu, which keeps
+// each file's checkpoint record contiguous.
+func (p *Pool) deliver(url string, result *PipelineResult) error {
+	fn := p.OnResults
+	if p.Checkpoint != nil {
+		fn = func(batch []mrf.RateResult) error {
+			if p.OnResults != nil {
+				if err := p.OnResults(batch); err != nil {
+					return err
+				}
+			}
+			return p.Checkpoint.add(url, batch)
+		}
+	}
+	if result.spool != nil {
+		err := result.spool.replay(fn)
+		result.spool = nil
+		if err != nil {
+			return fmt.Errorf("writing results: %w", err)
+		}
+	} else if p.Checkpoint != nil {
+		if err := p.Checkpoint.add(url, result.Results); err != nil {
+			return err
+		}
+	}
+	if p.Checkpoint != nil {
+		return p.Checkpoint.finish(url, result)
+	}
+	return nil
+}
+
+// resume returns the checkpointed result of a file completed by an earlier
+// run, delivering its recorded rates to OnResults or, without OnResults,
+// returning them in Results.
+func (p *Pool) resume(prior PipelineResult) PipelineResult {
+	p.deliverMu.Lock()
+	defer p.deliverMu.Unlock()
+	err := p.Checkpoint.replay(prior.URL, func(batch []mrf.RateResult) error {
+		if p.OnResults != nil {
+			return p.OnResults(batch)
+		}
+		prior.Results = append(prior.Results, batch...)
+		return nil
+	})
+	if err != nil {
+		prior.Err = fmt.Errorf("resuming from checkpoint: %w", err)
+	}
+	return prior
+}
+
+// runIsolated is runWithMirrors with any panic in the file's pipeline
+// turned into the file's error, so one malformed file can't take down the
+// whole run.
+func (p *Pool) runIsolated(ctx context.Context, url, tmpDir string, tracker progress.Tracker) (result *PipelineResult) {
+	defer func() {
+		if isPanic(result.Err) {
+			tracker.LogWarning(fmt.Sprintf("Recovered from %v (stack trace in the error report)", result.Err))
+		}
+	}()
+	var err error
+	defer func() {
+		if err != nil {
+			result = &PipelineResult{URL: url, Err: err}
+		}
+	}()
+	defer mrf.RecoverPanic(&err)
+	return p.runWithMirrors(ctx, url, tmpDir, tracker)
+}
+
+// runWithMirrors runs the pipeline against url and, if it fails for a reason
+// other than cancellation, a full disk or a panic, against each of its
+// mirrors in turn.
+// The returned result is always reported under the primary URL, with the URL
+// it was read from in SourceURL.
+func (p *Pool) runWithMirrors(ctx context.Context, url, tmpDir string, tracker progress.Tracker) *PipelineResult {
+	var spoolDir string
+	if p.OnResults != nil {
+		spoolDir = tmpDir
+	}
+	result := p.runRefreshing(ctx, url, tmpDir, spoolDir, tracker)
+	for _, mirror := range p.Mirrors[url] {
+		if result.Err == nil || ctx.Err() != nil || isDiskFullError(result.Err) || isPanic(result.Err) {
+			break
+		}
+		tracker.LogWarning(fmt.Sprintf("Failing over to mirror %s: %v", mirror, result.Err))
+		result = p.runRefreshing(ctx, mirror, tmpDir, spoolDir, tracker)
+	}
+	result.SourceURL, result.URL = result.URL, url
+	return result
+}
+
+// runRefreshing runs the pipeline against url and, if the server refuses
+// it with HTTP 403 and the pool has a RefreshURL, once more against the
+// URL that returns.
+func (p *Pool) runRefreshing(ctx context.Context, url, tmpDir, spoolDir string, tracker progress.Tracker) *PipelineResult {
+	result := runPipeline(ctx, url, p.Search, tmpDir, p.NoFIFO, p.Stream, spoolDir, tracker)
+	if p.RefreshURL == nil || !isForbidden(result.Err) || ctx.Err() != nil {
+		return result
+	}
+	tracker.SetStage("Refreshing URL")
+	fresh, err := p.RefreshURL(ctx, url)
+	if err != nil {
+		tracker.LogWarning(fmt.Sprintf("Could not refresh the URL after %v: %v", result.Err, err))
+		return result
+	}
+	if fresh == url {
+		tracker.LogWarning(fmt.Sprintf("URL refresh returned the same URL after %v", result.Err))
+		return result
+	}
+	tracker.LogWarning(fmt.Sprintf("Retrying with a refreshed URL after %v", result.Err))
+	logx.Debugf(ctx, "%s: refreshed to %s", FileNameFromURL(url), FileNameFromURL(fresh))
+	return runPipeline(ctx, fresh, p.Search, tmpDir, p.NoFIFO, p.Stream, spoolDir, tracker)
+}
+
+// tmpDirs returns every temp directory the pool writes to.
+func (p *Pool) tmpDirs() []string {
+	if len(p.TmpDirs) > 0 {
+		return p.TmpDirs
+	}
+	return []string{p.TmpDir}
+}
+
+// tmpDirFor returns the temp directory for the idx-th URL.
+func (p *Pool) tmpDirFor(idx int) string {
+	dirs := p.tmpDirs()
+	return dirs[idx%len(dirs)]
+}
+
+// DefaultParseParallelism splits GOMAXPROCS across the files that will be
+// parsed concurrently, so that workers × per-file fan-out roughly matches
+// the available CPUs instead of oversubscribing them by a factor of workers.
+func DefaultParseParallelism(workers, files int) int {
+	concurrent := workers
+	if files < concurrent {
+		concurrent = files
+	}
+	if concurrent < 1 {
+		concurrent = 1
+	}
+	procs := runtime.GOMAXPROCS(0)
+	n := (procs + concurrent - 1) / concurrent
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
@@ -0,0 +1,49 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// refreshTimeout bounds a --url-refresh-cmd run, which may re-fetch a TOC.
+const refreshTimeout = 5 * time.Minute
+
+// RefreshCommand returns a Pool.RefreshURL that runs command with sh -c to
+// get a fresh URL for the file at an expired one. The expired URL is the
+// command's $1 and $NPI_RATES_URL; the last non-empty line it prints is
+// taken as the fresh URL. Its stderr goes to ours.
+func RefreshCommand(command string) func(ctx context.Context, rawURL string) (string, error) {
+	return func(ctx context.Context, rawURL string) (string, error) {
+		ctx, cancel := context.WithTimeout(ctx, refreshTimeout)
+		defer cancel()
+		cmd := exec.CommandContext(ctx, "sh", "-c", command, "sh", rawURL)
+		cmd.Env = append(os.Environ(), "NPI_RATES_URL="+rawURL)
+		cmd.Stderr = os.Stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("url refresh command: %w", err)
+		}
+		lines := strings.Split(strings.TrimSpace(string(bytes.ReplaceAll(out, []byte("\r"), nil))), "\n")
+		fresh := strings.TrimSpace(lines[len(lines)-1])
+		u, err := url.Parse(fresh)
+		if fresh == "" || err != nil || u.Host == "" {
+			return "", fmt.Errorf("url refresh command printed %q, not a URL", fresh)
+		}
+		return fresh, nil
+	}
+}
+
+// isForbidden reports whether err is a download refused with HTTP 403, the
+// status signed CDN links answer with once they expire.
+func isForbidden(err error) bool {
+	var ae *AuthError
+	return errors.As(err, &ae) && ae.StatusCode == http.StatusForbidden
+}
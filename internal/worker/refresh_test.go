@@ -0,0 +1,132 @@
+package worker
+
+import (
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gyeh/npi-rates/internal/mrf"
+	"github.com/gyeh/npi-rates/internal/progress"
+)
+
+// TestPoolRefreshURL verifies that a file refused with 403 is searched again
+// from the URL RefreshURL returns, and that without it the file fails.
+func TestPoolRefreshURL(t *testing.T) {
+	mrfJSON := buildTestMRF()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("sig") == "old" {
+			http.Error(w, "Request has expired", http.StatusForbidden)
+			return
+		}
+		gz := gzip.NewWriter(w)
+		gz.Write([]byte(mrfJSON))
+		gz.Close()
+	}))
+	defer server.Close()
+	url := server.URL + "/in-network.json.gz?sig=old"
+
+	var refreshed []string
+	pool := &Pool{
+		Workers:  1,
+		Search:   &mrf.SearchOptions{NPIs: map[int64]struct{}{1316924913: {}}},
+		TmpDir:   t.TempDir(),
+		Progress: &progress.NoopManager{},
+		Stream:   true,
+		RefreshURL: func(ctx context.Context, u string) (string, error) {
+			refreshed = append(refreshed, u)
+			return strings.Replace(u, "sig=old", "sig=new", 1), nil
+		},
+	}
+	results := pool.Run(context.Background(), []string{url})
+	if r := results[0]; r.Err != nil || len(r.Results) != 4 {
+		t.Fatalf("got %d results, err %v; want 4 results", len(r.Results), r.Err)
+	}
+	if len(refreshed) != 1 || refreshed[0] != url {
+		t.Errorf("RefreshURL called with %q, want [%s]", refreshed, url)
+	}
+	if got := results[0].URL; got != url {
+		t.Errorf("result URL = %s, want the original %s", got, url)
+	}
+	fresh := strings.Replace(url, "sig=old", "sig=new", 1)
+	if got := results[0].SourceURL; got != fresh {
+		t.Errorf("SourceURL = %s, want the refreshed %s", got, fresh)
+	}
+	for _, r := range results[0].Results {
+		if r.SourceFile != fresh {
+			t.Errorf("result SourceFile = %s, want the refreshed %s", r.SourceFile, fresh)
+		}
+	}
+
+	pool.RefreshURL = nil
+	results = pool.Run(context.Background(), []string{url})
+	if !isForbidden(results[0].Err) {
+		t.Errorf("without RefreshURL: err = %v, want HTTP 403", results[0].Err)
+	}
+}
+
+func TestRefreshCommand(t *testing.T) {
+	refresh := RefreshCommand(`echo resolving "$1" >&2; echo; echo "${NPI_RATES_URL%old}new"`)
+	got, err := refresh(context.Background(), "https://cdn.example.com/f.json.gz?sig=old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "https://cdn.example.com/f.json.gz?sig=new"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if _, err := RefreshCommand("echo not a url")(context.Background(), "https://x/y"); err == nil {
+		t.Error("expected an error for output that isn't a URL")
+	}
+	if _, err := RefreshCommand("exit 3")(context.Background(), "https://x/y"); err == nil {
+		t.Error("expected an error for a failing command")
+	}
+}
+
+// TestPoolMirrors verifies that a file whose URL fails is searched from its
+// mirror, reported under the primary URL with the mirror in SourceURL and in
+// every result's SourceFile, and that the checkpoint keeps the mirror.
+func TestPoolMirrors(t *testing.T) {
+	server := serveGzippedMRF(t, buildTestMRF())
+	defer server.Close()
+	// A refused URL fails at once, without the pipeline's retries.
+	refused := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Access Denied", http.StatusForbidden)
+	}))
+	defer refused.Close()
+	primary := refused.URL + "/in-network.json.gz"
+	mirror := server.URL + "/in-network.json.gz"
+
+	ckptPath := filepath.Join(t.TempDir(), "ckpt.ndjson")
+	for run := 0; run < 2; run++ {
+		ckpt, err := OpenCheckpoint(ckptPath, "search")
+		if err != nil {
+			t.Fatal(err)
+		}
+		pool := &Pool{
+			Workers:    1,
+			Search:     &mrf.SearchOptions{NPIs: map[int64]struct{}{1316924913: {}}},
+			TmpDir:     t.TempDir(),
+			Progress:   &progress.NoopManager{},
+			Stream:     true,
+			Mirrors:    map[string][]string{primary: {mirror}},
+			Checkpoint: ckpt,
+		}
+		r := pool.Run(context.Background(), []string{primary})[0]
+		ckpt.Close()
+		if r.Err != nil || len(r.Results) != 4 {
+			t.Fatalf("run %d: got %d results, err %v; want 4 results", run, len(r.Results), r.Err)
+		}
+		if r.URL != primary || r.SourceURL != mirror {
+			t.Errorf("run %d: URL %s, SourceURL %s; want %s read from %s", run, r.URL, r.SourceURL, primary, mirror)
+		}
+		for _, res := range r.Results {
+			if res.SourceFile != mirror {
+				t.Errorf("run %d: result SourceFile = %s, want %s", run, res.SourceFile, mirror)
+			}
+		}
+	}
+}
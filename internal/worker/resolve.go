@@ -0,0 +1,80 @@
+package worker
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// pinned holds the addresses set by SetResolve, keyed by "host:port", or
+// "host:" for any port.
+var pinned struct {
+	mu    sync.RWMutex
+	addrs map[string][]net.IPAddr
+}
+
+// SetResolve pins hosts to addresses, like curl's --resolve, so a payer
+// endpoint whose DNS hands out a broken edge can be sent to a known-good
+// one without editing /etc/hosts. Each spec is "host:addr" for every port
+// or "host:port:addr", with several addresses separated by commas and IPv6
+// ones in brackets. Connections to a pinned host race its addresses as they
+// would resolved ones; TLS still verifies the certificate against the host
+// name.
+//
+// The pins apply to the connections this program makes: the httpx clients
+// (downloads, probes, NPPES lookups, and the object store SDKs' requests)
+// and Dial. Connections already pooled keep their address.
+func SetResolve(specs []string) error {
+	addrs := make(map[string][]net.IPAddr, len(specs))
+	for _, spec := range specs {
+		key, ips, err := parseResolve(spec)
+		if err != nil {
+			return err
+		}
+		addrs[key] = ips
+	}
+	pinned.mu.Lock()
+	pinned.addrs = addrs
+	pinned.mu.Unlock()
+	return nil
+}
+
+// parseResolve parses a SetResolve spec into its key and addresses.
+func parseResolve(spec string) (string, []net.IPAddr, error) {
+	host, rest, ok := strings.Cut(spec, ":")
+	if !ok || host == "" {
+		return "", nil, fmt.Errorf("--resolve %q: expected host:addr or host:port:addr", spec)
+	}
+	port := ""
+	if p, list, ok := strings.Cut(rest, ":"); ok && !strings.HasPrefix(rest, "[") {
+		if n, err := strconv.Atoi(p); err == nil && n > 0 && n < 1<<16 {
+			port, rest = p, list
+		}
+	}
+	var ips []net.IPAddr
+	for _, a := range strings.Split(rest, ",") {
+		a = strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(a), "["), "]")
+		ip := net.ParseIP(a)
+		if ip == nil {
+			return "", nil, fmt.Errorf("--resolve %q: %q is not an IP address", spec, a)
+		}
+		ips = append(ips, net.IPAddr{IP: ip})
+	}
+	return strings.ToLower(host) + ":" + port, ips, nil
+}
+
+// pinnedAddrs returns the addresses host is pinned to for port, or nil.
+func pinnedAddrs(host, port string) []net.IPAddr {
+	pinned.mu.RLock()
+	defer pinned.mu.RUnlock()
+	if pinned.addrs == nil {
+		return nil
+	}
+	host = strings.ToLower(host)
+	if ips, ok := pinned.addrs[host+":"+port]; ok {
+		return ips
+	}
+	return pinned.addrs[host+":"]
+}
@@ -0,0 +1,226 @@
+package worker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gyeh/npi-rates/internal/humanize"
+	"github.com/gyeh/npi-rates/internal/logx"
+)
+
+// maxResumes bounds how many times one fetch resumes an interrupted
+// download before giving up and leaving it to the pipeline's retry.
+const maxResumes = 5
+
+// partialDownload is a compressed download kept on disk across the
+// file-based pipeline's attempts, so that a broken transfer is resumed with
+// a Range request instead of starting over. A 50 GB file that breaks at 45 GB
+// then costs 5 GB to finish, not another 50.
+type partialDownload struct {
+	dir       string
+	url       string
+	path      string // "" until a response says which version is downloaded
+	validator string // strong ETag or Last-Modified of the response being resumed
+	total     int64  // full compressed size, -1 if unknown
+	complete  bool
+}
+
+// newPartialDownload returns the partial download for url in tmpDir. Its
+// file is named once the first response arrives, after the URL and the
+// version the server reports, so each file and version has its own.
+func newPartialDownload(tmpDir, url string) *partialDownload {
+	return &partialDownload{dir: tmpDir, url: url, total: -1}
+}
+
+// partPath returns the file holding the download of url's version with the
+// given validator and total size.
+func partPath(dir, url, validator string, total int64) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%s\x00%d", url, validator, total)))
+	return filepath.Join(dir, "dl-"+hex.EncodeToString(sum[:8])+".part")
+}
+
+// size returns the number of bytes on disk.
+func (p *partialDownload) size() int64 {
+	if p.path == "" {
+		return 0
+	}
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// discard removes the bytes on disk so the next fetch starts over.
+func (p *partialDownload) discard() {
+	if p.path != "" {
+		os.Remove(p.path)
+	}
+	*p = partialDownload{dir: p.dir, url: p.url, total: -1}
+}
+
+// fetch completes the download, resuming it after mid-stream failures as
+// long as each try makes progress. onProgress sees the bytes on disk,
+// including those from earlier tries.
+func (p *partialDownload) fetch(ctx context.Context, url string, onProgress func(downloaded, total int64)) error {
+	if p.complete {
+		return nil
+	}
+	for resumes := 0; ; resumes++ {
+		before := p.size()
+		err := p.fetchOnce(ctx, url, onProgress)
+		if err == nil {
+			p.complete = true
+			return nil
+		}
+		after := p.size()
+		if ctx.Err() != nil || resumes >= maxResumes || p.validator == "" || after <= before {
+			return err
+		}
+		logx.Debugf(ctx, "%s: download interrupted at %s, resuming: %v",
+			FileNameFromURL(url), humanize.Bytes(uint64(after)), err)
+	}
+}
+
+// fetchOnce makes one request for the missing bytes and appends them.
+func (p *partialDownload) fetchOnce(ctx context.Context, url string, onProgress func(downloaded, total int64)) error {
+	offset := p.size()
+	if p.validator == "" || (p.total >= 0 && offset > p.total) {
+		offset = 0 // nothing to check the bytes on disk against
+	}
+	resp, err := openDownloadRange(ctx, url, offset, p.validator)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if resp.StatusCode != http.StatusPartialContent {
+		if offset > 0 {
+			logx.Debugf(ctx, "%s: server sent the whole file instead of resuming at %s",
+				FileNameFromURL(url), humanize.Bytes(uint64(offset)))
+		}
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		offset = 0
+	}
+	validator := responseValidator(resp)
+	total := resumedTotal(resp, offset)
+	if offset > 0 {
+		// If-Range held, so the validator is the same; the size must be too.
+		if p.total >= 0 && total != p.total {
+			p.discard()
+			return fmt.Errorf("server resumed a %d-byte download as %d bytes; starting over", p.total, total)
+		}
+	} else if path := partPath(p.dir, url, validator, total); path != p.path {
+		if p.path != "" {
+			os.Remove(p.path) // bytes of a version the server no longer has
+		}
+		p.path = path
+	}
+	p.validator = validator
+	p.total = total
+
+	f, err := os.OpenFile(p.path, flags, 0o600)
+	if err != nil {
+		return fmt.Errorf("opening partial download: %w", err)
+	}
+	var reader io.Reader = resp.Body
+	if onProgress != nil {
+		reader = &progressReader{
+			reader:     resp.Body,
+			downloaded: offset,
+			total:      p.total,
+			callback:   onProgress,
+			url:        url,
+		}
+	}
+	n, err := io.Copy(f, reader)
+	if closeErr := f.Close(); closeErr != nil && err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("writing partial download: %w", err)
+	}
+	if p.total >= 0 && offset+n != p.total {
+		return fmt.Errorf("%w: got %d of %d compressed bytes", errTruncated, offset+n, p.total)
+	}
+	return nil
+}
+
+// resumedTotal returns the full size of the file resp is part of, starting
+// at offset, or -1 if unknown.
+func resumedTotal(resp *http.Response, offset int64) int64 {
+	if resp.StatusCode == http.StatusPartialContent {
+		// Content-Range: bytes 100-199/1000
+		cr := resp.Header.Get("Content-Range")
+		if i := strings.LastIndexByte(cr, '/'); i >= 0 {
+			if n, err := strconv.ParseInt(cr[i+1:], 10, 64); err == nil {
+				return n
+			}
+		}
+	}
+	if resp.ContentLength < 0 {
+		return -1
+	}
+	return offset + resp.ContentLength
+}
+
+// responseValidator returns the value to send in If-Range when resuming
+// resp's body: its ETag if strong (weak ETags can't be used with ranges),
+// else its Last-Modified date, else "" if the response can't be resumed.
+func responseValidator(resp *http.Response) string {
+	if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return resp.Header.Get("Last-Modified")
+}
+
+// downloadAndDecompressResumable is DownloadAndDecompress for the file-based
+// pipeline: the compressed file is fetched into part, resuming whatever an
+// earlier attempt left there, and decompressed from disk. A download that
+// fails to decompress with the standard library's gzip is discarded, since
+// its bytes must be what is corrupt; after a pgzip failure it is kept for
+// the next attempt, which uses compress/gzip.
+func downloadAndDecompressResumable(ctx context.Context, url string, part *partialDownload, tmpDir string, useStdGzip bool, onProgress func(downloaded, total int64)) (*DownloadResult, error) {
+	if err := part.fetch(ctx, url, onProgress); err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(part.path)
+	if err != nil {
+		return nil, fmt.Errorf("opening download: %w", err)
+	}
+	defer f.Close()
+	countReader := &countingReader{reader: f}
+
+	path, written, err := decompressToTemp(countReader, tmpDir, useStdGzip)
+	if err == nil {
+		if err = verifyJSONBrackets(path); err != nil {
+			os.Remove(path)
+			err = fmt.Errorf("%w: %w", errCorrupt, err)
+		}
+	}
+	if err != nil {
+		if useStdGzip {
+			part.discard()
+		}
+		return nil, err
+	}
+	downloadSizes.record(url, countReader.n)
+	downloadSizes.recordRatio(countReader.n, written)
+
+	return &DownloadResult{
+		FilePath:          path,
+		TotalBytes:        part.total,
+		CompressedBytes:   countReader.n,
+		DecompressedBytes: written,
+	}, nil
+}
@@ -0,0 +1,58 @@
+package worker
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// rewriteRule is one SetRewrites rule.
+type rewriteRule struct {
+	re   *regexp.Regexp
+	repl string
+}
+
+// rewrites holds the rules set by SetRewrites.
+var rewrites struct {
+	mu    sync.RWMutex
+	rules []rewriteRule
+}
+
+// SetRewrites sets rules that rewrite MRF URLs just before they are
+// fetched, for TOCs that list internal hostnames, plain http:// links, or
+// files better read through a caching proxy. Each spec is
+// "REGEX => REPLACEMENT", with REPLACEMENT able to use $1 or ${name} for
+// the regexp's groups. Rules apply in order, each to the output of the one
+// before. Only the request changes: results, checkpoints, logs and the
+// download cache keep naming the URL as listed.
+func SetRewrites(specs []string) error {
+	rules := make([]rewriteRule, 0, len(specs))
+	for _, spec := range specs {
+		pattern, repl, ok := strings.Cut(spec, "=>")
+		pattern, repl = strings.TrimSpace(pattern), strings.TrimSpace(repl)
+		if !ok || pattern == "" {
+			return fmt.Errorf("--rewrite-url %q: expected REGEX => REPLACEMENT", spec)
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("--rewrite-url %q: %w", spec, err)
+		}
+		rules = append(rules, rewriteRule{re: re, repl: repl})
+	}
+	rewrites.mu.Lock()
+	rewrites.rules = rules
+	rewrites.mu.Unlock()
+	return nil
+}
+
+// RewriteURL returns the URL to fetch for rawURL under the SetRewrites
+// rules; rawURL itself if none match.
+func RewriteURL(rawURL string) string {
+	rewrites.mu.RLock()
+	defer rewrites.mu.RUnlock()
+	for _, r := range rewrites.rules {
+		rawURL = r.re.ReplaceAllString(rawURL, r.repl)
+	}
+	return rawURL
+}
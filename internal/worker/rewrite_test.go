@@ -0,0 +1,67 @@
+package worker
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gyeh/npi-rates/internal/mrf"
+	"github.com/gyeh/npi-rates/internal/progress"
+)
+
+func TestRewriteURL(t *testing.T) {
+	t.Cleanup(func() { SetRewrites(nil) })
+	err := SetRewrites([]string{
+		`^http://mrf\.internal\.payer\.com/ => https://mrf.payer.com/`,
+		`^https://(.*)$ => http://proxy.local:3128/${1}`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct{ in, want string }{
+		{"http://mrf.internal.payer.com/a.json.gz", "http://proxy.local:3128/mrf.payer.com/a.json.gz"},
+		{"https://cdn.example.com/b.json.gz?sig=x", "http://proxy.local:3128/cdn.example.com/b.json.gz?sig=x"},
+		{"s3://bucket/c.json.gz", "s3://bucket/c.json.gz"},
+	}
+	for _, tt := range tests {
+		if got := RewriteURL(tt.in); got != tt.want {
+			t.Errorf("RewriteURL(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"no arrow", " => x", "([ => x"} {
+		if err := SetRewrites([]string{bad}); err == nil {
+			t.Errorf("SetRewrites(%q): expected an error", bad)
+		}
+	}
+}
+
+// TestPoolRewriteURL verifies that a rewritten URL is fetched from its new
+// location while results name the URL as listed.
+func TestPoolRewriteURL(t *testing.T) {
+	server := serveGzippedMRF(t, buildTestMRF())
+	defer server.Close()
+	t.Cleanup(func() { SetRewrites(nil) })
+	if err := SetRewrites([]string{`^http://mrf\.invalid/ => ` + server.URL + "/"}); err != nil {
+		t.Fatal(err)
+	}
+
+	listed := "http://mrf.invalid/in-network.json.gz"
+	for _, stream := range []bool{false, true} {
+		pool := &Pool{
+			Workers:  1,
+			Search:   &mrf.SearchOptions{NPIs: map[int64]struct{}{1316924913: {}}},
+			TmpDir:   t.TempDir(),
+			Progress: &progress.NoopManager{},
+			Stream:   stream,
+		}
+		r := pool.Run(context.Background(), []string{listed})[0]
+		if r.Err != nil || len(r.Results) != 4 {
+			t.Fatalf("stream=%v: got %d results, err %v; want 4 results", stream, len(r.Results), r.Err)
+		}
+		for _, rate := range r.Results {
+			if rate.SourceFile != listed {
+				t.Fatalf("stream=%v: source_file = %s, want %s", stream, rate.SourceFile, listed)
+			}
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package worker
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gyeh/npi-rates/internal/humanize"
+	"github.com/gyeh/npi-rates/internal/logx"
+)
+
+// LargeFileSize is the compressed size from which a file counts as large
+// when the pool schedules by size (see Pool.MaxWorkers).
+const LargeFileSize = 1 << 30
+
+// diskHeadroom is the fraction of a temp dir's free space the scheduler
+// leaves unplanned, for spools, logs and estimates that come out low.
+const diskHeadroom = 0.1
+
+// scheduler decides which files a pool starts when it schedules by size.
+// Waiting files are considered largest first. One held back only by the
+// limit on large files lets smaller ones past it; one held back by disk
+// space or MaxWorkers holds back everything after it too, so that a large
+// file is not starved by a stream of small ones taking the space it waits
+// for.
+type scheduler struct {
+	p     *Pool
+	urls  []string
+	dirs  []string
+	order []int // URL indexes still to start, largest first
+
+	mu      sync.Mutex
+	running int
+	large   int
+	free    []int64 // per dir; < 0 when unknown (not limited)
+	wake    chan struct{}
+}
+
+// slot is a started file's share of the scheduler's limits.
+type slot struct {
+	idx       int
+	dir       int
+	large     bool
+	footprint int64
+}
+
+func newScheduler(p *Pool, urls []string, order []int) *scheduler {
+	s := &scheduler{p: p, urls: urls, dirs: p.tmpDirs(), wake: make(chan struct{})}
+	s.free = make([]int64, len(s.dirs))
+	for i, dir := range s.dirs {
+		s.free[i] = -1
+		if !p.Stream {
+			if avail := AvailableSpace(dir); avail > 0 {
+				s.free[i] = int64(float64(avail) * (1 - diskHeadroom))
+			}
+		}
+	}
+	s.order = order
+	sort.SliceStable(s.order, func(i, j int) bool {
+		a, b := p.Sizes[s.order[i]], p.Sizes[s.order[j]]
+		if (a <= 0) != (b <= 0) {
+			return a > 0 // unknown sizes last
+		}
+		return a > b
+	})
+	return s
+}
+
+// footprint estimates the temp space searching the idx-th URL takes: its
+// decompressed size, which the split output roughly equals. Streamed files
+// and files of unknown size are counted as taking none.
+func (s *scheduler) footprint(idx int) int64 {
+	size := s.p.Sizes[idx]
+	if s.p.Stream || size <= 0 {
+		return 0
+	}
+	if strings.HasSuffix(strings.ToLower(FileNameFromURL(s.urls[idx])), ".gz") {
+		return int64(float64(size) * downloadSizes.ratio())
+	}
+	return size
+}
+
+// next removes and returns the files that may start now.
+func (s *scheduler) next() []slot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var started []slot
+	kept := s.order[:0]
+	blocked := false
+	for _, idx := range s.order {
+		if blocked || s.running >= max(s.p.MaxWorkers, s.p.Workers, 1) {
+			blocked = true
+			kept = append(kept, idx)
+			continue
+		}
+		size := s.p.Sizes[idx]
+		large := size <= 0 || size >= LargeFileSize
+		if large && s.large >= max(s.p.Workers, 1) {
+			kept = append(kept, idx)
+			continue
+		}
+		need := s.footprint(idx)
+		dir := s.pickDir(idx, need)
+		if dir < 0 {
+			if s.running > 0 {
+				blocked = true
+				kept = append(kept, idx)
+				continue
+			}
+			// Nothing running will free space: start it in the roomiest
+			// dir and let the pipeline's own size check warn.
+			dir = s.roomiest()
+		}
+		s.running++
+		if large {
+			s.large++
+		}
+		if s.free[dir] >= 0 {
+			s.free[dir] -= need
+		}
+		started = append(started, slot{idx: idx, dir: dir, large: large, footprint: need})
+	}
+	s.order = kept
+	return started
+}
+
+// pickDir returns the dir with room for need that has the most room, or
+// -1. Dirs of unknown free space always have room; among those, the URL's
+// round-robin dir is used.
+func (s *scheduler) pickDir(idx int, need int64) int {
+	if rr := idx % len(s.dirs); s.free[rr] < 0 {
+		return rr
+	}
+	best := -1
+	for i, free := range s.free {
+		if free < 0 {
+			return i
+		}
+		if free >= need && (best < 0 || free > s.free[best]) {
+			best = i
+		}
+	}
+	return best
+}
+
+func (s *scheduler) roomiest() int {
+	best := 0
+	for i, free := range s.free {
+		if free > s.free[best] {
+			best = i
+		}
+	}
+	return best
+}
+
+// done returns a finished file's slot and wakes the dispatcher.
+func (s *scheduler) done(sl slot) {
+	s.mu.Lock()
+	s.running--
+	if sl.large {
+		s.large--
+	}
+	if s.free[sl.dir] >= 0 {
+		s.free[sl.dir] += sl.footprint
+	}
+	close(s.wake)
+	s.wake = make(chan struct{})
+	s.mu.Unlock()
+}
+
+func (s *scheduler) waitChan() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.wake
+}
+
+// runScheduled is Run for a pool with Sizes: files start as the scheduler
+// allows rather than Workers at a time.
+func (p *Pool) runScheduled(ctx context.Context, urls []string, results []PipelineResult, pastDeadline <-chan struct{}) {
+	var order []int
+	for i, u := range urls {
+		if prior, ok := p.Checkpoint.completed(u); ok {
+			results[i] = p.resume(prior)
+			continue
+		}
+		order = append(order, i)
+	}
+	s := newScheduler(p, urls, order)
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		wake := s.waitChan()
+		for _, sl := range s.next() {
+			logx.Debugf(ctx, "%s: starting in %s (%s compressed, ~%s on disk)", FileNameFromURL(urls[sl.idx]),
+				s.dirs[sl.dir], humanize.Bytes(uint64(max(p.Sizes[sl.idx], 0))), humanize.Bytes(uint64(sl.footprint)))
+			wg.Add(1)
+			go func(sl slot) {
+				defer wg.Done()
+				defer s.done(sl)
+				results[sl.idx] = p.runFile(ctx, sl.idx, len(urls), urls[sl.idx], s.dirs[sl.dir], pastDeadline)
+			}(sl)
+		}
+		if len(s.order) == 0 {
+			return
+		}
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			s.fail(results, ctx.Err())
+			return
+		case <-pastDeadline:
+			s.fail(results, ErrDeadline)
+			return
+		}
+	}
+}
+
+// fail gives every file not yet started err.
+func (s *scheduler) fail(results []PipelineResult, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, idx := range s.order {
+		results[idx] = PipelineResult{URL: s.urls[idx], Err: err}
+	}
+	s.order = nil
+}
@@ -0,0 +1,100 @@
+package worker
+
+import (
+	"net/url"
+	"sync"
+)
+
+// defaultCompressionRatio is the decompressed/compressed ratio assumed before
+// any file has completed. MRF JSON typically compresses 8-15x.
+const defaultCompressionRatio = 10
+
+// sizeHistory remembers the compressed sizes of files that downloaded
+// successfully, per host. Payers that serve chunked responses without a
+// Content-Length usually publish many files of similar size from the same
+// host, so earlier files in a run give a usable estimate for later ones.
+// It also accumulates compression ratios for ISIZE resolution.
+type sizeHistory struct {
+	mu    sync.Mutex
+	hosts map[string]*hostSizes
+
+	compressed   int64
+	decompressed int64
+}
+
+type hostSizes struct {
+	files int64
+	bytes int64
+}
+
+var downloadSizes = &sizeHistory{hosts: make(map[string]*hostSizes)}
+
+// record adds a completed download of n compressed bytes from rawURL's host.
+func (h *sizeHistory) record(rawURL string, n int64) {
+	if n <= 0 {
+		return
+	}
+	host := hostOf(rawURL)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.hosts[host]
+	if s == nil {
+		s = &hostSizes{}
+		h.hosts[host] = s
+	}
+	s.files++
+	s.bytes += n
+}
+
+// estimate returns an expected total for a download from rawURL's host that
+// has received downloaded bytes so far, or -1 when there is no history. Once
+// a file outgrows the host average, the estimate stays 10% ahead of it so the
+// progress bar keeps moving without reaching 100% early.
+func (h *sizeHistory) estimate(rawURL string, downloaded int64) int64 {
+	host := hostOf(rawURL)
+	h.mu.Lock()
+	s := h.hosts[host]
+	var avg int64 = -1
+	if s != nil {
+		avg = s.bytes / s.files
+	}
+	h.mu.Unlock()
+
+	if avg <= 0 {
+		return -1
+	}
+	if floor := downloaded + downloaded/10; floor > avg {
+		return floor
+	}
+	return avg
+}
+
+// recordRatio adds a fully decompressed file to the compression history.
+func (h *sizeHistory) recordRatio(compressed, decompressed int64) {
+	if compressed <= 0 || decompressed <= 0 {
+		return
+	}
+	h.mu.Lock()
+	h.compressed += compressed
+	h.decompressed += decompressed
+	h.mu.Unlock()
+}
+
+// ratio returns the byte-weighted decompressed/compressed ratio over all
+// completed files, or defaultCompressionRatio when there are none.
+func (h *sizeHistory) ratio() float64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.compressed == 0 {
+		return defaultCompressionRatio
+	}
+	return float64(h.decompressed) / float64(h.compressed)
+}
+
+func hostOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
@@ -0,0 +1,46 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gyeh/npi-rates/internal/httpx"
+	"github.com/gyeh/npi-rates/internal/objstore"
+)
+
+// OpenSlice returns the first n compressed bytes of url, using a Range
+// request so the rest of the file is never sent. A server that ignores the
+// range is cut off after n bytes. The caller must close the result.
+func OpenSlice(ctx context.Context, url string, n int64) (io.ReadCloser, error) {
+	url = RewriteURL(url)
+	if objstore.IsObjectURL(url) {
+		info, err := statObject(ctx, url)
+		if err != nil {
+			return nil, err
+		}
+		return readObject(ctx, url, info, 0, min(n, info.Size))
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	AddRequestHeaders(req)
+	req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", n-1))
+	req.Header.Set("Accept-Encoding", "identity")
+
+	resp, err := httpx.Long.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	countTransfer(resp)
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return nil, &StatusError{StatusCode: resp.StatusCode}
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(resp.Body, n), resp.Body}, nil
+}
@@ -0,0 +1,98 @@
+package worker
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// spillSecondPass is set by SetSecondPassSpill.
+var spillSecondPass bool
+
+// SetSecondPassSpill makes streamed downloads keep a copy of their
+// compressed bytes in the temp dir until the file's layout is known. A file
+// whose in_network precedes provider_references needs a second pass, which
+// then reads the copy instead of downloading the file again; for any other
+// file the copy is deleted as soon as provider_references starts. The copy
+// takes up to the file's compressed size in the temp dir; if writing it
+// fails, the second pass downloads the file again as before.
+func SetSecondPassSpill(on bool) {
+	spillSecondPass = on
+}
+
+// downloadSpill is the copy of a download kept for a second pass. It is
+// written from the decompressor's read path, which may run on its own
+// goroutine, and dropped from the parser's, hence the lock. Its methods
+// are safe on a nil spill, which keeps nothing.
+type downloadSpill struct {
+	mu  sync.Mutex
+	f   *os.File
+	w   *bufio.Writer
+	err error // why the copy was dropped early, if writing it failed
+}
+
+func newDownloadSpill(dir string) (*downloadSpill, error) {
+	f, err := os.CreateTemp(dir, "spill-*.mrf")
+	if err != nil {
+		return nil, fmt.Errorf("creating download spill: %w", err)
+	}
+	return &downloadSpill{f: f, w: bufio.NewWriterSize(f, 1<<20)}, nil
+}
+
+// Write copies p to the spill. It never fails: a write error drops the
+// copy, leaving the download itself unaffected.
+func (s *downloadSpill) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f != nil {
+		if _, err := s.w.Write(p); err != nil {
+			s.err = err
+			s.dropLocked()
+		}
+	}
+	return len(p), nil
+}
+
+// drop deletes the copy.
+func (s *downloadSpill) drop() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dropLocked()
+}
+
+func (s *downloadSpill) dropLocked() {
+	if s.f != nil {
+		s.f.Close()
+		os.Remove(s.f.Name())
+		s.f = nil
+	}
+}
+
+// replay returns a reader over the copy and its size, once the download
+// has been read to the end. It fails if there is no copy.
+func (s *downloadSpill) replay() (io.Reader, int64, error) {
+	if s == nil {
+		return nil, 0, fmt.Errorf("no download spill")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		if s.err != nil {
+			return nil, 0, fmt.Errorf("download spill: %w", s.err)
+		}
+		return nil, 0, fmt.Errorf("download spill dropped")
+	}
+	if err := s.w.Flush(); err != nil {
+		return nil, 0, fmt.Errorf("download spill: %w", err)
+	}
+	size, err := s.f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, 0, err
+	}
+	return io.NewSectionReader(s.f, 0, size), size, nil
+}
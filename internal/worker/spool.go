@@ -0,0 +1,96 @@
+package worker
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/gyeh/npi-rates/internal/mrf"
+)
+
+// replayBatchSize is the number of spooled results handed to the consumer
+// per call when a spool is replayed.
+const replayBatchSize = 1000
+
+// resultSpool appends one pipeline attempt's results to a temp NDJSON file
+// instead of holding them in memory. A file with millions of matches would
+// otherwise have to fit in RAM before being written out, and results cannot
+// be forwarded to the output as they arrive because a failed attempt is
+// retried from scratch and would duplicate them. The spool is replayed once
+// the attempt succeeds and discarded if it fails.
+type resultSpool struct {
+	f   *os.File
+	w   *bufio.Writer
+	enc *json.Encoder
+	err error
+}
+
+func newResultSpool(dir string) (*resultSpool, error) {
+	f, err := os.CreateTemp(dir, "results-*.ndjson")
+	if err != nil {
+		return nil, fmt.Errorf("creating result spool: %w", err)
+	}
+	w := bufio.NewWriterSize(f, 1<<20)
+	return &resultSpool{f: f, w: w, enc: json.NewEncoder(w)}, nil
+}
+
+// write appends batch to the spool. The first error sticks and is reported
+// by finish, so emit callbacks need not handle it.
+func (s *resultSpool) write(batch []mrf.RateResult) {
+	for i := range batch {
+		if s.err != nil {
+			return
+		}
+		s.err = s.enc.Encode(&batch[i])
+	}
+}
+
+// finish flushes the spool and reports any write error.
+func (s *resultSpool) finish() error {
+	if s.err == nil {
+		s.err = s.w.Flush()
+	}
+	if s.err != nil {
+		return fmt.Errorf("writing result spool: %w", s.err)
+	}
+	return nil
+}
+
+// replay feeds the spooled results to fn in batches and removes the spool.
+func (s *resultSpool) replay(fn func([]mrf.RateResult) error) error {
+	defer s.discard()
+	if _, err := s.f.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("rewinding result spool: %w", err)
+	}
+	dec := json.NewDecoder(bufio.NewReaderSize(s.f, 1<<20))
+	batch := make([]mrf.RateResult, 0, replayBatchSize)
+	for {
+		var r mrf.RateResult
+		err := dec.Decode(&r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("reading result spool: %w", err)
+		}
+		batch = append(batch, r)
+		if len(batch) == replayBatchSize {
+			if err := fn(batch); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+	if len(batch) > 0 {
+		return fn(batch)
+	}
+	return nil
+}
+
+// discard closes and removes the spool file.
+func (s *resultSpool) discard() {
+	s.f.Close()
+	os.Remove(s.f.Name())
+}
@@ -0,0 +1,114 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+
+	"github.com/gyeh/npi-rates/internal/mrf"
+)
+
+// summaryTop is how many of the slowest files and most frequent error
+// categories a run summary lists.
+const summaryTop = 5
+
+// Summarize breaks a pool's results down by host, with the slowest files
+// and the most frequent kinds of failure.
+func Summarize(results []PipelineResult) *mrf.RunSummary {
+	s := &mrf.RunSummary{}
+	hosts := make(map[string]*mrf.HostSummary)
+	errs := make(map[string]*mrf.ErrorCount)
+	for _, r := range results {
+		host := hostOf(r.URL)
+		if host == "" {
+			host = "local"
+		}
+		h := hosts[host]
+		if h == nil {
+			h = &mrf.HostSummary{Host: host}
+			hosts[host] = h
+		}
+		h.Files++
+		switch {
+		case errors.Is(r.Err, ErrDeadline):
+			h.Skipped++
+			continue
+		case r.Err != nil:
+			h.Failed++
+			cat := errorCategory(r.Err)
+			if e := errs[cat]; e != nil {
+				e.Count++
+			} else {
+				errs[cat] = &mrf.ErrorCount{Category: cat, Count: 1, Example: FileNameFromURL(r.URL) + ": " + r.Err.Error(), Hint: ErrorHint(r.Err)}
+			}
+		default:
+			h.Succeeded++
+		}
+		h.Bytes += r.CompressedBytes
+		h.Rates += int64(r.Count)
+		if r.Duration > 0 {
+			s.Slowest = append(s.Slowest, mrf.FileTiming{URL: r.URL, Seconds: r.Duration.Seconds()})
+		}
+	}
+
+	for _, h := range hosts {
+		s.Hosts = append(s.Hosts, *h)
+	}
+	sort.Slice(s.Hosts, func(i, j int) bool {
+		if s.Hosts[i].Files != s.Hosts[j].Files {
+			return s.Hosts[i].Files > s.Hosts[j].Files
+		}
+		return s.Hosts[i].Host < s.Hosts[j].Host
+	})
+	sort.Slice(s.Slowest, func(i, j int) bool { return s.Slowest[i].Seconds > s.Slowest[j].Seconds })
+	s.Slowest = s.Slowest[:min(len(s.Slowest), summaryTop)]
+	for _, e := range errs {
+		s.Errors = append(s.Errors, *e)
+	}
+	sort.Slice(s.Errors, func(i, j int) bool {
+		if s.Errors[i].Count != s.Errors[j].Count {
+			return s.Errors[i].Count > s.Errors[j].Count
+		}
+		return s.Errors[i].Category < s.Errors[j].Category
+	})
+	s.Errors = s.Errors[:min(len(s.Errors), summaryTop)]
+	return s
+}
+
+// errorCategory names the kind of failure err is, coarsely enough that
+// files failing the same way share a category.
+func errorCategory(err error) string {
+	var netErr net.Error
+	var authErr *AuthError
+	var statusErr *StatusError
+	switch {
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case isPanic(err):
+		return "internal error"
+	case isDiskFullError(err):
+		return "disk full"
+	case errors.Is(err, ErrTooSlow):
+		return "too slow"
+	case errors.As(err, &authErr):
+		if authErr.Expired {
+			return "expired URL"
+		}
+		return fmt.Sprintf("HTTP %d", authErr.StatusCode)
+	case errors.As(err, &statusErr):
+		return fmt.Sprintf("HTTP %d", statusErr.StatusCode)
+	case errors.As(err, &netErr) && netErr.Timeout(), errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, errTruncated):
+		return "truncated download"
+	case errors.Is(err, errDecompress), errors.Is(err, errCorrupt):
+		return "decompression"
+	case errors.Is(err, errParse), errors.Is(err, errSplit):
+		return "parse"
+	case errors.As(err, &netErr):
+		return "network"
+	}
+	return "other"
+}
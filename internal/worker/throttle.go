@@ -0,0 +1,75 @@
+package worker
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"golang.org/x/time/rate"
+)
+
+// bandwidth holds the download rate limits; see SetMaxBandwidth.
+var bandwidth struct {
+	total       *rate.Limiter // shared by all downloads, or nil
+	perDownload float64       // bytes/s, 0: no cap
+}
+
+// SetMaxBandwidth caps download throughput: total across all concurrent
+// downloads, and perWorker for each one (a worker downloads one file at a
+// time), both in bytes per second. Bodies are read through a token bucket,
+// so the server sees ordinary TCP backpressure. Reads from the download
+// cache aren't limited. Zero or less leaves a cap off.
+func SetMaxBandwidth(total, perWorker int64) {
+	bandwidth.total = nil
+	if total > 0 {
+		bandwidth.total = rate.NewLimiter(rate.Limit(total), throttleBurst(total))
+	}
+	bandwidth.perDownload = max(float64(perWorker), 0)
+}
+
+// throttleBurst is the bucket size for a limit: a quarter second of
+// transfer, at least 16 KB so reads aren't split too finely.
+func throttleBurst(bytesPerSec int64) int {
+	return int(max(bytesPerSec/4, 16<<10))
+}
+
+// limitBody throttles resp.Body to the configured caps.
+func limitBody(ctx context.Context, resp *http.Response) {
+	if bandwidth.total == nil && bandwidth.perDownload <= 0 {
+		return
+	}
+	t := &throttledBody{ReadCloser: resp.Body, ctx: ctx, total: bandwidth.total}
+	if bandwidth.perDownload > 0 {
+		t.own = rate.NewLimiter(rate.Limit(bandwidth.perDownload), throttleBurst(int64(bandwidth.perDownload)))
+	}
+	resp.Body = t
+}
+
+// throttledBody waits for tokens for every chunk it reads. Reads are capped
+// at the smallest bucket, since a wait for more than a bucket holds fails.
+type throttledBody struct {
+	io.ReadCloser
+	ctx   context.Context
+	total *rate.Limiter
+	own   *rate.Limiter
+}
+
+func (t *throttledBody) Read(p []byte) (int, error) {
+	for _, l := range []*rate.Limiter{t.total, t.own} {
+		if l != nil && len(p) > l.Burst() {
+			p = p[:l.Burst()]
+		}
+	}
+	n, err := t.ReadCloser.Read(p)
+	if n > 0 {
+		for _, l := range []*rate.Limiter{t.total, t.own} {
+			if l == nil {
+				continue
+			}
+			if werr := l.WaitN(t.ctx, n); werr != nil && err == nil {
+				err = werr
+			}
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,50 @@
+package worker
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+
+	"github.com/gyeh/npi-rates/internal/mrf"
+)
+
+// ResultDiff is how two runs' results for the same file differ, compared as
+// multisets: a result found twice in one run and once in the other counts
+// once as extra.
+type ResultDiff struct {
+	Matched int              `json:"matched"`
+	OnlyA   []mrf.RateResult `json:"only_a,omitempty"`
+	OnlyB   []mrf.RateResult `json:"only_b,omitempty"`
+}
+
+// Same reports whether the two runs found the same results.
+func (d *ResultDiff) Same() bool { return len(d.OnlyA) == 0 && len(d.OnlyB) == 0 }
+
+// DiffResults compares two runs' whole results, ignoring their order.
+func DiffResults(a, b []mrf.RateResult) *ResultDiff {
+	key := func(r mrf.RateResult) [32]byte {
+		data, _ := json.Marshal(r)
+		return sha256.Sum256(data)
+	}
+	counts := make(map[[32]byte]int, len(a))
+	for _, r := range a {
+		counts[key(r)]++
+	}
+	d := &ResultDiff{}
+	for _, r := range b {
+		k := key(r)
+		if counts[k] > 0 {
+			counts[k]--
+			d.Matched++
+		} else {
+			d.OnlyB = append(d.OnlyB, r)
+		}
+	}
+	for _, r := range a {
+		k := key(r)
+		if counts[k] > 0 {
+			counts[k]--
+			d.OnlyA = append(d.OnlyA, r)
+		}
+	}
+	return d
+}
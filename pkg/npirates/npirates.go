@@ -0,0 +1,182 @@
+// Package npirates is the Go API of npi-rates: it searches CMS Transparency
+// in Coverage in-network rate files (MRFs) for the negotiated rates of given
+// providers, and resolves a plan's table-of-contents file to the MRFs to
+// search.
+//
+// A search over a list of MRF URLs:
+//
+//	s := &npirates.Search{NPIs: []int64{1770671182}, Workers: 4}
+//	for _, f := range s.Run(ctx, urls) {
+//		if f.Err != nil {
+//			log.Printf("%s: %v", f.URL, f.Err)
+//			continue
+//		}
+//		for _, r := range f.Results {
+//			fmt.Println(r.BillingCode, r.NegotiatedRate)
+//		}
+//	}
+//
+// The types here are aliases of the ones the npi-rates command uses, so
+// results serialize to the same JSON.
+package npirates
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"github.com/gyeh/npi-rates/internal/mrf"
+	"github.com/gyeh/npi-rates/internal/npi"
+	"github.com/gyeh/npi-rates/internal/progress"
+	"github.com/gyeh/npi-rates/internal/toc"
+	"github.com/gyeh/npi-rates/internal/worker"
+)
+
+type (
+	// RateResult is one negotiated rate for a matched provider.
+	RateResult = mrf.RateResult
+	// TIN is a provider group's tax identifier.
+	TIN = mrf.TIN
+	// SearchParams describes a search in the npi-rates output document.
+	SearchParams = mrf.SearchParams
+	// SearchOutput is the npi-rates output document.
+	SearchOutput = mrf.SearchOutput
+
+	// SearchOptions are what StreamParse searches for: NPIs, TINs,
+	// billing codes and the other filters the command's search flags set.
+	SearchOptions = mrf.SearchOptions
+	// StreamCallbacks reports StreamParse progress.
+	StreamCallbacks = mrf.StreamCallbacks
+	// StreamResult is returned by StreamParse.
+	StreamResult = mrf.StreamResult
+	// MatchedProviders indexes the provider_references entries that
+	// matched a search, for a second StreamParse pass.
+	MatchedProviders = mrf.MatchedProviders
+
+	// TOCResult lists the MRFs a table-of-contents file names for a plan.
+	TOCResult = toc.ResolveResult
+
+	// ProviderInfo is a provider's entry in the NPPES registry.
+	ProviderInfo = npi.ProviderInfo
+)
+
+// StreamParse parses one decompressed MRF from r, calling emit with each
+// batch of results matching opts (emit may be called concurrently).
+// sourceFile is recorded in each result. If the file lists in_network before
+// provider_references, the result has NeedSecondPass set and the file must
+// be parsed again, with the same opts, with prebuilt set to its
+// MatchedProviders; otherwise prebuilt is nil. Parses with different options
+// may run at once.
+func StreamParse(r io.Reader, opts *SearchOptions, sourceFile string, cb StreamCallbacks, emit func([]RateResult), prebuilt *MatchedProviders) (*StreamResult, error) {
+	return mrf.StreamParse(r, opts, sourceFile, cb, emit, prebuilt)
+}
+
+// ResolveTOC fetches the table-of-contents file at tocURL (.json or .json.gz)
+// and returns the in-network MRF URLs listed for planID (a HIOS ID or EIN).
+func ResolveTOC(ctx context.Context, tocURL, planID string) (*TOCResult, error) {
+	return toc.FetchAndResolve(ctx, tocURL, planID, nil)
+}
+
+// ResolveTOCReader is ResolveTOC for an already decompressed TOC document.
+func ResolveTOCReader(r io.Reader, planID string) (*TOCResult, error) {
+	return toc.ResolveTOC(r, planID, nil)
+}
+
+// LookupNPI returns the NPPES registry entry for an NPI, or nil if there is
+// none.
+func LookupNPI(ctx context.Context, number int64) (*ProviderInfo, error) {
+	return npi.Lookup(ctx, number)
+}
+
+// Search is a search of MRFs for the rates of a set of providers, given by
+// NPI, by provider group TIN, or both.
+type Search struct {
+	NPIs []int64
+	TINs []string
+
+	// BillingCodes and BillingCodeTypes, if set, restrict the search to
+	// those codes (e.g. 99213) and code types (e.g. CPT).
+	BillingCodes     []string
+	BillingCodeTypes []string
+
+	// Workers is the number of files processed at once (default 3).
+	Workers int
+
+	// TmpDir holds intermediate files (default: the system temp dir).
+	TmpDir string
+
+	// FileBased downloads each file to TmpDir before parsing it, instead of
+	// parsing while downloading. It needs disk space for the decompressed
+	// file but copes better with files that must be parsed twice.
+	FileBased bool
+
+	// Mirrors lists alternate URLs for a file, keyed by its URL.
+	Mirrors map[string][]string
+
+	// OnResults, if set, receives each file's results as the file
+	// completes, instead of FileResult.Results. Calls are serialized.
+	OnResults func([]RateResult) error
+}
+
+// FileResult is the outcome of searching one file.
+type FileResult struct {
+	URL     string
+	Results []RateResult
+	Count   int // number of results, also when they went to OnResults
+	Err     error
+
+	// SourceURL is the URL the file was read from and its results'
+	// SourceFile: URL, or the mirror searched in its place.
+	SourceURL string
+
+	CompressedBytes   int64
+	DecompressedBytes int64
+}
+
+// Run searches urls and returns one FileResult per URL, in order. Searches
+// may run at once, each with its own filters. Warnings about individual
+// files (retries, skipped elements) are printed to stderr, as by the
+// command.
+func (s *Search) Run(ctx context.Context, urls []string) []FileResult {
+	npis := make(map[int64]struct{}, len(s.NPIs))
+	for _, n := range s.NPIs {
+		npis[n] = struct{}{}
+	}
+	workers := s.Workers
+	if workers <= 0 {
+		workers = 3
+	}
+	tmpDir := s.TmpDir
+	if tmpDir == "" {
+		tmpDir = os.TempDir()
+	}
+	pool := &worker.Pool{
+		Workers: workers,
+		Search: &mrf.SearchOptions{
+			NPIs:             npis,
+			TINs:             s.TINs,
+			BillingCodes:     s.BillingCodes,
+			BillingCodeTypes: s.BillingCodeTypes,
+		},
+		TmpDir:    tmpDir,
+		Progress:  &progress.NoopManager{Quiet: true},
+		Stream:    !s.FileBased,
+		Mirrors:   s.Mirrors,
+		OnResults: s.OnResults,
+	}
+
+	results := pool.Run(ctx, urls)
+	out := make([]FileResult, len(results))
+	for i, r := range results {
+		out[i] = FileResult{
+			URL:               r.URL,
+			SourceURL:         r.SourceURL,
+			Results:           r.Results,
+			Count:             r.Count,
+			Err:               r.Err,
+			CompressedBytes:   r.CompressedBytes,
+			DecompressedBytes: r.DecompressedBytes,
+		}
+	}
+	return out
+}
@@ -0,0 +1,230 @@
+package npirates_test
+
+import (
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gyeh/npi-rates/pkg/npirates"
+)
+
+// testMRF has NPI 1316924913 in two groups, one of them under TIN
+// 55-5555555 with another NPI, and rates for two billing codes.
+const testMRF = `{
+	"reporting_entity_name": "Test Health Plan",
+	"provider_references": [
+		{"provider_group_id": 1, "provider_groups": [{"npi": [1316924913], "tin": {"type": "ein", "value": "16-0960964"}}]},
+		{"provider_group_id": 2, "provider_groups": [{"npi": [5555555555, 1316924913], "tin": {"type": "ein", "value": "55-5555555"}}]}
+	],
+	"in_network": [
+		{
+			"billing_code_type": "CPT", "billing_code": "99213", "negotiation_arrangement": "ffs",
+			"negotiated_rates": [{"provider_references": [1], "negotiated_prices": [
+				{"negotiated_rate": 125.50, "negotiated_type": "negotiated", "billing_class": "professional", "expiration_date": "2026-12-31"}
+			]}]
+		},
+		{
+			"billing_code_type": "CPT", "billing_code": "99214", "negotiation_arrangement": "ffs",
+			"negotiated_rates": [{"provider_references": [2], "negotiated_prices": [
+				{"negotiated_rate": 180.00, "negotiated_type": "negotiated", "billing_class": "professional", "expiration_date": "2026-12-31"}
+			]}]
+		}
+	]
+}`
+
+func serveMRF(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gz := gzip.NewWriter(w)
+		gz.Write([]byte(testMRF))
+		gz.Close()
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// summarize lists a search's results as "npi code" strings, sorted.
+func summarize(t *testing.T, files []npirates.FileResult) []string {
+	t.Helper()
+	var out []string
+	for _, f := range files {
+		if f.Err != nil {
+			t.Fatalf("%s: %v", f.URL, f.Err)
+		}
+		for _, r := range f.Results {
+			out = append(out, strconv.FormatInt(r.NPI, 10)+" "+r.BillingCode)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// TestSearchRun verifies searches by NPI, billing code and TIN, run one
+// after another, each seeing only its own filters.
+func TestSearchRun(t *testing.T) {
+	server := serveMRF(t)
+	urls := []string{server.URL + "/in_network.json.gz"}
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		search npirates.Search
+		want   []string
+	}{
+		{"by NPI", npirates.Search{NPIs: []int64{1316924913}},
+			[]string{"1316924913 99213", "1316924913 99214"}},
+		{"by billing code", npirates.Search{NPIs: []int64{1316924913}, BillingCodes: []string{"99214"}},
+			[]string{"1316924913 99214"}},
+		{"by TIN", npirates.Search{TINs: []string{"555555555"}},
+			[]string{"1316924913 99214", "5555555555 99214"}},
+		// The earlier searches' code and TIN filters must not carry over.
+		{"by NPI again", npirates.Search{NPIs: []int64{1316924913}},
+			[]string{"1316924913 99213", "1316924913 99214"}},
+		{"file-based", npirates.Search{NPIs: []int64{1316924913}, FileBased: true, TmpDir: t.TempDir()},
+			[]string{"1316924913 99213", "1316924913 99214"}},
+	}
+	for _, tt := range tests {
+		got := summarize(t, tt.search.Run(ctx, urls))
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestSearchRun_Concurrent verifies that searches with different filters
+// started at once don't see each other's.
+func TestSearchRun_Concurrent(t *testing.T) {
+	server := serveMRF(t)
+	urls := []string{server.URL + "/in_network.json.gz"}
+
+	var wg sync.WaitGroup
+	got := make([][]string, 2)
+	searches := []npirates.Search{
+		{NPIs: []int64{1316924913}, BillingCodes: []string{"99213"}},
+		{TINs: []string{"55-5555555"}},
+	}
+	for i := range searches {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			got[i] = summarize(t, searches[i].Run(context.Background(), urls))
+		}(i)
+	}
+	wg.Wait()
+	if strings.Join(got[0], ",") != "1316924913 99213" {
+		t.Errorf("code search got %v", got[0])
+	}
+	if strings.Join(got[1], ",") != "1316924913 99214,5555555555 99214" {
+		t.Errorf("TIN search got %v", got[1])
+	}
+}
+
+func TestSearchRun_OnResults(t *testing.T) {
+	server := serveMRF(t)
+	var mu sync.Mutex
+	var received int
+	s := &npirates.Search{
+		NPIs: []int64{1316924913},
+		OnResults: func(rs []npirates.RateResult) error {
+			mu.Lock()
+			received += len(rs)
+			mu.Unlock()
+			return nil
+		},
+	}
+	files := s.Run(context.Background(), []string{server.URL + "/in_network.json.gz"})
+	if len(files) != 1 || files[0].Err != nil {
+		t.Fatalf("unexpected results %+v", files)
+	}
+	if files[0].Count != 2 || received != 2 || len(files[0].Results) != 0 {
+		t.Errorf("expected 2 results delivered to OnResults only, got Count=%d received=%d Results=%d",
+			files[0].Count, received, len(files[0].Results))
+	}
+	if files[0].CompressedBytes == 0 || files[0].DecompressedBytes != int64(len(testMRF)) {
+		t.Errorf("unexpected sizes %d compressed, %d decompressed", files[0].CompressedBytes, files[0].DecompressedBytes)
+	}
+}
+
+func TestResolveTOCReader(t *testing.T) {
+	toc := `{
+		"reporting_entity_name": "Test Health Plan",
+		"reporting_structure": [
+			{"reporting_plans": [{"plan_id": "12345"}], "in_network_files": [{"location": "https://example.com/a.json.gz"}]},
+			{"reporting_plans": [{"plan_id": "67890"}], "in_network_files": [{"location": "https://example.com/b.json.gz"}]}
+		]
+	}`
+	res, err := npirates.ResolveTOCReader(strings.NewReader(toc), "67890")
+	if err != nil {
+		t.Fatalf("ResolveTOCReader: %v", err)
+	}
+	if len(res.URLs) != 1 || res.URLs[0] != "https://example.com/b.json.gz" || res.ReportingEntityName != "Test Health Plan" {
+		t.Errorf("unexpected result %+v", res)
+	}
+}
+
+func TestStreamParse(t *testing.T) {
+	var mu sync.Mutex
+	var results []npirates.RateResult
+	opts := &npirates.SearchOptions{NPIs: map[int64]struct{}{5555555555: {}}}
+	sr, err := npirates.StreamParse(strings.NewReader(testMRF), opts, "test.json",
+		npirates.StreamCallbacks{}, func(rs []npirates.RateResult) {
+			mu.Lock()
+			results = append(results, rs...)
+			mu.Unlock()
+		}, nil)
+	if err != nil {
+		t.Fatalf("StreamParse: %v", err)
+	}
+	if sr.NeedSecondPass {
+		t.Error("provider_references come first; no second pass should be needed")
+	}
+	if len(results) != 1 || results[0].BillingCode != "99214" || results[0].SourceFile != "test.json" {
+		t.Errorf("unexpected results %+v", results)
+	}
+}
+
+// TestStreamParse_Concurrent verifies that parses with different options
+// running at once each apply only their own.
+func TestStreamParse_Concurrent(t *testing.T) {
+	opts := []*npirates.SearchOptions{
+		{NPIs: map[int64]struct{}{1316924913: {}}, BillingCodes: []string{"99213"}},
+		{TINs: []string{"55-5555555"}},
+	}
+	want := []string{"1316924913 99213", "1316924913 99214,5555555555 99214"}
+
+	for round := 0; round < 20; round++ {
+		var wg sync.WaitGroup
+		got := make([][]string, len(opts))
+		for i := range opts {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				var mu sync.Mutex
+				_, err := npirates.StreamParse(strings.NewReader(testMRF), opts[i], "test.json",
+					npirates.StreamCallbacks{}, func(rs []npirates.RateResult) {
+						mu.Lock()
+						defer mu.Unlock()
+						for _, r := range rs {
+							got[i] = append(got[i], strconv.FormatInt(r.NPI, 10)+" "+r.BillingCode)
+						}
+					}, nil)
+				if err != nil {
+					t.Errorf("StreamParse: %v", err)
+				}
+				sort.Strings(got[i])
+			}(i)
+		}
+		wg.Wait()
+		for i := range opts {
+			if strings.Join(got[i], ",") != want[i] {
+				t.Fatalf("round %d: search %d got %v, want %s", round, i, got[i], want[i])
+			}
+		}
+	}
+}
@@ -9,8 +9,11 @@ Usage:
 
 import json
 import os
+import re
+import shlex
 import sys
 import time
+import uuid
 from datetime import datetime
 
 import modal
@@ -23,7 +26,7 @@ def log(msg: str):
         import socket
         task_id = socket.gethostname()
     task_id = task_id[-8:]
-    prefix = f"[ID|{task_id}] " if task_id else ""
+    prefix = f"[{_RUN_ID or 'ID'}|{task_id}] " if task_id else ""
     print(f"{ts} {prefix}{msg}", file=sys.stderr, flush=True)
 
 
@@ -42,6 +45,7 @@ def _cli_arg(name, default, type_fn=str):
     return default
 
 
+# internal/modal/estimate.go prices shards at these defaults; keep in sync.
 _MEMORY = _cli_arg("memory", 4096, int)
 _CPU = _cli_arg("cpu", 2, int)
 _WORKERS = 1
@@ -51,10 +55,62 @@ _TIMEOUT = _cli_arg("timeout", 3600, int)
 _CLOUD = _cli_arg("cloud", "aws")
 _REGION = _cli_arg("region", "us-east-1")
 
+# A failed shard (crash, preempted container, exit code) is re-run this many
+# times, with backoff, before the run fails. Shards only write to their own
+# work dir, so re-running one is safe.
+_TASK_RETRIES = _cli_arg("task-retries", 2, int)
+
+# Shards see the same proxy settings and extra request headers as the local
+# run: those variables are copied from the local environment into an
+# ephemeral secret, alongside any named Modal secrets (--secrets a,b) that
+# carry tokens kept in Modal. Secrets become environment variables in the
+# container, where npi-rates reads them.
+_FORWARD_ENV = ("NPI_RATES_HEADERS", "HTTPS_PROXY", "HTTP_PROXY", "NO_PROXY",
+                "https_proxy", "http_proxy", "no_proxy")
+_SECRET_NAMES = [n for n in _cli_arg("secrets", "").split(",") if n]
+_SECRETS = [modal.Secret.from_name(n) for n in _SECRET_NAMES]
+_forwarded = {k: os.environ[k] for k in _FORWARD_ENV if os.environ.get(k)}
+if _forwarded:
+    _SECRETS.append(modal.Secret.from_dict(_forwarded))
+
+# The run ID tags log lines, names the app (so concurrent runs show up as
+# separate apps in the Modal dashboard) and ends up in the merged output.
+_RUN_ID_RE = re.compile(r"[A-Za-z0-9_][A-Za-z0-9._-]{0,39}")
+
+
+def valid_run_id(run_id: str) -> bool:
+    """Mirrors validRunID in cmd/npi-rates: 1-40 letters, digits, '.', '_'
+    or '-', not starting with '.' or '-'."""
+    return _RUN_ID_RE.fullmatch(run_id) is not None
+
+
+def new_run_id() -> str:
+    return datetime.now().strftime("%Y%m%d-%H%M%S-") + uuid.uuid4().hex[:6]
+
+
+def resolve_run_id() -> str:
+    """Decide the run ID once, before the app is built, so that the app's
+    name and the run's logs and output always agree: --run-id if given (as
+    `npi-rates search --cloud` always does), else a new one for a local
+    `modal run`. A deploy keeps the plain app name, and shard containers,
+    which import this module without the CLI's arguments, receive the run
+    ID as an argument instead."""
+    run_id = _cli_arg("run-id", "")
+    if run_id:
+        if not valid_run_id(run_id):
+            sys.exit(f"--run-id must be 1-40 letters, digits, '.', '_' or '-', got {run_id!r}")
+        return run_id
+    if modal.is_local() and sys.argv[1:2] == ["run"]:
+        return new_run_id()
+    return ""
+
+
+_RUN_ID = resolve_run_id()
+
 # ---------------------------------------------------------------------------
 # Modal app setup
 # ---------------------------------------------------------------------------
-app = modal.App("npi-rates")
+app = modal.App(f"npi-rates-{_RUN_ID}" if _RUN_ID else "npi-rates")
 
 image = (
     modal.Image.from_dockerfile("Dockerfile")
@@ -70,12 +126,17 @@ image = (
     timeout=_TIMEOUT,
     cloud=_CLOUD,
     region=_REGION,
+    retries=modal.Retries(max_retries=_TASK_RETRIES, initial_delay=10.0, backoff_coefficient=2.0),
+    secrets=_SECRETS,
 )
-def run_search(shard_index: int, urls: list[str], npi: str, workers: int):
+def run_search(run_id: str, shard_index: int, urls: list[str], npi: str, workers: int, extra_args: list[str]):
     import os
     import subprocess as sp
 
-    work_dir = f"/tmp/shard-{shard_index}"
+    global _RUN_ID
+    _RUN_ID = run_id
+
+    work_dir = f"/tmp/{run_id}/shard-{shard_index}"
     tmp_dir = os.path.join(work_dir, "tmp")
     os.makedirs(tmp_dir, exist_ok=True)
 
@@ -85,18 +146,37 @@ def run_search(shard_index: int, urls: list[str], npi: str, workers: int):
 
     output_path = os.path.join(work_dir, "results.json")
 
-    proc = sp.run(
+    proc = sp.Popen(
         [
             "/npi-rates", "search",
+            "--run-id", run_id,
             "--npi", npi,
             "--urls-file", urls_path,
             "--workers", str(workers),
-            "--log-progress",
+            # Progress comes as NDJSON events on stderr, among the ordinary
+            # log lines; see shard_event_line.
+            "--progress-json",
+            # NPPES and IP geolocation lookups are the orchestrator's, made
+            # once rather than from every shard's IP.
+            "--worker-mode",
             "--stream",
             "--tmp-dir", tmp_dir,
             "-o", output_path,
+            *extra_args,
         ],
+        stderr=sp.PIPE,
+        text=True,
     )
+    for line in proc.stderr:
+        if line.startswith("{"):
+            try:
+                line = shard_event_line(shard_index, json.loads(line))
+            except ValueError:
+                pass
+            if not line:
+                continue
+        print(line.rstrip("\n"), file=sys.stderr, flush=True)
+    proc.wait()
 
     if proc.returncode != 0:
         raise RuntimeError(f"Shard {shard_index} failed with exit code {proc.returncode}")
@@ -105,6 +185,30 @@ def run_search(shard_index: int, urls: list[str], npi: str, workers: int):
         return f.read()
 
 
+def shard_event_line(shard_index: int, e: dict) -> str:
+    """Return the log line for one --progress-json event from a shard, or ""
+    for the frequent kinds (stage, progress, counter) that would flood the
+    logs."""
+    kind = e.get("event")
+    where = f"shard {shard_index}"
+    if e.get("file"):
+        where += f" [{e.get('index')}/{e.get('total')}] {e['file']}"
+    if kind == "file_done":
+        return f"{where}: done in {e.get('seconds', 0):.1f}s ({e.get('files_complete', 0)} files complete)"
+    if kind == "warning":
+        return f"{where}: WARNING: {e.get('message', '')}"
+    if kind == "phase_done":
+        status = f"failed: {e['error']}" if e.get("error") else e.get("message", "") or "done"
+        return f"{where}: {e.get('phase', '')} {status}"
+    if kind == "complete":
+        line = (f"{where}: search {e.get('status', '')}: {e.get('files_matched', 0)} of "
+                f"{e.get('files_searched', 0)} files matched, {e.get('rates', 0)} rates in {e.get('seconds', 0):.1f}s")
+        if e.get("error"):
+            line += f": {e['error']}"
+        return line
+    return ""
+
+
 def read_urls(path: str) -> list[str]:
     """Read URLs from a file, skipping blank lines and comments."""
     urls = []
@@ -124,13 +228,62 @@ def shard_urls(urls: list[str], n: int) -> list[list[str]]:
     return [s for s in shards if s]
 
 
-def merge_results(shard_outputs: list[bytes]) -> dict:
-    """Merge shard results into a single SearchOutput."""
+def dedup_results(results: list[dict], key: str = "") -> tuple[list[dict], int]:
+    """Drop results identical to an earlier one, comparing only the fields
+    named in key (comma-separated) if given. Returns the kept results and
+    the number dropped; mirrors output.DedupSink."""
+    fields = [f.strip() for f in key.split(",") if f.strip()]
+    seen = set()
+    kept = []
+    for r in results:
+        if fields:
+            ident = json.dumps([r.get(f) for f in fields], sort_keys=True)
+        else:
+            ident = json.dumps(r, sort_keys=True)
+        if ident in seen:
+            continue
+        seen.add(ident)
+        kept.append(r)
+    return kept, len(results) - len(kept)
+
+
+def shard_failure(out) -> str:
+    """Return why a shard's output (bytes, or the exception it raised)
+    can't be merged, or "" if it can."""
+    if isinstance(out, BaseException):
+        return f"{type(out).__name__}: {out}"
+    try:
+        doc = json.loads(out)
+    except ValueError as e:
+        return f"unreadable output: {e}"
+    if not isinstance(doc, dict) or "search_params" not in doc or not isinstance(doc.get("results"), list):
+        return "output is not a search result document"
+    return ""
+
+
+def run_shards(run_id: str, url_shards: list[list[str]], indexes: list[int], npi: str, workers: int,
+               search_args: list[str]) -> dict:
+    """Run the given shards, returning each one's output or, if it failed
+    after its retries, its exception."""
+    outputs = run_search.starmap(
+        [(run_id, i, url_shards[i], npi, workers, search_args) for i in indexes],
+        return_exceptions=True,
+    )
+    return dict(zip(indexes, outputs))
+
+
+def merge_results(run_id: str, shard_outputs: list[bytes], dedup: bool = True, dedup_key: str = "") -> dict:
+    """Merge shard results into a single SearchOutput, dropping results
+    duplicated across shards unless dedup is off."""
     all_results = []
+    duplicates = 0
+    truncated = False
+    skipped = 0
     total_searched = 0
     total_matched = 0
     total_duration = 0.0
     npis = []
+    tins = []
 
     for data in shard_outputs:
         output = json.loads(data)
@@ -140,14 +293,29 @@ def merge_results(shard_outputs: list[bytes]) -> dict:
         total_duration = max(total_duration, params.get("duration_seconds", 0))
         if not npis:
             npis = params.get("npis", [])
+        if not tins:
+            tins = params.get("tins", [])
         all_results.extend(output.get("results", []))
+        duplicates += params.get("duplicate_rates", 0)
+        if params.get("status") == "truncated":
+            truncated = True
+            skipped += params.get("skipped_files", 0)
+
+    if dedup:
+        all_results, dropped = dedup_results(all_results, dedup_key)
+        duplicates += dropped
 
     return {
         "search_params": {
+            "run_id": run_id,
             "npis": npis,
+            **({"tins": tins} if tins else {}),
             "searched_files": total_searched,
             "matched_files": total_matched,
             "duration_seconds": total_duration,
+            "status": "truncated" if truncated else "complete",
+            **({"skipped_files": skipped} if skipped else {}),
+            **({"duplicate_rates": duplicates} if duplicates else {}),
         },
         "results": all_results,
     }
@@ -155,37 +323,85 @@ def merge_results(shard_outputs: list[bytes]) -> dict:
 
 @app.local_entrypoint()
 def main(
-    npi: str,
+    npi: str = "",
     urls_file: str = None,
     shards: int = _SHARDS,
     workers: int = _WORKERS,
     output: str = "",
+    extra_args: str = "",
+    run_id: str = "",  # read at import by resolve_run_id
+    task_retries: int = _TASK_RETRIES,  # read at import by _cli_arg
+    secrets: str = "",  # likewise
+    no_dedup: bool = False,
+    dedup_key: str = "",
+    shards_file: str = "",
+    allow_partial: bool = False,
+    rerun_missing: int = 0,
 ):
+    run_id = _RUN_ID
+
     if workers == 0:
         workers = _CPU
 
+    # Extra search flags (e.g. billing code filters) forwarded to every shard.
+    search_args = shlex.split(extra_args)
+
     urls = read_urls(urls_file)
-    url_shards = shard_urls(urls, shards)
+    if shards_file:
+        # Planned by the CLI to balance compressed bytes across shards.
+        with open(shards_file) as f:
+            url_shards = [s for s in json.load(f) if s]
+    else:
+        url_shards = shard_urls(urls, shards)
 
+    log(f"Run ID: {run_id}")
     log(f"NPI: {npi}")
     log(f"Files: {len(urls)} URLs across {len(url_shards)} shards")
     log(f"Infra: {_CPU} CPU, {_MEMORY} MB memory, {_CLOUD}/{_REGION}")
-    log(f"Workers per shard: {workers}")
+    log(f"Workers per shard: {workers}, retries per shard: {task_retries}")
+    if _SECRETS:
+        names = _SECRET_NAMES + (["forwarded: " + ", ".join(sorted(_forwarded))] if _forwarded else [])
+        log(f"Secrets: {'; '.join(names)}")
 
     start = time.time()
 
+    # A shard that still fails after its retries, or returns output that
+    # can't be read, is missing. Missing shards can be given further rounds
+    # once the others are done (--rerun-missing), e.g. to outlast a burst of
+    # preemptions; any still missing then fail the search, or with
+    # --allow-partial leave the output marked incomplete.
     try:
-        shard_outputs = list(run_search.starmap(
-            [(i, shard, npi, workers) for i, shard in enumerate(url_shards)]
-        ))
+        outputs = run_shards(run_id, url_shards, list(range(len(url_shards))), npi, workers, search_args)
+        for round_ in range(1, rerun_missing + 1):
+            missing = [i for i, out in outputs.items() if shard_failure(out)]
+            if not missing:
+                break
+            log(f"Re-running {len(missing)} missing shard(s), round {round_}/{rerun_missing}")
+            outputs.update(run_shards(run_id, url_shards, missing, npi, workers, search_args))
     except Exception as e:
         log(f"Search failed: {e}")
         sys.exit(1)
 
     wall_time = time.time() - start
 
-    merged = merge_results(shard_outputs)
-    merged["search_params"]["duration_seconds"] = wall_time
+    missing = {i: why for i, out in sorted(outputs.items()) if (why := shard_failure(out))}
+    for i, why in missing.items():
+        log(f"Shard {i} ({len(url_shards[i])} files) missing: {why}")
+    if missing and not allow_partial:
+        log(f"Search failed: {len(missing)} of {len(url_shards)} shards missing "
+            "(--allow-partial writes the other shards' results, marked incomplete)")
+        sys.exit(1)
+
+    merged = merge_results(run_id, [out for i, out in sorted(outputs.items()) if i not in missing],
+                           dedup=not no_dedup, dedup_key=dedup_key)
+    params = merged["search_params"]
+    params["duration_seconds"] = wall_time
+    if missing:
+        # The first URL of each line; the rest are its mirrors.
+        unsearched = [line.split()[0] for i in missing for line in url_shards[i]]
+        params["status"] = "incomplete"
+        params["missing_shards"] = len(missing)
+        params["unsearched_urls"] = unsearched
 
     if output:
         output_path = output
@@ -199,5 +415,8 @@ def main(
     searched = merged["search_params"]["searched_files"]
     matched = merged["search_params"]["matched_files"]
     log(f"Search complete: {searched} files searched, {matched} matched, {count} rates found in {wall_time:.1f}s")
+    if missing:
+        log(f"INCOMPLETE: {len(missing)} shard(s) missing, {len(params['unsearched_urls'])} files not searched "
+            "(listed in search_params.unsearched_urls)")
     log(f"Results saved to {output_path}")
     log("Function run completed")
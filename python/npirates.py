@@ -0,0 +1,58 @@
+"""
+ctypes binding for libnpirates, the npi-rates parser built as a C shared library.
+
+Build the library first:
+    go build -buildmode=c-shared -o libnpirates.so ./cmd/libnpirates
+
+Usage:
+    import npirates
+    with open("in-network.json.gz", "rb") as f:
+        for rate in npirates.parse(f.read(), [1770671182], source="in-network.json.gz"):
+            print(rate["billing_code"], rate["negotiated_rate"])
+"""
+
+import ctypes
+import json
+import os
+
+_LIB_PATH = os.environ.get("NPIRATES_LIB", os.path.join(os.path.dirname(__file__), "..", "libnpirates.so"))
+
+_lib = None
+
+
+def _load():
+    global _lib
+    if _lib is None:
+        lib = ctypes.CDLL(_LIB_PATH)
+        lib.npirates_parse.argtypes = [
+            ctypes.c_char_p, ctypes.c_longlong, ctypes.c_char_p, ctypes.c_char_p,
+            ctypes.POINTER(ctypes.c_longlong), ctypes.POINTER(ctypes.c_void_p),
+        ]
+        lib.npirates_parse.restype = ctypes.c_void_p
+        lib.npirates_free.argtypes = [ctypes.c_void_p]
+        lib.npirates_free.restype = None
+        _lib = lib
+    return _lib
+
+
+def parse_ndjson(data: bytes, npis, source: str = "") -> bytes:
+    """Search one MRF (uncompressed, gzip, zstd or bzip2) for the given NPIs; return NDJSON rates."""
+    lib = _load()
+    npi_list = ",".join(str(n) for n in npis).encode()
+    out_len = ctypes.c_longlong()
+    err = ctypes.c_void_p()
+    out = lib.npirates_parse(data, len(data), npi_list, source.encode(), ctypes.byref(out_len), ctypes.byref(err))
+    if not out:
+        msg = ctypes.string_at(err.value).decode() if err.value else "unknown error"
+        if err.value:
+            lib.npirates_free(err.value)
+        raise RuntimeError(f"npirates: {msg}")
+    try:
+        return ctypes.string_at(out, out_len.value)
+    finally:
+        lib.npirates_free(out)
+
+
+def parse(data: bytes, npis, source: str = "") -> list[dict]:
+    """Like parse_ndjson, but return the rates as dicts."""
+    return [json.loads(line) for line in parse_ndjson(data, npis, source).splitlines() if line]